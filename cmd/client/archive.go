@@ -0,0 +1,235 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// transferArchive transfers a directory as a single tar archive stream (-tar) and returns a summary of the transfer.
+// The files and directories (including empty ones) are written to the archive with their mode and modification time,
+// and the server extracts the archive only once the checksum trailing the stream has been verified,
+// so the directory is either transferred as a whole or not at all.
+func transferArchive(ctx context.Context, dirPath string, filter *protocol.PathFilter) (*transferSummary, error) {
+	summary := &transferSummary{}
+	startTime := time.Now()
+	defer func() {
+		summary.duration = time.Since(startTime)
+	}()
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	if err := requireDirectory(dirPath); err != nil {
+		return summary, err
+	}
+
+	listing, err := listDirectoryFiles(dirPath, filter)
+	if err != nil {
+		return summary, fmt.Errorf("failed to walk the directory %s: %v", dirPath, err)
+	}
+	summary.tooLarge = listing.tooLarge
+	for _, path := range listing.tooLarge {
+		report := fileReport{Name: path, Status: FileStatusSkipped}
+		if relPath, err := filepath.Rel(dirPath, path); err == nil {
+			report.Name = filepath.ToSlash(relPath)
+		}
+		if fileInfo, err := os.Stat(path); err == nil {
+			report.Size = fileInfo.Size()
+		}
+		summary.files = append(summary.files, report)
+	}
+	summary.filteredFiles = listing.filteredFiles
+	summary.filteredDirs = listing.filteredDirs
+
+	logger.Info("Found the files and directories to archive in the directory", "dir", dirPath,
+		"files", len(listing.files), "dirs", len(listing.dirs), "bytes", listing.totalSize)
+
+	if err := validateDirectorySize(listing.totalSize); err != nil {
+		return summary, fmt.Errorf("archive transfer rejected: %v", err)
+	}
+
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return summary, fmt.Errorf("failed to resolve the directory %s: %v", dirPath, err)
+	}
+	name := filepath.Base(absPath)
+
+	logger = logger.With("file_name", name)
+	logger.Info("Connecting to the server...", "server", *serverAddr)
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return summary, fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+	}
+	stopTimeout := closeOnTimeout(ctx, conn)
+	defer func() {
+		stopTimeout()
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warn("Error closing the connection", "error", err)
+		}
+		logger.Info("Connection closed")
+	}()
+
+	header := &protocol.Header{
+		MessageType:   protocol.MessageTypeTransfer,        // Message type for file transfer.
+		FileSize:      0,                                   // Unknown until the end of the stream.
+		FileName:      name,                                // Name of the archived directory (for logging).
+		Checksum:      make([]byte, protocol.ChecksumSize), // Sent at the end of the stream instead.
+		TransferType:  protocol.TransferTypeTarArchive,     // Transfer type.
+		DirectoryPath: *remoteDir,                          // Destination subdirectory on the server (-remote-dir).
+	}
+
+	ctxWriter := &contextWriter{
+		ctx:  ctx,
+		conn: conn,
+	}
+	if err := protocol.WriteHeader(ctxWriter, header); err != nil {
+		return summary, fmt.Errorf("failed to send the archive header: %v", err)
+	}
+
+	fmt.Fprintf(statusOutput, "Sending the directory %s as a tar archive...\n", dirPath)
+
+	aggregate := protocol.NewAggregateProgress(uint64(listing.totalSize), len(listing.files), "Archive", os.Stderr, progressMode())
+	defer aggregate.Complete()
+
+	// Buffer the chunk frames, so that each chunk is not split into separate writes for its length and data.
+	bufferedWriter := bufio.NewWriterSize(ctxWriter, *bufferSize)
+	streamWriter := protocol.NewStreamWriter(bufferedWriter)
+	reports, err := writeArchive(streamWriter, dirPath, listing, aggregate)
+	if err == nil {
+		err = streamWriter.Close()
+	}
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to send the archive: %v", err)
+		summary.recordArchiveFailure(reports, err)
+		return summary, err
+	}
+
+	response, err := readTransferResponse(conn, header)
+	if err == nil {
+		err = checkResponseChecksum(response, streamWriter.Checksum())
+	}
+	if err != nil {
+		err = fmt.Errorf("archive transfer failed: %v", err)
+		for i := range reports {
+			reports[i].recordResponse(response)
+		}
+		summary.recordArchiveFailure(reports, err)
+		return summary, err
+	}
+
+	logger.Info("Archive sent successfully!", "files", len(reports), "bytes", streamWriter.Written(),
+		"duration_ms", time.Since(startTime).Milliseconds(), "checksum", hex.EncodeToString(streamWriter.Checksum()), "response", response.message)
+
+	for _, report := range reports {
+		report.Status = FileStatusSent
+		report.recordResponse(response)
+		report.finish(startTime)
+		summary.files = append(summary.files, report)
+		summary.totalBytes += report.Size
+		summary.successful++
+	}
+
+	logger.Info("Transfer summary", "successful", summary.successful, "too_large", len(summary.tooLarge),
+		"bytes", summary.totalBytes, "filtered_files", summary.filteredFiles, "filtered_dirs", summary.filteredDirs)
+	for _, path := range summary.tooLarge {
+		logger.Warn("Skipped (too large)", "file_name", path)
+	}
+
+	return summary, nil
+}
+
+// writeArchive writes the directories and files of the listing to a tar archive,
+// under their slash-separated paths relative to the directory, and returns the reports of the files.
+func writeArchive(w io.Writer, dirPath string, listing *directoryListing, aggregate *protocol.AggregateProgress) ([]fileReport, error) {
+	reports := make([]fileReport, len(listing.files))
+	for i, path := range listing.files {
+		reports[i] = fileReport{Name: path, Status: FileStatusFailed}
+		if relPath, err := filepath.Rel(dirPath, path); err == nil {
+			reports[i].Name = filepath.ToSlash(relPath)
+		}
+		if fileInfo, err := os.Stat(path); err == nil {
+			reports[i].Size = fileInfo.Size()
+		}
+	}
+
+	tarWriter := tar.NewWriter(w)
+	for _, path := range listing.dirs {
+		if err := writeArchiveEntry(tarWriter, dirPath, path, aggregate); err != nil {
+			return reports, err
+		}
+	}
+	for _, path := range listing.files {
+		if err := writeArchiveEntry(tarWriter, dirPath, path, aggregate); err != nil {
+			return reports, err
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		return reports, fmt.Errorf("failed to end the archive: %v", err)
+	}
+	return reports, nil
+}
+
+// writeArchiveEntry writes a directory or a file to the tar archive.
+func writeArchiveEntry(tarWriter *tar.Writer, dirPath, path string, aggregate *protocol.AggregateProgress) error {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to get the information of %s: %v", path, err)
+	}
+	relPath, err := filepath.Rel(dirPath, path)
+	if err != nil {
+		return fmt.Errorf("failed to calculate the relative path for %s: %v", path, err)
+	}
+	entryHeader, err := tar.FileInfoHeader(fileInfo, "")
+	if err != nil {
+		return fmt.Errorf("failed to create the archive entry for %s: %v", path, err)
+	}
+	entryHeader.Name = filepath.ToSlash(relPath)
+	if fileInfo.IsDir() {
+		entryHeader.Name += "/"
+	}
+	// Leave out the owner, which means nothing on the server.
+	entryHeader.Uid, entryHeader.Gid, entryHeader.Uname, entryHeader.Gname = 0, 0, "", ""
+
+	if err := tarWriter.WriteHeader(entryHeader); err != nil {
+		return fmt.Errorf("failed to write the archive entry for %s: %v", path, err)
+	}
+	if fileInfo.IsDir() {
+		return nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			slog.Warn("Error closing the file", "path", path, "error", err)
+		}
+	}()
+	// Copy exactly the size in the entry header, which fails if the file shrank in the meantime.
+	if _, err := io.CopyN(tarWriter, aggregate.Reader(file), entryHeader.Size); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", path, err)
+	}
+	aggregate.FileDone(uint64(entryHeader.Size))
+	return nil
+}
+
+// recordArchiveFailure records every file of a failed archive transfer as failed,
+// since the server extracts nothing from an archive it did not receive in full.
+func (s *transferSummary) recordArchiveFailure(reports []fileReport, err error) {
+	for _, report := range reports {
+		s.recordFailure(report, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTransferArchive tests `transferArchive` to ensure that
+// the files and directories of a tree, including an empty one, are sent as a single tar archive.
+func TestTransferArchive(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{"a.txt": "a", "sub/b.txt": "bb", "sub/deeper/c.txt": "ccc"}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tmpDir, "empty"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	ms := startMockServer(t)
+
+	summary, err := transferArchive(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	received := ms.receivedFiles()
+	for name, content := range files {
+		if string(received[name]) != content {
+			t.Fatalf("expected %s with %q in the archive, got %q", name, content, received[name])
+		}
+	}
+	for _, dir := range []string{"empty/", "sub/", "sub/deeper/"} {
+		if _, ok := received[dir]; !ok {
+			t.Fatalf("expected the directory %s in the archive, got %v", dir, received)
+		}
+	}
+	if summary.successful != len(files) || summary.totalBytes != 6 || len(summary.files) != len(files) {
+		t.Fatalf("expected %d successful files and 6 bytes, got %+v", len(files), *summary)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+)
+
+// Constants for the throughput self-test (`-benchmark`).
+const (
+	BenchmarkSize     = 256 * 1024 * 1024        // Default size of the generated content (256MB).
+	BenchmarkFileName = "filexfer-benchmark.bin" // Name of the content on the server, which stores it unless it runs with -discard.
+)
+
+// runBenchmark streams `size` bytes of generated content to the server as an ordinary stream transfer, so that the
+// throughput of the link and of the protocol (checksums, TLS) is measured without reading a file.
+// The content is pseudo-random, so that a compressing link (e.g. a VPN) cannot shrink it.
+func runBenchmark(ctx context.Context, size int64) (*transferSummary, error) {
+	content := io.LimitReader(rand.NewChaCha8([32]byte{}), size)
+	return transferStream(ctx, content, "generated content", BenchmarkFileName, uint64(size))
+}
+
+// benchmarkRate returns the throughput of a benchmark in MB per second, from the dial of the connection
+// to the response of the server.
+func benchmarkRate(summary *transferSummary) float64 {
+	if summary.duration <= 0 {
+		return 0
+	}
+	return toMB(uint64(summary.totalBytes)) / summary.duration.Seconds()
+}
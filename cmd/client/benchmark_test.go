@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"filexfer/protocol"
+	"filexfer/server"
+	"io"
+	"os"
+	"testing"
+)
+
+// TestRunBenchmark tests `runBenchmark` to ensure that
+// the generated content is verified by a server in "-discard" mode without being stored, and reported with a positive rate.
+func TestRunBenchmark(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	for name, value := range map[string]string{"progress": protocol.ProgressModeNone, "discard": "true"} {
+		if err := server.Flags.Set(name, value); err != nil {
+			t.Fatalf("failed to set the server flag: %v", err)
+		}
+	}
+	defer func() { _ = server.Flags.Set("discard", "false") }()
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone})
+
+	const size = 4 * 1024 * 1024
+	summary, err := runBenchmark(context.Background(), size)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.totalBytes != size || summary.successful != 1 {
+		t.Fatalf("expected %d bytes sent in one transfer, got %d in %d", size, summary.totalBytes, summary.successful)
+	}
+	if rate := benchmarkRate(summary); rate <= 0 {
+		t.Fatalf("expected a positive rate, got %f", rate)
+	}
+	entries, err := os.ReadDir(ts.Dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("failed to read the destination directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing stored by the discarding server, got %v", entries)
+	}
+}
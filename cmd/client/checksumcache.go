@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A checksumCacheEntry is the checksum of a file as of its size and modification time.
+type checksumCacheEntry struct {
+	Size     int64  `json:"size"`     // Size of the file in bytes.
+	ModTime  int64  `json:"mtime_ns"` // Modification time of the file in nanoseconds since the Unix epoch.
+	Checksum string `json:"checksum"` // Hex-encoded SHA-256 checksum of the file.
+}
+
+// A checksumCache remembers the checksums of files by their absolute path (the "-checksum-cache" file),
+// so that a file whose size and modification time are unchanged is not hashed again on the next run.
+// It is safe for concurrent use.
+type checksumCache struct {
+	mutex      sync.Mutex
+	path       string                        // Path of the cache file.
+	entries    map[string]checksumCacheEntry // Entries by the absolute path of their file.
+	dirty      bool                          // Whether the entries changed since the file was read.
+	verifyRate float64                       // Fraction of the hits re-hashed as a spot check ("-checksum-cache-verify").
+	random     func() float64                // Source of the spot checks, in [0, 1).
+}
+
+// checksums is the cache of "-checksum-cache", or nil if files are always hashed.
+var checksums *checksumCache
+
+// loadChecksumCache reads the cache file at `path`, re-hashing `verifyPercent` percent of the hits.
+// A missing file starts an empty cache. So does an unreadable one, with a warning,
+// since the cache only saves time and is rewritten on `save`.
+func loadChecksumCache(path string, verifyPercent float64) (*checksumCache, error) {
+	cache := &checksumCache{
+		path:       path,
+		entries:    make(map[string]checksumCacheEntry),
+		verifyRate: verifyPercent / 100,
+		random:     rand.Float64,
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the checksum cache: %v", err)
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		slog.Warn("Ignoring the invalid checksum cache", "path", path, "error", err)
+		cache.entries = make(map[string]checksumCacheEntry)
+	}
+	return cache, nil
+}
+
+// lookup returns the cached checksum of the file at the absolute path if its size and modification time are unchanged.
+// A hit picked for the spot check is returned with `verify` set, so that the file is hashed and compared anyway.
+func (cc *checksumCache) lookup(path string, info fs.FileInfo) (checksum []byte, verify bool) {
+	cc.mutex.Lock()
+	entry, ok := cc.entries[path]
+	spotCheck := cc.verifyRate > 0 && cc.random() < cc.verifyRate
+	cc.mutex.Unlock()
+
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return nil, false
+	}
+	checksum, err := hex.DecodeString(entry.Checksum)
+	if err != nil || len(checksum) != protocol.ChecksumSize {
+		return nil, false
+	}
+	return checksum, spotCheck
+}
+
+// store remembers the checksum of the file at the absolute path.
+func (cc *checksumCache) store(path string, info fs.FileInfo, checksum []byte) {
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	cc.entries[path] = checksumCacheEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		Checksum: hex.EncodeToString(checksum),
+	}
+	cc.dirty = true
+}
+
+// save writes the cache file if its entries changed, through a temporary file renamed over it,
+// so that an interrupted write never leaves a truncated cache. It does nothing on a nil cache.
+func (cc *checksumCache) save() error {
+	if cc == nil {
+		return nil
+	}
+	cc.mutex.Lock()
+	defer cc.mutex.Unlock()
+	if !cc.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(cc.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cc.path), 0700); err != nil {
+		return fmt.Errorf("failed to create the checksum cache directory: %v", err)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(cc.path), ".checksum-cache-*")
+	if err != nil {
+		return fmt.Errorf("failed to create the checksum cache: %v", err)
+	}
+	if _, err := temp.Write(data); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to write the checksum cache: %v", err)
+	}
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to write the checksum cache: %v", err)
+	}
+	if err := os.Rename(temp.Name(), cc.path); err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to replace the checksum cache: %v", err)
+	}
+	cc.dirty = false
+	return nil
+}
+
+// saveChecksumCache saves the "-checksum-cache" file, logging a failure, which only costs the next run its hits.
+func saveChecksumCache() {
+	if err := checksums.save(); err != nil {
+		slog.Warn("Failed to save the checksum cache", "path", checksums.path, "error", err)
+	}
+}
+
+// fileChecksum returns the SHA-256 checksum of the file at `path`, read from `r`, whose information is `info`.
+// With "-checksum-cache", the checksum of an unchanged file is taken from the cache instead of hashing it,
+// and a computed checksum is remembered. A spot-checked entry that no longer matches the content is logged and replaced.
+func fileChecksum(ctx context.Context, path string, info fs.FileInfo, r io.Reader) ([]byte, error) {
+	if checksums == nil {
+		return protocol.CalculateFileChecksumContext(ctx, r)
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return protocol.CalculateFileChecksumContext(ctx, r)
+	}
+	cached, verify := checksums.lookup(absPath, info)
+	if cached != nil && !verify {
+		return cached, nil
+	}
+
+	checksum, err := protocol.CalculateFileChecksumContext(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	if verify && bytes.Equal(cached, checksum) {
+		return checksum, nil
+	}
+	if verify {
+		slog.Warn("The cached checksum of an unchanged file does not match its content", "file_name", path,
+			"cached", hex.EncodeToString(cached), "checksum", hex.EncodeToString(checksum))
+	}
+	checksums.store(absPath, info, checksum)
+	return checksum, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withChecksumCache enables a checksum cache stored in a temporary directory for the duration of the test.
+func withChecksumCache(t *testing.T, verifyPercent float64) *checksumCache {
+	t.Helper()
+
+	cache, err := loadChecksumCache(filepath.Join(t.TempDir(), "cache", "checksums.json"), verifyPercent)
+	if err != nil {
+		t.Fatalf("failed to load the checksum cache: %v", err)
+	}
+	original := checksums
+	checksums = cache
+	t.Cleanup(func() { checksums = original })
+	return cache
+}
+
+// cachedFileChecksum opens the file and returns its checksum with `fileChecksum`.
+func cachedFileChecksum(t *testing.T, path string) []byte {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open the file: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatalf("failed to stat the file: %v", err)
+	}
+	checksum, err := fileChecksum(context.Background(), path, info, file)
+	if err != nil {
+		t.Fatalf("failed to calculate the checksum: %v", err)
+	}
+	return checksum
+}
+
+// TestFileChecksumCache tests `fileChecksum` to ensure that
+// the checksum of a file is taken from the cache while its size and modification time are unchanged,
+// and calculated again once either changes.
+func TestFileChecksumCache(t *testing.T) {
+	cache := withChecksumCache(t, 0)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("original"), 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+
+	if got := cachedFileChecksum(t, path); !bytes.Equal(got, protocol.CalculateDataChecksum([]byte("original"))) {
+		t.Fatalf("expected the checksum of the content, got %x", got)
+	}
+
+	// Replace the content without changing the size or the modification time, which the cache cannot notice.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat the file: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("replaced"), 0644); err != nil {
+		t.Fatalf("failed to rewrite the file: %v", err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("failed to restore the modification time: %v", err)
+	}
+	if got := cachedFileChecksum(t, path); !bytes.Equal(got, protocol.CalculateDataChecksum([]byte("original"))) {
+		t.Fatalf("expected the cached checksum of the unchanged metadata, got %x", got)
+	}
+
+	modTime := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to change the modification time: %v", err)
+	}
+	if got := cachedFileChecksum(t, path); !bytes.Equal(got, protocol.CalculateDataChecksum([]byte("replaced"))) {
+		t.Fatalf("expected the checksum to be calculated again, got %x", got)
+	}
+
+	// The cache survives a save and a reload.
+	if err := cache.save(); err != nil {
+		t.Fatalf("failed to save the checksum cache: %v", err)
+	}
+	reloaded, err := loadChecksumCache(cache.path, 0)
+	if err != nil {
+		t.Fatalf("failed to reload the checksum cache: %v", err)
+	}
+	absPath, _ := filepath.Abs(path)
+	info, _ = os.Stat(path)
+	if got, _ := reloaded.lookup(absPath, info); !bytes.Equal(got, protocol.CalculateDataChecksum([]byte("replaced"))) {
+		t.Fatalf("expected the saved checksum after a reload, got %x", got)
+	}
+}
+
+// TestFileChecksumCacheVerify tests `fileChecksum` with "-checksum-cache-verify" to ensure that
+// a spot-checked entry that does not match the content of the file is replaced with its actual checksum.
+func TestFileChecksumCacheVerify(t *testing.T) {
+	cache := withChecksumCache(t, 100)
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat the file: %v", err)
+	}
+	absPath, _ := filepath.Abs(path)
+	cache.store(absPath, info, protocol.CalculateDataChecksum([]byte("stale")))
+
+	expected := protocol.CalculateDataChecksum([]byte("content"))
+	if got := cachedFileChecksum(t, path); !bytes.Equal(got, expected) {
+		t.Fatalf("expected the spot check to calculate the checksum, got %x", got)
+	}
+	if got, _ := cache.lookup(absPath, info); !bytes.Equal(got, expected) {
+		t.Fatalf("expected the stale entry to be replaced, got %x", got)
+	}
+}
+
+// TestChecksumCacheConcurrent tests `checksumCache` to ensure that
+// concurrent lookups and stores, as by concurrent transfers, are safe.
+func TestChecksumCacheConcurrent(t *testing.T) {
+	cache := withChecksumCache(t, 50)
+	dir := t.TempDir()
+	paths := make([]string, 20)
+	for i := range paths {
+		paths[i] = filepath.Join(dir, strings.Repeat("f", i+1)+".txt")
+		if err := os.WriteFile(paths[i], []byte(paths[i]), 0644); err != nil {
+			t.Fatalf("failed to create the file: %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, path := range paths {
+				if got := cachedFileChecksum(t, path); !bytes.Equal(got, protocol.CalculateDataChecksum([]byte(path))) {
+					t.Errorf("expected the checksum of %s, got %x", path, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if len(cache.entries) != len(paths) {
+		t.Fatalf("expected %d entries, got %d", len(paths), len(cache.entries))
+	}
+}
+
+// TestPlanDirectoryChecksumCache tests `planDirectory` to ensure that
+// the checksums of the plan are taken from the checksum cache.
+func TestPlanDirectoryChecksumCache(t *testing.T) {
+	cache := withChecksumCache(t, 0)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat the file: %v", err)
+	}
+	absPath, _ := filepath.Abs(path)
+	cached := protocol.CalculateDataChecksum([]byte("cached"))
+	cache.store(absPath, info, cached)
+
+	plan, err := planDirectory(dir, nil, true)
+	if err != nil {
+		t.Fatalf("failed to plan the directory: %v", err)
+	}
+	if len(plan.Files) != 1 || plan.Files[0].Checksum != hex.EncodeToString(cached) {
+		t.Fatalf("expected the cached checksum in the plan, got %+v", plan.Files)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// cleanupRequested reports whether the source files are to be deleted (-delete-source) or archived (-archive-dir)
+// once the server has confirmed them.
+func cleanupRequested() bool {
+	return *deleteSource || *archiveDir != ""
+}
+
+// transferConfirmed reports whether the message of the server's response confirms that the server holds content
+// with the given checksum: a stored transfer acknowledged with the same checksum, or a -sync query that found the same file.
+// An acknowledgement without a checksum (e.g. from an older server) is not a confirmation.
+func transferConfirmed(response string, checksum []byte) bool {
+	if len(checksum) == 0 {
+		return false
+	}
+	if response == protocol.VerifyMessageMatch {
+		return true
+	}
+	confirmed, ok := protocol.ParseTransferReceivedChecksum(response)
+	return ok && bytes.Equal(confirmed, checksum)
+}
+
+// cleanupSource deletes a transferred source file, or moves it into the archive directory under `relPath`,
+// as requested by -delete-source or -archive-dir. `size` and `modTime` are those of the file before its transfer.
+// On any doubt the file is left untouched: a response that does not confirm the checksum, a file changed since then,
+// or an archived file of the same name. It returns whether the file was cleaned up, logging the outcome with the `logger` of the transfer.
+func cleanupSource(logger *slog.Logger, path, relPath string, size int64, modTime time.Time, checksum []byte, response string) bool {
+	if !cleanupRequested() {
+		return false
+	}
+
+	if !transferConfirmed(response, checksum) {
+		logger.Warn("Keeping the source file, since the server did not confirm its checksum", "file_name", path, "response", response)
+		return false
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != size || !info.ModTime().Equal(modTime) {
+		logger.Warn("Keeping the source file, since it changed while being sent", "file_name", path)
+		return false
+	}
+
+	if *deleteSource {
+		if err := os.Remove(path); err != nil {
+			logger.Error("Failed to delete the source file", "file_name", path, "error", err)
+			return false
+		}
+		logger.Info("Deleted the source file", "file_name", path)
+		return true
+	}
+
+	archivePath := filepath.Join(*archiveDir, relPath)
+	if err := archiveFile(path, archivePath); err != nil {
+		logger.Error("Failed to archive the source file", "file_name", path, "archive_path", archivePath, "error", err)
+		return false
+	}
+	logger.Info("Archived the source file", "file_name", path, "archive_path", archivePath)
+	return true
+}
+
+// archiveFile moves the file to the archive path, creating its parent directories, without overwriting an existing file.
+// If the file cannot be renamed (e.g. across file systems), it is copied and then removed.
+func archiveFile(path, archivePath string) error {
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return fmt.Errorf("failed to create the archive directory: %v", err)
+	}
+	if _, err := os.Lstat(archivePath); err == nil {
+		return fmt.Errorf("%s already exists", archivePath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Rename(path, archivePath); err == nil {
+		return nil
+	}
+	if err := copyFile(path, archivePath); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// copyFile copies the file to a new file at the destination, which must not exist.
+// A partial copy is removed.
+func copyFile(path, destination string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open the file: %v", err)
+	}
+	defer func() {
+		if closeErr := source.Close(); closeErr != nil {
+			slog.Warn("Error closing the file", "path", path, "error", closeErr)
+		}
+	}()
+
+	info, err := source.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get the file information: %v", err)
+	}
+	target, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create the archived file: %v", err)
+	}
+
+	_, err = io.Copy(target, source)
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(destination)
+		return fmt.Errorf("failed to copy the file: %v", err)
+	}
+	return nil
+}
+
+// validateArchiveDir checks that the archive directory is not inside the source directory,
+// where archived files would be picked up again (e.g. by -watch).
+func validateArchiveDir(dirPath, archivePath string) error {
+	root, err := filepath.Abs(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the source directory %s: %v", dirPath, err)
+	}
+	archive, err := filepath.Abs(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve the archive directory %s: %v", archivePath, err)
+	}
+	if relPath, err := filepath.Rel(root, archive); err == nil && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("the archive directory %s is inside the source directory %s", archivePath, dirPath)
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"filexfer/protocol"
+	"filexfer/server"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTransferConfirmed tests `transferConfirmed` to ensure that
+// only a response carrying the same checksum (or a matching -sync query) confirms a transfer.
+func TestTransferConfirmed(t *testing.T) {
+	checksum := protocol.CalculateDataChecksum([]byte("content"))
+	other := protocol.CalculateDataChecksum([]byte("other"))
+
+	tests := []struct {
+		name     string
+		response string
+		checksum []byte
+		expected bool
+	}{
+		{"same checksum", protocol.TransferReceivedMessage(checksum), checksum, true},
+		{"different checksum", protocol.TransferReceivedMessage(other), checksum, false},
+		{"no checksum in the response", protocol.TransferMessageReceived, checksum, false},
+		{"discarded by the server", protocol.TransferDiscardedMessage(checksum), checksum, false},
+		{"matching sync query", protocol.VerifyMessageMatch, checksum, true},
+		{"empty response", "", checksum, false},
+		{"no checksum sent", protocol.VerifyMessageMatch, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := transferConfirmed(tt.response, tt.checksum); got != tt.expected {
+				t.Fatalf("transferConfirmed(%q) = %v, expected %v", tt.response, got, tt.expected)
+			}
+		})
+	}
+}
+
+// TestTransferSingleFileDeleteSource tests the "-delete-source" option to ensure that
+// a single file is deleted once the server has confirmed its checksum, and counted in the summary.
+func TestTransferSingleFileDeleteSource(t *testing.T) {
+	withFlags(t, map[string]string{"delete-source": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "outgoing.txt")
+	if err := os.WriteFile(path, []byte("outgoing"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	ms := startMockServer(t)
+
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(ms.receivedFiles()["outgoing.txt"]) != "outgoing" {
+		t.Fatalf("expected the file to be uploaded, got %v", ms.receivedFiles())
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the source file to be deleted, got %v", err)
+	}
+	if summary.cleanedUp != 1 || newTransferReport(summary, nil).CleanedUp != 1 {
+		t.Fatalf("expected one cleaned up file in the summary, got %+v", *summary)
+	}
+}
+
+// TestTransferDirectoryArchiveDir tests the "-archive-dir" option to ensure that
+// the files of a directory are moved into the archive directory under their relative paths.
+func TestTransferDirectoryArchiveDir(t *testing.T) {
+	archiveDir := t.TempDir()
+	withFlags(t, map[string]string{"archive-dir": archiveDir})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{"a.txt": "a", "sub/b.txt": "b"}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	startMockServer(t)
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, content := range files {
+		if _, err := os.Stat(filepath.Join(tmpDir, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be moved, got %v", name, err)
+		}
+		got, err := os.ReadFile(filepath.Join(archiveDir, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("expected %s in the archive directory, got %q and %v", name, got, err)
+		}
+	}
+	if summary.cleanedUp != len(files) {
+		t.Fatalf("expected %d cleaned up files in the summary, got %+v", len(files), *summary)
+	}
+}
+
+// TestTransferKeepsUnconfirmedSource tests the "-delete-source" option to ensure that
+// a file is left untouched when the server acknowledges the transfer without confirming its checksum.
+func TestTransferKeepsUnconfirmedSource(t *testing.T) {
+	withFlags(t, map[string]string{"delete-source": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "outgoing.txt")
+	if err := os.WriteFile(path, []byte("outgoing"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	ms := startMockServer(t)
+	ms.mu.Lock()
+	ms.omitChecksum = true
+	ms.mu.Unlock()
+
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the source file to be kept, got %v", err)
+	}
+	if summary.successful != 1 || summary.cleanedUp != 0 {
+		t.Fatalf("expected a successful transfer without cleanup, got %+v", *summary)
+	}
+}
+
+// TestTransferKeepsDiscardedSource tests the "-delete-source" option to ensure that
+// a file is left untouched when a server in "-discard" mode verifies its content without storing it.
+func TestTransferKeepsDiscardedSource(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	for name, value := range map[string]string{"progress": protocol.ProgressModeNone, "discard": "true"} {
+		if err := server.Flags.Set(name, value); err != nil {
+			t.Fatalf("failed to set the server flag: %v", err)
+		}
+	}
+	defer func() { _ = server.Flags.Set("discard", "false") }()
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone, "delete-source": "true"})
+
+	path := filepath.Join(t.TempDir(), "outgoing.txt")
+	if err := os.WriteFile(path, []byte("outgoing"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the source file to be kept, got %v", err)
+	}
+	if summary.successful != 1 || summary.cleanedUp != 0 {
+		t.Fatalf("expected a successful transfer without cleanup, got %+v", *summary)
+	}
+}
+
+// TestCleanupSourceKeepsChangedFile tests `cleanupSource` to ensure that
+// a file changed since its transfer started is not deleted.
+func TestCleanupSourceKeepsChangedFile(t *testing.T) {
+	withFlags(t, map[string]string{"delete-source": "true"})
+
+	path := filepath.Join(t.TempDir(), "changing.txt")
+	if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat file: %v", err)
+	}
+	checksum := protocol.CalculateDataChecksum([]byte("first"))
+	if err := os.WriteFile(path, []byte("second, longer"), 0644); err != nil {
+		t.Fatalf("failed to rewrite file: %v", err)
+	}
+
+	if cleanupSource(slog.Default(), path, "changing.txt", info.Size(), info.ModTime(), checksum, protocol.TransferReceivedMessage(checksum)) {
+		t.Fatal("expected the changed file not to be cleaned up")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the changed file to be kept, got %v", err)
+	}
+}
+
+// TestArchiveFileDoesNotOverwrite tests `archiveFile` to ensure that
+// an existing file in the archive directory is not overwritten, and the source is kept.
+func TestArchiveFileDoesNotOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "source.txt")
+	archivePath := filepath.Join(dir, "archive", "source.txt")
+	if err := os.WriteFile(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(archivePath, []byte("old"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if err := archiveFile(path, archivePath); err == nil {
+		t.Fatal("expected error for an existing archived file, got nil")
+	}
+	if got, _ := os.ReadFile(archivePath); string(got) != "old" {
+		t.Fatalf("expected the archived file to be kept, got %q", got)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the source file to be kept, got %v", err)
+	}
+}
+
+// TestValidateArchiveDir tests `validateArchiveDir` to ensure that
+// an archive directory inside the source directory is rejected.
+func TestValidateArchiveDir(t *testing.T) {
+	root := t.TempDir()
+
+	if err := validateArchiveDir(root, filepath.Join(root, "archive")); err == nil {
+		t.Fatal("expected error for an archive directory inside the source directory, got nil")
+	}
+	if err := validateArchiveDir(root, root); err == nil {
+		t.Fatal("expected error for the source directory itself, got nil")
+	}
+	if err := validateArchiveDir(root, filepath.Join(filepath.Dir(root), "..archive")); err != nil {
+		t.Fatalf("unexpected error for an archive directory outside the source directory: %v", err)
+	}
+}
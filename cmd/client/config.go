@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DefaultProfile is the profile used when the configuration file has one of this name and -profile is not given.
+const DefaultProfile = "default"
+
+// Command-line flags for selecting the configuration, which cannot be set in a profile themselves.
+var (
+	configPath  = flag.String("config", "", "Path of the configuration file with named profiles (default: filexfer/config.toml in the user configuration directory, e.g. ~/.config)")
+	profileName = flag.String("profile", "", "Name of the configuration profile whose settings are used for the flags not given on the command line")
+	printConfig = flag.Bool("print-config", false, "Print the effective configuration (the profile merged with the command-line flags) and exit")
+)
+
+// A clientConfig is the content of the configuration file:
+// named profiles, each setting client flags by their name, e.g.
+//
+//	[profiles.backups]
+//	server = "backup.internal:8443"
+//	tls-ca = "/etc/pki/ca.pem"
+//	exclude = ["*.tmp", "cache/"]
+type clientConfig struct {
+	Profiles map[string]map[string]any `toml:"profiles"` // Profile name -> flag name -> value.
+}
+
+// defaultConfigPath returns the path of the configuration file used without -config,
+// or an empty string if the user configuration directory is unknown.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "filexfer", "config.toml")
+}
+
+// loadConfig reads the configuration file and warns about the keys it does not know.
+// A missing file is not an error unless `required` (i.e. its path was given with -config).
+func loadConfig(path string, required bool) (*clientConfig, error) {
+	config := &clientConfig{}
+	if path == "" {
+		return config, nil
+	}
+
+	metadata, err := toml.DecodeFile(path, config)
+	if errors.Is(err, fs.ErrNotExist) && !required {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file %s: %v", path, err)
+	}
+
+	// Report an unknown table once, not once more for each of its keys.
+	var unknown []string
+	for _, key := range metadata.Undecoded() {
+		name := key.String()
+		if slices.ContainsFunc(unknown, func(parent string) bool { return strings.HasPrefix(name, parent+".") }) {
+			continue
+		}
+		unknown = append(unknown, name)
+		slog.Warn("Ignoring an unknown key in the configuration file", "key", name, "path", path)
+	}
+	return config, nil
+}
+
+// loadProfile loads the configuration file and applies the selected profile (-profile, or `DefaultProfile` if any)
+// to the flags not given on the command line, whose names are in `explicit`.
+// It returns the name of the applied profile (empty if none) and the names of the flags it set.
+func loadProfile(explicit map[string]bool) (string, []string, error) {
+	path, required := *configPath, *configPath != ""
+	if !required {
+		path = defaultConfigPath()
+	}
+	config, err := loadConfig(path, required || *profileName != "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := *profileName
+	if name == "" {
+		if _, ok := config.Profiles[DefaultProfile]; !ok {
+			return "", nil, nil
+		}
+		name = DefaultProfile
+	}
+
+	profile, ok := config.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(config.Profiles))
+		for profileName := range config.Profiles {
+			names = append(names, profileName)
+		}
+		slices.Sort(names)
+		return "", nil, fmt.Errorf("unknown profile %q in the configuration file %s (available: %s)", name, path, strings.Join(names, ", "))
+	}
+
+	applied, err := applyProfile(name, profile, explicit)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid profile %q in the configuration file %s: %v", name, path, err)
+	}
+	slog.Info("Using the profile of the configuration file", "profile", name, "path", path)
+	return name, applied, nil
+}
+
+// applyProfile sets the flags of the profile, except those given on the command line, which take precedence.
+// Keys that are not client flags are ignored with a warning. It returns the names of the flags it set.
+func applyProfile(name string, profile map[string]any, explicit map[string]bool) ([]string, error) {
+	keys := make([]string, 0, len(profile))
+	for key := range profile {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var applied []string
+	for _, key := range keys {
+		f := flag.Lookup(key)
+		if f == nil || key == "config" || key == "profile" || key == "print-config" {
+			slog.Warn("Ignoring an unknown key in the profile", "key", key, "profile", name)
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+
+		values, err := profileFlagValues(profile[key])
+		if err != nil {
+			return applied, fmt.Errorf("%s: %v", key, err)
+		}
+		if _, repeatable := f.Value.(*stringListFlag); !repeatable && len(values) != 1 {
+			return applied, fmt.Errorf("%s: expected a single value, got %d", key, len(values))
+		}
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return applied, fmt.Errorf("%s: invalid value %q: %v", key, value, err)
+			}
+		}
+		applied = append(applied, key)
+	}
+	return applied, nil
+}
+
+// profileFlagValues converts a value of the configuration file into flag values:
+// one for a string, boolean, or number, and one per element for an array (of a repeatable flag).
+func profileFlagValues(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, element := range v {
+			elementValues, err := profileFlagValues(element)
+			if err != nil || len(elementValues) != 1 {
+				return nil, fmt.Errorf("unsupported array element %v", element)
+			}
+			values = append(values, elementValues...)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("unsupported value %v of type %T", value, value)
+	}
+}
+
+// printEffectiveConfig prints the value of every client flag in the TOML format of a profile,
+// with a comment on where each value that is not the default comes from.
+func printEffectiveConfig(w io.Writer, profile string, fromProfile []string, explicit map[string]bool) error {
+	if profile != "" {
+		if _, err := fmt.Fprintf(w, "# Effective configuration with the profile %q\n", profile); err != nil {
+			return err
+		}
+	} else if _, err := fmt.Fprintf(w, "# Effective configuration without a profile\n"); err != nil {
+		return err
+	}
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "profile" || f.Name == "print-config" {
+			return
+		}
+		origin := ""
+		switch {
+		case explicit[f.Name]:
+			origin = " # command line"
+		case slices.Contains(fromProfile, f.Name):
+			origin = " # profile"
+		}
+		_, err = fmt.Fprintf(w, "%s = %s%s\n", f.Name, formatConfigValue(f), origin)
+	})
+	return err
+}
+
+// formatConfigValue formats the value of a flag as a TOML value.
+func formatConfigValue(f *flag.Flag) string {
+	if list, ok := f.Value.(*stringListFlag); ok {
+		quoted := make([]string, len(*list))
+		for i, value := range *list {
+			quoted[i] = strconv.Quote(value)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			return f.Value.String()
+		}
+	}
+	return strconv.Quote(f.Value.String())
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a configuration file into a temporary directory and points the `-config` flag at it.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create the configuration file: %v", err)
+	}
+	withFlags(t, map[string]string{"config": path})
+	return path
+}
+
+const testConfig = `
+[profiles.backups]
+server = "backup.internal:8443"
+tls-ca = "/etc/pki/ca.pem"
+sync = true
+buffer-size = 65536
+exclude = ["*.tmp", "cache/"]
+bandwidth = "10M"
+
+[profiles.default]
+compress = "gzip"
+`
+
+// TestLoadProfile tests `loadProfile` to ensure that
+// the selected profile sets its flags, and unknown keys are ignored.
+func TestLoadProfile(t *testing.T) {
+	writeConfig(t, testConfig)
+	withFlags(t, map[string]string{"profile": "backups"})
+
+	profile, applied, err := loadProfile(map[string]bool{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if profile != "backups" || strings.Join(applied, ",") != "buffer-size,exclude,server,sync,tls-ca" {
+		t.Fatalf("unexpected profile %q with the flags %v", profile, applied)
+	}
+	if *serverAddr != "backup.internal:8443" || *tlsCAFile != "/etc/pki/ca.pem" || !*syncMode || *bufferSize != 65536 {
+		t.Fatalf("expected the flags of the profile, got server %q, CA %q, sync %v, buffer size %d",
+			*serverAddr, *tlsCAFile, *syncMode, *bufferSize)
+	}
+	if strings.Join(excludePatterns, ",") != "*.tmp,cache/" {
+		t.Fatalf("expected the exclude patterns of the profile, got %v", excludePatterns)
+	}
+	if *compress != "none" {
+		t.Fatalf("expected the default profile to be ignored with -profile, got compress %q", *compress)
+	}
+}
+
+// TestLoadProfileCommandLineOverrides tests `loadProfile` to ensure that
+// the flags given on the command line take precedence over the profile.
+func TestLoadProfileCommandLineOverrides(t *testing.T) {
+	writeConfig(t, testConfig)
+	withFlags(t, map[string]string{"profile": "backups", "server": "other:9000"})
+
+	if _, _, err := loadProfile(map[string]bool{"server": true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *serverAddr != "other:9000" || *tlsCAFile != "/etc/pki/ca.pem" {
+		t.Fatalf("expected the command-line server and the profile CA, got %q and %q", *serverAddr, *tlsCAFile)
+	}
+}
+
+// TestLoadProfileDefault tests `loadProfile` to ensure that
+// the "default" profile is used without -profile, and a missing default configuration file is not an error.
+func TestLoadProfileDefault(t *testing.T) {
+	writeConfig(t, testConfig)
+	if profile, _, err := loadProfile(map[string]bool{}); err != nil || profile != DefaultProfile || *compress != "gzip" {
+		t.Fatalf("expected the default profile, got %q (compress %q) and %v", profile, *compress, err)
+	}
+
+	withFlags(t, map[string]string{"config": ""})
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	if profile, _, err := loadProfile(map[string]bool{}); err != nil || profile != "" {
+		t.Fatalf("expected no profile without a configuration file, got %q and %v", profile, err)
+	}
+}
+
+// TestLoadProfileErrors tests `loadProfile` to ensure that
+// unknown profiles, missing configuration files, and invalid values are rejected.
+func TestLoadProfileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		flags    map[string]string
+		expected string
+	}{
+		{"unknown profile", testConfig, map[string]string{"profile": "nope"}, "available: backups, default"},
+		{"invalid value", "[profiles.p]\nbuffer-size = \"big\"", map[string]string{"profile": "p"}, "buffer-size: invalid value"},
+		{"array for a single flag", "[profiles.p]\nserver = [\"a\", \"b\"]", map[string]string{"profile": "p"}, "expected a single value"},
+		{"table value", "[profiles.p.server]\nhost = \"a\"", map[string]string{"profile": "p"}, "unsupported value"},
+		{"invalid TOML", "[profiles.p\n", map[string]string{"profile": "p"}, "failed to read"},
+		{"missing file", "", map[string]string{"config": "/nonexistent/config.toml"}, "failed to read"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.config != "" {
+				writeConfig(t, tt.config)
+			}
+			withFlags(t, tt.flags)
+
+			_, _, err := loadProfile(map[string]bool{})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestPrintEffectiveConfig tests `printEffectiveConfig` to ensure that
+// every flag is printed as TOML with the origin of its value.
+func TestPrintEffectiveConfig(t *testing.T) {
+	writeConfig(t, testConfig)
+	withFlags(t, map[string]string{"profile": "backups", "sync": "false"})
+
+	explicit := map[string]bool{"sync": true}
+	profile, applied, err := loadProfile(explicit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var out bytes.Buffer
+	if err := printEffectiveConfig(&out, profile, applied, explicit); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, line := range []string{
+		`# Effective configuration with the profile "backups"`,
+		`server = "backup.internal:8443" # profile`,
+		`exclude = ["*.tmp", "cache/"] # profile`,
+		`buffer-size = 65536 # profile`,
+		`sync = false # command line`,
+		`compress = "none"`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("expected the line %q in the configuration, got:\n%s", line, out.String())
+		}
+	}
+	if strings.Contains("\n"+out.String(), "\nprofile =") {
+		t.Fatalf("expected the -profile flag to be left out, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"filexfer/protocol"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// deleteSummary summarizes the outcome of a deletion of paths on the server (-delete-remote).
+type deleteSummary struct {
+	deleted int // Number of paths deleted.
+	missing int // Number of paths not found on the server.
+	failed  int // Number of paths the server refused or failed to delete.
+}
+
+// deleteRemotePaths deletes the paths on the server, relative to its destination directory (under -remote-dir),
+// over a single connection. Directories are only deleted with -recursive. Paths already missing on the server
+// are reported but do not fail the deletion, so that a mirror can be pruned again after an interrupted run.
+func deleteRemotePaths(ctx context.Context, paths []string) (*deleteSummary, error) {
+	summary := &deleteSummary{}
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return summary, fmt.Errorf("failed to establish the connection for the deletion: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logger.Warn("Error closing the deletion connection", "error", err)
+		}
+	}()
+
+	transferType := uint8(protocol.TransferTypeFile)
+	if *recursive {
+		transferType = protocol.TransferTypeDirectory
+	}
+
+	for _, path := range paths {
+		select {
+		case <-ctx.Done():
+			return summary, fmt.Errorf("deletion interrupted: %v", ctx.Err())
+		default:
+		}
+
+		header := &protocol.Header{
+			MessageType:   protocol.MessageTypeDelete,
+			FileName:      path,
+			Checksum:      make([]byte, protocol.ChecksumSize),
+			TransferType:  transferType,
+			DirectoryPath: *remoteDir,
+		}
+
+		if err := conn.SetDeadline(time.Now().Add(WriteTimeout)); err != nil {
+			return summary, fmt.Errorf("failed to set deadline: %v", err)
+		}
+		if err := protocol.WriteHeader(conn, header); err != nil {
+			return summary, fmt.Errorf("failed to send the deletion header for %s: %v", path, err)
+		}
+		status, message, err := protocol.ReadResponse(conn)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read the deletion response for %s: %v", path, err)
+		}
+
+		switch {
+		case status == protocol.ResponseStatusSuccess:
+			logger.Info("Deleted", "file_name", path)
+			summary.deleted++
+		case message == protocol.VerifyMessageNotFound:
+			logger.Warn("Missing on the server", "file_name", path)
+			summary.missing++
+		default:
+			logger.Error("Failed to delete", "file_name", path, "error", message)
+			summary.failed++
+		}
+	}
+
+	logger.Info("Deletion summary", "deleted", summary.deleted, "missing", summary.missing, "failed", summary.failed)
+
+	if summary.failed > 0 {
+		return summary, fmt.Errorf("failed to delete %d out of %d paths", summary.failed, len(paths))
+	}
+	return summary, nil
+}
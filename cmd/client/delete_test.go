@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestDeleteRemotePaths tests `deleteRemotePaths` to ensure that
+// files are deleted under -remote-dir, missing paths are reported without failing, and directories require -recursive.
+func TestDeleteRemotePaths(t *testing.T) {
+	withFlags(t, map[string]string{"remote-dir": "mirror"})
+	ms := startMockServer(t)
+	ms.store("mirror/a.txt", []byte("alpha"))
+	ms.store("mirror/old/b.txt", []byte("bravo"))
+	ms.store("kept.txt", []byte("kept"))
+
+	summary, err := deleteRemotePaths(context.Background(), []string{"a.txt", "gone.txt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.deleted != 1 || summary.missing != 1 || summary.failed != 0 {
+		t.Fatalf("expected 1 deleted and 1 missing path, got %+v", summary)
+	}
+
+	withFlags(t, map[string]string{"recursive": "true"})
+	if _, err := deleteRemotePaths(context.Background(), []string{"old"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	received := ms.receivedFiles()
+	if len(received) != 1 || string(received["kept.txt"]) != "kept" {
+		t.Fatalf("expected only kept.txt to remain, got %v", received)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"time"
+)
+
+// ErrServerLimit is returned for a transfer that the server would reject according to its limits (see `protocol.ServerInfo`).
+var ErrServerLimit = errors.New("transfer exceeds the limits of the server")
+
+// ServerInfoThreshold is the size from which a single file is checked against the limits of the server before it is uploaded,
+// so that small files do not pay for the extra request (64MB).
+// It's defined as a variable to allow modification during testing, although it should remain constant in practice.
+var ServerInfoThreshold int64 = 64 * 1024 * 1024
+
+// fetchServerInfo asks the server for its limits and capabilities on a connection of its own.
+// A server predating information requests answers with an error, so the caller should fall back to its own limits.
+func fetchServerInfo() (*protocol.ServerInfo, error) {
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return nil, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypeInfo,
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeFile,
+	}
+	if err := protocol.WriteHeader(conn, header); err != nil {
+		return nil, fmt.Errorf("failed to send the information header: %v", err)
+	}
+
+	message, err := readServerResponseMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the server information: %w", err)
+	}
+	return protocol.ParseServerInfo(message)
+}
+
+// pingServer sends a ping request on a connection of its own and returns the message of the server's response,
+// with its version and uptime, and the round-trip time of the request (including the TLS handshake, if any).
+func pingServer() (string, time.Duration, error) {
+	startTime := time.Now()
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return "", 0, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypePing,
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeFile,
+	}
+	if err := protocol.WriteHeader(conn, header); err != nil {
+		return "", 0, fmt.Errorf("failed to send the ping header: %v", err)
+	}
+
+	message, err := readServerResponseMessage(conn)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to ping the server: %w", err)
+	}
+	return message, time.Since(startTime), nil
+}
+
+// checkFileLimits checks a single file of `size` bytes against the limits of the server.
+func checkFileLimits(info *protocol.ServerInfo, name string, size int64) error {
+	if uint64(size) > info.MaxFileSize {
+		return fmt.Errorf("%w: the server only accepts files up to %d bytes (%.2f GB), but %s is %d bytes",
+			ErrServerLimit, info.MaxFileSize, toGB(info.MaxFileSize), name, size)
+	}
+	return checkFreeSpace(info, size)
+}
+
+// checkDirectoryLimits checks the files of a directory transfer against the limits of the server:
+// their total size, and the size of the largest one.
+func checkDirectoryLimits(info *protocol.ServerInfo, dirPath string, listing *directoryListing) error {
+	if uint64(listing.totalSize) > info.MaxDirectorySize {
+		return fmt.Errorf("%w: the server only accepts directories up to %d bytes (%.2f GB), but %s is %d bytes",
+			ErrServerLimit, info.MaxDirectorySize, toGB(info.MaxDirectorySize), dirPath, listing.totalSize)
+	}
+	if listing.largestFile != "" {
+		if err := checkFileLimits(info, listing.largestFile, listing.largestSize); err != nil {
+			return err
+		}
+	}
+	return checkFreeSpace(info, listing.totalSize)
+}
+
+// checkFreeSpace checks that the server has room for `size` bytes, if it reports its free space.
+func checkFreeSpace(info *protocol.ServerInfo, size int64) error {
+	if info.FreeBytes > 0 && uint64(size) > info.FreeBytes {
+		return fmt.Errorf("%w: the server only has %d bytes (%.2f GB) free, but the transfer needs %d bytes",
+			ErrServerLimit, info.FreeBytes, toGB(info.FreeBytes), size)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withServerInfo makes the mock server answer information requests with the limits.
+func withServerInfo(ms *mockServer, maxFileSize, maxDirectorySize, freeBytes uint64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.info = &protocol.ServerInfo{
+		MaxFileSize:      maxFileSize,
+		MaxDirectorySize: maxDirectorySize,
+		Checksums:        []string{protocol.ChecksumAlgorithmSHA256},
+		Compressions:     protocol.CompressionNames(),
+		Sessions:         true,
+		FreeBytes:        freeBytes,
+	}
+}
+
+// TestTransferDirectoryServerLimits tests `transferDirectory` to ensure that
+// a directory exceeding a limit reported by the server is rejected locally, before any file is uploaded.
+func TestTransferDirectoryServerLimits(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"small.txt": "small", "large.txt": "larger content"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name             string
+		maxFileSize      uint64
+		maxDirectorySize uint64
+		freeBytes        uint64
+		expected         string
+	}{
+		{"file too large", 10, 1024, 0, "only accepts files up to 10 bytes"},
+		{"directory too large", 1024, 10, 0, "only accepts directories up to 10 bytes"},
+		{"not enough space", 1024, 1024, 10, "only has 10 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := startMockServer(t)
+			withServerInfo(ms, tt.maxFileSize, tt.maxDirectorySize, tt.freeBytes)
+
+			_, err := transferDirectory(context.Background(), dir, nil)
+			if !errors.Is(err, ErrServerLimit) || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected ErrServerLimit with %q, got %v", tt.expected, err)
+			}
+			if received := ms.receivedFiles(); len(received) != 0 {
+				t.Fatalf("expected no file to be uploaded, got %v", received)
+			}
+		})
+	}
+
+	ms := startMockServer(t)
+	withServerInfo(ms, 1024, 1024, 1024)
+	summary, err := transferDirectory(context.Background(), dir, nil)
+	if err != nil || summary.successful != 2 {
+		t.Fatalf("expected the directory within the limits to be transferred, got %d files (%v)", summary.successful, err)
+	}
+}
+
+// TestTransferSingleFileServerLimits tests `transferSingleFile` to ensure that
+// a file above `ServerInfoThreshold` is checked against the limits of the server before it is uploaded,
+// and is still transferred to a server that does not answer information requests.
+func TestTransferSingleFileServerLimits(t *testing.T) {
+	originalThreshold := ServerInfoThreshold
+	ServerInfoThreshold = 0
+	t.Cleanup(func() { ServerInfoThreshold = originalThreshold })
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("some content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ms := startMockServer(t)
+	withServerInfo(ms, 4, 1024, 0)
+	summary, err := transferSingleFile(context.Background(), path)
+	if !errors.Is(err, ErrServerLimit) || !strings.Contains(err.Error(), "only accepts files up to 4 bytes") {
+		t.Fatalf("expected ErrServerLimit, got %v", err)
+	}
+	if summary.failed != 1 || len(ms.receivedFiles()) != 0 {
+		t.Fatalf("expected the file to fail without being uploaded, got %d failed and %v", summary.failed, ms.receivedFiles())
+	}
+
+	// A server predating information requests.
+	ms = startMockServer(t)
+	if _, err := transferSingleFile(context.Background(), path); err != nil {
+		t.Fatalf("expected the file to be transferred without the server information, got %v", err)
+	}
+	if string(ms.receivedFiles()["file.txt"]) != "some content" {
+		t.Fatalf("expected the file on the server, got %v", ms.receivedFiles())
+	}
+}
+
+// TestPingServer tests `pingServer` to ensure that
+// the message of the server is returned without transferring anything.
+func TestPingServer(t *testing.T) {
+	ms := startMockServer(t)
+
+	message, roundTrip, err := pingServer()
+	if err != nil {
+		t.Fatalf("failed to ping the server: %v", err)
+	}
+	if message != "filexfer test, uptime 1m0s" || roundTrip <= 0 {
+		t.Fatalf("expected the version and uptime with a round trip, got %q in %v", message, roundTrip)
+	}
+	if received := ms.receivedFiles(); len(received) != 0 {
+		t.Fatalf("expected no file to be transferred, got %v", received)
+	}
+}
@@ -1,19 +1,28 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"filexfer/protocol"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net"
+	"net/netip"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -26,12 +35,49 @@ var (
 	ErrFileTooLarge     = errors.New("file size exceeds the maximum allowed size")
 	ErrInvalidFilename  = errors.New("invalid filename")
 	ErrConnectionFailed = errors.New("connection failed")
+	ErrFileUnchanged    = errors.New("file is unchanged on the server")
+	ErrServerShutdown   = errors.New("server is shutting down, retry later")
+	ErrServerSkipped    = errors.New("file already exists on the server, which skips existing files")
+	ErrAuthFailed       = errors.New("not allowed by the server")
+	ErrTimeout          = errors.New("operation exceeded its time limit (-timeout)")
+	ErrConnectionLost   = errors.New("connection to the server lost")
+	ErrIsDirectory      = errors.New("path is a directory, but a file is expected")
+	ErrNotDirectory     = errors.New("path is not a directory, but a directory is expected")
 )
 
+// Exit codes of the failures that a script may handle on their own, from sysexits.h.
+const (
+	// ExitServerShutdown is the exit code of a transfer refused or interrupted by a server shutting down
+	// (`EX_TEMPFAIL`), so that a script can tell it from other failures and retry the transfer later.
+	ExitServerShutdown = 75
+	// ExitAuthFailed is the exit code of a transfer refused by the server because the client is not allowed to make it
+	// (`EX_NOPERM`), which is not worth retrying.
+	ExitAuthFailed = 77
+)
+
+// responseCodeErrors maps the error codes of the server's responses to the errors returned for them.
+var responseCodeErrors = map[uint16]error{
+	protocol.ErrorCodeFileTooLarge:      ErrFileTooLarge,
+	protocol.ErrorCodeQuotaExceeded:     ErrServerLimit,
+	protocol.ErrorCodeTraversalRejected: ErrInvalidFilename,
+	protocol.ErrorCodeChecksumMismatch:  protocol.ErrChecksumMismatch,
+	protocol.ErrorCodeAuthFailed:        ErrAuthFailed,
+}
+
+// StdinPath is the source path that stands for the standard input, whose content is streamed to the server.
+const StdinPath = "-"
+
 // MaxFileSize is the maximum allowed file size for transfers (5GB).
 // It's defined as a variable to allow modification during testing, although it should remain constant in practice.
 var MaxFileSize int64 = 5 * 1024 * 1024 * 1024
 
+// Limits of the reconnection of a directory transfer whose connection is lost part-way (e.g. the server restarted).
+// They're defined as variables to allow modification during testing, although they should remain constant in practice.
+var (
+	MaxFileRetries   = 3           // Times a file is sent again on a new connection after losing the connection during its transfer.
+	ReconnectBackoff = time.Second // Wait before reconnecting, doubled for each further attempt for the same file.
+)
+
 // Other constants for client configuration.
 const (
 	LogPrefix          = "[CLIENT]"       // Log prefix for client logs.
@@ -39,17 +85,516 @@ const (
 	ReadTimeout        = 30 * time.Second // Read timeout duration.
 	WriteTimeout       = 30 * time.Second // Write timeout duration.
 	ShutdownTimeout    = 30 * time.Second // Shutdown timeout duration.
-	TransferBufferSize = 1024 * 1024      // 1MB buffer for `io.CopyBuffer` to improve throughput.
+	TransferBufferSize = 256 * 1024       // Default 256KB buffer for `io.CopyBuffer` to improve throughput (see `protocol.CopyBuffers`).
+	MaxBufferSize      = 64 * 1024 * 1024 // Maximum allowed copy buffer size (64MB).
+	DefaultServerPort  = "8080"           // Port used when the server address has none.
 )
 
 // Command-line flags for the client.
 var (
-	serverAddr    = flag.String("server", "localhost:8080", "Server address (IP:Port)")
-	filePath      = flag.String("file", "", "File or directory to be transferred (required)")
+	serverAddr    = flag.String("server", "localhost:8080", "Server address (host:port, with IPv6 addresses in brackets, e.g. [::1]:8080)")
+	filePath      = flag.String("file", "", "File or directory to be transferred (more can be given as positional arguments)")
 	tlsSkipVerify = flag.Bool("tls-skip-verify", false, "Skip TLS certificate verification (insecure, for testing only)")
+	tcpNoDelay    = flag.Bool("tcp-nodelay", true, "Disable Nagle's algorithm, so that the small headers of many-file transfers are sent without delay")
+	tcpKeepAlive  = flag.Duration("tcp-keepalive", protocol.DefaultKeepAlivePeriod, "Interval of TCP keep-alive probes on idle connections (0 to disable keep-alive)")
 	tlsCAFile     = flag.String("tls-ca", "", "Path to CA certificate file for TLS verification")
+	tlsCertFile   = flag.String("cert", "", "Path to the TLS client certificate presented to a server requiring one (enables TLS)")
+	tlsKeyFile    = flag.String("key", "", "Path to the private key of the -cert client certificate")
+	planOnly      = flag.Bool("plan", false, "Print the transfer plan of a directory as JSON and exit without transferring")
+	planChecksums = flag.Bool("plan-checksums", false, "Include per-file checksums in the transfer plan printed by -plan")
+	verifyOnly    = flag.Bool("verify", false, "Verify that the server's copies match the local file or directory without re-sending")
+	checksumOnly  = flag.String("checksum-only", "", "Verify the directory against this manifest of checksums (sha256sum format or -plan JSON) offline and exit")
+	noIgnoreFile  = flag.Bool("no-ignore-file", false, "Do not honor the .filexferignore file at the root of a transferred directory")
+	followLinks   = flag.Bool("follow-symlinks", false, "Transfer the content of symbolic links in a directory (walking linked directories) instead of skipping them")
+	bufferSize    = flag.Int("buffer-size", TransferBufferSize, "Size of the copy buffer in bytes used for transfers")
+	streamName    = flag.String("name", "", "Name of the file on the server when streaming from stdin (-file -)")
+	streamSize    = flag.Int64("size", 0, "Expected size in bytes of the stream from stdin (-file -), shown in its progress and checked at its end (0 if unknown)")
+	quiet         = flag.Bool("quiet", false, "Suppress all progress output (same as -progress=none)")
+	progress      = flag.String("progress", protocol.ProgressModeAuto, "Progress output mode: auto, bar, plain, or none")
+	compress      = flag.String("compress", "none", "Compress the content of files in transit: none, gzip, or zstd")
+	syncMode      = flag.Bool("sync", false, "Ask the server for each file first and only upload files it does not already have")
+	watchMode     = flag.Bool("watch", false, "Keep watching the directory and transfer new or changed files until interrupted")
+	watchInterval = flag.Duration("watch-interval", time.Second, "How often -watch scans the directory for new or changed files")
+	watchSettle   = flag.Duration("watch-settle", 2*time.Second, "How long the size and modification time of a file must stay unchanged before -watch sends it")
+	deleteSource  = flag.Bool("delete-source", false, "Delete each local file once the server has confirmed its checksum")
+	archiveDir    = flag.String("archive-dir", "", "Move each local file into this directory (under its relative path) once the server has confirmed its checksum")
+	tarMode       = flag.Bool("tar", false, "Send each directory as a single tar archive stream instead of file by file")
+	parallel      = flag.Int("parallel-streams", 1, "Send a single large file (64MB or more) as this many byte ranges, each over a connection of its own, which the server assembles")
+	checksumBlock = flag.Int("checksum-block", 0, "Send the checksums of the blocks of this many bytes of each file (at least 65536), so that the server reports the first corrupted block (0 to disable)")
+	xattrs        = flag.Bool("xattrs", false, "Send the extended attributes of files for the server to restore (Linux and macOS; only user.* on a Linux server)")
+	preserveTimes = flag.Bool("preserve-times", false, "Send the modification time of files for the server to restore and compare with its -strategy newer")
+	failFast      = flag.Bool("fail-fast", false, "Stop at the first source path that fails instead of continuing with the rest")
+	jsonOutput    = flag.Bool("json", false, "Print a JSON summary of the transfer to stdout (status messages go to stderr)")
+	remoteDir     = flag.String("remote-dir", "", "Subdirectory of the server's destination directory to store the transferred files in (e.g. backups/2024)")
+	deleteRemote  = flag.Bool("delete-remote", false, "Delete the source paths on the server (relative to its destination directory, under -remote-dir) instead of transferring them")
+	recursive     = flag.Bool("recursive", false, "With -delete-remote, also delete directories with their contents")
+	cachePath     = flag.String("checksum-cache", "", "Path of a file caching the checksums of files by path, size, and modification time, e.g. ~/.cache/filexfer/checksums.json (off if empty)")
+	cacheVerify   = flag.Float64("checksum-cache-verify", 0, "Percentage of the -checksum-cache hits re-hashed anyway as a spot check (0 to 100)")
+	ping          = flag.Bool("ping", false, "Check that the server is up (over TLS if configured) and print its version and uptime, without transferring anything")
+	benchmark     = flag.Bool("benchmark", false, "Send -benchmark-size bytes of generated content to the server and print the throughput, without reading a file (best against a -discard server)")
+	benchmarkSize = flag.Int64("benchmark-size", BenchmarkSize, "Size in bytes of the content sent by -benchmark")
+	logFormat     = flag.String("log-format", protocol.LogFormatText, "Log output format: text or json")
+	logLevel      = flag.String("log-level", "info", "Minimum level of logged messages: debug, info, warn, or error")
+	opTimeout     = flag.Duration("timeout", 0, "Time limit of the whole operation (e.g. a directory transfer), after which it is cancelled (0 for no limit)")
+)
+
+// statusOutput is where human-readable transfer status is printed.
+// In JSON mode it is switched to stderr so that stdout carries only the JSON summary.
+var statusOutput io.Writer = os.Stdout
+
+// Repeatable command-line flags for filtering directory transfers.
+var (
+	excludePatterns stringListFlag // Glob patterns of paths to be excluded from directory transfers.
+	includePatterns stringListFlag // Glob patterns of paths to be included even if they match an exclude pattern.
 )
 
+func init() {
+	flag.Var(&excludePatterns, "exclude", "Glob pattern of paths to exclude from directory transfers (repeatable)")
+	flag.Var(&includePatterns, "include", "Glob pattern of paths to include even if excluded (repeatable)")
+}
+
+// A flagRule is an invariant over one or more command-line flags that is checked at startup,
+// before any connection is made or file is read.
+type flagRule struct {
+	flags []string     // Flags involved in the rule.
+	check func() error // Returns a description of the violation, or nil if the rule holds.
+	fix   string       // Suggested fix printed along with a violation.
+}
+
+// flagRules holds the invariants over the client's flags.
+// New flags register their constraints here, next to their definitions above.
+var flagRules = []flagRule{
+	{
+		flags: []string{"file"},
+		check: func() error {
+			if len(sourceArgs()) == 0 && !*ping && !*benchmark {
+				return fmt.Errorf("file path is required")
+			}
+			return nil
+		},
+		fix: "use -file flag or positional arguments to specify the source files or directories",
+	},
+	{
+		flags: []string{"ping", "file"},
+		check: func() error {
+			if *ping && len(sourceArgs()) > 0 {
+				return fmt.Errorf("-ping only checks the server, so it takes no source paths")
+			}
+			return nil
+		},
+		fix: "run -ping on its own, e.g. -ping -server host:8080",
+	},
+	{
+		flags: []string{"benchmark", "file"},
+		check: func() error {
+			if *benchmark && len(sourceArgs()) > 0 {
+				return fmt.Errorf("-benchmark sends generated content, so it takes no source paths")
+			}
+			return nil
+		},
+		fix: "run -benchmark on its own, e.g. -benchmark -benchmark-size 1073741824 -server host:8080",
+	},
+	{
+		flags: []string{"benchmark-size"},
+		check: func() error {
+			if *benchmarkSize <= 0 {
+				return fmt.Errorf("size %d is not positive", *benchmarkSize)
+			}
+			return nil
+		},
+		fix: "use a size in bytes, e.g. 1073741824 for 1GB",
+	},
+	{
+		flags: []string{"server"},
+		check: func() error {
+			_, err := parseServerAddress(*serverAddr)
+			return err
+		},
+		fix: "use host:port, e.g. localhost:8080, 192.0.2.1:8080, or [::1]:8080",
+	},
+	{
+		flags: []string{"tcp-keepalive"},
+		check: func() error {
+			if *tcpKeepAlive < 0 {
+				return fmt.Errorf("negative keep-alive period %v", *tcpKeepAlive)
+			}
+			return nil
+		},
+		fix: "use a period such as 30s, or 0 to disable keep-alive",
+	},
+	{
+		flags: []string{"buffer-size"},
+		check: func() error {
+			if *bufferSize <= 0 || *bufferSize > MaxBufferSize {
+				return fmt.Errorf("invalid buffer size %d: must be between 1 and %d bytes", *bufferSize, MaxBufferSize)
+			}
+			return nil
+		},
+		fix: "use a positive size up to 64MB, e.g. 1048576 for 1MB",
+	},
+	{
+		flags: []string{"progress", "quiet"},
+		check: func() error {
+			if !protocol.ValidProgressMode(*progress) {
+				return fmt.Errorf("invalid progress mode %q", *progress)
+			}
+			if *quiet && (*progress == protocol.ProgressModeBar || *progress == protocol.ProgressModePlain) {
+				return fmt.Errorf("-quiet suppresses the progress output requested by -progress=%s", *progress)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use one of: %s, %s, %s, %s, and drop -quiet to show progress",
+			protocol.ProgressModeAuto, protocol.ProgressModeBar, protocol.ProgressModePlain, protocol.ProgressModeNone),
+	},
+	{
+		flags: []string{"tls-skip-verify", "tls-ca"},
+		check: func() error {
+			if *tlsSkipVerify && *tlsCAFile != "" {
+				return fmt.Errorf("-tls-skip-verify and -tls-ca are mutually exclusive")
+			}
+			return nil
+		},
+		fix: "drop -tls-skip-verify to verify the server against the CA certificate",
+	},
+	{
+		flags: []string{"checksum-cache-verify", "checksum-cache"},
+		check: func() error {
+			if *cacheVerify < 0 || *cacheVerify > 100 {
+				return fmt.Errorf("invalid -checksum-cache-verify percentage %g: must be between 0 and 100", *cacheVerify)
+			}
+			if *cacheVerify > 0 && *cachePath == "" {
+				return fmt.Errorf("-checksum-cache-verify only applies with -checksum-cache")
+			}
+			return nil
+		},
+		fix: "use a percentage such as 5 along with -checksum-cache",
+	},
+	{
+		flags: []string{"cert", "key"},
+		check: func() error {
+			if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+				return fmt.Errorf("-cert and -key must be given together")
+			}
+			return nil
+		},
+		fix: "provide both the client certificate and its private key",
+	},
+	{
+		flags: []string{"include", "exclude", "no-ignore-file"},
+		check: func() error {
+			if len(includePatterns) > 0 && len(excludePatterns) == 0 && *noIgnoreFile {
+				return fmt.Errorf("-include only re-admits paths matched by -exclude or the ignore file, but neither is in effect")
+			}
+			return nil
+		},
+		fix: "add an -exclude pattern, drop -no-ignore-file, or drop -include",
+	},
+	{
+		flags: []string{"include", "exclude"},
+		check: func() error {
+			_, err := protocol.NewPathFilter(includePatterns, excludePatterns)
+			return err
+		},
+		fix: "use glob patterns such as '*.log', 'node_modules', or 'src/**/*.tmp'",
+	},
+	{
+		flags: []string{"plan-checksums", "plan"},
+		check: func() error {
+			if *planChecksums && !*planOnly {
+				return fmt.Errorf("-plan-checksums has no effect without -plan")
+			}
+			return nil
+		},
+		fix: "add -plan or drop -plan-checksums",
+	},
+	{
+		flags: []string{"plan", "verify"},
+		check: func() error {
+			if *planOnly && *verifyOnly {
+				return fmt.Errorf("-plan and -verify are mutually exclusive")
+			}
+			return nil
+		},
+		fix: "run -plan and -verify as separate invocations",
+	},
+	{
+		flags: []string{"name", "file"},
+		check: func() error {
+			stdinCount := 0
+			for _, arg := range sourceArgs() {
+				if arg == StdinPath {
+					stdinCount++
+				}
+			}
+			switch {
+			case stdinCount > 1:
+				return fmt.Errorf("stdin (%s) can only be given once", StdinPath)
+			case stdinCount == 1 && *streamName == "":
+				return fmt.Errorf("-name is required when reading from stdin")
+			case stdinCount == 0 && *streamName != "":
+				return fmt.Errorf("-name only applies when reading from stdin")
+			case stdinCount == 1 && (*planOnly || *verifyOnly):
+				return fmt.Errorf("stdin can only be transferred, not planned or verified")
+			}
+			return nil
+		},
+		fix: "use '-file - -name <file name>' to stream stdin, e.g. pg_dump mydb | filexfer-client -file - -name mydb.sql",
+	},
+	{
+		flags: []string{"size", "file"},
+		check: func() error {
+			switch {
+			case *streamSize < 0:
+				return fmt.Errorf("invalid stream size %d: must not be negative", *streamSize)
+			case *streamSize > 0 && !slices.Contains(sourceArgs(), StdinPath):
+				return fmt.Errorf("-size only applies when reading from stdin")
+			}
+			return nil
+		},
+		fix: "use '-file - -name <file name> -size <bytes>', or leave -size out if the size of the stream is unknown",
+	},
+	{
+		flags: []string{"timeout"},
+		check: func() error {
+			if *opTimeout < 0 {
+				return fmt.Errorf("invalid timeout %v: must not be negative", *opTimeout)
+			}
+			return nil
+		},
+		fix: "use a duration such as 10m, or 0 for no limit",
+	},
+	{
+		flags: []string{"plan", "file"},
+		check: func() error {
+			if *planOnly && len(sourceArgs()) > 1 {
+				return fmt.Errorf("-plan takes a single directory, but %d source paths are given", len(sourceArgs()))
+			}
+			return nil
+		},
+		fix: "run -plan once per directory",
+	},
+	{
+		flags: []string{"compress", "file"},
+		check: func() error {
+			if _, err := protocol.ParseCompression(*compress); err != nil {
+				return err
+			}
+			if *compress != "none" && slices.Contains(sourceArgs(), StdinPath) {
+				return fmt.Errorf("-compress does not apply to stdin streams")
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use one of: %s, and pipe stdin through a compressor instead", strings.Join(protocol.CompressionNames(), ", ")),
+	},
+	{
+		flags: []string{"sync", "plan", "verify"},
+		check: func() error {
+			if *syncMode && (*planOnly || *verifyOnly) {
+				return fmt.Errorf("-sync only applies to transfers, not -plan or -verify runs")
+			}
+			return nil
+		},
+		fix: "drop -sync (-verify already compares without uploading)",
+	},
+	{
+		flags: []string{"watch", "file", "plan", "verify", "json"},
+		check: func() error {
+			if !*watchMode {
+				return nil
+			}
+			switch {
+			case len(sourceArgs()) != 1 || sourceArgs()[0] == StdinPath:
+				return fmt.Errorf("-watch takes a single directory, but %d source paths are given", len(sourceArgs()))
+			case *planOnly || *verifyOnly:
+				return fmt.Errorf("-watch only applies to transfers, not -plan or -verify runs")
+			case *jsonOutput:
+				return fmt.Errorf("-json summarizes a finished transfer, but -watch runs until interrupted")
+			}
+			return nil
+		},
+		fix: "watch one directory per client, e.g. -watch -file ./outbox",
+	},
+	{
+		flags: []string{"watch-interval", "watch-settle"},
+		check: func() error {
+			if *watchInterval <= 0 {
+				return fmt.Errorf("invalid scan interval %v: must be positive", *watchInterval)
+			}
+			if *watchSettle < 0 {
+				return fmt.Errorf("invalid settle time %v: must not be negative", *watchSettle)
+			}
+			return nil
+		},
+		fix: "use durations such as 1s or 500ms",
+	},
+	{
+		flags: []string{"delete-source", "archive-dir", "plan", "verify", "file"},
+		check: func() error {
+			if !cleanupRequested() {
+				return nil
+			}
+			if *deleteSource && *archiveDir != "" {
+				return fmt.Errorf("-delete-source and -archive-dir are mutually exclusive")
+			}
+			if *planOnly || *verifyOnly {
+				return fmt.Errorf("-delete-source and -archive-dir only apply to transfers, not -plan or -verify runs")
+			}
+			if slices.Contains(sourceArgs(), StdinPath) {
+				return fmt.Errorf("stdin (-file -) cannot be deleted or archived")
+			}
+			return nil
+		},
+		fix: "choose either -delete-source or -archive-dir, and give file or directory sources",
+	},
+	{
+		flags: []string{"tar", "sync", "watch", "compress", "xattrs", "delete-source", "archive-dir", "plan", "verify"},
+		check: func() error {
+			switch {
+			case !*tarMode:
+				return nil
+			case *syncMode || *watchMode:
+				return fmt.Errorf("-tar sends whole directories, so it cannot skip or watch individual files (-sync, -watch)")
+			case *compress != "none":
+				return fmt.Errorf("-tar does not support -compress")
+			case *xattrs:
+				return fmt.Errorf("-tar does not support -xattrs")
+			case cleanupRequested():
+				return fmt.Errorf("-tar confirms the whole archive, not the individual files to delete or archive")
+			case *planOnly || *verifyOnly:
+				return fmt.Errorf("-tar only applies to transfers, not -plan or -verify runs")
+			}
+			return nil
+		},
+		fix: "drop -tar to use these options with file-by-file transfers",
+	},
+	{
+		flags: []string{"parallel-streams", "compress", "xattrs", "sync", "tar", "file"},
+		check: func() error {
+			switch {
+			case *parallel < 1 || *parallel > MaxParallelStreams:
+				return fmt.Errorf("invalid number of parallel streams %d: must be between 1 and %d", *parallel, MaxParallelStreams)
+			case *parallel == 1:
+				return nil
+			case *compress != "none" || *xattrs:
+				return fmt.Errorf("-parallel-streams sends raw byte ranges, so it does not support -compress or -xattrs")
+			case *syncMode || *tarMode:
+				return fmt.Errorf("-parallel-streams uploads single files as they are, so it cannot be combined with -sync or -tar")
+			case slices.Contains(sourceArgs(), StdinPath):
+				return fmt.Errorf("-parallel-streams needs a file to read the ranges from, not stdin")
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use a number of streams up to %d, e.g. -parallel-streams 4, without these options", MaxParallelStreams),
+	},
+	{
+		flags: []string{"checksum-block", "parallel-streams", "tar", "file"},
+		check: func() error {
+			switch {
+			case *checksumBlock == 0:
+				return nil
+			case *checksumBlock < protocol.MinBlockSize || *checksumBlock > protocol.MaxBlockSize:
+				return fmt.Errorf("invalid block size %d: must be between %d and %d", *checksumBlock, protocol.MinBlockSize, protocol.MaxBlockSize)
+			case *parallel > 1:
+				return fmt.Errorf("-checksum-block is not sent with the byte ranges of -parallel-streams")
+			case *tarMode || slices.Contains(sourceArgs(), StdinPath):
+				return fmt.Errorf("-checksum-block needs the size of each file up front, so it does not support -tar or stdin")
+			}
+			return nil
+		},
+		fix: "use a block size such as 4194304 (4MB), without these options, or 0 to disable",
+	},
+	{
+		flags: []string{"checksum-only", "file", "plan", "verify", "sync", "watch", "tar", "json"},
+		check: func() error {
+			switch {
+			case *checksumOnly == "":
+				return nil
+			case len(sourceArgs()) != 1 || sourceArgs()[0] == StdinPath:
+				return fmt.Errorf("-checksum-only takes a single directory, but %d source paths are given", len(sourceArgs()))
+			case *planOnly || *verifyOnly || *syncMode || *watchMode || *tarMode || *jsonOutput:
+				return fmt.Errorf("-checksum-only checks the directory offline, so it cannot be combined with other modes")
+			}
+			return nil
+		},
+		fix: "run -checksum-only on its own, e.g. -checksum-only SHA256SUMS -file ./dir",
+	},
+	{
+		flags: []string{"json", "plan", "verify"},
+		check: func() error {
+			if *jsonOutput && (*planOnly || *verifyOnly) {
+				return fmt.Errorf("-json only summarizes transfers, not -plan or -verify runs")
+			}
+			return nil
+		},
+		fix: "drop -json (-plan already prints JSON)",
+	},
+	{
+		flags: []string{"remote-dir"},
+		check: func() error {
+			if *remoteDir == "" {
+				return nil
+			}
+			if err := validateRemotePath(*remoteDir); err != nil {
+				return fmt.Errorf("invalid remote directory %q: %v", *remoteDir, err)
+			}
+			return nil
+		},
+		fix: "give a relative path without \"..\", e.g. -remote-dir backups/2024",
+	},
+	{
+		flags: []string{"delete-remote", "recursive", "file", "plan", "verify", "sync", "watch", "tar", "checksum-only", "json"},
+		check: func() error {
+			switch {
+			case !*deleteRemote && *recursive:
+				return fmt.Errorf("-recursive only applies to -delete-remote")
+			case !*deleteRemote:
+				return nil
+			case *planOnly || *verifyOnly || *syncMode || *watchMode || *tarMode || *checksumOnly != "" || *jsonOutput || cleanupRequested():
+				return fmt.Errorf("-delete-remote deletes paths on the server, so it cannot be combined with other modes or transfer options")
+			}
+			for _, path := range sourceArgs() {
+				if err := validateRemotePath(path); err != nil || path == StdinPath {
+					return fmt.Errorf("invalid remote path %q: must be a relative path on the server", path)
+				}
+			}
+			return nil
+		},
+		fix: "run -delete-remote on its own with paths relative to the server's destination directory, e.g. -delete-remote old/report.pdf",
+	},
+	{
+		flags: []string{"log-format"},
+		check: func() error {
+			if !protocol.ValidLogFormat(*logFormat) {
+				return fmt.Errorf("invalid log format %q", *logFormat)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use one of: %s, %s", protocol.LogFormatText, protocol.LogFormatJSON),
+	},
+	{
+		flags: []string{"log-level"},
+		check: func() error {
+			_, err := protocol.ParseLogLevel(*logLevel)
+			return err
+		},
+		fix: "use one of: debug, info, warn, error",
+	},
+}
+
+// stringListFlag is a `flag.Value` that collects the values of a repeatable flag.
+type stringListFlag []string
+
+// String returns the collected values as a comma-separated list.
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value each time the flag is given.
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 // toKB converts bytes to kilobytes.
 func toKB(bytes uint64) float64 {
 	return float64(bytes) / 1024
@@ -65,26 +610,148 @@ func toGB(bytes uint64) float64 {
 	return float64(bytes) / 1024 / 1024 / 1024
 }
 
-// setupLogging configures structured logging with timestamps and custom prefix.
+// progressMode returns the progress output mode selected by the -progress and -quiet flags.
+func progressMode() string {
+	if *quiet {
+		return protocol.ProgressModeNone
+	}
+	return *progress
+}
+
+// compression returns the compression value of the -compress flag (validated at startup).
+func compression() uint8 {
+	value, _ := protocol.ParseCompression(*compress)
+	return value
+}
+
+// setupLogging configures structured logging in the format and at the level of the "-log-format" and "-log-level" flags.
 func setupLogging() {
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
-	log.SetPrefix(LogPrefix + " ")
+	level, err := protocol.ParseLogLevel(*logLevel)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	protocol.SetupLogging(os.Stderr, *logFormat, level, LogPrefix)
 }
 
-// validateArgs validates command-line arguments.
+// fatal logs the message and its attributes as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// validateArgs validates command-line arguments against every flag rule and reports all violations at once.
 func validateArgs() error {
-	if *filePath == "" {
-		return fmt.Errorf("file path is required: use -file flag to specify the source file")
+	var violations []string
+	for _, rule := range flagRules {
+		if err := rule.check(); err != nil {
+			violations = append(violations, fmt.Sprintf("-%s: %v (fix: %s)", strings.Join(rule.flags, ", -"), err, rule.fix))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%d invalid flag(s):\n  - %s", len(violations), strings.Join(violations, "\n  - "))
 	}
 
 	return nil
 }
 
+// parseServerAddress parses a server address into the "host:port" form to dial.
+// An IPv6 address with a port must be bracketed (e.g. "[::1]:8080"). An address without a port
+// (a host name, an IPv4 address, or a bare or bracketed IPv6 address) gets the default port.
+func parseServerAddress(addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		// Without a port, the whole address is the host.
+		host = addr
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+		if strings.ContainsAny(host, "[]") || strings.Contains(host, ":") && !isIPAddress(host) {
+			return "", fmt.Errorf("invalid server address %q: %v", addr, err)
+		}
+		port = DefaultServerPort
+	}
+
+	if host == "" {
+		return "", fmt.Errorf("invalid server address %q: missing host", addr)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return "", fmt.Errorf("invalid port %q in the server address %q", port, addr)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// isIPAddress reports whether the host is an IPv4 or IPv6 address, with an optional IPv6 zone (e.g. "fe80::1%eth0").
+func isIPAddress(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// validateRemotePath checks a path on the server, which must be relative to its destination directory
+// and stay within it (no ".." components), with names the server accepts.
+func validateRemotePath(path string) error {
+	if filepath.IsAbs(path) || slices.Contains(strings.Split(filepath.ToSlash(path), "/"), "..") {
+		return fmt.Errorf("must be relative to the server's destination directory")
+	}
+	return protocol.ValidatePathComponents(path, protocol.MaxPathComponentLength)
+}
+
+// sourceArgs returns the source paths given on the command line: the `-file` flag followed by the positional arguments.
+func sourceArgs() []string {
+	var args []string
+	if *filePath != "" {
+		args = append(args, *filePath)
+	}
+	return append(args, flag.Args()...)
+}
+
+// A sourcePath is a source path to be transferred, or the error that prevented expanding its argument.
+type sourcePath struct {
+	path string // Path of the file or directory.
+	err  error  // Error of the argument the path was expanded from, if any.
+}
+
+// expandSourcePaths expands the shell-style glob patterns among the source arguments, in order.
+// Patterns are expanded by the client so that quoted patterns behave the same on every platform (e.g. on Windows,
+// where the shell does not expand them). An argument naming an existing path is taken literally even if it contains
+// glob characters, and an argument that cannot be expanded is returned with its error instead of aborting the others.
+func expandSourcePaths(args []string) []sourcePath {
+	var sources []sourcePath
+	for _, arg := range args {
+		if _, err := os.Lstat(arg); err == nil || !strings.ContainsAny(arg, "*?[") {
+			sources = append(sources, sourcePath{path: arg})
+			continue
+		}
+
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			sources = append(sources, sourcePath{path: arg, err: fmt.Errorf("invalid glob pattern %s: %v", arg, err)})
+			continue
+		}
+		if len(matches) == 0 {
+			sources = append(sources, sourcePath{path: arg, err: fmt.Errorf("%w: no paths match %s", ErrFileNotFound, arg)})
+			continue
+		}
+		for _, match := range matches {
+			sources = append(sources, sourcePath{path: match})
+		}
+	}
+	return sources
+}
+
 // validatePath performs validation on the provided file or directory path before a transfer.
+// Names with control characters are rejected, since the server would refuse them (or store them under sanitized names).
 func validatePath(path string) error {
 	if path == "" {
 		return fmt.Errorf("%w: path cannot be empty", ErrInvalidFilename)
 	}
+	if err := protocol.ValidatePathComponents(path, protocol.MaxPathComponentLength); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidFilename, err)
+	}
+	if err := protocol.ValidatePathCharacters(path); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidFilename, err)
+	}
 
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -107,28 +774,184 @@ func validatePath(path string) error {
 
 // readServerResponse reads and processes the server's response after a file transfer.
 func readServerResponse(conn net.Conn) error {
+	_, err := readServerResponseMessage(conn)
+	return err
+}
+
+// readServerResponseMessage reads and processes the server's response after a file transfer and returns its message,
+// which is also returned along with the error of an error response.
+func readServerResponseMessage(conn net.Conn) (string, error) {
+	message, _, err := readServerResponseReceipt(conn)
+	return message, err
+}
+
+// readServerResponseReceipt reads and processes the server's response like `readServerResponseMessage`,
+// and also returns the receipt of the stored file, if the response has one (see `protocol.Receipt`).
+func readServerResponseReceipt(conn net.Conn) (string, *protocol.Receipt, error) {
 	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
-		return fmt.Errorf("failed to set a read deadline: %w", err)
+		return "", nil, fmt.Errorf("failed to set a read deadline: %w", err)
 	}
 
-	status, message, err := protocol.ReadResponse(conn)
+	status, code, message, receipt, err := protocol.ReadReceiptResponse(conn)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
-			return fmt.Errorf("server closed connection unexpectedly")
+			return "", nil, fmt.Errorf("server closed connection unexpectedly: %w", ErrConnectionLost)
+		}
+		return "", nil, fmt.Errorf("failed to read the server response: %w", err)
+	}
+
+	if status != protocol.ResponseStatusSuccess {
+		return message, nil, responseError(status, code, message)
+	}
+
+	return message, receipt, nil
+}
+
+// A transferResponse is the server's response to a transfer.
+type transferResponse struct {
+	message    string            // Message of the response.
+	receipt    *protocol.Receipt // Receipt of the stored file, or nil (e.g. from a server predating receipts).
+	storedName string            // Path the receipt names, if it differs from the one requested by the header of the transfer.
+}
+
+// readTransferResponse reads the server's response to the transfer of the header (see `readServerResponseReceipt`),
+// which is also returned along with the error of an error response.
+func readTransferResponse(conn net.Conn, header *protocol.Header) (transferResponse, error) {
+	message, receipt, err := readServerResponseReceipt(conn)
+	return newTransferResponse(header, message, receipt), err
+}
+
+// newTransferResponse returns the response to the transfer of the header with the message and the receipt.
+// The file is stored under another path than requested if, for instance, the server renamed it with its "rename"
+// strategy or "-sanitize-names", or placed it under its "-dest-template". The receipt of an archive names the directory
+// its entries were extracted into, which is requested by the directory path of its header alone.
+func newTransferResponse(header *protocol.Header, message string, receipt *protocol.Receipt) transferResponse {
+	response := transferResponse{message: message, receipt: receipt}
+	requested := path.Join(filepath.ToSlash(header.DirectoryPath), filepath.ToSlash(header.FileName))
+	if header.TransferType == protocol.TransferTypeTarArchive {
+		requested = path.Clean(filepath.ToSlash(header.DirectoryPath))
+	}
+	if receipt != nil && receipt.Path != requested {
+		response.storedName = receipt.Path
+	}
+	return response
+}
+
+// responseError returns the error of a response that is not successful, given its status, error code, and message:
+// `ErrServerShutdown` if the server refused the request because it is shutting down, `ErrServerSkipped` for a file
+// the server already has and skips, and otherwise the error of the code (see `responseCodeErrors`) with the message.
+func responseError(status uint8, code uint16, message string) error {
+	switch {
+	case status == protocol.ResponseStatusRetryLater || code == protocol.ErrorCodeShuttingDown:
+		return ErrServerShutdown
+	case status == protocol.ResponseStatusSkipped || code == protocol.ErrorCodeConflictSkip:
+		return fmt.Errorf("%w: %s", ErrServerSkipped, message)
+	}
+	if err, ok := responseCodeErrors[code]; ok {
+		return fmt.Errorf("%w: server error: %s", err, message)
+	}
+	return fmt.Errorf("server error: %s", message)
+}
+
+// retryable reports whether a transfer that failed with the error may succeed if sent again unchanged:
+// not a file the server skips, or one it refuses for its size, its name, or the permissions of the client.
+func retryable(err error) bool {
+	for _, permanent := range []error{ErrServerSkipped, ErrFileTooLarge, ErrInvalidFilename, ErrAuthFailed} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	return true
+}
+
+// connectionLost reports whether a transfer failed with the error because its connection was lost
+// (e.g. closed or reset by a server that restarted), so that the transfer may succeed on a new connection.
+func connectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, lost := range []error{ErrConnectionLost, io.EOF, io.ErrUnexpectedEOF, net.ErrClosed, syscall.EPIPE, syscall.ECONNRESET} {
+		if errors.Is(err, lost) {
+			return true
 		}
-		return fmt.Errorf("failed to read the server response: %w", err)
 	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// An unsentError is the error of a transfer that failed before the server could have stored the file:
+// while it was queried for, or while its header or content was being sent.
+type unsentError struct {
+	err error
+}
+
+// Error returns the message of the underlying error.
+func (e *unsentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *unsentError) Unwrap() error {
+	return e.err
+}
+
+// resendable reports whether a transfer that failed with the error can be sent again on a new connection:
+// its connection was lost before the server could have stored the file, and not by a timeout, after which
+// the server may still be receiving it. A file lost while waiting for the response may be stored already,
+// so sending it again could store it twice (e.g. as "name_1" with the "rename" strategy).
+func resendable(err error) bool {
+	var unsent *unsentError
+	if !errors.As(err, &unsent) || !connectionLost(err) {
+		return false
+	}
+	var netErr net.Error
+	return !errors.As(err, &netErr) || !netErr.Timeout()
+}
 
-	if status == protocol.ResponseStatusError {
-		return fmt.Errorf("server error: %s", message)
+// reconnect closes the lost connection of a directory transfer and dials the server again after `ReconnectBackoff`,
+// doubled for each previous attempt, which leaves a restarting server the time to listen again.
+func reconnect(ctx context.Context, conn net.Conn, attempt int) (net.Conn, error) {
+	_ = conn.Close()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(ReconnectBackoff << (attempt - 1)):
 	}
+	return dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+}
 
-	if message != "" {
-		log.Printf("Server response: %s", message)
+// checkResponseChecksum compares the checksum confirmed by the server's response to a stored transfer
+// (or verified by a server in "-discard" mode) with the checksum of the content sent, and returns `protocol.ErrChecksumMismatch`
+// if they differ. The checksum is that of the receipt, or that of the message from a server predating receipts.
+// A response confirming no checksum (e.g. from an older server) is accepted.
+func checkResponseChecksum(response transferResponse, checksum []byte) error {
+	if response.receipt != nil {
+		if !bytes.Equal(response.receipt.Checksum, checksum) {
+			return fmt.Errorf("%w: the server stored the checksum %x instead of %x", protocol.ErrChecksumMismatch, response.receipt.Checksum, checksum)
+		}
+		return nil
+	}
+	confirmed, ok := protocol.ParseTransferReceivedChecksum(response.message)
+	if !ok {
+		confirmed, ok = protocol.ParseTransferDiscardedChecksum(response.message)
+	}
+	if ok && !bytes.Equal(confirmed, checksum) {
+		return fmt.Errorf("%w: the server received the checksum %x instead of %x", protocol.ErrChecksumMismatch, confirmed, checksum)
 	}
 	return nil
 }
 
+// closeOnTimeout closes the connection once the -timeout deadline of the context passes, so that an operation blocked
+// reading or writing (e.g. waiting for a slow server) is cancelled as well. A shutdown signal cancels the context without
+// closing the connection, which lets the transfer in progress finish. The returned function stops watching the context.
+func closeOnTimeout(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			_ = conn.Close()
+		}
+	})
+}
+
 // contextWriter is a writer that supports context cancellation and coordination of the transfer with shutdown.
 type contextWriter struct {
 	ctx  context.Context
@@ -154,46 +977,92 @@ func (cw *contextWriter) Write(p []byte) (n int, err error) {
 	return cw.conn.Write(p)
 }
 
-// transferFile transfers a single file.
-func transferFile(ctx context.Context, conn net.Conn, filePath string, relPath ...string) error {
+// tcpContextWriter is a `contextWriter` of a plain TCP connection, whose `ReadFrom` lets the connection copy a file
+// in the kernel (sendfile on Linux) instead of through a buffer in userspace.
+type tcpContextWriter struct {
+	contextWriter
+	tcpConn *net.TCPConn
+}
+
+// ReadFrom implements the `io.ReaderFrom` interface with context awareness. A progress reader hands it a file by chunks
+// (see `protocol.ProgressReader.WriteTo`), so that the context and the write deadline are still checked between them.
+func (tw *tcpContextWriter) ReadFrom(r io.Reader) (int64, error) {
+	if err := tw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := tw.conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return 0, err
+	}
+	return tw.tcpConn.ReadFrom(r)
+}
+
+// newContextWriter returns the writer of the content of a transfer to the connection: a `tcpContextWriter` for a plain TCP
+// connection, or a `contextWriter` for any other (e.g. a TLS connection, whose content must be encrypted in userspace).
+func newContextWriter(ctx context.Context, conn net.Conn) io.Writer {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		return &tcpContextWriter{contextWriter: contextWriter{ctx: ctx, conn: conn}, tcpConn: tcpConn}
+	}
+	return &contextWriter{ctx: ctx, conn: conn}
+}
+
+// transferFile transfers a single file and returns its checksum and the server's response
+// (which is also returned along with the error if the server rejects the file).
+// With -sync, the server is asked first, and `ErrFileUnchanged` is returned (with the checksum) if it has the file already.
+// A non-empty `relPath` marks the file as part of a directory transfer, whose overall progress is tracked by `aggregate` (if non-nil).
+// The checksum computed ahead by a `checksumPipeline` (if `hashed` is non-nil) is used unless the file changed since.
+// The messages about the file are logged with the `logger` of the transfer.
+func transferFile(ctx context.Context, logger *slog.Logger, conn net.Conn, filePath, relPath string, aggregate *protocol.AggregateProgress, hashed *hashedFile) ([]byte, transferResponse, error) {
+	fileName := filepath.Base(filePath)
+	// If there exists a relative path, meaning that the file is a subfile of a directory,
+	// use the relative path instead of the file name.
+	if relPath != "" {
+		fileName = relPath
+	}
+	// The names of the files in a directory are not checked by `validatePath`.
+	if err := protocol.ValidatePathCharacters(fileName); err != nil {
+		return nil, transferResponse{}, fmt.Errorf("%w: %w", ErrInvalidFilename, err)
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %v", filePath, err)
+		return nil, transferResponse{}, fmt.Errorf("failed to open file %s: %v", filePath, err)
 	}
 
 	defer func() {
 		if err := file.Close(); err != nil {
-			log.Printf("Error closing file %s: %v", filePath, err)
+			logger.Warn("Error closing the file", "path", filePath, "error", err)
 		}
 	}()
 
 	statInfo, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to get file information for %s: %v", filePath, err)
+		return nil, transferResponse{}, fmt.Errorf("failed to get file information for %s: %v", filePath, err)
 	}
-
-	fmt.Printf("Calculating the file checksum...\n")
-	checksum, err := protocol.CalculateFileChecksum(file)
-	if err != nil {
-		return fmt.Errorf("failed to calculate the file checksum: %v", err)
+	// A directory opens like a file, but its content cannot be read.
+	if statInfo.IsDir() {
+		return nil, transferResponse{}, fmt.Errorf("%w: %s", ErrIsDirectory, filePath)
 	}
-	fmt.Printf("File checksum: %x\n", checksum)
 
-	// Reset the file position to the beginning for the transfer.
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to reset file position: %v", err)
-	}
+	checksum := hashed.checksumFor(statInfo.Size(), statInfo.ModTime())
+	// A file the server asked for in its reply to a manifest need not be queried, unless it changed since it was hashed.
+	wanted := checksum != nil && hashed.wanted
+	if checksum == nil {
+		fmt.Fprintf(statusOutput, "Calculating the file checksum...\n")
+		checksum, err = fileChecksum(ctx, filePath, statInfo, file)
+		if err != nil {
+			return nil, transferResponse{}, fmt.Errorf("failed to calculate the file checksum: %v", err)
+		}
 
-	fileName := filepath.Base(filePath)
-	// If there exists at least one relative path, meaning that the file is a subfile of a directory,
-	// use the relative path instead of the file name.
-	if len(relPath) > 0 {
-		fileName = relPath[0]
+		// Reset the file position to the beginning for the transfer.
+		if _, err := file.Seek(0, 0); err != nil {
+			return nil, transferResponse{}, fmt.Errorf("failed to reset file position: %v", err)
+		}
 	}
+	fmt.Fprintf(statusOutput, "File checksum: %x\n", checksum)
 
 	// Determine the transfer type: if this is part of a directory transfer (`relPath` provided), use `TransferTypeDirectory`.
 	transferType := uint8(protocol.TransferTypeFile)
-	if len(relPath) > 0 {
+	if relPath != "" {
 		transferType = uint8(protocol.TransferTypeDirectory)
 	}
 	header := &protocol.Header{
@@ -202,27 +1071,64 @@ func transferFile(ctx context.Context, conn net.Conn, filePath string, relPath .
 		FileName:      fileName,                     // Use relative path if provided.
 		Checksum:      checksum,                     // File checksum.
 		TransferType:  transferType,                 // Transfer type.
-		DirectoryPath: "",                           // Not used for single file transfer.
+		DirectoryPath: *remoteDir,                   // Destination subdirectory on the server (-remote-dir).
+		Compression:   compression(),                // Compression of the content in transit.
+	}
+	logger = logger.With("file_name", header.FileName)
+
+	if *syncMode && !wanted {
+		unchanged, response, err := queryServer(conn, header)
+		if err != nil {
+			return nil, transferResponse{message: response}, &unsentError{fmt.Errorf("failed to query the server for %s: %w", header.FileName, err)}
+		}
+		if unchanged {
+			fmt.Fprintf(statusOutput, "Skipping unchanged file: %s (%d bytes)\n", header.FileName, header.FileSize)
+			return checksum, transferResponse{message: response}, ErrFileUnchanged
+		}
+		fmt.Fprintf(statusOutput, "Server does not have the file (%s), uploading it\n", response)
+	}
+
+	if *xattrs {
+		header.Xattrs = fileXattrs(logger, filePath, header)
+	}
+	if *preserveTimes {
+		header.ModTime = statInfo.ModTime()
+	}
+	if *checksumBlock > 0 {
+		if err := setBlockChecksums(ctx, logger, file, header); err != nil {
+			return nil, transferResponse{}, err
+		}
 	}
 
-	fmt.Printf("Starting file transfer: %s (%d bytes)\n", header.FileName, header.FileSize)
+	fmt.Fprintf(statusOutput, "Starting file transfer: %s (%d bytes)\n", header.FileName, header.FileSize)
 
-	fmt.Printf("Sending file header...\n")
+	fmt.Fprintf(statusOutput, "Sending file header...\n")
 	if err := protocol.WriteHeader(conn, header); err != nil {
-		return fmt.Errorf("failed to send file transfer header: %v", err)
+		return nil, transferResponse{}, &unsentError{fmt.Errorf("failed to send file transfer header: %w", err)}
 	}
-	fmt.Printf("Header sent successfully. Starting file transfer...\n")
+	fmt.Fprintf(statusOutput, "Header sent successfully. Starting file transfer...\n")
 
 	startTime := time.Now()
 
-	// Create a progress reader to track the transfer progress.
-	progressReader := protocol.NewProgressReader(file, header.FileSize, "Uploading", os.Stderr)
+	// `io.CopyBuffer` hands the copy to the progress reader, which copies with the buffer unless it has a faster way:
+	// the file is sent to a plain TCP connection with sendfile, unless it is compressed (see `newContextWriter`).
+	// The buffer returns to the pool once the copy ends, which may be after this function returns on a shutdown.
+	pooledBuffer := protocol.CopyBuffers.Get(*bufferSize)
+	transferBuffer := *pooledBuffer
+	progressOptions := []protocol.ProgressOption{protocol.WithCopyBuffer(transferBuffer)}
+
+	// Create a progress reader to track the transfer progress. The file of a directory transfer feeds the overall progress
+	// instead of displaying its own, which would be drowned out by those of many small files.
+	mode := progressMode()
+	if aggregate != nil {
+		progressOptions = append(progressOptions, protocol.WithAggregate(aggregate))
+		mode = protocol.ProgressModeNone
+	}
+	progressReader := protocol.NewProgressReader(file, header.FileSize, fmt.Sprintf("Uploading %s", header.FileName), os.Stderr, mode,
+		progressOptions...)
 
 	// Create a context-aware writer that can be interrupted during shutdown.
-	ctxWriter := &contextWriter{
-		ctx:  ctx,
-		conn: conn,
-	}
+	ctxWriter := newContextWriter(ctx, conn)
 
 	// Use a `WaitGroup` to coordinate the transfer with shutdown.
 	var transferWg sync.WaitGroup
@@ -231,11 +1137,25 @@ func transferFile(ctx context.Context, conn net.Conn, filePath string, relPath .
 	var bytesWritten int64
 	var transferErr error
 
+	// Compress the content on its way to the connection if requested. The header's checksum still covers the uncompressed content.
+	var destination io.Writer = ctxWriter
+	var compressedWriter *protocol.CompressedWriter
+	if header.Compression != protocol.CompressionNone {
+		compressedWriter, err = protocol.NewCompressedWriter(ctxWriter, header.Compression)
+		if err != nil {
+			return nil, transferResponse{}, fmt.Errorf("failed to start compressing the file content: %v", err)
+		}
+		destination = compressedWriter
+	}
+
 	// Start the file transfer in a separate goroutine.
 	go func() {
 		defer transferWg.Done()
-		transferBuffer := make([]byte, TransferBufferSize)
-		bytesWritten, transferErr = io.CopyBuffer(ctxWriter, progressReader, transferBuffer)
+		defer protocol.CopyBuffers.Put(pooledBuffer)
+		bytesWritten, transferErr = io.CopyBuffer(destination, progressReader, transferBuffer)
+		if transferErr == nil && compressedWriter != nil {
+			transferErr = compressedWriter.Close()
+		}
 	}()
 
 	// Wait for the transfer to complete or for a shutdown signal.
@@ -249,29 +1169,43 @@ func transferFile(ctx context.Context, conn net.Conn, filePath string, relPath .
 	case <-transferDoneChan:
 		// Transfer completed: do nothing.
 	case <-ctx.Done():
-		log.Printf("Transfer interrupted due to a shutdown signal")
+		logger.Warn("Transfer interrupted due to a shutdown signal")
 		// Wait for a while for the transfer to finish gracefully.
 		select {
 		case <-transferDoneChan:
-			log.Printf("Transfer completed after a shutdown signal")
+			logger.Info("Transfer completed after a shutdown signal")
 		case <-time.After(ShutdownTimeout):
-			log.Printf("Transfer did not complete within the shutdown timeout")
+			logger.Warn("Transfer did not complete within the shutdown timeout")
 		}
 	}
 
 	progressReader.Complete()
 
 	if transferErr != nil {
-		return fmt.Errorf("failed to send file content: %v", transferErr)
+		return nil, transferResponse{}, &unsentError{fmt.Errorf("failed to send file content: %w", transferErr)}
 	}
 
 	if bytesWritten != int64(header.FileSize) {
-		return fmt.Errorf("file transfer incomplete: expected %d bytes, sent %d bytes",
+		return nil, transferResponse{}, fmt.Errorf("file transfer incomplete: expected %d bytes, sent %d bytes",
 			header.FileSize, bytesWritten)
 	}
 
-	if err := readServerResponse(conn); err != nil {
-		return fmt.Errorf("failed to read server response: %v", err)
+	if compressedWriter != nil && bytesWritten > 0 {
+		logger.Info("Compressed the file content", "bytes", bytesWritten, "compressed_bytes", compressedWriter.CompressedBytes(),
+			"ratio_percent", float64(compressedWriter.CompressedBytes())/float64(bytesWritten)*100, "compression", *compress)
+	}
+
+	response, err := readTransferResponse(conn, header)
+	if err != nil {
+		if index, ok := protocol.ParseBlockMismatch(response.message); ok && header.BlockSize != 0 {
+			offset := uint64(index) * uint64(header.BlockSize)
+			logger.Error("The server received a corrupted block", "block", index, "offset", offset,
+				"bytes", min(uint64(header.BlockSize), header.FileSize-min(offset, header.FileSize)))
+		}
+		return nil, response, fmt.Errorf("failed to read server response: %w", err)
+	}
+	if err := checkResponseChecksum(response, checksum); err != nil {
+		return nil, response, err
 	}
 
 	transferDuration := time.Since(startTime)
@@ -283,20 +1217,74 @@ func transferFile(ctx context.Context, conn net.Conn, filePath string, relPath .
 		transferRate = 0
 	}
 
-	if bytesWritten < 1024 {
-		log.Printf("File sent successfully! %d bytes sent in %v",
-			bytesWritten, transferDuration)
-	} else if bytesWritten < 1024*1024 {
-		log.Printf("File sent successfully! %.1f KB sent in %v (%.2f MB/s)",
-			toKB(uint64(bytesWritten)), transferDuration, transferRate)
-	} else {
-		log.Printf("File sent successfully! %.1f MB sent in %v (%.2f MB/s)",
-			toMB(uint64(bytesWritten)), transferDuration, transferRate)
+	logger.Info("File sent successfully!", "bytes", bytesWritten, "duration_ms", transferDuration.Milliseconds(),
+		"rate_mb_s", transferRate, "response", response.message)
+	if response.storedName != "" {
+		logger.Info("The server stored the file under another name", "stored_name", response.storedName)
 	}
 
+	return checksum, response, nil
+}
+
+// setBlockChecksums sets the block checksums of the transfer header (-checksum-block), reading the file from
+// its current position and seeking back to it, so that the server verifies each block as it arrives.
+// A file with too many blocks of the requested size is split into larger ones (see `protocol.FitBlockSize`).
+func setBlockChecksums(ctx context.Context, logger *slog.Logger, file *os.File, header *protocol.Header) error {
+	blockSize := protocol.FitBlockSize(header.FileSize, uint32(*checksumBlock))
+	if blockSize == 0 {
+		logger.Warn("The file is too large for block checksums, sending it without them", "bytes", header.FileSize)
+		return nil
+	}
+	if blockSize != uint32(*checksumBlock) {
+		logger.Debug("Using larger blocks to fit the block checksums in the header", "block_size", blockSize)
+	}
+
+	position, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to get the file position: %v", err)
+	}
+	checksums, err := protocol.CalculateBlockChecksums(ctx, file, blockSize)
+	if err != nil {
+		return fmt.Errorf("failed to calculate the block checksums: %v", err)
+	}
+	if _, err := file.Seek(position, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset file position: %v", err)
+	}
+	header.BlockSize, header.BlockChecksums = blockSize, checksums
 	return nil
 }
 
+// queryServer asks the server whether it already has the file described by the transfer header
+// (the same name, size, and checksum) without sending its content.
+// It returns whether the file is unchanged on the server, and the message of the server's response.
+func queryServer(conn net.Conn, transferHeader *protocol.Header) (bool, string, error) {
+	query := *transferHeader
+	query.MessageType = protocol.MessageTypeQuery
+	query.Compression = protocol.CompressionNone
+	query.Xattrs = nil
+
+	if err := conn.SetDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return false, "", fmt.Errorf("failed to set deadline: %v", err)
+	}
+	if err := protocol.WriteHeader(conn, &query); err != nil {
+		return false, "", fmt.Errorf("failed to send the query header: %w", err)
+	}
+	status, code, message, err := protocol.ReadCodedResponse(conn)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to read the query response: %w", err)
+	}
+
+	switch {
+	// Servers predating `protocol.ResponseStatusExists` answer with a success.
+	case status == protocol.ResponseStatusExists, status == protocol.ResponseStatusSuccess:
+		return true, message, nil
+	case message == protocol.VerifyMessageMismatch, message == protocol.VerifyMessageNotFound, message == protocol.QueryMessageTooLarge:
+		return false, message, nil
+	default:
+		return false, message, responseError(status, code, message)
+	}
+}
+
 // validateDirectorySize validates the total size of the directory with the server before starting the transfer.
 func validateDirectorySize(totalSize int64) error {
 	// Create a connection to validate directory size.
@@ -306,7 +1294,7 @@ func validateDirectorySize(totalSize int64) error {
 	}
 	defer func() {
 		if err := conn.Close(); err != nil {
-			log.Printf("Error closing validation connection: %v", err)
+			slog.Warn("Error closing the validation connection", "error", err)
 		}
 	}()
 
@@ -332,123 +1320,1011 @@ func validateDirectorySize(totalSize int64) error {
 	}
 
 	if err := readServerResponse(conn); err != nil {
-		return fmt.Errorf("directory size validation failed: %v", err)
+		return fmt.Errorf("directory size validation failed: %w", err)
 	}
 
-	log.Printf("Directory size validation successful: %.2f GB", toGB(uint64(totalSize)))
+	slog.Info("Directory size validation successful", "bytes", totalSize)
 	return nil
 }
 
-// transferDirectory transfers a directory.
-func transferDirectory(ctx context.Context, dirPath string) error {
-	var allFiles []string
-	var totalDirectorySize int64
+// directoryListing holds the files of a directory selected for a transfer.
+type directoryListing struct {
+	files         []string // Paths of the files to be transferred.
+	dirs          []string // Paths of the directories walked (not pruned), parents before their subdirectories.
+	totalSize     int64    // Total size of the files to be transferred in bytes.
+	filteredFiles int      // Number of files left out by the filter.
+	filteredDirs  int      // Number of directories pruned (without walking them) by the filter.
+	tooLarge      []string // Paths of the files skipped because they exceed `MaxFileSize`.
+	largestFile   string   // Path of the largest file to be transferred (empty if there is none).
+	largestSize   int64    // Size of the largest file to be transferred in bytes.
+}
 
-	// Walk the directory and add all files to the list, calculating the total size.
-	err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			allFiles = append(allFiles, path)
-			totalDirectorySize += info.Size()
-		}
-		return nil
-	})
-	if err != nil {
-		return fmt.Errorf("failed to walk the directory %s: %v", dirPath, err)
+// planDirectory plans the transfer of the directory using the same options as the actual transfer,
+// so that the validated total size always matches what is sent.
+// The checksums (if computed) are taken from the "-checksum-cache" for unchanged files.
+func planDirectory(dirPath string, filter *protocol.PathFilter, computeChecksums bool) (*protocol.TransferPlan, error) {
+	opts := protocol.DirectoryTransferOptions{
+		Filter:           filter,
+		MaxFileSize:      MaxFileSize,
+		ComputeChecksums: computeChecksums,
+		IgnoreFile:       !*noIgnoreFile,
+		FollowSymlinks:   *followLinks,
 	}
-
-	log.Printf("Found %d files to transfer in the directory %s (total size: %.2f GB)",
-		len(allFiles), dirPath, toGB(uint64(totalDirectorySize)))
-
-	if err := validateDirectorySize(totalDirectorySize); err != nil {
-		return fmt.Errorf("directory transfer rejected: %v", err)
+	if checksums != nil {
+		opts.Checksum = func(name string, info fs.FileInfo) ([]byte, error) {
+			path := filepath.Join(dirPath, filepath.FromSlash(name))
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer func() {
+				_ = file.Close()
+			}()
+			return fileChecksum(context.Background(), path, info, file)
+		}
 	}
+	return protocol.PlanDirectoryTransfer(os.DirFS(dirPath), ".", opts)
+}
 
-	var successfulTransfers, failedTransfers int
-	var totalBytesTransferred int64
-
-	log.Printf("Establishing a persistent connection for the directory transfer...")
-	fileConn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+// listDirectoryFiles collects the files of the directory to be transferred, applying the path filter.
+// Files exceeding `MaxFileSize` are skipped (and recorded) up front so that the rest of the directory can still be transferred.
+func listDirectoryFiles(dirPath string, filter *protocol.PathFilter) (*directoryListing, error) {
+	plan, err := planDirectory(dirPath, filter, false)
 	if err != nil {
-		return fmt.Errorf("failed to establish the connection for the directory transfer: %v", err)
+		return nil, err
 	}
 
-	defer func() {
-		if err := fileConn.Close(); err != nil {
-			log.Printf("Error closing the directory transfer connection: %v", err)
+	listing := &directoryListing{
+		totalSize:     plan.Stats.TotalBytes,
+		filteredFiles: plan.Stats.ExcludedFiles,
+		filteredDirs:  plan.Stats.PrunedDirs,
+	}
+	for _, file := range plan.Files {
+		path := filepath.Join(dirPath, filepath.FromSlash(file.Path))
+		listing.files = append(listing.files, path)
+		if listing.largestFile == "" || file.Size > listing.largestSize {
+			listing.largestFile, listing.largestSize = path, file.Size
 		}
-		log.Printf("Directory transfer connection closed")
-	}()
+	}
+	for _, dir := range plan.Dirs {
+		listing.dirs = append(listing.dirs, filepath.Join(dirPath, filepath.FromSlash(dir)))
+	}
+	for _, file := range plan.TooLarge {
+		path := filepath.Join(dirPath, filepath.FromSlash(file.Path))
+		slog.Warn("Skipping a file too large to transfer", "file_name", path, "bytes", file.Size, "max_bytes", MaxFileSize,
+			"error", ErrFileTooLarge)
+		listing.tooLarge = append(listing.tooLarge, path)
+	}
+	for _, link := range plan.Symlinks {
+		path := filepath.Join(dirPath, filepath.FromSlash(link.Path))
+		if link.Loop {
+			slog.Warn("Skipping a symbolic link that loops back to a parent directory", "file_name", path, "target", link.Target)
+			continue
+		}
+		slog.Info("Skipping a symbolic link", "file_name", path, "target", link.Target, "follow_symlinks", *followLinks)
+	}
+
+	return listing, nil
+}
+
+// printDirectoryPlan prints the transfer plan of the directory as JSON to stdout.
+func printDirectoryPlan(dirPath string, filter *protocol.PathFilter) error {
+	plan, err := planDirectory(dirPath, filter, *planChecksums)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// Statuses of a file in a transfer report.
+const (
+	FileStatusSent    = "sent"    // The file was sent and stored by the server successfully.
+	FileStatusFailed  = "failed"  // The file failed to transfer.
+	FileStatusSkipped = "skipped" // The file was skipped because it exceeds `MaxFileSize` or is unchanged (-sync).
+)
+
+// A fileReport is the outcome of a single file of a transfer.
+type fileReport struct {
+	Name            string  `json:"name"`                      // Relative path of the file (or its base name for single file transfers).
+	Size            int64   `json:"size"`                      // Size of the file in bytes.
+	Status          string  `json:"status"`                    // One of the `FileStatus` constants.
+	DurationMs      int64   `json:"duration_ms"`               // Time spent on the file (checksum and transfer) in milliseconds.
+	RateBytesPerSec float64 `json:"rate_bytes_per_sec"`        // Average transfer rate of a sent file in bytes per second.
+	Checksum        string  `json:"checksum,omitempty"`        // Hex-encoded SHA-256 checksum of the transferred file.
+	Error           string  `json:"error,omitempty"`           // Reason of the failure, if any.
+	ServerResponse  string  `json:"server_response,omitempty"` // Message of the server's response to the file, if any.
+	StoredName      string  `json:"stored_name,omitempty"`     // Path the server stored the file under, if it differs from `Name`.
+}
+
+// recordResponse records the message of the server's response to the file, and the name the server stored it under.
+func (r *fileReport) recordResponse(response transferResponse) {
+	r.ServerResponse = response.message
+	r.StoredName = response.storedName
+}
+
+// finish records the time spent on the file since `startTime` and, if the file was sent, its average transfer rate.
+func (r *fileReport) finish(startTime time.Time) {
+	duration := time.Since(startTime)
+	r.DurationMs = duration.Milliseconds()
+	if r.Status == FileStatusSent && duration > 0 {
+		r.RateBytesPerSec = float64(r.Size) / duration.Seconds()
+	}
+}
+
+// transferSummary summarizes the outcome of a file or directory transfer.
+type transferSummary struct {
+	successful    int           // Number of files transferred successfully.
+	failed        int           // Number of files that failed to transfer.
+	tooLarge      []string      // Paths of the files skipped because they exceed `MaxFileSize`.
+	unchanged     int           // Number of files skipped by -sync because the server already has them.
+	serverSkipped int           // Number of files skipped by the server because it already has a file of the same name.
+	bytesSaved    int64         // Total size of the files skipped by -sync.
+	cleanedUp     int           // Number of source files deleted or archived after the server confirmed them.
+	totalBytes    int64         // Total number of bytes transferred successfully.
+	filteredFiles int           // Number of files left out by the filter.
+	filteredDirs  int           // Number of directories pruned by the filter.
+	duration      time.Duration // Wall-clock duration of the transfer.
+	files         []fileReport  // Per-file outcomes in transfer order.
+	// Whether the server refused or interrupted a transfer because it is shutting down (`ErrServerShutdown`).
+	serverShutdown bool
+	// Whether the server refused a transfer because the client is not allowed to make it (`ErrAuthFailed`).
+	authFailed bool
+}
+
+// A transferReport is the JSON summary of a transfer printed with `-json`.
+type transferReport struct {
+	TotalFiles    int          `json:"total_files"`     // Number of files considered (transferred, failed, or skipped).
+	Successful    int          `json:"successful"`      // Number of files transferred successfully.
+	Failed        int          `json:"failed"`          // Number of files that failed to transfer.
+	Skipped       int          `json:"skipped"`         // Number of files skipped because they exceed the maximum file size or are unchanged.
+	Unchanged     int          `json:"unchanged"`       // Number of the skipped files that the server already has (-sync).
+	BytesSaved    int64        `json:"bytes_saved"`     // Total size of the unchanged files that were not uploaded.
+	CleanedUp     int          `json:"cleaned_up"`      // Number of source files deleted or archived (-delete-source, -archive-dir).
+	FilteredFiles int          `json:"filtered_files"`  // Number of files left out by the filter.
+	FilteredDirs  int          `json:"filtered_dirs"`   // Number of directories pruned by the filter.
+	TotalBytes    int64        `json:"total_bytes"`     // Total number of bytes transferred successfully.
+	DurationMs    int64        `json:"duration_ms"`     // Wall-clock duration of the transfer in milliseconds.
+	Files         []fileReport `json:"files"`           // Per-file outcomes in transfer order.
+	Error         string       `json:"error,omitempty"` // Error that failed the transfer as a whole, if any.
+}
+
+// newTransferReport builds the JSON report of a transfer from its summary and final error.
+func newTransferReport(summary *transferSummary, transferErr error) *transferReport {
+	report := &transferReport{
+		Successful:    summary.successful,
+		Failed:        summary.failed,
+		Skipped:       len(summary.tooLarge) + summary.unchanged + summary.serverSkipped,
+		Unchanged:     summary.unchanged,
+		BytesSaved:    summary.bytesSaved,
+		CleanedUp:     summary.cleanedUp,
+		FilteredFiles: summary.filteredFiles,
+		FilteredDirs:  summary.filteredDirs,
+		TotalBytes:    summary.totalBytes,
+		DurationMs:    summary.duration.Milliseconds(),
+		Files:         summary.files,
+	}
+	report.TotalFiles = report.Successful + report.Failed + report.Skipped
+	if report.Files == nil {
+		report.Files = []fileReport{}
+	}
+	if transferErr != nil {
+		report.Error = transferErr.Error()
+	}
+	return report
+}
+
+// printTransferReport prints the JSON report of a transfer to stdout.
+func printTransferReport(summary *transferSummary, transferErr error) error {
+	return json.NewEncoder(os.Stdout).Encode(newTransferReport(summary, transferErr))
+}
+
+// requireDirectory returns `ErrNotDirectory` if the path is not a directory, or `ErrFileNotFound` if it does not exist,
+// since walking a file would transfer it as a directory of its own.
+func requireDirectory(path string) error {
+	fileInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get the path information for %s: %v", path, err)
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("%w: %s", ErrNotDirectory, path)
+	}
+	return nil
+}
+
+// transferDirectory transfers a directory and returns a summary of the transfer (even if it fails part-way).
+func transferDirectory(ctx context.Context, dirPath string, filter *protocol.PathFilter) (*transferSummary, error) {
+	summary := &transferSummary{}
+	startTime := time.Now()
+	defer func() {
+		summary.duration = time.Since(startTime)
+	}()
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	if err := requireDirectory(dirPath); err != nil {
+		return summary, err
+	}
+
+	if *archiveDir != "" {
+		if err := validateArchiveDir(dirPath, *archiveDir); err != nil {
+			return summary, err
+		}
+	}
+
+	// Walk the directory and add all files to the list, calculating the total size.
+	listing, err := listDirectoryFiles(dirPath, filter)
+	if err != nil {
+		return summary, fmt.Errorf("failed to walk the directory %s: %v", dirPath, err)
+	}
+	summary.tooLarge = listing.tooLarge
+	for _, path := range listing.tooLarge {
+		report := fileReport{Name: path, Status: FileStatusSkipped}
+		if relPath, err := filepath.Rel(dirPath, path); err == nil {
+			report.Name = filepath.ToSlash(relPath)
+		}
+		if fileInfo, err := os.Stat(path); err == nil {
+			report.Size = fileInfo.Size()
+		}
+		summary.files = append(summary.files, report)
+	}
+	summary.filteredFiles = listing.filteredFiles
+	summary.filteredDirs = listing.filteredDirs
+	allFiles := listing.files
+	totalDirectorySize := listing.totalSize
+
+	logger.Info("Found the files to transfer in the directory", "dir", dirPath, "files", len(allFiles), "bytes", totalDirectorySize)
+
+	// The limits of the server are checked locally, falling back to a size validation for a server predating them.
+	info, err := fetchServerInfo()
+	if err == nil {
+		if err := checkDirectoryLimits(info, dirPath, listing); err != nil {
+			return summary, fmt.Errorf("directory transfer rejected: %w", err)
+		}
+	} else {
+		logger.Debug("Failed to get the server information, validating the directory size instead", "error", err)
+		if err := validateDirectorySize(totalDirectorySize); err != nil {
+			return summary, fmt.Errorf("directory transfer rejected: %w", err)
+		}
+	}
+
+	logger.Info("Establishing a persistent connection for the directory transfer...")
+	fileConn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return summary, fmt.Errorf("failed to establish the connection for the directory transfer: %v", err)
+	}
+
+	stopTimeout := closeOnTimeout(ctx, fileConn)
+	defer func() {
+		stopTimeout()
+		if err := fileConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warn("Error closing the directory transfer connection", "error", err)
+		}
+		logger.Info("Directory transfer connection closed")
+	}()
 
 	if err := fileConn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
-		return fmt.Errorf("failed to set read deadline: %v", err)
+		return summary, fmt.Errorf("failed to set read deadline: %v", err)
 	}
 	if err := fileConn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
-		return fmt.Errorf("failed to set write deadline: %v", err)
+		return summary, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+
+	logger.Info("Persistent connection established. Transferring the files on the same connection...", "files", len(allFiles))
+
+	// Track the overall progress across all files, in place of the per-file progress bars.
+	aggregate := protocol.NewAggregateProgress(uint64(totalDirectorySize), len(allFiles), "Directory", os.Stderr, progressMode())
+	defer aggregate.Complete()
+
+	// The next files are hashed while the current one is uploaded, or with -sync, all the files are hashed first
+	// to send their manifest to a server that accepts one, which replies with the files it needs.
+	useManifest := *syncMode && info != nil && info.Manifest && len(allFiles) <= protocol.MaxManifestEntries
+	lookahead := ChecksumLookahead
+	if useManifest {
+		lookahead = len(allFiles)
 	}
+	pipeline := startChecksumPipeline(ctx, allFiles, ChecksumWorkers, lookahead)
+	defer pipeline.stop()
 
-	log.Printf("Persistent connection established. Transferring %d files on the same connection...", len(allFiles))
+	var hashes []hashedFile
+	var unchanged []bool
+	if useManifest {
+		hashes, unchanged, err = sendManifest(ctx, logger, fileConn, dirPath, allFiles, pipeline)
+		if err != nil {
+			if ctx.Err() != nil {
+				return summary, fmt.Errorf("directory transfer interrupted: %v", ctx.Err())
+			}
+			// The files are still synced, each queried on its own.
+			logger.Warn("Failed to exchange the manifest, querying the files one by one", "error", err)
+		}
+	}
 
 	// Transfer all files in the directory using the persistent connection.
 	for i, filePath := range allFiles {
 		// Check for a shutdown signal before each file transfer.
 		select {
 		case <-ctx.Done():
-			log.Printf("Directory transfer interrupted due to a shutdown signal")
-			return fmt.Errorf("directory transfer interrupted: %v", ctx.Err())
+			logger.Warn("Directory transfer interrupted due to a shutdown signal")
+			return summary, fmt.Errorf("directory transfer interrupted: %v", ctx.Err())
 		default:
 		}
+		var hashed hashedFile
+		if hashes != nil {
+			hashed = hashes[i]
+		} else {
+			hashed = pipeline.next(ctx, i)
+		}
+
+		report := fileReport{Name: filePath, Status: FileStatusFailed}
+		fileInfo, statErr := os.Stat(filePath)
+		if statErr == nil {
+			report.Size = fileInfo.Size()
+		}
 
 		// Refresh the connection timeouts for each file transfer.
 		if err := fileConn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
-			log.Printf("Failed to set read deadline for file %s: %v", filePath, err)
-			failedTransfers++
+			logger.Error("Failed to set the read deadline", "file_name", filePath, "error", err)
+			aggregate.FileFailed(uint64(report.Size))
+			summary.recordFailure(report, err)
 			continue
 		}
 		if err := fileConn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
-			log.Printf("Failed to set write deadline for file %s: %v", filePath, err)
-			failedTransfers++
+			logger.Error("Failed to set the write deadline", "file_name", filePath, "error", err)
+			aggregate.FileFailed(uint64(report.Size))
+			summary.recordFailure(report, err)
 			continue
 		}
 
 		relPath, err := filepath.Rel(dirPath, filePath)
 		if err != nil {
-			log.Printf("Failed to calculate the relative path for %s: %v", filePath, err)
-			failedTransfers++
+			logger.Error("Failed to calculate the relative path", "file_name", filePath, "error", err)
+			aggregate.FileFailed(uint64(report.Size))
+			summary.recordFailure(report, err)
 			continue
 		}
-		fmt.Printf("Transferring file %d/%d: %s\n", i+1, len(allFiles), relPath)
+		report.Name = filepath.ToSlash(relPath)
+		fmt.Fprintf(statusOutput, "Transferring file %d/%d: %s\n", i+1, len(allFiles), relPath)
 
 		// The `transferFile` function will then handle the file transfer with the relative path instead of the plain file name.
-		if err := transferFile(ctx, fileConn, filePath, relPath); err != nil {
-			log.Printf("Failed to transfer file %s: %v", filePath, err)
-			failedTransfers++
-			// If a connection error is encountered, break the loop, since the connection is likely dead.
-			if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "connection") {
-				log.Printf("Connection error detected, aborting remaining transfers")
+		fileStartTime := time.Now()
+		aggregate.StartFile(report.Name)
+		var checksum []byte
+		var response transferResponse
+		if unchanged != nil && unchanged[i] && statErr == nil && hashed.checksumFor(fileInfo.Size(), fileInfo.ModTime()) != nil {
+			// The server already has the file according to its reply to the manifest.
+			fmt.Fprintf(statusOutput, "Skipping unchanged file: %s (%d bytes)\n", relPath, fileInfo.Size())
+			checksum, response, err = hashed.checksum, transferResponse{message: protocol.VerifyMessageMatch}, ErrFileUnchanged
+		} else {
+			checksum, response, err = transferFile(ctx, logger, fileConn, filePath, relPath, aggregate, &hashed)
+			// A file interrupted by the loss of the connection before it was sent in full is sent again on a new one,
+			// a bounded number of times.
+			for attempt := 1; resendable(err) && ctx.Err() == nil && attempt <= MaxFileRetries; attempt++ {
+				logger.Warn("Lost the connection to the server, reconnecting to send the file again",
+					"file_name", relPath, "attempt", attempt, "error", err)
+				stopTimeout()
+				var conn net.Conn
+				if conn, err = reconnect(ctx, fileConn, attempt); err != nil {
+					continue
+				}
+				fileConn, stopTimeout = conn, closeOnTimeout(ctx, conn)
+				if err = fileConn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+					continue
+				}
+				aggregate.RestartFile()
+				checksum, response, err = transferFile(ctx, logger, fileConn, filePath, relPath, aggregate, &hashed)
+			}
+		}
+		report.recordResponse(response)
+		if errors.Is(err, ErrServerSkipped) {
+			aggregate.FileSkipped(uint64(report.Size))
+			summary.recordServerSkipped(report, err, fileStartTime)
+			continue
+		}
+		if errors.Is(err, ErrFileUnchanged) {
+			aggregate.FileSkipped(uint64(report.Size))
+			summary.recordUnchanged(report, checksum, fileStartTime)
+			if statErr == nil && cleanupSource(logger, filePath, relPath, fileInfo.Size(), fileInfo.ModTime(), checksum, response.message) {
+				summary.cleanedUp++
+			}
+			continue
+		}
+		if err != nil {
+			aggregate.FileFailed(uint64(report.Size))
+			logger.Error("Failed to transfer the file", "file_name", relPath, "error", err)
+			report.finish(fileStartTime)
+			summary.recordFailure(report, err)
+			if errors.Is(err, ErrServerShutdown) {
+				logger.Error("The server is shutting down, aborting the remaining transfers")
+				break
+			}
+			// If the connection is still lost after the retries of the file, the server is likely gone for good.
+			if connectionLost(err) {
+				logger.Error("Connection error detected, aborting the remaining transfers")
 				break
 			}
 			continue
 		}
 
-		if fileInfo, err := os.Stat(filePath); err == nil {
-			totalBytesTransferred += fileInfo.Size()
+		aggregate.FileDone(uint64(report.Size))
+		report.Status = FileStatusSent
+		report.Checksum = hex.EncodeToString(checksum)
+		report.finish(fileStartTime)
+		summary.files = append(summary.files, report)
+		summary.totalBytes += report.Size
+		summary.successful++
+		if statErr == nil && cleanupSource(logger, filePath, relPath, fileInfo.Size(), fileInfo.ModTime(), checksum, response.message) {
+			summary.cleanedUp++
 		}
-		successfulTransfers++
 	}
 
-	log.Printf("Directory transfer completed: %s", dirPath)
-	log.Printf("Transfer summary: %d successful, %d failed, %d total bytes",
-		successfulTransfers, failedTransfers, totalBytesTransferred)
+	logger.Info("Directory transfer completed", "dir", dirPath, "duration_ms", time.Since(startTime).Milliseconds())
+	logger.Info("Transfer summary", "successful", summary.successful, "failed", summary.failed, "too_large", len(summary.tooLarge),
+		"bytes", summary.totalBytes, "filtered_files", summary.filteredFiles, "filtered_dirs", summary.filteredDirs)
+	if *syncMode {
+		logger.Info("Sync summary", "transferred", summary.successful, "unchanged", summary.unchanged, "bytes_saved", summary.bytesSaved)
+	}
+	if cleanupRequested() {
+		logger.Info("Cleanup summary", "cleaned_up", summary.cleanedUp, "files", len(allFiles))
+	}
+	for _, path := range summary.tooLarge {
+		logger.Warn("Skipped (too large)", "file_name", path)
+	}
 
-	if failedTransfers > 0 {
-		return fmt.Errorf("directory transfer completed with %d failed transfers out of %d total files",
-			failedTransfers, len(allFiles))
+	if summary.failed > 0 {
+		return summary, fmt.Errorf("directory transfer completed with %d failed transfers out of %d total files",
+			summary.failed, len(allFiles))
 	}
 
-	return nil
+	return summary, nil
+}
+
+// recordFailure records a file that failed to transfer.
+func (s *transferSummary) recordFailure(report fileReport, err error) {
+	report.Status = FileStatusFailed
+	report.Error = err.Error()
+	s.files = append(s.files, report)
+	s.failed++
+	if errors.Is(err, ErrServerShutdown) {
+		s.serverShutdown = true
+	}
+	if errors.Is(err, ErrAuthFailed) {
+		s.authFailed = true
+	}
+}
+
+// recordServerSkipped records a file that the server skipped because it already has a file of the same name,
+// which is not a failure, since the server is configured to keep its files (the "skip" strategy).
+func (s *transferSummary) recordServerSkipped(report fileReport, err error, startTime time.Time) {
+	report.Status = FileStatusSkipped
+	report.Error = err.Error()
+	report.finish(startTime)
+	s.files = append(s.files, report)
+	s.serverSkipped++
+}
+
+// recordUnchanged records a file skipped by -sync because the server already has it.
+func (s *transferSummary) recordUnchanged(report fileReport, checksum []byte, startTime time.Time) {
+	report.Status = FileStatusSkipped
+	report.Checksum = hex.EncodeToString(checksum)
+	report.finish(startTime)
+	s.files = append(s.files, report)
+	s.unchanged++
+	s.bytesSaved += report.Size
+}
+
+// transferSingleFile transfers a single file on its own connection and returns a summary of the transfer.
+func transferSingleFile(ctx context.Context, path string) (*transferSummary, error) {
+	summary := &transferSummary{}
+	startTime := time.Now()
+	defer func() {
+		summary.duration = time.Since(startTime)
+	}()
+
+	report := fileReport{Name: filepath.Base(path), Status: FileStatusFailed}
+	fileInfo, statErr := os.Stat(path)
+	if statErr == nil && fileInfo.IsDir() {
+		err := fmt.Errorf("%w: %s", ErrIsDirectory, path)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+	if statErr == nil {
+		report.Size = fileInfo.Size()
+	}
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	// A large file is checked against the limits of the server first, rather than rejected once uploaded.
+	var info *protocol.ServerInfo
+	if statErr == nil && fileInfo.Size() >= ServerInfoThreshold {
+		var err error
+		if info, err = fetchServerInfo(); err != nil {
+			logger.Debug("Failed to get the server information", "error", err)
+		} else if err := checkFileLimits(info, path, fileInfo.Size()); err != nil {
+			summary.recordFailure(report, err)
+			return summary, err
+		}
+	}
+
+	var checksum []byte
+	var response transferResponse
+	var err error
+	switch {
+	case *parallel > 1 && info != nil && info.Ranges:
+		checksum, response, err = transferRanges(ctx, logger, path, *parallel)
+	case *parallel > 1 && info != nil:
+		logger.Warn("The server does not accept files sent over several connections, sending over one", "parallel_streams", *parallel)
+		fallthrough
+	default:
+		checksum, response, err = sendSingleFile(ctx, logger, path)
+	}
+	report.recordResponse(response)
+	if errors.Is(err, ErrServerSkipped) {
+		summary.recordServerSkipped(report, err, startTime)
+		return summary, nil
+	}
+	if errors.Is(err, ErrFileUnchanged) {
+		summary.recordUnchanged(report, checksum, startTime)
+		if statErr == nil && cleanupSource(logger, path, filepath.Base(path), fileInfo.Size(), fileInfo.ModTime(), checksum, response.message) {
+			summary.cleanedUp = 1
+		}
+		return summary, nil
+	}
+	if err != nil {
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+
+	report.Status = FileStatusSent
+	report.Checksum = hex.EncodeToString(checksum)
+	report.finish(startTime)
+	summary.files = append(summary.files, report)
+	summary.totalBytes = report.Size
+	summary.successful = 1
+	if statErr == nil && cleanupSource(logger, path, filepath.Base(path), fileInfo.Size(), fileInfo.ModTime(), checksum, response.message) {
+		summary.cleanedUp = 1
+	}
+
+	return summary, nil
+}
+
+// sendSingleFile transfers a single file on its own connection (see `transferFile`).
+func sendSingleFile(ctx context.Context, logger *slog.Logger, path string) ([]byte, transferResponse, error) {
+	logger.Info("Connecting to the server...", "server", *serverAddr)
+
+	// Establish a TCP connection to the server using the server's address.
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+	}
+
+	// Close the connection when the surrounding function exits, or at the -timeout deadline.
+	stopTimeout := closeOnTimeout(ctx, conn)
+	defer func() {
+		stopTimeout()
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warn("Error closing the connection", "error", err)
+		}
+		logger.Info("Connection closed")
+	}()
+
+	logger.Info("Connected successfully to the server", "server", *serverAddr)
+
+	// Set connection timeouts.
+	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to set read deadline: %v", err)
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+
+	return transferFile(ctx, logger, conn, path, "", nil, nil)
+}
+
+// merge adds the outcome of another transfer to the summary.
+func (s *transferSummary) merge(other *transferSummary) {
+	if other == nil {
+		return
+	}
+	s.successful += other.successful
+	s.failed += other.failed
+	s.tooLarge = append(s.tooLarge, other.tooLarge...)
+	s.unchanged += other.unchanged
+	s.serverSkipped += other.serverSkipped
+	s.bytesSaved += other.bytesSaved
+	s.cleanedUp += other.cleanedUp
+	s.totalBytes += other.totalBytes
+	s.filteredFiles += other.filteredFiles
+	s.filteredDirs += other.filteredDirs
+	s.files = append(s.files, other.files...)
+	s.serverShutdown = s.serverShutdown || other.serverShutdown
+	s.authFailed = s.authFailed || other.authFailed
+}
+
+// transferStream streams the content of the reader (e.g. stdin) to the server as a file named `name`,
+// whose size is not known up front, and returns a summary of the transfer.
+// The checksum is calculated incrementally while streaming and sent at the end of the stream.
+// A non-zero `size` is the size the stream is expected to have: its progress is shown against it, and a stream of
+// another size fails without its end being sent, so that the server discards it.
+func transferStream(ctx context.Context, reader io.Reader, source, name string, size uint64) (*transferSummary, error) {
+	summary := &transferSummary{}
+	startTime := time.Now()
+	defer func() {
+		summary.duration = time.Since(startTime)
+	}()
+
+	report := fileReport{Name: name, Status: FileStatusFailed}
+	logger := slog.With("transfer_id", protocol.NewTransferID(), "file_name", name)
+
+	logger.Info("Connecting to the server...", "server", *serverAddr)
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		err = fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+	stopTimeout := closeOnTimeout(ctx, conn)
+	defer func() {
+		stopTimeout()
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warn("Error closing the connection", "error", err)
+		}
+		logger.Info("Connection closed")
+	}()
+
+	header := &protocol.Header{
+		MessageType:   protocol.MessageTypeTransfer,        // Message type for file transfer.
+		FileSize:      0,                                   // Unknown until the end of the stream.
+		FileName:      name,                                // Name of the file on the server.
+		Checksum:      make([]byte, protocol.ChecksumSize), // Sent at the end of the stream instead.
+		TransferType:  protocol.TransferTypeStream,         // Transfer type.
+		DirectoryPath: *remoteDir,                          // Destination subdirectory on the server (-remote-dir).
+	}
+
+	ctxWriter := &contextWriter{
+		ctx:  ctx,
+		conn: conn,
+	}
+	if err := protocol.WriteHeader(ctxWriter, header); err != nil {
+		err = fmt.Errorf("failed to send the stream header: %v", err)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+
+	fmt.Fprintf(statusOutput, "Streaming %s to the server as %s...\n", source, name)
+
+	// Buffer the chunk frames, so that each chunk is not split into separate writes for its length and data.
+	bufferedWriter := bufio.NewWriterSize(ctxWriter, *bufferSize)
+	streamWriter := protocol.NewStreamWriter(bufferedWriter)
+	pooledBuffer := protocol.CopyBuffers.Get(*bufferSize)
+	defer protocol.CopyBuffers.Put(pooledBuffer)
+	transferBuffer := *pooledBuffer
+
+	// A stream of a known size is read one byte past it at most, which is enough to tell that it is too long.
+	// The progress of a stream of an unknown size is shown with a spinner instead of a bar.
+	if size > 0 {
+		reader = io.LimitReader(reader, int64(size)+1)
+	}
+	progressReader := protocol.NewProgressReader(reader, size, fmt.Sprintf("Streaming %s", name), os.Stderr, progressMode(),
+		protocol.WithCopyBuffer(transferBuffer))
+	if _, err := io.CopyBuffer(streamWriter, progressReader, transferBuffer); err != nil {
+		err = fmt.Errorf("failed to stream the content: %v", err)
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+	report.Size = int64(streamWriter.Written())
+
+	if size > 0 && streamWriter.Written() != size {
+		err := fmt.Errorf("the stream does not have the expected size of %d bytes (-size): %d bytes read", size, streamWriter.Written())
+		if streamWriter.Written() > size {
+			err = fmt.Errorf("the stream exceeds the expected size of %d bytes (-size)", size)
+		}
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+	progressReader.Complete()
+
+	if err := streamWriter.Close(); err != nil {
+		err = fmt.Errorf("failed to end the stream: %v", err)
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+	if err := bufferedWriter.Flush(); err != nil {
+		err = fmt.Errorf("failed to send the stream: %v", err)
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+
+	response, err := readTransferResponse(conn, header)
+	report.recordResponse(response)
+	if err == nil {
+		err = checkResponseChecksum(response, streamWriter.Checksum())
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to read server response: %w", err)
+		report.finish(startTime)
+		summary.recordFailure(report, err)
+		return summary, err
+	}
+
+	logger.Info("Stream sent successfully!", "bytes", report.Size, "duration_ms", time.Since(startTime).Milliseconds(),
+		"checksum", hex.EncodeToString(streamWriter.Checksum()), "response", response.message)
+
+	report.Status = FileStatusSent
+	report.Checksum = hex.EncodeToString(streamWriter.Checksum())
+	report.finish(startTime)
+	summary.files = append(summary.files, report)
+	summary.totalBytes = report.Size
+	summary.successful = 1
+
+	return summary, nil
+}
+
+// transferSource validates and transfers a single source path, which may be a file, a directory, or stdin.
+func transferSource(ctx context.Context, source sourcePath, filter *protocol.PathFilter) (*transferSummary, error) {
+	summary := &transferSummary{}
+
+	if source.path == StdinPath {
+		slog.Info("Preparing the stream transfer from stdin", "file_name", *streamName)
+		return transferStream(ctx, os.Stdin, "stdin", *streamName, uint64(*streamSize))
+	}
+
+	err := source.err
+	if err == nil {
+		err = validatePath(source.path)
+	}
+	if err != nil {
+		err = fmt.Errorf("path validation failed: %v", err)
+		summary.recordFailure(fileReport{Name: source.path}, err)
+		return summary, err
+	}
+
+	fileInfo, err := os.Stat(source.path)
+	if err != nil {
+		err = fmt.Errorf("failed to get the path information: %v", err)
+		summary.recordFailure(fileReport{Name: source.path}, err)
+		return summary, err
+	}
+
+	if fileInfo.IsDir() && *tarMode {
+		slog.Info("Preparing the archive transfer", "dir", source.path)
+		return transferArchive(ctx, source.path, filter)
+	}
+	if fileInfo.IsDir() {
+		slog.Info("Preparing the directory transfer", "dir", source.path)
+		return transferDirectory(ctx, source.path, filter)
+	}
+
+	slog.Info("Preparing the file transfer", "file_name", source.path)
+	return transferSingleFile(ctx, source.path)
+}
+
+// transferSources transfers every source path in order and returns a summary aggregated across all of them.
+// A failed source path does not stop the remaining ones unless `-fail-fast` is set.
+func transferSources(ctx context.Context, sources []sourcePath, filter *protocol.PathFilter) (*transferSummary, error) {
+	summary := &transferSummary{}
+	startTime := time.Now()
+	defer func() {
+		summary.duration = time.Since(startTime)
+	}()
+
+	var failedSources []string
+	var lastErr error
+	for _, source := range sources {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return summary, fmt.Errorf("transfer interrupted: %w", ErrTimeout)
+		}
+		if ctx.Err() != nil {
+			return summary, fmt.Errorf("transfer interrupted: %v", ctx.Err())
+		}
+
+		result, err := transferSource(ctx, source, filter)
+		summary.merge(result)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return summary, fmt.Errorf("transfer of %s interrupted: %w: %v", source.path, ErrTimeout, err)
+		}
+		if errors.Is(err, ErrServerShutdown) {
+			summary.serverShutdown = true
+		}
+		if summary.serverShutdown {
+			// The remaining source paths would be refused as well.
+			return summary, fmt.Errorf("transfer of %s failed: %w", source.path, ErrServerShutdown)
+		}
+		if err != nil {
+			slog.Error("Transfer of the source path failed", "path", source.path, "error", err)
+			failedSources = append(failedSources, source.path)
+			lastErr = err
+			if *failFast {
+				return summary, fmt.Errorf("transfer of %s failed: %v", source.path, err)
+			}
+		}
+	}
+
+	if len(sources) > 1 {
+		slog.Info("Overall summary", "sources", len(sources), "successful", summary.successful, "failed", summary.failed,
+			"too_large", len(summary.tooLarge), "bytes", summary.totalBytes)
+		if *syncMode {
+			slog.Info("Overall sync summary", "transferred", summary.successful, "unchanged", summary.unchanged, "bytes_saved", summary.bytesSaved)
+		}
+		if cleanupRequested() {
+			slog.Info("Overall cleanup summary", "cleaned_up", summary.cleanedUp)
+		}
+	}
+
+	if len(failedSources) > 0 && len(sources) == 1 {
+		return summary, lastErr
+	}
+	if len(failedSources) > 0 {
+		return summary, fmt.Errorf("%d of %d source paths failed: %s",
+			len(failedSources), len(sources), strings.Join(failedSources, ", "))
+	}
+
+	return summary, nil
+}
+
+// verifySummary summarizes the outcome of a verification run.
+type verifySummary struct {
+	matched    int // Number of files whose server copy matches.
+	mismatched int // Number of files whose server copy differs.
+	missing    int // Number of files missing on the server.
+	failed     int // Number of files that could not be verified.
+}
+
+// verifyPath verifies that the server's copies of a local file or directory match their local checksums,
+// querying the server for each file on a single connection without re-sending any content.
+func verifyPath(ctx context.Context, path string, filter *protocol.PathFilter) (*verifySummary, error) {
+	summary := &verifySummary{}
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get the path information for %s: %v", path, err)
+	}
+
+	var files []protocol.PlannedFile
+	if fileInfo.IsDir() {
+		plan, err := planDirectory(path, filter, true)
+		if err != nil {
+			return summary, fmt.Errorf("failed to walk the directory %s: %v", path, err)
+		}
+		files = plan.Files
+	} else {
+		checksum, err := protocol.CalculateFileChecksumFromPath(ctx, path)
+		if err != nil {
+			return summary, fmt.Errorf("failed to calculate the file checksum: %v", err)
+		}
+		files = []protocol.PlannedFile{{
+			Path:     filepath.Base(path),
+			Size:     fileInfo.Size(),
+			Checksum: hex.EncodeToString(checksum),
+		}}
+	}
+
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return summary, fmt.Errorf("failed to establish the connection for the verification: %v", err)
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			logger.Warn("Error closing the verification connection", "error", err)
+		}
+	}()
+
+	for _, file := range files {
+		select {
+		case <-ctx.Done():
+			return summary, fmt.Errorf("verification interrupted: %v", ctx.Err())
+		default:
+		}
+
+		checksum, err := hex.DecodeString(file.Checksum)
+		if err != nil {
+			return summary, fmt.Errorf("invalid checksum for %s: %v", file.Path, err)
+		}
+		header := &protocol.Header{
+			MessageType:   protocol.MessageTypeVerify,
+			FileSize:      uint64(file.Size),
+			FileName:      filepath.FromSlash(file.Path),
+			Checksum:      checksum,
+			TransferType:  protocol.TransferTypeFile,
+			DirectoryPath: *remoteDir,
+		}
+
+		if err := conn.SetDeadline(time.Now().Add(WriteTimeout)); err != nil {
+			return summary, fmt.Errorf("failed to set deadline: %v", err)
+		}
+		if err := protocol.WriteHeader(conn, header); err != nil {
+			return summary, fmt.Errorf("failed to send the verification header for %s: %v", file.Path, err)
+		}
+		status, message, err := protocol.ReadResponse(conn)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read the verification response for %s: %v", file.Path, err)
+		}
+
+		switch {
+		case status == protocol.ResponseStatusSuccess:
+			logger.Info("Verified", "file_name", file.Path)
+			summary.matched++
+		case message == protocol.VerifyMessageMismatch:
+			logger.Warn("Mismatch", "file_name", file.Path)
+			summary.mismatched++
+		case message == protocol.VerifyMessageNotFound:
+			logger.Warn("Missing on the server", "file_name", file.Path)
+			summary.missing++
+		default:
+			logger.Error("Failed to verify", "file_name", file.Path, "error", message)
+			summary.failed++
+		}
+	}
+
+	logger.Info("Verification summary", "verified", summary.matched, "mismatched", summary.mismatched,
+		"missing", summary.missing, "failed", summary.failed)
+
+	if summary.mismatched+summary.missing+summary.failed > 0 {
+		return summary, fmt.Errorf("verification found %d mismatched, %d missing, and %d failed files out of %d total files",
+			summary.mismatched, summary.missing, summary.failed, len(files))
+	}
+
+	return summary, nil
+}
+
+// merge adds the outcome of another verification run to the summary.
+func (s *verifySummary) merge(other *verifySummary) {
+	if other == nil {
+		return
+	}
+	s.matched += other.matched
+	s.mismatched += other.mismatched
+	s.missing += other.missing
+	s.failed += other.failed
+}
+
+// verifySources verifies every source path in order and returns a summary aggregated across all of them.
+// A failed source path does not stop the remaining ones unless `-fail-fast` is set.
+func verifySources(ctx context.Context, sources []sourcePath, filter *protocol.PathFilter) (*verifySummary, error) {
+	summary := &verifySummary{}
+
+	var failedSources []string
+	var lastErr error
+	for _, source := range sources {
+		if ctx.Err() != nil {
+			return summary, fmt.Errorf("verification interrupted: %v", ctx.Err())
+		}
+
+		err := source.err
+		if err == nil {
+			var result *verifySummary
+			result, err = verifyPath(ctx, source.path, filter)
+			summary.merge(result)
+		} else {
+			summary.failed++
+		}
+		if err != nil {
+			slog.Error("Verification of the source path failed", "path", source.path, "error", err)
+			failedSources = append(failedSources, source.path)
+			lastErr = err
+			if *failFast {
+				return summary, fmt.Errorf("verification of %s failed: %v", source.path, err)
+			}
+		}
+	}
+
+	if len(failedSources) > 0 && len(sources) == 1 {
+		return summary, lastErr
+	}
+	if len(failedSources) > 0 {
+		return summary, fmt.Errorf("%d of %d source paths failed verification: %s",
+			len(failedSources), len(sources), strings.Join(failedSources, ", "))
+	}
+
+	return summary, nil
 }
 
 func main() {
@@ -456,32 +2332,114 @@ func main() {
 
 	setupLogging()
 
-	log.Printf("Starting the file transfer client...")
+	// Fill in the flags not given on the command line from the configuration profile, if any.
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	profile, fromProfile, err := loadProfile(explicit)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	// The profile may set the logging flags as well.
+	setupLogging()
+	if *printConfig {
+		if err := printEffectiveConfig(os.Stdout, profile, fromProfile, explicit); err != nil {
+			fatal("Failed to print the configuration", "error", err)
+		}
+		return
+	}
+
+	if *jsonOutput {
+		statusOutput = os.Stderr
+	}
+
+	slog.Info("Starting the file transfer client...")
 
 	if err := validateArgs(); err != nil {
-		log.Fatalf("Invalid command-line arguments: %v", err)
+		fatal("Invalid command-line arguments", "error", err)
+	}
+
+	// Dial the normalized address, e.g. "[::1]:8080" for "-server ::1".
+	*serverAddr, _ = parseServerAddress(*serverAddr)
+
+	if *ping {
+		message, roundTrip, err := pingServer()
+		if err != nil {
+			fatal("Ping failed", "server", *serverAddr, "error", err)
+		}
+		fmt.Fprintf(statusOutput, "Server %s is up: %s (round trip %v)\n", *serverAddr, message, roundTrip.Round(time.Microsecond))
+		return
 	}
 
-	if err := validatePath(*filePath); err != nil {
-		log.Fatalf("Path validation failed: %v", err)
+	if *cachePath != "" {
+		cache, err := loadChecksumCache(*cachePath, *cacheVerify)
+		if err != nil {
+			fatal("Failed to load the checksum cache", "error", err)
+		}
+		checksums = cache
 	}
 
-	fileInfo, err := os.Stat(*filePath)
+	sources := expandSourcePaths(sourceArgs())
+
+	filter, err := protocol.NewPathFilter(includePatterns, excludePatterns)
 	if err != nil {
-		log.Fatalf("Failed to get the path information: %v", err)
+		fatal("Invalid filter pattern", "error", err)
 	}
 
-	isDirectory := fileInfo.IsDir()
+	if *planOnly {
+		if len(sources) != 1 {
+			fatal("The -plan mode takes a single directory, but the arguments expand to several paths", "paths", len(sources))
+		}
+		if sources[0].err != nil {
+			fatal("Path validation failed", "error", sources[0].err)
+		}
+		dirPath := sources[0].path
+		if err := validatePath(dirPath); err != nil {
+			fatal("Path validation failed", "error", err)
+		}
+		fileInfo, err := os.Stat(dirPath)
+		if err != nil {
+			fatal("Failed to get the path information", "error", err)
+		}
+		if !fileInfo.IsDir() {
+			fatal("The -plan mode requires a directory", "path", dirPath)
+		}
+		err = printDirectoryPlan(dirPath, filter)
+		saveChecksumCache()
+		if err != nil {
+			fatal("Failed to plan the directory transfer", "error", err)
+		}
+		return
+	}
 
-	if isDirectory {
-		log.Printf("Preparing the directory transfer: %s", *filePath)
-	} else {
-		log.Printf("Preparing the file transfer: %s", *filePath)
+	if *checksumOnly != "" {
+		if len(sources) != 1 {
+			fatal("The -checksum-only mode takes a single directory, but the arguments expand to several paths", "paths", len(sources))
+		}
+		if sources[0].err != nil {
+			fatal("Path validation failed", "error", sources[0].err)
+		}
+		dirPath := sources[0].path
+		if err := validatePath(dirPath); err != nil {
+			fatal("Path validation failed", "error", err)
+		}
+		if fileInfo, err := os.Stat(dirPath); err != nil || !fileInfo.IsDir() {
+			fatal("The -checksum-only mode requires a directory", "path", dirPath)
+		}
+		if err := checkManifestFile(*checksumOnly, dirPath, filter); err != nil {
+			fatal("Manifest check failed", "error", err)
+		}
+		return
 	}
 
-	// Create context for graceful shutdown.
+	// Create context for graceful shutdown, which also ends the operation at its -timeout deadline.
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if *opTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *opTimeout)
+		defer cancel()
+	}
 
 	// Set up signal handling for graceful shutdown.
 	sigChan := make(chan os.Signal, 1)
@@ -490,55 +2448,82 @@ func main() {
 	// Handle shutdown signals.
 	go func() {
 		sig := <-sigChan
-		log.Printf("Shutdown signal received: %v. Starting graceful shutdown...", sig)
+		slog.Info("Shutdown signal received. Starting graceful shutdown...", "signal", sig.String())
 		cancel()
 	}()
 
-	if isDirectory {
-		if err := transferDirectory(ctx, *filePath); err != nil {
-			log.Fatalf("Directory transfer failed: %v", err)
+	if *benchmark {
+		summary, err := runBenchmark(ctx, *benchmarkSize)
+		if *jsonOutput {
+			if err := printTransferReport(summary, err); err != nil {
+				slog.Error("Failed to print the JSON summary", "error", err)
+			}
 		}
+		if err != nil {
+			fatal("Benchmark failed", "server", *serverAddr, "error", err)
+		}
+		fmt.Fprintf(statusOutput, "Sent %d bytes (%.2f MB) to %s in %v: %.2f MB/s\n", summary.totalBytes, toMB(uint64(summary.totalBytes)),
+			*serverAddr, summary.duration.Round(time.Millisecond), benchmarkRate(summary))
 		return
 	}
 
-	log.Printf("Connecting to the server at %s...", *serverAddr)
+	if *deleteRemote {
+		if _, err := deleteRemotePaths(ctx, sourceArgs()); err != nil {
+			fatal("Deletion failed", "error", err)
+		}
+		return
+	}
 
-	// Establish a TCP connection to the server using the server's address.
-	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
-	if err != nil {
-		log.Fatalf("Failed to establish TCP connection to the server: %v", err)
+	if *verifyOnly {
+		_, err := verifySources(ctx, sources, filter)
+		saveChecksumCache()
+		if err != nil {
+			fatal("Verification failed", "error", err)
+		}
+		return
 	}
 
-	// Close the connection when the surrounding function exits.
-	defer func() {
-		if err := conn.Close(); err != nil {
-			log.Printf("Error closing connection: %v", err)
+	if *watchMode {
+		if err := validateWatchSource(sources[0]); err != nil {
+			fatal("Path validation failed", "error", err)
 		}
-		log.Printf("Connection closed")
-	}()
+		_, err := watchDirectory(ctx, sources[0].path, filter)
+		saveChecksumCache()
+		if err != nil {
+			fatal("Watch failed", "error", err)
+		}
+		slog.Info("Client shutting down.")
+		return
+	}
 
-	log.Printf("Connected successfully to the server at %s", *serverAddr)
+	summary, err := transferSources(ctx, sources, filter)
+	saveChecksumCache()
 
-	// Set connection timeouts.
-	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
-		log.Fatalf("Failed to set read deadline: %v", err)
-	}
-	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
-		log.Fatalf("Failed to set write deadline: %v", err)
+	if *jsonOutput {
+		if err := printTransferReport(summary, err); err != nil {
+			slog.Error("Failed to print the JSON summary", "error", err)
+		}
 	}
 
-	// Handle the single file transfer.
-	if err := transferFile(ctx, conn, *filePath); err != nil {
-		log.Fatalf("File transfer failed: %v", err)
+	if err != nil && summary.serverShutdown {
+		slog.Error("Transfer failed", "error", err)
+		os.Exit(ExitServerShutdown)
+	}
+	if err != nil && summary.authFailed {
+		slog.Error("Transfer failed", "error", err)
+		os.Exit(ExitAuthFailed)
+	}
+	if err != nil {
+		fatal("Transfer failed", "error", err)
 	}
 
-	log.Printf("Client shutting down.")
+	slog.Info("Client shutting down.")
 }
 
 // loadTLSConfig loads the TLS configuration for the client based on command-line flags.
 func loadTLSConfig() (*tls.Config, error) {
 	// When no TLS flags are provided, return nil to indicate plain TCP.
-	if !*tlsSkipVerify && *tlsCAFile == "" {
+	if !*tlsSkipVerify && *tlsCAFile == "" && *tlsCertFile == "" {
 		return nil, nil
 	}
 
@@ -546,9 +2531,23 @@ func loadTLSConfig() (*tls.Config, error) {
 		MinVersion: tls.VersionTLS12,
 	}
 
+	// Present the client certificate to a server requiring one (mutual TLS).
+	if *tlsCertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
 	if *tlsSkipVerify {
 		config.InsecureSkipVerify = true
-		log.Printf("WARNING: TLS certificate verification is disabled (insecure)")
+		slog.Warn("TLS certificate verification is disabled (insecure)")
+		return config, nil
+	}
+
+	// Without a CA certificate, the server is verified against the system roots.
+	if *tlsCAFile == "" {
 		return config, nil
 	}
 
@@ -566,7 +2565,8 @@ func loadTLSConfig() (*tls.Config, error) {
 	return config, nil
 }
 
-// dialWithTLS establishes a connection with optional TLS encryption (fallback to plain TCP if no TLS config is provided).
+// dialWithTLS establishes a connection with optional TLS encryption (fallback to plain TCP if no TLS config is provided),
+// with the TCP options of "-tcp-nodelay" and "-tcp-keepalive".
 func dialWithTLS(network, address string, timeout time.Duration) (net.Conn, error) {
 	tlsConfig, err := loadTLSConfig()
 	if err != nil {
@@ -577,9 +2577,18 @@ func dialWithTLS(network, address string, timeout time.Duration) (net.Conn, erro
 		Timeout: timeout,
 	}
 
+	var conn net.Conn
 	if tlsConfig != nil {
-		return tls.DialWithDialer(dialer, network, address, tlsConfig)
+		conn, err = tls.DialWithDialer(dialer, network, address, tlsConfig)
+	} else {
+		conn, err = dialer.Dial(network, address)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return dialer.Dial(network, address)
+	if _, err := protocol.TuneTCP(conn, *tcpNoDelay, *tcpKeepAlive); err != nil {
+		slog.Warn("Failed to tune the connection", "error", err)
+	}
+	return conn, nil
 }
@@ -1,22 +1,33 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"filexfer/protocol"
+	"filexfer/server"
+	"flag"
+	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"math/big"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -87,9 +98,53 @@ func TestToGB5GB(t *testing.T) {
 	}
 }
 
+// captureLogs sends the log messages of the test to a JSON handler, and returns a function
+// that decodes the messages logged so far. The default loggers are restored at the end of the test.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+
+	logger, writer, flags, prefix := slog.Default(), log.Writer(), log.Flags(), log.Prefix()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	})
+
+	var output bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	return func() []map[string]any {
+		t.Helper()
+
+		var entries []map[string]any
+		for line := range strings.Lines(output.String()) {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to decode the log line %q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+// findLog returns the first logged message with the given text, or fails the test.
+func findLog(t *testing.T, entries []map[string]any, msg string) map[string]any {
+	t.Helper()
+
+	for _, entry := range entries {
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	t.Fatalf("expected the log to contain %q, got: %v", msg, entries)
+	return nil
+}
+
 // TestSetupLogging tests the `setupLogging` function to ensure that
-// it expectedly configures structured logging.
+// it expectedly configures the text format by default, and the JSON format with "-log-format".
 func TestSetupLogging(t *testing.T) {
+	captureLogs(t)
 	setupLogging()
 
 	expectedFlags := log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile
@@ -101,6 +156,15 @@ func TestSetupLogging(t *testing.T) {
 	if log.Prefix() != expectedPrefix {
 		t.Fatalf("expected the log prefix %q, got %q", expectedPrefix, log.Prefix())
 	}
+
+	withFlags(t, map[string]string{"log-format": protocol.LogFormatJSON, "log-level": "warn"})
+	setupLogging()
+	if _, ok := slog.Default().Handler().(*slog.JSONHandler); !ok {
+		t.Fatalf("expected a JSON handler, got %T", slog.Default().Handler())
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("expected info messages to be dropped at the warn level")
+	}
 }
 
 // TestValidateArgsWithEmptyFilePath tests `validateArgs` with an empty file path.
@@ -217,6 +281,36 @@ func TestValidatePathWithInvalidFilename(t *testing.T) {
 	}
 }
 
+// TestValidatePathWithOverlongComponent tests `validatePath` to ensure that
+// a name longer than `protocol.MaxPathComponentLength` bytes is rejected before the path is accessed.
+func TestValidatePathWithOverlongComponent(t *testing.T) {
+	err := validatePath(filepath.Join(t.TempDir(), strings.Repeat("ü", 128)))
+	if !errors.Is(err, ErrInvalidFilename) || !errors.Is(err, protocol.ErrPathComponentTooLong) {
+		t.Fatalf("expected ErrInvalidFilename and ErrPathComponentTooLong, got: %v", err)
+	}
+}
+
+// TestValidatePathWithControlCharacters tests `validatePath` to ensure that
+// names with a newline or a tab are rejected, while a regular name in the same directory passes.
+func TestValidatePathWithControlCharacters(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"foo\nbar.txt", "foo\tbar.txt", "regular.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	for _, name := range []string{"foo\nbar.txt", "foo\tbar.txt"} {
+		err := validatePath(filepath.Join(dir, name))
+		if !errors.Is(err, ErrInvalidFilename) || !errors.Is(err, protocol.ErrUnsafeFileName) {
+			t.Fatalf("expected ErrInvalidFilename and ErrUnsafeFileName for %q, got: %v", name, err)
+		}
+	}
+	if err := validatePath(filepath.Join(dir, "regular.txt")); err != nil {
+		t.Fatalf("expected a regular name to pass, got: %v", err)
+	}
+}
+
 // TestValidatePathWithFileTooLarge tests `validatePath` with a file that exceeds `MaxFileSize`.
 // This test temporarily reduces `MaxFileSize` to create a testable scenario.
 func TestValidatePathWithFileTooLarge(t *testing.T) {
@@ -611,6 +705,38 @@ func TestContextWriterWithSetDeadlineError(t *testing.T) {
 	}
 }
 
+// TestNewContextWriter tests `newContextWriter` to ensure that only a plain TCP connection gets the `io.ReaderFrom`
+// through which files are sent with sendfile, which still honors the context.
+func TestNewContextWriter(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	if _, ok := newContextWriter(context.Background(), clientConn).(io.ReaderFrom); ok {
+		t.Fatal("expected a connection other than TCP to be written through a buffer")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	readerFrom, ok := newContextWriter(ctx, conn).(io.ReaderFrom)
+	if !ok {
+		t.Fatal("expected a TCP connection to be written through its ReadFrom")
+	}
+	cancel()
+	if n, err := readerFrom.ReadFrom(strings.NewReader("data")); n != 0 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled without any byte sent, got %d bytes (%v)", n, err)
+	}
+}
+
 // TestReadServerResponseSuccess tests `readServerResponse` with a successful response.
 func TestReadServerResponseSuccess(t *testing.T) {
 	responseData := []byte{
@@ -650,6 +776,102 @@ func TestReadServerResponseWithErrorStatus(t *testing.T) {
 	}
 }
 
+// TestReadServerResponseShutdown tests `readServerResponse` to ensure that
+// the response of a server shutting down is recognized as `ErrServerShutdown`.
+func TestReadServerResponseShutdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := protocol.WriteCodedResponse(&buf, protocol.ResponseStatusRetryLater, protocol.ErrorCodeShuttingDown, protocol.ShutdownMessage); err != nil {
+		t.Fatalf("failed to encode the response: %v", err)
+	}
+
+	err := readServerResponse(&MockConn{readData: buf.Bytes()})
+	if !errors.Is(err, ErrServerShutdown) {
+		t.Fatalf("expected ErrServerShutdown, got %v", err)
+	}
+}
+
+// TestResponseError tests `responseError` to ensure that
+// the status and the error code of a response are mapped to errors without matching its message, and whether they are retried.
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    uint8
+		code      uint16
+		expected  error
+		retryable bool
+	}{
+		{"shutdown", protocol.ResponseStatusRetryLater, protocol.ErrorCodeShuttingDown, ErrServerShutdown, true},
+		{"skipped", protocol.ResponseStatusSkipped, protocol.ErrorCodeConflictSkip, ErrServerSkipped, false},
+		{"file too large", protocol.ResponseStatusError, protocol.ErrorCodeFileTooLarge, ErrFileTooLarge, false},
+		{"quota exceeded", protocol.ResponseStatusError, protocol.ErrorCodeQuotaExceeded, ErrServerLimit, true},
+		{"traversal rejected", protocol.ResponseStatusError, protocol.ErrorCodeTraversalRejected, ErrInvalidFilename, false},
+		{"checksum mismatch", protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, protocol.ErrChecksumMismatch, true},
+		{"authentication failed", protocol.ResponseStatusError, protocol.ErrorCodeAuthFailed, ErrAuthFailed, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := protocol.WriteCodedResponse(&buf, tt.status, tt.code, "any message"); err != nil {
+				t.Fatalf("failed to encode the response: %v", err)
+			}
+			err := readServerResponse(&MockConn{readData: buf.Bytes()})
+			if !errors.Is(err, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, err)
+			}
+			if retryable(err) != tt.retryable {
+				t.Fatalf("expected retryable to be %v for %v", tt.retryable, err)
+			}
+		})
+	}
+
+	// An error without a code, e.g. from a server predating error codes, is a plain server error.
+	err := responseError(protocol.ResponseStatusError, protocol.ErrorCodeNone, "File already exists and skip strategy is enabled")
+	if errors.Is(err, ErrServerSkipped) || err.Error() != "server error: File already exists and skip strategy is enabled" || !retryable(err) {
+		t.Fatalf("expected a plain retryable server error, got %v", err)
+	}
+}
+
+// TestTransferDirectoryServerSkipped tests `transferDirectory` to ensure that
+// a file skipped by the server is reported as skipped rather than failed, and does not fail the transfer.
+func TestTransferDirectoryServerSkipped(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{"existing.txt": "existing", "new.txt": "new"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	ms := startMockServer(t)
+	ms.respond = func(header *protocol.Header, checksum []byte) (uint8, uint16, string) {
+		if header.FileName == "existing.txt" {
+			return protocol.ResponseStatusSkipped, protocol.ErrorCodeConflictSkip, "File already exists and skip strategy is enabled"
+		}
+		return protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(checksum)
+	}
+
+	summary, err := transferDirectory(context.Background(), dir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != 1 || summary.failed != 0 || summary.serverSkipped != 1 {
+		t.Fatalf("expected 1 successful and 1 skipped file, got %d successful, %d failed, and %d skipped",
+			summary.successful, summary.failed, summary.serverSkipped)
+	}
+	report := newTransferReport(summary, nil)
+	for _, file := range report.Files {
+		if file.Name == "existing.txt" && file.Status != FileStatusSkipped {
+			t.Fatalf("expected existing.txt to be reported as skipped, got %+v", file)
+		}
+	}
+	if report.Skipped != 1 || report.TotalFiles != 2 {
+		t.Fatalf("expected 1 skipped file out of 2, got %+v", report)
+	}
+}
+
 // TestReadServerResponseWithEOF tests `readServerResponse` when connection closes unexpectedly.
 func TestReadServerResponseWithEOF(t *testing.T) {
 	mockConn := &MockConn{
@@ -940,18 +1162,7 @@ func TestLoadTLSConfigWithSkipVerify(t *testing.T) {
 	*tlsSkipVerify = true
 	*tlsCAFile = ""
 
-	var logBuf bytes.Buffer
-	oldOutput := log.Writer()
-	oldFlags := log.Flags()
-	oldPrefix := log.Prefix()
-
-	log.SetOutput(&logBuf)
-	log.SetFlags(0)
-	defer func() {
-		log.SetOutput(oldOutput)
-		log.SetFlags(oldFlags)
-		log.SetPrefix(oldPrefix)
-	}()
+	logs := captureLogs(t)
 
 	config, err := loadTLSConfig()
 	if err != nil {
@@ -964,9 +1175,8 @@ func TestLoadTLSConfigWithSkipVerify(t *testing.T) {
 		t.Fatal("expected InsecureSkipVerify to be true")
 	}
 
-	logOutput := logBuf.String()
-	if !strings.Contains(logOutput, "WARNING: TLS certificate verification is disabled") {
-		t.Fatalf("expected warning message in log, got: %q", logOutput)
+	if entry := findLog(t, logs(), "TLS certificate verification is disabled (insecure)"); entry["level"] != "WARN" {
+		t.Fatalf("expected a warning, got: %v", entry)
 	}
 }
 
@@ -1050,6 +1260,71 @@ func TestLoadTLSConfigWithInvalidCACertificate(t *testing.T) {
 	}
 }
 
+// generateTestClientCert generates a self-signed TLS client certificate and its private key for testing.
+func generateTestClientCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	certFile = filepath.Join(tmpDir, "client.crt")
+	keyFile = filepath.Join(tmpDir, "client.key")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the private key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test client"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create the certificate: %v", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+		t.Fatalf("failed to write the certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatalf("failed to write the key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+// TestLoadTLSConfigWithClientCertificate tests that `loadTLSConfig` enables TLS with the client certificate
+// of "-cert" and "-key", verifying the server against the system roots without "-tls-ca".
+func TestLoadTLSConfigWithClientCertificate(t *testing.T) {
+	oldSkipVerify, oldCAFile, oldCertFile, oldKeyFile := *tlsSkipVerify, *tlsCAFile, *tlsCertFile, *tlsKeyFile
+	defer func() {
+		*tlsSkipVerify, *tlsCAFile, *tlsCertFile, *tlsKeyFile = oldSkipVerify, oldCAFile, oldCertFile, oldKeyFile
+	}()
+
+	*tlsSkipVerify = false
+	*tlsCAFile = ""
+	*tlsCertFile, *tlsKeyFile = generateTestClientCert(t)
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config == nil || len(config.Certificates) != 1 {
+		t.Fatal("expected the client certificate to be presented")
+	}
+	if config.RootCAs != nil || config.InsecureSkipVerify {
+		t.Fatal("expected the server to be verified against the system roots")
+	}
+
+	*tlsKeyFile = "/nonexistent/client.key"
+	if _, err := loadTLSConfig(); err == nil || !strings.Contains(err.Error(), "failed to load the client certificate") {
+		t.Fatalf("expected 'failed to load the client certificate' in error, got: %v", err)
+	}
+}
+
 // TestDialWithTLSWithoutTLS tests that `dialWithTLS` uses plain TCP when TLS config is nil to ensure that
 // `dialWithTLS` expectedly falls back to plain TCP when no TLS config is provided.
 func TestDialWithTLSWithoutTLS(t *testing.T) {
@@ -1159,3 +1434,1866 @@ func TestDialWithTLSErrorOnInvalidCA(t *testing.T) {
 		t.Fatalf("expected load TLS configuration error, got: %v", err)
 	}
 }
+
+// TestListDirectoryFilesWithFilter tests `listDirectoryFiles` to ensure that
+// excluded directories are pruned, excluded files are counted, and includes override excludes.
+func TestListDirectoryFilesWithFilter(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"main.go":                    "package main",
+		"debug.log":                  "log",
+		"keep.log":                   "kept",
+		"node_modules/pkg/index.js":  "js",
+		"node_modules/pkg/README.md": "md",
+		"src/app.go":                 "package src",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	filter, err := protocol.NewPathFilter([]string{"keep.log"}, []string{"node_modules", "*.log"})
+	if err != nil {
+		t.Fatalf("failed to create the filter: %v", err)
+	}
+
+	listing, err := listDirectoryFiles(tmpDir, filter)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, path := range listing.files {
+		relPath, err := filepath.Rel(tmpDir, path)
+		if err != nil {
+			t.Fatalf("failed to calculate the relative path: %v", err)
+		}
+		got = append(got, filepath.ToSlash(relPath))
+	}
+	expected := []string{"keep.log", "main.go", "src/app.go"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected files %v, got %v", expected, got)
+	}
+
+	if listing.filteredFiles != 1 {
+		t.Fatalf("expected 1 filtered file, got %d", listing.filteredFiles)
+	}
+	if listing.filteredDirs != 1 {
+		t.Fatalf("expected 1 pruned directory, got %d", listing.filteredDirs)
+	}
+	expectedSize := int64(len("kept") + len("package main") + len("package src"))
+	if listing.totalSize != expectedSize {
+		t.Fatalf("expected the total size %d, got %d", expectedSize, listing.totalSize)
+	}
+}
+
+// mockServer is a minimal in-process server that accepts validation and transfer messages
+// and records the content of every received file by name.
+type mockServer struct {
+	listener net.Listener
+	mu       sync.Mutex
+	received map[string][]byte
+	uploads  int // Number of files whose content was received.
+	// Whether transfers are acknowledged without their checksum, like a server predating checksums in responses.
+	omitChecksum bool
+	// Number of files received before the next transfers are answered with the shutdown response, if positive.
+	shutdownAfter int
+	// Answer to information requests, or nil to refuse them like a server predating them.
+	info *protocol.ServerInfo
+	// Status, error code, and message of the response to a transfer given its header and the checksum of its content, if set.
+	respond func(header *protocol.Header, checksum []byte) (uint8, uint16, string)
+	// Receipt attached to a successful response to a transfer given its header and the checksum of its content, if set.
+	// Without it, transfers are acknowledged without receipts, like a server predating them.
+	receipt func(header *protocol.Header, checksum []byte) *protocol.Receipt
+	// Time waited before answering each transfer, like a slow server.
+	delay time.Duration
+	// Number of the next files stored without a response, their connections closed like by a server that crashed.
+	dropResponses int
+	// Number of sync queries and manifests received.
+	queries, manifests int
+}
+
+// startMockServer starts a `mockServer` on a loopback port and points the `-server` flag at it.
+func startMockServer(t *testing.T) *mockServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to start the mock server: %v", err)
+	}
+	return serveMock(t, listener)
+}
+
+// serveMock serves a `mockServer` on the listener and points the `-server` flag at it.
+func serveMock(t *testing.T, listener net.Listener) *mockServer {
+	t.Helper()
+
+	ms := &mockServer{
+		listener: listener,
+		received: make(map[string][]byte),
+	}
+
+	originalServerAddr := *serverAddr
+	*serverAddr = listener.Addr().String()
+	t.Cleanup(func() {
+		*serverAddr = originalServerAddr
+		_ = listener.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go ms.handle(conn)
+		}
+	}()
+
+	return ms
+}
+
+// handle serves a single connection of the `mockServer`.
+func (ms *mockServer) handle(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+
+	for {
+		header, err := protocol.ReadHeader(conn)
+		if err != nil {
+			return
+		}
+		if header.MessageType == protocol.MessageTypeValidate {
+			_ = protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, "Directory size validated!")
+			return
+		}
+		if header.MessageType == protocol.MessageTypeManifest {
+			if err := ms.compareManifest(conn, header); err != nil {
+				return
+			}
+			continue
+		}
+		if header.MessageType == protocol.MessageTypeVerify || header.MessageType == protocol.MessageTypeQuery {
+			if err := ms.verify(conn, header); err != nil {
+				return
+			}
+			continue
+		}
+		if header.MessageType == protocol.MessageTypeDelete {
+			if err := ms.delete(conn, header); err != nil {
+				return
+			}
+			continue
+		}
+		if header.MessageType == protocol.MessageTypePing {
+			if protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, protocol.PingMessage("test", time.Minute)) != nil {
+				return
+			}
+			continue
+		}
+		if header.MessageType == protocol.MessageTypeInfo {
+			ms.mu.Lock()
+			info := ms.info
+			ms.mu.Unlock()
+			if info == nil {
+				_ = protocol.WriteResponse(conn, protocol.ResponseStatusError, "Failed to read file transfer header: invalid message type")
+				return
+			}
+			message, err := protocol.EncodeServerInfo(info)
+			if err != nil || protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, message) != nil {
+				return
+			}
+			continue
+		}
+
+		if header.TransferType == protocol.TransferTypeTarArchive {
+			if err := ms.extract(conn, header); err != nil {
+				_ = protocol.WriteResponse(conn, protocol.ResponseStatusError, err.Error())
+				return
+			}
+			continue
+		}
+
+		var content []byte
+		if header.TransferType == protocol.TransferTypeStream {
+			if content, err = io.ReadAll(protocol.NewStreamReader(conn, 0)); err != nil {
+				_ = protocol.WriteResponse(conn, protocol.ResponseStatusError, "Data integrity check failed")
+				return
+			}
+		} else if header.Compression != protocol.CompressionNone {
+			reader, err := protocol.NewCompressedReader(conn, header.Compression, 0)
+			if err != nil {
+				return
+			}
+			if content, err = io.ReadAll(reader); err != nil || !bytes.Equal(protocol.CalculateDataChecksum(content), header.Checksum) {
+				_ = protocol.WriteResponse(conn, protocol.ResponseStatusError, "Data integrity check failed")
+				return
+			}
+		} else {
+			content = make([]byte, header.FileSize)
+			if _, err := io.ReadFull(conn, content); err != nil {
+				return
+			}
+		}
+		ms.mu.Lock()
+		if ms.shutdownAfter > 0 && ms.uploads >= ms.shutdownAfter {
+			ms.mu.Unlock()
+			_ = protocol.WriteCodedResponse(conn, protocol.ResponseStatusRetryLater, protocol.ErrorCodeShuttingDown, protocol.ShutdownMessage)
+			return
+		}
+		ms.received[receivedName(header, header.FileName)] = content
+		ms.uploads++
+		if ms.dropResponses > 0 {
+			ms.dropResponses--
+			ms.mu.Unlock()
+			return
+		}
+		status, code, response := uint8(protocol.ResponseStatusSuccess), uint16(protocol.ErrorCodeNone), protocol.TransferReceivedMessage(protocol.CalculateDataChecksum(content))
+		if ms.omitChecksum {
+			response = protocol.TransferMessageReceived
+		}
+		if ms.respond != nil {
+			status, code, response = ms.respond(header, protocol.CalculateDataChecksum(content))
+		}
+		var receipt *protocol.Receipt
+		if ms.receipt != nil && status == protocol.ResponseStatusSuccess {
+			receipt = ms.receipt(header, protocol.CalculateDataChecksum(content))
+		}
+		delay := ms.delay
+		ms.mu.Unlock()
+
+		time.Sleep(delay)
+		if receipt != nil {
+			if err := protocol.WriteReceiptResponse(conn, response, receipt); err != nil {
+				return
+			}
+		} else if err := protocol.WriteCodedResponse(conn, status, code, response); err != nil {
+			return
+		}
+	}
+}
+
+// extract receives a tar archive transfer and records its files by their paths, and its directories with a trailing "/".
+func (ms *mockServer) extract(conn net.Conn, header *protocol.Header) error {
+	reader := protocol.NewStreamReader(conn, 0)
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+
+	tarReader := tar.NewReader(bytes.NewReader(content))
+	for {
+		entryHeader, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			return err
+		}
+		ms.mu.Lock()
+		name := receivedName(header, entryHeader.Name)
+		if entryHeader.Typeflag == tar.TypeDir {
+			name += "/"
+		}
+		ms.received[name] = data
+		if entryHeader.Typeflag == tar.TypeReg {
+			ms.uploads++
+		}
+		ms.mu.Unlock()
+	}
+	return protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, protocol.TransferReceivedMessage(reader.Checksum()))
+}
+
+// receivedName returns the slash-separated path under which the `mockServer` records a file of the request,
+// i.e. the name under the directory path of the header.
+func receivedName(header *protocol.Header, name string) string {
+	return path.Join(filepath.ToSlash(header.DirectoryPath), filepath.ToSlash(name))
+}
+
+// verify answers a verification (or query) request against the files received so far.
+func (ms *mockServer) verify(conn net.Conn, header *protocol.Header) error {
+	ms.mu.Lock()
+	if header.MessageType == protocol.MessageTypeQuery {
+		ms.queries++
+	}
+	content, ok := ms.received[receivedName(header, header.FileName)]
+	ms.mu.Unlock()
+
+	switch {
+	case !ok:
+		return protocol.WriteResponse(conn, protocol.ResponseStatusError, protocol.VerifyMessageNotFound)
+	case !bytes.Equal(protocol.CalculateDataChecksum(content), header.Checksum):
+		return protocol.WriteResponse(conn, protocol.ResponseStatusError, protocol.VerifyMessageMismatch)
+	default:
+		return protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, protocol.VerifyMessageMatch)
+	}
+}
+
+// compareManifest answers a manifest with the files that are not among the files received so far.
+func (ms *mockServer) compareManifest(conn net.Conn, header *protocol.Header) error {
+	data := make([]byte, header.FileSize)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return err
+	}
+	entries, err := protocol.DecodeManifest(data)
+	if err != nil {
+		return protocol.WriteResponse(conn, protocol.ResponseStatusError, err.Error())
+	}
+
+	ms.mu.Lock()
+	ms.manifests++
+	needed := make([]bool, len(entries))
+	for i, entry := range entries {
+		content, ok := ms.received[receivedName(header, entry.Path)]
+		needed[i] = !ok || !bytes.Equal(protocol.CalculateDataChecksum(content), entry.Checksum)
+	}
+	ms.mu.Unlock()
+	return protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, protocol.EncodeManifestReply(needed))
+}
+
+// delete answers a deletion request by forgetting a received file, or with a recursive request, the files under a directory.
+func (ms *mockServer) delete(conn net.Conn, header *protocol.Header) error {
+	name := receivedName(header, header.FileName)
+
+	ms.mu.Lock()
+	deleted := 0
+	for received := range ms.received {
+		if received == name || (header.TransferType == protocol.TransferTypeDirectory && strings.HasPrefix(received, name+"/")) {
+			delete(ms.received, received)
+			deleted++
+		}
+	}
+	ms.mu.Unlock()
+
+	if deleted == 0 {
+		return protocol.WriteResponse(conn, protocol.ResponseStatusError, protocol.VerifyMessageNotFound)
+	}
+	return protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, protocol.DeleteMessageDeleted)
+}
+
+// store records a file as if it had been received by the `mockServer`.
+func (ms *mockServer) store(name string, content []byte) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.received[name] = content
+}
+
+// receivedFiles returns a copy of the files received by the `mockServer`, keyed by name.
+func (ms *mockServer) receivedFiles() map[string][]byte {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	files := make(map[string][]byte, len(ms.received))
+	for name, content := range ms.received {
+		files[name] = content
+	}
+	return files
+}
+
+// TestTransferDirectorySkipsOversizedFile tests `transferDirectory` to ensure that
+// a file exceeding `MaxFileSize` is reported as skipped while the other files are transferred.
+func TestTransferDirectorySkipsOversizedFile(t *testing.T) {
+	originalMaxFileSize := MaxFileSize
+	MaxFileSize = 16
+	defer func() { MaxFileSize = originalMaxFileSize }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"small.txt":     "small",
+		"sub/tiny.txt":  "tiny",
+		"sub/large.bin": strings.Repeat("x", 64),
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	ms := startMockServer(t)
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if summary.successful != 2 || summary.failed != 0 {
+		t.Fatalf("expected 2 successful and 0 failed transfers, got %d and %d", summary.successful, summary.failed)
+	}
+	if len(summary.tooLarge) != 1 || filepath.Base(summary.tooLarge[0]) != "large.bin" {
+		t.Fatalf("expected large.bin to be reported as skipped, got %v", summary.tooLarge)
+	}
+
+	received := ms.receivedFiles()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 files on the server, got %d", len(received))
+	}
+	if string(received["small.txt"]) != "small" || string(received["sub/tiny.txt"]) != "tiny" {
+		t.Fatalf("unexpected received files: %v", received)
+	}
+	if _, ok := received["sub/large.bin"]; ok {
+		t.Fatal("expected large.bin not to be sent to the server")
+	}
+}
+
+// TestTransferDirectoryToServer tests `transferDirectory` against a real server (`server.TestServer`) to ensure that
+// the files of the directory are stored by the server under their relative paths.
+func TestTransferDirectoryToServer(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo", "sub/deep/c.txt": "charlie"}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	if err := server.Flags.Set("progress", protocol.ProgressModeNone); err != nil {
+		t.Fatalf("failed to set the server flag: %v", err)
+	}
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone})
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != len(files) || summary.failed != 0 {
+		t.Fatalf("expected %d successful transfers, got %d successful and %d failed", len(files), summary.successful, summary.failed)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(ts.Dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("expected %s with %q on the server, got %q and %v", name, content, got, err)
+		}
+	}
+}
+
+// writerFunc is an `io.Writer` calling a function with the bytes written.
+type writerFunc func(p []byte) (int, error)
+
+// Write calls the function with the bytes.
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// withReconnectBackoff shortens the wait before reconnecting and bounds the retries of a file for the duration of the test.
+func withReconnectBackoff(t *testing.T, retries int) {
+	t.Helper()
+
+	originalRetries, originalBackoff := MaxFileRetries, ReconnectBackoff
+	MaxFileRetries, ReconnectBackoff = retries, 10*time.Millisecond
+	t.Cleanup(func() { MaxFileRetries, ReconnectBackoff = originalRetries, originalBackoff })
+}
+
+// startDirectoryTransferServer creates a directory of three files and starts a server to transfer it to,
+// whose `action` runs when the status output announces the second file, before it is sent.
+func startDirectoryTransferServer(t *testing.T, action func(ts *server.TestServer) error) (string, map[string]string, *server.TestServer) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{"a.txt": "alpha", "b.txt": "bravo", "c.txt": "charlie"}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	if err := server.Flags.Set("progress", protocol.ProgressModeNone); err != nil {
+		t.Fatalf("failed to set the server flag: %v", err)
+	}
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	t.Cleanup(func() { _ = ts.Close() })
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone})
+
+	originalStatusOutput := statusOutput
+	statusOutput = writerFunc(func(p []byte) (int, error) {
+		if strings.HasPrefix(string(p), "Transferring file 2/") {
+			if err := action(ts); err != nil {
+				t.Errorf("failed to act on the server: %v", err)
+			}
+		}
+		return len(p), nil
+	})
+	t.Cleanup(func() { statusOutput = originalStatusOutput })
+	return tmpDir, files, ts
+}
+
+// TestTransferDirectoryReconnects tests `transferDirectory` to ensure that
+// when the server restarts between files, the transfer reconnects and sends the remaining files.
+func TestTransferDirectoryReconnects(t *testing.T) {
+	withReconnectBackoff(t, 3)
+	tmpDir, files, ts := startDirectoryTransferServer(t, (*server.TestServer).Restart)
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != len(files) || summary.failed != 0 {
+		t.Fatalf("expected %d successful transfers, got %d successful and %d failed", len(files), summary.successful, summary.failed)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(ts.Dir, name))
+		if err != nil || string(got) != content {
+			t.Fatalf("expected %s with %q on the server, got %q and %v", name, content, got, err)
+		}
+	}
+}
+
+// TestTransferDirectoryReconnectRetriesBounded tests `transferDirectory` to ensure that
+// when the server stops for good, a file is retried a bounded number of times before the remaining files are given up.
+func TestTransferDirectoryReconnectRetriesBounded(t *testing.T) {
+	withReconnectBackoff(t, 2)
+	tmpDir, _, _ := startDirectoryTransferServer(t, (*server.TestServer).Close)
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err == nil {
+		t.Fatal("expected an error once the server is gone, got nil")
+	}
+	if summary.successful != 1 || summary.failed != 1 {
+		t.Fatalf("expected 1 successful and 1 failed transfer, got %d successful and %d failed", summary.successful, summary.failed)
+	}
+	if report := summary.files[1]; report.Name != "b.txt" || !strings.Contains(report.Error, "connection refused") {
+		t.Fatalf("expected b.txt to fail on the refused reconnection, got %+v", report)
+	}
+}
+
+// TestTransferDirectoryNoResendAfterStored tests `transferDirectory` against a server that stores a file
+// and drops the connection before responding to ensure that the file is not sent again.
+func TestTransferDirectoryNoResendAfterStored(t *testing.T) {
+	withReconnectBackoff(t, 2)
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "a.txt"), []byte("a.txt"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ms := startMockServer(t)
+	ms.dropResponses = 1
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err == nil {
+		t.Fatal("expected an error for the unanswered transfer, got nil")
+	}
+	if summary.failed != 1 || !strings.Contains(summary.files[0].Error, "server closed connection unexpectedly") {
+		t.Fatalf("expected a.txt to fail on the lost connection, got %+v", summary.files)
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if ms.uploads != 1 {
+		t.Fatalf("expected the file to be uploaded once, got %d uploads", ms.uploads)
+	}
+}
+
+// TestResendable tests `resendable` to ensure that only connections lost before the content was sent,
+// and not by a timeout, allow a file to be sent again.
+func TestResendable(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"lost while sending", &unsentError{fmt.Errorf("failed to send file content: %w", syscall.EPIPE)}, true},
+		{"closed while sending", &unsentError{fmt.Errorf("failed to send file transfer header: %w", io.EOF)}, true},
+		{"timeout while sending", &unsentError{&net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}}, false},
+		{"lost awaiting the response", fmt.Errorf("failed to read server response: %w", ErrConnectionLost), false},
+		{"rejected while sending", &unsentError{errors.New("file too large")}, false},
+		{"no error", nil, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := resendable(tc.err); got != tc.want {
+				t.Fatalf("resendable(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestVerifyPathReportsMatchMismatchAndMissing tests `verifyPath` to ensure that
+// matching, corrupted, and missing server copies are each classified correctly.
+func TestVerifyPathReportsMatchMismatchAndMissing(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"same.txt":        "same",
+		"sub/corrupt.txt": "original",
+		"sub/missing.txt": "missing",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	ms := startMockServer(t)
+	ms.store("same.txt", []byte("same"))
+	ms.store("sub/corrupt.txt", []byte("0riginal"))
+
+	summary, err := verifyPath(context.Background(), tmpDir, nil)
+	if err == nil {
+		t.Fatal("expected an error for the mismatched and missing files, got nil")
+	}
+	if summary.matched != 1 || summary.mismatched != 1 || summary.missing != 1 || summary.failed != 0 {
+		t.Fatalf("expected 1 verified, 1 mismatched, 1 missing, and 0 failed, got %+v", *summary)
+	}
+
+	summary, err = verifyPath(context.Background(), filepath.Join(tmpDir, "same.txt"), nil)
+	if err != nil {
+		t.Fatalf("unexpected error verifying a single matching file: %v", err)
+	}
+	if summary.matched != 1 {
+		t.Fatalf("expected 1 verified file, got %+v", *summary)
+	}
+}
+
+// withFlags sets the given command-line flags for the duration of the test and restores all flags afterward.
+func withFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	original := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		original[f.Name] = f.Value.String()
+	})
+	originalExclude, originalInclude := excludePatterns, includePatterns
+	t.Cleanup(func() {
+		for name, value := range original {
+			_ = flag.Set(name, value)
+		}
+		excludePatterns, includePatterns = originalExclude, originalInclude
+	})
+
+	excludePatterns, includePatterns = nil, nil
+	for name, value := range values {
+		if err := flag.Set(name, value); err != nil {
+			t.Fatalf("failed to set the flag -%s: %v", name, err)
+		}
+	}
+}
+
+// TestValidateArgsFlagRules tests `validateArgs` to ensure that
+// every flag rule rejects its violation and accepts a valid combination.
+func TestValidateArgsFlagRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr string
+	}{
+		{"file only", map[string]string{"file": "/some/path"}, ""},
+		{"missing file", map[string]string{}, "file path is required"},
+		{"skip-verify with CA", map[string]string{"file": "f", "tls-skip-verify": "true", "tls-ca": "ca.pem"}, "-tls-skip-verify, -tls-ca"},
+		{"skip-verify alone", map[string]string{"file": "f", "tls-skip-verify": "true"}, ""},
+		{"CA alone", map[string]string{"file": "f", "tls-ca": "ca.pem"}, ""},
+		{"include without exclude", map[string]string{"file": "f", "include": "keep.log", "no-ignore-file": "true"}, "-include, -exclude, -no-ignore-file"},
+		{"include over the ignore file", map[string]string{"file": "f", "include": "keep.log"}, ""},
+		{"include with exclude", map[string]string{"file": "f", "include": "keep.log", "exclude": "*.log"}, ""},
+		{"malformed pattern", map[string]string{"file": "f", "exclude": "[a-"}, "invalid filter pattern"},
+		{"plan-checksums without plan", map[string]string{"file": "f", "plan-checksums": "true"}, "-plan-checksums, -plan"},
+		{"plan with checksums", map[string]string{"file": "f", "plan": "true", "plan-checksums": "true"}, ""},
+		{"plan with verify", map[string]string{"file": "f", "plan": "true", "verify": "true"}, "-plan, -verify"},
+		{"verify alone", map[string]string{"file": "f", "verify": "true"}, ""},
+		{"json with plan", map[string]string{"file": "f", "json": "true", "plan": "true"}, "-json, -plan, -verify"},
+		{"json with verify", map[string]string{"file": "f", "json": "true", "verify": "true"}, "-json, -plan, -verify"},
+		{"json alone", map[string]string{"file": "f", "json": "true"}, ""},
+		{"zero buffer size", map[string]string{"file": "f", "buffer-size": "0"}, "-buffer-size"},
+		{"negative buffer size", map[string]string{"file": "f", "buffer-size": "-1"}, "-buffer-size"},
+		{"buffer size too large", map[string]string{"file": "f", "buffer-size": "67108865"}, "-buffer-size"},
+		{"32KB buffer size", map[string]string{"file": "f", "buffer-size": "32768"}, ""},
+		{"keep-alive disabled", map[string]string{"file": "f", "tcp-keepalive": "0", "tcp-nodelay": "false"}, ""},
+		{"negative keep-alive", map[string]string{"file": "f", "tcp-keepalive": "-1s"}, "-tcp-keepalive"},
+		{"stdin with name", map[string]string{"file": "-", "name": "mydb.sql"}, ""},
+		{"stdin with size", map[string]string{"file": "-", "name": "mydb.sql", "size": "1024"}, ""},
+		{"timeout", map[string]string{"file": "f", "timeout": "10m"}, ""},
+		{"benchmark alone", map[string]string{"benchmark": "true"}, ""},
+		{"benchmark with file", map[string]string{"file": "f", "benchmark": "true"}, "-benchmark, -file"},
+		{"zero benchmark size", map[string]string{"benchmark": "true", "benchmark-size": "0"}, "-benchmark-size"},
+		{"negative timeout", map[string]string{"file": "f", "timeout": "-1s"}, "invalid timeout"},
+		{"negative stream size", map[string]string{"file": "-", "name": "mydb.sql", "size": "-1"}, "invalid stream size"},
+		{"size without stdin", map[string]string{"file": "f", "size": "1024"}, "-size only applies"},
+		{"stdin without name", map[string]string{"file": "-"}, "-name is required"},
+		{"name without stdin", map[string]string{"file": "f", "name": "mydb.sql"}, "-name only applies"},
+		{"stdin with verify", map[string]string{"file": "-", "name": "mydb.sql", "verify": "true"}, "not planned or verified"},
+		{"invalid progress mode", map[string]string{"file": "f", "progress": "fancy"}, "invalid progress mode"},
+		{"plain progress mode", map[string]string{"file": "f", "progress": "plain"}, ""},
+		{"quiet with plain progress", map[string]string{"file": "f", "quiet": "true", "progress": "plain"}, "-progress, -quiet"},
+		{"quiet with none progress", map[string]string{"file": "f", "quiet": "true", "progress": "none"}, ""},
+		{"IPv6 server address", map[string]string{"file": "f", "server": "[::1]:8080"}, ""},
+		{"unbracketed IPv6 server address with port", map[string]string{"file": "f", "server": "::1:8080:x"}, "-server"},
+		{"server address without host", map[string]string{"file": "f", "server": ":8080"}, "missing host"},
+		{"server port out of range", map[string]string{"file": "f", "server": "localhost:70000"}, "invalid port"},
+		{"zstd compression", map[string]string{"file": "f", "compress": "zstd"}, ""},
+		{"unknown compression", map[string]string{"file": "f", "compress": "lz4"}, "-compress"},
+		{"compressed stdin", map[string]string{"file": "-", "name": "x", "compress": "gzip"}, "stdin streams"},
+		{"watch a directory", map[string]string{"file": "outbox", "watch": "true", "delete-source": "true"}, ""},
+		{"watch stdin", map[string]string{"file": "-", "name": "x", "watch": "true"}, "-watch takes a single directory"},
+		{"watch with verify", map[string]string{"file": "f", "watch": "true", "verify": "true"}, "-watch only applies"},
+		{"watch with json", map[string]string{"file": "f", "watch": "true", "json": "true"}, "-watch, -file, -plan, -verify, -json"},
+		{"zero watch interval", map[string]string{"file": "f", "watch": "true", "watch-interval": "0s"}, "-watch-interval"},
+		{"delete source", map[string]string{"file": "f", "delete-source": "true"}, ""},
+		{"archive source", map[string]string{"file": "f", "archive-dir": "a"}, ""},
+		{"delete and archive", map[string]string{"file": "f", "delete-source": "true", "archive-dir": "a"}, "mutually exclusive"},
+		{"delete with verify", map[string]string{"file": "f", "verify": "true", "delete-source": "true"}, "only apply to transfers"},
+		{"archive stdin", map[string]string{"file": "-", "name": "x", "archive-dir": "a"}, "stdin"},
+		{"tar a directory", map[string]string{"file": "dir", "tar": "true"}, ""},
+		{"tar with sync", map[string]string{"file": "dir", "tar": "true", "sync": "true"}, "cannot skip or watch"},
+		{"tar with compression", map[string]string{"file": "dir", "tar": "true", "compress": "gzip"}, "does not support -compress"},
+		{"tar with delete source", map[string]string{"file": "dir", "tar": "true", "delete-source": "true"}, "whole archive"},
+		{"tar with verify", map[string]string{"file": "dir", "tar": "true", "verify": "true"}, "only applies to transfers"},
+		{"parallel streams", map[string]string{"file": "f", "parallel-streams": "4"}, ""},
+		{"too many parallel streams", map[string]string{"file": "f", "parallel-streams": "17"}, "invalid number of parallel streams"},
+		{"parallel streams with compression", map[string]string{"file": "f", "parallel-streams": "4", "compress": "gzip"}, "raw byte ranges"},
+		{"parallel streams with sync", map[string]string{"file": "f", "parallel-streams": "4", "sync": "true"}, "-sync or -tar"},
+		{"parallel streams from stdin", map[string]string{"file": "-", "name": "x", "parallel-streams": "4"}, "not stdin"},
+		{"checksum blocks", map[string]string{"file": "f", "checksum-block": "1048576"}, ""},
+		{"checksum blocks too small", map[string]string{"file": "f", "checksum-block": "4096"}, "invalid block size"},
+		{"checksum blocks with parallel streams", map[string]string{"file": "f", "checksum-block": "1048576", "parallel-streams": "4"}, "byte ranges"},
+		{"checksum blocks from stdin", map[string]string{"file": "-", "name": "x", "checksum-block": "1048576"}, "-tar or stdin"},
+		{"checksum only", map[string]string{"file": "dir", "checksum-only": "SHA256SUMS"}, ""},
+		{"checksum only with sync", map[string]string{"file": "dir", "checksum-only": "SHA256SUMS", "sync": "true"}, "offline"},
+		{"checksum only stdin", map[string]string{"file": "-", "name": "x", "checksum-only": "SHA256SUMS"}, "single directory"},
+		{"JSON log format", map[string]string{"file": "f", "log-format": "json", "log-level": "debug"}, ""},
+		{"invalid log format", map[string]string{"file": "f", "log-format": "xml"}, "invalid log format"},
+		{"invalid log level", map[string]string{"file": "f", "log-level": "verbose"}, "invalid log level"},
+		{"remote directory", map[string]string{"file": "f", "remote-dir": "backups/2024"}, ""},
+		{"delete remote", map[string]string{"file": "old/report.pdf", "delete-remote": "true", "recursive": "true"}, ""},
+		{"recursive without delete remote", map[string]string{"file": "f", "recursive": "true"}, "-recursive only applies"},
+		{"delete remote traversal", map[string]string{"file": "../report.pdf", "delete-remote": "true"}, "invalid remote path"},
+		{"delete remote stdin", map[string]string{"file": "-", "name": "x", "delete-remote": "true"}, "invalid remote path"},
+		{"delete remote with sync", map[string]string{"file": "f", "delete-remote": "true", "sync": "true"}, "cannot be combined"},
+		{"remote directory traversal", map[string]string{"file": "f", "remote-dir": "backups/../../x"}, "invalid remote directory"},
+		{"absolute remote directory", map[string]string{"file": "f", "remote-dir": "/srv/x"}, "invalid remote directory"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, tt.flags)
+
+			err := validateArgs()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error containing %q, got nil", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "fix:") {
+				t.Fatalf("expected an error containing %q and a fix, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestValidateArgsReportsAllViolations tests `validateArgs` to ensure that
+// all violations are reported at once instead of only the first one.
+func TestValidateArgsReportsAllViolations(t *testing.T) {
+	withFlags(t, map[string]string{"plan-checksums": "true", "include": "keep.log", "no-ignore-file": "true"})
+
+	err := validateArgs()
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if !strings.HasPrefix(err.Error(), "3 invalid flag(s)") {
+		t.Fatalf("expected 3 violations, got: %v", err)
+	}
+}
+
+// TestListDirectoryFilesWithIgnoreFile tests `listDirectoryFiles` to ensure that
+// the ignore file is honored unless `-no-ignore-file` is given, and is itself not transferred.
+func TestListDirectoryFilesWithIgnoreFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		protocol.IgnoreFileName: "*.log\n!keep.log\ncache/\n",
+		"main.go":               "package main",
+		"debug.log":             "log",
+		"sub/keep.log":          "kept",
+		"cache/blob.bin":        "blob",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	listing, err := listDirectoryFiles(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectedSize := int64(len(files["main.go"]) + len(files["sub/keep.log"]))
+	if len(listing.files) != 2 || listing.totalSize != expectedSize {
+		t.Fatalf("expected main.go and sub/keep.log (%d bytes), got %v (%d bytes)", expectedSize, listing.files, listing.totalSize)
+	}
+	if listing.filteredFiles != 2 || listing.filteredDirs != 1 {
+		t.Fatalf("expected 2 filtered files and 1 pruned directory, got %d and %d", listing.filteredFiles, listing.filteredDirs)
+	}
+
+	withFlags(t, map[string]string{"no-ignore-file": "true"})
+	listing, err = listDirectoryFiles(tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(listing.files) != len(files) {
+		t.Fatalf("expected all %d files with -no-ignore-file, got %v", len(files), listing.files)
+	}
+}
+
+// captureStdout runs the function with stdout redirected and returns what it printed.
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create the pipe: %v", err)
+	}
+	originalStdout := os.Stdout
+	os.Stdout = writer
+	defer func() { os.Stdout = originalStdout }()
+
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(reader)
+		output <- data
+	}()
+
+	fn()
+	_ = writer.Close()
+	return <-output
+}
+
+// TestPrintTransferReportJSON tests `printTransferReport` to ensure that
+// the JSON summary of a directory transfer carries the expected counts and per-file outcomes.
+func TestPrintTransferReportJSON(t *testing.T) {
+	originalMaxFileSize := MaxFileSize
+	MaxFileSize = 16
+	defer func() { MaxFileSize = originalMaxFileSize }()
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"a.txt":         "alpha",
+		"sub/b.txt":     "bravo!",
+		"sub/large.bin": strings.Repeat("x", 64),
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	startMockServer(t)
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := captureStdout(t, func() {
+		if err := printTransferReport(summary, err); err != nil {
+			t.Errorf("failed to print the report: %v", err)
+		}
+	})
+
+	var report transferReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		t.Fatalf("failed to unmarshal the JSON summary %q: %v", output, err)
+	}
+	if report.TotalFiles != 3 || report.Successful != 2 || report.Failed != 0 || report.Skipped != 1 {
+		t.Fatalf("expected 3 files (2 successful, 0 failed, 1 skipped), got %+v", report)
+	}
+	if report.TotalBytes != int64(len(files["a.txt"])+len(files["sub/b.txt"])) {
+		t.Fatalf("unexpected total bytes: %d", report.TotalBytes)
+	}
+	if report.Error != "" {
+		t.Fatalf("expected no error, got %q", report.Error)
+	}
+
+	statuses := map[string]fileReport{}
+	for _, file := range report.Files {
+		statuses[file.Name] = file
+	}
+	if file := statuses["sub/b.txt"]; file.Status != FileStatusSent || file.ServerResponse != protocol.TransferReceivedMessage(protocol.CalculateDataChecksum([]byte(files["sub/b.txt"]))) ||
+		file.Checksum != hex.EncodeToString(protocol.CalculateDataChecksum([]byte(files["sub/b.txt"]))) {
+		t.Fatalf("unexpected report for sub/b.txt: %+v", file)
+	}
+	if file := statuses["sub/large.bin"]; file.Status != FileStatusSkipped || file.Size != 64 || file.Checksum != "" {
+		t.Fatalf("unexpected report for sub/large.bin: %+v", file)
+	}
+}
+
+// TestTransferReportSchema pins the field names of the JSON summary printed with `-json`,
+// so that scripts parsing it do not break silently when the report changes.
+func TestTransferReportSchema(t *testing.T) {
+	report := newTransferReport(&transferSummary{
+		files: []fileReport{{
+			Name:            "a.txt",
+			Size:            5,
+			Status:          FileStatusFailed,
+			DurationMs:      1,
+			RateBytesPerSec: 5000,
+			Checksum:        "00",
+			Error:           "server error: Data integrity check failed",
+			ServerResponse:  "Data integrity check failed",
+			StoredName:      "a-1.txt",
+		}},
+	}, errors.New("transfer failed"))
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatalf("failed to marshal the report: %v", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to unmarshal the report: %v", err)
+	}
+	var files []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["files"], &files); err != nil || len(files) != 1 {
+		t.Fatalf("failed to unmarshal the files of the report: %v", err)
+	}
+
+	expectedFields := []string{
+		"total_files", "successful", "failed", "skipped", "unchanged", "bytes_saved", "cleaned_up", "filtered_files", "filtered_dirs",
+		"total_bytes", "duration_ms", "files", "error",
+	}
+	expectedFileFields := []string{
+		"name", "size", "status", "duration_ms", "rate_bytes_per_sec", "checksum", "error", "server_response", "stored_name",
+	}
+	for name, fields := range map[string]struct {
+		got      map[string]json.RawMessage
+		expected []string
+	}{
+		"report": {fields, expectedFields},
+		"file":   {files[0], expectedFileFields},
+	} {
+		if len(fields.got) != len(fields.expected) {
+			t.Fatalf("expected the %s fields %v, got %s", name, fields.expected, data)
+		}
+		for _, field := range fields.expected {
+			if _, ok := fields.got[field]; !ok {
+				t.Fatalf("expected the %s field %q, got %s", name, field, data)
+			}
+		}
+	}
+
+	if FileStatusSent != "sent" || FileStatusSkipped != "skipped" || FileStatusFailed != "failed" {
+		t.Fatalf("unexpected file statuses: %q, %q, %q", FileStatusSent, FileStatusSkipped, FileStatusFailed)
+	}
+}
+
+// TestTransferSingleFileResponse tests `transferSingleFile` to ensure that the name the server stored the file under,
+// as named by the receipt of its response, is reported, and that a receipt or a message confirming another checksum fails the transfer.
+func TestTransferSingleFileResponse(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the test file: %v", err)
+	}
+	ms := startMockServer(t)
+
+	ms.receipt = func(header *protocol.Header, checksum []byte) *protocol.Receipt {
+		return &protocol.Receipt{Path: "report-1.txt", BytesWritten: header.FileSize, Checksum: checksum}
+	}
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(summary.files) != 1 || summary.files[0].Status != FileStatusSent || summary.files[0].StoredName != "report-1.txt" {
+		t.Fatalf("expected the file to be reported as stored under report-1.txt, got %+v", summary.files)
+	}
+
+	// A file stored under the requested name has no stored name to report.
+	ms.receipt = func(header *protocol.Header, checksum []byte) *protocol.Receipt {
+		return &protocol.Receipt{Path: header.FileName, BytesWritten: header.FileSize, Checksum: checksum}
+	}
+	summary, err = transferSingleFile(context.Background(), path)
+	if err != nil || len(summary.files) != 1 || summary.files[0].StoredName != "" {
+		t.Fatalf("expected no stored name for a file stored as requested, got %+v and error %v", summary.files, err)
+	}
+
+	ms.receipt = func(header *protocol.Header, checksum []byte) *protocol.Receipt {
+		return &protocol.Receipt{Path: header.FileName, BytesWritten: header.FileSize, Checksum: protocol.CalculateDataChecksum([]byte("other"))}
+	}
+	summary, err = transferSingleFile(context.Background(), path)
+	if !errors.Is(err, protocol.ErrChecksumMismatch) || summary.failed != 1 {
+		t.Fatalf("expected a receipt with another checksum to fail with ErrChecksumMismatch, got %v", err)
+	}
+	ms.receipt = nil
+
+	ms.respond = func(header *protocol.Header, checksum []byte) (uint8, uint16, string) {
+		return protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(protocol.CalculateDataChecksum([]byte("other")))
+	}
+	summary, err = transferSingleFile(context.Background(), path)
+	if !errors.Is(err, protocol.ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+	if summary.failed != 1 || summary.successful != 0 {
+		t.Fatalf("expected the transfer to be reported as failed, got %d successful and %d failed", summary.successful, summary.failed)
+	}
+}
+
+// TestTransferSingleFileReceipt tests `transferSingleFile` against a server with the rename strategy to ensure that
+// a file stored under its requested name reports no stored name, that the renamed copy reports the name from its receipt,
+// and that the files of an archive extracted where requested report no stored name.
+func TestTransferSingleFileReceipt(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	if err := server.Flags.Set("progress", protocol.ProgressModeNone); err != nil {
+		t.Fatalf("failed to set the server flag: %v", err)
+	}
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone, "remote-dir": "reports"})
+
+	path := filepath.Join(t.TempDir(), "report.txt")
+	if err := os.WriteFile(path, []byte("report"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	for _, expected := range []string{"", "reports/report_1.txt"} {
+		summary, err := transferSingleFile(context.Background(), path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(summary.files) != 1 || summary.files[0].StoredName != expected {
+			t.Fatalf("expected the stored name %q, got %+v", expected, summary.files)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(ts.Dir, "reports", "report_1.txt")); err != nil {
+		t.Fatalf("expected the renamed copy on the server, got %v", err)
+	}
+
+	// The receipt of an archive names the directory its entries were extracted into, as requested.
+	for _, remoteDir := range []string{"reports", ""} {
+		withFlags(t, map[string]string{"remote-dir": remoteDir})
+		summary, err := transferArchive(context.Background(), filepath.Dir(path), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(summary.files) != 1 || summary.files[0].StoredName != "" {
+			t.Fatalf("expected no stored name for the archive into %q, got %+v", remoteDir, summary.files)
+		}
+	}
+}
+
+// TestTransferSingleFileReport tests `transferSingleFile` to ensure that
+// a single file transfer is summarized with its checksum, and that a failed connection is reported.
+func TestTransferSingleFileReport(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "single.txt")
+	if err := os.WriteFile(path, []byte("single"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ms := startMockServer(t)
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	report := newTransferReport(summary, err)
+	if report.TotalFiles != 1 || report.Successful != 1 || report.Files[0].Name != "single.txt" || report.Files[0].Checksum == "" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	if string(ms.receivedFiles()["single.txt"]) != "single" {
+		t.Fatalf("expected single.txt on the server, got %v", ms.receivedFiles())
+	}
+
+	_ = ms.listener.Close()
+	summary, err = transferSingleFile(context.Background(), path)
+	if err == nil {
+		t.Fatal("expected an error after the server went away, got nil")
+	}
+	report = newTransferReport(summary, err)
+	if report.Failed != 1 || report.Files[0].Status != FileStatusFailed || report.Error == "" {
+		t.Fatalf("unexpected report for the failed transfer: %+v", report)
+	}
+}
+
+// TestTransferSingleFileLogAttributes tests the log messages of a single file transfer to ensure that
+// they carry the transfer identifier and the details of the file.
+func TestTransferSingleFileLogAttributes(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "single.txt")
+	if err := os.WriteFile(path, []byte("single"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	startMockServer(t)
+	logs := captureLogs(t)
+	if _, err := transferSingleFile(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries := logs()
+	connected := findLog(t, entries, "Connected successfully to the server")
+	sent := findLog(t, entries, "File sent successfully!")
+	if sent["transfer_id"] == nil || sent["transfer_id"] != connected["transfer_id"] {
+		t.Fatalf("expected the same transfer identifier on every message of the transfer, got %v and %v", connected, sent)
+	}
+	if sent["file_name"] != "single.txt" || sent["bytes"] != 6.0 || sent["response"] == nil {
+		t.Fatalf("expected the file name, size, and server response, got %v", sent)
+	}
+	if _, ok := sent["duration_ms"].(float64); !ok {
+		t.Fatalf("expected the duration in milliseconds, got %v", sent)
+	}
+}
+
+// TestExpandSourcePaths tests `expandSourcePaths` to ensure that
+// glob patterns are expanded in order, existing paths are taken literally, and unmatched patterns are reported.
+func TestExpandSourcePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.tar.gz", "b.tar.gz", "notes.txt", "literal[1].txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	sources := expandSourcePaths([]string{
+		filepath.Join(tmpDir, "*.tar.gz"),
+		filepath.Join(tmpDir, "literal[1].txt"),
+		filepath.Join(tmpDir, "*.zip"),
+		filepath.Join(tmpDir, "notes.txt"),
+	})
+
+	expected := []string{"a.tar.gz", "b.tar.gz", "literal[1].txt", "*.zip", "notes.txt"}
+	if len(sources) != len(expected) {
+		t.Fatalf("expected %d source paths, got %+v", len(expected), sources)
+	}
+	for i, source := range sources {
+		if filepath.Base(source.path) != expected[i] {
+			t.Fatalf("expected source path %d to be %s, got %s", i, expected[i], source.path)
+		}
+		if wantErr := expected[i] == "*.zip"; (source.err != nil) != wantErr {
+			t.Fatalf("unexpected error for %s: %v", source.path, source.err)
+		}
+	}
+	if !errors.Is(sources[3].err, ErrFileNotFound) {
+		t.Fatalf("expected ErrFileNotFound for the unmatched pattern, got: %v", sources[3].err)
+	}
+}
+
+// TestTransferSourcesMixedPaths tests `transferSources` to ensure that
+// files and directories are transferred together, a failed source path does not stop the rest,
+// and `-fail-fast` stops at the first failure.
+func TestTransferSourcesMixedPaths(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"single.txt":    "single",
+		"docs/a.md":     "alpha",
+		"docs/sub/b.md": "bravo",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	ms := startMockServer(t)
+
+	sources := []sourcePath{
+		{path: filepath.Join(tmpDir, "missing.txt")},
+		{path: filepath.Join(tmpDir, "single.txt")},
+		{path: filepath.Join(tmpDir, "docs")},
+	}
+	summary, err := transferSources(context.Background(), sources, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 source paths failed") {
+		t.Fatalf("expected 1 of 3 source paths to fail, got: %v", err)
+	}
+	if summary.successful != 3 || summary.failed != 1 {
+		t.Fatalf("expected 3 successful and 1 failed files, got %d and %d", summary.successful, summary.failed)
+	}
+	received := ms.receivedFiles()
+	if string(received["single.txt"]) != "single" || string(received["a.md"]) != "alpha" ||
+		string(received[filepath.Join("sub", "b.md")]) != "bravo" {
+		t.Fatalf("unexpected received files: %v", received)
+	}
+
+	withFlags(t, map[string]string{"fail-fast": "true"})
+	summary, err = transferSources(context.Background(), sources, nil)
+	if err == nil {
+		t.Fatal("expected an error with -fail-fast, got nil")
+	}
+	if summary.successful != 0 || summary.failed != 1 {
+		t.Fatalf("expected -fail-fast to stop after the first source path, got %+v", *summary)
+	}
+}
+
+// TestTransferSourcesTimeout tests `transferSources` with a context deadline, as set by -timeout, to ensure that
+// a directory transfer to a slow server is cancelled while waiting for a response and reports a timeout with the partial summary.
+func TestTransferSourcesTimeout(t *testing.T) {
+	withFlags(t, map[string]string{"quiet": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("content of "+name), 0644); err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+	}
+	ms := startMockServer(t)
+	ms.delay = 250 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	summary, err := transferSources(ctx, []sourcePath{{path: dir}}, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected a timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the transfer to be cancelled at the deadline, took %v", elapsed)
+	}
+
+	// The first file is answered before the deadline, and the second is cancelled while waiting for its response.
+	if summary.successful != 1 || summary.failed != 1 || len(summary.files) != 2 {
+		t.Fatalf("expected 1 successful and 1 failed file, got %+v", *summary)
+	}
+	if summary.files[0].Name != "a.txt" || summary.files[0].Status != FileStatusSent || summary.files[1].Status != FileStatusFailed {
+		t.Fatalf("unexpected file reports: %+v", summary.files)
+	}
+}
+
+// TestTransferStream tests `transferStream` to ensure that
+// content of unknown size is streamed to the server under the given name with its checksum.
+func TestTransferStream(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	ms := startMockServer(t)
+	content := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 100000)
+
+	// Hide the length of the content, as with a pipe.
+	summary, err := transferStream(context.Background(), io.MultiReader(bytes.NewReader(content)), "stdin", "mydb.sql", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != 1 || summary.totalBytes != int64(len(content)) {
+		t.Fatalf("expected 1 successful transfer of %d bytes, got %+v", len(content), *summary)
+	}
+	if summary.files[0].Checksum != hex.EncodeToString(protocol.CalculateDataChecksum(content)) {
+		t.Fatalf("unexpected checksum in the summary: %s", summary.files[0].Checksum)
+	}
+	if !bytes.Equal(ms.receivedFiles()["mydb.sql"], content) {
+		t.Fatalf("expected the streamed content to be received as mydb.sql")
+	}
+}
+
+// TestTransferStreamSize tests `transferStream` with an expected size to ensure that
+// a stream of that size is sent, while a shorter or longer one fails without being stored by the server.
+func TestTransferStreamSize(t *testing.T) {
+	withFlags(t, map[string]string{"quiet": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	ms := startMockServer(t)
+	content := bytes.Repeat([]byte("backup"), 10000)
+
+	tests := []struct {
+		name    string
+		size    uint64
+		wantErr string
+	}{
+		{"exact.tar.gz", uint64(len(content)), ""},
+		{"short.tar.gz", uint64(len(content)) + 1, "does not have the expected size"},
+		{"long.tar.gz", uint64(len(content)) - 1, "exceeds the expected size"},
+	}
+	for _, tt := range tests {
+		summary, err := transferStream(context.Background(), io.MultiReader(bytes.NewReader(content)), "stdin", tt.name, tt.size)
+		if tt.wantErr == "" {
+			if err != nil || summary.successful != 1 {
+				t.Fatalf("%s: expected a successful transfer, got %v and %+v", tt.name, err, *summary)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) || summary.failed != 1 {
+			t.Fatalf("%s: expected an error containing %q, got %v and %+v", tt.name, tt.wantErr, err, *summary)
+		}
+	}
+
+	// The failed streams are ended by closing the connection, which the server reads as an interrupted transfer.
+	received := ms.receivedFiles()
+	if !bytes.Equal(received["exact.tar.gz"], content) || len(received) != 1 {
+		t.Fatalf("expected only exact.tar.gz to be received, got %d files", len(received))
+	}
+}
+
+// TestTransferSourceStdin tests `transferSource` with "-" to ensure that
+// a payload piped to stdin arrives intact under the name given by -name.
+func TestTransferSourceStdin(t *testing.T) {
+	content := bytes.Repeat([]byte{0x1f, 0x8b, 0x08, 0x00}, 50000)
+	withFlags(t, map[string]string{"name": "backup.tar.gz", "size": strconv.Itoa(len(content)), "quiet": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create a pipe: %v", err)
+	}
+	originalStdin := os.Stdin
+	os.Stdin = reader
+	defer func() {
+		os.Stdin = originalStdin
+		_ = reader.Close()
+	}()
+	go func() {
+		_, _ = writer.Write(content)
+		_ = writer.Close()
+	}()
+
+	ms := startMockServer(t)
+	summary, err := transferSource(context.Background(), sourcePath{path: StdinPath}, nil)
+	if err != nil || summary.successful != 1 {
+		t.Fatalf("expected a successful transfer, got %v and %+v", err, *summary)
+	}
+	if !bytes.Equal(ms.receivedFiles()["backup.tar.gz"], content) {
+		t.Fatal("expected the piped content to be received as backup.tar.gz")
+	}
+}
+
+// TestProgressMode tests `progressMode` to ensure that -quiet turns off the progress output regardless of -progress.
+func TestProgressMode(t *testing.T) {
+	withFlags(t, map[string]string{})
+	if got := progressMode(); got != protocol.ProgressModeAuto {
+		t.Fatalf("expected the auto progress mode by default, got %q", got)
+	}
+
+	withFlags(t, map[string]string{"quiet": "true"})
+	if got := progressMode(); got != protocol.ProgressModeNone {
+		t.Fatalf("expected no progress with -quiet, got %q", got)
+	}
+}
+
+// TestTransferDirectorySync tests `transferDirectory` with -sync to ensure that
+// files the server already has are skipped and reported as unchanged, while new and changed files are uploaded.
+func TestTransferDirectorySync(t *testing.T) {
+	withFlags(t, map[string]string{"sync": "true"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"same.txt":        "unchanged content",
+		"sub/changed.txt": "new content",
+		"sub/new.txt":     "brand new",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	ms := startMockServer(t)
+	ms.store("same.txt", []byte(files["same.txt"]))
+	ms.store("sub/changed.txt", []byte("old content"))
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != 2 || summary.unchanged != 1 || summary.bytesSaved != int64(len(files["same.txt"])) {
+		t.Fatalf("expected 2 transferred and 1 unchanged file, got %+v", *summary)
+	}
+	if ms.uploads != 2 {
+		t.Fatalf("expected only the new and changed files to be uploaded, got %d uploads", ms.uploads)
+	}
+	if got := string(ms.receivedFiles()["sub/changed.txt"]); got != files["sub/changed.txt"] {
+		t.Fatalf("expected the changed file to be uploaded, got %q", got)
+	}
+
+	report := newTransferReport(summary, err)
+	if report.Skipped != 1 || report.Unchanged != 1 || report.BytesSaved != summary.bytesSaved || report.TotalFiles != 3 {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+	for _, file := range report.Files {
+		if file.Name == "same.txt" && (file.Status != FileStatusSkipped || file.ServerResponse != protocol.VerifyMessageMatch) {
+			t.Fatalf("expected same.txt to be reported as skipped, got %+v", file)
+		}
+	}
+}
+
+// TestQueryServerResponses tests `queryServer` to ensure that
+// only a match reports the file as unchanged, and an unexpected error response fails the query.
+func TestQueryServerResponses(t *testing.T) {
+	tests := []struct {
+		status        uint8
+		message       string
+		wantUnchanged bool
+		wantErr       bool
+	}{
+		{protocol.ResponseStatusSuccess, protocol.VerifyMessageMatch, true, false},
+		{protocol.ResponseStatusError, protocol.VerifyMessageMismatch, false, false},
+		{protocol.ResponseStatusError, protocol.VerifyMessageNotFound, false, false},
+		{protocol.ResponseStatusError, protocol.QueryMessageTooLarge, false, false},
+		{protocol.ResponseStatusError, "Invalid file path", false, true},
+	}
+
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     4,
+		FileName:     "file.txt",
+		Checksum:     protocol.CalculateDataChecksum([]byte("data")),
+		TransferType: protocol.TransferTypeFile,
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	for _, tt := range tests {
+		// A TCP connection is used rather than `net.Pipe`, which blocks on the zero-length write of the directory path.
+		go func() {
+			serverConn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer func() { _ = serverConn.Close() }()
+			query, err := protocol.ReadHeader(serverConn)
+			if err != nil || query.MessageType != protocol.MessageTypeQuery {
+				return
+			}
+			_ = protocol.WriteResponse(serverConn, tt.status, tt.message)
+		}()
+
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		unchanged, message, err := queryServer(clientConn, header)
+		_ = clientConn.Close()
+		if unchanged != tt.wantUnchanged || (err != nil) != tt.wantErr || message != tt.message {
+			t.Errorf("queryServer with %q = %v, %q, %v; want unchanged=%v, error=%v",
+				tt.message, unchanged, message, err, tt.wantUnchanged, tt.wantErr)
+		}
+	}
+	if header.MessageType != protocol.MessageTypeTransfer {
+		t.Fatal("expected the transfer header to be left unchanged")
+	}
+}
+
+// TestParseServerAddress tests `parseServerAddress` to ensure that
+// host names, IPv4 addresses, and bracketed or bare IPv6 addresses are normalized to a dialable address.
+func TestParseServerAddress(t *testing.T) {
+	tests := []struct {
+		addr     string
+		expected string
+	}{
+		{"localhost:8080", "localhost:8080"},
+		{"192.0.2.1:9090", "192.0.2.1:9090"},
+		{"[::1]:8080", "[::1]:8080"},
+		{"[fe80::1%eth0]:8080", "[fe80::1%eth0]:8080"},
+		{"[2001:db8::1]", "[2001:db8::1]:" + DefaultServerPort},
+		{"2001:db8::1", "[2001:db8::1]:" + DefaultServerPort},
+		{"example.com", "example.com:" + DefaultServerPort},
+		{"192.0.2.1", "192.0.2.1:" + DefaultServerPort},
+	}
+	for _, tt := range tests {
+		got, err := parseServerAddress(tt.addr)
+		if err != nil {
+			t.Fatalf("parseServerAddress(%q): unexpected error: %v", tt.addr, err)
+		}
+		if got != tt.expected {
+			t.Errorf("parseServerAddress(%q) = %q, expected %q", tt.addr, got, tt.expected)
+		}
+	}
+
+	for _, addr := range []string{"", ":8080", "[::1", "::1]:8080", "[localhost]:x", "host:0", "[::1]:65536", "a:b:c"} {
+		if got, err := parseServerAddress(addr); err == nil {
+			t.Errorf("parseServerAddress(%q) = %q, expected an error", addr, got)
+		}
+	}
+}
+
+// TestTransferSingleFileIPv6 tests `transferSingleFile` to ensure that
+// a file is transferred to a server given by a bracketed IPv6 address.
+func TestTransferSingleFileIPv6(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	listener, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	ms := serveMock(t, listener)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	*serverAddr, err = parseServerAddress("[::1]:" + strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "ipv6.txt")
+	if err := os.WriteFile(path, []byte("over IPv6"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if _, err := transferSingleFile(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := string(ms.receivedFiles()["ipv6.txt"]); got != "over IPv6" {
+		t.Fatalf("expected ipv6.txt on the server, got %q", got)
+	}
+}
+
+// TestTransferDirectoryCompressed tests the "-compress" option to ensure that
+// every file of a directory transfer arrives intact with each codec, including with -sync.
+func TestTransferDirectoryCompressed(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string][]byte{
+		"text.txt":  bytes.Repeat([]byte("compressible "), 10000),
+		"empty.txt": {},
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), content, 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	for _, codec := range []string{"gzip", "zstd"} {
+		withFlags(t, map[string]string{"compress": codec, "sync": "true"})
+		ms := startMockServer(t)
+		if _, err := transferDirectory(context.Background(), tmpDir, nil); err != nil {
+			t.Fatalf("%s: unexpected error: %v", codec, err)
+		}
+		for name, content := range files {
+			if got := ms.receivedFiles()[name]; !bytes.Equal(got, content) {
+				t.Fatalf("%s: expected %d bytes for %s, got %d", codec, len(content), name, len(got))
+			}
+		}
+	}
+}
+
+// TestTransferDirectoryRemoteDir tests `transferDirectory` to ensure that
+// the files are sent under the subdirectory given with -remote-dir, and a resent directory is found there.
+func TestTransferDirectoryRemoteDir(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "alpha", filepath.Join("sub", "b.txt"): "bravo"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	withFlags(t, map[string]string{"remote-dir": "backups/2024", "sync": "true"})
+	ms := startMockServer(t)
+	for range 2 {
+		if _, err := transferDirectory(context.Background(), tmpDir, nil); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	received := ms.receivedFiles()
+	if string(received["backups/2024/a.txt"]) != "alpha" || string(received["backups/2024/sub/b.txt"]) != "bravo" {
+		t.Fatalf("expected the files under backups/2024, got %v", received)
+	}
+	if ms.uploads != 2 {
+		t.Fatalf("expected the second transfer to find the files under backups/2024, got %d uploads", ms.uploads)
+	}
+}
+
+// TestTransferSingleFileRemoteDir tests `transferSingleFile` to ensure that
+// a single file is stored under the subdirectory given with -remote-dir, and that the server refuses one escaping its root.
+func TestTransferSingleFileRemoteDir(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("alpha"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if err := server.Flags.Set("progress", protocol.ProgressModeNone); err != nil {
+		t.Fatalf("failed to set the server flag: %v", err)
+	}
+	root := t.TempDir()
+	ts, err := server.StartTestServer(filepath.Join(root, "dest"))
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone, "remote-dir": "reports"})
+
+	if _, err := transferSingleFile(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(ts.Dir, "reports", "a.txt")); err != nil || string(got) != "alpha" {
+		t.Fatalf("expected reports/a.txt on the server, got %q and %v", got, err)
+	}
+
+	// A remote directory that -remote-dir validation would refuse is refused by the server as well.
+	withFlags(t, map[string]string{"remote-dir": "../escape"})
+	if _, err := transferSingleFile(context.Background(), path); err == nil || !strings.Contains(err.Error(), "invalid directory path") {
+		t.Fatalf("expected the server to refuse the traversal, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written outside the destination directory, got %v", err)
+	}
+}
+
+// TestTransferSingleFilePreserveTimes tests `transferSingleFile` to ensure that with "-preserve-times"
+// a server with "-strategy newer" replaces its copy with a newer file, which keeps its modification time, and keeps it otherwise.
+func TestTransferSingleFilePreserveTimes(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	for name, value := range map[string]string{"progress": protocol.ProgressModeNone, "strategy": "newer"} {
+		if err := server.Flags.Set(name, value); err != nil {
+			t.Fatalf("failed to set the server flag: %v", err)
+		}
+	}
+	defer func() { _ = server.Flags.Set("strategy", "rename") }()
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone, "preserve-times": "true"})
+
+	storedTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	stored := filepath.Join(ts.Dir, "a.txt")
+	if err := os.WriteFile(stored, []byte("stored"), 0644); err != nil {
+		t.Fatalf("failed to create the stored file: %v", err)
+	}
+	if err := os.Chtimes(stored, storedTime, storedTime); err != nil {
+		t.Fatalf("failed to set the modification time: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	for _, tt := range []struct {
+		modTime  time.Time
+		expected string
+	}{
+		{storedTime.Add(-time.Hour), "stored"},
+		{storedTime, "stored"},
+		{storedTime.Add(time.Hour), "local"},
+	} {
+		if err := os.WriteFile(path, []byte("local"), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		if err := os.Chtimes(path, tt.modTime, tt.modTime); err != nil {
+			t.Fatalf("failed to set the modification time: %v", err)
+		}
+		if _, err := transferSingleFile(context.Background(), path); err != nil && !errors.Is(err, ErrServerSkipped) {
+			t.Fatalf("unexpected error for the time %v: %v", tt.modTime, err)
+		}
+		if got, err := os.ReadFile(stored); err != nil || string(got) != tt.expected {
+			t.Fatalf("expected %q on the server for the time %v, got %q and %v", tt.expected, tt.modTime, got, err)
+		}
+	}
+	info, err := os.Stat(stored)
+	if err != nil || !info.ModTime().Equal(storedTime.Add(time.Hour)) {
+		t.Fatalf("expected the stored file to keep the time of the local one, got %v", err)
+	}
+}
+
+// TestTransferMisdirectedPaths tests `transferFile`, `transferSingleFile`, `transferDirectory`, and `transferArchive`
+// to ensure that a directory passed where a file is expected fails with `ErrIsDirectory`, and a file passed where
+// a directory is expected with `ErrNotDirectory`, before anything is sent.
+func TestTransferMisdirectedPaths(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(file, []byte("alpha"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	// Nothing listens on the address, so a transfer that went as far as connecting would fail otherwise.
+	withFlags(t, map[string]string{"server": "127.0.0.1:1", "progress": protocol.ProgressModeNone})
+
+	serverConn, clientConn := net.Pipe()
+	defer func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	}()
+	if _, _, err := transferFile(context.Background(), slog.Default(), clientConn, dir, "", nil, nil); !errors.Is(err, ErrIsDirectory) {
+		t.Fatalf("expected transferFile to fail with ErrIsDirectory, got %v", err)
+	}
+	if _, err := transferSingleFile(context.Background(), dir); !errors.Is(err, ErrIsDirectory) {
+		t.Fatalf("expected transferSingleFile to fail with ErrIsDirectory, got %v", err)
+	}
+
+	if _, err := transferDirectory(context.Background(), file, nil); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("expected transferDirectory to fail with ErrNotDirectory, got %v", err)
+	}
+	if _, err := transferArchive(context.Background(), file, nil); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("expected transferArchive to fail with ErrNotDirectory, got %v", err)
+	}
+	if _, err := transferDirectory(context.Background(), filepath.Join(dir, "missing"), nil); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected transferDirectory to fail with ErrFileNotFound, got %v", err)
+	}
+}
+
+// TestTransferSourcesStopsOnServerShutdown tests `transferSources` to ensure that
+// the transfer stops at the first file refused by a server shutting down, and reports the shutdown.
+func TestTransferSourcesStopsOnServerShutdown(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	other := filepath.Join(t.TempDir(), "other.txt")
+	if err := os.WriteFile(other, []byte("other"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ms := startMockServer(t)
+	ms.shutdownAfter = 1
+
+	summary, err := transferSources(context.Background(), expandSourcePaths([]string{tmpDir, other}), nil)
+	if !errors.Is(err, ErrServerShutdown) || !summary.serverShutdown {
+		t.Fatalf("expected the transfer to fail with ErrServerShutdown, got %v", err)
+	}
+	if summary.successful != 1 || summary.failed != 1 {
+		t.Fatalf("expected 1 successful and 1 failed transfer before stopping, got %d and %d", summary.successful, summary.failed)
+	}
+	if received := ms.receivedFiles(); len(received) != 1 {
+		t.Fatalf("expected 1 file on the server, got %v", received)
+	}
+}
+
+// TestTransferDirectorySyncManifest tests `transferDirectory` with -sync against a server that accepts manifests
+// to ensure that the manifest is sent up front, and that only the files the server needs are uploaded, none of them queried.
+func TestTransferDirectorySyncManifest(t *testing.T) {
+	withFlags(t, map[string]string{"sync": "true", "remote-dir": "backup"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"same.txt":        "unchanged content",
+		"sub/same.txt":    "unchanged nested content",
+		"sub/changed.txt": "new content",
+		"new.txt":         "brand new",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	ms := startMockServer(t)
+	withServerInfo(ms, 1<<30, 1<<30, 0)
+	ms.info.Manifest = true
+	ms.store("backup/same.txt", []byte(files["same.txt"]))
+	ms.store("backup/sub/same.txt", []byte(files["sub/same.txt"]))
+	ms.store("backup/sub/changed.txt", []byte("old content"))
+
+	summary, err := transferDirectory(context.Background(), tmpDir, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bytesSaved := int64(len(files["same.txt"]) + len(files["sub/same.txt"]))
+	if summary.successful != 2 || summary.unchanged != 2 || summary.bytesSaved != bytesSaved {
+		t.Fatalf("expected 2 transferred and 2 unchanged files, got %+v", *summary)
+	}
+	if ms.manifests != 1 || ms.queries != 0 {
+		t.Fatalf("expected 1 manifest and no queries, got %d manifests and %d queries", ms.manifests, ms.queries)
+	}
+	if ms.uploads != 2 {
+		t.Fatalf("expected only the new and changed files to be uploaded, got %d uploads", ms.uploads)
+	}
+	received := ms.receivedFiles()
+	for _, name := range []string{"sub/changed.txt", "new.txt"} {
+		if got := string(received["backup/"+name]); got != files[name] {
+			t.Fatalf("expected %s to be uploaded, got %q", name, got)
+		}
+	}
+	for _, file := range newTransferReport(summary, err).Files {
+		if strings.HasSuffix(file.Name, "same.txt") && (file.Status != FileStatusSkipped || file.ServerResponse != protocol.VerifyMessageMatch) {
+			t.Fatalf("expected %s to be reported as skipped, got %+v", file.Name, file)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Statuses of a file in a manifest check, printed at the start of its report line.
+const (
+	ManifestStatusOK       = "OK"       // The file matches its checksum in the manifest.
+	ManifestStatusMismatch = "MISMATCH" // The file differs from its checksum in the manifest.
+	ManifestStatusMissing  = "MISSING"  // The file is in the manifest but not in the directory.
+	ManifestStatusExtra    = "EXTRA"    // The file is in the directory but not in the manifest.
+	ManifestStatusFailed   = "FAILED"   // The file could not be read.
+)
+
+// manifestSummary summarizes the outcome of a manifest check (-checksum-only).
+type manifestSummary struct {
+	matched    int // Number of files that match their checksums.
+	mismatched int // Number of files that differ from their checksums.
+	missing    int // Number of files of the manifest missing in the directory.
+	extra      int // Number of files of the directory missing in the manifest.
+	failed     int // Number of files that could not be read.
+}
+
+// readManifest reads a manifest of relative paths and hex-encoded SHA-256 checksums, either in the format of
+// `sha256sum` ("<checksum>  <path>" per line) or as the JSON plan printed by -plan -plan-checksums.
+// It returns the checksums by slash-separated relative path.
+func readManifest(r io.Reader) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the manifest: %v", err)
+	}
+
+	manifest := make(map[string]string)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var plan protocol.TransferPlan
+		if err := json.Unmarshal(trimmed, &plan); err != nil {
+			return nil, fmt.Errorf("invalid JSON manifest: %v", err)
+		}
+		for _, file := range plan.Files {
+			if err := addManifestEntry(manifest, file.Path, file.Checksum); err != nil {
+				return nil, err
+			}
+		}
+		return manifest, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		checksum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid manifest line %d: expected \"<checksum>  <path>\"", lineNumber)
+		}
+		// `sha256sum` separates the path with a space and a space (text mode) or an asterisk (binary mode).
+		name = strings.TrimPrefix(strings.TrimPrefix(name, " "), "*")
+		if err := addManifestEntry(manifest, name, checksum); err != nil {
+			return nil, fmt.Errorf("invalid manifest line %d: %v", lineNumber, err)
+		}
+	}
+	return manifest, nil
+}
+
+// addManifestEntry adds the checksum of a path to the manifest, after validating both.
+func addManifestEntry(manifest map[string]string, name, checksum string) error {
+	decoded, err := hex.DecodeString(checksum)
+	if err != nil || len(decoded) != protocol.ChecksumSize {
+		return fmt.Errorf("invalid SHA-256 checksum %q for %s", checksum, name)
+	}
+	name = path.Clean(filepath.ToSlash(name))
+	if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return fmt.Errorf("invalid path %q: must be relative to the directory", name)
+	}
+	if _, ok := manifest[name]; ok {
+		return fmt.Errorf("duplicate path %s", name)
+	}
+	manifest[name] = strings.ToLower(checksum)
+	return nil
+}
+
+// checkManifest compares the files of the directory with the manifest without any network,
+// and prints a report line per file to `w`. Every file of the manifest is checked, while only the files
+// selected for a transfer (with the filter and the ignore file) are reported as extra.
+func checkManifest(w io.Writer, dirPath string, manifest map[string]string, filter *protocol.PathFilter) (*manifestSummary, error) {
+	summary := &manifestSummary{}
+
+	plan, err := planDirectory(dirPath, filter, false)
+	if err != nil {
+		return summary, fmt.Errorf("failed to walk the directory %s: %v", dirPath, err)
+	}
+	// Report the files of both the directory and the manifest, in path order.
+	names := make(map[string]bool, len(manifest))
+	for name := range manifest {
+		names[name] = true
+	}
+	for _, file := range plan.Files {
+		names[file.Path] = true
+	}
+	for _, file := range plan.TooLarge {
+		names[file.Path] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	slices.Sort(sorted)
+
+	for _, name := range sorted {
+		expected, inManifest := manifest[name]
+		status := ManifestStatusOK
+		switch {
+		case !inManifest:
+			status = ManifestStatusExtra
+			summary.extra++
+		default:
+			checksum, err := protocol.CalculateFileChecksumFromPath(context.Background(), filepath.Join(dirPath, filepath.FromSlash(name)))
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				status = ManifestStatusMissing
+				summary.missing++
+			case err != nil:
+				status = ManifestStatusFailed
+				summary.failed++
+			case hex.EncodeToString(checksum) != expected:
+				status = ManifestStatusMismatch
+				summary.mismatched++
+			default:
+				summary.matched++
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s %s\n", status, name); err != nil {
+			return summary, err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "Manifest check summary: %d matched, %d mismatched, %d missing, %d extra, %d failed\n",
+		summary.matched, summary.mismatched, summary.missing, summary.extra, summary.failed); err != nil {
+		return summary, err
+	}
+
+	if summary.mismatched+summary.missing+summary.extra+summary.failed > 0 {
+		return summary, fmt.Errorf("the directory %s does not match the manifest: %d mismatched, %d missing, %d extra, and %d failed files",
+			dirPath, summary.mismatched, summary.missing, summary.extra, summary.failed)
+	}
+	return summary, nil
+}
+
+// checkManifestFile checks the directory against the manifest file (-checksum-only) and prints the report to stdout.
+func checkManifestFile(manifestPath, dirPath string, filter *protocol.PathFilter) error {
+	file, err := os.Open(manifestPath)
+	if err != nil {
+		return fmt.Errorf("failed to open the manifest: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	manifest, err := readManifest(file)
+	if err != nil {
+		return fmt.Errorf("%s: %v", manifestPath, err)
+	}
+	_, err = checkManifest(os.Stdout, dirPath, manifest, filter)
+	return err
+}
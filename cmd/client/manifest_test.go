@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// createManifestTree creates a directory with the given files and returns its path
+// along with a manifest of their checksums in the format of `sha256sum`.
+func createManifestTree(t *testing.T, files map[string]string) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	var manifest strings.Builder
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+		manifest.WriteString(hex.EncodeToString(protocol.CalculateDataChecksum([]byte(content))) + "  " + name + "\n")
+	}
+	return dir, manifest.String()
+}
+
+// runManifestCheck checks the directory against the manifest and returns the report and the error.
+func runManifestCheck(t *testing.T, dir, manifestContent string) (string, *manifestSummary, error) {
+	t.Helper()
+
+	manifest, err := readManifest(strings.NewReader(manifestContent))
+	if err != nil {
+		t.Fatalf("failed to read the manifest: %v", err)
+	}
+	var out bytes.Buffer
+	summary, err := checkManifest(&out, dir, manifest, nil)
+	return out.String(), summary, err
+}
+
+// TestCheckManifestMatching tests `checkManifest` to ensure that
+// a directory matching its manifest is reported as such.
+func TestCheckManifestMatching(t *testing.T) {
+	dir, manifest := createManifestTree(t, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+
+	report, summary, err := runManifestCheck(t, dir, manifest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(report, "OK a.txt\nOK sub/b.txt\n") || summary.matched != 2 {
+		t.Fatalf("expected two matching files, got:\n%s", report)
+	}
+}
+
+// TestCheckManifestCorruptedFile tests `checkManifest` to ensure that
+// a file whose content changed is reported as a mismatch.
+func TestCheckManifestCorruptedFile(t *testing.T) {
+	dir, manifest := createManifestTree(t, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+	if err := os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("bet4"), 0644); err != nil {
+		t.Fatalf("failed to corrupt file: %v", err)
+	}
+
+	report, summary, err := runManifestCheck(t, dir, manifest)
+	if err == nil || !strings.Contains(err.Error(), "1 mismatched") {
+		t.Fatalf("expected a mismatch error, got %v", err)
+	}
+	if !strings.Contains(report, "OK a.txt\n") || !strings.Contains(report, "MISMATCH sub/b.txt\n") || summary.mismatched != 1 {
+		t.Fatalf("expected sub/b.txt to be reported as a mismatch, got:\n%s", report)
+	}
+}
+
+// TestCheckManifestMissingAndExtraFiles tests `checkManifest` to ensure that
+// files missing in the directory or in the manifest are reported.
+func TestCheckManifestMissingAndExtraFiles(t *testing.T) {
+	dir, manifest := createManifestTree(t, map[string]string{"a.txt": "alpha", "b.txt": "beta"})
+	if err := os.Remove(filepath.Join(dir, "b.txt")); err != nil {
+		t.Fatalf("failed to remove file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	report, summary, err := runManifestCheck(t, dir, manifest)
+	if err == nil {
+		t.Fatal("expected error for missing and extra files, got nil")
+	}
+	expected := "OK a.txt\nMISSING b.txt\nEXTRA new.txt\n"
+	if !strings.HasPrefix(report, expected) || summary.missing != 1 || summary.extra != 1 {
+		t.Fatalf("expected the report to start with:\n%s\ngot:\n%s", expected, report)
+	}
+}
+
+// TestReadManifest tests `readManifest` to ensure that
+// both the `sha256sum` format and the JSON plan are read, and invalid entries are rejected.
+func TestReadManifest(t *testing.T) {
+	checksum := hex.EncodeToString(protocol.CalculateDataChecksum([]byte("x")))
+
+	manifest, err := readManifest(strings.NewReader("# comment\n" + checksum + " *./bin/x\n" + strings.ToUpper(checksum) + "  y z.txt\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if manifest["bin/x"] != checksum || manifest["y z.txt"] != checksum {
+		t.Fatalf("unexpected manifest %v", manifest)
+	}
+
+	manifest, err = readManifest(strings.NewReader(`{"files": [{"path": "a/b.txt", "size": 1, "checksum": "` + checksum + `"}]}`))
+	if err != nil || manifest["a/b.txt"] != checksum {
+		t.Fatalf("expected the files of the JSON plan, got %v and %v", manifest, err)
+	}
+
+	for _, content := range []string{"nochecksum\n", "abcd  a.txt\n", checksum + "  ../escape.txt\n", checksum + "  a\n" + checksum + "  ./a\n"} {
+		if _, err := readManifest(strings.NewReader(content)); err == nil {
+			t.Fatalf("expected error for the manifest %q, got nil", content)
+		}
+	}
+}
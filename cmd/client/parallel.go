@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Constants for sending a file as byte ranges over several connections (-parallel-streams).
+const (
+	MaxParallelStreams = 16              // Maximum number of connections a file is sent over.
+	RangeRetries       = 3               // Number of times a failed range is sent again before the transfer fails.
+	RangeChunkSize     = 4 * 1024 * 1024 // Bytes of a range copied at a time, between which the progress is updated (4MB).
+)
+
+// MinRangeSize is the smallest range a file is split into with -parallel-streams (16MB), so that a file too small
+// to gain from more connections is sent over fewer.
+// It's defined as a variable to allow modification during testing, although it should remain constant in practice.
+var MinRangeSize uint64 = 16 * 1024 * 1024
+
+// rangeProgress is the progress of a file sent as ranges, shared by the goroutines sending them.
+type rangeProgress struct {
+	mutex   sync.Mutex
+	tracker *protocol.ProgressTracker
+	sent    uint64
+}
+
+// add counts `n` more bytes sent, or, if negative, fewer (of a range that is sent again).
+func (rp *rangeProgress) add(n int64) {
+	rp.mutex.Lock()
+	defer rp.mutex.Unlock()
+	rp.sent = uint64(int64(rp.sent) + n)
+	rp.tracker.Update(rp.sent)
+}
+
+// transferRanges transfers a single file as `streams` contiguous byte ranges (`protocol.TransferTypeRange`),
+// each sent over a connection of its own, and returns its checksum and the server's response.
+// A range that fails is sent again up to `RangeRetries` times, without the others. The server answers the range
+// that completes the file once it has verified the checksum of the whole file, like the transfer of a whole file.
+func transferRanges(ctx context.Context, logger *slog.Logger, filePath string, streams int) ([]byte, transferResponse, error) {
+	fileName := filepath.Base(filePath)
+	statInfo, err := os.Stat(filePath)
+	if err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to get file information for %s: %v", filePath, err)
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to open file %s: %v", filePath, err)
+	}
+	fmt.Fprintf(statusOutput, "Calculating the file checksum...\n")
+	checksum, err := fileChecksum(ctx, filePath, statInfo, file)
+	if closeErr := file.Close(); closeErr != nil {
+		logger.Warn("Error closing the file", "path", filePath, "error", closeErr)
+	}
+	if err != nil {
+		return nil, transferResponse{}, fmt.Errorf("failed to calculate the file checksum: %v", err)
+	}
+	fmt.Fprintf(statusOutput, "File checksum: %x\n", checksum)
+
+	header := protocol.Header{
+		MessageType:   protocol.MessageTypeTransfer,
+		FileSize:      uint64(statInfo.Size()),
+		FileName:      fileName,
+		Checksum:      checksum,
+		TransferType:  protocol.TransferTypeRange,
+		DirectoryPath: *remoteDir,
+	}
+	if *preserveTimes {
+		header.ModTime = statInfo.ModTime()
+	}
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), header.FileSize, streams, MinRangeSize)
+	logger = logger.With("file_name", fileName, "range_id", ranges[0].ID)
+	fmt.Fprintf(statusOutput, "Starting file transfer: %s (%d bytes) over %d connections\n", fileName, header.FileSize, len(ranges))
+
+	startTime := time.Now()
+	progress := &rangeProgress{
+		tracker: protocol.NewProgressTracker(header.FileSize, fmt.Sprintf("Uploading %s", fileName), os.Stderr, progressMode()),
+	}
+
+	responses := make([]transferResponse, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, byteRange := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rangeHeader := header
+			rangeHeader.Range = &byteRange
+			rangeLogger := logger.With("offset", byteRange.Offset, "bytes", byteRange.Length)
+			for attempt := 0; ; attempt++ {
+				var sent int64
+				sent, responses[i], errs[i] = sendRange(ctx, filePath, &rangeHeader, progress)
+				if errs[i] == nil || !retryable(errs[i]) || attempt == RangeRetries || ctx.Err() != nil {
+					return
+				}
+				rangeLogger.Warn("Failed to send the range, sending it again", "attempt", attempt+1, "error", errs[i])
+				progress.add(-sent)
+			}
+		}()
+	}
+	wg.Wait()
+	progress.tracker.Complete()
+
+	// The response to the range that completed the file is the only one that is not `protocol.RangeReceivedMessage`.
+	var response transferResponse
+	for i, err := range errs {
+		if err != nil {
+			errs[i] = fmt.Errorf("range at offset %d: %w", ranges[i].Offset, err)
+			if !errors.Is(err, ErrServerSkipped) {
+				continue
+			}
+		}
+		if responses[i].message != protocol.RangeReceivedMessage {
+			response = responses[i]
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, response, fmt.Errorf("failed to send the file over %d connections: %w", len(ranges), err)
+	}
+	if response.message == "" {
+		return nil, transferResponse{}, fmt.Errorf("the server received all ranges of %s without completing the file", fileName)
+	}
+	if err := checkResponseChecksum(response, checksum); err != nil {
+		return nil, response, err
+	}
+
+	transferDuration := time.Since(startTime)
+	logger.Info("File sent successfully!", "bytes", header.FileSize, "connections", len(ranges),
+		"duration_ms", transferDuration.Milliseconds(), "rate_mb_s", float64(header.FileSize)/max(transferDuration.Seconds(), 1e-9)/1024/1024,
+		"response", response.message)
+	if response.storedName != "" {
+		logger.Info("The server stored the file under another name", "stored_name", response.storedName)
+	}
+	return checksum, response, nil
+}
+
+// sendRange sends the range of the header over a connection of its own, and returns the number of bytes sent
+// and the server's response (which is also returned along with the error if the server rejects the range).
+// The content is copied from the file to a plain TCP connection with sendfile (see `newContextWriter`).
+func sendRange(ctx context.Context, filePath string, header *protocol.Header, progress *rangeProgress) (int64, transferResponse, error) {
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		return 0, transferResponse{}, fmt.Errorf("failed to establish TCP connection to the server: %v", err)
+	}
+	stopTimeout := closeOnTimeout(ctx, conn)
+	defer func() {
+		stopTimeout()
+		_ = conn.Close()
+	}()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, transferResponse{}, fmt.Errorf("failed to open file %s: %v", filePath, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if _, err := file.Seek(int64(header.Range.Offset), io.SeekStart); err != nil {
+		return 0, transferResponse{}, fmt.Errorf("failed to seek to the range: %v", err)
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return 0, transferResponse{}, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+	if err := protocol.WriteHeader(conn, header); err != nil {
+		return 0, transferResponse{}, fmt.Errorf("failed to send the range header: %v", err)
+	}
+
+	sent, err := copyRange(newContextWriter(ctx, conn), file, int64(header.Range.Length), progress)
+	if err != nil {
+		return sent, transferResponse{}, fmt.Errorf("failed to send the range content: %w", err)
+	}
+	response, err := readRangeResponse(conn, header)
+	if err != nil {
+		return sent, response, fmt.Errorf("failed to read server response: %w", err)
+	}
+	return sent, response, nil
+}
+
+// copyRange copies `length` bytes from the file to the writer by chunks of `RangeChunkSize`, as an `io.LimitedReader`
+// of the file, which the `ReadFrom` of a TCP connection copies in the kernel, and adds each chunk to the progress.
+func copyRange(w io.Writer, file *os.File, length int64, progress *rangeProgress) (int64, error) {
+	var sent int64
+	for sent < length {
+		n, err := io.Copy(w, &io.LimitedReader{R: file, N: min(RangeChunkSize, length-sent)})
+		sent += n
+		progress.add(n)
+		if err != nil {
+			return sent, err
+		}
+		if n == 0 {
+			return sent, io.ErrUnexpectedEOF
+		}
+	}
+	return sent, nil
+}
+
+// readRangeResponse reads the server's response to a range like `readServerResponseMessage`, but waits for the range
+// that completes the file for as long as the server may take to verify the whole file: until the connection is closed
+// (or found dead by the TCP keep-alive probes), or the -timeout deadline passes.
+func readRangeResponse(conn net.Conn, header *protocol.Header) (transferResponse, error) {
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return transferResponse{}, fmt.Errorf("failed to clear the read deadline: %w", err)
+	}
+	status, code, message, receipt, err := protocol.ReadReceiptResponse(conn)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return transferResponse{}, fmt.Errorf("server closed connection unexpectedly")
+		}
+		return transferResponse{}, fmt.Errorf("failed to read the server response: %w", err)
+	}
+	if status != protocol.ResponseStatusSuccess {
+		return transferResponse{message: message}, responseError(status, code, message)
+	}
+	return newTransferResponse(header, message, receipt), nil
+}
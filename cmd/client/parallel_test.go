@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"filexfer/protocol"
+	"filexfer/server"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTransferSingleFileParallelStreams tests `transferSingleFile` to ensure that
+// with -parallel-streams, a file is sent as ranges over several connections and assembled by the server,
+// which leaves no partial file behind.
+func TestTransferSingleFileParallelStreams(t *testing.T) {
+	originalThreshold, originalMinRange := ServerInfoThreshold, MinRangeSize
+	ServerInfoThreshold, MinRangeSize = 0, 64*1024
+	t.Cleanup(func() { ServerInfoThreshold, MinRangeSize = originalThreshold, originalMinRange })
+
+	content := make([]byte, 1024*1024+3)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range content {
+		content[i] = byte(rng.UintN(256))
+	}
+	path := filepath.Join(t.TempDir(), "large.bin")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if err := server.Flags.Set("progress", protocol.ProgressModeNone); err != nil {
+		t.Fatalf("failed to set the server flag: %v", err)
+	}
+	ts, err := server.StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the server: %v", err)
+	}
+	defer func() { _ = ts.Close() }()
+	withFlags(t, map[string]string{"server": ts.Addr, "progress": protocol.ProgressModeNone, "parallel-streams": "4"})
+
+	summary, err := transferSingleFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if summary.successful != 1 {
+		t.Fatalf("expected a successful transfer, got %+v", summary)
+	}
+	got, err := os.ReadFile(filepath.Join(ts.Dir, "large.bin"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the assembled file to match the original, got %d bytes and %v", len(got), err)
+	}
+	parts, err := filepath.Glob(filepath.Join(ts.Dir, ".filexfer-range-*"))
+	if err != nil || len(parts) != 0 {
+		t.Fatalf("expected no partial file to be left, got %v and %v", parts, err)
+	}
+}
+
+// TestTransferSingleFileParallelStreamsFallback tests `transferSingleFile` to ensure that
+// with -parallel-streams, a file is sent over a single connection to a server that does not accept ranges.
+func TestTransferSingleFileParallelStreamsFallback(t *testing.T) {
+	originalThreshold := ServerInfoThreshold
+	ServerInfoThreshold = 0
+	t.Cleanup(func() { ServerInfoThreshold = originalThreshold })
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("some content"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ms := startMockServer(t)
+	withServerInfo(ms, 1024, 1024, 0)
+	withFlags(t, map[string]string{"parallel-streams": "4"})
+	if _, err := transferSingleFile(context.Background(), path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(ms.receivedFiles()["file.txt"]) != "some content" {
+		t.Fatalf("expected the file on the server, got %v", ms.receivedFiles())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Constants for hashing the files of a directory ahead of their uploads.
+const (
+	ChecksumWorkers   = 2 // Number of files hashed at the same time.
+	ChecksumLookahead = 4 // Maximum number of files hashed (or being hashed) ahead of the one being uploaded.
+)
+
+// A hashedFile is the checksum of a file computed ahead of its upload, as of its size and modification time.
+type hashedFile struct {
+	size     int64     // Size of the file in bytes when it was hashed.
+	modTime  time.Time // Modification time of the file when it was hashed.
+	checksum []byte    // SHA-256 checksum of the file.
+	err      error     // Error opening or hashing the file, if any.
+	wanted   bool      // Whether the server asked for the file in its reply to a manifest, so that it is not queried again.
+}
+
+// checksumFor returns the checksum if the file still has the size and modification time it was hashed at,
+// or nil if it changed since (or failed to hash), so that the caller hashes it again.
+func (hf *hashedFile) checksumFor(size int64, modTime time.Time) []byte {
+	if hf == nil || hf.err != nil || hf.size != size || !hf.modTime.Equal(modTime) {
+		return nil
+	}
+	return hf.checksum
+}
+
+// A checksumPipeline hashes a list of files on a pool of goroutines, at most `lookahead` files ahead of the consumer,
+// so that the next files are hashed while the current one is uploaded. The results are consumed in order with `next`.
+type checksumPipeline struct {
+	results []chan hashedFile // Result of each file, buffered so that the workers never wait for the consumer.
+	slots   chan struct{}     // One token per file hashed but not consumed yet, bounding the lookahead.
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// startChecksumPipeline starts hashing the files at `paths` on `workers` goroutines, at most `lookahead` files ahead.
+// Hashing stops when the context is canceled or the pipeline is stopped.
+func startChecksumPipeline(ctx context.Context, paths []string, workers, lookahead int) *checksumPipeline {
+	ctx, cancel := context.WithCancel(ctx)
+	cp := &checksumPipeline{
+		results: make([]chan hashedFile, len(paths)),
+		slots:   make(chan struct{}, max(lookahead, 1)),
+		cancel:  cancel,
+	}
+	for i := range cp.results {
+		cp.results[i] = make(chan hashedFile, 1)
+	}
+
+	// The files are handed out in order, each once a slot is free.
+	jobs := make(chan int)
+	cp.wg.Add(1)
+	go func() {
+		defer cp.wg.Done()
+		defer close(jobs)
+		for i := range paths {
+			select {
+			case cp.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for range max(workers, 1) {
+		cp.wg.Add(1)
+		go func() {
+			defer cp.wg.Done()
+			for i := range jobs {
+				cp.results[i] <- hashFile(ctx, paths[i])
+			}
+		}()
+	}
+	return cp
+}
+
+// next waits for the result of the file at the index, which must be consumed in order, and frees its slot.
+// A canceled context returns its error as the result.
+func (cp *checksumPipeline) next(ctx context.Context, i int) hashedFile {
+	select {
+	case result := <-cp.results[i]:
+		<-cp.slots
+		return result
+	case <-ctx.Done():
+		return hashedFile{err: ctx.Err()}
+	}
+}
+
+// stop cancels the hashing still in progress and waits for the goroutines to exit.
+func (cp *checksumPipeline) stop() {
+	cp.cancel()
+	cp.wg.Wait()
+}
+
+// hashFile computes the checksum of the file at the path (through the "-checksum-cache" if any), with its size and modification time.
+func hashFile(ctx context.Context, path string) hashedFile {
+	file, err := os.Open(path)
+	if err != nil {
+		return hashedFile{err: fmt.Errorf("failed to open file %s: %v", path, err)}
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	info, err := file.Stat()
+	if err != nil {
+		return hashedFile{err: fmt.Errorf("failed to get file information for %s: %v", path, err)}
+	}
+	checksum, err := fileChecksum(ctx, path, info, file)
+	if err != nil {
+		return hashedFile{err: err}
+	}
+	return hashedFile{size: info.Size(), modTime: info.ModTime(), checksum: checksum}
+}
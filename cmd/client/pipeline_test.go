@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createHashFiles creates `count` files of `size` bytes with distinct contents in a temporary directory and returns their paths.
+func createHashFiles(tb testing.TB, count, size int) []string {
+	tb.Helper()
+
+	dir := tb.TempDir()
+	paths := make([]string, count)
+	for i := range paths {
+		content := bytes.Repeat([]byte{byte(i)}, size)
+		paths[i] = filepath.Join(dir, fmt.Sprintf("file%03d.bin", i))
+		if err := os.WriteFile(paths[i], content, 0644); err != nil {
+			tb.Fatalf("failed to create file: %v", err)
+		}
+	}
+	return paths
+}
+
+// TestChecksumPipelineInOrder tests `checksumPipeline` to ensure that
+// the checksums computed by several workers are returned in the order of the files.
+func TestChecksumPipelineInOrder(t *testing.T) {
+	paths := createHashFiles(t, 20, 1000)
+
+	pipeline := startChecksumPipeline(context.Background(), paths, 3, 2)
+	defer pipeline.stop()
+	for i, path := range paths {
+		result := pipeline.next(context.Background(), i)
+		if result.err != nil {
+			t.Fatalf("unexpected error for %s: %v", path, result.err)
+		}
+		expected := protocol.CalculateDataChecksum(bytes.Repeat([]byte{byte(i)}, 1000))
+		if !bytes.Equal(result.checksum, expected) || result.size != 1000 {
+			t.Fatalf("expected the checksum %x of 1000 bytes for %s, got %x of %d bytes", expected, path, result.checksum, result.size)
+		}
+	}
+}
+
+// TestChecksumPipelineLookahead tests `checksumPipeline` to ensure that
+// no more than `lookahead` files are hashed ahead of the consumer.
+func TestChecksumPipelineLookahead(t *testing.T) {
+	paths := createHashFiles(t, 10, 100)
+
+	pipeline := startChecksumPipeline(context.Background(), paths, 4, 3)
+	defer pipeline.stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pipeline.results[2]) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the first 3 files to be hashed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	for i := 3; i < len(paths); i++ {
+		if len(pipeline.results[i]) != 0 {
+			t.Fatalf("expected file %d not to be hashed before the first one is consumed", i)
+		}
+	}
+
+	// Consuming a result frees a slot for the next file.
+	pipeline.next(context.Background(), 0)
+	if result := pipeline.next(context.Background(), 3); result.err != nil || result.size != 100 {
+		t.Fatalf("expected file 3 to be hashed once a slot is free, got %+v", result)
+	}
+}
+
+// TestChecksumPipelineCanceled tests `checksumPipeline` to ensure that
+// a canceled context ends the hashing and is returned as the result.
+func TestChecksumPipelineCanceled(t *testing.T) {
+	paths := createHashFiles(t, 10, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pipeline := startChecksumPipeline(ctx, paths, 2, 1)
+	cancel()
+
+	stopped := make(chan struct{})
+	go func() {
+		pipeline.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the pipeline to stop once the context is canceled")
+	}
+	if result := pipeline.next(ctx, len(paths)-1); !errors.Is(result.err, context.Canceled) {
+		t.Fatalf("expected the cancellation as the result, got %+v", result)
+	}
+}
+
+// TestHashedFileChecksumFor tests `checksumFor` to ensure that
+// a checksum computed ahead is only used for a file that has not changed since.
+func TestHashedFileChecksumFor(t *testing.T) {
+	modTime := time.Now()
+	hashed := &hashedFile{size: 10, modTime: modTime, checksum: []byte("checksum")}
+
+	if checksum := hashed.checksumFor(10, modTime); !bytes.Equal(checksum, []byte("checksum")) {
+		t.Fatalf("expected the checksum of an unchanged file, got %q", checksum)
+	}
+	if checksum := hashed.checksumFor(11, modTime); checksum != nil {
+		t.Fatal("expected no checksum for a file whose size changed")
+	}
+	if checksum := hashed.checksumFor(10, modTime.Add(time.Second)); checksum != nil {
+		t.Fatal("expected no checksum for a file modified since")
+	}
+	if checksum := (&hashedFile{err: errors.New("failed")}).checksumFor(0, time.Time{}); checksum != nil {
+		t.Fatal("expected no checksum for a file that failed to hash")
+	}
+	if checksum := (*hashedFile)(nil).checksumFor(10, modTime); checksum != nil {
+		t.Fatal("expected no checksum without a file hashed ahead")
+	}
+}
+
+// BenchmarkChecksumPipeline compares hashing each file of a directory of medium-sized files before sending it
+// with hashing them ahead on a `checksumPipeline`, sending the files over a loopback connection
+// whose receiver reads at a bounded rate like a network link, so that the uploads take time that hashing can overlap.
+func BenchmarkChecksumPipeline(b *testing.B) {
+	const files, size = 64, 512 * 1024
+	paths := createHashFiles(b, files, size)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("failed to listen: %v", err)
+	}
+	defer func() { _ = listener.Close() }()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				buf := make([]byte, 64*1024)
+				for {
+					if _, err := conn.Read(buf); err != nil {
+						return
+					}
+					time.Sleep(100 * time.Microsecond)
+				}
+			}()
+		}
+	}()
+
+	send := func(conn net.Conn, path string) {
+		file, err := os.Open(path)
+		if err != nil {
+			b.Fatalf("failed to open file: %v", err)
+		}
+		defer func() { _ = file.Close() }()
+		if _, err := io.Copy(conn, file); err != nil {
+			b.Fatalf("failed to send file: %v", err)
+		}
+	}
+
+	for _, bench := range []struct {
+		name      string
+		pipelined bool
+	}{
+		{"serial", false},
+		{"pipelined", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				b.Fatalf("failed to connect: %v", err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			b.SetBytes(files * size)
+			for b.Loop() {
+				var pipeline *checksumPipeline
+				if bench.pipelined {
+					pipeline = startChecksumPipeline(context.Background(), paths, ChecksumWorkers, ChecksumLookahead)
+				}
+				for i, path := range paths {
+					var result hashedFile
+					if pipeline != nil {
+						result = pipeline.next(context.Background(), i)
+					} else {
+						result = hashFile(context.Background(), path)
+					}
+					if result.err != nil {
+						b.Fatalf("failed to hash file: %v", result.err)
+					}
+					send(conn, path)
+				}
+				if pipeline != nil {
+					pipeline.stop()
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"filexfer/protocol"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+// sendManifest hashes every file of the directory with the pipeline and sends the manifest of their checksums
+// to the server ahead of a sync (`protocol.MessageTypeManifest`), so that the files the server already has are skipped
+// without querying them one by one. It returns the hashed files, marking those the server needs as `wanted`,
+// and whether the server has each file already (false for a file that failed to hash, which is left to its own query).
+// The hashed files are returned even if the exchange fails, so that they need not be hashed again.
+func sendManifest(ctx context.Context, logger *slog.Logger, conn net.Conn, dirPath string, files []string, pipeline *checksumPipeline) ([]hashedFile, []bool, error) {
+	fmt.Fprintf(statusOutput, "Calculating the checksums of %d files...\n", len(files))
+	hashes := make([]hashedFile, len(files))
+	entries := make([]protocol.ManifestEntry, 0, len(files))
+	indexes := make([]int, 0, len(files)) // Index of the file of each entry.
+	for i, filePath := range files {
+		hashes[i] = pipeline.next(ctx, i)
+		if hashes[i].err != nil {
+			continue
+		}
+		relPath, err := filepath.Rel(dirPath, filePath)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, protocol.ManifestEntry{Path: relPath, Size: uint64(hashes[i].size), Checksum: hashes[i].checksum})
+		indexes = append(indexes, i)
+	}
+	if err := ctx.Err(); err != nil {
+		return hashes, nil, err
+	}
+
+	data, err := protocol.EncodeManifest(entries)
+	if err != nil {
+		return hashes, nil, err
+	}
+	header := &protocol.Header{
+		MessageType:   protocol.MessageTypeManifest,
+		FileSize:      uint64(len(data)),
+		Checksum:      protocol.CalculateDataChecksum(data),
+		TransferType:  protocol.TransferTypeDirectory,
+		DirectoryPath: *remoteDir,
+	}
+
+	fmt.Fprintf(statusOutput, "Sending the manifest of %d files...\n", len(entries))
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return hashes, nil, fmt.Errorf("failed to set write deadline: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+		return hashes, nil, fmt.Errorf("failed to set read deadline: %v", err)
+	}
+	if err := protocol.WriteHeader(conn, header); err != nil {
+		return hashes, nil, fmt.Errorf("failed to send the manifest header: %v", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		return hashes, nil, fmt.Errorf("failed to send the manifest: %v", err)
+	}
+	status, code, message, err := protocol.ReadCodedResponse(conn)
+	if err != nil {
+		return hashes, nil, fmt.Errorf("failed to read the manifest response: %v", err)
+	}
+	if status != protocol.ResponseStatusSuccess {
+		return hashes, nil, responseError(status, code, message)
+	}
+	needed, err := protocol.ParseManifestReply(message, len(entries))
+	if err != nil {
+		return hashes, nil, err
+	}
+
+	unchanged := make([]bool, len(files))
+	missing := 0
+	for j, i := range indexes {
+		if needed[j] {
+			hashes[i].wanted = true
+			missing++
+		} else {
+			unchanged[i] = true
+		}
+	}
+	logger.Info("The server compared the manifest", "files", len(entries), "needed", missing)
+	fmt.Fprintf(statusOutput, "Server needs %d of %d files\n", missing, len(entries))
+	return hashes, unchanged, nil
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchMaxBackoff is the longest time the watch mode waits before retrying a file that failed to transfer.
+const WatchMaxBackoff = 5 * time.Minute
+
+// A watchedFile is the state of a file seen by the watch mode.
+type watchedFile struct {
+	size     int64     // Size of the file at the last scan.
+	modTime  time.Time // Modification time of the file at the last scan.
+	changed  time.Time // When the size or modification time was last seen changing.
+	sent     bool      // Whether this version of the file has been sent.
+	failures int       // Number of consecutive failed transfers of this version of the file.
+	retryAt  time.Time // Earliest time of the next transfer attempt after a failure.
+}
+
+// A watcher scans a directory at an interval and transfers the files that are new or changed,
+// once their size and modification time have settled (so that half-written files are not sent).
+// With change notifications (see `startNotify`), the directory is only walked again after files or directories
+// were created, removed, or renamed, and a scan otherwise checks only the files written since the last one and those
+// still waiting to be sent. Without them (e.g. past the inotify watch limit, or on a network file system),
+// the whole directory is walked at every scan.
+type watcher struct {
+	root        string                  // Watched directory.
+	filter      *protocol.PathFilter    // Path filter of the watched files.
+	files       map[string]*watchedFile // Slash-separated relative path -> state of the file.
+	rootMissing bool                    // Whether the watched directory was missing at the last scan.
+	summary     *transferSummary        // Outcome of every transfer attempt.
+	now         func() time.Time        // Current time (replaced in tests).
+	notify      *fsnotify.Watcher       // Change notifications of the watched directories, or nil to walk at every scan.
+	notifyDirs  map[string]bool         // Paths of the directories watched by `notify`.
+	rescan      bool                    // Whether the directory must be walked at the next scan, with `notify`.
+	touched     map[string]bool         // Relative paths of the tracked files written since the last scan, with `notify`.
+}
+
+// newWatcher instantiates a new watcher of the directory.
+func newWatcher(root string, filter *protocol.PathFilter) *watcher {
+	return &watcher{
+		root:    root,
+		filter:  filter,
+		files:   make(map[string]*watchedFile),
+		summary: &transferSummary{},
+		now:     time.Now,
+		touched: make(map[string]bool),
+	}
+}
+
+// startNotify starts the change notifications of the watched directory. The directory is walked at the first scan,
+// which watches its subdirectories as well. If notifications are not available, the watcher keeps walking it at every scan.
+func (w *watcher) startNotify() error {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	w.notify, w.notifyDirs, w.rescan = notify, make(map[string]bool), true
+	return nil
+}
+
+// stopNotify stops the change notifications, after which the whole directory is walked at every scan.
+func (w *watcher) stopNotify() {
+	if w.notify == nil {
+		return
+	}
+	if err := w.notify.Close(); err != nil {
+		slog.Warn("Error closing the change notifications of the watched directory", "dir", w.root, "error", err)
+	}
+	w.notify, w.notifyDirs = nil, nil
+}
+
+// watchDirs adds the watched directory and its subdirectories walked by the last scan (`dirs`, relative paths)
+// to the change notifications. If one cannot be added, the notifications are stopped, since changes in it would be missed.
+func (w *watcher) watchDirs(dirs []string) {
+	for _, dir := range append([]string{"."}, dirs...) {
+		path := filepath.Join(w.root, filepath.FromSlash(dir))
+		if w.notifyDirs[path] {
+			continue
+		}
+		if err := w.notify.Add(path); err != nil {
+			slog.Warn("Cannot watch the directory for changes, scanning it at every interval instead", "dir", path, "error", err)
+			w.stopNotify()
+			return
+		}
+		w.notifyDirs[path] = true
+	}
+}
+
+// noteEvent records a change notification for the next scan: a write to a tracked file is checked by itself,
+// while any other change (a file or directory created, removed, or renamed) has the directory walked again.
+func (w *watcher) noteEvent(event fsnotify.Event) {
+	if relPath, err := filepath.Rel(w.root, event.Name); err == nil && (event.Has(fsnotify.Write) || event.Has(fsnotify.Chmod)) &&
+		!event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+		if _, ok := w.files[filepath.ToSlash(relPath)]; ok {
+			w.touched[filepath.ToSlash(relPath)] = true
+			return
+		}
+	}
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// The notifications of a removed directory stop, so it is watched again if it reappears.
+		delete(w.notifyDirs, event.Name)
+	}
+	w.rescan = true
+}
+
+// noteError records an error of the change notifications, such as an overflow of the event queue,
+// after which the directory is walked again, since changes may have been missed.
+func (w *watcher) noteError(err error) {
+	slog.Warn("Change notifications of the watched directory were lost, scanning it again", "dir", w.root, "error", err)
+	w.rescan = true
+}
+
+// watchDirectory transfers the files of the directory as they appear or change until the context is canceled.
+// It returns a summary of every transfer attempt. An interruption is a normal end of the watch, not an error.
+func watchDirectory(ctx context.Context, dirPath string, filter *protocol.PathFilter) (*transferSummary, error) {
+	if *archiveDir != "" {
+		if err := validateArchiveDir(dirPath, *archiveDir); err != nil {
+			return &transferSummary{}, err
+		}
+	}
+
+	w := newWatcher(dirPath, filter)
+	startTime := time.Now()
+	defer func() {
+		w.summary.duration = time.Since(startTime)
+	}()
+
+	if err := w.startNotify(); err != nil {
+		slog.Warn("Change notifications are not available, scanning the directory at every interval instead", "dir", dirPath, "error", err)
+	}
+	defer w.stopNotify()
+
+	slog.Info("Watching the directory for new or changed files...", "dir", dirPath,
+		"interval", watchInterval.String(), "settle", watchSettle.String(), "notify", w.notify != nil)
+
+	ticker := time.NewTicker(*watchInterval)
+	defer ticker.Stop()
+	for {
+		w.poll(ctx)
+
+		// The notifications are collected until the next scan, so that a file written in many chunks is checked once.
+		for waiting := true; waiting; {
+			var events chan fsnotify.Event
+			var errs chan error
+			if w.notify != nil {
+				events, errs = w.notify.Events, w.notify.Errors
+			}
+			select {
+			case <-ctx.Done():
+				slog.Info("Stopped watching the directory", "dir", dirPath)
+				slog.Info("Watch summary", "sent", w.summary.successful, "failed", w.summary.failed, "unchanged", w.summary.unchanged,
+					"cleaned_up", w.summary.cleanedUp, "bytes", w.summary.totalBytes)
+				return w.summary, nil
+			case event := <-events:
+				w.noteEvent(event)
+			case err := <-errs:
+				w.noteError(err)
+			case <-ticker.C:
+				waiting = false
+			}
+		}
+	}
+}
+
+// poll scans the directory once and transfers the files that are due.
+func (w *watcher) poll(ctx context.Context) {
+	due := w.scan()
+	if len(due) == 0 || ctx.Err() != nil {
+		return
+	}
+	w.transfer(ctx, due)
+}
+
+// scan updates the state of the watched files and returns the relative paths of the files due for a transfer:
+// files not sent yet whose size and modification time have not changed for the settle time, and whose retry time has passed.
+// A missing directory is not an error, since it may be removed and recreated while being watched.
+func (w *watcher) scan() []string {
+	if _, err := os.Stat(w.root); err != nil {
+		if !w.rootMissing {
+			slog.Warn("Cannot access the watched directory, waiting for it to reappear", "dir", w.root, "error", err)
+			w.rootMissing = true
+		}
+		clear(w.files)
+		return nil
+	}
+	if w.rootMissing {
+		slog.Info("The watched directory is accessible again", "dir", w.root)
+		w.rootMissing = false
+		// The notifications of the removed directory stopped, so the recreated one is watched from scratch.
+		if w.notify != nil {
+			for path := range w.notifyDirs {
+				_ = w.notify.Remove(path)
+			}
+			clear(w.notifyDirs)
+			w.rescan = true
+		}
+	}
+
+	if w.notify != nil && !w.rescan {
+		return w.check()
+	}
+
+	plan, err := planDirectory(w.root, w.filter, false)
+	if err != nil {
+		// A file removed during the walk fails the whole scan, so just try again at the next one.
+		slog.Warn("Failed to scan the watched directory", "dir", w.root, "error", err)
+		return nil
+	}
+	if w.notify != nil {
+		w.rescan = false
+		clear(w.touched)
+		w.watchDirs(plan.Dirs)
+	}
+
+	now := w.now()
+	seen := make(map[string]bool, len(plan.Files))
+	var due []string
+	for _, planned := range plan.Files {
+		info, err := os.Stat(filepath.Join(w.root, filepath.FromSlash(planned.Path)))
+		if err != nil {
+			continue
+		}
+		seen[planned.Path] = true
+
+		if w.update(planned.Path, info, now) {
+			due = append(due, planned.Path)
+		}
+	}
+	for _, planned := range plan.TooLarge {
+		seen[planned.Path] = true
+		// A too large file is tracked without a size, so that it is reported only once,
+		// and as sent, so that it is not checked between walks until it is written again.
+		if _, ok := w.files[planned.Path]; !ok {
+			w.files[planned.Path] = &watchedFile{sent: true}
+			slog.Warn("Skipping a file too large to transfer", "file_name", planned.Path, "bytes", planned.Size, "max_bytes", MaxFileSize,
+				"error", ErrFileTooLarge)
+		}
+	}
+
+	// Forget the files that are gone, so that they are sent again if they reappear.
+	for relPath := range w.files {
+		if !seen[relPath] {
+			delete(w.files, relPath)
+		}
+	}
+
+	return due
+}
+
+// check is the scan between walks of the directory with change notifications: it checks only the tracked files
+// written since the last scan and those not sent yet, without walking the directory.
+func (w *watcher) check() []string {
+	now := w.now()
+	var due []string
+	for relPath, state := range w.files {
+		if state.sent && !w.touched[relPath] {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(w.root, filepath.FromSlash(relPath)))
+		if err != nil {
+			// A file removed without a notification yet: the walk of the next scan settles it.
+			w.rescan = true
+			continue
+		}
+		// A file too large to transfer is left to the walk, which reports it.
+		if info.Size() > MaxFileSize {
+			continue
+		}
+		if w.update(relPath, info, now) {
+			due = append(due, relPath)
+		}
+	}
+	clear(w.touched)
+	// The files are sent in a stable order, rather than the random order of the map.
+	slices.Sort(due)
+	return due
+}
+
+// update records the size and modification time of the tracked file at `now`, and reports whether it is due for a transfer.
+// A new file, or a new version of a file, waits for the settle time.
+func (w *watcher) update(relPath string, info os.FileInfo, now time.Time) bool {
+	state, ok := w.files[relPath]
+	if !ok || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+		state = &watchedFile{size: info.Size(), modTime: info.ModTime(), changed: now}
+		w.files[relPath] = state
+	}
+	return !state.sent && now.Sub(state.changed) >= *watchSettle && !now.Before(state.retryAt)
+}
+
+// transfer transfers the due files on one connection.
+// A failed file is retried after a backoff, which grows with its consecutive failures.
+func (w *watcher) transfer(ctx context.Context, due []string) {
+	conn, err := dialWithTLS("tcp", *serverAddr, ConnectionTimeout)
+	if err != nil {
+		err = fmt.Errorf("failed to establish the connection to the server: %v", err)
+		slog.Error("Failed to transfer the watched files", "files", len(due), "error", err)
+		for _, relPath := range due {
+			w.recordFailure(relPath, err)
+		}
+		return
+	}
+	defer func() {
+		if err := conn.Close(); err != nil {
+			slog.Warn("Error closing the watch connection", "error", err)
+		}
+	}()
+
+	for i, relPath := range due {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := w.transferFile(ctx, conn, relPath)
+		if err == nil {
+			continue
+		}
+		slog.Error("Failed to transfer the watched file", "file_name", relPath, "error", err)
+		w.recordFailure(relPath, err)
+		// The rest of the files are retried at the next scan if the connection is likely dead.
+		if errors.Is(err, io.EOF) || strings.Contains(err.Error(), "connection") {
+			slog.Error("Connection error detected, postponing the remaining files", "files", len(due)-i-1)
+			return
+		}
+	}
+}
+
+// transferFile transfers a single watched file on the connection, and then deletes or archives it as requested.
+func (w *watcher) transferFile(ctx context.Context, conn net.Conn, relPath string) error {
+	state := w.files[relPath]
+	path := filepath.Join(w.root, filepath.FromSlash(relPath))
+	report := fileReport{Name: relPath, Size: state.size}
+	logger := slog.With("transfer_id", protocol.NewTransferID())
+
+	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+		return fmt.Errorf("failed to set read deadline: %v", err)
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return fmt.Errorf("failed to set write deadline: %v", err)
+	}
+
+	fmt.Fprintf(statusOutput, "Transferring watched file: %s\n", relPath)
+	startTime := time.Now()
+	checksum, response, err := transferFile(ctx, logger, conn, path, filepath.FromSlash(relPath), nil, nil)
+	report.recordResponse(response)
+	switch {
+	case errors.Is(err, ErrServerSkipped):
+		w.summary.recordServerSkipped(report, err, startTime)
+	case errors.Is(err, ErrFileUnchanged):
+		w.summary.recordUnchanged(report, checksum, startTime)
+	case err != nil:
+		return err
+	default:
+		report.Status = FileStatusSent
+		report.Checksum = hex.EncodeToString(checksum)
+		report.finish(startTime)
+		w.summary.files = append(w.summary.files, report)
+		w.summary.totalBytes += report.Size
+		w.summary.successful++
+	}
+
+	state.sent = true
+	state.failures = 0
+	// A file changed since its scan is kept, since the server may have received its previous version.
+	if cleanupSource(logger, path, filepath.FromSlash(relPath), state.size, state.modTime, checksum, response.message) {
+		w.summary.cleanedUp++
+		delete(w.files, relPath)
+	}
+	return nil
+}
+
+// recordFailure records a failed transfer of the watched file and schedules its retry,
+// unless the failure is not worth retrying (see `retryable`), in which case the file is sent again only once it changes.
+func (w *watcher) recordFailure(relPath string, err error) {
+	state := w.files[relPath]
+	if !retryable(err) {
+		state.sent = true
+		w.summary.recordFailure(fileReport{Name: relPath, Size: state.size}, err)
+		slog.Info("Not retrying the watched file until it changes", "file_name", relPath)
+		return
+	}
+	state.failures++
+	state.retryAt = w.now().Add(retryBackoff(state.failures))
+	w.summary.recordFailure(fileReport{Name: relPath, Size: state.size}, err)
+	slog.Info("Retrying the watched file", "file_name", relPath, "backoff", retryBackoff(state.failures).String(), "attempt", state.failures+1)
+}
+
+// retryBackoff returns how long to wait before retrying a file after the given number of consecutive failures:
+// the scan interval, doubled for each further failure, up to `WatchMaxBackoff`.
+func retryBackoff(failures int) time.Duration {
+	backoff := *watchInterval
+	for i := 1; i < failures && backoff < WatchMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, WatchMaxBackoff)
+}
+
+// validateWatchSource checks that the source path of the watch mode is an existing directory.
+func validateWatchSource(source sourcePath) error {
+	if source.err != nil {
+		return source.err
+	}
+	if err := validatePath(source.path); err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(source.path)
+	if err != nil {
+		return fmt.Errorf("failed to get the path information: %v", err)
+	}
+	if !fileInfo.IsDir() {
+		return fmt.Errorf("the -watch mode requires a directory: %s", source.path)
+	}
+	return nil
+}
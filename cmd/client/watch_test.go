@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"filexfer/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// newTestWatcher creates a watcher of a new temporary directory with a controllable clock,
+// with the status output discarded.
+func newTestWatcher(t *testing.T) (*watcher, *time.Time) {
+	t.Helper()
+
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	t.Cleanup(func() { statusOutput = originalStatusOutput })
+
+	clock := time.Now()
+	w := newWatcher(t.TempDir(), nil)
+	w.now = func() time.Time { return clock }
+	return w, &clock
+}
+
+// writeWatchedFile writes a file under the watched directory, creating its parent directories.
+func writeWatchedFile(t *testing.T, w *watcher, relPath, content string) {
+	t.Helper()
+
+	path := filepath.Join(w.root, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+}
+
+// TestWatcherWaitsForFilesToSettle tests the watch mode to ensure that
+// a file is sent only once it stops changing, and is sent again after it changes.
+func TestWatcherWaitsForFilesToSettle(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "1m"})
+	w, clock := newTestWatcher(t)
+	ms := startMockServer(t)
+
+	writeWatchedFile(t, w, "sub/report.csv", "first version")
+	w.poll(context.Background())
+	if ms.uploads != 0 {
+		t.Fatalf("expected no upload before the file settles, got %d", ms.uploads)
+	}
+
+	*clock = clock.Add(time.Minute)
+	w.poll(context.Background())
+	w.poll(context.Background())
+	if ms.uploads != 1 || string(ms.receivedFiles()["sub/report.csv"]) != "first version" {
+		t.Fatalf("expected the settled file to be uploaded once, got %d uploads: %v", ms.uploads, ms.receivedFiles())
+	}
+
+	writeWatchedFile(t, w, "sub/report.csv", "second, longer version")
+	w.poll(context.Background())
+	*clock = clock.Add(time.Minute)
+	w.poll(context.Background())
+	if ms.uploads != 2 || string(ms.receivedFiles()["sub/report.csv"]) != "second, longer version" {
+		t.Fatalf("expected the changed file to be uploaded again, got %d uploads: %v", ms.uploads, ms.receivedFiles())
+	}
+	if w.summary.successful != 2 || w.summary.cleanedUp != 0 {
+		t.Fatalf("expected 2 successful transfers in the summary, got %+v", *w.summary)
+	}
+}
+
+// TestWatcherRetriesWithBackoff tests the watch mode to ensure that
+// a failed file is retried only after its backoff, which doubles with each failure.
+func TestWatcherRetriesWithBackoff(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s", "watch-interval": "1s"})
+	w, clock := newTestWatcher(t)
+	ms := startMockServer(t)
+	_ = ms.listener.Close()
+
+	writeWatchedFile(t, w, "retry.txt", "content")
+	w.poll(context.Background())
+	if w.summary.failed != 1 || w.files["retry.txt"].failures != 1 {
+		t.Fatalf("expected one failed attempt, got %+v", *w.summary)
+	}
+
+	w.poll(context.Background())
+	if w.summary.failed != 1 {
+		t.Fatalf("expected no attempt before the backoff expires, got %d failures", w.summary.failed)
+	}
+
+	*clock = clock.Add(time.Second)
+	w.poll(context.Background())
+	if w.summary.failed != 2 {
+		t.Fatalf("expected a second attempt after the backoff, got %d failures", w.summary.failed)
+	}
+	if got := w.files["retry.txt"].retryAt.Sub(*clock); got != 2*time.Second {
+		t.Fatalf("expected the backoff to double to 2s, got %v", got)
+	}
+
+	// Once the server is reachable again, the file goes through.
+	ms = startMockServer(t)
+	*clock = clock.Add(2 * time.Second)
+	w.poll(context.Background())
+	if string(ms.receivedFiles()["retry.txt"]) != "content" || w.files["retry.txt"].failures != 0 {
+		t.Fatalf("expected the file to be uploaded after the retries, got %v", ms.receivedFiles())
+	}
+}
+
+// TestWatcherDoesNotRetryRefusedFile tests the watch mode to ensure that
+// a file refused by the server for a reason that retrying cannot fix is sent again only once it changes.
+func TestWatcherDoesNotRetryRefusedFile(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s", "watch-interval": "1s"})
+	w, clock := newTestWatcher(t)
+	ms := startMockServer(t)
+	ms.respond = func(header *protocol.Header, checksum []byte) (uint8, uint16, string) {
+		return protocol.ResponseStatusError, protocol.ErrorCodeFileTooLarge, "file size exceeds the maximum allowed size"
+	}
+
+	writeWatchedFile(t, w, "refused.txt", "content")
+	w.poll(context.Background())
+	if w.summary.failed != 1 || !w.files["refused.txt"].sent {
+		t.Fatalf("expected one failed attempt without a retry, got %+v", *w.summary)
+	}
+
+	*clock = clock.Add(time.Hour)
+	w.poll(context.Background())
+	if w.summary.failed != 1 {
+		t.Fatalf("expected the refused file not to be retried, got %d failures", w.summary.failed)
+	}
+
+	// A new version of the file is sent again.
+	ms.respond = nil
+	writeWatchedFile(t, w, "refused.txt", "new content")
+	w.poll(context.Background())
+	w.poll(context.Background())
+	if string(ms.receivedFiles()["refused.txt"]) != "new content" {
+		t.Fatalf("expected the changed file to be uploaded, got %v", ms.receivedFiles())
+	}
+}
+
+// TestRetryBackoff tests `retryBackoff` to ensure that
+// the backoff starts at the scan interval, doubles, and is capped at `WatchMaxBackoff`.
+func TestRetryBackoff(t *testing.T) {
+	withFlags(t, map[string]string{"watch-interval": "1s"})
+
+	for failures, expected := range map[int]time.Duration{
+		1:    time.Second,
+		2:    2 * time.Second,
+		4:    8 * time.Second,
+		20:   WatchMaxBackoff,
+		1000: WatchMaxBackoff,
+	} {
+		if got := retryBackoff(failures); got != expected {
+			t.Errorf("retryBackoff(%d) = %v, expected %v", failures, got, expected)
+		}
+	}
+}
+
+// waitForNotifications records the change notifications of the watcher until `done` reports true.
+func waitForNotifications(t *testing.T, w *watcher, done func() bool) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for !done() {
+		select {
+		case event := <-w.notify.Events:
+			w.noteEvent(event)
+		case err := <-w.notify.Errors:
+			w.noteError(err)
+		case <-timeout:
+			t.Fatal("timed out waiting for the change notifications")
+		}
+	}
+}
+
+// TestWatcherNotifications tests the watch mode with change notifications to ensure that
+// the directory is walked only after files are created, that a write to a tracked file is checked by itself,
+// and that new subdirectories are watched as well.
+func TestWatcherNotifications(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s"})
+	w, _ := newTestWatcher(t)
+	if err := w.startNotify(); err != nil {
+		t.Skipf("change notifications are not available: %v", err)
+	}
+	t.Cleanup(w.stopNotify)
+
+	writeWatchedFile(t, w, "a.txt", "first")
+	if due := w.scan(); !slices.Equal(due, []string{"a.txt"}) || w.rescan {
+		t.Fatalf("expected the first scan to walk the directory and find a.txt, got %v", due)
+	}
+	w.files["a.txt"].sent = true
+
+	// A file created without a notification yet is not found, since the directory is not walked.
+	writeWatchedFile(t, w, "b.txt", "second")
+	if due := w.scan(); len(due) != 0 {
+		t.Fatalf("expected no walk without notifications, got %v", due)
+	}
+	waitForNotifications(t, w, func() bool { return w.rescan })
+	if due := w.scan(); !slices.Equal(due, []string{"b.txt"}) {
+		t.Fatalf("expected the walk after the notification to find b.txt, got %v", due)
+	}
+	w.files["b.txt"].sent = true
+
+	writeWatchedFile(t, w, "a.txt", "first, rewritten")
+	waitForNotifications(t, w, func() bool { return w.touched["a.txt"] })
+	if w.rescan {
+		t.Fatal("expected a write to a tracked file not to require a walk")
+	}
+	if due := w.scan(); !slices.Equal(due, []string{"a.txt"}) {
+		t.Fatalf("expected the rewritten a.txt to be due, got %v", due)
+	}
+	w.files["a.txt"].sent = true
+
+	writeWatchedFile(t, w, "sub/c.txt", "third")
+	waitForNotifications(t, w, func() bool { return w.rescan })
+	if due := w.scan(); !slices.Equal(due, []string{"sub/c.txt"}) || !w.notifyDirs[filepath.Join(w.root, "sub")] {
+		t.Fatalf("expected the walk to find sub/c.txt and watch sub, got %v", due)
+	}
+	w.files["sub/c.txt"].sent = true
+
+	writeWatchedFile(t, w, "sub/c.txt", "third, rewritten")
+	waitForNotifications(t, w, func() bool { return w.touched["sub/c.txt"] })
+	if due := w.scan(); !slices.Equal(due, []string{"sub/c.txt"}) {
+		t.Fatalf("expected the rewritten sub/c.txt to be due, got %v", due)
+	}
+}
+
+// TestWatcherSurvivesRecreatedDirectory tests the watch mode to ensure that
+// the watched directory can be removed and recreated without stopping the watch.
+func TestWatcherSurvivesRecreatedDirectory(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s"})
+	w, _ := newTestWatcher(t)
+	ms := startMockServer(t)
+
+	writeWatchedFile(t, w, "before.txt", "before")
+	w.poll(context.Background())
+
+	if err := os.RemoveAll(w.root); err != nil {
+		t.Fatalf("failed to remove the watched directory: %v", err)
+	}
+	w.poll(context.Background())
+	if !w.rootMissing {
+		t.Fatal("expected the watcher to notice the missing directory")
+	}
+
+	writeWatchedFile(t, w, "after.txt", "after")
+	w.poll(context.Background())
+	received := ms.receivedFiles()
+	if string(received["before.txt"]) != "before" || string(received["after.txt"]) != "after" {
+		t.Fatalf("expected the files before and after the recreation to be uploaded, got %v", received)
+	}
+}
+
+// TestWatcherDeleteSource tests the "-delete-source" option with the watch mode to ensure that
+// a file is deleted locally once the server has stored it.
+func TestWatcherDeleteSource(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s", "delete-source": "true"})
+	w, _ := newTestWatcher(t)
+	ms := startMockServer(t)
+
+	writeWatchedFile(t, w, "outgoing.txt", "outgoing")
+	w.poll(context.Background())
+
+	if string(ms.receivedFiles()["outgoing.txt"]) != "outgoing" {
+		t.Fatalf("expected the file to be uploaded, got %v", ms.receivedFiles())
+	}
+	if _, err := os.Stat(filepath.Join(w.root, "outgoing.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the sent file to be deleted, got %v", err)
+	}
+}
+
+// TestWatcherArchiveDir tests the "-archive-dir" option with the watch mode to ensure that
+// a file is moved into the archive directory, under its relative path, once the server has stored it.
+func TestWatcherArchiveDir(t *testing.T) {
+	archiveDir := t.TempDir()
+	withFlags(t, map[string]string{"watch-settle": "0s", "archive-dir": archiveDir})
+	w, _ := newTestWatcher(t)
+	startMockServer(t)
+
+	writeWatchedFile(t, w, "sub/outgoing.txt", "outgoing")
+	w.poll(context.Background())
+
+	if _, err := os.Stat(filepath.Join(w.root, "sub", "outgoing.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the sent file to be moved, got %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(archiveDir, "sub", "outgoing.txt"))
+	if err != nil || string(got) != "outgoing" {
+		t.Fatalf("expected the sent file in the archive directory, got %q and %v", got, err)
+	}
+}
+
+// TestWatchDirectoryStopsOnCancel tests `watchDirectory` to ensure that
+// it keeps transferring new files until the context is canceled, and then returns without an error.
+func TestWatchDirectoryStopsOnCancel(t *testing.T) {
+	withFlags(t, map[string]string{"watch-settle": "0s", "watch-interval": "10ms"})
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	dir := t.TempDir()
+	ms := startMockServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type result struct {
+		summary *transferSummary
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		summary, err := watchDirectory(ctx, dir, nil)
+		done <- result{summary, err}
+	}()
+
+	if err := os.WriteFile(filepath.Join(dir, "late.txt"), []byte("late"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for string(ms.receivedFiles()["late.txt"]) != "late" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the new file to be uploaded while watching")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case r := <-done:
+		if r.err != nil || r.summary.successful != 1 {
+			t.Fatalf("expected one successful transfer and no error, got %+v and %v", r.summary, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the watch to stop after the cancellation")
+	}
+}
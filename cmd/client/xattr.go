@@ -0,0 +1,30 @@
+package main
+
+import (
+	"filexfer/protocol"
+	"io"
+	"log/slog"
+)
+
+// fileXattrs returns the extended attributes of the file to send in its header with "-xattrs", or none if the file
+// has none, its filesystem or platform does not support them, or they do not fit in the header.
+// The file is sent either way, since its content is what the transfer verifies.
+func fileXattrs(logger *slog.Logger, path string, header *protocol.Header) []protocol.Xattr {
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		logger.Warn("Failed to read the extended attributes, sending the file without them", "path", path, "error", err)
+		return nil
+	}
+	if len(xattrs) == 0 {
+		return nil
+	}
+
+	// Encoding the header without sending it checks that the attributes fit in it.
+	withXattrs := *header
+	withXattrs.Xattrs = xattrs
+	if err := protocol.WriteHeader(io.Discard, &withXattrs); err != nil {
+		logger.Warn("Extended attributes do not fit in the header, sending the file without them", "path", path, "count", len(xattrs), "error", err)
+		return nil
+	}
+	return xattrs
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package main
+
+import "filexfer/protocol"
+
+// readXattrs returns no extended attributes, since they are only supported on Linux and macOS.
+func readXattrs(path string) ([]protocol.Xattr, error) {
+	return nil, nil
+}
@@ -0,0 +1,62 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"filexfer/protocol"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the extended attributes of the file at `path`, or none if its filesystem does not support them.
+func readXattrs(path string) ([]protocol.Xattr, error) {
+	list, err := readXattrValue(func(dest []byte) (int, error) { return unix.Listxattr(path, dest) })
+	if errors.Is(err, errors.ErrUnsupported) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var xattrs []protocol.Xattr
+	for name := range bytes.SplitSeq(list, []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		value, err := readXattrValue(func(dest []byte) (int, error) { return unix.Getxattr(path, string(name), dest) })
+		// An attribute removed since it was listed is left out.
+		if errors.Is(err, unix.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		xattrs = append(xattrs, protocol.Xattr{Name: string(name), Value: value})
+	}
+	return xattrs, nil
+}
+
+// readXattrValue calls `read` (`unix.Listxattr` or `unix.Getxattr`) first for the size and then for the data,
+// retrying if the data grew in between.
+func readXattrValue(read func(dest []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return []byte{}, nil
+		}
+		dest := make([]byte, size)
+		n, err := read(dest)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return dest[:n], nil
+	}
+}
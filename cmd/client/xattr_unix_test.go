@@ -0,0 +1,91 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"filexfer/protocol"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTestXattr sets an extended attribute on the file, skipping the test if the filesystem does not support them.
+func setTestXattr(t *testing.T, path, name string, value []byte) {
+	t.Helper()
+
+	if err := unix.Setxattr(path, name, value, 0); err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("extended attributes are not supported by the filesystem of %s", path)
+		}
+		t.Fatalf("failed to set the extended attribute: %v", err)
+	}
+}
+
+// TestReadXattrs tests the `readXattrs` function to ensure that it returns the extended attributes of a file.
+func TestReadXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the test file: %v", err)
+	}
+	setTestXattr(t, path, "user.filexfer.comment", []byte("a comment"))
+	setTestXattr(t, path, "user.filexfer.empty", nil)
+
+	xattrs, err := readXattrs(path)
+	if err != nil {
+		t.Fatalf("readXattrs returned error: %v", err)
+	}
+	got := map[string][]byte{}
+	for _, xattr := range xattrs {
+		got[xattr.Name] = xattr.Value
+	}
+	if value, ok := got["user.filexfer.comment"]; !ok || !bytes.Equal(value, []byte("a comment")) {
+		t.Errorf("expected user.filexfer.comment=%q, got %q (present: %v)", "a comment", value, ok)
+	}
+	if value, ok := got["user.filexfer.empty"]; !ok || len(value) != 0 {
+		t.Errorf("expected an empty user.filexfer.empty, got %q (present: %v)", value, ok)
+	}
+}
+
+// TestTransferSingleFileXattrs tests `transferSingleFile` to ensure that with "-xattrs" the extended attributes
+// of the file are sent in its header, and that they are not sent without it.
+func TestTransferSingleFileXattrs(t *testing.T) {
+	originalStatusOutput := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = originalStatusOutput }()
+
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the test file: %v", err)
+	}
+	setTestXattr(t, path, "user.filexfer.comment", []byte("a comment"))
+
+	ms := startMockServer(t)
+	headers := make(chan *protocol.Header, 1)
+	ms.respond = func(header *protocol.Header, checksum []byte) (uint8, uint16, string) {
+		headers <- header
+		return protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(checksum)
+	}
+
+	for _, enabled := range []string{"false", "true"} {
+		withFlags(t, map[string]string{"xattrs": enabled})
+		if _, err := transferSingleFile(context.Background(), path); err != nil {
+			t.Fatalf("unexpected error with -xattrs=%s: %v", enabled, err)
+		}
+		header := <-headers
+		found := false
+		for _, xattr := range header.Xattrs {
+			if xattr.Name == "user.filexfer.comment" && bytes.Equal(xattr.Value, []byte("a comment")) {
+				found = true
+			}
+		}
+		if found != (enabled == "true") {
+			t.Fatalf("with -xattrs=%s, expected the attribute to be sent: %v, got the attributes %+v", enabled, enabled == "true", header.Xattrs)
+		}
+	}
+}
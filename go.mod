@@ -1,3 +1,11 @@
 module filexfer
 
 go 1.24.5
+
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/sys v0.41.0
+	golang.org/x/text v0.34.0
+)
@@ -0,0 +1,207 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Constants for the block checksums of a file (see `Header.BlockChecksums`).
+const (
+	MinBlockSize      = 64 * 1024 // Smallest block a file is split into for block checksums (64KB).
+	MaxBlockSize      = 1 << 31   // Largest block a file is split into for block checksums (2GB).
+	MaxBlockChecksums = 1024      // Maximum number of block checksums of a header, which must fit in `MaxHeaderSize`.
+)
+
+// blockChecksumsFlag is set in the compression byte of a header followed by block checksums,
+// so that a header without them keeps the encoding of the headers that predate block checksums.
+const blockChecksumsFlag = 0x80
+
+// blockMismatchPrefix precedes the index of the first corrupted block in the message of the response to a transfer.
+const blockMismatchPrefix = "block checksum mismatch at block "
+
+// ErrInvalidBlockChecksums is returned for block checksums that do not fit the header carrying them.
+var ErrInvalidBlockChecksums = errors.New("invalid block checksums in the header")
+
+// ErrBlockMismatch is returned (wrapped in a `BlockMismatchError`) for a block whose content does not match its checksum.
+var ErrBlockMismatch = errors.New("block checksum mismatch")
+
+// A BlockMismatchError reports the first block of a file whose content does not match its checksum.
+type BlockMismatchError struct {
+	Index int // Index of the block, which starts at byte `Index` times the block size of the file.
+}
+
+// Error returns the message of the error.
+func (e *BlockMismatchError) Error() string {
+	return fmt.Sprintf("%v: block %d", ErrBlockMismatch, e.Index)
+}
+
+// Unwrap returns `ErrBlockMismatch`.
+func (e *BlockMismatchError) Unwrap() error {
+	return ErrBlockMismatch
+}
+
+// BlockMismatchMessage returns the message of the error response to a transfer whose block `index` is corrupted.
+func BlockMismatchMessage(index int) string {
+	return blockMismatchPrefix + strconv.Itoa(index)
+}
+
+// ParseBlockMismatch returns the index of the corrupted block reported by the message of a response.
+// It returns false if the message reports no corrupted block.
+func ParseBlockMismatch(message string) (int, bool) {
+	encoded, ok := strings.CutPrefix(message, blockMismatchPrefix)
+	if !ok {
+		return 0, false
+	}
+	index, err := strconv.Atoi(encoded)
+	return index, err == nil && index >= 0
+}
+
+// BlockCount returns the number of blocks of `blockSize` bytes a file of `fileSize` bytes is split into,
+// the last of which may be shorter.
+func BlockCount(fileSize uint64, blockSize uint32) int {
+	if blockSize == 0 {
+		return 0
+	}
+	return int(min((fileSize+uint64(blockSize)-1)/uint64(blockSize), MaxBlockChecksums+1))
+}
+
+// FitBlockSize returns the smallest block size of at least `blockSize` bytes, doubled as many times as needed,
+// that splits a file of `fileSize` bytes into at most `MaxBlockChecksums` blocks.
+// It returns 0 if even `MaxBlockSize` splits the file into too many blocks.
+func FitBlockSize(fileSize uint64, blockSize uint32) uint32 {
+	size := uint64(max(blockSize, MinBlockSize))
+	for (fileSize+size-1)/size > MaxBlockChecksums {
+		size *= 2
+	}
+	if size > MaxBlockSize {
+		return 0
+	}
+	return uint32(size)
+}
+
+// CalculateBlockChecksums calculates the SHA-256 checksums of the consecutive blocks of `blockSize` bytes
+// of a file, checking the context between chunks like `CalculateFileChecksumContext`.
+func CalculateBlockChecksums(ctx context.Context, file io.Reader, blockSize uint32) ([][]byte, error) {
+	hasher := NewBlockHasher(blockSize, nil)
+	if _, err := CalculateFileChecksumContext(ctx, io.TeeReader(file, hasher)); err != nil {
+		return nil, err
+	}
+	return hasher.Sum()
+}
+
+// A BlockHasher calculates the checksums of the consecutive blocks of the content written to it.
+// Given the expected checksums, it verifies each block as soon as it is complete, and fails the write
+// that completes a corrupted block with a `BlockMismatchError`, so that the corruption is found where it is.
+type BlockHasher struct {
+	blockSize uint32
+	expected  [][]byte  // Expected checksums of the blocks (nil to only calculate them).
+	hash      hash.Hash // Hash of the current block.
+	written   uint32    // Number of bytes of the current block written.
+	sums      [][]byte  // Checksums of the complete blocks.
+}
+
+// NewBlockHasher returns a `BlockHasher` of blocks of `blockSize` bytes, which verifies them against
+// the expected checksums unless they are nil.
+func NewBlockHasher(blockSize uint32, expected [][]byte) *BlockHasher {
+	return &BlockHasher{blockSize: blockSize, expected: expected, hash: sha256.New()}
+}
+
+// Write hashes the bytes into the blocks they belong to, and verifies each block they complete.
+func (bh *BlockHasher) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p[:min(uint64(len(p)), uint64(bh.blockSize-bh.written))]
+		bh.hash.Write(chunk)
+		bh.written += uint32(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+		if bh.written == bh.blockSize {
+			if err := bh.completeBlock(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// completeBlock records the checksum of the current block, verifies it, and starts the next block.
+func (bh *BlockHasher) completeBlock() error {
+	index := len(bh.sums)
+	bh.sums = append(bh.sums, bh.hash.Sum(nil))
+	bh.hash.Reset()
+	bh.written = 0
+	if bh.expected != nil && (index >= len(bh.expected) || !bytes.Equal(bh.sums[index], bh.expected[index])) {
+		return &BlockMismatchError{Index: index}
+	}
+	return nil
+}
+
+// Sum completes the last block, if shorter than the others, and returns the checksums of all blocks.
+// With the expected checksums, it fails with a `BlockMismatchError` if the last block is corrupted,
+// or for the first missing block if the content is shorter than expected.
+func (bh *BlockHasher) Sum() ([][]byte, error) {
+	if bh.written > 0 {
+		if err := bh.completeBlock(); err != nil {
+			return nil, err
+		}
+	}
+	if bh.expected != nil && len(bh.sums) < len(bh.expected) {
+		return nil, &BlockMismatchError{Index: len(bh.sums)}
+	}
+	return bh.sums, nil
+}
+
+// validateBlockChecksums validates the block checksums of a file of `fileSize` bytes: a valid block size,
+// and a checksum for each block.
+func validateBlockChecksums(blockSize uint32, checksums [][]byte, fileSize uint64) error {
+	if blockSize < MinBlockSize || blockSize > MaxBlockSize {
+		return fmt.Errorf("%w: block size %d is invalid, expected between %d and %d", ErrInvalidBlockChecksums, blockSize, MinBlockSize, MaxBlockSize)
+	}
+	count := BlockCount(fileSize, blockSize)
+	if count > MaxBlockChecksums {
+		return fmt.Errorf("%w: the file of %d bytes has more than %d blocks of %d bytes",
+			ErrInvalidBlockChecksums, fileSize, MaxBlockChecksums, blockSize)
+	}
+	if len(checksums) != count {
+		return fmt.Errorf("%w: %d checksums for %d blocks", ErrInvalidBlockChecksums, len(checksums), count)
+	}
+	for i, checksum := range checksums {
+		if len(checksum) != ChecksumSize {
+			return fmt.Errorf("%w: checksum %d has length %d, expected %d", ErrInvalidBlockChecksums, i, len(checksum), ChecksumSize)
+		}
+	}
+	return nil
+}
+
+// blockChecksumsSize returns the size of the encoded block checksums: the block size, and the checksums.
+func blockChecksumsSize(checksums [][]byte) int {
+	return 4 + len(checksums)*ChecksumSize
+}
+
+// encodeBlockChecksums encodes the block size (4 bytes, big-endian) followed by the checksums of the blocks,
+// whose number follows from the file size. The block checksums must be valid (see `validateBlockChecksums`).
+func encodeBlockChecksums(blockSize uint32, checksums [][]byte) []byte {
+	data := binary.BigEndian.AppendUint32(make([]byte, 0, blockChecksumsSize(checksums)), blockSize)
+	for _, checksum := range checksums {
+		data = append(data, checksum...)
+	}
+	return data
+}
+
+// decodeBlockChecksums splits the encoded checksums of the blocks (without the block size) into one per block.
+func decodeBlockChecksums(data []byte) [][]byte {
+	checksums := make([][]byte, 0, len(data)/ChecksumSize)
+	for len(data) >= ChecksumSize {
+		checksums = append(checksums, data[:ChecksumSize:ChecksumSize])
+		data = data[ChecksumSize:]
+	}
+	return checksums
+}
@@ -0,0 +1,144 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestBlockHasherLocalizesCorruption tests that `BlockHasher` fails on the first corrupted block with its index,
+// whether it is a full block, the shorter last block, or a block missing from truncated content.
+func TestBlockHasherLocalizesCorruption(t *testing.T) {
+	const blockSize = MinBlockSize
+	content := bytes.Repeat([]byte("0123456789"), blockSize/2) // 5 blocks, the last of half a block.
+	expected, err := CalculateBlockChecksums(context.Background(), bytes.NewReader(content), blockSize)
+	if err != nil {
+		t.Fatalf("CalculateBlockChecksums returned error: %v", err)
+	}
+	if len(expected) != 5 || len(expected) != BlockCount(uint64(len(content)), blockSize) {
+		t.Fatalf("expected 5 block checksums, got %d", len(expected))
+	}
+
+	tests := []struct {
+		name     string
+		offset   int // Offset of the corrupted byte (-1 for none).
+		length   int // Length of the content written.
+		expected int // Index of the corrupted block (-1 for none).
+	}{
+		{"intact", -1, len(content), -1},
+		{"first byte", 0, len(content), 0},
+		{"middle block", 2*blockSize + 123, len(content), 2},
+		{"last byte of a block", 4*blockSize - 1, len(content), 3},
+		{"short last block", len(content) - 1, len(content), 4},
+		{"truncated", -1, 3 * blockSize, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Clone(content[:tt.length])
+			if tt.offset >= 0 {
+				data[tt.offset] ^= 0xFF
+			}
+			hasher := NewBlockHasher(blockSize, expected)
+			var err error
+			// Write in odd-sized chunks that straddle the block boundaries.
+			for len(data) > 0 && err == nil {
+				n := min(len(data), 10007)
+				_, err = hasher.Write(data[:n])
+				data = data[n:]
+			}
+			if err == nil {
+				_, err = hasher.Sum()
+			}
+
+			var blockErr *BlockMismatchError
+			switch {
+			case tt.expected < 0 && err != nil:
+				t.Fatalf("expected the blocks to match, got %v", err)
+			case tt.expected >= 0 && (!errors.As(err, &blockErr) || !errors.Is(err, ErrBlockMismatch)):
+				t.Fatalf("expected a BlockMismatchError, got %v", err)
+			case tt.expected >= 0 && blockErr.Index != tt.expected:
+				t.Fatalf("expected the corrupted block %d, got %d", tt.expected, blockErr.Index)
+			}
+		})
+	}
+}
+
+// TestFitBlockSize tests that `FitBlockSize` doubles the block size until the file fits in `MaxBlockChecksums` blocks.
+func TestFitBlockSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileSize  uint64
+		blockSize uint32
+		expected  uint32
+	}{
+		{"fits", 10 << 20, 1 << 20, 1 << 20},
+		{"below the minimum", 10 << 20, 1024, MinBlockSize},
+		{"doubled", 5 << 30, 1 << 20, 8 << 20},
+		{"empty file", 0, 1 << 20, 1 << 20},
+		{"too large", MaxBlockChecksums*MaxBlockSize + 1, 1 << 20, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FitBlockSize(tt.fileSize, tt.blockSize); got != tt.expected {
+				t.Fatalf("expected %d, got %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestBlockChecksumsHeaderRoundTrip tests that the block checksums of a transfer header are written and read back,
+// along with its compression, and that a header with the wrong number of checksums is refused.
+func TestBlockChecksumsHeaderRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 3*MinBlockSize+1)
+	checksums, err := CalculateBlockChecksums(context.Background(), bytes.NewReader(content), MinBlockSize)
+	if err != nil {
+		t.Fatalf("CalculateBlockChecksums returned error: %v", err)
+	}
+	header := &Header{
+		MessageType:    MessageTypeTransfer,
+		FileSize:       uint64(len(content)),
+		FileName:       "blocks.bin",
+		Checksum:       CalculateDataChecksum(content),
+		TransferType:   TransferTypeFile,
+		Compression:    CompressionGzip,
+		BlockSize:      MinBlockSize,
+		BlockChecksums: checksums,
+	}
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, header); err != nil {
+		t.Fatalf("WriteHeader returned error: %v", err)
+	}
+	if buf.Len() != encodedHeaderSize(header) {
+		t.Fatalf("expected %d bytes, got %d", encodedHeaderSize(header), buf.Len())
+	}
+	got, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadHeader returned error: %v", err)
+	}
+	if got.Compression != CompressionGzip || got.BlockSize != MinBlockSize || !reflect.DeepEqual(got.BlockChecksums, checksums) {
+		t.Fatalf("expected the compression and block checksums to be read back, got %+v", got)
+	}
+
+	header.BlockChecksums = checksums[:3]
+	if err := WriteHeader(&buf, header); !errors.Is(err, ErrInvalidBlockChecksums) {
+		t.Fatalf("expected ErrInvalidBlockChecksums for a missing checksum, got %v", err)
+	}
+	header.BlockChecksums, header.TransferType, header.Compression = checksums, TransferTypeStream, CompressionNone
+	if err := WriteHeader(&buf, header); !errors.Is(err, ErrInvalidBlockChecksums) {
+		t.Fatalf("expected the block checksums of a stream to be refused, got %v", err)
+	}
+}
+
+// TestParseBlockMismatch tests that `ParseBlockMismatch` parses what `BlockMismatchMessage` returns, and nothing else.
+func TestParseBlockMismatch(t *testing.T) {
+	if index, ok := ParseBlockMismatch(BlockMismatchMessage(42)); !ok || index != 42 {
+		t.Fatalf("expected block 42, got %d and %v", index, ok)
+	}
+	for _, message := range []string{"Data integrity check failed", blockMismatchPrefix + "x", blockMismatchPrefix + "-1"} {
+		if _, ok := ParseBlockMismatch(message); ok {
+			t.Fatalf("expected %q to report no block", message)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package protocol
+
+import "sync"
+
+// CopyBuffers is the pool of copy buffers shared by the transfers of the client and the server (sized by their
+// "-buffer-size") and by the checksum routines, so that each transfer or checksum reuses a buffer instead of
+// allocating its own.
+//
+// Recommended sizes: 256KB (the default of "-buffer-size") takes 8 times fewer system calls than the 32KB of `io.Copy`
+// and copies about 5% faster over loopback, and 1MB about 10% faster (see `BenchmarkCopyBufferLoopback`); larger
+// buffers gain little more, while every connection in flight holds one, so they only pay off on fast links to
+// a server with few concurrent clients.
+var CopyBuffers BufferPool
+
+// A BufferPool hands out byte slices of any size, reusing those of the same size returned with `Put`.
+// The zero value is ready to use, and a pool is safe for concurrent use.
+type BufferPool struct {
+	pools sync.Map // Buffer size -> `*sync.Pool` of `*[]byte` of that size.
+}
+
+// Get returns a buffer of `size` bytes (with an arbitrary content), to be returned with `Put` once no longer in use.
+// Buffers are passed by pointer, so that returning them to the pool does not allocate.
+func (bp *BufferPool) Get(size int) *[]byte {
+	if pool, ok := bp.pools.Load(size); ok {
+		if buffer, ok := pool.(*sync.Pool).Get().(*[]byte); ok {
+			return buffer
+		}
+	}
+	buffer := make([]byte, size)
+	return &buffer
+}
+
+// Put returns a buffer obtained with `Get` to the pool. The buffer must not be used afterward.
+func (bp *BufferPool) Put(buffer *[]byte) {
+	size := len(*buffer)
+	pool, ok := bp.pools.Load(size)
+	if !ok {
+		pool, _ = bp.pools.LoadOrStore(size, &sync.Pool{})
+	}
+	pool.(*sync.Pool).Put(buffer)
+}
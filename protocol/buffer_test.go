@@ -0,0 +1,115 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+)
+
+// TestBufferPool tests the `BufferPool` type to ensure that it hands out buffers of the requested size
+// and reuses returned buffers without allocating.
+func TestBufferPool(t *testing.T) {
+	var pool BufferPool
+	for _, size := range []int{1, 32 * 1024, 1024 * 1024} {
+		buffer := pool.Get(size)
+		if len(*buffer) != size {
+			t.Fatalf("expected a buffer of %d bytes, got %d", size, len(*buffer))
+		}
+		pool.Put(buffer)
+	}
+
+	// A reused buffer has the size of its own pool, not that of another one.
+	pool.Put(pool.Get(1024))
+	if buffer := pool.Get(2048); len(*buffer) != 2048 {
+		t.Fatalf("expected a buffer of 2048 bytes, got %d", len(*buffer))
+	}
+
+	if allocs := testing.AllocsPerRun(100, func() {
+		pool.Put(pool.Get(256 * 1024))
+	}); allocs != 0 {
+		t.Fatalf("expected pooled buffers to be reused without allocating, got %.1f allocations per use", allocs)
+	}
+}
+
+// TestCalculateChecksumPooledBuffer tests the checksum routines to ensure that they take their buffer from
+// `CopyBuffers`, so that a checksum no longer allocates a `ChecksumChunkSize` buffer each time.
+func TestCalculateChecksumPooledBuffer(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector drops pooled buffers at random")
+	}
+	content := bytes.Repeat([]byte("x"), 4096)
+	const runs = 50
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for range runs {
+		if _, err := CalculateFileChecksumContext(context.Background(), bytes.NewReader(content)); err != nil {
+			t.Fatalf("failed to calculate the checksum: %v", err)
+		}
+		if _, err := CalculateRangeChecksum(bytes.NewReader(content), 0, int64(len(content))); err != nil {
+			t.Fatalf("failed to calculate the range checksum: %v", err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+
+	// A garbage collection may empty the pool now and then, so only most checksums are expected to reuse a buffer.
+	if perRun := (after.TotalAlloc - before.TotalAlloc) / runs; perRun > ChecksumChunkSize/2 {
+		t.Fatalf("expected the checksum buffers to be reused, got %d bytes allocated per run", perRun)
+	}
+}
+
+// BenchmarkCopyBufferLoopback compares the throughput of `io.CopyBuffer` over a loopback TCP connection with
+// buffers of 32KB (the default of `io.Copy`), 256KB, and 1MB on both ends, with pooled buffers.
+func BenchmarkCopyBufferLoopback(b *testing.B) {
+	const payloadSize = 64 * 1024 * 1024
+	payload := make([]byte, payloadSize)
+
+	for _, size := range []int{32 * 1024, 256 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				b.Fatalf("failed to listen: %v", err)
+			}
+			defer listener.Close()
+
+			b.SetBytes(payloadSize)
+			b.ReportAllocs()
+			for b.Loop() {
+				received := make(chan error, 1)
+				go func() {
+					conn, err := listener.Accept()
+					if err != nil {
+						received <- err
+						return
+					}
+					defer conn.Close()
+					buffer := CopyBuffers.Get(size)
+					defer CopyBuffers.Put(buffer)
+					// Hide `io.Discard`'s `ReaderFrom`, which would bypass the buffer under test.
+					_, err = io.CopyBuffer(struct{ io.Writer }{io.Discard}, conn, *buffer)
+					received <- err
+				}()
+
+				conn, err := net.Dial("tcp", listener.Addr().String())
+				if err != nil {
+					b.Fatalf("failed to dial: %v", err)
+				}
+				buffer := CopyBuffers.Get(size)
+				// Hide the `WriterTo` of the reader and the `ReaderFrom` of the connection, which would bypass the buffer.
+				_, err = io.CopyBuffer(struct{ io.Writer }{conn}, struct{ io.Reader }{bytes.NewReader(payload)}, *buffer)
+				CopyBuffers.Put(buffer)
+				if err != nil {
+					b.Fatalf("failed to send: %v", err)
+				}
+				_ = conn.Close()
+				if err := <-received; err != nil {
+					b.Fatalf("failed to receive: %v", err)
+				}
+			}
+		})
+	}
+}
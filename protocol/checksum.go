@@ -1,30 +1,130 @@
 package protocol
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+)
+
+// ChecksumChunkSize is the default size of the chunks a file is read in to calculate its checksum (1MB).
+const ChecksumChunkSize = 1024 * 1024
+
+// Errors of the checksum calculation.
+var (
+	ErrRangeBeyondEOF        = errors.New("range extends beyond the end of the file")
+	ErrChecksumLimitExceeded = errors.New("content exceeds the size limit of the checksum calculation")
 )
 
 // CalculateFileChecksum calculates the SHA256 checksum of a file and returns it as a byte slice.
 func CalculateFileChecksum(file io.Reader) ([]byte, error) {
+	return CalculateFileChecksumContext(context.Background(), file)
+}
+
+// CalculateFileChecksumContext calculates the SHA-256 checksum of a file like `CalculateFileChecksum`,
+// but reads it in bounded chunks and stops with the context error as soon as the context is done.
+func CalculateFileChecksumContext(ctx context.Context, file io.Reader) ([]byte, error) {
+	return CalculateFileChecksumChunked(ctx, file, ChecksumChunkSize)
+}
+
+// CalculateFileChecksumLimit calculates the SHA-256 checksum of a file like `CalculateFileChecksumContext`,
+// but fails with `ErrChecksumLimitExceeded` once more than `maxBytes` bytes are read (no limit if 0),
+// e.g. for a file that grew since its size was checked.
+func CalculateFileChecksumLimit(ctx context.Context, file io.Reader, maxBytes int64) ([]byte, error) {
+	return calculateChecksum(ctx, file, ChecksumChunkSize, maxBytes)
+}
+
+// CalculateFileChecksumChunked calculates the SHA-256 checksum of a file like `CalculateFileChecksumContext`,
+// reading it in chunks of `chunkSize` bytes, e.g. smaller ones to check the context more often.
+func CalculateFileChecksumChunked(ctx context.Context, file io.Reader, chunkSize int) ([]byte, error) {
+	return calculateChecksum(ctx, file, chunkSize, 0)
+}
+
+// calculateChecksum calculates the SHA-256 checksum of a file in chunks of `chunkSize` bytes,
+// checking the context between chunks and failing once more than `maxBytes` bytes are read (no limit if 0).
+func calculateChecksum(ctx context.Context, file io.Reader, chunkSize int, maxBytes int64) ([]byte, error) {
 	if file == nil {
 		return nil, fmt.Errorf("file reader is nil")
 	}
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d: must be positive", chunkSize)
+	}
+	if maxBytes < 0 {
+		return nil, fmt.Errorf("invalid size limit %d: must not be negative", maxBytes)
+	}
 
 	hash := sha256.New()
 
-	// Use a 1MB buffer for better performance on large files.
-	buffer := make([]byte, 1024*1024)
-	_, err := io.CopyBuffer(hash, file, buffer)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file for checksum calculation: %w", err)
+	pooled := CopyBuffers.Get(chunkSize)
+	defer CopyBuffers.Put(pooled)
+	buffer := *pooled
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("checksum calculation interrupted: %w", err)
+		}
+
+		n, err := file.Read(buffer)
+		if n > 0 {
+			total += int64(n)
+			if maxBytes > 0 && total > maxBytes {
+				return nil, fmt.Errorf("%w: read more than %d bytes", ErrChecksumLimitExceeded, maxBytes)
+			}
+			hash.Write(buffer[:n])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file for checksum calculation: %w", err)
+		}
 	}
 
 	checksum := hash.Sum(nil)
 	return checksum, nil
 }
 
+// CalculateRangeChecksum calculates the SHA-256 checksum of the `length` bytes of the file starting at `offset`,
+// e.g. the prefix of a file already sent by an interrupted transfer. A range extending beyond the end of the file
+// fails with `ErrRangeBeyondEOF`, rather than hashing the shorter content.
+func CalculateRangeChecksum(r io.ReaderAt, offset, length int64) ([]byte, error) {
+	if r == nil {
+		return nil, fmt.Errorf("file reader is nil")
+	}
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range of %d bytes at offset %d", length, offset)
+	}
+
+	hash := sha256.New()
+	buffer := CopyBuffers.Get(ChecksumChunkSize)
+	defer CopyBuffers.Put(buffer)
+	n, err := io.CopyBuffer(hash, io.NewSectionReader(r, offset, length), *buffer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file for checksum calculation: %w", err)
+	}
+	if n < length {
+		return nil, fmt.Errorf("%w: %d bytes at offset %d requested, only %d available", ErrRangeBeyondEOF, length, offset, n)
+	}
+	return hash.Sum(nil), nil
+}
+
+// CalculateFileChecksumFromPath opens the file at the given path and calculates its SHA-256 checksum
+// with `CalculateFileChecksumContext`, so that it stops as soon as the context is done.
+func CalculateFileChecksumFromPath(ctx context.Context, path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for checksum calculation: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return CalculateFileChecksumContext(ctx, file)
+}
+
 // CalculateDataChecksum calculates the SHA-256 checksum of data and returns it as a byte slice.
 func CalculateDataChecksum(data []byte) []byte {
 	hash := sha256.New()
@@ -32,19 +132,10 @@ func CalculateDataChecksum(data []byte) []byte {
 	return hash.Sum(nil)
 }
 
-// compareChecksums compares two checksums.
+// compareChecksums compares two checksums in constant time, so that the time taken does not reveal
+// how many leading bytes match. Checksums of different lengths are never equal.
 func compareChecksums(a, b []byte) bool {
-	if len(a) != len(b) {
-		return false
-	}
-
-	for i := range a {
-		if a[i] != b[i] {
-			return false
-		}
-	}
-
-	return true
+	return subtle.ConstantTimeCompare(a, b) == 1
 }
 
 // VerifyDataChecksum verifies the SHA-256 checksum of data.
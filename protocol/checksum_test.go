@@ -2,7 +2,12 @@ package protocol
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -120,19 +125,39 @@ func TestCalculateDataChecksumSuccess(t *testing.T) {
 
 // TestCompareChecksums tests the `compareChecksums` function.
 func TestCompareChecksums(t *testing.T) {
-	a := []byte{1, 2, 3, 4, 5}
-	b := []byte{1, 2, 3, 4, 5}
-	c := []byte{1, 2, 3, 4, 6}
-	d := []byte{1, 2, 3, 4}
+	checksum := CalculateDataChecksum([]byte("test data"))
+	flipped := bytes.Clone(checksum)
+	flipped[len(flipped)-1] ^= 1
 
-	if compareChecksums(a, d) {
-		t.Error("expected checksums a and d to be different due to length")
+	tests := []struct {
+		name     string
+		a, b     []byte
+		expected bool
+	}{
+		{"equal", checksum, bytes.Clone(checksum), true},
+		{"unequal same length", checksum, flipped, false},
+		{"unequal first byte", []byte{1, 2, 3, 4, 5}, []byte{0, 2, 3, 4, 5}, false},
+		{"unequal length", checksum, checksum[:len(checksum)-1], false},
+		{"empty and non-empty", nil, checksum, false},
 	}
-	if !compareChecksums(a, b) {
-		t.Error("expected checksums a and b to be equal")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareChecksums(tt.a, tt.b); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
 	}
-	if compareChecksums(a, c) {
-		t.Error("expected checksums a and c to be different")
+}
+
+// BenchmarkCompareChecksums measures `compareChecksums` on SHA-256 checksums differing in their last byte,
+// the slowest case for an early-exit comparison.
+func BenchmarkCompareChecksums(b *testing.B) {
+	checksum := CalculateDataChecksum([]byte("test data"))
+	other := bytes.Clone(checksum)
+	other[len(other)-1] ^= 1
+
+	for b.Loop() {
+		compareChecksums(checksum, other)
 	}
 }
 
@@ -177,3 +202,174 @@ func TestVerifyDataChecksumMismatch(t *testing.T) {
 		t.Fatalf("expected 'checksum mismatch' error, got: %v", err)
 	}
 }
+
+// TestCalculateFileChecksumFromPathSuccess tests `CalculateFileChecksumFromPath` to ensure that
+// it expectedly calculates the checksum of a file on disk.
+func TestCalculateFileChecksumFromPathSuccess(t *testing.T) {
+	testData := []byte("test data")
+	path := filepath.Join(t.TempDir(), "file.txt")
+	if err := os.WriteFile(path, testData, 0644); err != nil {
+		t.Fatalf("failed to create the test file: %v", err)
+	}
+
+	got, err := CalculateFileChecksumFromPath(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, CalculateDataChecksum(testData)) {
+		t.Fatalf("expected checksum %x, got %x", CalculateDataChecksum(testData), got)
+	}
+}
+
+// TestCalculateFileChecksumFromPathMissingFile tests `CalculateFileChecksumFromPath` to ensure that
+// it expectedly returns a wrapped `fs.ErrNotExist` for a missing file.
+func TestCalculateFileChecksumFromPathMissingFile(t *testing.T) {
+	_, err := CalculateFileChecksumFromPath(context.Background(), filepath.Join(t.TempDir(), "missing.txt"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected `fs.ErrNotExist`, got: %v", err)
+	}
+}
+
+// cancelingReader is a test helper that yields an endless stream of zeros and
+// cancels a context after a given number of reads.
+type cancelingReader struct {
+	cancelAfter int                // Number of reads after which to cancel the context.
+	reads       int                // Number of reads so far.
+	cancel      context.CancelFunc // Cancels the context of the checksum calculation.
+}
+
+// Read implements the `io.Reader` interface and cancels the context after `cancelAfter` reads.
+func (cr *cancelingReader) Read(p []byte) (int, error) {
+	cr.reads++
+	if cr.reads == cr.cancelAfter {
+		cr.cancel()
+	}
+	clear(p)
+	return len(p), nil
+}
+
+// TestCalculateFileChecksumContextCanceled tests `CalculateFileChecksumContext` to ensure that
+// canceling the context mid-hash of a large reader stops the calculation with the context error.
+func TestCalculateFileChecksumContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reader := &cancelingReader{cancelAfter: 3, cancel: cancel}
+
+	got, err := CalculateFileChecksumContext(ctx, reader)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a context.Canceled error, got: %v", err)
+	}
+	if got != nil {
+		t.Fatalf("expected nil checksum on cancellation, got %x", got)
+	}
+	if reader.reads != reader.cancelAfter {
+		t.Fatalf("expected the calculation to stop right after %d reads, got %d", reader.cancelAfter, reader.reads)
+	}
+}
+
+// TestCalculateFileChecksumContextSuccess tests `CalculateFileChecksumContext` to ensure that
+// it computes the same checksum as `CalculateDataChecksum` when the context is not canceled.
+func TestCalculateFileChecksumContextSuccess(t *testing.T) {
+	data := bytes.Repeat([]byte("filexfer"), 300*1024)
+
+	got, err := CalculateFileChecksumContext(context.Background(), bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(got, CalculateDataChecksum(data)) {
+		t.Fatalf("expected checksum %x, got %x", CalculateDataChecksum(data), got)
+	}
+}
+
+// TestCalculateFileChecksumChunked tests `CalculateFileChecksumChunked` to ensure that
+// the checksum does not depend on the chunk size, and that an invalid chunk size is rejected.
+func TestCalculateFileChecksumChunked(t *testing.T) {
+	data := bytes.Repeat([]byte("filexfer"), 1000)
+
+	for _, chunkSize := range []int{1, 7, 4096, ChecksumChunkSize} {
+		got, err := CalculateFileChecksumChunked(context.Background(), bytes.NewReader(data), chunkSize)
+		if err != nil {
+			t.Fatalf("unexpected error with %d-byte chunks: %v", chunkSize, err)
+		}
+		if !bytes.Equal(got, CalculateDataChecksum(data)) {
+			t.Fatalf("expected checksum %x with %d-byte chunks, got %x", CalculateDataChecksum(data), chunkSize, got)
+		}
+	}
+
+	if _, err := CalculateFileChecksumChunked(context.Background(), bytes.NewReader(data), 0); err == nil {
+		t.Fatal("expected error for the zero chunk size, got nil")
+	}
+}
+
+// TestCalculateFileChecksumLimit tests `CalculateFileChecksumLimit` to ensure that
+// content up to the limit is hashed, and content beyond it fails with `ErrChecksumLimitExceeded`.
+func TestCalculateFileChecksumLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("filexfer"), 1000)
+
+	for _, maxBytes := range []int64{0, int64(len(data)), int64(len(data)) + 1} {
+		got, err := CalculateFileChecksumLimit(context.Background(), bytes.NewReader(data), maxBytes)
+		if err != nil {
+			t.Fatalf("unexpected error with a limit of %d bytes: %v", maxBytes, err)
+		}
+		if !bytes.Equal(got, CalculateDataChecksum(data)) {
+			t.Fatalf("expected checksum %x with a limit of %d bytes, got %x", CalculateDataChecksum(data), maxBytes, got)
+		}
+	}
+
+	_, err := CalculateFileChecksumLimit(context.Background(), bytes.NewReader(data), int64(len(data))-1)
+	if !errors.Is(err, ErrChecksumLimitExceeded) {
+		t.Fatalf("expected ErrChecksumLimitExceeded, got %v", err)
+	}
+	if _, err := CalculateFileChecksumLimit(context.Background(), bytes.NewReader(data), -1); err == nil {
+		t.Fatal("expected error for the negative limit, got nil")
+	}
+}
+
+// TestCalculateRangeChecksum tests `CalculateRangeChecksum` to ensure that
+// the checksum of a range equals the checksum of that slice hashed on its own.
+func TestCalculateRangeChecksum(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	reader := bytes.NewReader(data)
+
+	tests := []struct {
+		offset int64
+		length int64
+	}{
+		{0, int64(len(data))},
+		{0, 100},
+		{1234, 4321},
+		{int64(len(data)) - 1, 1},
+		{500, 0},
+		{int64(len(data)), 0},
+	}
+	for _, tt := range tests {
+		got, err := CalculateRangeChecksum(reader, tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("unexpected error for %d bytes at offset %d: %v", tt.length, tt.offset, err)
+		}
+		expected := CalculateDataChecksum(data[tt.offset : tt.offset+tt.length])
+		if !bytes.Equal(got, expected) {
+			t.Fatalf("expected checksum %x for %d bytes at offset %d, got %x", expected, tt.length, tt.offset, got)
+		}
+	}
+}
+
+// TestCalculateRangeChecksumErrors tests `CalculateRangeChecksum` to ensure that
+// ranges beyond the end of the file, negative ranges, and a nil reader are rejected.
+func TestCalculateRangeChecksumErrors(t *testing.T) {
+	reader := bytes.NewReader([]byte("test data"))
+
+	for _, r := range [][2]int64{{0, 10}, {5, 5}, {9, 1}, {20, 1}} {
+		if _, err := CalculateRangeChecksum(reader, r[0], r[1]); !errors.Is(err, ErrRangeBeyondEOF) {
+			t.Errorf("expected ErrRangeBeyondEOF for %d bytes at offset %d, got %v", r[1], r[0], err)
+		}
+	}
+	for _, r := range [][2]int64{{-1, 1}, {0, -1}} {
+		if _, err := CalculateRangeChecksum(reader, r[0], r[1]); err == nil || errors.Is(err, ErrRangeBeyondEOF) {
+			t.Errorf("expected an invalid range error for %d bytes at offset %d, got %v", r[1], r[0], err)
+		}
+	}
+	if _, err := CalculateRangeChecksum(nil, 0, 1); err == nil {
+		t.Error("expected error for the nil reader, got nil")
+	}
+}
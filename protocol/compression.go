@@ -0,0 +1,225 @@
+package protocol
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Constants for representing the compression of a transfer's content.
+const (
+	CompressionNone = 0 // Content is sent as is.
+	CompressionGzip = 1 // Content is compressed with gzip.
+	CompressionZstd = 2 // Content is compressed with zstd.
+)
+
+// ZstdMaxWindowSize is the largest zstd window accepted when decompressing (64MB), which bounds the decoder's memory.
+const ZstdMaxWindowSize = 64 * 1024 * 1024
+
+// ErrTrailingCompressedData is returned when a compressed transfer carries data after the end of the compressed content.
+var ErrTrailingCompressedData = errors.New("unexpected data after the compressed content")
+
+// A Codec compresses and decompresses the content of transfers.
+type Codec interface {
+	// Name returns the name of the codec, as given on the command line (e.g. "gzip").
+	Name() string
+	// NewEncoder returns a writer that compresses the data written to it into `w` until it is closed.
+	NewEncoder(w io.Writer) (io.WriteCloser, error)
+	// NewDecoder returns a reader that decompresses the data read from `r`.
+	NewDecoder(r io.Reader) (io.ReadCloser, error)
+}
+
+// codecs holds the supported codecs by their compression value in the header.
+var codecs = map[uint8]Codec{
+	CompressionGzip: gzipCodec{},
+	CompressionZstd: zstdCodec{},
+}
+
+// LookupCodec returns the codec of a compression value, or nil for `CompressionNone`.
+func LookupCodec(compression uint8) (Codec, error) {
+	if compression == CompressionNone {
+		return nil, nil
+	}
+	codec, ok := codecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("%w: compression %d is not supported", ErrInvalidCompression, compression)
+	}
+	return codec, nil
+}
+
+// ParseCompression returns the compression value of a codec name, or of "none".
+func ParseCompression(name string) (uint8, error) {
+	if name == "none" {
+		return CompressionNone, nil
+	}
+	for compression, codec := range codecs {
+		if codec.Name() == name {
+			return compression, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown compression %q", ErrInvalidCompression, name)
+}
+
+// CompressionNames returns the names accepted by `ParseCompression`, starting with "none".
+func CompressionNames() []string {
+	names := []string{}
+	for _, codec := range codecs {
+		names = append(names, codec.Name())
+	}
+	sort.Strings(names)
+	return append([]string{"none"}, names...)
+}
+
+// gzipCodec compresses with gzip (`compress/gzip`).
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) NewEncoder(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewDecoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// zstdCodec compresses with zstd (`github.com/klauspost/compress/zstd`).
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) NewEncoder(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewDecoder(r io.Reader) (io.ReadCloser, error) {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(ZstdMaxWindowSize))
+	if err != nil {
+		return nil, err
+	}
+	return decoder.IOReadCloser(), nil
+}
+
+// A CompressedWriter writes the content of a compressed transfer.
+// Format: the compressed content, framed as a stream (see `StreamWriter`), so that its end is known without its size.
+// The checksum in the header covers the uncompressed content, while the stream's trailing checksum covers the compressed bytes.
+type CompressedWriter struct {
+	stream  *StreamWriter  // Stream framing of the compressed content.
+	encoder io.WriteCloser // Encoder writing into the stream.
+	written uint64         // Number of uncompressed bytes written so far.
+}
+
+// NewCompressedWriter instantiates a new compressed writer on top of the given writer.
+func NewCompressedWriter(w io.Writer, compression uint8) (*CompressedWriter, error) {
+	codec, err := LookupCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return nil, fmt.Errorf("%w: a compressed writer requires a compression", ErrInvalidCompression)
+	}
+
+	stream := NewStreamWriter(w)
+	encoder, err := codec.NewEncoder(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s encoder: %w", codec.Name(), err)
+	}
+	return &CompressedWriter{stream: stream, encoder: encoder}, nil
+}
+
+// Write implements the `io.Writer` interface and compresses the data.
+func (cw *CompressedWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.encoder.Write(p)
+	cw.written += uint64(n)
+	return n, err
+}
+
+// Close flushes the compressed content and writes the end of the stream.
+// It does not close the underlying writer.
+func (cw *CompressedWriter) Close() error {
+	if err := cw.encoder.Close(); err != nil {
+		return fmt.Errorf("failed to flush the compressed content: %w", err)
+	}
+	return cw.stream.Close()
+}
+
+// Written returns the number of uncompressed bytes written so far.
+func (cw *CompressedWriter) Written() uint64 {
+	return cw.written
+}
+
+// CompressedBytes returns the number of compressed bytes written so far.
+func (cw *CompressedWriter) CompressedBytes() uint64 {
+	return cw.stream.Written()
+}
+
+// A CompressedReader reads the uncompressed content of a compressed transfer written by a `CompressedWriter`.
+// At the end of the compressed content, it reads the rest of the stream, so that the next message on the connection
+// starts right after it, and verifies the stream's checksum.
+type CompressedReader struct {
+	stream  *StreamReader // Stream framing of the compressed content.
+	decoder io.ReadCloser // Decoder reading from the stream.
+	err     error         // Sticky error (including `io.EOF` at the verified end of the stream).
+}
+
+// NewCompressedReader instantiates a new compressed reader on top of the given reader.
+// `maxCompressedSize` limits the number of compressed bytes (0 for no limit).
+func NewCompressedReader(r io.Reader, compression uint8, maxCompressedSize uint64) (*CompressedReader, error) {
+	codec, err := LookupCodec(compression)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return nil, fmt.Errorf("%w: a compressed reader requires a compression", ErrInvalidCompression)
+	}
+
+	stream := NewStreamReader(r, maxCompressedSize)
+	decoder, err := codec.NewDecoder(stream)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %s decoder: %w", codec.Name(), err)
+	}
+	return &CompressedReader{stream: stream, decoder: decoder}, nil
+}
+
+// Read implements the `io.Reader` interface and returns the uncompressed content.
+func (cr *CompressedReader) Read(p []byte) (n int, err error) {
+	if cr.err != nil {
+		return 0, cr.err
+	}
+
+	n, err = cr.decoder.Read(p)
+	if errors.Is(err, io.EOF) {
+		err = cr.finish()
+	}
+	if err != nil {
+		cr.err = err
+	}
+	return n, err
+}
+
+// finish reads the rest of the stream after the end of the compressed content.
+// It returns `io.EOF` if the stream ends there and its checksum matches.
+func (cr *CompressedReader) finish() error {
+	trailing, err := io.Copy(io.Discard, cr.stream)
+	if err != nil {
+		return err
+	}
+	if trailing > 0 {
+		return fmt.Errorf("%w: %d bytes", ErrTrailingCompressedData, trailing)
+	}
+	return io.EOF
+}
+
+// Close releases the resources of the decoder. It does not close the underlying reader.
+func (cr *CompressedReader) Close() error {
+	return cr.decoder.Close()
+}
+
+// CompressedBytes returns the number of compressed bytes read so far.
+func (cr *CompressedReader) CompressedBytes() uint64 {
+	return cr.stream.BytesRead()
+}
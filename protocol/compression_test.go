@@ -0,0 +1,126 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// compressedTestContent returns compressible content for testing the codecs.
+func compressedTestContent() []byte {
+	return bytes.Repeat([]byte("compressible line of text\n"), 100000)
+}
+
+// TestCompressedRoundTrip tests `CompressedWriter` and `CompressedReader` to ensure that
+// content compressed with every codec decompresses to the original, and that the reader stops at the end of the stream.
+func TestCompressedRoundTrip(t *testing.T) {
+	content := compressedTestContent()
+
+	for _, compression := range []uint8{CompressionGzip, CompressionZstd} {
+		var buf bytes.Buffer
+		writer, err := NewCompressedWriter(&buf, compression)
+		if err != nil {
+			t.Fatalf("compression %d: failed to create the writer: %v", compression, err)
+		}
+		if _, err := writer.Write(content); err != nil {
+			t.Fatalf("compression %d: failed to write: %v", compression, err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("compression %d: failed to close: %v", compression, err)
+		}
+		if writer.Written() != uint64(len(content)) || writer.CompressedBytes() >= uint64(len(content))/10 {
+			t.Fatalf("compression %d: expected %d bytes compressed well, got %d compressed bytes",
+				compression, len(content), writer.CompressedBytes())
+		}
+
+		// The next message on the connection follows the compressed content.
+		buf.WriteString("next message")
+
+		reader, err := NewCompressedReader(&buf, compression, 0)
+		if err != nil {
+			t.Fatalf("compression %d: failed to create the reader: %v", compression, err)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("compression %d: failed to read: %v", compression, err)
+		}
+		_ = reader.Close()
+		if !bytes.Equal(got, content) {
+			t.Fatalf("compression %d: expected the original %d bytes, got %d bytes", compression, len(content), len(got))
+		}
+		if buf.String() != "next message" {
+			t.Fatalf("compression %d: expected the reader to stop at the end of the stream, left %q", compression, buf.String())
+		}
+	}
+}
+
+// TestCompressedReaderTrailingData tests `CompressedReader` to ensure that
+// data after the end of the compressed content, within the stream, is rejected.
+func TestCompressedReaderTrailingData(t *testing.T) {
+	var compressed bytes.Buffer
+	encoder, err := zstdCodec{}.NewEncoder(&compressed)
+	if err != nil {
+		t.Fatalf("failed to create the encoder: %v", err)
+	}
+	_, _ = encoder.Write([]byte("content"))
+	_ = encoder.Close()
+
+	var buf bytes.Buffer
+	stream := NewStreamWriter(&buf)
+	_, _ = stream.Write(compressed.Bytes())
+	_, _ = stream.Write([]byte("garbage"))
+	_ = stream.Close()
+
+	reader, err := NewCompressedReader(&buf, CompressionZstd, 0)
+	if err != nil {
+		t.Fatalf("failed to create the reader: %v", err)
+	}
+	if _, err := io.ReadAll(reader); err == nil {
+		t.Fatal("expected error for the trailing data, got nil")
+	}
+}
+
+// TestCompressedReaderCorrupted tests `CompressedReader` to ensure that
+// corrupted compressed bytes fail the stream's checksum.
+func TestCompressedReaderCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	writer, err := NewCompressedWriter(&buf, CompressionGzip)
+	if err != nil {
+		t.Fatalf("failed to create the writer: %v", err)
+	}
+	_, _ = writer.Write(compressedTestContent())
+	_ = writer.Close()
+
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xFF // Corrupt the trailing checksum of the stream.
+
+	reader, err := NewCompressedReader(bytes.NewReader(data), CompressionGzip, 0)
+	if err != nil {
+		t.Fatalf("failed to create the reader: %v", err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+// TestParseCompression tests `ParseCompression` and `CompressionNames` to ensure that
+// every codec name maps to its value and unknown names are rejected.
+func TestParseCompression(t *testing.T) {
+	for name, expected := range map[string]uint8{"none": CompressionNone, "gzip": CompressionGzip, "zstd": CompressionZstd} {
+		got, err := ParseCompression(name)
+		if err != nil || got != expected {
+			t.Errorf("ParseCompression(%q) = %d, %v, expected %d", name, got, err, expected)
+		}
+	}
+	if _, err := ParseCompression("brotli"); !errors.Is(err, ErrInvalidCompression) {
+		t.Fatalf("expected ErrInvalidCompression for an unknown name, got %v", err)
+	}
+	if got := CompressionNames(); !reflect.DeepEqual(got, []string{"none", "gzip", "zstd"}) {
+		t.Fatalf("unexpected compression names: %v", got)
+	}
+	if _, err := LookupCodec(0xFF); !errors.Is(err, ErrInvalidCompression) {
+		t.Fatalf("expected ErrInvalidCompression for an unknown value, got %v", err)
+	}
+}
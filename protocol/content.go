@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Errors for content that does not match the size declared by its header.
+var (
+	ErrIncompleteContent = errors.New("client sent less data than declared")
+	ErrTrailingData      = errors.New("client sent more data than declared")
+)
+
+// A ContentReader reads exactly the `FileSize` bytes of content declared by a header, through an `io.LimitedReader`,
+// so that the bytes following the content are left on the connection.
+// Content ending before the declared size fails with `ErrIncompleteContent` rather than a plain `io.EOF`.
+type ContentReader struct {
+	limited *io.LimitedReader // Reader of the bytes of content not read yet.
+	size    int64             // Declared size of the content in bytes.
+}
+
+// NewContentReader instantiates a reader of the `size` bytes of content at the start of the reader.
+func NewContentReader(r io.Reader, size int64) *ContentReader {
+	return &ContentReader{
+		limited: &io.LimitedReader{R: r, N: size},
+		size:    size,
+	}
+}
+
+// Read reads from the content, returning `io.EOF` once the declared size is read.
+func (cr *ContentReader) Read(p []byte) (int, error) {
+	n, err := cr.limited.Read(p)
+	if errors.Is(err, io.EOF) && cr.limited.N > 0 {
+		return n, fmt.Errorf("%w: received %d of %d bytes", ErrIncompleteContent, cr.size-cr.limited.N, cr.size)
+	}
+	return n, err
+}
+
+// CheckTrailingData reports whether the peer sent data after the content it declared, by waiting up to `wait`
+// for a byte to arrive on the connection, and returns `ErrTrailingData` if one does.
+// A peer that waits for the response before sending its next request has nothing in flight, so any byte is trailing data;
+// it would otherwise be parsed as the next header. The check is best-effort: data arriving after `wait` is not detected.
+// The read deadline of the connection is left at `wait`, so the caller must set its own before the next read.
+func CheckTrailingData(conn net.Conn, wait time.Duration) error {
+	if err := conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return fmt.Errorf("failed to set a read deadline: %w", err)
+	}
+
+	var b [1]byte
+	n, err := conn.Read(b[:])
+	if n > 0 {
+		return ErrTrailingData
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestContentReader tests `ContentReader` to ensure that
+// exactly the declared size is read, leaving the following bytes unread, and that short content is reported.
+func TestContentReader(t *testing.T) {
+	source := bytes.NewReader([]byte("0123456789trailing"))
+	content, err := io.ReadAll(NewContentReader(source, 10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != "0123456789" {
+		t.Fatalf("expected the 10 declared bytes, got %q", content)
+	}
+	if source.Len() != len("trailing") {
+		t.Fatalf("expected the trailing bytes to be left unread, %d bytes remain", source.Len())
+	}
+
+	content, err = io.ReadAll(NewContentReader(bytes.NewReader([]byte("short")), 10))
+	if !errors.Is(err, ErrIncompleteContent) {
+		t.Fatalf("expected ErrIncompleteContent for under-sent content, got %v", err)
+	}
+	if string(content) != "short" {
+		t.Fatalf("expected the received bytes to be returned, got %q", content)
+	}
+}
+
+// TestCheckTrailingData tests `CheckTrailingData` to ensure that
+// a byte sent after the declared content is reported, while silence and a closed connection are not.
+func TestCheckTrailingData(t *testing.T) {
+	tests := []struct {
+		name    string
+		send    func(conn net.Conn)
+		wantErr error
+	}{
+		{"over-sent content", func(conn net.Conn) { _, _ = conn.Write([]byte("extra")) }, ErrTrailingData},
+		{"no trailing data", func(net.Conn) {}, nil},
+		{"closed connection", func(conn net.Conn) { _ = conn.Close() }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConn, clientConn := net.Pipe()
+			defer func() {
+				_ = serverConn.Close()
+				_ = clientConn.Close()
+			}()
+			go tt.send(clientConn)
+
+			err := CheckTrailingData(serverConn, 50*time.Millisecond)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
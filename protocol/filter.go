@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// A PathFilter decides which entries of a directory transfer are sent based on glob patterns.
+// Patterns are matched against slash-normalized paths relative to the transferred directory:
+// a pattern without a slash (e.g. `*.log` or `node_modules`) matches the base name at any depth,
+// while a pattern with a slash is matched against the whole relative path, where `**` matches any number of segments.
+// Ignore rules (from an ignore file) are evaluated first, exclude patterns then apply on top of them,
+// and include patterns override both.
+type PathFilter struct {
+	Include []string     // Patterns that re-admit otherwise excluded paths.
+	Exclude []string     // Patterns of paths to be left out of the transfer.
+	Ignore  []IgnoreRule // Gitignore-style rules, where the last matching rule wins.
+}
+
+// NewPathFilter instantiates a new path filter after checking that all patterns are well-formed.
+func NewPathFilter(include, exclude []string) (*PathFilter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if pattern == "" {
+			return nil, fmt.Errorf("filter pattern cannot be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter pattern %q: %w", pattern, err)
+		}
+	}
+
+	return &PathFilter{
+		Include: include,
+		Exclude: exclude,
+	}, nil
+}
+
+// A FilterDecision records which rule decided the fate of a path matched by a `PathFilter`.
+type FilterDecision struct {
+	Path       string `json:"path"`                 // Slash-separated relative path.
+	IsDir      bool   `json:"is_dir"`               // Whether the path is a directory (whose subtree is then pruned if excluded).
+	Excluded   bool   `json:"excluded"`             // Whether the path is left out of the transfer.
+	Rule       string `json:"rule"`                 // The rule that decided, e.g. "exclude:*.log", "include:keep.log", or "ignore:!keep.log".
+	Overridden string `json:"overridden,omitempty"` // The excluding rule overridden by an include or negated ignore rule, if any.
+}
+
+// Decide returns the decision for the given relative path, or nil if no rule matches it.
+// The last matching ignore rule, the first matching exclude pattern, and the first matching include pattern
+// (in flag order) are reported.
+// A nil filter matches nothing.
+func (f *PathFilter) Decide(relPath string, isDir bool) *FilterDecision {
+	if f == nil {
+		return nil
+	}
+
+	relPath = strings.TrimPrefix(path.Clean(strings.ReplaceAll(relPath, "\\", "/")), "./")
+
+	decision := f.decideIgnore(relPath, isDir)
+	if decision == nil || !decision.Excluded {
+		for _, exclude := range f.Exclude {
+			if MatchPattern(exclude, relPath) {
+				decision = &FilterDecision{
+					Path:     relPath,
+					IsDir:    isDir,
+					Excluded: true,
+					Rule:     "exclude:" + exclude,
+				}
+				break
+			}
+		}
+	}
+
+	if decision != nil && decision.Excluded {
+		for _, include := range f.Include {
+			if MatchPattern(include, relPath) {
+				decision.Excluded = false
+				decision.Overridden = decision.Rule
+				decision.Rule = "include:" + include
+				break
+			}
+		}
+	}
+
+	return decision
+}
+
+// decideIgnore returns the decision of the ignore rules for the given relative path, or nil if none matches it.
+// As with gitignore, the last matching rule wins.
+func (f *PathFilter) decideIgnore(relPath string, isDir bool) *FilterDecision {
+	var last *IgnoreRule
+	overridden := ""
+	for i := range f.Ignore {
+		rule := &f.Ignore[i]
+		if !rule.matches(relPath, isDir) {
+			continue
+		}
+
+		if !rule.Negate {
+			overridden = ""
+		} else if last != nil && !last.Negate {
+			overridden = "ignore:" + last.String()
+		}
+		last = rule
+	}
+
+	if last == nil {
+		return nil
+	}
+	return &FilterDecision{
+		Path:       relPath,
+		IsDir:      isDir,
+		Excluded:   !last.Negate,
+		Rule:       "ignore:" + last.String(),
+		Overridden: overridden,
+	}
+}
+
+// withIgnoreRules returns a copy of the filter with the given ignore rules appended.
+// A nil filter yields a filter with only the ignore rules.
+func (f *PathFilter) withIgnoreRules(rules []IgnoreRule) *PathFilter {
+	filtered := &PathFilter{}
+	if f != nil {
+		*filtered = *f
+	}
+	filtered.Ignore = append(append([]IgnoreRule{}, filtered.Ignore...), rules...)
+	return filtered
+}
+
+// Excluded reports whether the given relative path should be left out of the transfer.
+// A nil filter excludes nothing.
+func (f *PathFilter) Excluded(relPath string) bool {
+	decision := f.Decide(relPath, false)
+	return decision != nil && decision.Excluded
+}
+
+// MatchPattern reports whether the slash-separated relative path matches the glob pattern.
+// Malformed patterns never match.
+func MatchPattern(pattern, relPath string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, err := path.Match(pattern, path.Base(relPath))
+		return err == nil && matched
+	}
+
+	pattern = strings.Trim(pattern, "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(relPath, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where a `**` segment matches zero or more segments.
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern, segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+		if matched, err := path.Match(pattern[0], segments[0]); err != nil || !matched {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"testing"
+)
+
+// TestNewPathFilterInvalidPattern tests `NewPathFilter` to ensure that
+// it expectedly rejects malformed and empty patterns.
+func TestNewPathFilterInvalidPattern(t *testing.T) {
+	if _, err := NewPathFilter(nil, []string{"[a-"}); err == nil {
+		t.Fatal("expected error for a malformed exclude pattern, got nil")
+	}
+	if _, err := NewPathFilter([]string{""}, nil); err == nil {
+		t.Fatal("expected error for an empty include pattern, got nil")
+	}
+}
+
+// TestMatchPattern tests `MatchPattern` to ensure that
+// it expectedly matches base names, whole relative paths, and `**` segments.
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		relPath string
+		want    bool
+	}{
+		{"*.log", "app.log", true},
+		{"*.log", "logs/nested/app.log", true},
+		{"*.log", "app.log.txt", false},
+		{"node_modules", "web/node_modules", true},
+		{"node_modules", "web/node_modules_backup", false},
+		{"docs/*.md", "docs/readme.md", true},
+		{"docs/*.md", "src/docs/readme.md", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/c.go", true},
+		{"src/**/*.go", "lib/a/c.go", false},
+		{"**/build", "a/b/build", true},
+		{"build/**", "build/x/y", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, tt.relPath); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, expected %v", tt.pattern, tt.relPath, got, tt.want)
+		}
+	}
+}
+
+// TestPathFilterExcluded tests `PathFilter.Excluded` to ensure that
+// include patterns override exclude patterns.
+func TestPathFilterExcluded(t *testing.T) {
+	filter, err := NewPathFilter([]string{"keep.log"}, []string{"*.log", ".git"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		relPath string
+		want    bool
+	}{
+		{"app.log", true},
+		{"sub/keep.log", false},
+		{".git", true},
+		{"main.go", false},
+		{"sub\\debug.log", true},
+	}
+
+	for _, tt := range tests {
+		if got := filter.Excluded(tt.relPath); got != tt.want {
+			t.Errorf("Excluded(%q) = %v, expected %v", tt.relPath, got, tt.want)
+		}
+	}
+}
+
+// TestPathFilterExcludedNilFilter tests `PathFilter.Excluded` to ensure that
+// a nil filter excludes nothing.
+func TestPathFilterExcludedNilFilter(t *testing.T) {
+	var filter *PathFilter
+	if filter.Excluded("anything.log") {
+		t.Fatal("expected a nil filter to exclude nothing")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"strings"
+	"time"
 )
 
 // Constants for header validation.
@@ -13,18 +14,32 @@ const (
 	ChecksumSize      = 32        // SHA-256 checksum size (32 bytes).
 	MaxFileNameLength = 64 * 1024 // Maximum allowed filename length (64KB).
 	MaxDirPathLength  = 64 * 1024 // Maximum allowed directory path length (64KB).
+	MaxHeaderSize     = 64 * 1024 // Maximum allowed size of an encoded header, with its filename and directory path (64KB).
 )
 
+// headerFixedSize is the size of the fixed-size fields of an encoded header: the message type, file size,
+// filename length, checksum, transfer type, directory path length, compression, and extended attributes length.
+const headerFixedSize = 1 + 8 + 4 + ChecksumSize + 1 + 4 + 1 + 4
+
 // Constants for representing transfer types.
 const (
-	TransferTypeFile      = 0 // Transfer type for single file.
-	TransferTypeDirectory = 1 // Transfer type for directory.
+	TransferTypeFile       = 0 // Transfer type for single file.
+	TransferTypeDirectory  = 1 // Transfer type for directory.
+	TransferTypeStream     = 2 // Transfer type for a single file of unknown size, sent in chunks (see `StreamWriter`).
+	TransferTypeTarArchive = 3 // Transfer type for a whole directory sent as a tar archive, framed as a stream.
+	TransferTypeRange      = 4 // Transfer type for a byte range of a single file, one of several sent in parallel (see `ByteRange`).
 )
 
 // Constants for representing message types.
 const (
 	MessageTypeValidate = 1 // Message type for validation requests.
 	MessageTypeTransfer = 2 // Message type for file transfer requests.
+	MessageTypeVerify   = 3 // Message type for verifying an already-transferred file against its checksum.
+	MessageTypeQuery    = 4 // Message type for asking whether the server already has a file before uploading it.
+	MessageTypeDelete   = 5 // Message type for deleting a file (or, with `TransferTypeDirectory`, a directory tree) on the server.
+	MessageTypeInfo     = 6 // Message type for asking the server for its limits and capabilities (see `ServerInfo`).
+	MessageTypePing     = 7 // Message type for checking that the server is up, answered with its version and uptime (see `PingMessage`).
+	MessageTypeManifest = 8 // Message type for sending the checksums of the files of a directory ahead of a sync (see `EncodeManifest`).
 )
 
 // Errors for header validation.
@@ -36,18 +51,30 @@ var (
 	ErrChecksumMismatch     = errors.New("checksum mismatch in the header")
 	ErrInvalidDirectoryPath = errors.New("invalid directory path in the header")
 	ErrDirectoryPathTooLong = errors.New("directory path length exceeds the maximum allowed size")
+	ErrHeaderTooLarge       = errors.New("header size exceeds the maximum allowed size")
 	ErrInvalidTransferType  = errors.New("invalid transfer type in the header")
 	ErrInvalidMessageType   = errors.New("invalid message type in the header")
+	ErrInvalidCompression   = errors.New("invalid compression in the header")
 )
 
 // Header represents the protocol header for file transfers.
 type Header struct {
-	MessageType   uint8  // Message type (1 for validation, 2 for transfer).
-	FileSize      uint64 // Size of the file or directory in bytes.
-	FileName      string // Name of the file or directory.
-	Checksum      []byte // SHA-256 checksum of the file or directory.
-	TransferType  uint8  // Transfer type (0 for single file, 1 for directory).
-	DirectoryPath string // Path of the directory (only used for directory transfers).
+	MessageType   uint8      // Message type (1 for validation, 2 for transfer, 3 for verification, 4 for query, 5 for deletion, 6 for information, 7 for ping, 8 for manifest).
+	FileSize      uint64     // Size of the file or directory in bytes (0 for streamed transfers and archives, whose size is unknown; the manifest size for manifests).
+	FileName      string     // Name of the file or directory.
+	Checksum      []byte     // SHA-256 checksum of the file or directory (zeroed for streamed transfers and archives, whose checksum trails the stream).
+	TransferType  uint8      // Transfer type (0 for single file, 1 for directory, 2 for stream, 3 for tar archive, 4 for range).
+	DirectoryPath string     // Subdirectory of the destination directory under which the file is stored (empty for the destination directory itself).
+	Compression   uint8      // Compression of the content (0 for none, 1 for gzip, 2 for zstd; see `CompressedWriter`).
+	Xattrs        []Xattr    // Extended attributes to restore on the written file (file and directory transfer messages only).
+	Range         *ByteRange // Byte range of the file carried by the content (range transfer messages only).
+	BlockSize     uint32     // Size of the blocks of the block checksums (0 without block checksums).
+	// BlockChecksums are the SHA-256 checksums of the consecutive blocks of `BlockSize` bytes of the file, which the server
+	// verifies as they arrive to report the first corrupted block (file and directory transfer messages only).
+	BlockChecksums [][]byte
+	// ModTime is the modification time of the file, which the server restores and compares for its "newer" strategy
+	// (file, directory, and range transfer messages only; the zero time if it is not sent).
+	ModTime time.Time
 }
 
 // validateHeader validates the header data.
@@ -56,14 +83,20 @@ func validateHeader(header *Header) error {
 		return fmt.Errorf("header is nil")
 	}
 
-	if header.MessageType != MessageTypeValidate && header.MessageType != MessageTypeTransfer {
-		return fmt.Errorf("%w: message type %d is invalid, expected %d (Validate) or %d (Transfer)",
-			ErrInvalidMessageType, header.MessageType, MessageTypeValidate, MessageTypeTransfer)
+	switch header.MessageType {
+	case MessageTypeValidate, MessageTypeTransfer, MessageTypeVerify, MessageTypeQuery, MessageTypeDelete, MessageTypeInfo, MessageTypePing,
+		MessageTypeManifest:
+		// Do nothing.
+	default:
+		return fmt.Errorf("%w: message type %d is invalid, expected %d (Validate), %d (Transfer), %d (Verify), %d (Query), %d (Delete), %d (Info), %d (Ping), or %d (Manifest)",
+			ErrInvalidMessageType, header.MessageType, MessageTypeValidate, MessageTypeTransfer, MessageTypeVerify, MessageTypeQuery, MessageTypeDelete,
+			MessageTypeInfo, MessageTypePing, MessageTypeManifest)
 	}
 
-	// `FileName` is permitted to be empty for validation messages.
-	if header.MessageType == MessageTypeTransfer && header.FileName == "" {
-		return fmt.Errorf("%w: filename cannot be empty for transfer messages", ErrInvalidFileName)
+	// `FileName` is permitted to be empty for validation, information, ping, and manifest messages only.
+	if header.MessageType != MessageTypeValidate && header.MessageType != MessageTypeInfo && header.MessageType != MessageTypePing &&
+		header.MessageType != MessageTypeManifest && header.FileName == "" {
+		return fmt.Errorf("%w: filename cannot be empty for transfer, verification, query, and deletion messages", ErrInvalidFileName)
 	}
 
 	if len(header.FileName) > MaxFileNameLength {
@@ -84,19 +117,119 @@ func validateHeader(header *Header) error {
 			ErrInvalidChecksum, len(header.Checksum), ChecksumSize)
 	}
 
-	if header.TransferType != TransferTypeFile && header.TransferType != TransferTypeDirectory {
-		return fmt.Errorf("%w: transfer type %d is invalid, expected %d or %d",
-			ErrInvalidTransferType, header.TransferType, TransferTypeFile, TransferTypeDirectory)
+	switch header.TransferType {
+	case TransferTypeFile, TransferTypeDirectory:
+		// Do nothing.
+	case TransferTypeStream, TransferTypeTarArchive, TransferTypeRange:
+		if header.MessageType != MessageTypeTransfer {
+			return fmt.Errorf("%w: transfer type %d (Stream, TarArchive, or Range) is only valid for transfer messages",
+				ErrInvalidTransferType, header.TransferType)
+		}
+	default:
+		return fmt.Errorf("%w: transfer type %d is invalid, expected %d, %d, %d, %d, or %d",
+			ErrInvalidTransferType, header.TransferType, TransferTypeFile, TransferTypeDirectory, TransferTypeStream, TransferTypeTarArchive,
+			TransferTypeRange)
 	}
 
-	if header.TransferType == TransferTypeDirectory && len(header.DirectoryPath) > MaxDirPathLength {
+	// A range transfer carries its range, and no other transfer does.
+	if header.TransferType == TransferTypeRange {
+		if header.Range == nil {
+			return fmt.Errorf("%w: range transfers must carry a range", ErrInvalidRange)
+		}
+		if err := validateRange(header.Range, header.FileSize); err != nil {
+			return err
+		}
+	} else if header.Range != nil {
+		return fmt.Errorf("%w: ranges are only valid for range transfer messages", ErrInvalidRange)
+	}
+
+	// An all-zero checksum is only expected where it is not known
+	// (validation messages, and streams and archives, which send it at the end).
+	isStreamed := header.TransferType == TransferTypeStream || header.TransferType == TransferTypeTarArchive
+	if (header.MessageType == MessageTypeTransfer && !isStreamed || header.MessageType == MessageTypeManifest) && isZeroChecksum(header.Checksum) {
+		return fmt.Errorf("%w: checksum cannot be all zeros for transfer and manifest messages", ErrInvalidChecksum)
+	}
+
+	if header.MessageType == MessageTypeManifest && header.FileSize > MaxManifestSize {
+		return fmt.Errorf("%w: manifest size %d exceeds the maximum %d", ErrInvalidFileSize, header.FileSize, MaxManifestSize)
+	}
+
+	if len(header.DirectoryPath) > MaxDirPathLength {
 		return fmt.Errorf("%w: directory path length %d exceeds the maximum %d",
 			ErrDirectoryPathTooLong, len(header.DirectoryPath), MaxDirPathLength)
 	}
 
+	if size := encodedHeaderSize(header); size > MaxHeaderSize {
+		return fmt.Errorf("%w: header size %d exceeds the maximum %d", ErrHeaderTooLarge, size, MaxHeaderSize)
+	}
+
+	// Only the content of a whole file may be compressed or carry extended attributes.
+	isWholeFile := header.TransferType == TransferTypeFile || header.TransferType == TransferTypeDirectory
+
+	if header.Compression != CompressionNone {
+		if _, err := LookupCodec(header.Compression); err != nil {
+			return err
+		}
+		if header.MessageType != MessageTypeTransfer || !isWholeFile {
+			return fmt.Errorf("%w: compression is only valid for file and directory transfer messages", ErrInvalidCompression)
+		}
+	}
+
+	if len(header.Xattrs) > 0 {
+		if header.MessageType != MessageTypeTransfer || !isWholeFile {
+			return fmt.Errorf("%w: extended attributes are only valid for file and directory transfer messages", ErrInvalidXattrs)
+		}
+		if err := validateXattrs(header.Xattrs); err != nil {
+			return err
+		}
+	}
+
+	if header.BlockSize != 0 || len(header.BlockChecksums) > 0 {
+		if header.MessageType != MessageTypeTransfer || !isWholeFile {
+			return fmt.Errorf("%w: block checksums are only valid for file and directory transfer messages", ErrInvalidBlockChecksums)
+		}
+		if err := validateBlockChecksums(header.BlockSize, header.BlockChecksums, header.FileSize); err != nil {
+			return err
+		}
+	}
+
+	if !header.ModTime.IsZero() {
+		if header.MessageType != MessageTypeTransfer || !isWholeFile && header.TransferType != TransferTypeRange {
+			return fmt.Errorf("%w: modification times are only valid for file, directory, and range transfer messages", ErrInvalidModTime)
+		}
+		if err := validateModTime(header.ModTime); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// encodedHeaderSize returns the number of bytes the header takes on the wire.
+func encodedHeaderSize(header *Header) int {
+	size := headerFixedSize + len(header.FileName) + len(header.DirectoryPath) + xattrsSize(header.Xattrs)
+	if header.Range != nil {
+		size += rangeSize
+	}
+	if header.BlockSize != 0 {
+		size += blockChecksumsSize(header.BlockChecksums)
+	}
+	if !header.ModTime.IsZero() {
+		size += modTimeSize
+	}
+	return size
+}
+
+// isZeroChecksum reports whether every byte of the checksum is zero.
+func isZeroChecksum(checksum []byte) bool {
+	for _, b := range checksum {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // WriteHeader writes the header to the given writer using length-prefixed format.
 func WriteHeader(w io.Writer, header *Header) error {
 	if w == nil {
@@ -147,14 +280,60 @@ func WriteHeader(w io.Writer, header *Header) error {
 		return fmt.Errorf("failed to write the directory path: %w", err)
 	}
 
+	// Write the compression as a single byte, flagged if block checksums or the modification time follow.
+	compression := header.Compression
+	if header.BlockSize != 0 {
+		compression |= blockChecksumsFlag
+	}
+	if !header.ModTime.IsZero() {
+		compression |= modTimeFlag
+	}
+	if _, err := w.Write([]byte{compression}); err != nil {
+		return fmt.Errorf("failed to write the compression: %w", err)
+	}
+
+	// Write the extended attributes length as 4 bytes in big-endian format, followed by the extended attributes.
+	xattrBytes := encodeXattrs(header.Xattrs)
+	if err := binary.Write(w, binary.BigEndian, uint32(len(xattrBytes))); err != nil {
+		return fmt.Errorf("failed to write the extended attributes length: %w", err)
+	}
+	if _, err := w.Write(xattrBytes); err != nil {
+		return fmt.Errorf("failed to write the extended attributes: %w", err)
+	}
+
+	// Write the byte range of a range transfer (see `encodeRange`).
+	if header.Range != nil {
+		if _, err := w.Write(encodeRange(header.Range)); err != nil {
+			return fmt.Errorf("failed to write the byte range: %w", err)
+		}
+	}
+
+	// Write the block checksums (see `encodeBlockChecksums`).
+	if header.BlockSize != 0 {
+		if _, err := w.Write(encodeBlockChecksums(header.BlockSize, header.BlockChecksums)); err != nil {
+			return fmt.Errorf("failed to write the block checksums: %w", err)
+		}
+	}
+
+	// Write the modification time (see `encodeModTime`).
+	if !header.ModTime.IsZero() {
+		if _, err := w.Write(encodeModTime(header.ModTime)); err != nil {
+			return fmt.Errorf("failed to write the modification time: %w", err)
+		}
+	}
+
 	return nil
 }
 
 // ReadHeader reads the header from the given reader using length-prefixed format.
-func ReadHeader(r io.Reader) (*Header, error) {
-	if r == nil {
+// At most `MaxHeaderSize` bytes are read, and a length field that would take the header beyond it
+// fails with `ErrHeaderTooLarge` before its data is allocated.
+func ReadHeader(reader io.Reader) (*Header, error) {
+	if reader == nil {
 		return nil, fmt.Errorf("reader is nil")
 	}
+	limited := &io.LimitedReader{R: reader, N: MaxHeaderSize}
+	r := io.Reader(limited)
 
 	// Read the message type (1 byte).
 	messageTypeBytes := make([]byte, 1)
@@ -190,6 +369,11 @@ func ReadHeader(r io.Reader) (*Header, error) {
 		return nil, fmt.Errorf("%w: filename length %d exceeds the maximum %d",
 			ErrFileNameTooLong, fileNameLength, MaxFileNameLength)
 	}
+	// The filename is followed by the checksum, transfer type, directory path length, compression, and extended attributes length.
+	if remaining := int64(fileNameLength) + ChecksumSize + 1 + 4 + 1 + 4; remaining > limited.N {
+		return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+			ErrHeaderTooLarge, MaxHeaderSize-limited.N+remaining, MaxHeaderSize)
+	}
 
 	// Read the file name (variable length).
 	fileNameBytes := make([]byte, fileNameLength)
@@ -244,6 +428,11 @@ func ReadHeader(r io.Reader) (*Header, error) {
 		return nil, fmt.Errorf("%w: directory path length %d exceeds the maximum %d",
 			ErrDirectoryPathTooLong, dirPathLength, MaxDirPathLength)
 	}
+	// The directory path is followed by the compression and extended attributes length.
+	if remaining := int64(dirPathLength) + 1 + 4; remaining > limited.N {
+		return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+			ErrHeaderTooLarge, MaxHeaderSize-limited.N+remaining, MaxHeaderSize)
+	}
 
 	// Read the directory path (variable length).
 	dirPathBytes := make([]byte, dirPathLength)
@@ -259,14 +448,128 @@ func ReadHeader(r io.Reader) (*Header, error) {
 	}
 	dirPath := string(dirPathBytes)
 
+	// Read the compression (1 byte).
+	compressionBytes := make([]byte, 1)
+	_, err = io.ReadFull(r, compressionBytes)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("unexpected end of stream while reading compression: %w", err)
+		}
+		return nil, fmt.Errorf("failed to read the compression: %w", err)
+	}
+
+	// Read the extended attributes length (4 bytes, big-endian).
+	var xattrsLength uint32
+	if err := binary.Read(r, binary.BigEndian, &xattrsLength); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("unexpected end of stream while reading extended attributes length: %w", err)
+		}
+		return nil, fmt.Errorf("failed to read the extended attributes length: %w", err)
+	}
+	if int64(xattrsLength) > limited.N {
+		return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+			ErrHeaderTooLarge, MaxHeaderSize-limited.N+int64(xattrsLength), MaxHeaderSize)
+	}
+
+	// Read and decode the extended attributes (variable length).
+	var xattrs []Xattr
+	if xattrsLength > 0 {
+		xattrBytes := make([]byte, xattrsLength)
+		n, err = io.ReadFull(r, xattrBytes)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("unexpected end of stream while reading extended attributes: got %d bytes, expected %d: %w",
+					n, xattrsLength, err)
+			}
+			return nil, fmt.Errorf("failed to read the extended attributes: %w", err)
+		}
+		if xattrs, err = decodeXattrs(xattrBytes); err != nil {
+			return nil, fmt.Errorf("invalid header read from stream: %w", err)
+		}
+	}
+
+	// Read the byte range of a range transfer (24 bytes, fixed size).
+	var byteRange *ByteRange
+	if transferType == TransferTypeRange {
+		if limited.N < rangeSize {
+			return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+				ErrHeaderTooLarge, MaxHeaderSize-limited.N+rangeSize, MaxHeaderSize)
+		}
+		rangeBytes := make([]byte, rangeSize)
+		n, err = io.ReadFull(r, rangeBytes)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("unexpected end of stream while reading byte range: got %d bytes, expected %d: %w",
+					n, rangeSize, err)
+			}
+			return nil, fmt.Errorf("failed to read the byte range: %w", err)
+		}
+		byteRange = decodeRange(rangeBytes)
+	}
+
+	// Read the block size (4 bytes, big-endian) and the block checksums (32 bytes each) of a flagged compression.
+	var blockSize uint32
+	var blockChecksums [][]byte
+	if compressionBytes[0]&blockChecksumsFlag != 0 {
+		if err := binary.Read(r, binary.BigEndian, &blockSize); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("unexpected end of stream while reading block size: %w", err)
+			}
+			return nil, fmt.Errorf("failed to read the block size: %w", err)
+		}
+		if blockSize < MinBlockSize {
+			return nil, fmt.Errorf("%w: block size %d is below the minimum %d", ErrInvalidBlockChecksums, blockSize, MinBlockSize)
+		}
+		count := BlockCount(fileSize, blockSize)
+		if remaining := int64(count) * ChecksumSize; remaining > limited.N {
+			return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+				ErrHeaderTooLarge, MaxHeaderSize-limited.N+remaining, MaxHeaderSize)
+		}
+		blockBytes := make([]byte, count*ChecksumSize)
+		n, err = io.ReadFull(r, blockBytes)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("unexpected end of stream while reading block checksums: got %d bytes, expected %d: %w",
+					n, len(blockBytes), err)
+			}
+			return nil, fmt.Errorf("failed to read the block checksums: %w", err)
+		}
+		blockChecksums = decodeBlockChecksums(blockBytes)
+	}
+
+	// Read the modification time (8 bytes, fixed size) of a flagged compression.
+	var modTime time.Time
+	if compressionBytes[0]&modTimeFlag != 0 {
+		if limited.N < modTimeSize {
+			return nil, fmt.Errorf("%w: header size %d exceeds the maximum %d",
+				ErrHeaderTooLarge, MaxHeaderSize-limited.N+modTimeSize, MaxHeaderSize)
+		}
+		modTimeBytes := make([]byte, modTimeSize)
+		n, err = io.ReadFull(r, modTimeBytes)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, fmt.Errorf("unexpected end of stream while reading modification time: got %d bytes, expected %d: %w",
+					n, modTimeSize, err)
+			}
+			return nil, fmt.Errorf("failed to read the modification time: %w", err)
+		}
+		modTime = decodeModTime(modTimeBytes)
+	}
+
 	// Create and validate the header.
 	header := &Header{
-		MessageType:   messageType,
-		FileSize:      fileSize,
-		FileName:      fileName,
-		Checksum:      checksumBytes,
-		TransferType:  transferType,
-		DirectoryPath: dirPath,
+		MessageType:    messageType,
+		FileSize:       fileSize,
+		FileName:       fileName,
+		Checksum:       checksumBytes,
+		TransferType:   transferType,
+		DirectoryPath:  dirPath,
+		Compression:    compressionBytes[0] &^ (blockChecksumsFlag | modTimeFlag),
+		Xattrs:         xattrs,
+		Range:          byteRange,
+		BlockSize:      blockSize,
+		BlockChecksums: blockChecksums,
+		ModTime:        modTime,
 	}
 	if err := validateHeader(header); err != nil {
 		return nil, fmt.Errorf("invalid header read from stream: %w", err)
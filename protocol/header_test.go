@@ -3,8 +3,11 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -84,6 +87,22 @@ func TestValidateHeaderSuccess(t *testing.T) {
 		t.Fatalf("expected valid transfer header, got error: %v", err)
 	}
 
+	// Validate a valid query header.
+	queryHeader := newValidHeader()
+	queryHeader.MessageType = MessageTypeQuery
+	if err := validateHeader(queryHeader); err != nil {
+		t.Fatalf("expected valid query header, got error: %v", err)
+	}
+
+	// Validate a valid deletion header, with a zeroed checksum since no content is involved.
+	deleteHeader := newValidHeader()
+	deleteHeader.MessageType = MessageTypeDelete
+	deleteHeader.TransferType = TransferTypeDirectory
+	deleteHeader.Checksum = make([]byte, ChecksumSize)
+	if err := validateHeader(deleteHeader); err != nil {
+		t.Fatalf("expected valid deletion header, got error: %v", err)
+	}
+
 	// Validate a valid validation header (with empty filename).
 	validationHeader := newValidHeader()
 	validationHeader.MessageType = MessageTypeValidate
@@ -91,6 +110,40 @@ func TestValidateHeaderSuccess(t *testing.T) {
 	if err := validateHeader(validationHeader); err != nil {
 		t.Fatalf("expected valid validation header, got error: %v", err)
 	}
+
+	// Validate an information header, with an empty filename and a zeroed checksum.
+	infoHeader := newValidHeader()
+	infoHeader.MessageType = MessageTypeInfo
+	infoHeader.FileName = ""
+	infoHeader.Checksum = make([]byte, ChecksumSize)
+	if err := validateHeader(infoHeader); err != nil {
+		t.Fatalf("expected valid information header, got error: %v", err)
+	}
+
+	// Validate a ping header, with an empty filename and a zeroed checksum.
+	pingHeader := newValidHeader()
+	pingHeader.MessageType = MessageTypePing
+	pingHeader.FileName = ""
+	pingHeader.Checksum = make([]byte, ChecksumSize)
+	if err := validateHeader(pingHeader); err != nil {
+		t.Fatalf("expected valid ping header, got error: %v", err)
+	}
+
+	// Validate a validation header with a zeroed checksum, as sent for directory size validation.
+	validationHeader.Checksum = make([]byte, ChecksumSize)
+	if err := validateHeader(validationHeader); err != nil {
+		t.Fatalf("expected valid validation header with a zeroed checksum, got error: %v", err)
+	}
+
+	// Validate stream and archive headers with a zeroed checksum, which trails the stream instead.
+	for _, transferType := range []uint8{TransferTypeStream, TransferTypeTarArchive} {
+		streamHeader := newValidHeader()
+		streamHeader.TransferType = transferType
+		streamHeader.Checksum = make([]byte, ChecksumSize)
+		if err := validateHeader(streamHeader); err != nil {
+			t.Fatalf("expected valid header of transfer type %d with a zeroed checksum, got error: %v", transferType, err)
+		}
+	}
 }
 
 // TestValidateHeaderErrors tests the `validateHeader` function to ensure that
@@ -101,7 +154,16 @@ func TestValidateHeaderErrors(t *testing.T) {
 		header *Header
 	}{
 		{"nil header", nil},
-		{"invalid message type", func() *Header { h := newValidHeader(); h.MessageType = 3; return h }()},
+		{"invalid message type", func() *Header { h := newValidHeader(); h.MessageType = 0xFF; return h }()},
+		{"stream transfer type for information", func() *Header {
+			h := newValidHeader()
+			h.MessageType = MessageTypeInfo
+			h.TransferType = TransferTypeStream
+			return h
+		}()},
+		{"empty filename for verification", func() *Header { h := newValidHeader(); h.MessageType = MessageTypeVerify; h.FileName = ""; return h }()},
+		{"empty filename for query", func() *Header { h := newValidHeader(); h.MessageType = MessageTypeQuery; h.FileName = ""; return h }()},
+		{"empty filename for deletion", func() *Header { h := newValidHeader(); h.MessageType = MessageTypeDelete; h.FileName = ""; return h }()},
 		{"empty filename for transfer", func() *Header { h := newValidHeader(); h.FileName = ""; return h }()},
 		{"filename too long", func() *Header { h := newValidHeader(); h.FileName = strings.Repeat("a", MaxFileNameLength+1); return h }()},
 		{"filename contains null", func() *Header { h := newValidHeader(); h.FileName = "bad\x00name"; return h }()},
@@ -111,13 +173,78 @@ func TestValidateHeaderErrors(t *testing.T) {
 			h.Checksum = bytes.Repeat([]byte{0x01}, ChecksumSize-1)
 			return h
 		}()},
-		{"invalid transfer type", func() *Header { h := newValidHeader(); h.TransferType = 3; return h }()},
+		{"zeroed checksum for transfer", func() *Header { h := newValidHeader(); h.Checksum = make([]byte, ChecksumSize); return h }()},
+		{"invalid transfer type", func() *Header { h := newValidHeader(); h.TransferType = 5; return h }()},
+		{"archive transfer type for query", func() *Header {
+			h := newValidHeader()
+			h.MessageType = MessageTypeQuery
+			h.TransferType = TransferTypeTarArchive
+			return h
+		}()},
+		{"compressed archive", func() *Header {
+			h := newValidHeader()
+			h.TransferType = TransferTypeTarArchive
+			h.Compression = CompressionGzip
+			return h
+		}()},
+		{"stream transfer type for verification", func() *Header {
+			h := newValidHeader()
+			h.MessageType = MessageTypeVerify
+			h.TransferType = TransferTypeStream
+			return h
+		}()},
 		{"directory path too long", func() *Header {
 			h := newValidHeader()
 			h.TransferType = TransferTypeDirectory
 			h.DirectoryPath = strings.Repeat("d", MaxDirPathLength+1)
 			return h
 		}()},
+		{"directory path too long for a single file", func() *Header {
+			h := newValidHeader()
+			h.DirectoryPath = strings.Repeat("d", MaxDirPathLength+1)
+			return h
+		}()},
+		{"header too large", func() *Header {
+			h := newValidHeader()
+			h.FileName = strings.Repeat("a", MaxFileNameLength/2)
+			h.DirectoryPath = strings.Repeat("d", MaxDirPathLength/2)
+			return h
+		}()},
+		{"unknown compression", func() *Header { h := newValidHeader(); h.Compression = 0xFF; return h }()},
+		{"compressed stream", func() *Header {
+			h := newValidHeader()
+			h.TransferType = TransferTypeStream
+			h.Compression = CompressionGzip
+			return h
+		}()},
+		{"compressed verification", func() *Header {
+			h := newValidHeader()
+			h.MessageType = MessageTypeVerify
+			h.Compression = CompressionZstd
+			return h
+		}()},
+		{"extended attributes on a stream", func() *Header {
+			h := newValidHeader()
+			h.TransferType = TransferTypeStream
+			h.Xattrs = []Xattr{{Name: "user.a", Value: []byte("1")}}
+			return h
+		}()},
+		{"extended attributes on a query", func() *Header {
+			h := newValidHeader()
+			h.MessageType = MessageTypeQuery
+			h.Xattrs = []Xattr{{Name: "user.a", Value: []byte("1")}}
+			return h
+		}()},
+		{"duplicate extended attributes", func() *Header {
+			h := newValidHeader()
+			h.Xattrs = []Xattr{{Name: "user.a"}, {Name: "user.a"}}
+			return h
+		}()},
+		{"extended attributes too large", func() *Header {
+			h := newValidHeader()
+			h.Xattrs = []Xattr{{Name: "user.a", Value: make([]byte, MaxHeaderSize)}}
+			return h
+		}()},
 	}
 
 	for _, tt := range tests {
@@ -130,10 +257,25 @@ func TestValidateHeaderErrors(t *testing.T) {
 	}
 }
 
+// TestValidateHeaderZeroChecksum tests the `validateHeader` function to ensure that
+// a transfer header with an all-zero checksum is rejected with `ErrInvalidChecksum`.
+func TestValidateHeaderZeroChecksum(t *testing.T) {
+	for _, transferType := range []uint8{TransferTypeFile, TransferTypeDirectory} {
+		header := newValidHeader()
+		header.TransferType = transferType
+		header.Checksum = make([]byte, ChecksumSize)
+		if err := validateHeader(header); !errors.Is(err, ErrInvalidChecksum) {
+			t.Fatalf("expected ErrInvalidChecksum for transfer type %d, got %v", transferType, err)
+		}
+	}
+}
+
 // TestWriteAndReadHeaderRoundTrip tests a round-trip write and read of a header.
 func TestWriteAndReadHeaderRoundTrip(t *testing.T) {
 	buf := &bytes.Buffer{}
 	header := newValidHeader()
+	header.Compression = CompressionZstd
+	header.Xattrs = []Xattr{{Name: "user.comment", Value: []byte("hello")}, {Name: "user.empty", Value: []byte{}}}
 
 	if err := WriteHeader(buf, header); err != nil {
 		t.Fatalf("WriteHeader returned error: %v", err)
@@ -162,6 +304,12 @@ func TestWriteAndReadHeaderRoundTrip(t *testing.T) {
 	if got.DirectoryPath != header.DirectoryPath {
 		t.Errorf("DirectoryPath mismatch: got %s, want %s", got.DirectoryPath, header.DirectoryPath)
 	}
+	if got.Compression != header.Compression {
+		t.Errorf("Compression mismatch: got %d, want %d", got.Compression, header.Compression)
+	}
+	if !reflect.DeepEqual(got.Xattrs, header.Xattrs) {
+		t.Errorf("Xattrs mismatch: got %v, want %v", got.Xattrs, header.Xattrs)
+	}
 }
 
 // TestWriteHeaderErrors tests the `WriteHeader` function to ensure that it
@@ -393,11 +541,146 @@ func TestReadHeaderErrors(t *testing.T) {
 	buf.Write(name)
 	buf.Write(bytes.Repeat([]byte{0x01}, ChecksumSize))
 	// Intentionally write an invalid transfer type.
-	buf.WriteByte(3)
+	buf.WriteByte(5)
+	if err := binary.Write(buf, binary.BigEndian, uint32(0)); err != nil {
+		t.Fatalf("failed to write to the buffer: %v", err)
+	}
+	buf.WriteByte(CompressionNone)
 	if err := binary.Write(buf, binary.BigEndian, uint32(0)); err != nil {
 		t.Fatalf("failed to write to the buffer: %v", err)
 	}
 	if _, err := ReadHeader(bytes.NewReader(buf.Bytes())); err == nil || !strings.Contains(err.Error(), "invalid transfer type in the header") {
 		t.Fatalf("expected 'invalid transfer type in the header' error, got %v", err)
 	}
+
+	// EOF while reading the compression, and an unknown compression value.
+	buf.Reset()
+	buf.WriteByte(MessageTypeTransfer)
+	if err := binary.Write(buf, binary.BigEndian, uint64(1)); err != nil {
+		t.Fatalf("failed to write to the buffer: %v", err)
+	}
+	name = []byte("f")
+	if err := binary.Write(buf, binary.BigEndian, uint32(len(name))); err != nil {
+		t.Fatalf("failed to write to the buffer: %v", err)
+	}
+	buf.Write(name)
+	buf.Write(bytes.Repeat([]byte{0x01}, ChecksumSize))
+	buf.WriteByte(TransferTypeFile)
+	if err := binary.Write(buf, binary.BigEndian, uint32(0)); err != nil {
+		t.Fatalf("failed to write to the buffer: %v", err)
+	}
+	if _, err := ReadHeader(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("expected error for EOF while reading the compression, got nil")
+	}
+	// Intentionally write an unknown compression.
+	buf.WriteByte(9)
+	if _, err := ReadHeader(bytes.NewReader(buf.Bytes())); err == nil {
+		t.Fatalf("expected error for EOF while reading the extended attributes length, got nil")
+	}
+	if err := binary.Write(buf, binary.BigEndian, uint32(0)); err != nil {
+		t.Fatalf("failed to write to the buffer: %v", err)
+	}
+	if _, err := ReadHeader(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrInvalidCompression) {
+		t.Fatalf("expected ErrInvalidCompression, got %v", err)
+	}
+}
+
+// hostileHeader returns the encoding of a transfer header whose filename and directory path lengths
+// are each within their own maximum, but together take the header beyond `MaxHeaderSize`.
+func hostileHeader(fileNameLength, dirPathLength uint32) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(MessageTypeTransfer)
+	buf.Write(u64Bytes(1))
+	buf.Write(u32Bytes(fileNameLength))
+	buf.Write(bytes.Repeat([]byte("a"), int(fileNameLength)))
+	buf.Write(bytes.Repeat([]byte{0x01}, ChecksumSize))
+	buf.WriteByte(TransferTypeFile)
+	buf.Write(u32Bytes(dirPathLength))
+	buf.Write(bytes.Repeat([]byte("d"), int(dirPathLength)))
+	buf.WriteByte(CompressionNone)
+	buf.Write(u32Bytes(0))
+	return buf.Bytes()
+}
+
+// TestReadHeaderTooLarge tests the `ReadHeader` function to ensure that
+// a header beyond `MaxHeaderSize` fails with `ErrHeaderTooLarge` without reading its oversized field,
+// while a header of exactly `MaxHeaderSize` is accepted.
+func TestReadHeaderTooLarge(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileNameLength uint32
+		dirPathLength  uint32
+		consumed       int // Bytes read before the error.
+	}{
+		{"filename", MaxFileNameLength, 0, 1 + 8 + 4},
+		{"directory path", MaxFileNameLength / 2, MaxDirPathLength / 2, 1 + 8 + 4 + MaxFileNameLength/2 + ChecksumSize + 1 + 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := hostileHeader(tt.fileNameLength, tt.dirPathLength)
+			reader := bytes.NewReader(data)
+			if _, err := ReadHeader(reader); !errors.Is(err, ErrHeaderTooLarge) {
+				t.Fatalf("expected ErrHeaderTooLarge, got %v", err)
+			}
+			if consumed := len(data) - reader.Len(); consumed != tt.consumed {
+				t.Fatalf("expected %d bytes to be read before the error, got %d", tt.consumed, consumed)
+			}
+		})
+	}
+
+	data := hostileHeader(MaxHeaderSize-headerFixedSize, 0)
+	header, err := ReadHeader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected a header of exactly %d bytes to be accepted, got %v", MaxHeaderSize, err)
+	}
+	if err := WriteHeader(io.Discard, header); err != nil {
+		t.Fatalf("expected a header of exactly %d bytes to be written, got %v", MaxHeaderSize, err)
+	}
+}
+
+// zeroReader is an `io.Reader` of endless zero bytes.
+type zeroReader struct{}
+
+// Read implements the `io.Reader` interface and fills the buffer with zeros.
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// FuzzReadHeader fuzzes the `ReadHeader` function to ensure that it never panics, never reads more than
+// `MaxHeaderSize` bytes, and never allocates much more than twice `MaxHeaderSize` (each variable-length field
+// is read into a buffer and copied into a string), whatever lengths the bytes declare.
+// The fuzzed bytes are followed by endless zeros, so that every declared length can be satisfied.
+func FuzzReadHeader(f *testing.F) {
+	buf := &bytes.Buffer{}
+	if err := WriteHeader(buf, newValidHeader()); err != nil {
+		f.Fatalf("failed to encode the header: %v", err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte{})
+	// Hostile lengths, whose data is left to the zeros.
+	f.Add(hostileHeader(MaxFileNameLength, 0)[:1+8+4])
+	f.Add(hostileHeader(MaxHeaderSize-headerFixedSize, 0)[:1+8+4])
+	f.Add([]byte{MessageTypeTransfer, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF})
+	dirPathLength := hostileHeader(1, MaxDirPathLength)
+	f.Add(dirPathLength[:len(dirPathLength)-MaxDirPathLength-1-4])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		source := &io.LimitedReader{R: io.MultiReader(bytes.NewReader(data), zeroReader{}), N: 1 << 30}
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		header, err := ReadHeader(source)
+		runtime.ReadMemStats(&after)
+
+		if consumed := 1<<30 - source.N; consumed > MaxHeaderSize {
+			t.Fatalf("expected at most %d bytes to be read, got %d", MaxHeaderSize, consumed)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 2*MaxHeaderSize+16*1024 {
+			t.Fatalf("expected at most about %d bytes to be allocated, got %d", 2*MaxHeaderSize, allocated)
+		}
+		if err == nil && encodedHeaderSize(header) > MaxHeaderSize {
+			t.Fatalf("expected the header to be at most %d bytes, got %d", MaxHeaderSize, encodedHeaderSize(header))
+		}
+	})
 }
@@ -0,0 +1,106 @@
+package protocol
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// IgnoreFileName is the name of the ignore file honored at the root of a transferred directory.
+const IgnoreFileName = ".filexferignore"
+
+// An IgnoreRule is a single gitignore-style rule of an ignore file.
+type IgnoreRule struct {
+	Pattern string // Glob pattern, without the leading `!` and the trailing `/`.
+	Negate  bool   // Whether the rule re-includes paths excluded by earlier rules (`!pattern`).
+	DirOnly bool   // Whether the rule only matches directories (`pattern/`).
+}
+
+// String returns the rule as it would be written in an ignore file.
+func (r IgnoreRule) String() string {
+	s := r.Pattern
+	if r.Negate {
+		s = "!" + s
+	}
+	if r.DirOnly {
+		s += "/"
+	}
+	return s
+}
+
+// matches reports whether the rule matches the slash-separated relative path.
+func (r IgnoreRule) matches(relPath string, isDir bool) bool {
+	if r.DirOnly && !isDir {
+		return false
+	}
+	return MatchPattern(r.Pattern, relPath)
+}
+
+// ParseIgnoreRules parses gitignore-style rules, one per line.
+// Blank lines and lines starting with `#` are skipped, a leading `!` negates the rule,
+// a trailing `/` restricts it to directories, and a leading `\` escapes a literal `#` or `!`.
+func ParseIgnoreRules(r io.Reader) ([]IgnoreRule, error) {
+	rules := []IgnoreRule{}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule IgnoreRule
+		switch {
+		case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+			line = line[1:]
+		case strings.HasPrefix(line, "!"):
+			rule.Negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.DirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			return nil, fmt.Errorf("line %d: empty ignore pattern", lineNumber)
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("line %d: invalid ignore pattern %q: %w", lineNumber, line, err)
+		}
+
+		rule.Pattern = line
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the ignore rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// LoadIgnoreFile loads the rules of the ignore file at the root of the directory `dir` of the file system.
+// It returns nil rules without an error if the directory has no ignore file.
+func LoadIgnoreFile(fsys fs.FS, dir string) ([]IgnoreRule, error) {
+	name := path.Join(dir, IgnoreFileName)
+	file, err := fsys.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open the ignore file %s: %w", name, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	rules, err := ParseIgnoreRules(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ignore file %s: %w", name, err)
+	}
+
+	return rules, nil
+}
@@ -0,0 +1,185 @@
+package protocol
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// TestParseIgnoreRules tests `ParseIgnoreRules` to ensure that
+// comments, blank lines, negations, directory-only rules, and escapes are parsed.
+func TestParseIgnoreRules(t *testing.T) {
+	input := strings.Join([]string{
+		"# Build output.",
+		"",
+		"build/",
+		"*.log   ",
+		"!keep.log",
+		`\#notes.txt`,
+		`\!important`,
+		"/root-only.txt",
+	}, "\n")
+
+	rules, err := ParseIgnoreRules(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []IgnoreRule{
+		{Pattern: "build", DirOnly: true},
+		{Pattern: "*.log"},
+		{Pattern: "keep.log", Negate: true},
+		{Pattern: "#notes.txt"},
+		{Pattern: "!important"},
+		{Pattern: "/root-only.txt"},
+	}
+	if !reflect.DeepEqual(rules, expected) {
+		t.Fatalf("expected rules %+v, got %+v", expected, rules)
+	}
+}
+
+// TestParseIgnoreRulesInvalid tests `ParseIgnoreRules` to ensure that
+// malformed and empty patterns are rejected with their line number.
+func TestParseIgnoreRulesInvalid(t *testing.T) {
+	for _, input := range []string{"ok.txt\n[a-", "ok.txt\n!/"} {
+		_, err := ParseIgnoreRules(strings.NewReader(input))
+		if err == nil {
+			t.Fatalf("expected error for %q, got nil", input)
+		}
+		if !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("expected the error to mention line 2, got: %v", err)
+		}
+	}
+}
+
+// TestPathFilterIgnoreRulesNestedNegation tests `PathFilter.Decide` to ensure that
+// the last matching ignore rule wins across nested negations and directory-only rules.
+func TestPathFilterIgnoreRulesNestedNegation(t *testing.T) {
+	rules, err := ParseIgnoreRules(strings.NewReader(strings.Join([]string{
+		"*.log",
+		"!logs/**/keep.log",
+		"logs/tmp/keep.log",
+		"cache/",
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	filter := &PathFilter{Ignore: rules}
+
+	tests := []struct {
+		relPath    string
+		isDir      bool
+		excluded   bool
+		rule       string
+		overridden string
+	}{
+		{"app.log", false, true, "ignore:*.log", ""},
+		{"logs/keep.log", false, false, "ignore:!logs/**/keep.log", "ignore:*.log"},
+		{"logs/a/b/keep.log", false, false, "ignore:!logs/**/keep.log", "ignore:*.log"},
+		{"logs/tmp/keep.log", false, true, "ignore:logs/tmp/keep.log", ""},
+		{"src/cache", true, true, "ignore:cache/", ""},
+	}
+	for _, tt := range tests {
+		decision := filter.Decide(tt.relPath, tt.isDir)
+		if decision == nil {
+			t.Fatalf("expected a decision for %q, got nil", tt.relPath)
+		}
+		if decision.Excluded != tt.excluded || decision.Rule != tt.rule || decision.Overridden != tt.overridden {
+			t.Errorf("Decide(%q) = %+v, expected excluded=%v rule=%q overridden=%q",
+				tt.relPath, *decision, tt.excluded, tt.rule, tt.overridden)
+		}
+	}
+
+	// A directory-only rule does not match a file of the same name.
+	if decision := filter.Decide("cache", false); decision != nil {
+		t.Fatalf("expected no decision for the file cache, got %+v", *decision)
+	}
+}
+
+// TestPathFilterIgnoreRulesWithFlags tests `PathFilter.Decide` to ensure that
+// exclude patterns apply on top of ignore rules and include patterns override both.
+func TestPathFilterIgnoreRulesWithFlags(t *testing.T) {
+	filter := &PathFilter{
+		Include: []string{"keep.tmp"},
+		Exclude: []string{"*.bak"},
+		Ignore:  []IgnoreRule{{Pattern: "*.tmp"}, {Pattern: "*.bak", Negate: true}},
+	}
+
+	if decision := filter.Decide("old.bak", false); decision == nil || !decision.Excluded || decision.Rule != "exclude:*.bak" {
+		t.Fatalf("expected old.bak to be excluded by the exclude pattern, got %+v", decision)
+	}
+	decision := filter.Decide("keep.tmp", false)
+	if decision == nil || decision.Excluded || decision.Overridden != "ignore:*.tmp" {
+		t.Fatalf("expected keep.tmp to be re-included over the ignore rule, got %+v", decision)
+	}
+}
+
+// TestPlanDirectoryTransferIgnoreFile tests `PlanDirectoryTransfer` to ensure that
+// the ignore file is honored only when requested and is itself not transferred.
+func TestPlanDirectoryTransferIgnoreFile(t *testing.T) {
+	fsys := fstest.MapFS{
+		"project/" + IgnoreFileName: {Data: []byte("*.log\n!keep.log\nbuild/\n")},
+		"project/main.go":           {Data: []byte("package main")},
+		"project/debug.log":         {Data: []byte("debug")},
+		"project/sub/keep.log":      {Data: []byte("keep")},
+		"project/build/out.bin":     {Data: []byte("binary")},
+	}
+
+	plan, err := PlanDirectoryTransfer(fsys, "project", DirectoryTransferOptions{IgnoreFile: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, []string{"main.go", "sub/keep.log"}) {
+		t.Fatalf("expected main.go and sub/keep.log to be planned, got %v", got)
+	}
+	if plan.IgnoreFile != "project/"+IgnoreFileName {
+		t.Fatalf("expected the honored ignore file to be reported, got %q", plan.IgnoreFile)
+	}
+
+	plan, err = PlanDirectoryTransfer(fsys, "project", DirectoryTransferOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plan.Files) != len(fsys) || plan.IgnoreFile != "" {
+		t.Fatalf("expected every file to be planned without the ignore file, got %v", plannedPaths(plan.Files))
+	}
+}
+
+// TestPlanDirectoryTransferIgnoreFileExplicitlyIncluded tests `PlanDirectoryTransfer` to ensure that
+// the ignore file is transferred when an include pattern or a negated rule re-admits it.
+func TestPlanDirectoryTransferIgnoreFileExplicitlyIncluded(t *testing.T) {
+	filter, err := NewPathFilter([]string{IgnoreFileName}, nil)
+	if err != nil {
+		t.Fatalf("failed to create the filter: %v", err)
+	}
+
+	for name, opts := range map[string]struct {
+		content string
+		filter  *PathFilter
+	}{
+		"include pattern": {"*.log\n", filter},
+		"negated rule":    {"*.log\n!" + IgnoreFileName + "\n", nil},
+	} {
+		fsys := fstest.MapFS{
+			IgnoreFileName: {Data: []byte(opts.content)},
+			"debug.log":    {Data: []byte("debug")},
+		}
+		plan, err := PlanDirectoryTransfer(fsys, ".", DirectoryTransferOptions{Filter: opts.filter, IgnoreFile: true})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", name, err)
+		}
+		if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, []string{IgnoreFileName}) {
+			t.Fatalf("%s: expected only the ignore file to be planned, got %v", name, got)
+		}
+	}
+}
+
+// TestPlanDirectoryTransferInvalidIgnoreFile tests `PlanDirectoryTransfer` to ensure that
+// a malformed ignore file fails the plan instead of being silently ignored.
+func TestPlanDirectoryTransferInvalidIgnoreFile(t *testing.T) {
+	fsys := fstest.MapFS{IgnoreFileName: {Data: []byte("[a-\n")}}
+	if _, err := PlanDirectoryTransfer(fsys, ".", DirectoryTransferOptions{IgnoreFile: true}); err == nil {
+		t.Fatal("expected error for the malformed ignore file, got nil")
+	}
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the version of filexfer, which a server reports to ping requests (`MessageTypePing`).
+// It is set at build time, e.g. with `-ldflags "-X filexfer/protocol.Version=1.2.0"`.
+var Version = "dev"
+
+// ChecksumAlgorithmSHA256 is the name of the checksum algorithm of the protocol, as listed in `ServerInfo`.
+const ChecksumAlgorithmSHA256 = "sha256"
+
+// ServerInfo describes the effective limits and capabilities of a server, sent as the JSON message of the response
+// to an information request (`MessageTypeInfo`), so that a client can refuse a transfer the server would reject
+// before uploading it.
+type ServerInfo struct {
+	MaxFileSize      uint64   `json:"max_file_size"`        // Maximum size of a single file in bytes.
+	MaxDirectorySize uint64   `json:"max_directory_size"`   // Maximum total size of a directory transfer in bytes.
+	Checksums        []string `json:"checksums"`            // Accepted checksum algorithms (e.g. "sha256").
+	Compressions     []string `json:"compressions"`         // Accepted compressions, as named by `CompressionNames`.
+	Resume           bool     `json:"resume"`               // Whether interrupted transfers can be resumed.
+	Sessions         bool     `json:"sessions"`             // Whether several requests can be sent on the same connection.
+	Manifest         bool     `json:"manifest,omitempty"`   // Whether a sync can send the manifest of a directory up front (`MessageTypeManifest`).
+	Ranges           bool     `json:"ranges,omitempty"`     // Whether a file can be sent as byte ranges over several connections (`TransferTypeRange`).
+	FreeBytes        uint64   `json:"free_bytes,omitempty"` // Free space of the destination directory in bytes (0 if unknown).
+}
+
+// EncodeServerInfo returns the message of the response to an information request.
+func EncodeServerInfo(info *ServerInfo) (string, error) {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the server information: %w", err)
+	}
+	return string(data), nil
+}
+
+// ParseServerInfo parses the message of the response to an information request.
+func ParseServerInfo(message string) (*ServerInfo, error) {
+	var info ServerInfo
+	if err := json.Unmarshal([]byte(message), &info); err != nil {
+		return nil, fmt.Errorf("invalid server information %q: %w", abbreviate(message, 64), err)
+	}
+	return &info, nil
+}
+
+// PingMessage returns the message of the response to a ping request from a server of the version that has been up for `uptime`,
+// e.g. "filexfer 1.2.0, uptime 3h25m10s".
+func PingMessage(version string, uptime time.Duration) string {
+	return fmt.Sprintf("filexfer %s, uptime %s", version, uptime.Round(time.Second))
+}
@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestServerInfoRoundTrip tests `EncodeServerInfo` and `ParseServerInfo` to ensure that
+// the information survives the response message.
+func TestServerInfoRoundTrip(t *testing.T) {
+	info := &ServerInfo{
+		MaxFileSize:      5 << 30,
+		MaxDirectorySize: 50 << 30,
+		Checksums:        []string{ChecksumAlgorithmSHA256},
+		Compressions:     CompressionNames(),
+		Sessions:         true,
+		FreeBytes:        1 << 40,
+	}
+
+	message, err := EncodeServerInfo(info)
+	if err != nil {
+		t.Fatalf("failed to encode the server information: %v", err)
+	}
+	if len(message) > MaxResponseMessageLength {
+		t.Fatalf("expected the message to fit in a response, got %d bytes", len(message))
+	}
+	parsed, err := ParseServerInfo(message)
+	if err != nil {
+		t.Fatalf("failed to parse the server information: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, info) {
+		t.Fatalf("expected %+v, got %+v", info, parsed)
+	}
+
+	if _, err := ParseServerInfo("invalid message type in the header"); err == nil {
+		t.Fatal("expected an error for a message that is not server information")
+	}
+}
+
+// TestPingMessage tests `PingMessage` to ensure that
+// the uptime is rounded to the second.
+func TestPingMessage(t *testing.T) {
+	message := PingMessage("1.2.0", 90*time.Minute+1500*time.Millisecond)
+	if message != "filexfer 1.2.0, uptime 1h30m2s" {
+		t.Fatalf("expected the version and rounded uptime, got %q", message)
+	}
+}
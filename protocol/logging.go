@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+)
+
+// Log output formats of the client and the server (the "-log-format" flag).
+const (
+	LogFormatText = "text" // Human-readable lines of the `log` package, with the attributes as key=value pairs.
+	LogFormatJSON = "json" // One JSON object per line, for log shippers such as Loki or ELK.
+)
+
+// ValidLogFormat reports whether the format is one of the `LogFormat` constants.
+func ValidLogFormat(format string) bool {
+	return format == LogFormatText || format == LogFormatJSON
+}
+
+// ParseLogLevel parses the name of a log level: debug, info, warn, or error (the "-log-level" flag).
+func ParseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level %q", name)
+	}
+	return level, nil
+}
+
+// SetupLogging configures the default `slog` logger to write to `w` in the format and at the level given.
+// The text format keeps the lines of the `log` package, with the prefix, a timestamp, and the source file of each message,
+// while the JSON format writes an object per message with its level, source, and attributes.
+func SetupLogging(w io.Writer, format string, level slog.Level, prefix string) {
+	if format == LogFormatJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true, Level: level})))
+		return
+	}
+
+	log.SetOutput(w)
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+	log.SetPrefix(prefix + " ")
+	slog.SetLogLoggerLevel(level)
+}
+
+// NewTransferID returns a random identifier for a transfer, attached as "transfer_id" to its log messages
+// so that the messages of concurrent transfers can be told apart.
+func NewTransferID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id) // `rand.Read` never returns an error.
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restoreLogging restores the default loggers changed by `SetupLogging` at the end of the test.
+func restoreLogging(t *testing.T) {
+	t.Helper()
+
+	logger, writer, flags, prefix := slog.Default(), log.Writer(), log.Flags(), log.Prefix()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	})
+}
+
+// TestParseLogLevel tests `ParseLogLevel` with valid and invalid level names.
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected slog.Level
+		valid    bool
+	}{
+		{"debug", slog.LevelDebug, true},
+		{"info", slog.LevelInfo, true},
+		{"WARN", slog.LevelWarn, true},
+		{"error", slog.LevelError, true},
+		{"verbose", slog.LevelInfo, false},
+	}
+
+	for _, tt := range tests {
+		level, err := ParseLogLevel(tt.name)
+		if (err == nil) != tt.valid {
+			t.Fatalf("ParseLogLevel(%q): expected valid %v, got error %v", tt.name, tt.valid, err)
+		}
+		if level != tt.expected {
+			t.Fatalf("ParseLogLevel(%q) = %v; want %v", tt.name, level, tt.expected)
+		}
+	}
+}
+
+// TestSetupLoggingJSON tests `SetupLogging` to ensure that
+// the JSON format writes an object per message with its attributes, and drops messages below the level.
+func TestSetupLoggingJSON(t *testing.T) {
+	restoreLogging(t)
+	var output bytes.Buffer
+	SetupLogging(&output, LogFormatJSON, slog.LevelInfo, "[SERVER]")
+
+	slog.Debug("Hidden message")
+	slog.Info("Transfer completed", "file_name", "a.txt", "bytes", 42)
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %d: %q", len(lines), output.String())
+	}
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %q: %v", lines[0], err)
+	}
+	if entry["msg"] != "Transfer completed" || entry["level"] != "INFO" || entry["file_name"] != "a.txt" || entry["bytes"] != 42.0 {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+	if _, ok := entry["source"]; !ok {
+		t.Fatalf("expected the source of the message, got %v", entry)
+	}
+	if timestamp, ok := entry["time"].(string); !ok {
+		t.Fatalf("expected the time of the message, got %v", entry)
+	} else if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+		t.Fatalf("expected an RFC 3339 time, got %q: %v", timestamp, err)
+	}
+}
+
+// TestSetupLoggingText tests `SetupLogging` to ensure that
+// the text format keeps the prefixed lines of the `log` package, with the attributes as key=value pairs.
+func TestSetupLoggingText(t *testing.T) {
+	restoreLogging(t)
+	var output bytes.Buffer
+	SetupLogging(&output, LogFormatText, slog.LevelWarn, "[CLIENT]")
+
+	slog.Info("Hidden message")
+	slog.Warn("Transfer failed", "error", "connection reset")
+
+	line := output.String()
+	if !strings.HasPrefix(line, "[CLIENT] ") || strings.Contains(line, "Hidden message") {
+		t.Fatalf("unexpected output: %q", line)
+	}
+	if !strings.Contains(line, "WARN Transfer failed error=\"connection reset\"") {
+		t.Fatalf("expected the message and its attributes, got %q", line)
+	}
+}
+
+// TestNewTransferID tests `NewTransferID` to ensure that the identifiers are hex-encoded and distinct.
+func TestNewTransferID(t *testing.T) {
+	first, second := NewTransferID(), NewTransferID()
+	if len(first) != 16 || strings.Trim(first, "0123456789abcdef") != "" {
+		t.Fatalf("expected 16 hex digits, got %q", first)
+	}
+	if first == second {
+		t.Fatalf("expected distinct identifiers, got %q twice", first)
+	}
+}
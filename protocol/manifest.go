@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Constants for the manifest of a directory sent up front for a sync (`MessageTypeManifest`).
+const (
+	MaxManifestEntries = 64 * 1024        // Maximum number of files listed by a manifest.
+	MaxManifestSize    = 16 * 1024 * 1024 // Maximum size of an encoded manifest (16MB).
+)
+
+// manifestEntryOverhead is the size of the fixed-size fields of an encoded manifest entry: its path length, size, and checksum.
+const manifestEntryOverhead = 4 + 8 + ChecksumSize
+
+// ErrInvalidManifest is returned for a manifest, or a reply to it, that cannot be encoded or decoded.
+var ErrInvalidManifest = errors.New("invalid manifest")
+
+// A ManifestEntry is a file of a directory listed by a manifest, as it would be sent by a transfer.
+type ManifestEntry struct {
+	Path     string // Path of the file relative to the directory, as the `FileName` of its transfer header.
+	Size     uint64 // Size of the file in bytes.
+	Checksum []byte // SHA-256 checksum of the file.
+}
+
+// EncodeManifest encodes the entries as the content of a manifest message: the number of entries (4 bytes, big-endian),
+// followed by each entry as its path length (4 bytes, big-endian), path, size (8 bytes, big-endian), and checksum.
+func EncodeManifest(entries []ManifestEntry) ([]byte, error) {
+	if len(entries) > MaxManifestEntries {
+		return nil, fmt.Errorf("%w: %d entries exceed the maximum %d", ErrInvalidManifest, len(entries), MaxManifestEntries)
+	}
+
+	size := 4
+	for _, entry := range entries {
+		switch {
+		case entry.Path == "":
+			return nil, fmt.Errorf("%w: empty path", ErrInvalidManifest)
+		case len(entry.Path) > MaxFileNameLength:
+			return nil, fmt.Errorf("%w: path length %d exceeds the maximum %d", ErrInvalidManifest, len(entry.Path), MaxFileNameLength)
+		case strings.ContainsRune(entry.Path, 0):
+			return nil, fmt.Errorf("%w: path contains null bytes", ErrInvalidManifest)
+		case len(entry.Checksum) != ChecksumSize:
+			return nil, fmt.Errorf("%w: checksum length %d of %q is invalid, expected %d",
+				ErrInvalidManifest, len(entry.Checksum), entry.Path, ChecksumSize)
+		}
+		size += manifestEntryOverhead + len(entry.Path)
+	}
+	if size > MaxManifestSize {
+		return nil, fmt.Errorf("%w: size %d exceeds the maximum %d", ErrInvalidManifest, size, MaxManifestSize)
+	}
+
+	data := make([]byte, 0, size)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(entries)))
+	for _, entry := range entries {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(entry.Path)))
+		data = append(data, entry.Path...)
+		data = binary.BigEndian.AppendUint64(data, entry.Size)
+		data = append(data, entry.Checksum...)
+	}
+	return data, nil
+}
+
+// DecodeManifest decodes the entries encoded by `EncodeManifest`. The checksums share the memory of `data`.
+func DecodeManifest(data []byte) ([]ManifestEntry, error) {
+	if len(data) > MaxManifestSize {
+		return nil, fmt.Errorf("%w: size %d exceeds the maximum %d", ErrInvalidManifest, len(data), MaxManifestSize)
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%w: truncated entry count", ErrInvalidManifest)
+	}
+	count := binary.BigEndian.Uint32(data)
+	if count > MaxManifestEntries {
+		return nil, fmt.Errorf("%w: %d entries exceed the maximum %d", ErrInvalidManifest, count, MaxManifestEntries)
+	}
+	data = data[4:]
+
+	entries := make([]ManifestEntry, 0, min(int(count), len(data)/manifestEntryOverhead))
+	for range count {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("%w: truncated entry", ErrInvalidManifest)
+		}
+		pathLength := binary.BigEndian.Uint32(data)
+		if pathLength == 0 || pathLength > MaxFileNameLength {
+			return nil, fmt.Errorf("%w: path length %d is invalid, expected 1 to %d", ErrInvalidManifest, pathLength, MaxFileNameLength)
+		}
+		if uint64(len(data)) < manifestEntryOverhead+uint64(pathLength) {
+			return nil, fmt.Errorf("%w: truncated entry", ErrInvalidManifest)
+		}
+		data = data[4:]
+
+		entry := ManifestEntry{Path: string(data[:pathLength])}
+		if strings.ContainsRune(entry.Path, 0) {
+			return nil, fmt.Errorf("%w: path contains null bytes", ErrInvalidManifest)
+		}
+		data = data[pathLength:]
+		entry.Size = binary.BigEndian.Uint64(data)
+		entry.Checksum = data[8 : 8+ChecksumSize : 8+ChecksumSize]
+		data = data[8+ChecksumSize:]
+		entries = append(entries, entry)
+	}
+	if len(data) > 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidManifest, len(data))
+	}
+	return entries, nil
+}
+
+// EncodeManifestReply returns the message of the response to a manifest, listing the files the server needs:
+// a bitmap with the bit of each needed entry set (the first entry in the most significant bit of the first byte),
+// encoded in base64, so that the reply to `MaxManifestEntries` entries fits in a response message.
+func EncodeManifestReply(needed []bool) string {
+	bitmap := make([]byte, (len(needed)+7)/8)
+	for i, need := range needed {
+		if need {
+			bitmap[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return base64.StdEncoding.EncodeToString(bitmap)
+}
+
+// ParseManifestReply parses the message of the response to a manifest of `entries` entries (see `EncodeManifestReply`),
+// and returns whether each entry is needed by the server.
+func ParseManifestReply(message string, entries int) ([]bool, error) {
+	bitmap, err := base64.StdEncoding.DecodeString(message)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reply %q: %w", ErrInvalidManifest, abbreviate(message, 64), err)
+	}
+	if len(bitmap) != (entries+7)/8 {
+		return nil, fmt.Errorf("%w: reply of %d bytes for %d entries", ErrInvalidManifest, len(bitmap), entries)
+	}
+
+	needed := make([]bool, entries)
+	for i := range needed {
+		needed[i] = bitmap[i/8]&(0x80>>(i%8)) != 0
+	}
+	return needed, nil
+}
@@ -0,0 +1,114 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestDecodeManifestRoundTrip tests that `DecodeManifest` decodes what `EncodeManifest` encodes, including an empty manifest.
+func TestDecodeManifestRoundTrip(t *testing.T) {
+	for _, entries := range [][]ManifestEntry{
+		{},
+		{
+			{Path: "a.txt", Size: 5, Checksum: CalculateDataChecksum([]byte("hello"))},
+			{Path: "sub/b.txt", Size: 0, Checksum: CalculateDataChecksum(nil)},
+		},
+	} {
+		data, err := EncodeManifest(entries)
+		if err != nil {
+			t.Fatalf("EncodeManifest returned error: %v", err)
+		}
+		got, err := DecodeManifest(data)
+		if err != nil {
+			t.Fatalf("DecodeManifest returned error: %v", err)
+		}
+		if !reflect.DeepEqual(got, entries) {
+			t.Fatalf("expected %+v, got %+v", entries, got)
+		}
+	}
+}
+
+// TestEncodeManifestInvalid tests that `EncodeManifest` rejects entries that cannot be decoded with `ErrInvalidManifest`.
+func TestEncodeManifestInvalid(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("data"))
+	tests := []struct {
+		name  string
+		entry ManifestEntry
+	}{
+		{"empty path", ManifestEntry{Checksum: checksum}},
+		{"null byte", ManifestEntry{Path: "a\x00b", Checksum: checksum}},
+		{"short checksum", ManifestEntry{Path: "a", Checksum: checksum[:16]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncodeManifest([]ManifestEntry{tt.entry}); !errors.Is(err, ErrInvalidManifest) {
+				t.Fatalf("expected ErrInvalidManifest, got %v", err)
+			}
+		})
+	}
+
+	if _, err := EncodeManifest(make([]ManifestEntry, MaxManifestEntries+1)); !errors.Is(err, ErrInvalidManifest) {
+		t.Fatalf("expected ErrInvalidManifest for too many entries, got %v", err)
+	}
+}
+
+// TestDecodeManifestInvalid tests that `DecodeManifest` rejects truncated, oversized, and padded manifests
+// with `ErrInvalidManifest`, without allocating for an entry count the data cannot hold.
+func TestDecodeManifestInvalid(t *testing.T) {
+	valid, err := EncodeManifest([]ManifestEntry{{Path: "a.txt", Size: 1, Checksum: CalculateDataChecksum([]byte("a"))}})
+	if err != nil {
+		t.Fatalf("EncodeManifest returned error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated count", valid[:2]},
+		{"missing entry", valid[:4]},
+		{"truncated path", valid[:4+4+2]},
+		{"truncated checksum", valid[:len(valid)-1]},
+		{"trailing bytes", append(bytes.Clone(valid), 0)},
+		{"too many entries", binary.BigEndian.AppendUint32(nil, MaxManifestEntries+1)},
+		{"hostile count", binary.BigEndian.AppendUint32(nil, MaxManifestEntries)},
+		{"zero path length", append(binary.BigEndian.AppendUint32(nil, 1), make([]byte, manifestEntryOverhead)...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeManifest(tt.data); !errors.Is(err, ErrInvalidManifest) {
+				t.Fatalf("expected ErrInvalidManifest, got %v", err)
+			}
+		})
+	}
+}
+
+// TestParseManifestReply tests that `ParseManifestReply` parses what `EncodeManifestReply` encodes,
+// and rejects a reply of the wrong length or encoding.
+func TestParseManifestReply(t *testing.T) {
+	needed := []bool{true, false, false, true, true, false, true, false, false, true}
+	message := EncodeManifestReply(needed)
+
+	got, err := ParseManifestReply(message, len(needed))
+	if err != nil {
+		t.Fatalf("ParseManifestReply returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, needed) {
+		t.Fatalf("expected %v, got %v", needed, got)
+	}
+
+	if _, err := ParseManifestReply(message, 20); !errors.Is(err, ErrInvalidManifest) {
+		t.Fatalf("expected ErrInvalidManifest for the wrong number of entries, got %v", err)
+	}
+	if _, err := ParseManifestReply("not base64!", len(needed)); !errors.Is(err, ErrInvalidManifest) {
+		t.Fatalf("expected ErrInvalidManifest for an invalid encoding, got %v", err)
+	}
+
+	// The reply to the largest manifest fits in a response message.
+	if size := len(EncodeManifestReply(make([]bool, MaxManifestEntries))); size > MaxResponseMessageLength {
+		t.Fatalf("expected the largest reply to fit in a response, got %d bytes", size)
+	}
+}
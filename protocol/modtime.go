@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// modTimeFlag is set in the compression byte of a header that ends with the modification time of the file,
+// so that a header without it keeps the encoding of the headers that predate modification times.
+const modTimeFlag = 0x40
+
+// modTimeSize is the size of an encoded modification time: nanoseconds since the Unix epoch.
+const modTimeSize = 8
+
+// ErrInvalidModTime is returned for a modification time that cannot be carried by a header.
+var ErrInvalidModTime = errors.New("invalid modification time in the header")
+
+// validateModTime checks that the modification time round-trips through its encoding,
+// i.e. that it lies between the years 1678 and 2262.
+func validateModTime(modTime time.Time) error {
+	if decodeModTime(encodeModTime(modTime)).Equal(modTime) {
+		return nil
+	}
+	return fmt.Errorf("%w: %v is out of range", ErrInvalidModTime, modTime)
+}
+
+// encodeModTime encodes the modification time as nanoseconds since the Unix epoch (int64, big-endian).
+func encodeModTime(modTime time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(modTime.UnixNano()))
+}
+
+// decodeModTime decodes a modification time encoded by `encodeModTime`.
+func decodeModTime(data []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestModTimeHeaderRoundTrip tests that the modification time of a transfer header is written and read back
+// with its nanoseconds, along with its compression and block checksums, and that it is refused where it does not apply.
+func TestModTimeHeaderRoundTrip(t *testing.T) {
+	content := []byte("content")
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 123456789, time.UTC)
+	header := &Header{
+		MessageType:  MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "dated.txt",
+		Checksum:     CalculateDataChecksum(content),
+		TransferType: TransferTypeFile,
+		Compression:  CompressionZstd,
+		ModTime:      modTime,
+	}
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, header); err != nil {
+		t.Fatalf("WriteHeader returned error: %v", err)
+	}
+	if buf.Len() != encodedHeaderSize(header) {
+		t.Fatalf("expected %d bytes, got %d", encodedHeaderSize(header), buf.Len())
+	}
+	got, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadHeader returned error: %v", err)
+	}
+	if got.Compression != CompressionZstd || !got.ModTime.Equal(modTime) {
+		t.Fatalf("expected the compression and modification time to be read back, got %+v", got)
+	}
+
+	// A header without a modification time keeps the encoding of the headers that predate it.
+	header.ModTime = time.Time{}
+	buf.Reset()
+	if err := WriteHeader(&buf, header); err != nil {
+		t.Fatalf("WriteHeader returned error: %v", err)
+	}
+	if got, err := ReadHeader(&buf); err != nil || !got.ModTime.IsZero() {
+		t.Fatalf("expected no modification time, got %+v and %v", got, err)
+	}
+
+	header.ModTime, header.TransferType, header.Compression = modTime, TransferTypeStream, CompressionNone
+	if err := WriteHeader(&buf, header); !errors.Is(err, ErrInvalidModTime) {
+		t.Fatalf("expected the modification time of a stream to be refused, got %v", err)
+	}
+	header.TransferType, header.ModTime = TransferTypeFile, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := WriteHeader(&buf, header); !errors.Is(err, ErrInvalidModTime) {
+		t.Fatalf("expected a modification time out of range to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// MaxPathComponentLength is the default maximum length of a single path component (a file or directory name) in bytes.
+// Most file systems (e.g. ext4, XFS, and APFS) reject names longer than 255 bytes, far below `MaxFileNameLength`.
+const MaxPathComponentLength = 255
+
+// ErrPathComponentTooLong is returned when a component of a path exceeds the maximum allowed length.
+var ErrPathComponentTooLong = errors.New("path component exceeds the maximum allowed length")
+
+// ErrUnsafeFileName is returned when a component of a path is a name that is unusable or dangerous on some platforms:
+// one with control characters, a reserved Windows device name, or one ending in a dot or a space.
+var ErrUnsafeFileName = errors.New("file name is unsafe on some platforms")
+
+// windowsReservedNames are the device names reserved by Windows, with or without an extension (e.g. "aux.txt").
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// ValidatePathComponents checks that every component of the path is at most `maxLength` bytes long.
+// Lengths are counted in bytes rather than runes, since file systems limit the encoded length of a name
+// (e.g. a name of 100 three-byte UTF-8 characters is 300 bytes long).
+func ValidatePathComponents(path string, maxLength int) error {
+	components := strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == filepath.Separator
+	})
+	for _, component := range components {
+		if len(component) > maxLength {
+			return fmt.Errorf("%w: %q is %d bytes long, but at most %d bytes are allowed",
+				ErrPathComponentTooLong, abbreviate(component, 32), len(component), maxLength)
+		}
+	}
+
+	return nil
+}
+
+// ValidatePathNames checks that no component of the path is an unsafe name (see `ErrUnsafeFileName`).
+func ValidatePathNames(path string) error {
+	for _, component := range strings.Split(filepath.ToSlash(path), "/") {
+		if reason := unsafeNameReason(component); reason != "" {
+			return fmt.Errorf("%w: %q %s", ErrUnsafeFileName, abbreviate(component, 32), reason)
+		}
+	}
+	return nil
+}
+
+// ValidatePathCharacters checks that no component of the path contains control characters (e.g. a newline or a tab),
+// which would corrupt the logs and terminals showing the name. Unlike `ValidatePathNames`, it accepts the names
+// that are only unsafe on some platforms, which a server may store under sanitized names.
+func ValidatePathCharacters(path string) error {
+	for _, component := range strings.Split(filepath.ToSlash(path), "/") {
+		if strings.ContainsFunc(component, isControlCharacter) {
+			return fmt.Errorf("%w: %q contains control characters", ErrUnsafeFileName, abbreviate(component, 32))
+		}
+	}
+	return nil
+}
+
+// SanitizePathNames rewrites the unsafe components of the slash-separated path (see `ErrUnsafeFileName`)
+// by percent-encoding the offending bytes, so that the same name is always stored under the same safe name:
+// control characters and trailing dots and spaces are encoded (e.g. "a\nb." becomes "a%0Ab%2E"),
+// and so is the first letter of a reserved Windows device name (e.g. "aux.txt" becomes "%61ux.txt").
+func SanitizePathNames(path string) string {
+	components := strings.Split(filepath.ToSlash(path), "/")
+	for i, component := range components {
+		if unsafeNameReason(component) != "" {
+			components[i] = sanitizeName(component)
+		}
+	}
+	return strings.Join(components, "/")
+}
+
+// unsafeNameReason returns why the path component is an unsafe name, or "" if it is safe.
+// The "." and ".." components are left to the path traversal checks.
+func unsafeNameReason(name string) string {
+	if name == "." || name == ".." {
+		return ""
+	}
+	if strings.ContainsFunc(name, isControlCharacter) {
+		return "contains control characters"
+	}
+	if isWindowsReservedName(name) {
+		return "is a reserved Windows device name"
+	}
+	if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+		return "ends with a dot or a space"
+	}
+	return ""
+}
+
+// sanitizeName percent-encodes the offending bytes of an unsafe name.
+func sanitizeName(name string) string {
+	trimmed := strings.TrimRight(name, ". ")
+	var builder strings.Builder
+	for i := 0; i < len(trimmed); i++ {
+		c := trimmed[i]
+		if c < 0x20 || c == 0x7f || (i == 0 && isWindowsReservedName(trimmed)) {
+			fmt.Fprintf(&builder, "%%%02X", c)
+			continue
+		}
+		builder.WriteByte(c)
+	}
+	for i := len(trimmed); i < len(name); i++ {
+		fmt.Fprintf(&builder, "%%%02X", name[i])
+	}
+	return builder.String()
+}
+
+// isControlCharacter reports whether the rune is an ASCII control character.
+func isControlCharacter(r rune) bool {
+	return r < 0x20 || r == 0x7f
+}
+
+// isWindowsReservedName reports whether the name, up to its first dot and without trailing spaces,
+// is a reserved Windows device name in any case.
+func isWindowsReservedName(name string) bool {
+	stem, _, _ := strings.Cut(name, ".")
+	stem = strings.TrimRight(stem, " ")
+	for _, reserved := range windowsReservedNames {
+		if strings.EqualFold(stem, reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// abbreviate shortens a string to at most `maxLength` bytes for error messages,
+// without splitting a multibyte UTF-8 character.
+func abbreviate(s string, maxLength int) string {
+	if len(s) <= maxLength {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxLength], "") + "..."
+}
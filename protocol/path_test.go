@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestValidatePathComponents tests `ValidatePathComponents` to ensure that
+// components are limited by their length in bytes, whether they are single-byte or multibyte names.
+func TestValidatePathComponents(t *testing.T) {
+	// "é" is 2 bytes long in UTF-8, so 128 of them are 256 bytes long even though they are only 128 runes.
+	multibyte := strings.Repeat("é", 128)
+	if utf8.RuneCountInString(multibyte) > MaxPathComponentLength || len(multibyte) <= MaxPathComponentLength {
+		t.Fatalf("expected a name within the limit in runes but over it in bytes, got %d bytes", len(multibyte))
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"255-byte component", strings.Repeat("a", 255), false},
+		{"255-byte nested component", "dir/" + strings.Repeat("a", 251) + ".txt", false},
+		{"256-byte component", strings.Repeat("a", 256), true},
+		{"over-long directory component", strings.Repeat("d", 300) + "/file.txt", true},
+		{"multibyte component over the limit in bytes", multibyte, true},
+		{"multibyte component within the limit", strings.Repeat("é", 127), false},
+		{"empty path", "", false},
+	}
+	for _, tt := range tests {
+		err := ValidatePathComponents(tt.path, MaxPathComponentLength)
+		if tt.wantErr {
+			if !errors.Is(err, ErrPathComponentTooLong) {
+				t.Errorf("%s: expected ErrPathComponentTooLong, got %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
+
+// TestValidatePathComponentsErrorMessage tests `ValidatePathComponents` to ensure that
+// the error reports the length of the component and abbreviates it without splitting a character.
+func TestValidatePathComponentsErrorMessage(t *testing.T) {
+	err := ValidatePathComponents(strings.Repeat("é", 200), MaxPathComponentLength)
+	if err == nil {
+		t.Fatal("expected an error for the over-long component, got nil")
+	}
+	if !strings.Contains(err.Error(), "400 bytes long") || !strings.Contains(err.Error(), "at most 255 bytes") {
+		t.Fatalf("expected the lengths in the error message, got: %v", err)
+	}
+	if !utf8.ValidString(err.Error()) || len(err.Error()) > 200 {
+		t.Fatalf("expected a short, valid UTF-8 error message, got: %v", err)
+	}
+}
+
+// TestValidatePathNames tests `ValidatePathNames` to ensure that
+// control characters, reserved Windows device names, and trailing dots or spaces are refused in any component.
+func TestValidatePathNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{"regular name", "dir/file.txt", false},
+		{"dot in the middle", "archive.tar.gz", false},
+		{"reserved name as a prefix", "console.log", false},
+		{"current directory", "./file.txt", false},
+		{"newline", "foo\nbar", true},
+		{"tab", "foo\tbar", true},
+		{"delete character", "foo\x7fbar", true},
+		{"reserved name", "CON", true},
+		{"reserved name with an extension", "aux.txt", true},
+		{"reserved name in lower case", "com1", true},
+		{"reserved directory name", "dir/nul/file.txt", true},
+		{"trailing dot", "file.", true},
+		{"trailing space", "file. ", true},
+		{"trailing space in a directory", "dir /file.txt", true},
+	}
+	for _, tt := range tests {
+		err := ValidatePathNames(tt.path)
+		if tt.wantErr {
+			if !errors.Is(err, ErrUnsafeFileName) {
+				t.Errorf("%s: expected ErrUnsafeFileName, got %v", tt.name, err)
+			}
+		} else if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+		}
+	}
+}
+
+// TestValidatePathCharacters tests `ValidatePathCharacters` to ensure that
+// names with control characters are refused in any component, while names unsafe only on some platforms pass.
+func TestValidatePathCharacters(t *testing.T) {
+	for _, path := range []string{"dir/file.txt", "aux.txt", "file.", "ünïcödé.txt"} {
+		if err := ValidatePathCharacters(path); err != nil {
+			t.Errorf("%q: unexpected error: %v", path, err)
+		}
+	}
+	for _, path := range []string{"foo\nbar", "dir/foo\tbar.txt", "foo\rbar/file.txt", "bell\a"} {
+		if err := ValidatePathCharacters(path); !errors.Is(err, ErrUnsafeFileName) {
+			t.Errorf("%q: expected ErrUnsafeFileName, got %v", path, err)
+		}
+	}
+}
+
+// TestSanitizePathNames tests `SanitizePathNames` to ensure that
+// only the offending bytes of unsafe components are percent-encoded, and that the result is safe.
+func TestSanitizePathNames(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"dir/file.txt", "dir/file.txt"},
+		{"foo\nbar", "foo%0Abar"},
+		{"CON", "%43ON"},
+		{"aux.txt", "%61ux.txt"},
+		{"file. ", "file%2E%20"},
+		{"...", "%2E%2E%2E"},
+		{"nul /a\tb.", "%6Eul%20/a%09b%2E"},
+	}
+	for _, tt := range tests {
+		got := SanitizePathNames(tt.path)
+		if got != tt.expected {
+			t.Errorf("SanitizePathNames(%q) = %q; want %q", tt.path, got, tt.expected)
+		}
+		if err := ValidatePathNames(got); err != nil {
+			t.Errorf("SanitizePathNames(%q) = %q is still unsafe: %v", tt.path, got, err)
+		}
+	}
+}
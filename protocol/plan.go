@@ -0,0 +1,253 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// DirectoryTransferOptions configures which files of a directory are selected for a transfer.
+type DirectoryTransferOptions struct {
+	Filter           *PathFilter // Include/exclude filter applied to relative paths (nil selects everything).
+	MaxFileSize      int64       // Files larger than this are skipped (0 for no limit).
+	ComputeChecksums bool        // Whether to compute the SHA-256 checksum of every selected file.
+	IgnoreFile       bool        // Whether to honor the ignore file (`.filexferignore`) at the root of the directory.
+	FollowSymlinks   bool        // Whether to transfer the content of symbolic links (and walk linked directories) instead of skipping them.
+	// Checksum, if non-nil, returns the SHA-256 checksum of a selected file by its name in the file system and its information
+	// instead of hashing the file, e.g. from a cache of the checksums of unchanged files. It is only used with `ComputeChecksums`.
+	Checksum func(name string, info fs.FileInfo) ([]byte, error)
+}
+
+// A PlannedFile is a file selected for a directory transfer.
+type PlannedFile struct {
+	Path     string `json:"path"`               // Slash-separated path relative to the transferred directory.
+	Size     int64  `json:"size"`               // Size of the file in bytes.
+	Checksum string `json:"checksum,omitempty"` // Hex-encoded SHA-256 checksum (only if requested).
+}
+
+// A PlannedLink is a symbolic link of a directory left out of a transfer, which sends content and not links.
+type PlannedLink struct {
+	Path   string `json:"path"`             // Slash-separated path relative to the transferred directory.
+	Target string `json:"target,omitempty"` // Target of the link, if the file system can read it.
+	Loop   bool   `json:"loop,omitempty"`   // Whether the link was skipped because following it would loop back to a walked directory.
+}
+
+// PlanStats holds the aggregate statistics of a `TransferPlan`.
+type PlanStats struct {
+	TotalFiles      int   `json:"total_files"`       // Number of files selected for the transfer.
+	TotalBytes      int64 `json:"total_bytes"`       // Total size of the selected files in bytes.
+	ExcludedFiles   int   `json:"excluded_files"`    // Number of files left out by the filter.
+	PrunedDirs      int   `json:"pruned_dirs"`       // Number of directories pruned (without walking them) by the filter.
+	SkippedTooLarge int   `json:"skipped_too_large"` // Number of files skipped because they exceed the maximum file size.
+	SkippedSymlinks int   `json:"skipped_symlinks"`  // Number of symbolic links skipped (not followed, broken or looping).
+}
+
+// A TransferPlan is the exact set of files a directory transfer would send,
+// together with the filter decisions taken along the way.
+type TransferPlan struct {
+	Root       string           `json:"root"`                  // Root of the planned directory within the file system.
+	IgnoreFile string           `json:"ignore_file,omitempty"` // Ignore file honored by the plan, if any.
+	Files      []PlannedFile    `json:"files"`                 // Selected files in lexical (walk) order.
+	Dirs       []string         `json:"dirs"`                  // Slash-separated relative paths of the walked (not pruned) directories, in walk order.
+	TooLarge   []PlannedFile    `json:"too_large"`             // Files skipped because they exceed the maximum file size.
+	Symlinks   []PlannedLink    `json:"symlinks"`              // Symbolic links skipped instead of followed.
+	Decisions  []FilterDecision `json:"decisions"`             // Filter decisions for every path matched by a rule.
+	Stats      PlanStats        `json:"stats"`                 // Aggregate statistics.
+}
+
+// PlanDirectoryTransfer walks the directory `root` of the file system and computes the exact set of files
+// a directory transfer would send, without sending anything.
+//
+// Symbolic links are recorded in `Symlinks` and skipped, unless `FollowSymlinks` is set: the content of a linked file
+// is then planned under the path of the link, and a linked directory is walked as if it were a regular one.
+// A link resolving to the directory it is in or to one of its parents is skipped (and recorded with `Loop`),
+// which stops link cycles; this relies on `os.SameFile`, so it requires a file system backed by the OS, such as `os.DirFS`.
+func PlanDirectoryTransfer(fsys fs.FS, root string, opts DirectoryTransferOptions) (*TransferPlan, error) {
+	if fsys == nil {
+		return nil, fmt.Errorf("file system is nil")
+	}
+
+	plan := &TransferPlan{
+		Root:      root,
+		Files:     []PlannedFile{},
+		Dirs:      []string{},
+		TooLarge:  []PlannedFile{},
+		Symlinks:  []PlannedLink{},
+		Decisions: []FilterDecision{},
+	}
+
+	filter := opts.Filter
+	if opts.IgnoreFile {
+		rules, err := LoadIgnoreFile(fsys, root)
+		if err != nil {
+			return nil, err
+		}
+		if rules != nil {
+			// The ignore file itself is not transferred unless a later rule or an include pattern re-admits it.
+			filter = filter.withIgnoreRules(append([]IgnoreRule{{Pattern: "/" + IgnoreFileName}}, rules...))
+			plan.IgnoreFile = path.Join(root, IgnoreFileName)
+		}
+	}
+
+	// walk walks the directory `start`, either `root` or a linked directory being followed, which was already planned.
+	var walk func(start string) error
+	walk = func(start string) error {
+		return fs.WalkDir(fsys, start, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if p == start {
+				return nil
+			}
+			relPath := relativeToRoot(root, p)
+
+			var info fs.FileInfo
+			isDir := d.IsDir()
+			if d.Type()&fs.ModeSymlink != 0 {
+				link := PlannedLink{Path: relPath, Target: readLink(fsys, p)}
+				if opts.FollowSymlinks {
+					// A broken link is skipped like a link that is not followed.
+					if info, err = fs.Stat(fsys, p); err == nil {
+						isDir = info.IsDir()
+						link.Loop = isDir && linksToParent(fsys, p, info)
+					}
+				}
+				if info == nil || link.Loop {
+					if decision := filter.Decide(relPath, false); decision != nil {
+						plan.Decisions = append(plan.Decisions, *decision)
+						if decision.Excluded {
+							plan.Stats.ExcludedFiles++
+							return nil
+						}
+					}
+					plan.Symlinks = append(plan.Symlinks, link)
+					plan.Stats.SkippedSymlinks++
+					return nil
+				}
+			}
+
+			if decision := filter.Decide(relPath, isDir); decision != nil {
+				plan.Decisions = append(plan.Decisions, *decision)
+				if decision.Excluded {
+					if isDir {
+						plan.Stats.PrunedDirs++
+						if d.IsDir() {
+							return fs.SkipDir
+						}
+						return nil
+					}
+					plan.Stats.ExcludedFiles++
+					return nil
+				}
+			}
+
+			if isDir {
+				plan.Dirs = append(plan.Dirs, relPath)
+				if !d.IsDir() {
+					// `fs.WalkDir` does not walk the linked directory by itself.
+					return walk(p)
+				}
+				return nil
+			}
+
+			if info == nil {
+				if info, err = d.Info(); err != nil {
+					return err
+				}
+			}
+			file := PlannedFile{
+				Path: relPath,
+				Size: info.Size(),
+			}
+
+			if opts.MaxFileSize > 0 && file.Size > opts.MaxFileSize {
+				plan.TooLarge = append(plan.TooLarge, file)
+				plan.Stats.SkippedTooLarge++
+				return nil
+			}
+
+			if opts.ComputeChecksums {
+				calculate := opts.Checksum
+				if calculate == nil {
+					calculate = func(name string, _ fs.FileInfo) ([]byte, error) {
+						return calculateFSChecksum(fsys, name)
+					}
+				}
+				checksum, err := calculate(p, info)
+				if err != nil {
+					return err
+				}
+				file.Checksum = hex.EncodeToString(checksum)
+			}
+
+			plan.Files = append(plan.Files, file)
+			plan.Stats.TotalFiles++
+			plan.Stats.TotalBytes += file.Size
+			return nil
+		})
+	}
+	if err := walk(root); err != nil {
+		return nil, fmt.Errorf("failed to plan the directory transfer of %s: %w", root, err)
+	}
+
+	return plan, nil
+}
+
+// linksToParent reports whether the directory `info`, the target of the link `p`, is the directory
+// the link is in or one of its parents, up to the root of the file system, so that following the link would loop.
+// A linked directory is recognized by its identity (device and inode on Unix) and not by its path,
+// since the same directory is reached under a new path every time the loop is followed.
+func linksToParent(fsys fs.FS, p string, info fs.FileInfo) bool {
+	for dir := path.Dir(p); ; dir = path.Dir(dir) {
+		if parent, err := fs.Stat(fsys, dir); err == nil && os.SameFile(parent, info) {
+			return true
+		}
+		if dir == "." {
+			return false
+		}
+	}
+}
+
+// readLink returns the target of the symbolic link `p`, or "" if the file system cannot read links.
+func readLink(fsys fs.FS, p string) string {
+	linkFS, ok := fsys.(interface {
+		ReadLink(name string) (string, error)
+	})
+	if !ok {
+		return ""
+	}
+	target, err := linkFS.ReadLink(p)
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+// relativeToRoot returns the path of `p` relative to `root`, both being slash-separated `fs.FS` paths.
+func relativeToRoot(root, p string) string {
+	root = path.Clean(root)
+	switch {
+	case p == root:
+		return "."
+	case root == ".":
+		return p
+	default:
+		return strings.TrimPrefix(p, root+"/")
+	}
+}
+
+// calculateFSChecksum calculates the SHA-256 checksum of a file in the file system.
+func calculateFSChecksum(fsys fs.FS, name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return CalculateFileChecksum(file)
+}
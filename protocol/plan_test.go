@@ -0,0 +1,334 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// newPlanTestFS creates an in-memory file system tree for testing `PlanDirectoryTransfer`.
+func newPlanTestFS() fstest.MapFS {
+	return fstest.MapFS{
+		"project/main.go":                 {Data: []byte("package main")},
+		"project/debug.log":               {Data: []byte("debug")},
+		"project/important.log":           {Data: []byte("important")},
+		"project/logs/app.txt":            {Data: []byte("app")},
+		"project/logs/keep.txt":           {Data: []byte("keep")},
+		"project/build/out.bin":           {Data: []byte("binary")},
+		"project/build/keep.txt":          {Data: []byte("keep")},
+		"project/src/lib.go":              {Data: []byte("package src")},
+		"project/src/vendor/dep.go":       {Data: []byte("package dep")},
+		"project/src/vendor/dep_test.log": {Data: []byte("log")},
+	}
+}
+
+// plannedPaths returns the paths of the planned files.
+func plannedPaths(files []PlannedFile) []string {
+	paths := []string{}
+	for _, file := range files {
+		paths = append(paths, file.Path)
+	}
+	return paths
+}
+
+// TestPlanDirectoryTransferNilFS tests `PlanDirectoryTransfer` to ensure that
+// it expectedly rejects a nil file system.
+func TestPlanDirectoryTransferNilFS(t *testing.T) {
+	if _, err := PlanDirectoryTransfer(nil, ".", DirectoryTransferOptions{}); err == nil {
+		t.Fatal("expected error for the nil file system, got nil")
+	}
+}
+
+// TestPlanDirectoryTransferMissingRoot tests `PlanDirectoryTransfer` to ensure that
+// it expectedly fails for a missing root directory.
+func TestPlanDirectoryTransferMissingRoot(t *testing.T) {
+	if _, err := PlanDirectoryTransfer(newPlanTestFS(), "missing", DirectoryTransferOptions{}); err == nil {
+		t.Fatal("expected error for the missing root, got nil")
+	}
+}
+
+// TestPlanDirectoryTransferNoFilter tests `PlanDirectoryTransfer` to ensure that
+// without a filter every file is planned in lexical order and no decisions are recorded.
+func TestPlanDirectoryTransferNoFilter(t *testing.T) {
+	fsys := newPlanTestFS()
+	plan, err := PlanDirectoryTransfer(fsys, "project", DirectoryTransferOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		"build/keep.txt", "build/out.bin", "debug.log", "important.log", "logs/app.txt", "logs/keep.txt",
+		"main.go", "src/lib.go", "src/vendor/dep.go", "src/vendor/dep_test.log",
+	}
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected files %v, got %v", expected, got)
+	}
+	if len(plan.Decisions) != 0 {
+		t.Fatalf("expected no decisions, got %v", plan.Decisions)
+	}
+	if plan.Stats.TotalFiles != len(expected) {
+		t.Fatalf("expected %d files in the stats, got %d", len(expected), plan.Stats.TotalFiles)
+	}
+	var expectedBytes int64
+	for _, file := range fsys {
+		expectedBytes += int64(len(file.Data))
+	}
+	if plan.Stats.TotalBytes != expectedBytes {
+		t.Fatalf("expected %d total bytes, got %d", expectedBytes, plan.Stats.TotalBytes)
+	}
+	if plan.Files[0].Checksum != "" {
+		t.Fatalf("expected no checksum unless requested, got %q", plan.Files[0].Checksum)
+	}
+}
+
+// TestPlanDirectoryTransferDecisionTrace tests `PlanDirectoryTransfer` to ensure that
+// overlapping include and exclude rules are traced with the rule that decided each path.
+func TestPlanDirectoryTransferDecisionTrace(t *testing.T) {
+	filter, err := NewPathFilter(
+		[]string{"important.log", "logs/keep.txt", "build/keep.txt"},
+		[]string{"*.log", "logs", "build/*", "src/**/vendor"},
+	)
+	if err != nil {
+		t.Fatalf("failed to create the filter: %v", err)
+	}
+
+	plan, err := PlanDirectoryTransfer(newPlanTestFS(), "project", DirectoryTransferOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// `logs` is pruned, so the include of `logs/keep.txt` never gets a chance to apply.
+	expectedFiles := []string{"build/keep.txt", "important.log", "main.go", "src/lib.go"}
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, expectedFiles) {
+		t.Fatalf("expected files %v, got %v", expectedFiles, got)
+	}
+	if expectedDirs := []string{"build", "src"}; !reflect.DeepEqual(plan.Dirs, expectedDirs) {
+		t.Fatalf("expected the walked directories %v, got %v", expectedDirs, plan.Dirs)
+	}
+
+	expectedDecisions := []FilterDecision{
+		{Path: "build/keep.txt", Excluded: false, Rule: "include:build/keep.txt", Overridden: "exclude:build/*"},
+		{Path: "build/out.bin", Excluded: true, Rule: "exclude:build/*"},
+		{Path: "debug.log", Excluded: true, Rule: "exclude:*.log"},
+		{Path: "important.log", Excluded: false, Rule: "include:important.log", Overridden: "exclude:*.log"},
+		{Path: "logs", IsDir: true, Excluded: true, Rule: "exclude:logs"},
+		{Path: "src/vendor", IsDir: true, Excluded: true, Rule: "exclude:src/**/vendor"},
+	}
+	if !reflect.DeepEqual(plan.Decisions, expectedDecisions) {
+		t.Fatalf("expected decisions %+v, got %+v", expectedDecisions, plan.Decisions)
+	}
+
+	if plan.Stats.ExcludedFiles != 2 {
+		t.Fatalf("expected 2 excluded files, got %d", plan.Stats.ExcludedFiles)
+	}
+	if plan.Stats.PrunedDirs != 2 {
+		t.Fatalf("expected 2 pruned directories, got %d", plan.Stats.PrunedDirs)
+	}
+}
+
+// TestPlanDirectoryTransferFirstMatchingRule tests `PlanDirectoryTransfer` to ensure that
+// when several exclude rules match the same path, the first one in order is reported.
+func TestPlanDirectoryTransferFirstMatchingRule(t *testing.T) {
+	filter, err := NewPathFilter(nil, []string{"debug.*", "*.log"})
+	if err != nil {
+		t.Fatalf("failed to create the filter: %v", err)
+	}
+
+	plan, err := PlanDirectoryTransfer(newPlanTestFS(), "project", DirectoryTransferOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rules := map[string]string{}
+	for _, decision := range plan.Decisions {
+		rules[decision.Path] = decision.Rule
+	}
+	if rules["debug.log"] != "exclude:debug.*" {
+		t.Fatalf("expected debug.log to be excluded by the first rule, got %q", rules["debug.log"])
+	}
+	if rules["important.log"] != "exclude:*.log" {
+		t.Fatalf("expected important.log to be excluded by the second rule, got %q", rules["important.log"])
+	}
+}
+
+// TestPlanDirectoryTransferMaxFileSize tests `PlanDirectoryTransfer` to ensure that
+// files exceeding the maximum file size are reported separately and left out of the totals.
+func TestPlanDirectoryTransferMaxFileSize(t *testing.T) {
+	plan, err := PlanDirectoryTransfer(newPlanTestFS(), "project/build", DirectoryTransferOptions{MaxFileSize: 4})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, []string{"keep.txt"}) {
+		t.Fatalf("expected only keep.txt to be planned, got %v", got)
+	}
+	if got := plannedPaths(plan.TooLarge); !reflect.DeepEqual(got, []string{"out.bin"}) {
+		t.Fatalf("expected out.bin to be skipped as too large, got %v", got)
+	}
+	if plan.Stats.SkippedTooLarge != 1 || plan.Stats.TotalBytes != 4 {
+		t.Fatalf("unexpected stats: %+v", plan.Stats)
+	}
+}
+
+// TestPlanDirectoryTransferChecksums tests `PlanDirectoryTransfer` to ensure that
+// checksums are computed for every planned file when requested.
+func TestPlanDirectoryTransferChecksums(t *testing.T) {
+	fsys := newPlanTestFS()
+	plan, err := PlanDirectoryTransfer(fsys, "project/src", DirectoryTransferOptions{ComputeChecksums: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, file := range plan.Files {
+		expected := hex.EncodeToString(CalculateDataChecksum(fsys["project/src/"+file.Path].Data))
+		if file.Checksum != expected {
+			t.Fatalf("expected checksum %s for %s, got %s", expected, file.Path, file.Checksum)
+		}
+	}
+}
+
+// newSymlinkTestDir creates a directory with a linked file, a linked directory, a link looping back to the root,
+// and a broken link, for testing `PlanDirectoryTransfer` with symbolic links.
+func newSymlinkTestDir(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "data"), 0755); err != nil {
+		t.Fatalf("failed to create the directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "data", "file.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	links := map[string]string{
+		"link.txt":  filepath.Join("data", "file.txt"),
+		"linked":    "data",
+		"data/loop": "..",
+		"broken":    "missing",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			t.Skipf("symbolic links are not supported: %v", err)
+		}
+	}
+	return root
+}
+
+// planWithTimeout runs `PlanDirectoryTransfer` on the directory, failing the test if it does not return in time.
+func planWithTimeout(t *testing.T, root string, opts DirectoryTransferOptions) *TransferPlan {
+	t.Helper()
+	type result struct {
+		plan *TransferPlan
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		plan, err := PlanDirectoryTransfer(os.DirFS(root), ".", opts)
+		done <- result{plan, err}
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("unexpected error: %v", r.err)
+		}
+		return r.plan
+	case <-time.After(10 * time.Second):
+		t.Fatal("planning did not finish, the symbolic link loop was followed")
+		return nil
+	}
+}
+
+// TestPlanDirectoryTransferSkipsSymlinks tests `PlanDirectoryTransfer` to ensure that
+// symbolic links are recorded and not followed by default.
+func TestPlanDirectoryTransferSkipsSymlinks(t *testing.T) {
+	plan := planWithTimeout(t, newSymlinkTestDir(t), DirectoryTransferOptions{})
+
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, []string{"data/file.txt"}) {
+		t.Fatalf("expected only data/file.txt to be planned, got %v", got)
+	}
+	expected := []PlannedLink{
+		{Path: "broken", Target: "missing"},
+		{Path: "data/loop", Target: ".."},
+		{Path: "link.txt", Target: filepath.Join("data", "file.txt")},
+		{Path: "linked", Target: "data"},
+	}
+	if !reflect.DeepEqual(plan.Symlinks, expected) {
+		t.Fatalf("expected the symbolic links %+v, got %+v", expected, plan.Symlinks)
+	}
+	if plan.Stats.SkippedSymlinks != 4 || plan.Stats.TotalBytes != 7 {
+		t.Fatalf("unexpected stats: %+v", plan.Stats)
+	}
+}
+
+// TestPlanDirectoryTransferFollowsSymlinks tests `PlanDirectoryTransfer` to ensure that
+// with `FollowSymlinks` the content of linked files and directories is planned,
+// while links looping back to a parent directory and broken links are skipped without hanging.
+func TestPlanDirectoryTransferFollowsSymlinks(t *testing.T) {
+	plan := planWithTimeout(t, newSymlinkTestDir(t), DirectoryTransferOptions{FollowSymlinks: true, ComputeChecksums: true})
+
+	expectedFiles := []string{"data/file.txt", "link.txt", "linked/file.txt"}
+	if got := plannedPaths(plan.Files); !reflect.DeepEqual(got, expectedFiles) {
+		t.Fatalf("expected the files %v, got %v", expectedFiles, got)
+	}
+	checksum := hex.EncodeToString(CalculateDataChecksum([]byte("content")))
+	for _, file := range plan.Files {
+		if file.Size != 7 || file.Checksum != checksum {
+			t.Fatalf("expected %s to be planned with the content of data/file.txt, got %+v", file.Path, file)
+		}
+	}
+	if !reflect.DeepEqual(plan.Dirs, []string{"data", "linked"}) {
+		t.Fatalf("expected the directories [data linked], got %v", plan.Dirs)
+	}
+
+	expectedLinks := []PlannedLink{
+		{Path: "broken", Target: "missing"},
+		{Path: "data/loop", Target: "..", Loop: true},
+		{Path: "linked/loop", Target: "..", Loop: true},
+	}
+	if !reflect.DeepEqual(plan.Symlinks, expectedLinks) {
+		t.Fatalf("expected the skipped links %+v, got %+v", expectedLinks, plan.Symlinks)
+	}
+	if plan.Stats.TotalFiles != 3 || plan.Stats.SkippedSymlinks != 3 {
+		t.Fatalf("unexpected stats: %+v", plan.Stats)
+	}
+}
+
+// TestTransferPlanJSONRoundTrip tests that a `TransferPlan` survives a JSON round-trip
+// with the documented field names.
+func TestTransferPlanJSONRoundTrip(t *testing.T) {
+	filter, err := NewPathFilter([]string{"important.log"}, []string{"*.log"})
+	if err != nil {
+		t.Fatalf("failed to create the filter: %v", err)
+	}
+	plan, err := PlanDirectoryTransfer(newPlanTestFS(), "project", DirectoryTransferOptions{Filter: filter})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("failed to marshal the plan: %v", err)
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("failed to unmarshal the plan: %v", err)
+	}
+	for _, name := range []string{"root", "files", "dirs", "too_large", "symlinks", "decisions", "stats"} {
+		if _, ok := fields[name]; !ok {
+			t.Fatalf("expected the JSON field %q, got %s", name, data)
+		}
+	}
+
+	var decoded TransferPlan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal the plan: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, plan) {
+		t.Fatalf("expected %+v after the round-trip, got %+v", plan, decoded)
+	}
+}
@@ -3,21 +3,227 @@ package protocol
 import (
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"strings"
+	"syscall"
 	"time"
 )
 
+// Constants for representing progress output modes.
+const (
+	ProgressModeAuto  = "auto"  // Progress bars on a terminal, plain lines otherwise (e.g. under cron or CI).
+	ProgressModeBar   = "bar"   // Progress bars redrawn in place with `\r`.
+	ProgressModePlain = "plain" // Periodic single-line updates, suitable for log files.
+	ProgressModeNone  = "none"  // No progress output at all.
+)
+
+// rateWindow is the span of the recent progress from which the ETA of a `ProgressTracker` is estimated,
+// so that a stall earlier in the transfer does not skew the estimate as the average rate since the start does.
+const rateWindow = 5 * time.Second
+
+// spinnerFrames are the frames of the spinner shown in place of the bar while the total size is unknown.
+const spinnerFrames = `|/-\`
+
+// DefaultBarWidth is the number of cells of a progress bar unless set with `WithBarWidth`.
+const DefaultBarWidth = 30
+
+// A BarStyle is the pair of runes a progress bar is drawn with.
+type BarStyle struct {
+	Fill  rune // Rune of the cells done.
+	Empty rune // Rune of the cells left.
+}
+
+// Styles of progress bars.
+var (
+	BarStyleASCII   = BarStyle{Fill: '=', Empty: '-'} // The default style, e.g. "[=====-----]".
+	BarStyleUnicode = BarStyle{Fill: '█', Empty: '░'} // Block characters, e.g. "[█████░░░░░]".
+)
+
+// progressChunkSize is the number of bytes handed at a time to the `io.ReaderFrom` of the destination by the `WriteTo`
+// and `ReadFrom` methods of progress readers and writers, between which the progress is updated.
+const progressChunkSize = 1024 * 1024
+
+// Intervals between progress updates for each output mode.
+const (
+	barUpdateInterval   = 250 * time.Millisecond // Update the progress bar every 250ms.
+	plainUpdateInterval = 5 * time.Second        // Print a plain progress line every 5s.
+)
+
+// ValidProgressMode reports whether mode is a known progress output mode.
+func ValidProgressMode(mode string) bool {
+	switch mode {
+	case ProgressModeAuto, ProgressModeBar, ProgressModePlain, ProgressModeNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// ResolveProgressMode resolves the "auto" progress mode (or an empty one) for the given output writer:
+// progress bars if the writer is a terminal, plain lines otherwise. Other modes are returned as is.
+func ResolveProgressMode(mode string, writer io.Writer) string {
+	if mode != ProgressModeAuto && mode != "" {
+		return mode
+	}
+	if IsTerminal(writer) {
+		return ProgressModeBar
+	}
+	return ProgressModePlain
+}
+
+// IsTerminal reports whether the writer is a terminal (a character device such as a TTY).
+func IsTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// updateInterval returns the interval between progress updates for the resolved output mode.
+func updateInterval(mode string) time.Duration {
+	if mode == ProgressModePlain {
+		return plainUpdateInterval
+	}
+	return barUpdateInterval
+}
+
+// formatSize formats a number of bytes with a human-readable unit (e.g. "1.2 GB").
+func formatSize(bytes uint64) string {
+	switch {
+	case bytes < 1024:
+		return fmt.Sprintf("%d bytes", bytes)
+	case bytes < 1024*1024:
+		return fmt.Sprintf("%.1f KB", toKB(bytes))
+	case bytes < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MB", toMB(bytes))
+	case bytes < 1024*1024*1024*1024:
+		return fmt.Sprintf("%.1f GB", toGB(bytes))
+	default:
+		return fmt.Sprintf("%.1f TB", toTB(bytes))
+	}
+}
+
+// formatSizeOf formats the bytes done out of a total, both in the unit of the total (e.g. "1.2/4.7 GB").
+func formatSizeOf(done, total uint64) string {
+	switch {
+	case total < 1024:
+		return fmt.Sprintf("%d/%d bytes", done, total)
+	case total < 1024*1024:
+		return fmt.Sprintf("%.1f/%.1f KB", toKB(done), toKB(total))
+	case total < 1024*1024*1024:
+		return fmt.Sprintf("%.1f/%.1f MB", toMB(done), toMB(total))
+	case total < 1024*1024*1024*1024:
+		return fmt.Sprintf("%.1f/%.1f GB", toGB(done), toGB(total))
+	default:
+		return fmt.Sprintf("%.1f/%.1f TB", toTB(done), toTB(total))
+	}
+}
+
+// formatRate formats a rate in bytes per second with a human-readable unit (e.g. "12.50 MB/s").
+func formatRate(bytesPerSecond float64) string {
+	switch {
+	case bytesPerSecond < 1024:
+		return fmt.Sprintf("%.0f bytes/s", bytesPerSecond)
+	case bytesPerSecond < 1024*1024:
+		return fmt.Sprintf("%.2f KB/s", bytesPerSecond/1024)
+	case bytesPerSecond < 1024*1024*1024:
+		return fmt.Sprintf("%.2f MB/s", bytesPerSecond/1024/1024)
+	default:
+		return fmt.Sprintf("%.2f GB/s", bytesPerSecond/1024/1024/1024)
+	}
+}
+
 // A ProgressTracker tracks the progress of file transfers.
 type ProgressTracker struct {
-	totalBytes        uint64        // Total number of bytes to transfer.
-	bytesTransferred  uint64        // Bytes transferred so far.
-	startTime         time.Time     // Time when the transfer started.
-	lastUpdate        time.Time     // Time of the last progress update.
-	barUpdateInterval time.Duration // Interval between progress bar updates.
-	description       string        // Description of the transfer.
-	writer            io.Writer     // Writer for progress output (defaults to os.Stderr).
+	totalBytes        uint64             // Total number of bytes to transfer.
+	bytesTransferred  uint64             // Bytes transferred so far.
+	startTime         time.Time          // Time when the transfer started.
+	lastUpdate        time.Time          // Time of the last progress update.
+	barUpdateInterval time.Duration      // Interval between progress bar updates.
+	description       string             // Description of the transfer.
+	writer            io.Writer          // Writer for progress output (defaults to os.Stderr).
+	mode              string             // Resolved progress output mode (bar, plain, or none).
+	callback          ProgressFunc       // Receiver of the progress instead of the output, if set (see `WithCallback`).
+	samples           []progressSample   // Progress at the updates within the last `rateWindow`, oldest first.
+	barWidth          int                // Number of cells of the progress bar.
+	barStyle          BarStyle           // Runes the progress bar is drawn with.
+	spinnerFrame      int                // Index of the next spinner frame, while the total size is unknown.
+	copyBuffer        []byte             // Buffer of the copies without a fast path (see `copyWithProgress`), or nil for a default one.
+	aggregate         *AggregateProgress // Overall progress fed with the bytes transferred, if set (see `WithAggregate`).
+}
+
+// A progressSample records the bytes transferred at a point in time, from which the recent rate is measured.
+type progressSample struct {
+	at    time.Time // Time of the sample.
+	bytes uint64    // Bytes transferred at that time.
+}
+
+// A ProgressFunc receives the progress of a transfer as data: the bytes transferred so far, the total bytes,
+// and the average rate in bytes per second. It is called on the goroutine making the transfer,
+// in the middle of its reads or writes, so it must return quickly and never block.
+type ProgressFunc func(transferred, total int64, rate float64)
+
+// A ProgressOption configures a `ProgressTracker` (and the `ProgressReader` or `ProgressWriter` encapsulating it).
+type ProgressOption func(*progressOptions)
+
+// progressOptions holds the options of a progress tracker.
+type progressOptions struct {
+	callback  ProgressFunc       // Receiver of the progress, replacing the output to the writer.
+	interval  time.Duration      // Interval between progress updates, or 0 for the default of the mode.
+	barWidth  int                // Number of cells of the progress bar, or 0 for `DefaultBarWidth`.
+	barStyle  *BarStyle          // Runes the progress bar is drawn with, or nil for `BarStyleASCII`.
+	buffer    []byte             // Buffer of the copies without a fast path, or nil for a default one.
+	aggregate *AggregateProgress // Overall progress fed with the bytes transferred, if any.
+}
+
+// WithCallback reports the progress to the function instead of rendering it to the writer:
+// at most once per update interval (250ms unless set with `WithInterval`) while the transfer runs,
+// and once from `Complete` with the final values.
+func WithCallback(callback ProgressFunc) ProgressOption {
+	return func(o *progressOptions) {
+		o.callback = callback
+	}
+}
+
+// WithInterval sets the minimum interval between progress updates, instead of the default of the output mode.
+func WithInterval(interval time.Duration) ProgressOption {
+	return func(o *progressOptions) {
+		o.interval = interval
+	}
+}
+
+// WithBarWidth sets the number of cells of the progress bar (e.g. fewer for a narrow terminal), instead of `DefaultBarWidth`.
+func WithBarWidth(width int) ProgressOption {
+	return func(o *progressOptions) {
+		o.barWidth = width
+	}
+}
+
+// WithBarStyle sets the runes the progress bar is drawn with (e.g. `BarStyleUnicode`), instead of `BarStyleASCII`.
+func WithBarStyle(style BarStyle) ProgressOption {
+	return func(o *progressOptions) {
+		o.barStyle = &style
+	}
+}
+
+// WithCopyBuffer sets the buffer with which the `WriteTo` and `ReadFrom` methods of a progress reader or writer copy
+// when neither side has a fast path (e.g. the size set with the client's "-buffer-size"), instead of a 32KB one.
+func WithCopyBuffer(buffer []byte) ProgressOption {
+	return func(o *progressOptions) {
+		o.buffer = buffer
+	}
+}
+
+// WithAggregate adds the bytes transferred to the overall progress of a transfer made up of several files,
+// e.g. with the mode set to `ProgressModeNone` in place of the progress of each file. Unlike wrapping the source with
+// `AggregateProgress.Reader`, it keeps the fast paths of the copies of a progress reader or writer (see `copyWithProgress`).
+func WithAggregate(aggregate *AggregateProgress) ProgressOption {
+	return func(o *progressOptions) {
+		o.aggregate = aggregate
+	}
 }
 
 // A ProgressReader tracks the progress of reading from an `io.Reader`.
@@ -42,112 +248,258 @@ func toMB(bytes uint64) float64 {
 	return float64(bytes) / 1024 / 1024
 }
 
+// toGB converts bytes to gigabytes.
+func toGB(bytes uint64) float64 {
+	return float64(bytes) / 1024 / 1024 / 1024
+}
+
+// toTB converts bytes to terabytes.
+func toTB(bytes uint64) float64 {
+	return float64(bytes) / 1024 / 1024 / 1024 / 1024
+}
+
 // NewProgressTracker instantiates a new progress tracker.
 // If writer is nil, it defaults to os.Stderr to keep os.Stdout clean for piping.
-func NewProgressTracker(totalBytes uint64, description string, writer io.Writer) *ProgressTracker {
+// The "auto" (or empty) mode is resolved against the writer (see `ResolveProgressMode`).
+// With `WithCallback`, the progress is reported to the callback instead, and the writer and mode are unused.
+func NewProgressTracker(totalBytes uint64, description string, writer io.Writer, mode string, opts ...ProgressOption) *ProgressTracker {
+	var options progressOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
 	if writer == nil {
 		writer = os.Stderr
 	}
+	mode = ResolveProgressMode(mode, writer)
+	interval := updateInterval(mode)
+	if options.callback != nil {
+		interval = barUpdateInterval
+	}
+	if options.interval > 0 {
+		interval = options.interval
+	}
+	barWidth := DefaultBarWidth
+	if options.barWidth > 0 {
+		barWidth = options.barWidth
+	}
+	barStyle := BarStyleASCII
+	if options.barStyle != nil {
+		barStyle = *options.barStyle
+	}
+	now := time.Now()
 	return &ProgressTracker{
 		totalBytes:        totalBytes,
 		bytesTransferred:  0,
-		startTime:         time.Now(),
-		lastUpdate:        time.Now(),
-		barUpdateInterval: 250 * time.Millisecond, // Update every 250ms.
+		startTime:         now,
+		lastUpdate:        now,
+		barUpdateInterval: interval,
 		description:       description,
 		writer:            writer,
+		mode:              mode,
+		callback:          options.callback,
+		samples:           []progressSample{{at: now}},
+		barWidth:          barWidth,
+		barStyle:          barStyle,
+		copyBuffer:        options.buffer,
+		aggregate:         options.aggregate,
 	}
 }
 
-// Update updates the progress and displays it if `barUpdateInterval` has passed.
+// SetTotal sets the total number of bytes of a transfer whose size became known (e.g. once its header arrives),
+// switching the display from the spinner to the progress bar.
+func (pt *ProgressTracker) SetTotal(totalBytes uint64) {
+	pt.totalBytes = totalBytes
+}
+
+// Update updates the progress and reports it if `barUpdateInterval` has passed.
 func (pt *ProgressTracker) Update(bytesTransferred uint64) {
+	if pt.aggregate != nil && bytesTransferred > pt.bytesTransferred {
+		pt.aggregate.Add(bytesTransferred - pt.bytesTransferred)
+	}
 	pt.bytesTransferred = bytesTransferred
 
 	now := time.Now()
 	if now.Sub(pt.lastUpdate) >= pt.barUpdateInterval {
-		pt.displayProgress()
+		pt.sample(now)
+		pt.report()
 		pt.lastUpdate = now
 	}
 }
 
-// Complete displays the final progress and transfer statistics.
-func (pt *ProgressTracker) Complete() {
-	pt.bytesTransferred = pt.totalBytes
+// sample records the current progress, dropping the samples older than needed to span `rateWindow`.
+// The newest sample before the window is kept, so that the recent rate is measured over the whole window.
+func (pt *ProgressTracker) sample(now time.Time) {
+	pt.samples = append(pt.samples, progressSample{at: now, bytes: pt.bytesTransferred})
+	drop := 0
+	for drop+1 < len(pt.samples) && now.Sub(pt.samples[drop+1].at) >= rateWindow {
+		drop++
+	}
+	pt.samples = pt.samples[drop:]
+}
+
+// recentRate calculates the transfer rate in bytes per second since the oldest sample within `rateWindow`,
+// or since the start of the transfer until a sample is recorded.
+func (pt *ProgressTracker) recentRate(now time.Time) float64 {
+	if len(pt.samples) == 0 {
+		return pt.bytesPerSecond()
+	}
+	oldest := pt.samples[0]
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 || pt.bytesTransferred < oldest.bytes {
+		return 0
+	}
+	return float64(pt.bytesTransferred-oldest.bytes) / elapsed
+}
+
+// ETA estimates the remaining time of the transfer from the rate over the last `rateWindow`.
+// It returns false if nothing was transferred within the window (e.g. at the start or during a stall).
+func (pt *ProgressTracker) ETA() (time.Duration, bool) {
+	if pt.bytesTransferred >= pt.totalBytes {
+		return 0, true
+	}
+	rate := pt.recentRate(time.Now())
+	if rate <= 0 {
+		return 0, false
+	}
+
+	remaining := float64(pt.totalBytes-pt.bytesTransferred) / rate * float64(time.Second)
+	return time.Duration(remaining), true
+}
+
+// report reports the current progress to the callback, or displays it on the writer without one.
+func (pt *ProgressTracker) report() {
+	if pt.callback != nil {
+		pt.callback(int64(pt.bytesTransferred), int64(pt.totalBytes), pt.bytesPerSecond())
+		return
+	}
 	pt.displayProgress()
+}
 
-	duration := time.Since(pt.startTime)
-	rate := pt.calculateRate()
+// Complete reports the final progress to the callback, or displays it along with the transfer statistics without one.
+// A transfer of an unknown total size (0) is reported with the bytes transferred so far, without a percentage.
+func (pt *ProgressTracker) Complete() {
+	if pt.totalBytes > 0 {
+		pt.bytesTransferred = pt.totalBytes
+	}
+	if pt.callback != nil {
+		pt.report()
+		return
+	}
+	if pt.mode == ProgressModeNone {
+		return
+	}
 
-	if pt.totalBytes < 1024 {
-		if _, err := fmt.Fprintf(pt.writer, "\n%s completed! %d bytes in %v\n",
-			pt.description, pt.totalBytes, duration); err != nil {
-			log.Printf("Failed to write the transfer completion message: %v", err)
-		}
-	} else if pt.totalBytes < 1024*1024 {
-		if _, err := fmt.Fprintf(pt.writer, "\n%s completed! %.1f KB in %v (%.2f MB/s)\n",
-			pt.description, toKB(pt.totalBytes), duration, rate); err != nil {
-			log.Printf("Failed to write the transfer completion message: %v", err)
-		}
+	// A progress bar is finished by its final state, while plain lines only need the completion message.
+	prefix := ""
+	if pt.mode == ProgressModeBar {
+		pt.displayProgress()
+		prefix = "\n"
+	}
 
+	duration := time.Since(pt.startTime)
+
+	var err error
+	if pt.bytesTransferred < 1024 {
+		_, err = fmt.Fprintf(pt.writer, "%s%s completed! %s in %v\n",
+			prefix, pt.description, formatSize(pt.bytesTransferred), duration)
 	} else {
-		if _, err := fmt.Fprintf(pt.writer, "\n%s completed! %.1f MB in %v (%.2f MB/s)\n",
-			pt.description, toMB(pt.totalBytes), duration, rate); err != nil {
-			log.Printf("Failed to write the transfer completion message: %v", err)
-		}
+		_, err = fmt.Fprintf(pt.writer, "%s%s completed! %s in %v (%s)\n",
+			prefix, pt.description, formatSize(pt.bytesTransferred), duration, formatRate(pt.bytesPerSecond()))
+	}
+	if err != nil {
+		slog.Warn("Failed to write the transfer completion message", "error", err)
 	}
 }
 
-// createProgressBar creates a visual progress bar.
+// createProgressBar creates a visual progress bar with the width and the style of the tracker.
 func (pt *ProgressTracker) createProgressBar(percentage float64) string {
-	const barWidth = 30
-	filled := int(percentage / 100 * barWidth)
+	return renderProgressBar(percentage, pt.barWidth, pt.barStyle)
+}
 
-	bar := strings.Repeat("=", filled)
-	bar += strings.Repeat("-", barWidth-filled)
+// renderProgressBar renders a visual progress bar of `width` cells for the given percentage.
+func renderProgressBar(percentage float64, width int, style BarStyle) string {
+	filled := int(percentage / 100 * float64(width))
+	filled = max(0, min(filled, width))
+
+	bar := strings.Repeat(string(style.Fill), filled)
+	bar += strings.Repeat(string(style.Empty), width-filled)
 
 	return "[" + bar + "]"
 }
 
 // calculateRate calculates the transfer rate in MB/s.
 func (pt *ProgressTracker) calculateRate() float64 {
+	return pt.bytesPerSecond() / 1024 / 1024
+}
+
+// bytesPerSecond calculates the transfer rate in bytes per second.
+func (pt *ProgressTracker) bytesPerSecond() float64 {
 	duration := time.Since(pt.startTime)
 	if duration.Seconds() > 0 {
-		return toMB(pt.bytesTransferred) / duration.Seconds()
+		return float64(pt.bytesTransferred) / duration.Seconds()
 	}
 	return 0
 }
 
-// displayProgress displays the current progress with a progress bar.
+// displayProgress displays the current progress with a progress bar, or as a plain line in the plain mode.
+// While the total size is unknown (0), a spinner (or a plain line) shows the bytes transferred so far instead.
 func (pt *ProgressTracker) displayProgress() {
+	if pt.mode == ProgressModeNone {
+		return
+	}
 	if pt.totalBytes == 0 {
+		pt.displayIndeterminate()
 		return
 	}
 
 	percentage := float64(pt.bytesTransferred) / float64(pt.totalBytes) * 100
+	elapsed := time.Since(pt.startTime).Round(time.Second)
+	etaDisplay := "--"
+	if eta, ok := pt.ETA(); ok {
+		etaDisplay = eta.Round(time.Second).String()
+	}
+
+	if pt.mode == ProgressModePlain {
+		_, _ = fmt.Fprintf(pt.writer, "%s: %.0f%% %s/%s (%v elapsed, ETA %s)\n",
+			pt.description, percentage, formatSize(pt.bytesTransferred), formatSize(pt.totalBytes), elapsed, etaDisplay)
+		return
+	}
+
 	progressBar := pt.createProgressBar(percentage)
-	rate := pt.calculateRate()
-
-	var sizeDisplay string
-	if pt.totalBytes < 1024 {
-		sizeDisplay = fmt.Sprintf("%d/%d bytes", pt.bytesTransferred, pt.totalBytes)
-	} else if pt.totalBytes < 1024*1024 {
-		sizeDisplay = fmt.Sprintf("%.1f/%.1f KB",
-			toKB(pt.bytesTransferred), toKB(pt.totalBytes))
-	} else {
-		sizeDisplay = fmt.Sprintf("%.1f/%.1f MB",
-			toMB(pt.bytesTransferred), toMB(pt.totalBytes))
+	rate := pt.recentRate(time.Now())
+
+	_, _ = fmt.Fprintf(pt.writer, "\r%s %s %.1f%% (%s, %s, %v elapsed, ETA %s)",
+		pt.description, progressBar, percentage, formatSizeOf(pt.bytesTransferred, pt.totalBytes), formatRate(rate), elapsed, etaDisplay)
+}
+
+// displayIndeterminate displays the bytes transferred, the rate, and the elapsed time of a transfer of an unknown size,
+// with a spinner in the bar mode. Nothing is displayed before the first byte.
+func (pt *ProgressTracker) displayIndeterminate() {
+	if pt.bytesTransferred == 0 {
+		return
 	}
 
-	_, _ = fmt.Fprintf(pt.writer, "\r%s %s %.1f%% (%s, %.2f MB/s)",
-		pt.description, progressBar, percentage, sizeDisplay, rate)
+	elapsed := time.Since(pt.startTime).Round(time.Second)
+	rate := pt.recentRate(time.Now())
+	if pt.mode == ProgressModePlain {
+		_, _ = fmt.Fprintf(pt.writer, "%s: %s (%s, %v elapsed)\n",
+			pt.description, formatSize(pt.bytesTransferred), formatRate(rate), elapsed)
+		return
+	}
+
+	frame := spinnerFrames[pt.spinnerFrame%len(spinnerFrames)]
+	pt.spinnerFrame++
+	_, _ = fmt.Fprintf(pt.writer, "\r%s %c %s (%s, %v elapsed)",
+		pt.description, frame, formatSize(pt.bytesTransferred), formatRate(rate), elapsed)
 }
 
-// NewProgressReader creates a new progress reader.
+// NewProgressReader creates a new progress reader, whose tracker is configured by the options (see `NewProgressTracker`).
 // If writer is nil, progress output defaults to os.Stderr to keep os.Stdout clean for piping.
-func NewProgressReader(reader io.Reader, totalBytes uint64, description string, writer io.Writer) *ProgressReader {
+func NewProgressReader(reader io.Reader, totalBytes uint64, description string, writer io.Writer, mode string, opts ...ProgressOption) *ProgressReader {
 	return &ProgressReader{
 		reader:  reader,
-		tracker: NewProgressTracker(totalBytes, description, writer),
+		tracker: NewProgressTracker(totalBytes, description, writer, mode, opts...),
 	}
 }
 
@@ -160,30 +512,308 @@ func (pr *ProgressReader) Read(p []byte) (n int, err error) {
 	return n, err
 }
 
+// WriteTo implements the `io.WriterTo` interface, so that `io.Copy` from a progress reader keeps the fast paths of
+// the underlying reader and of `w` (see `copyWithProgress`), and updates progress with the bytes written to `w`.
+func (pr *ProgressReader) WriteTo(w io.Writer) (int64, error) {
+	return copyWithProgress(w, pr.reader, pr.tracker)
+}
+
 // Complete marks the transfer as complete.
 func (pr *ProgressReader) Complete() {
 	pr.tracker.Complete()
 }
 
-// NewProgressWriter creates a new progress writer.
+// NewProgressWriter creates a new progress writer, whose tracker is configured by the options (see `NewProgressTracker`).
 // If progressWriter is nil, progress output defaults to os.Stderr to keep os.Stdout clean for piping.
-func NewProgressWriter(writer io.Writer, totalBytes uint64, description string, progressWriter io.Writer) *ProgressWriter {
+func NewProgressWriter(writer io.Writer, totalBytes uint64, description string, progressWriter io.Writer, mode string, opts ...ProgressOption) *ProgressWriter {
 	return &ProgressWriter{
 		writer:  writer,
-		tracker: NewProgressTracker(totalBytes, description, progressWriter),
+		tracker: NewProgressTracker(totalBytes, description, progressWriter, mode, opts...),
 	}
 }
 
-// Write implements the `io.Writer` interface and updates progress.
+// Write implements the `io.Writer` interface and updates progress with the cumulative number of bytes written.
+// A short write of the underlying writer is retried with the rest of `p`, so that `p` is written in full unless an error
+// is returned. An underlying writer that makes no progress without an error fails with `io.ErrShortWrite`.
 func (pw *ProgressWriter) Write(p []byte) (n int, err error) {
-	n, err = pw.writer.Write(p)
-	if n > 0 {
-		pw.tracker.Update(pw.tracker.bytesTransferred + uint64(n))
+	return writeWithProgress(pw.writer, pw.tracker, p)
+}
+
+// writeWithProgress writes `p` in full to `w`, retrying short writes, and updates the tracker with the bytes written
+// (see `ProgressWriter.Write`).
+func writeWithProgress(w io.Writer, tracker *ProgressTracker, p []byte) (n int, err error) {
+	for n < len(p) {
+		written, err := w.Write(p[n:])
+		if written > 0 {
+			n += written
+			tracker.Update(tracker.bytesTransferred + uint64(written))
+		}
+		if err != nil {
+			return n, err
+		}
+		if written == 0 {
+			return n, io.ErrShortWrite
+		}
 	}
-	return n, err
+	return n, nil
+}
+
+// ReadFrom implements the `io.ReaderFrom` interface, so that `io.Copy` to a progress writer keeps the fast paths of
+// `r` and of the underlying writer (see `copyWithProgress`), and updates progress with the bytes written.
+func (pw *ProgressWriter) ReadFrom(r io.Reader) (int64, error) {
+	return copyWithProgress(pw.writer, r, pw.tracker)
 }
 
 // Complete marks the transfer as complete.
 func (pw *ProgressWriter) Complete() {
 	pw.tracker.Complete()
 }
+
+// progressCounter is an `io.Writer` that updates a progress tracker with the bytes written to the underlying writer.
+type progressCounter struct {
+	writer  io.Writer        // Underlying writer.
+	tracker *ProgressTracker // Tracker updated with the bytes written.
+}
+
+// Write implements the `io.Writer` interface like `ProgressWriter.Write`.
+func (pc *progressCounter) Write(p []byte) (int, error) {
+	return writeWithProgress(pc.writer, pc.tracker, p)
+}
+
+// copyWithProgress copies from `src` to `dst` like `io.Copy`, updating the tracker as the bytes are copied,
+// while keeping the fast paths that wrapping either side would lose:
+//   - The `io.WriterTo` of an in-memory source (e.g. `bytes.Reader`) writes through a `progressCounter` in one go.
+//   - A source backed by a file descriptor (e.g. `*os.File` or a TCP connection) is handed to the `io.ReaderFrom` of
+//     the destination by chunks of `progressChunkSize` bytes, as an `io.LimitedReader`, which the `ReadFrom` of files
+//     and TCP connections unwrap to copy in the kernel (sendfile, splice, or copy_file_range).
+//   - Otherwise, the bytes are copied through a `progressCounter` with the buffer of `WithCopyBuffer`.
+func copyWithProgress(dst io.Writer, src io.Reader, tracker *ProgressTracker) (int64, error) {
+	// The `WriteTo` of a file only has a fast path to a destination it can see, which the counter would hide.
+	_, isFile := src.(*os.File)
+	if writerTo, ok := src.(io.WriterTo); ok && !isFile {
+		return writerTo.WriteTo(&progressCounter{writer: dst, tracker: tracker})
+	}
+
+	_, hasDescriptor := src.(syscall.Conn)
+	if _, ok := dst.(io.ReaderFrom); ok && hasDescriptor {
+		var written int64
+		for {
+			n, err := io.Copy(dst, &io.LimitedReader{R: src, N: progressChunkSize})
+			written += n
+			if n > 0 {
+				tracker.Update(tracker.bytesTransferred + uint64(n))
+			}
+			if err != nil || n < progressChunkSize {
+				return written, err
+			}
+		}
+	}
+
+	// The source is hidden behind a plain `io.Reader`, so that `io.CopyBuffer` does not call back into its `WriteTo`.
+	return io.CopyBuffer(&progressCounter{writer: dst, tracker: tracker}, struct{ io.Reader }{src}, tracker.copyBuffer)
+}
+
+// An AggregateProgress tracks the overall progress of a transfer made up of several files (e.g. a directory transfer),
+// accumulating bytes across files against the total size of the transfer.
+type AggregateProgress struct {
+	totalBytes        uint64        // Total number of bytes of all files.
+	totalFiles        int           // Total number of files.
+	completedBytes    uint64        // Bytes of the files done so far.
+	currentBytes      uint64        // Bytes transferred of the file in flight.
+	filesDone         int           // Number of files done so far, whatever their outcome.
+	filesFailed       int           // Number of the files done that failed.
+	filesSkipped      int           // Number of the files done that were skipped.
+	currentFile       string        // Name of the file in flight, if set with `StartFile`.
+	lastLineLength    int           // Length of the last progress bar line, which a shorter line is padded to.
+	startTime         time.Time     // Time when the transfer started.
+	lastUpdate        time.Time     // Time of the last progress update.
+	barUpdateInterval time.Duration // Interval between progress line updates.
+	description       string        // Description of the transfer.
+	writer            io.Writer     // Writer for progress output (defaults to os.Stderr).
+	mode              string        // Resolved progress output mode (bar, plain, or none).
+}
+
+// NewAggregateProgress instantiates a new aggregate progress tracker.
+// If writer is nil, it defaults to os.Stderr to keep os.Stdout clean for piping.
+// The "auto" (or empty) mode is resolved against the writer (see `ResolveProgressMode`).
+func NewAggregateProgress(totalBytes uint64, totalFiles int, description string, writer io.Writer, mode string) *AggregateProgress {
+	if writer == nil {
+		writer = os.Stderr
+	}
+	mode = ResolveProgressMode(mode, writer)
+	return &AggregateProgress{
+		totalBytes:        totalBytes,
+		totalFiles:        totalFiles,
+		startTime:         time.Now(),
+		lastUpdate:        time.Now(),
+		barUpdateInterval: updateInterval(mode),
+		description:       description,
+		writer:            writer,
+		mode:              mode,
+	}
+}
+
+// Add records bytes transferred of the file in flight and displays the progress if `barUpdateInterval` has passed.
+func (ap *AggregateProgress) Add(n uint64) {
+	ap.currentBytes += n
+
+	now := time.Now()
+	if now.Sub(ap.lastUpdate) >= ap.barUpdateInterval {
+		ap.displayProgress()
+		ap.lastUpdate = now
+	}
+}
+
+// StartFile sets the name of the file in flight, which the progress bar shows after the overall progress.
+func (ap *AggregateProgress) StartFile(name string) {
+	ap.currentFile = name
+}
+
+// RestartFile discards the bytes transferred of the file in flight, which is sent again from its start
+// (e.g. on a new connection after the previous one was lost).
+func (ap *AggregateProgress) RestartFile() {
+	ap.currentBytes = 0
+}
+
+// FileDone marks the file in flight as done, counting its full size.
+func (ap *AggregateProgress) FileDone(size uint64) {
+	ap.finishFile(size)
+}
+
+// FileFailed marks the file in flight as done but failed, counting its full size
+// so that the percentage still reaches 100%.
+func (ap *AggregateProgress) FileFailed(size uint64) {
+	ap.filesFailed++
+	ap.finishFile(size)
+}
+
+// FileSkipped marks the file in flight as done but skipped (e.g. a file the server already has), counting its full size.
+func (ap *AggregateProgress) FileSkipped(size uint64) {
+	ap.filesSkipped++
+	ap.finishFile(size)
+}
+
+// finishFile settles the file in flight at its full size and displays the progress.
+func (ap *AggregateProgress) finishFile(size uint64) {
+	ap.completedBytes += size
+	ap.currentBytes = 0
+	ap.currentFile = ""
+	ap.filesDone++
+
+	// Plain lines stay throttled across files, so that many small files do not flood the log.
+	now := time.Now()
+	if ap.mode == ProgressModeBar || now.Sub(ap.lastUpdate) >= ap.barUpdateInterval {
+		ap.displayProgress()
+		ap.lastUpdate = now
+	}
+}
+
+// Complete displays the final overall progress, which reflects the files actually done
+// (so an interrupted transfer is not reported as complete), with the number of files completed, failed, and skipped.
+func (ap *AggregateProgress) Complete() {
+	if ap.mode == ProgressModeNone {
+		return
+	}
+
+	prefix := ""
+	if ap.mode == ProgressModeBar {
+		ap.displayProgress()
+		prefix = "\n"
+	}
+	completed := ap.filesDone - ap.filesFailed - ap.filesSkipped
+	_, _ = fmt.Fprintf(ap.writer, "%s%s: %d/%d files done in %v (%d completed, %d failed, %d skipped)\n",
+		prefix, ap.description, ap.filesDone, ap.totalFiles, time.Since(ap.startTime).Round(time.Millisecond),
+		completed, ap.filesFailed, ap.filesSkipped)
+}
+
+// Percentage returns the overall completion percentage.
+func (ap *AggregateProgress) Percentage() float64 {
+	if ap.totalBytes == 0 {
+		if ap.totalFiles == 0 {
+			return 100
+		}
+		return float64(ap.filesDone) / float64(ap.totalFiles) * 100
+	}
+
+	percentage := float64(ap.completedBytes+ap.currentBytes) / float64(ap.totalBytes) * 100
+	if percentage > 100 {
+		percentage = 100
+	}
+	return percentage
+}
+
+// ETA estimates the remaining time of the transfer from the average rate so far.
+// It returns false if no bytes have been transferred yet.
+func (ap *AggregateProgress) ETA() (time.Duration, bool) {
+	done := ap.completedBytes + ap.currentBytes
+	elapsed := time.Since(ap.startTime)
+	if done == 0 || elapsed <= 0 {
+		return 0, false
+	}
+	if done >= ap.totalBytes {
+		return 0, true
+	}
+
+	remaining := float64(ap.totalBytes-done) / float64(done) * float64(elapsed)
+	return time.Duration(remaining), true
+}
+
+// Reader wraps the reader of the file in flight so that bytes read from it are added to the overall progress.
+func (ap *AggregateProgress) Reader(reader io.Reader) io.Reader {
+	return &aggregateReader{reader: reader, progress: ap}
+}
+
+// bytesPerSecond calculates the overall transfer rate in bytes per second.
+func (ap *AggregateProgress) bytesPerSecond() float64 {
+	elapsed := time.Since(ap.startTime).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(ap.completedBytes+ap.currentBytes) / elapsed
+}
+
+// displayProgress displays the overall progress line, followed by the file in flight in the bar mode.
+func (ap *AggregateProgress) displayProgress() {
+	if ap.mode == ProgressModeNone {
+		return
+	}
+
+	percentage := ap.Percentage()
+	etaDisplay := "--"
+	if eta, ok := ap.ETA(); ok {
+		etaDisplay = eta.Round(time.Second).String()
+	}
+	details := fmt.Sprintf("%d/%d files, %s, %s, ETA %s", ap.filesDone, ap.totalFiles,
+		formatSizeOf(min(ap.completedBytes+ap.currentBytes, ap.totalBytes), ap.totalBytes), formatRate(ap.bytesPerSecond()), etaDisplay)
+
+	if ap.mode == ProgressModePlain {
+		_, _ = fmt.Fprintf(ap.writer, "%s: %.0f%% (%s)\n", ap.description, percentage, details)
+		return
+	}
+
+	progressBar := renderProgressBar(percentage, DefaultBarWidth, BarStyleASCII)
+	line := fmt.Sprintf("%s %s %.1f%% (%s)", ap.description, progressBar, percentage, details)
+	if ap.currentFile != "" {
+		line += " " + ap.currentFile
+	}
+
+	// The line is redrawn in place, so the rest of a longer previous line (e.g. a longer file name) is blanked out.
+	padding := max(0, ap.lastLineLength-len(line))
+	ap.lastLineLength = len(line)
+	_, _ = fmt.Fprintf(ap.writer, "\r%s%s", line, strings.Repeat(" ", padding))
+}
+
+// An aggregateReader adds the bytes read from the underlying reader to an `AggregateProgress`.
+type aggregateReader struct {
+	reader   io.Reader          // Underlying reader.
+	progress *AggregateProgress // Overall progress to be updated.
+}
+
+// Read implements the `io.Reader` interface and updates the overall progress.
+func (ar *aggregateReader) Read(p []byte) (n int, err error) {
+	n, err = ar.reader.Read(p)
+	if n > 0 {
+		ar.progress.Add(uint64(n))
+	}
+	return n, err
+}
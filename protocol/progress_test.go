@@ -1,10 +1,16 @@
 package protocol
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -52,10 +58,68 @@ func TestToMB5GB(t *testing.T) {
 	}
 }
 
+// TestToGB tests the `toGB` function at the gigabyte boundary and with 5 GB.
+func TestToGB(t *testing.T) {
+	if got := toGB(1024 * 1024 * 1024); got != 1.0 {
+		t.Errorf("toGB(1 GiB) = %f; want 1", got)
+	}
+	if got := toGB(5 * 1024 * 1024 * 1024); got != 5.0 {
+		t.Errorf("toGB(5 GiB) = %f; want 5", got)
+	}
+}
+
+// TestToTB tests the `toTB` function at the terabyte boundary and with half a terabyte.
+func TestToTB(t *testing.T) {
+	if got := toTB(1024 * 1024 * 1024 * 1024); got != 1.0 {
+		t.Errorf("toTB(1 TiB) = %f; want 1", got)
+	}
+	if got := toTB(512 * 1024 * 1024 * 1024); got != 0.5 {
+		t.Errorf("toTB(512 GiB) = %f; want 0.5", got)
+	}
+}
+
+// TestFormatSizeBoundaries tests `formatSize`, `formatSizeOf`, and `formatRate` to ensure that
+// each unit starts exactly at its power of 1024.
+func TestFormatSizeBoundaries(t *testing.T) {
+	tests := []struct {
+		bytes uint64
+		size  string
+		of    string
+		rate  string
+	}{
+		{0, "0 bytes", "0/0 bytes", "0 bytes/s"},
+		{1023, "1023 bytes", "1023/1023 bytes", "1023 bytes/s"},
+		{1024, "1.0 KB", "1.0/1.0 KB", "1.00 KB/s"},
+		{1024*1024 - 1, "1024.0 KB", "1024.0/1024.0 KB", "1024.00 KB/s"},
+		{1024 * 1024, "1.0 MB", "1.0/1.0 MB", "1.00 MB/s"},
+		{1024*1024*1024 - 1, "1024.0 MB", "1024.0/1024.0 MB", "1024.00 MB/s"},
+		{1024 * 1024 * 1024, "1.0 GB", "1.0/1.0 GB", "1.00 GB/s"},
+		{5046586573, "4.7 GB", "4.7/4.7 GB", "4.70 GB/s"},
+		{1024 * 1024 * 1024 * 1024, "1.0 TB", "1.0/1.0 TB", "1024.00 GB/s"},
+	}
+
+	for _, tt := range tests {
+		if got := formatSize(tt.bytes); got != tt.size {
+			t.Errorf("formatSize(%d) = %q; want %q", tt.bytes, got, tt.size)
+		}
+		if got := formatSizeOf(tt.bytes, tt.bytes); got != tt.of {
+			t.Errorf("formatSizeOf(%d, %d) = %q; want %q", tt.bytes, tt.bytes, got, tt.of)
+		}
+		if got := formatRate(float64(tt.bytes)); got != tt.rate {
+			t.Errorf("formatRate(%d) = %q; want %q", tt.bytes, got, tt.rate)
+		}
+	}
+
+	// The bytes done are shown in the unit of the total.
+	if got := formatSizeOf(512*1024*1024, 4*1024*1024*1024); got != "0.5/4.0 GB" {
+		t.Errorf("formatSizeOf(512 MiB, 4 GiB) = %q; want %q", got, "0.5/4.0 GB")
+	}
+}
+
 // TestNewProgressTracker tests the `NewProgressTracker` constructor to ensure that
 // it expectedly initializes with given total bytes and description.
 func TestNewProgressTracker(t *testing.T) {
-	pt := NewProgressTracker(0, "", os.Stderr)
+	pt := NewProgressTracker(0, "", os.Stderr, ProgressModeBar)
 	if pt.totalBytes != 0 {
 		t.Errorf("Expected totalBytes to be 0, got %d", pt.totalBytes)
 	}
@@ -66,7 +130,7 @@ func TestNewProgressTracker(t *testing.T) {
 
 // TestNewProgressTrackerWithNilWriter tests that `NewProgressTracker` defaults to `os.Stderr` when writer is nil.
 func TestNewProgressTrackerWithNilWriter(t *testing.T) {
-	pt := NewProgressTracker(1000, "Test", nil)
+	pt := NewProgressTracker(1000, "Test", nil, ProgressModeBar)
 	if pt.writer != os.Stderr {
 		t.Errorf("Expected writer to be os.Stderr when nil is passed, got %v", pt.writer)
 	}
@@ -81,7 +145,7 @@ func TestNewProgressTrackerWithNilWriter(t *testing.T) {
 // TestProgressTrackerUpdate tests the `Update` method of the `ProgressTracker` struct to ensure that
 // it expectedly updates the bytes transferred.
 func TestProgressTrackerUpdate(t *testing.T) {
-	pt := NewProgressTracker(1000, "Test Transfer", os.Stderr)
+	pt := NewProgressTracker(1000, "Test Transfer", os.Stderr, ProgressModeBar)
 	pt.Update(500)
 	if pt.bytesTransferred != 500 {
 		t.Errorf("Expected bytesTransferred to be 500, got %d", pt.bytesTransferred)
@@ -91,7 +155,7 @@ func TestProgressTrackerUpdate(t *testing.T) {
 // TestProgressTrackerUpdateMultiple tests the `Update` method with multiple calls to ensure that
 // it expectedly accumulates the bytes transferred.
 func TestProgressTrackerUpdateMultiple(t *testing.T) {
-	pt := NewProgressTracker(1000, "Test Transfer", os.Stderr)
+	pt := NewProgressTracker(1000, "Test Transfer", os.Stderr, ProgressModeBar)
 	pt.Update(100)
 	pt.Update(300)
 	pt.Update(800)
@@ -124,7 +188,7 @@ func TestProgressTrackerCompleteWithErrorWriter(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pt := NewProgressTracker(tt.totalBytes, tt.name, errorWriter)
+			pt := NewProgressTracker(tt.totalBytes, tt.name, errorWriter, ProgressModeBar)
 			pt.Update(tt.totalBytes / 2)
 			pt.Complete()
 			if pt.bytesTransferred != tt.totalBytes {
@@ -151,7 +215,7 @@ func TestCreateProgressBar(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pt := NewProgressTracker(1000, "Test", os.Stderr)
+			pt := NewProgressTracker(1000, "Test", os.Stderr, ProgressModeBar)
 			got := pt.createProgressBar(tt.percentage)
 			if got != tt.expected {
 				t.Errorf("createProgressBar(%.1f) = %q; expected %q", tt.percentage, got, tt.expected)
@@ -162,7 +226,7 @@ func TestCreateProgressBar(t *testing.T) {
 
 // TestCreateProgressBarEdgeCases tests the `createProgressBar` method with edge-case percentages.
 func TestCreateProgressBarEdgeCases(t *testing.T) {
-	pt := NewProgressTracker(1000, "Test", os.Stderr)
+	pt := NewProgressTracker(1000, "Test", os.Stderr, ProgressModeBar)
 
 	// Test a very low percentage just above 0%.
 	bar := pt.createProgressBar(0.1)
@@ -177,11 +241,33 @@ func TestCreateProgressBarEdgeCases(t *testing.T) {
 	}
 }
 
+// TestCreateProgressBarWidthAndStyle tests the `createProgressBar` method with `WithBarWidth` and `WithBarStyle`
+// to ensure that the bar has the given number of cells, drawn with the given runes.
+func TestCreateProgressBarWidthAndStyle(t *testing.T) {
+	pt := NewProgressTracker(1000, "Test", os.Stderr, ProgressModeBar, WithBarWidth(10))
+	if bar := pt.createProgressBar(30); bar != "[===-------]" {
+		t.Errorf("expected a 10-cell bar, got %q", bar)
+	}
+
+	pt = NewProgressTracker(1000, "Test", os.Stderr, ProgressModeBar, WithBarWidth(10), WithBarStyle(BarStyleUnicode))
+	if bar := pt.createProgressBar(50); bar != "[█████░░░░░]" {
+		t.Errorf("expected a half-filled bar of block characters, got %q", bar)
+	}
+	if bar := pt.createProgressBar(150); bar != "[██████████]" {
+		t.Errorf("expected a percentage above 100 to fill the bar, got %q", bar)
+	}
+
+	pt = NewProgressTracker(1000, "Test", os.Stderr, ProgressModeBar, WithBarStyle(BarStyle{Fill: '#', Empty: '.'}))
+	if bar := pt.createProgressBar(50); bar != "["+strings.Repeat("#", 15)+strings.Repeat(".", 15)+"]" {
+		t.Errorf("expected a half-filled bar of custom runes with the default width, got %q", bar)
+	}
+}
+
 // TestProgressReaderReadMultiple tests the `Read` method with multiple reads to ensure that
 // it expectedly accumulates the bytes transferred.
 func TestProgressReaderReadMultiple(t *testing.T) {
 	reader := strings.NewReader("hello world test")
-	pr := NewProgressReader(reader, 16, "Download", os.Stderr)
+	pr := NewProgressReader(reader, 16, "Download", os.Stderr, ProgressModeBar)
 
 	buf := make([]byte, 5)
 	n1, _ := pr.Read(buf)
@@ -198,7 +284,7 @@ func TestProgressReaderReadMultiple(t *testing.T) {
 // it expectedly returns `io.EOF` when the underlying reader is saturated.
 func TestProgressReaderReadEOF(t *testing.T) {
 	reader := strings.NewReader("test")
-	pr := NewProgressReader(reader, 4, "Download", os.Stderr)
+	pr := NewProgressReader(reader, 4, "Download", os.Stderr, ProgressModeBar)
 
 	buf := make([]byte, 10)
 	n, err := pr.Read(buf)
@@ -228,7 +314,7 @@ func TestProgressReaderReadEOF(t *testing.T) {
 // it expectedly accumulates the bytes transferred.
 func TestProgressWriterWriteMultiple(t *testing.T) {
 	writer := &strings.Builder{}
-	pw := NewProgressWriter(writer, 15, "Upload", os.Stderr)
+	pw := NewProgressWriter(writer, 15, "Upload", os.Stderr, ProgressModeBar)
 
 	if _, err := pw.Write([]byte("hello")); err != nil {
 		t.Errorf("Unexpected error: %v", err)
@@ -248,11 +334,199 @@ func TestProgressWriterWriteMultiple(t *testing.T) {
 	}
 }
 
+// A shortWriter accepts at most `limit` bytes per write, without an error, and stalls once `stall` writes are made.
+type shortWriter struct {
+	bytes.Buffer
+	limit  int
+	writes int
+	stall  int
+}
+
+// Write writes at most `limit` bytes of p.
+func (sw *shortWriter) Write(p []byte) (int, error) {
+	sw.writes++
+	if sw.stall > 0 && sw.writes > sw.stall {
+		return 0, nil
+	}
+	return sw.Buffer.Write(p[:min(len(p), sw.limit)])
+}
+
+// TestProgressWriterShortWrites tests the `Write` method of `ProgressWriter` with an underlying writer that short-writes
+// to ensure that it retries until the whole buffer lands, and fails rather than spinning on a writer that stalls.
+func TestProgressWriterShortWrites(t *testing.T) {
+	content := []byte("a buffer written three bytes at a time")
+	writer := &shortWriter{limit: 3}
+	pw := NewProgressWriter(writer, uint64(len(content)), "Upload", io.Discard, ProgressModeNone)
+
+	n, err := pw.Write(content)
+	if err != nil || n != len(content) {
+		t.Fatalf("expected %d bytes written without an error, got %d: %v", len(content), n, err)
+	}
+	if writer.String() != string(content) || pw.tracker.bytesTransferred != uint64(len(content)) {
+		t.Fatalf("expected the full buffer and %d bytes of progress, got %q and %d", len(content), writer.String(), pw.tracker.bytesTransferred)
+	}
+
+	stalled := &shortWriter{limit: 3, stall: 2}
+	pw = NewProgressWriter(stalled, uint64(len(content)), "Upload", io.Discard, ProgressModeNone)
+	if n, err := pw.Write(content); !errors.Is(err, io.ErrShortWrite) || n != 6 {
+		t.Fatalf("expected io.ErrShortWrite after 6 bytes, got %d: %v", n, err)
+	}
+}
+
+// writeRecorder is an `io.Writer` without `io.ReaderFrom` that records the size of each write.
+type writeRecorder struct {
+	data   []byte
+	writes []int
+}
+
+// Write appends p and records its size.
+func (wr *writeRecorder) Write(p []byte) (int, error) {
+	wr.data = append(wr.data, p...)
+	wr.writes = append(wr.writes, len(p))
+	return len(p), nil
+}
+
+// TestProgressReaderWriteTo tests the `WriteTo` method of `ProgressReader` to ensure that it keeps the `io.WriterTo`
+// of an in-memory source (a single write), hands a file to the `io.ReaderFrom` of a file, copies other readers with
+// the buffer of `WithCopyBuffer`, and tracks every byte in each case.
+func TestProgressReaderWriteTo(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), progressChunkSize/4) // 2.5 chunks.
+
+	t.Run("in-memory source", func(t *testing.T) {
+		writer := &writeRecorder{}
+		pr := NewProgressReader(bytes.NewReader(content), uint64(len(content)), "Upload", io.Discard, ProgressModeNone)
+		if n, err := io.Copy(writer, pr); err != nil || n != int64(len(content)) {
+			t.Fatalf("expected %d bytes copied, got %d: %v", len(content), n, err)
+		}
+		if len(writer.writes) != 1 || !bytes.Equal(writer.data, content) {
+			t.Fatalf("expected the content in a single write, got %d writes", len(writer.writes))
+		}
+		if pr.tracker.bytesTransferred != uint64(len(content)) {
+			t.Fatalf("expected %d bytes of progress, got %d", len(content), pr.tracker.bytesTransferred)
+		}
+	})
+
+	t.Run("file to file", func(t *testing.T) {
+		dir := t.TempDir()
+		sourcePath := filepath.Join(dir, "source")
+		if err := os.WriteFile(sourcePath, content, 0644); err != nil {
+			t.Fatalf("failed to create the source file: %v", err)
+		}
+		source, err := os.Open(sourcePath)
+		if err != nil {
+			t.Fatalf("failed to open the source file: %v", err)
+		}
+		defer source.Close()
+		destination, err := os.Create(filepath.Join(dir, "destination"))
+		if err != nil {
+			t.Fatalf("failed to create the destination file: %v", err)
+		}
+		defer destination.Close()
+
+		var updates []uint64
+		pr := NewProgressReader(source, uint64(len(content)), "Upload", nil, ProgressModeNone,
+			WithInterval(time.Nanosecond), WithCallback(func(transferred, total int64, rate float64) { updates = append(updates, uint64(transferred)) }))
+		if n, err := io.Copy(destination, pr); err != nil || n != int64(len(content)) {
+			t.Fatalf("expected %d bytes copied, got %d: %v", len(content), n, err)
+		}
+		if data, err := os.ReadFile(destination.Name()); err != nil || !bytes.Equal(data, content) {
+			t.Fatalf("expected the destination to hold the content, got %d bytes: %v", len(data), err)
+		}
+		if pr.tracker.bytesTransferred != uint64(len(content)) {
+			t.Fatalf("expected %d bytes of progress, got %d", len(content), pr.tracker.bytesTransferred)
+		}
+		if len(updates) < 2 {
+			t.Fatalf("expected the progress to be updated by chunks, got the updates %v", updates)
+		}
+	})
+
+	t.Run("plain reader", func(t *testing.T) {
+		writer := &writeRecorder{}
+		pr := NewProgressReader(struct{ io.Reader }{bytes.NewReader(content[:100])}, 100, "Upload", io.Discard, ProgressModeNone,
+			WithCopyBuffer(make([]byte, 10)))
+		if n, err := io.Copy(writer, pr); err != nil || n != 100 {
+			t.Fatalf("expected 100 bytes copied, got %d: %v", n, err)
+		}
+		if !bytes.Equal(writer.data, content[:100]) || pr.tracker.bytesTransferred != 100 {
+			t.Fatalf("expected the content and 100 bytes of progress, got %d bytes and %d", len(writer.data), pr.tracker.bytesTransferred)
+		}
+		if len(writer.writes) != 10 {
+			t.Fatalf("expected 10 writes of the 10-byte buffer, got the writes %v", writer.writes)
+		}
+	})
+}
+
+// TestProgressWriterReadFrom tests the `ReadFrom` method of `ProgressWriter` to ensure that it keeps the `io.WriterTo`
+// of an in-memory source, retries the short writes of the underlying writer otherwise, and tracks every byte.
+func TestProgressWriterReadFrom(t *testing.T) {
+	content := []byte("a buffer written three bytes at a time")
+
+	writer := &writeRecorder{}
+	pw := NewProgressWriter(writer, uint64(len(content)), "Download", io.Discard, ProgressModeNone)
+	if n, err := io.Copy(pw, bytes.NewReader(content)); err != nil || n != int64(len(content)) {
+		t.Fatalf("expected %d bytes copied, got %d: %v", len(content), n, err)
+	}
+	if len(writer.writes) != 1 || pw.tracker.bytesTransferred != uint64(len(content)) {
+		t.Fatalf("expected a single write and %d bytes of progress, got %d writes and %d", len(content), len(writer.writes), pw.tracker.bytesTransferred)
+	}
+
+	short := &shortWriter{limit: 3}
+	pw = NewProgressWriter(short, uint64(len(content)), "Download", io.Discard, ProgressModeNone)
+	if n, err := io.Copy(pw, iotest.HalfReader(bytes.NewReader(content))); err != nil || n != int64(len(content)) {
+		t.Fatalf("expected %d bytes copied, got %d: %v", len(content), n, err)
+	}
+	if short.String() != string(content) || pw.tracker.bytesTransferred != uint64(len(content)) {
+		t.Fatalf("expected the content and %d bytes of progress, got %q and %d", len(content), short.String(), pw.tracker.bytesTransferred)
+	}
+}
+
+// BenchmarkProgressReaderCopy benchmarks `io.Copy` of a 1GB in-memory source through a `ProgressReader` (and into a
+// `ProgressWriter`) against an unwrapped copy, to show that the wrappers keep the fast path of the source.
+func BenchmarkProgressReaderCopy(b *testing.B) {
+	content := make([]byte, 1<<30)
+	destinations := []struct {
+		name   string
+		writer io.Writer
+	}{
+		{"discard", io.Discard},
+		{"hash", sha256.New()}, // A destination reading every byte, without `io.ReaderFrom`.
+	}
+
+	for _, destination := range destinations {
+		b.Run(destination.name+"/unwrapped", func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for b.Loop() {
+				if _, err := io.Copy(destination.writer, bytes.NewReader(content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(destination.name+"/ProgressReader", func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for b.Loop() {
+				pr := NewProgressReader(bytes.NewReader(content), uint64(len(content)), "Upload", io.Discard, ProgressModeNone)
+				if _, err := io.Copy(destination.writer, pr); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(destination.name+"/ProgressWriter", func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for b.Loop() {
+				pw := NewProgressWriter(destination.writer, uint64(len(content)), "Download", io.Discard, ProgressModeNone)
+				if _, err := io.Copy(pw, bytes.NewReader(content)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
 // TestProgressWriterWriteEmpty tests the `Write` method of `ProgressWriter` when writing an empty byte to ensure that
 // it expectedly handles zero-length writes.
 func TestProgressWriterWriteEmpty(t *testing.T) {
 	writer := &strings.Builder{}
-	pw := NewProgressWriter(writer, 10, "Upload", os.Stderr)
+	pw := NewProgressWriter(writer, 10, "Upload", os.Stderr, ProgressModeBar)
 
 	n, err := pw.Write([]byte{})
 
@@ -271,7 +545,7 @@ func TestProgressWriterWriteEmpty(t *testing.T) {
 // it expectedly sets `bytesTransferred` to the total bytes when marked complete.
 func TestProgressReaderComplete(t *testing.T) {
 	reader := strings.NewReader("hello")
-	pr := NewProgressReader(reader, 5, "Download", os.Stderr)
+	pr := NewProgressReader(reader, 5, "Download", os.Stderr, ProgressModeBar)
 
 	pr.tracker.Update(3)
 	pr.Complete()
@@ -298,7 +572,7 @@ func TestProgressTrackerCompleteVariousSizes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pt := NewProgressTracker(tt.bytes, tt.name, os.Stderr)
+			pt := NewProgressTracker(tt.bytes, tt.name, os.Stderr, ProgressModeBar)
 			pt.Update(tt.bytes)
 			pt.Complete()
 
@@ -312,7 +586,7 @@ func TestProgressTrackerCompleteVariousSizes(t *testing.T) {
 // TestProgressTrackerUpdateWithTimeDilation tests the `Update` method of `ProgressTracker` to ensure that
 // it expectedly calls `displayProgress` when enough time has passed since the last update.
 func TestProgressTrackerUpdateWithTimeDilation(t *testing.T) {
-	pt := NewProgressTracker(1000, "Delayed Transfer", os.Stderr)
+	pt := NewProgressTracker(1000, "Delayed Transfer", os.Stderr, ProgressModeBar)
 
 	// The first update should not trigger `displayProgress` since less than `barUpdateInterval` has passed.
 	pt.Update(100)
@@ -331,11 +605,59 @@ func TestProgressTrackerUpdateWithTimeDilation(t *testing.T) {
 // TestProgressTrackerZeroTotalBytes tests the `displayProgress` method of `ProgressTracker` when totalBytes is zero to ensure that
 // it expectedly handles the edge case without errors.
 func TestProgressTrackerZeroTotalBytes(t *testing.T) {
-	pt := NewProgressTracker(0, "Zero Transfer", os.Stderr)
+	pt := NewProgressTracker(0, "Zero Transfer", os.Stderr, ProgressModeBar)
 	pt.displayProgress()
 	// No panic or error should occur.
 }
 
+// TestProgressTrackerUnknownTotal tests `ProgressTracker` with a total of 0 to ensure that
+// a spinner shows the bytes transferred so far, the bar takes over once the total is set, and `Complete` keeps the bytes.
+func TestProgressTrackerUnknownTotal(t *testing.T) {
+	var output bytes.Buffer
+	pt := NewProgressTracker(0, "Streaming dump.sql", &output, ProgressModeBar)
+	pt.displayProgress()
+	if output.Len() != 0 {
+		t.Fatalf("expected nothing displayed before the first byte, got %q", output.String())
+	}
+
+	for i, frame := range []string{"|", "/", "-", "\\", "|"} {
+		output.Reset()
+		pt.lastUpdate = time.Now().Add(-barUpdateInterval)
+		pt.Update(uint64(i+1) * 1024 * 1024)
+		want := fmt.Sprintf("\rStreaming dump.sql %s %d.0 MB (", frame, i+1)
+		if got := output.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, " elapsed)") || strings.Contains(got, "%") {
+			t.Fatalf("expected a spinner line starting with %q, got %q", want, got)
+		}
+	}
+
+	output.Reset()
+	pt.Complete()
+	lines := strings.Split(output.String(), "\n")
+	if pt.bytesTransferred != 5*1024*1024 || len(lines) != 3 || strings.Contains(output.String(), "%") {
+		t.Fatalf("expected the final spinner line and the completion line without a percentage, got %q", output.String())
+	}
+	if !strings.HasPrefix(lines[1], "Streaming dump.sql completed! 5.0 MB in ") || !strings.HasSuffix(lines[1], "/s)") {
+		t.Fatalf("unexpected completion line: %q", lines[1])
+	}
+
+	// The total becomes known, e.g. once a header arrives.
+	output.Reset()
+	pt = NewProgressTracker(0, "Receiving data.bin", &output, ProgressModePlain)
+	pt.lastUpdate = time.Now().Add(-plainUpdateInterval)
+	pt.Update(1024 * 1024)
+	if got := output.String(); !strings.HasPrefix(got, "Receiving data.bin: 1.0 MB (") {
+		t.Fatalf("unexpected plain line of an unknown total: %q", got)
+	}
+
+	output.Reset()
+	pt.SetTotal(4 * 1024 * 1024)
+	pt.lastUpdate = time.Now().Add(-plainUpdateInterval)
+	pt.Update(2 * 1024 * 1024)
+	if got := output.String(); !strings.HasPrefix(got, "Receiving data.bin: 50% 2.0 MB/4.0 MB (") {
+		t.Fatalf("expected the percentage once the total is known, got %q", got)
+	}
+}
+
 // TestProgressTrackerDisplayProgressVariousSizes tests the `displayProgress` method of `ProgressTracker` with different file sizes to ensure that
 // it expectedly handles various progress display scenarios.
 func TestProgressTrackerDisplayProgressVariousSizes(t *testing.T) {
@@ -353,7 +675,7 @@ func TestProgressTrackerDisplayProgressVariousSizes(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pt := NewProgressTracker(tt.totalBytes, tt.name, os.Stderr)
+			pt := NewProgressTracker(tt.totalBytes, tt.name, os.Stderr, ProgressModeBar)
 			pt.Update(tt.bytesProgress)
 			pt.lastUpdate = time.Now().Add(-500 * time.Millisecond)
 			pt.displayProgress()
@@ -368,7 +690,7 @@ func TestProgressTrackerDisplayProgressVariousSizes(t *testing.T) {
 // TestProgressTrackerUpdateNoDisplayBecauseOfTime tests the `Update` method of `ProgressTracker` to ensure that
 // it expectedly does not call `displayProgress` when not enough time has passed since the last update.
 func TestProgressTrackerUpdateNoDisplayBecauseOfTime(t *testing.T) {
-	pt := NewProgressTracker(1000, "No Display Transfer", os.Stderr)
+	pt := NewProgressTracker(1000, "No Display Transfer", os.Stderr, ProgressModeBar)
 	pt.Update(100)
 
 	// Intentionally update again immediately, which should not trigger `displayProgress` since less than `barUpdateInterval` has passed.
@@ -382,7 +704,7 @@ func TestProgressTrackerUpdateNoDisplayBecauseOfTime(t *testing.T) {
 // TestProgressTrackerCompleteZeroRate tests the `Complete` method of `ProgressTracker` when transfer time is very short ($rate \approx 0$) to ensure that
 // it expectedly handles the zero-rate scenario.
 func TestProgressTrackerCompleteZeroRate(t *testing.T) {
-	pt := NewProgressTracker(100, "Instant Transfer", os.Stderr)
+	pt := NewProgressTracker(100, "Instant Transfer", os.Stderr, ProgressModeBar)
 	// Intentionally complete the transfer immediately.
 	pt.Complete()
 
@@ -395,7 +717,7 @@ func TestProgressTrackerCompleteZeroRate(t *testing.T) {
 // it expectedly handles the zero-byte read scenario.
 func TestProgressReaderReadWithZeroBytes(t *testing.T) {
 	reader := strings.NewReader("")
-	pr := NewProgressReader(reader, 0, "Empty Download", os.Stderr)
+	pr := NewProgressReader(reader, 0, "Empty Download", os.Stderr, ProgressModeBar)
 
 	buf := make([]byte, 10)
 	n, _ := pr.Read(buf)
@@ -423,7 +745,7 @@ func TestProgressTrackerCalculateRateVariations(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			pt := NewProgressTracker(tt.bytes, tt.name, os.Stderr)
+			pt := NewProgressTracker(tt.bytes, tt.name, os.Stderr, ProgressModeBar)
 			pt.bytesTransferred = tt.bytes
 			pt.startTime = time.Now().Add(-tt.timePassed)
 
@@ -442,7 +764,7 @@ func TestProgressTrackerCalculateRateVariations(t *testing.T) {
 // TestProgressTrackerCalculateRateExactlyZero tests the `calculateRate` method of `ProgressTracker` when duration is exactly zero to ensure that
 // it expectedly returns a rate of zero.
 func TestProgressTrackerCalculateRateExactlyZero(t *testing.T) {
-	pt := NewProgressTracker(1000, "Exactly Zero", os.Stderr)
+	pt := NewProgressTracker(1000, "Exactly Zero", os.Stderr, ProgressModeBar)
 	pt.bytesTransferred = 500
 	// Set `startTime` to `now + 1` second to simulate zero or negative duration.
 	futureTime := time.Now().Add(time.Second)
@@ -460,7 +782,7 @@ func TestProgressTrackerCalculateRateExactlyZero(t *testing.T) {
 // it expectedly sets `bytesTransferred` to the total bytes when marked complete.
 func TestProgressWriterComplete(t *testing.T) {
 	writer := &strings.Builder{}
-	pw := NewProgressWriter(writer, 5, "Upload", os.Stderr)
+	pw := NewProgressWriter(writer, 5, "Upload", os.Stderr, ProgressModeBar)
 
 	pw.tracker.Update(3)
 	pw.Complete()
@@ -469,3 +791,382 @@ func TestProgressWriterComplete(t *testing.T) {
 		t.Errorf("Expected bytesTransferred to be 5 after Complete(), got %d", pw.tracker.bytesTransferred)
 	}
 }
+
+// TestAggregateProgressAcrossFiles tests `AggregateProgress` to ensure that
+// bytes are accumulated across several files and the overall percentage is reported correctly.
+func TestAggregateProgressAcrossFiles(t *testing.T) {
+	var output bytes.Buffer
+	ap := NewAggregateProgress(800, 4, "Directory", &output, ProgressModeBar)
+	ap.barUpdateInterval = 0
+
+	files := []string{strings.Repeat("a", 100), strings.Repeat("b", 200), strings.Repeat("c", 300), strings.Repeat("d", 200)}
+	expected := []float64{12.5, 37.5, 75, 100}
+	for i, content := range files {
+		if _, err := io.Copy(io.Discard, ap.Reader(strings.NewReader(content))); err != nil {
+			t.Fatalf("failed to read file %d: %v", i, err)
+		}
+		if got := ap.Percentage(); got != expected[i] {
+			t.Fatalf("expected %.1f%% after file %d is read, got %.1f%%", expected[i], i, got)
+		}
+		ap.FileDone(uint64(len(content)))
+		if got := ap.Percentage(); got != expected[i] {
+			t.Fatalf("expected %.1f%% after file %d is done, got %.1f%%", expected[i], i, got)
+		}
+	}
+
+	if !strings.Contains(output.String(), "Directory") || !strings.Contains(output.String(), "37.5% (2/4 files") {
+		t.Fatalf("expected the overall progress line in the output, got %q", output.String())
+	}
+	if eta, ok := ap.ETA(); !ok || eta != 0 {
+		t.Fatalf("expected a zero ETA once everything is transferred, got %v (%v)", eta, ok)
+	}
+
+	ap.Complete()
+	if !strings.Contains(output.String(), "4/4 files done") {
+		t.Fatalf("expected the completion line in the output, got %q", output.String())
+	}
+}
+
+// TestProgressReaderWithAggregate tests `WithAggregate` to ensure that the bytes copied by a progress reader
+// are added to the overall progress, also through the fast path of a file copied to a file.
+func TestProgressReaderWithAggregate(t *testing.T) {
+	dir := t.TempDir()
+	content := strings.Repeat("a", 100)
+	if err := os.WriteFile(filepath.Join(dir, "source"), []byte(content), 0644); err != nil {
+		t.Fatalf("failed to create the source file: %v", err)
+	}
+	source, err := os.Open(filepath.Join(dir, "source"))
+	if err != nil {
+		t.Fatalf("failed to open the source file: %v", err)
+	}
+	defer source.Close()
+	destination, err := os.Create(filepath.Join(dir, "destination"))
+	if err != nil {
+		t.Fatalf("failed to create the destination file: %v", err)
+	}
+	defer destination.Close()
+
+	ap := NewAggregateProgress(400, 2, "Directory", io.Discard, ProgressModeNone)
+	pr := NewProgressReader(source, uint64(len(content)), "Upload", io.Discard, ProgressModeNone, WithAggregate(ap))
+	if n, err := io.Copy(destination, pr); err != nil || n != int64(len(content)) {
+		t.Fatalf("expected %d bytes copied, got %d (%v)", len(content), n, err)
+	}
+	if got := ap.Percentage(); got != 25 {
+		t.Fatalf("expected 25%% of the overall progress, got %.1f%%", got)
+	}
+
+	// Completing the reader does not count the file twice once it is done.
+	pr.Complete()
+	ap.FileDone(uint64(len(content)))
+	if got := ap.Percentage(); got != 25 {
+		t.Fatalf("expected 25%% once the file is done, got %.1f%%", got)
+	}
+}
+
+// TestAggregateProgressOutcomes tests `AggregateProgress` to ensure that
+// the bar shows the sizes, the rate, and the file in flight, and the final line counts the files by outcome.
+func TestAggregateProgressOutcomes(t *testing.T) {
+	var output bytes.Buffer
+	ap := NewAggregateProgress(4*1024*1024*1024, 4, "Directory", &output, ProgressModeBar)
+	ap.startTime = time.Now().Add(-time.Minute)
+
+	ap.StartFile("sub/a.iso")
+	ap.Add(512 * 1024 * 1024)
+	ap.displayProgress()
+	bar := strings.TrimPrefix(output.String(), "\r")
+	if !strings.HasPrefix(bar, "Directory [==="+strings.Repeat("-", 27)+"] 12.5% (0/4 files, 0.5/4.0 GB, 8.53 MB/s, ETA ") ||
+		!strings.HasSuffix(bar, ") sub/a.iso") {
+		t.Fatalf("unexpected progress bar: %q", bar)
+	}
+
+	// The line without a file in flight is padded over the end of the previous one.
+	output.Reset()
+	ap.FileDone(1024 * 1024 * 1024)
+	if done := strings.TrimPrefix(output.String(), "\r"); len(done) != len(bar) || strings.Contains(done, "a.iso") {
+		t.Fatalf("expected the line to be padded to the previous one, got %q after %q", done, bar)
+	}
+
+	ap.FileFailed(1024 * 1024 * 1024)
+	ap.FileSkipped(1024 * 1024 * 1024)
+	output.Reset()
+	ap.Complete()
+	if got := output.String(); !strings.Contains(got, "\nDirectory: 3/4 files done in ") ||
+		!strings.HasSuffix(got, " (1 completed, 1 failed, 1 skipped)\n") {
+		t.Fatalf("unexpected completion line: %q", got)
+	}
+}
+
+// TestAggregateProgressPartialFile tests `AggregateProgress` to ensure that
+// a partially transferred file counts toward the percentage until it restarts, and is settled at its full size once done.
+func TestAggregateProgressPartialFile(t *testing.T) {
+	ap := NewAggregateProgress(1000, 2, "Directory", io.Discard, ProgressModeBar)
+
+	if _, ok := ap.ETA(); ok {
+		t.Fatal("expected no ETA before any bytes are transferred")
+	}
+
+	ap.Add(250)
+	if got := ap.Percentage(); got != 25 {
+		t.Fatalf("expected 25%% mid-file, got %.1f%%", got)
+	}
+	if _, ok := ap.ETA(); !ok {
+		t.Fatal("expected an ETA once bytes are transferred")
+	}
+
+	// A file sent again from its start drops the bytes sent before.
+	ap.RestartFile()
+	if got := ap.Percentage(); got != 0 {
+		t.Fatalf("expected 0%% once the file restarts, got %.1f%%", got)
+	}
+	ap.Add(250)
+
+	// A failed file is settled at its full size so that the percentage still reaches 100%.
+	ap.FileDone(500)
+	if got := ap.Percentage(); got != 50 {
+		t.Fatalf("expected 50%% after the first file, got %.1f%%", got)
+	}
+}
+
+// TestAggregateProgressEmpty tests `AggregateProgress` to ensure that
+// a transfer without any bytes is reported by the number of files done.
+func TestAggregateProgressEmpty(t *testing.T) {
+	ap := NewAggregateProgress(0, 2, "Directory", io.Discard, ProgressModeBar)
+	ap.FileDone(0)
+	if got := ap.Percentage(); got != 50 {
+		t.Fatalf("expected 50%% after one of two empty files, got %.1f%%", got)
+	}
+}
+
+// TestResolveProgressMode tests `ResolveProgressMode` to ensure that
+// the "auto" mode falls back to plain lines when the output is not a terminal, and other modes are kept.
+func TestResolveProgressMode(t *testing.T) {
+	var output bytes.Buffer
+	tests := []struct {
+		mode     string
+		expected string
+	}{
+		{ProgressModeAuto, ProgressModePlain},
+		{"", ProgressModePlain},
+		{ProgressModeBar, ProgressModeBar},
+		{ProgressModePlain, ProgressModePlain},
+		{ProgressModeNone, ProgressModeNone},
+	}
+	for _, tt := range tests {
+		if got := ResolveProgressMode(tt.mode, &output); got != tt.expected {
+			t.Errorf("ResolveProgressMode(%q) = %q; want %q", tt.mode, got, tt.expected)
+		}
+	}
+
+	if ValidProgressMode("fancy") || !ValidProgressMode(ProgressModeNone) {
+		t.Fatal("expected only the known progress modes to be valid")
+	}
+}
+
+// TestProgressTrackerBarOutput tests `ProgressTracker` in the bar mode to ensure that
+// the bar and the completion line are written to the writer given to `NewProgressTracker`.
+func TestProgressTrackerBarOutput(t *testing.T) {
+	var output bytes.Buffer
+	pt := NewProgressTracker(4*1024*1024, "Uploading data.bin", &output, ProgressModeBar)
+	pt.startTime = time.Now().Add(-time.Second)
+
+	pt.lastUpdate = time.Now().Add(-barUpdateInterval)
+	pt.Update(1024 * 1024)
+	bar := output.String()
+	if !strings.HasPrefix(bar, "\rUploading data.bin [=======-----------------------] 25.0% (1.0/4.0 MB, ") ||
+		!strings.Contains(bar, "/s, 1s elapsed, ETA ") {
+		t.Fatalf("unexpected progress bar: %q", bar)
+	}
+
+	output.Reset()
+	pt.Complete()
+	lines := strings.Split(output.String(), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "100.0% (4.0/4.0 MB, ") {
+		t.Fatalf("expected the final bar followed by the completion line, got %q", output.String())
+	}
+	if !strings.HasPrefix(lines[1], "Uploading data.bin completed! 4.0 MB in ") || !strings.HasSuffix(lines[1], " MB/s)") {
+		t.Fatalf("unexpected completion line: %q", lines[1])
+	}
+}
+
+// TestProgressTrackerPlainMode tests `ProgressTracker` in the plain mode to ensure that
+// progress is written as newline-terminated lines without carriage returns.
+func TestProgressTrackerPlainMode(t *testing.T) {
+	var output bytes.Buffer
+	pt := NewProgressTracker(3*1024*1024*1024, "Uploading big.iso", &output, ProgressModeAuto)
+	if pt.barUpdateInterval != plainUpdateInterval {
+		t.Fatalf("expected the plain update interval, got %v", pt.barUpdateInterval)
+	}
+
+	pt.lastUpdate = time.Now().Add(-plainUpdateInterval)
+	pt.Update(1288490188) // 1.2 GB.
+	pt.Complete()
+
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a progress line and a completion line, got %q", output.String())
+	}
+	if !strings.HasPrefix(lines[0], "Uploading big.iso: 40% 1.2 GB/3.0 GB (0s elapsed, ETA ") {
+		t.Fatalf("unexpected progress line: %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "Uploading big.iso completed!") {
+		t.Fatalf("unexpected completion line: %q", lines[1])
+	}
+	if strings.Contains(output.String(), "\r") {
+		t.Fatalf("expected no carriage returns in the plain mode, got %q", output.String())
+	}
+}
+
+// TestProgressNoneMode tests the progress trackers in the none mode to ensure that nothing is written.
+func TestProgressNoneMode(t *testing.T) {
+	var output bytes.Buffer
+
+	pr := NewProgressReader(strings.NewReader("hello"), 5, "Download", &output, ProgressModeNone)
+	pr.tracker.lastUpdate = time.Now().Add(-time.Second)
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pr.Complete()
+
+	ap := NewAggregateProgress(5, 1, "Directory", &output, ProgressModeNone)
+	ap.Add(5)
+	ap.FileDone(5)
+	ap.Complete()
+
+	if output.Len() != 0 {
+		t.Fatalf("expected no progress output, got %q", output.String())
+	}
+}
+
+// TestAggregateProgressPlainModeThrottled tests `AggregateProgress` in the plain mode to ensure that
+// finishing many files in quick succession does not print a line per file.
+func TestAggregateProgressPlainModeThrottled(t *testing.T) {
+	var output bytes.Buffer
+	ap := NewAggregateProgress(100, 100, "Directory", &output, ProgressModePlain)
+	for range 100 {
+		ap.Add(1)
+		ap.FileDone(1)
+	}
+	if output.Len() != 0 {
+		t.Fatalf("expected no lines within the update interval, got %q", output.String())
+	}
+
+	ap.Complete()
+	if got := output.String(); !strings.HasPrefix(got, "Directory: 100/100 files done in ") || strings.Count(got, "\n") != 1 {
+		t.Fatalf("expected a single completion line, got %q", got)
+	}
+}
+
+// A progressCall is a call of a `ProgressFunc` recorded by a test.
+type progressCall struct {
+	transferred, total int64
+	rate               float64
+}
+
+// TestProgressTrackerCallbackCadence tests `WithCallback` to ensure that
+// the callback is called at most once per interval while the transfer runs, instead of writing to the writer.
+func TestProgressTrackerCallbackCadence(t *testing.T) {
+	var output bytes.Buffer
+	var calls []progressCall
+	interval := 20 * time.Millisecond
+	pt := NewProgressTracker(1000, "Callback", &output, ProgressModeBar, WithInterval(interval),
+		WithCallback(func(transferred, total int64, rate float64) {
+			calls = append(calls, progressCall{transferred, total, rate})
+		}))
+
+	start := time.Now()
+	for i := range 100 {
+		pt.Update(uint64(i + 1))
+		time.Sleep(time.Millisecond)
+	}
+	elapsed := time.Since(start)
+
+	if len(calls) == 0 || len(calls) > int(elapsed/interval) {
+		t.Fatalf("expected between 1 and %d calls in %v, got %d", int(elapsed/interval), elapsed, len(calls))
+	}
+	for i, call := range calls {
+		if call.total != 1000 || call.transferred < 1 || call.transferred > 100 || (i > 0 && call.transferred <= calls[i-1].transferred) {
+			t.Fatalf("expected increasing progress out of 1000 bytes, got %+v", calls)
+		}
+	}
+	if output.Len() != 0 {
+		t.Fatalf("expected no output with a callback, got %q", output.String())
+	}
+}
+
+// TestProgressTrackerCallbackComplete tests `Complete` with `WithCallback` to ensure that
+// the callback is called once more with the final values.
+func TestProgressTrackerCallbackComplete(t *testing.T) {
+	var calls []progressCall
+	pt := NewProgressTracker(1000, "Callback", nil, ProgressModeNone, WithCallback(func(transferred, total int64, rate float64) {
+		calls = append(calls, progressCall{transferred, total, rate})
+	}))
+	if pt.barUpdateInterval != barUpdateInterval {
+		t.Fatalf("expected the default interval of a callback to be %v, got %v", barUpdateInterval, pt.barUpdateInterval)
+	}
+
+	pt.Update(400)
+	pt.Complete()
+	if len(calls) != 1 || calls[0].transferred != 1000 || calls[0].total != 1000 || calls[0].rate <= 0 {
+		t.Fatalf("expected a single final call with 1000 of 1000 bytes, got %+v", calls)
+	}
+}
+
+// TestProgressReaderWithCallback tests `NewProgressReader` with options to ensure that
+// a reader reports the bytes read to the callback at the configured interval.
+func TestProgressReaderWithCallback(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 10)
+	var calls []progressCall
+	pr := NewProgressReader(iotest.OneByteReader(bytes.NewReader(content)), uint64(len(content)), "Reading", nil, ProgressModeBar,
+		WithInterval(time.Nanosecond), WithCallback(func(transferred, total int64, rate float64) {
+			calls = append(calls, progressCall{transferred, total, rate})
+		}))
+
+	if _, err := io.Copy(io.Discard, pr); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(calls) == 0 || calls[len(calls)-1].transferred != 10 {
+		t.Fatalf("expected the calls to end with the 10 bytes read, got %+v", calls)
+	}
+	pr.Complete()
+	if last := calls[len(calls)-1]; last.transferred != 10 || last.total != 10 {
+		t.Fatalf("expected the final call with 10 of 10 bytes, got %+v", last)
+	}
+}
+
+// TestProgressTrackerETARecentRate tests the `ETA` method of `ProgressTracker` to ensure that
+// the estimate follows the rate over the last `rateWindow` rather than the average rate since a stalled start.
+func TestProgressTrackerETARecentRate(t *testing.T) {
+	pt := NewProgressTracker(100*1024*1024, "Recovered Transfer", io.Discard, ProgressModeBar)
+	if _, ok := pt.ETA(); ok {
+		t.Fatal("expected no ETA before any progress")
+	}
+
+	// The transfer stalled for a minute, then moved 10 MB in the last 10 seconds.
+	now := time.Now()
+	pt.startTime = now.Add(-70 * time.Second)
+	pt.samples = []progressSample{{at: pt.startTime}}
+	for i := 0; i <= 10; i++ {
+		pt.bytesTransferred = uint64(i) * 1024 * 1024
+		pt.sample(now.Add(time.Duration(i-10) * time.Second))
+	}
+	if oldest := pt.samples[0].at; now.Sub(oldest) != rateWindow {
+		t.Fatalf("expected the samples to span the rate window, got %v", now.Sub(oldest))
+	}
+
+	// 90 MB remain at 1 MB/s, whereas the average rate since the start would give more than 10 minutes.
+	eta, ok := pt.ETA()
+	if !ok || eta.Round(time.Second) != 90*time.Second {
+		t.Fatalf("expected an ETA of 1m30s, got %v (%v)", eta, ok)
+	}
+
+	// A stall over the whole window leaves the ETA unknown.
+	pt.samples = []progressSample{{at: now.Add(-rateWindow), bytes: pt.bytesTransferred}}
+	if _, ok := pt.ETA(); ok {
+		t.Fatal("expected no ETA during a stall")
+	}
+
+	pt.Complete()
+	if eta, ok := pt.ETA(); !ok || eta != 0 {
+		t.Fatalf("expected a zero ETA once complete, got %v (%v)", eta, ok)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package protocol
+
+// raceEnabled reports whether the tests run with the race detector, under which `sync.Pool` drops
+// a random share of the returned items, so that allocation counts of pooled buffers are meaningless.
+const raceEnabled = true
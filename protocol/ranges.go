@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// RangeReceivedMessage is the message of the response to a range transfer (`TransferTypeRange`) that is stored
+// while other ranges of the file are still missing. The range completing the file is answered like a whole file
+// (see `TransferReceivedMessage`), once the checksum of the assembled file is verified.
+const RangeReceivedMessage = "range received"
+
+// rangeSize is the size of an encoded byte range: its identifier, offset, and length.
+const rangeSize = 8 + 8 + 8
+
+// ErrInvalidRange is returned for a byte range that does not fit the header carrying it.
+var ErrInvalidRange = errors.New("invalid byte range in the header")
+
+// A ByteRange is the part of a file carried by a range transfer (`TransferTypeRange`), one of several sent in parallel,
+// each on its own connection, and assembled by the server into the file of the header's size and checksum.
+type ByteRange struct {
+	ID     string // Identifier shared by the ranges of the same file, as 16 hex digits (see `NewTransferID`).
+	Offset uint64 // Offset of the range in the file.
+	Length uint64 // Length of the range in bytes, which the content of the transfer carries.
+}
+
+// SplitRanges splits a file of `size` bytes into at most `count` contiguous ranges of the transfer `id`,
+// each of at least `minLength` bytes (except for the only range of a smaller file). The last range takes the remainder.
+func SplitRanges(id string, size uint64, count int, minLength uint64) []ByteRange {
+	count = max(count, 1)
+	if minLength > 0 {
+		count = int(min(uint64(count), max(size/minLength, 1)))
+	}
+	length := size / uint64(count)
+
+	ranges := make([]ByteRange, count)
+	for i := range ranges {
+		ranges[i] = ByteRange{ID: id, Offset: uint64(i) * length, Length: length}
+	}
+	ranges[count-1].Length = size - ranges[count-1].Offset
+	return ranges
+}
+
+// validateRange validates the byte range of a file of `fileSize` bytes: a valid identifier,
+// and a non-empty range within the file.
+func validateRange(byteRange *ByteRange, fileSize uint64) error {
+	if id, err := hex.DecodeString(byteRange.ID); err != nil || len(id) != 8 {
+		return fmt.Errorf("%w: identifier %q is not 16 hex digits", ErrInvalidRange, byteRange.ID)
+	}
+	if byteRange.Length == 0 {
+		return fmt.Errorf("%w: empty range", ErrInvalidRange)
+	}
+	if byteRange.Length > fileSize || byteRange.Offset > fileSize-byteRange.Length {
+		return fmt.Errorf("%w: range of %d bytes at offset %d exceeds the file size %d",
+			ErrInvalidRange, byteRange.Length, byteRange.Offset, fileSize)
+	}
+	return nil
+}
+
+// encodeRange encodes the byte range as its identifier (8 bytes), offset, and length (8 bytes each, big-endian).
+// The range must be valid (see `validateRange`).
+func encodeRange(byteRange *ByteRange) []byte {
+	data, _ := hex.DecodeString(byteRange.ID)
+	data = binary.BigEndian.AppendUint64(data, byteRange.Offset)
+	return binary.BigEndian.AppendUint64(data, byteRange.Length)
+}
+
+// decodeRange decodes the byte range encoded by `encodeRange`.
+func decodeRange(data []byte) *ByteRange {
+	return &ByteRange{
+		ID:     hex.EncodeToString(data[:8]),
+		Offset: binary.BigEndian.Uint64(data[8:]),
+		Length: binary.BigEndian.Uint64(data[16:]),
+	}
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// TestSplitRanges tests that `SplitRanges` covers the file with contiguous ranges,
+// and splits it into fewer ranges than asked for when they would be smaller than the minimum length.
+func TestSplitRanges(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      uint64
+		count     int
+		minLength uint64
+		expected  []uint64
+	}{
+		{"even", 30, 3, 0, []uint64{10, 10, 10}},
+		{"remainder in the last range", 32, 3, 0, []uint64{10, 10, 12}},
+		{"limited by the minimum length", 100, 8, 30, []uint64{33, 33, 34}},
+		{"smaller than the minimum length", 10, 4, 30, []uint64{10}},
+		{"no count", 10, 0, 0, []uint64{10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := SplitRanges("0123456789abcdef", tt.size, tt.count, tt.minLength)
+			var lengths []uint64
+			var offset uint64
+			for _, r := range ranges {
+				if r.Offset != offset || r.ID != "0123456789abcdef" {
+					t.Fatalf("expected a range of the transfer at offset %d, got %+v", offset, r)
+				}
+				offset += r.Length
+				lengths = append(lengths, r.Length)
+			}
+			if !reflect.DeepEqual(lengths, tt.expected) {
+				t.Fatalf("expected the lengths %v, got %v", tt.expected, lengths)
+			}
+		})
+	}
+}
+
+// TestRangeHeaderRoundTrip tests that the byte range of a range transfer header is written and read back.
+func TestRangeHeaderRoundTrip(t *testing.T) {
+	header := &Header{
+		MessageType:  MessageTypeTransfer,
+		FileSize:     1 << 40,
+		FileName:     "huge.bin",
+		Checksum:     CalculateDataChecksum([]byte("content")),
+		TransferType: TransferTypeRange,
+		Range:        &ByteRange{ID: "0123456789abcdef", Offset: 1 << 39, Length: 1 << 38},
+	}
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, header); err != nil {
+		t.Fatalf("WriteHeader returned error: %v", err)
+	}
+	if buf.Len() != encodedHeaderSize(header) {
+		t.Fatalf("expected %d bytes, got %d", encodedHeaderSize(header), buf.Len())
+	}
+	got, err := ReadHeader(&buf)
+	if err != nil {
+		t.Fatalf("ReadHeader returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got.Range, header.Range) {
+		t.Fatalf("expected the range %+v, got %+v", header.Range, got.Range)
+	}
+}
+
+// TestRangeHeaderInvalid tests that headers with a missing, misplaced, or out-of-bounds range are rejected with `ErrInvalidRange`,
+// and that ranges carry neither compression nor extended attributes.
+func TestRangeHeaderInvalid(t *testing.T) {
+	newRangeHeader := func() *Header {
+		return &Header{
+			MessageType:  MessageTypeTransfer,
+			FileSize:     100,
+			FileName:     "file.bin",
+			Checksum:     CalculateDataChecksum([]byte("content")),
+			TransferType: TransferTypeRange,
+			Range:        &ByteRange{ID: "0123456789abcdef", Offset: 50, Length: 50},
+		}
+	}
+	tests := []struct {
+		name   string
+		header func() *Header
+		target error
+	}{
+		{"missing range", func() *Header { h := newRangeHeader(); h.Range = nil; return h }, ErrInvalidRange},
+		{"range of a file transfer", func() *Header { h := newRangeHeader(); h.TransferType = TransferTypeFile; return h }, ErrInvalidRange},
+		{"invalid identifier", func() *Header { h := newRangeHeader(); h.Range.ID = "xyz"; return h }, ErrInvalidRange},
+		{"empty range", func() *Header { h := newRangeHeader(); h.Range.Length = 0; return h }, ErrInvalidRange},
+		{"beyond the file", func() *Header { h := newRangeHeader(); h.Range.Offset = 51; return h }, ErrInvalidRange},
+		{"overflowing offset", func() *Header { h := newRangeHeader(); h.Range.Offset = ^uint64(0) - 10; return h }, ErrInvalidRange},
+		{"range of a query", func() *Header { h := newRangeHeader(); h.MessageType = MessageTypeQuery; return h }, ErrInvalidTransferType},
+		{"compressed range", func() *Header { h := newRangeHeader(); h.Compression = CompressionGzip; return h }, ErrInvalidCompression},
+		{"range with xattrs", func() *Header {
+			h := newRangeHeader()
+			h.Xattrs = []Xattr{{Name: "user.a", Value: []byte("b")}}
+			return h
+		}, ErrInvalidXattrs},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := WriteHeader(&bytes.Buffer{}, tt.header()); !errors.Is(err, tt.target) {
+				t.Fatalf("expected %v, got %v", tt.target, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MaxRateLimitChunkSize is the largest number of bytes drawn from a `RateLimiter` at once (64KB),
+// which bounds how long one reader can hold up the others.
+const MaxRateLimitChunkSize = 64 * 1024
+
+// A RateLimiter is a token bucket shared by concurrent readers (or writers) to cap their aggregate throughput.
+// Draws are scheduled in the order they arrive, each after the ones before it are paid off, so that no reader starves:
+// as long as every draw is at most `ChunkSize` bytes, a reader waits for at most one chunk of every other reader.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64       // Bytes per second.
+	chunkSize int           // Largest number of bytes to draw at once, about a tenth of a second's worth.
+	burst     time.Duration // Time worth of unused tokens that the bucket holds, drawn without waiting.
+	next      time.Time     // Time at which the tokens drawn so far are paid off.
+}
+
+// NewRateLimiter instantiates a rate limiter of the given number of bytes per second, which must be positive.
+func NewRateLimiter(bytesPerSecond uint64) *RateLimiter {
+	chunkSize := int(min(max(bytesPerSecond/10, 1), MaxRateLimitChunkSize))
+	rate := float64(bytesPerSecond)
+	return &RateLimiter{
+		rate:      rate,
+		chunkSize: chunkSize,
+		burst:     time.Duration(float64(chunkSize) / rate * float64(time.Second)),
+	}
+}
+
+// ChunkSize returns the largest number of bytes that a single call to `Wait` should draw.
+func (rl *RateLimiter) ChunkSize() int {
+	return rl.chunkSize
+}
+
+// Wait draws `n` bytes from the bucket and blocks until they are available, or until the context is done.
+func (rl *RateLimiter) Wait(ctx context.Context, n int) error {
+	rl.mu.Lock()
+	now := time.Now()
+	// An idle limiter holds at most one burst of tokens, so that unused time cannot be saved up for later.
+	if earliest := now.Add(-rl.burst); rl.next.Before(earliest) {
+		rl.next = earliest
+	}
+	rl.next = rl.next.Add(time.Duration(float64(n) / rl.rate * float64(time.Second)))
+	delay := rl.next.Sub(now)
+	rl.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
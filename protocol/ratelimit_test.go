@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRateLimiterChunkSize tests `NewRateLimiter` to ensure that
+// the chunk size is a tenth of a second's worth of bytes, within 1 byte and `MaxRateLimitChunkSize`.
+func TestRateLimiterChunkSize(t *testing.T) {
+	tests := []struct {
+		rate     uint64
+		expected int
+	}{
+		{5, 1},
+		{10000, 1000},
+		{1024 * 1024 * 1024, MaxRateLimitChunkSize},
+	}
+	for _, tt := range tests {
+		if got := NewRateLimiter(tt.rate).ChunkSize(); got != tt.expected {
+			t.Errorf("NewRateLimiter(%d).ChunkSize() = %d; want %d", tt.rate, got, tt.expected)
+		}
+	}
+}
+
+// TestRateLimiterWait tests `RateLimiter.Wait` to ensure that
+// concurrent draws are held to the aggregate rate, and that a done context stops the wait.
+func TestRateLimiterWait(t *testing.T) {
+	const rate = 100000
+	limiter := NewRateLimiter(rate)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 3 {
+				if err := limiter.Wait(context.Background(), limiter.ChunkSize()); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 12 chunks of 10000 bytes, of which the first is covered by the burst: at least 1.1 seconds at 100000 bytes per second.
+	if elapsed := time.Since(start); elapsed < 1100*time.Millisecond {
+		t.Fatalf("expected the draws to take at least 1.1s, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, rate); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
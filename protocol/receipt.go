@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// responseReceiptFlag is set in the status byte of a successful response followed by a receipt,
+// so that a response without one keeps the encoding of the responses that predate receipts.
+const responseReceiptFlag = 0x40
+
+// receiptFixedSize is the size of the fields of an encoded receipt before its path:
+// the bytes written (8 bytes), the checksum, and the path length (4 bytes).
+const receiptFixedSize = 8 + ChecksumSize + 4
+
+// ErrInvalidReceipt is returned for a receipt that cannot be carried by a response.
+var ErrInvalidReceipt = errors.New("invalid receipt in the response")
+
+// A Receipt describes a stored transfer, as attached by the server to its successful response
+// (see `WriteReceiptResponse`), so that the client learns where its file went without parsing the message.
+type Receipt struct {
+	Path         string // Slash-separated path of the stored file (or directory of an archive), relative to the destination directory.
+	BytesWritten uint64 // Number of bytes stored.
+	Checksum     []byte // SHA-256 checksum of the stored content, as computed by the server.
+}
+
+// validateReceipt checks that the receipt has a path and a checksum, and fits in a response.
+func validateReceipt(receipt *Receipt) error {
+	if receipt.Path == "" {
+		return fmt.Errorf("%w: the path is empty", ErrInvalidReceipt)
+	}
+	if len(receipt.Checksum) != ChecksumSize {
+		return fmt.Errorf("%w: checksum of %d bytes, expected %d", ErrInvalidReceipt, len(receipt.Checksum), ChecksumSize)
+	}
+	if len(receipt.Path) > MaxResponseMessageLength {
+		return fmt.Errorf("%w: path length %d exceeds the maximum %d", ErrInvalidReceipt, len(receipt.Path), MaxResponseMessageLength)
+	}
+	return nil
+}
+
+// encodeReceipt encodes the receipt as the bytes written (uint64, big-endian), the checksum,
+// and the path with its length (uint32, big-endian).
+func encodeReceipt(receipt *Receipt) []byte {
+	data := make([]byte, 0, receiptFixedSize+len(receipt.Path))
+	data = binary.BigEndian.AppendUint64(data, receipt.BytesWritten)
+	data = append(data, receipt.Checksum...)
+	data = binary.BigEndian.AppendUint32(data, uint32(len(receipt.Path)))
+	return append(data, receipt.Path...)
+}
+
+// decodeReceipt decodes a receipt encoded by `encodeReceipt`. Bytes after the path are ignored,
+// so that fields added to receipts later do not break the readers that predate them.
+func decodeReceipt(data []byte) (*Receipt, error) {
+	if len(data) < receiptFixedSize {
+		return nil, fmt.Errorf("%w: %d bytes, expected at least %d", ErrInvalidReceipt, len(data), receiptFixedSize)
+	}
+	receipt := &Receipt{
+		BytesWritten: binary.BigEndian.Uint64(data),
+		Checksum:     append([]byte(nil), data[8:8+ChecksumSize]...),
+	}
+	pathLength := binary.BigEndian.Uint32(data[8+ChecksumSize:])
+	if uint64(pathLength) > uint64(len(data)-receiptFixedSize) {
+		return nil, fmt.Errorf("%w: path length %d exceeds the %d remaining bytes", ErrInvalidReceipt, pathLength, len(data)-receiptFixedSize)
+	}
+	receipt.Path = string(data[receiptFixedSize : receiptFixedSize+int(pathLength)])
+	if err := validateReceipt(receipt); err != nil {
+		return nil, err
+	}
+	return receipt, nil
+}
@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestReceiptResponseRoundTrip tests that the receipt of a successful response is written and read back with its message,
+// and that `ReadCodedResponse` skips it so that the next response on the stream is read as usual.
+func TestReceiptResponseRoundTrip(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("content"))
+	receipt := &Receipt{Path: "reports/report_3.txt", BytesWritten: 7, Checksum: checksum}
+	var buf bytes.Buffer
+	if err := WriteReceiptResponse(&buf, TransferReceivedMessage(checksum), receipt); err != nil {
+		t.Fatalf("WriteReceiptResponse returned error: %v", err)
+	}
+	if buf.Bytes()[0] != ResponseStatusSuccess|responseReceiptFlag {
+		t.Fatalf("expected the status byte to announce the receipt, got %#x", buf.Bytes()[0])
+	}
+	encoded := bytes.Clone(buf.Bytes())
+
+	status, code, message, got, err := ReadReceiptResponse(&buf)
+	if err != nil {
+		t.Fatalf("ReadReceiptResponse returned error: %v", err)
+	}
+	if status != ResponseStatusSuccess || code != ErrorCodeNone || message != TransferReceivedMessage(checksum) {
+		t.Fatalf("unexpected response: status %d, code %d, message %q", status, code, message)
+	}
+	if got == nil || got.Path != receipt.Path || got.BytesWritten != receipt.BytesWritten || !bytes.Equal(got.Checksum, checksum) {
+		t.Fatalf("expected the receipt %+v, got %+v", receipt, got)
+	}
+
+	stream := bytes.NewBuffer(encoded)
+	if err := WriteResponse(stream, ResponseStatusSuccess, "next"); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+	if _, message, err := ReadResponse(stream); err != nil || message != TransferReceivedMessage(checksum) {
+		t.Fatalf("expected `ReadResponse` to read the message, got %q and error %v", message, err)
+	}
+	if _, message, err := ReadResponse(stream); err != nil || message != "next" {
+		t.Fatalf("expected the receipt to be skipped before the next response, got %q and error %v", message, err)
+	}
+
+	// A response without a receipt, e.g. from a server predating receipts, is read with a nil receipt.
+	if err := WriteResponse(&buf, ResponseStatusSuccess, TransferMessageReceived); err != nil {
+		t.Fatalf("WriteResponse returned error: %v", err)
+	}
+	if _, _, _, got, err := ReadReceiptResponse(&buf); err != nil || got != nil {
+		t.Fatalf("expected no receipt, got %+v and error %v", got, err)
+	}
+}
+
+// TestReceiptIgnoresTrailingFields tests that bytes after the path of a receipt are ignored,
+// so that fields added to receipts later can be read by the readers that predate them.
+func TestReceiptIgnoresTrailingFields(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("content"))
+	data := append(encodeReceipt(&Receipt{Path: "a.txt", BytesWritten: 7, Checksum: checksum}), 1, 2, 3)
+	got, err := decodeReceipt(data)
+	if err != nil || got.Path != "a.txt" || got.BytesWritten != 7 {
+		t.Fatalf("expected the receipt of a.txt, got %+v and error %v", got, err)
+	}
+}
+
+// TestReceiptInvalid tests that invalid receipts are refused on write and on read.
+func TestReceiptInvalid(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("content"))
+	for _, tt := range []struct {
+		name    string
+		receipt *Receipt
+	}{
+		{"nil receipt", nil},
+		{"empty path", &Receipt{Checksum: checksum}},
+		{"short checksum", &Receipt{Path: "a.txt", Checksum: checksum[:4]}},
+	} {
+		var buf bytes.Buffer
+		if err := WriteReceiptResponse(&buf, "", tt.receipt); !errors.Is(err, ErrInvalidReceipt) {
+			t.Errorf("%s: expected ErrInvalidReceipt on write, got %v", tt.name, err)
+		}
+	}
+	if err := writeResponse(&bytes.Buffer{}, ResponseStatusError, ErrorCodeNone, "", &Receipt{Path: "a.txt", Checksum: checksum}); !errors.Is(err, ErrInvalidReceipt) {
+		t.Errorf("expected ErrInvalidReceipt for an error response with a receipt, got %v", err)
+	}
+
+	truncated := encodeReceipt(&Receipt{Path: "a.txt", Checksum: checksum})
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"error with a receipt", []byte{ResponseStatusError | responseReceiptFlag, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"short receipt", append([]byte{ResponseStatusSuccess | responseReceiptFlag, 0, 0, 0, 0, 0, 0, 0, 4}, 0, 0, 0, 0)},
+		{"path beyond the receipt", append([]byte{ResponseStatusSuccess | responseReceiptFlag, 0, 0, 0, 0, 0, 0, 0, byte(len(truncated) - 1)},
+			truncated[:len(truncated)-1]...)},
+	} {
+		if _, _, _, _, err := ReadReceiptResponse(bytes.NewReader(tt.data)); !errors.Is(err, ErrInvalidReceipt) {
+			t.Errorf("%s: expected ErrInvalidReceipt, got %v", tt.name, err)
+		}
+	}
+}
@@ -2,36 +2,159 @@ package protocol
 
 import (
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 // Constants for response status.
 const (
-	ResponseStatusSuccess = 0
-	ResponseStatusError   = 1
+	ResponseStatusSuccess    = 0 // The request was carried out.
+	ResponseStatusError      = 1 // The request failed.
+	ResponseStatusSkipped    = 2 // The request was not carried out by the choice of the server (e.g. the "skip" strategy).
+	ResponseStatusRetryLater = 3 // The request was refused for now (e.g. by a server shutting down) and can be sent again later.
+	ResponseStatusExists     = 4 // The server already has the file of a sync query.
 )
 
+// Error codes of the responses, which tell the client why a request failed without matching the text of the message.
+// `ErrorCodeNone` is the code of every response that predates error codes, and of the responses without a specific reason.
+const (
+	ErrorCodeNone              = 0 // No specific reason.
+	ErrorCodeFileTooLarge      = 1 // The file exceeds the maximum file size of the server.
+	ErrorCodeQuotaExceeded     = 2 // The transfer would exceed the maximum directory size of the server.
+	ErrorCodeTraversalRejected = 3 // The path is absolute, escapes the destination directory, or is otherwise refused.
+	ErrorCodeChecksumMismatch  = 4 // The content does not match its checksum.
+	ErrorCodeConflictSkip      = 5 // The file already exists and the server skips existing files.
+	ErrorCodeAuthFailed        = 6 // The client is not allowed to make the request.
+	ErrorCodeShuttingDown      = 7 // The server is shutting down.
+	ErrorCodeServerFull        = 8 // The destination volume of the server is nearly full.
+	ErrorCodeServerBusy        = 9 // The server has no memory left for the request.
+)
+
+// maxErrorCode is the highest known error code.
+const maxErrorCode = ErrorCodeServerBusy
+
+// responseCodeFlag is set in the status byte of a response followed by an error code,
+// so that a response without an error code keeps the encoding of the responses that predate error codes.
+const responseCodeFlag = 0x80
+
 // Custom error types for response errors.
 var (
 	ErrInvalidResponseStatus = errors.New("invalid response status")
 	ErrInvalidMessageLength  = errors.New("invalid message length in the response")
+	ErrInvalidErrorCode      = errors.New("invalid error code in the response")
 )
 
+// Messages of the responses to verification, query, and deletion requests, shared by the client and the server.
+const (
+	VerifyMessageMatch    = "checksum verified" // The file exists and its checksum matches.
+	VerifyMessageMismatch = "checksum mismatch" // The file exists but its content differs.
+	VerifyMessageNotFound = "file not found"    // The file does not exist on the server (also answered to deletions).
+	QueryMessageTooLarge  = "too large to hash" // The file exists with the same size but is too large to be hashed for a query.
+	DeleteMessageDeleted  = "deleted"           // The file (or directory tree) was deleted.
+)
+
+// ShutdownMessage is the message of the error response to a request refused, or a transfer interrupted, by a server shutting down.
+// The request can be sent again once the server is back.
+const ShutdownMessage = "server shutting down, retry later"
+
+// ServerFullMessage is the message of the error response to a transfer refused by a server whose destination volume
+// is nearly full. The transfer can be sent again once space is freed on the server.
+const ServerFullMessage = "server full, retry later"
+
+// ServerBusyMessage is the message of the error response to a connection or request refused by a server that has used up
+// its memory budget. The request can be sent again once other connections finish.
+const ServerBusyMessage = "server busy, retry later"
+
+// TransferMessageReceived is the message of the response to a stored transfer,
+// followed by the checksum verified by the server (see `TransferReceivedMessage`).
+const TransferMessageReceived = "Transfer received!"
+
+// TransferMessageDiscarded is the message of the response to a transfer verified but not stored by a server in "-discard" mode,
+// followed by the checksum verified by the server (see `TransferDiscardedMessage`). It does not confirm that the server holds the file.
+const TransferMessageDiscarded = "Transfer discarded!"
+
+// transferChecksumPrefix separates `TransferMessageReceived` or `TransferMessageDiscarded` from the hex-encoded checksum.
+const transferChecksumPrefix = " checksum "
+
+// TransferReceivedMessage returns the message of the response to a stored transfer whose content has the given checksum.
+func TransferReceivedMessage(checksum []byte) string {
+	return TransferMessageReceived + transferChecksumPrefix + hex.EncodeToString(checksum)
+}
+
+// TransferDiscardedMessage returns the message of the response to a transfer verified with the given checksum, then discarded.
+func TransferDiscardedMessage(checksum []byte) string {
+	return TransferMessageDiscarded + transferChecksumPrefix + hex.EncodeToString(checksum)
+}
+
+// ParseTransferReceivedChecksum returns the checksum confirmed by the message of the response to a stored transfer.
+// It returns false if the message confirms no checksum (e.g. from a server predating checksums in responses).
+func ParseTransferReceivedChecksum(message string) ([]byte, bool) {
+	return parseTransferChecksum(message, TransferMessageReceived)
+}
+
+// ParseTransferDiscardedChecksum returns the checksum verified by a server in "-discard" mode, from the message of its response.
+func ParseTransferDiscardedChecksum(message string) ([]byte, bool) {
+	return parseTransferChecksum(message, TransferMessageDiscarded)
+}
+
+// parseTransferChecksum returns the checksum that follows the message `outcome` in the message of a transfer response.
+func parseTransferChecksum(message, outcome string) ([]byte, bool) {
+	encoded, ok := strings.CutPrefix(message, outcome+transferChecksumPrefix)
+	if !ok {
+		return nil, false
+	}
+	checksum, err := hex.DecodeString(encoded)
+	if err != nil || len(checksum) != ChecksumSize {
+		return nil, false
+	}
+	return checksum, true
+}
+
 // MaxResponseMessageLength is the maximum allowed response message length (64KB).
 const MaxResponseMessageLength = 64 * 1024
 
-// WriteResponse writes a structured response to the given writer.
-// Format: [1 byte for status] [4 bytes for message length] [variable length for message].
+// WriteResponse writes a structured response without an error code to the given writer (see `WriteCodedResponse`).
 func WriteResponse(w io.Writer, status uint8, message string) error {
+	return WriteCodedResponse(w, status, ErrorCodeNone, message)
+}
+
+// WriteCodedResponse writes a structured response with an error code to the given writer.
+// Format: [1 byte for status] [2 bytes for error code] [4 bytes for message length] [variable length for message],
+// where the status byte has `responseCodeFlag` set. A response with `ErrorCodeNone` is written without the error code.
+func WriteCodedResponse(w io.Writer, status uint8, code uint16, message string) error {
+	return writeResponse(w, status, code, message, nil)
+}
+
+// WriteReceiptResponse writes a successful response followed by the receipt of a stored transfer to the given writer.
+// Format: the response (see `WriteCodedResponse`), whose status byte has `responseReceiptFlag` set,
+// followed by [4 bytes for receipt length] [variable length for receipt] (see `encodeReceipt`).
+func WriteReceiptResponse(w io.Writer, message string, receipt *Receipt) error {
+	if receipt == nil {
+		return fmt.Errorf("%w: the receipt is nil", ErrInvalidReceipt)
+	}
+	return writeResponse(w, ResponseStatusSuccess, ErrorCodeNone, message, receipt)
+}
+
+// writeResponse writes a response with an error code and, if not nil, a receipt to the given writer.
+func writeResponse(w io.Writer, status uint8, code uint16, message string, receipt *Receipt) error {
 	if w == nil {
 		return fmt.Errorf("writer is nil")
 	}
 
-	if status != ResponseStatusSuccess && status != ResponseStatusError {
-		return fmt.Errorf("%w: status %d is invalid, expected %d (Success) or %d (Error)",
-			ErrInvalidResponseStatus, status, ResponseStatusSuccess, ResponseStatusError)
+	if err := validateResponseStatus(status, code); err != nil {
+		return err
+	}
+	if receipt != nil {
+		if err := validateReceipt(receipt); err != nil {
+			return err
+		}
+		if status != ResponseStatusSuccess {
+			return fmt.Errorf("%w: only a successful response can have a receipt", ErrInvalidReceipt)
+		}
+		status |= responseReceiptFlag
 	}
 
 	messageBytes := []byte(message)
@@ -42,9 +165,15 @@ func WriteResponse(w io.Writer, status uint8, message string) error {
 			ErrInvalidMessageLength, messageLength, MaxResponseMessageLength)
 	}
 
-	// Write the status byte (1 byte).
-	if _, err := w.Write([]byte{status}); err != nil {
-		return fmt.Errorf("failed to write the response status: %w", err)
+	// Write the status byte (1 byte), followed by the error code (2 bytes, big-endian) if any.
+	if code == ErrorCodeNone {
+		if _, err := w.Write([]byte{status}); err != nil {
+			return fmt.Errorf("failed to write the response status: %w", err)
+		}
+	} else {
+		if _, err := w.Write([]byte{status | responseCodeFlag, byte(code >> 8), byte(code)}); err != nil {
+			return fmt.Errorf("failed to write the response status: %w", err)
+		}
 	}
 
 	// Write the message length (4 bytes, big-endian).
@@ -59,14 +188,71 @@ func WriteResponse(w io.Writer, status uint8, message string) error {
 		}
 	}
 
+	// Write the receipt length (4 bytes, big-endian) and the receipt (variable length).
+	if receipt != nil {
+		receiptBytes := encodeReceipt(receipt)
+		if err := binary.Write(w, binary.BigEndian, uint32(len(receiptBytes))); err != nil {
+			return fmt.Errorf("failed to write the receipt length: %w", err)
+		}
+		if _, err := w.Write(receiptBytes); err != nil {
+			return fmt.Errorf("failed to write the receipt: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// ReadResponse reads a structured response from the given reader.
-// Format: [1 byte for status] [4 bytes for message length] [variable length for message].
+// ReadResponse reads a structured response from the given reader, ignoring its error code (see `ReadCodedResponse`).
 func ReadResponse(r io.Reader) (status uint8, message string, err error) {
+	status, _, message, err = ReadCodedResponse(r)
+	return status, message, err
+}
+
+// ReadCodedResponse reads a structured response, with or without an error code, from the given reader.
+// The error code of a response without one is `ErrorCodeNone`. See `WriteCodedResponse` for the format.
+// The receipt of a response with one is read and dropped (see `ReadReceiptResponse`).
+func ReadCodedResponse(r io.Reader) (status uint8, code uint16, message string, err error) {
+	status, code, message, _, err = ReadReceiptResponse(r)
+	return status, code, message, err
+}
+
+// ReadReceiptResponse reads a structured response, with or without an error code or a receipt, from the given reader.
+// The receipt of a response without one, e.g. from a server predating receipts, is nil. See `WriteReceiptResponse` for the format.
+func ReadReceiptResponse(r io.Reader) (status uint8, code uint16, message string, receipt *Receipt, err error) {
+	status, code, message, hasReceipt, err := readResponse(r)
+	if err != nil || !hasReceipt {
+		return status, code, message, nil, err
+	}
+
+	// Read the receipt length (4 bytes, big-endian) and the receipt (variable length).
+	var receiptLength uint32
+	if err := binary.Read(r, binary.BigEndian, &receiptLength); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, 0, "", nil, fmt.Errorf("unexpected end of stream while reading the receipt length: %w", err)
+		}
+		return 0, 0, "", nil, fmt.Errorf("failed to read the receipt length: %w", err)
+	}
+	if receiptLength > receiptFixedSize+MaxResponseMessageLength {
+		return 0, 0, "", nil, fmt.Errorf("%w: receipt length %d exceeds the maximum %d",
+			ErrInvalidReceipt, receiptLength, receiptFixedSize+MaxResponseMessageLength)
+	}
+	receiptBytes := make([]byte, receiptLength)
+	if _, err := io.ReadFull(r, receiptBytes); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, 0, "", nil, fmt.Errorf("unexpected end of stream while reading the receipt: %w", err)
+		}
+		return 0, 0, "", nil, fmt.Errorf("failed to read the receipt: %w", err)
+	}
+	if receipt, err = decodeReceipt(receiptBytes); err != nil {
+		return 0, 0, "", nil, err
+	}
+	return status, code, message, receipt, nil
+}
+
+// readResponse reads a structured response up to its message, and reports whether a receipt follows it.
+func readResponse(r io.Reader) (status uint8, code uint16, message string, hasReceipt bool, err error) {
 	if r == nil {
-		return 0, "", fmt.Errorf("reader is nil")
+		return 0, 0, "", false, fmt.Errorf("reader is nil")
 	}
 
 	// Read the status byte (1 byte).
@@ -74,29 +260,47 @@ func ReadResponse(r io.Reader) (status uint8, message string, err error) {
 	_, err = io.ReadFull(r, statusBytes)
 	if err != nil {
 		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-			return 0, "", fmt.Errorf("unexpected end of stream while reading the response status: %w", err)
+			return 0, 0, "", false, fmt.Errorf("unexpected end of stream while reading the response status: %w", err)
 		}
-		return 0, "", fmt.Errorf("failed to read the response status: %w", err)
+		return 0, 0, "", false, fmt.Errorf("failed to read the response status: %w", err)
+	}
+	status = statusBytes[0] &^ (responseCodeFlag | responseReceiptFlag)
+	if err := validateResponseStatus(status, ErrorCodeNone); err != nil {
+		return 0, 0, "", false, err
+	}
+	hasReceipt = statusBytes[0]&responseReceiptFlag != 0
+	if hasReceipt && status != ResponseStatusSuccess {
+		return 0, 0, "", false, fmt.Errorf("%w: only a successful response can have a receipt", ErrInvalidReceipt)
 	}
-	status = statusBytes[0]
 
-	if status != ResponseStatusSuccess && status != ResponseStatusError {
-		return 0, "", fmt.Errorf("%w: status %d is invalid, expected %d (Success) or %d (Error)",
-			ErrInvalidResponseStatus, status, ResponseStatusSuccess, ResponseStatusError)
+	// Read the error code (2 bytes, big-endian), if the status byte announces one.
+	if statusBytes[0]&responseCodeFlag != 0 {
+		if err = binary.Read(r, binary.BigEndian, &code); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return 0, 0, "", false, fmt.Errorf("unexpected end of stream while reading the error code: %w", err)
+			}
+			return 0, 0, "", false, fmt.Errorf("failed to read the error code: %w", err)
+		}
+		if code == ErrorCodeNone {
+			return 0, 0, "", false, fmt.Errorf("%w: a response with an error code cannot have code %d", ErrInvalidErrorCode, ErrorCodeNone)
+		}
+		if err := validateResponseStatus(status, code); err != nil {
+			return 0, 0, "", false, err
+		}
 	}
 
 	// Read the message length (4 bytes, big-endian).
 	var messageLength uint32
 	if err = binary.Read(r, binary.BigEndian, &messageLength); err != nil {
 		if errors.Is(err, io.EOF) {
-			return 0, "", fmt.Errorf("unexpected end of stream while reading the message length: %w", err)
+			return 0, 0, "", false, fmt.Errorf("unexpected end of stream while reading the message length: %w", err)
 		}
-		return 0, "", fmt.Errorf("failed to read the message length: %w", err)
+		return 0, 0, "", false, fmt.Errorf("failed to read the message length: %w", err)
 	}
 
 	// Validate message length to prevent excessive memory allocation.
 	if messageLength > MaxResponseMessageLength {
-		return 0, "", fmt.Errorf("%w: message length %d exceeds the maximum %d",
+		return 0, 0, "", false, fmt.Errorf("%w: message length %d exceeds the maximum %d",
 			ErrInvalidMessageLength, messageLength, MaxResponseMessageLength)
 	}
 
@@ -106,13 +310,30 @@ func ReadResponse(r io.Reader) (status uint8, message string, err error) {
 		_, err = io.ReadFull(r, messageBytes)
 		if err != nil {
 			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-				return 0, "", fmt.Errorf("unexpected end of stream while reading the message: got %d bytes, expected %d: %w",
+				return 0, 0, "", false, fmt.Errorf("unexpected end of stream while reading the message: got %d bytes, expected %d: %w",
 					len(messageBytes), messageLength, err)
 			}
-			return 0, "", fmt.Errorf("failed to read the message: %w", err)
+			return 0, 0, "", false, fmt.Errorf("failed to read the message: %w", err)
 		}
 	}
 	message = string(messageBytes)
 
-	return status, message, nil
+	return status, code, message, hasReceipt, nil
+}
+
+// validateResponseStatus checks that the status and the error code of a response are known,
+// and that a successful response has no error code.
+func validateResponseStatus(status uint8, code uint16) error {
+	if status > ResponseStatusExists {
+		return fmt.Errorf("%w: status %d is invalid, expected %d (Success), %d (Error), %d (Skipped), %d (RetryLater), or %d (Exists)",
+			ErrInvalidResponseStatus, status, ResponseStatusSuccess, ResponseStatusError, ResponseStatusSkipped,
+			ResponseStatusRetryLater, ResponseStatusExists)
+	}
+	if code > maxErrorCode {
+		return fmt.Errorf("%w: code %d is invalid, expected at most %d", ErrInvalidErrorCode, code, maxErrorCode)
+	}
+	if status == ResponseStatusSuccess && code != ErrorCodeNone {
+		return fmt.Errorf("%w: a successful response cannot have code %d", ErrInvalidErrorCode, code)
+	}
+	return nil
 }
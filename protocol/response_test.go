@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"strings"
 	"testing"
@@ -406,3 +407,129 @@ func TestReadResponseCustomErrorOnMessage(t *testing.T) {
 		t.Fatalf("expected 'failed to read the message' error, got: %v", err)
 	}
 }
+
+// TestTransferReceivedMessage tests `TransferReceivedMessage` and `ParseTransferReceivedChecksum` to ensure that
+// the confirmed checksum round-trips, and that messages without a valid checksum confirm none.
+func TestTransferReceivedMessage(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("content"))
+
+	got, ok := ParseTransferReceivedChecksum(TransferReceivedMessage(checksum))
+	if !ok || !bytes.Equal(got, checksum) {
+		t.Fatalf("expected the checksum %x to round-trip, got %x (%v)", checksum, got, ok)
+	}
+
+	for _, message := range []string{
+		TransferMessageReceived,
+		TransferMessageReceived + " checksum abc",
+		TransferMessageReceived + " checksum " + strings.Repeat("zz", ChecksumSize),
+		"File size mismatch",
+	} {
+		if _, ok := ParseTransferReceivedChecksum(message); ok {
+			t.Errorf("expected no checksum confirmed by %q", message)
+		}
+	}
+}
+
+// TestTransferDiscardedMessage tests `TransferDiscardedMessage` and `ParseTransferDiscardedChecksum` to ensure that
+// the verified checksum round-trips, and that a discarded transfer is not confirmed as received.
+func TestTransferDiscardedMessage(t *testing.T) {
+	checksum := CalculateDataChecksum([]byte("content"))
+	message := TransferDiscardedMessage(checksum)
+
+	if got, ok := ParseTransferDiscardedChecksum(message); !ok || !bytes.Equal(got, checksum) {
+		t.Fatalf("expected the checksum %x to round-trip, got %x (%v)", checksum, got, ok)
+	}
+	if got, ok := ParseTransferReceivedChecksum(message); ok {
+		t.Fatalf("expected the discarded transfer not to be confirmed as received, got %x", got)
+	}
+	if got, ok := ParseTransferDiscardedChecksum(TransferReceivedMessage(checksum)); ok {
+		t.Fatalf("expected the received transfer not to be reported as discarded, got %x", got)
+	}
+}
+
+// TestReadWriteCodedResponseRoundTrip tests a round-trip write and read of responses with every status and error code,
+// and that a response without an error code is read as `ErrorCodeNone`.
+func TestReadWriteCodedResponseRoundTrip(t *testing.T) {
+	tests := []struct {
+		status uint8
+		code   uint16
+	}{
+		{ResponseStatusSuccess, ErrorCodeNone},
+		{ResponseStatusError, ErrorCodeNone},
+		{ResponseStatusError, ErrorCodeFileTooLarge},
+		{ResponseStatusError, ErrorCodeQuotaExceeded},
+		{ResponseStatusError, ErrorCodeTraversalRejected},
+		{ResponseStatusError, ErrorCodeChecksumMismatch},
+		{ResponseStatusSkipped, ErrorCodeConflictSkip},
+		{ResponseStatusError, ErrorCodeAuthFailed},
+		{ResponseStatusRetryLater, ErrorCodeShuttingDown},
+		{ResponseStatusRetryLater, ErrorCodeServerFull},
+		{ResponseStatusRetryLater, ErrorCodeServerBusy},
+		{ResponseStatusExists, ErrorCodeNone},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := WriteCodedResponse(&buf, tt.status, tt.code, "message"); err != nil {
+			t.Fatalf("failed to write the response with status %d and code %d: %v", tt.status, tt.code, err)
+		}
+		status, code, message, err := ReadCodedResponse(&buf)
+		if err != nil {
+			t.Fatalf("failed to read the response with status %d and code %d: %v", tt.status, tt.code, err)
+		}
+		if status != tt.status || code != tt.code || message != "message" {
+			t.Fatalf("expected status %d, code %d, and message %q, got %d, %d, and %q", tt.status, tt.code, "message", status, code, message)
+		}
+	}
+}
+
+// TestWriteResponseWithoutCodeEncoding tests `WriteCodedResponse` to ensure that
+// a response without an error code keeps the encoding of the responses that predate error codes.
+func TestWriteResponseWithoutCodeEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteCodedResponse(&buf, ResponseStatusError, ErrorCodeNone, "no"); err != nil {
+		t.Fatalf("failed to write the response: %v", err)
+	}
+	if expected := []byte{ResponseStatusError, 0, 0, 0, 2, 'n', 'o'}; !bytes.Equal(buf.Bytes(), expected) {
+		t.Fatalf("expected the bytes %v, got %v", expected, buf.Bytes())
+	}
+
+	status, message, err := ReadResponse(bytes.NewReader([]byte{ResponseStatusError | responseCodeFlag, 0, ErrorCodeConflictSkip, 0, 0, 0, 2, 'n', 'o'}))
+	if err != nil || status != ResponseStatusError || message != "no" {
+		t.Fatalf("expected `ReadResponse` to skip the error code, got status %d, message %q, and error %v", status, message, err)
+	}
+}
+
+// TestCodedResponseInvalid tests `WriteCodedResponse` and `ReadCodedResponse` to ensure that
+// unknown error codes, successful responses with an error code, and an announced error code of zero are refused.
+func TestCodedResponseInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		status uint8
+		code   uint16
+	}{
+		{"unknown code", ResponseStatusError, maxErrorCode + 1},
+		{"success with a code", ResponseStatusSuccess, ErrorCodeFileTooLarge},
+		{"unknown status", ResponseStatusExists + 1, ErrorCodeNone},
+	} {
+		var buf bytes.Buffer
+		if err := WriteCodedResponse(&buf, tt.status, tt.code, ""); err == nil {
+			t.Errorf("%s: expected an error on write", tt.name)
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"unknown code", []byte{ResponseStatusError | responseCodeFlag, 0xff, 0xff, 0, 0, 0, 0}, ErrInvalidErrorCode},
+		{"success with a code", []byte{ResponseStatusSuccess | responseCodeFlag, 0, ErrorCodeFileTooLarge, 0, 0, 0, 0}, ErrInvalidErrorCode},
+		{"announced code of zero", []byte{ResponseStatusError | responseCodeFlag, 0, 0, 0, 0, 0, 0}, ErrInvalidErrorCode},
+		{"unknown status", []byte{ResponseStatusExists + 1, 0, 0, 0, 0}, ErrInvalidResponseStatus},
+	} {
+		if _, _, _, err := ReadCodedResponse(bytes.NewReader(tt.data)); !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+	}
+}
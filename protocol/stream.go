@@ -0,0 +1,203 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// MaxStreamChunkSize is the maximum size of a single chunk of a streamed transfer (1MB).
+const MaxStreamChunkSize = 1024 * 1024
+
+// Errors for streamed transfers.
+var (
+	ErrInvalidChunkSize = errors.New("invalid chunk size in the stream")
+	ErrStreamTooLarge   = errors.New("stream exceeds the maximum allowed size")
+	ErrStreamClosed     = errors.New("stream is already closed")
+)
+
+// A StreamWriter writes content of unknown size as a streamed transfer (`TransferTypeStream`).
+// Format: a sequence of chunks, each [4 bytes for chunk length] [variable length for chunk data],
+// terminated by a zero-length chunk followed by the SHA-256 checksum of the content (32 bytes).
+// The checksum is calculated incrementally while writing, so the content is read only once.
+type StreamWriter struct {
+	writer  io.Writer // Underlying writer.
+	hash    hash.Hash // Running SHA-256 hash of the content.
+	written uint64    // Number of content bytes written so far.
+	closed  bool      // Whether the end of the stream has been written.
+}
+
+// NewStreamWriter instantiates a new stream writer on top of the given writer.
+func NewStreamWriter(w io.Writer) *StreamWriter {
+	return &StreamWriter{
+		writer: w,
+		hash:   sha256.New(),
+	}
+}
+
+// Write implements the `io.Writer` interface and writes the data as one or more chunks.
+func (sw *StreamWriter) Write(p []byte) (n int, err error) {
+	if sw.closed {
+		return 0, ErrStreamClosed
+	}
+
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxStreamChunkSize {
+			chunk = chunk[:MaxStreamChunkSize]
+		}
+
+		if err := binary.Write(sw.writer, binary.BigEndian, uint32(len(chunk))); err != nil {
+			return n, fmt.Errorf("failed to write the chunk length: %w", err)
+		}
+		if _, err := sw.writer.Write(chunk); err != nil {
+			return n, fmt.Errorf("failed to write the chunk: %w", err)
+		}
+
+		sw.hash.Write(chunk)
+		sw.written += uint64(len(chunk))
+		n += len(chunk)
+		p = p[len(chunk):]
+	}
+
+	return n, nil
+}
+
+// Close writes the end of the stream (a zero-length chunk followed by the checksum).
+// It does not close the underlying writer.
+func (sw *StreamWriter) Close() error {
+	if sw.closed {
+		return ErrStreamClosed
+	}
+	sw.closed = true
+
+	if err := binary.Write(sw.writer, binary.BigEndian, uint32(0)); err != nil {
+		return fmt.Errorf("failed to write the end of the stream: %w", err)
+	}
+	if _, err := sw.writer.Write(sw.hash.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to write the stream checksum: %w", err)
+	}
+
+	return nil
+}
+
+// Checksum returns the SHA-256 checksum of the content written so far.
+func (sw *StreamWriter) Checksum() []byte {
+	return sw.hash.Sum(nil)
+}
+
+// Written returns the number of content bytes written so far.
+func (sw *StreamWriter) Written() uint64 {
+	return sw.written
+}
+
+// A StreamReader reads the content of a streamed transfer written by a `StreamWriter`.
+// It enforces a maximum content size against the bytes actually received, and verifies the trailing checksum
+// against the checksum calculated while reading, returning `io.EOF` only if they match.
+type StreamReader struct {
+	reader    io.Reader // Underlying reader.
+	hash      hash.Hash // Running SHA-256 hash of the content.
+	maxSize   uint64    // Maximum allowed content size in bytes (0 for no limit).
+	read      uint64    // Number of content bytes read so far.
+	remaining uint32    // Bytes remaining in the current chunk.
+	err       error     // Sticky error (including `io.EOF` at the verified end of the stream).
+}
+
+// NewStreamReader instantiates a new stream reader on top of the given reader.
+func NewStreamReader(r io.Reader, maxSize uint64) *StreamReader {
+	return &StreamReader{
+		reader:  r,
+		hash:    sha256.New(),
+		maxSize: maxSize,
+	}
+}
+
+// Read implements the `io.Reader` interface and returns the content of the stream.
+func (sr *StreamReader) Read(p []byte) (n int, err error) {
+	if sr.err != nil {
+		return 0, sr.err
+	}
+
+	if sr.remaining == 0 {
+		if sr.err = sr.nextChunk(); sr.err != nil {
+			return 0, sr.err
+		}
+	}
+
+	if len(p) > int(sr.remaining) {
+		p = p[:sr.remaining]
+	}
+	n, err = sr.reader.Read(p)
+	if n > 0 {
+		sr.hash.Write(p[:n])
+		sr.read += uint64(n)
+		sr.remaining -= uint32(n)
+	}
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		sr.err = fmt.Errorf("failed to read the chunk: %w", err)
+		return n, sr.err
+	}
+
+	return n, nil
+}
+
+// nextChunk reads the length of the next chunk, or the checksum at the end of the stream.
+func (sr *StreamReader) nextChunk() error {
+	var length uint32
+	if err := binary.Read(sr.reader, binary.BigEndian, &length); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read the chunk length: %w", err)
+	}
+
+	if length == 0 {
+		return sr.verifyChecksum()
+	}
+
+	if length > MaxStreamChunkSize {
+		return fmt.Errorf("%w: chunk length %d exceeds the maximum %d", ErrInvalidChunkSize, length, MaxStreamChunkSize)
+	}
+	if sr.maxSize > 0 && sr.read+uint64(length) > sr.maxSize {
+		return fmt.Errorf("%w: more than %d bytes received", ErrStreamTooLarge, sr.maxSize)
+	}
+
+	sr.remaining = length
+	return nil
+}
+
+// verifyChecksum reads the trailing checksum and compares it with the calculated one.
+// It returns `io.EOF` if they match.
+func (sr *StreamReader) verifyChecksum() error {
+	expected := make([]byte, ChecksumSize)
+	if _, err := io.ReadFull(sr.reader, expected); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read the stream checksum: %w", err)
+	}
+
+	actual := sr.hash.Sum(nil)
+	if !bytes.Equal(actual, expected) {
+		return fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, expected, actual)
+	}
+
+	return io.EOF
+}
+
+// Checksum returns the SHA-256 checksum of the content read so far.
+func (sr *StreamReader) Checksum() []byte {
+	return sr.hash.Sum(nil)
+}
+
+// BytesRead returns the number of content bytes read so far.
+func (sr *StreamReader) BytesRead() uint64 {
+	return sr.read
+}
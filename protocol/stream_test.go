@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// TestStreamRoundTrip tests `StreamWriter` and `StreamReader` to ensure that
+// content spanning several chunks survives a round-trip with its checksum.
+func TestStreamRoundTrip(t *testing.T) {
+	data := make([]byte, 2*MaxStreamChunkSize+123)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatalf("failed to generate data: %v", err)
+	}
+
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf)
+	if _, err := io.Copy(writer, bytes.NewReader(data)); err != nil {
+		t.Fatalf("failed to write the stream: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+	if writer.Written() != uint64(len(data)) || !bytes.Equal(writer.Checksum(), CalculateDataChecksum(data)) {
+		t.Fatalf("unexpected writer state: %d bytes, checksum %x", writer.Written(), writer.Checksum())
+	}
+
+	reader := NewStreamReader(&buf, 0)
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read the stream: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("expected the read content to match the written content")
+	}
+	if reader.BytesRead() != uint64(len(data)) || !bytes.Equal(reader.Checksum(), CalculateDataChecksum(data)) {
+		t.Fatalf("unexpected reader state: %d bytes, checksum %x", reader.BytesRead(), reader.Checksum())
+	}
+}
+
+// TestStreamEmpty tests that an empty stream round-trips to empty content.
+func TestStreamEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf)
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+	if _, err := writer.Write([]byte("late")); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("expected ErrStreamClosed after closing, got %v", err)
+	}
+
+	got, err := io.ReadAll(NewStreamReader(&buf, 0))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("expected empty content without an error, got %q and %v", got, err)
+	}
+}
+
+// TestStreamReaderMaxSize tests `StreamReader` to ensure that
+// the maximum size is enforced against the bytes actually received.
+func TestStreamReaderMaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf)
+	for i := 0; i < 3; i++ {
+		if _, err := writer.Write([]byte("12345")); err != nil {
+			t.Fatalf("failed to write the stream: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+
+	reader := NewStreamReader(bytes.NewReader(buf.Bytes()), 12)
+	got, err := io.ReadAll(reader)
+	if !errors.Is(err, ErrStreamTooLarge) {
+		t.Fatalf("expected ErrStreamTooLarge, got %v", err)
+	}
+	if len(got) != 10 {
+		t.Fatalf("expected the reader to stop before the chunk exceeding the limit, got %d bytes", len(got))
+	}
+
+	if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(buf.Bytes()), 15)); err != nil {
+		t.Fatalf("expected a stream of exactly the maximum size to be accepted, got %v", err)
+	}
+}
+
+// TestStreamReaderChecksumMismatch tests `StreamReader` to ensure that
+// corrupted content is detected against the trailing checksum.
+func TestStreamReaderChecksumMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf)
+	if _, err := writer.Write([]byte("original")); err != nil {
+		t.Fatalf("failed to write the stream: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+
+	corrupted := buf.Bytes()
+	corrupted[4] = '0' // First byte of the chunk data, right after the chunk length.
+
+	if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(corrupted), 0)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+}
+
+// TestStreamReaderMalformed tests `StreamReader` to ensure that
+// truncated streams and oversized chunk lengths are rejected.
+func TestStreamReaderMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	writer := NewStreamWriter(&buf)
+	if _, err := writer.Write([]byte("content")); err != nil {
+		t.Fatalf("failed to write the stream: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+	full := buf.Bytes()
+
+	for _, cut := range []int{2, 8, len(full) - 40, len(full) - 1} {
+		if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(full[:cut]), 0)); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("expected io.ErrUnexpectedEOF for a stream truncated at %d bytes, got %v", cut, err)
+		}
+	}
+
+	oversized := binary.BigEndian.AppendUint32(nil, MaxStreamChunkSize+1)
+	if _, err := io.ReadAll(NewStreamReader(bytes.NewReader(oversized), 0)); !errors.Is(err, ErrInvalidChunkSize) {
+		t.Fatalf("expected ErrInvalidChunkSize, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// DefaultKeepAlivePeriod is the default interval of the TCP keep-alive probes of an idle connection ("-tcp-keepalive").
+const DefaultKeepAlivePeriod = 30 * time.Second
+
+// tcpTuner is the part of `*net.TCPConn` that `TuneTCP` uses, so that tests can stand in for a TCP connection.
+type tcpTuner interface {
+	SetNoDelay(noDelay bool) error
+	SetKeepAlive(keepalive bool) error
+	SetKeepAlivePeriod(d time.Duration) error
+}
+
+// TuneTCP sets TCP_NODELAY (disabling Nagle's algorithm, which delays the small writes of headers and responses)
+// and the keep-alive period on the TCP connection underlying `conn`, unwrapping a TLS connection.
+// Keep-alive is disabled if the period is not positive.
+// It reports whether `conn` is backed by a TCP connection: other connections (e.g. `net.Pipe`) are left unchanged.
+func TuneTCP(conn net.Conn, noDelay bool, keepAlivePeriod time.Duration) (bool, error) {
+	// A TLS connection (`*tls.Conn`) exposes the connection it wraps.
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+
+	tcpConn, ok := conn.(tcpTuner)
+	if !ok {
+		return false, nil
+	}
+	if err := tcpConn.SetNoDelay(noDelay); err != nil {
+		return true, fmt.Errorf("failed to set TCP_NODELAY: %v", err)
+	}
+	if keepAlivePeriod <= 0 {
+		if err := tcpConn.SetKeepAlive(false); err != nil {
+			return true, fmt.Errorf("failed to disable TCP keep-alive: %v", err)
+		}
+		return true, nil
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		return true, fmt.Errorf("failed to enable TCP keep-alive: %v", err)
+	}
+	if err := tcpConn.SetKeepAlivePeriod(keepAlivePeriod); err != nil {
+		return true, fmt.Errorf("failed to set the TCP keep-alive period: %v", err)
+	}
+	return true, nil
+}
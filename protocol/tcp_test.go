@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fakeTCPConn records the TCP options set on it, and fails setting TCP_NODELAY if `err` is set.
+type fakeTCPConn struct {
+	net.Conn
+	calls []string
+	err   error
+}
+
+// SetNoDelay records the option.
+func (c *fakeTCPConn) SetNoDelay(noDelay bool) error {
+	c.calls = append(c.calls, "nodelay="+map[bool]string{true: "on", false: "off"}[noDelay])
+	return c.err
+}
+
+// SetKeepAlive records the option.
+func (c *fakeTCPConn) SetKeepAlive(keepalive bool) error {
+	c.calls = append(c.calls, "keepalive="+map[bool]string{true: "on", false: "off"}[keepalive])
+	return nil
+}
+
+// SetKeepAlivePeriod records the option.
+func (c *fakeTCPConn) SetKeepAlivePeriod(d time.Duration) error {
+	c.calls = append(c.calls, "period="+d.String())
+	return nil
+}
+
+// TestTuneTCP tests `TuneTCP` to ensure that
+// the options are set on a TCP connection, also under TLS, and that other connections are left unchanged.
+func TestTuneTCP(t *testing.T) {
+	tests := []struct {
+		name     string
+		noDelay  bool
+		period   time.Duration
+		wrap     bool
+		expected []string
+	}{
+		{"defaults", true, DefaultKeepAlivePeriod, false, []string{"nodelay=on", "keepalive=on", "period=30s"}},
+		{"under TLS", true, time.Minute, true, []string{"nodelay=on", "keepalive=on", "period=1m0s"}},
+		{"keep-alive disabled", false, 0, false, []string{"nodelay=off", "keepalive=off"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeTCPConn{}
+			var conn net.Conn = fake
+			if tt.wrap {
+				conn = tls.Client(fake, &tls.Config{InsecureSkipVerify: true})
+			}
+			tuned, err := TuneTCP(conn, tt.noDelay, tt.period)
+			if err != nil || !tuned {
+				t.Fatalf("expected the connection to be tuned, got %v (%v)", tuned, err)
+			}
+			if !reflect.DeepEqual(fake.calls, tt.expected) {
+				t.Fatalf("expected the calls %v, got %v", tt.expected, fake.calls)
+			}
+		})
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	tcpConn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer func() {
+		_ = tcpConn.Close()
+	}()
+	if tuned, err := TuneTCP(tcpConn, true, DefaultKeepAlivePeriod); !tuned || err != nil {
+		t.Fatalf("expected a TCP connection to be tuned, got %v (%v)", tuned, err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	defer func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	}()
+	if tuned, err := TuneTCP(clientConn, true, DefaultKeepAlivePeriod); tuned || err != nil {
+		t.Fatalf("expected a pipe to be left unchanged, got %v (%v)", tuned, err)
+	}
+
+	failing := &fakeTCPConn{err: errors.New("bad option")}
+	if _, err := TuneTCP(failing, true, DefaultKeepAlivePeriod); err == nil || !strings.Contains(err.Error(), "TCP_NODELAY") {
+		t.Fatalf("expected the error setting TCP_NODELAY, got %v", err)
+	}
+}
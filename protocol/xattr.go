@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Constants for the extended attributes carried by a header.
+const (
+	MaxXattrNameLength = 255 // Maximum length of the name of an extended attribute (the limit of Linux and macOS).
+	MaxXattrs          = 128 // Maximum number of extended attributes of a file.
+)
+
+// xattrEntryOverhead is the size of the length fields of an encoded extended attribute: its name length and value length.
+const xattrEntryOverhead = 1 + 4
+
+// ErrInvalidXattrs is returned for extended attributes that cannot be carried by a header.
+var ErrInvalidXattrs = errors.New("invalid extended attributes in the header")
+
+// An Xattr is an extended attribute of a file (e.g. "user.comment"), preserved by a transfer with the client's "-xattrs".
+type Xattr struct {
+	Name  string // Name of the attribute, including its namespace on Linux.
+	Value []byte // Value of the attribute.
+}
+
+// xattrsSize returns the number of bytes the attributes take once encoded (see `encodeXattrs`).
+func xattrsSize(xattrs []Xattr) int {
+	size := 0
+	for _, xattr := range xattrs {
+		size += xattrEntryOverhead + len(xattr.Name) + len(xattr.Value)
+	}
+	return size
+}
+
+// validateXattrs validates the attributes: at most `MaxXattrs` of them, with unique non-empty names of at most
+// `MaxXattrNameLength` bytes without null bytes.
+func validateXattrs(xattrs []Xattr) error {
+	if len(xattrs) > MaxXattrs {
+		return fmt.Errorf("%w: %d attributes exceed the maximum %d", ErrInvalidXattrs, len(xattrs), MaxXattrs)
+	}
+
+	names := make(map[string]bool, len(xattrs))
+	for _, xattr := range xattrs {
+		switch {
+		case xattr.Name == "":
+			return fmt.Errorf("%w: empty attribute name", ErrInvalidXattrs)
+		case len(xattr.Name) > MaxXattrNameLength:
+			return fmt.Errorf("%w: attribute name length %d exceeds the maximum %d", ErrInvalidXattrs, len(xattr.Name), MaxXattrNameLength)
+		case strings.ContainsRune(xattr.Name, 0):
+			return fmt.Errorf("%w: attribute name contains null bytes", ErrInvalidXattrs)
+		case names[xattr.Name]:
+			return fmt.Errorf("%w: duplicate attribute %q", ErrInvalidXattrs, xattr.Name)
+		}
+		names[xattr.Name] = true
+	}
+	return nil
+}
+
+// encodeXattrs encodes the attributes as a sequence of entries, each made of the name length (1 byte), the name,
+// the value length (4 bytes, big-endian), and the value.
+func encodeXattrs(xattrs []Xattr) []byte {
+	data := make([]byte, 0, xattrsSize(xattrs))
+	for _, xattr := range xattrs {
+		data = append(data, byte(len(xattr.Name)))
+		data = append(data, xattr.Name...)
+		data = binary.BigEndian.AppendUint32(data, uint32(len(xattr.Value)))
+		data = append(data, xattr.Value...)
+	}
+	return data
+}
+
+// decodeXattrs decodes the attributes encoded by `encodeXattrs`. The values share the memory of `data`.
+func decodeXattrs(data []byte) ([]Xattr, error) {
+	var xattrs []Xattr
+	for len(data) > 0 {
+		if len(xattrs) == MaxXattrs {
+			return nil, fmt.Errorf("%w: more than %d attributes", ErrInvalidXattrs, MaxXattrs)
+		}
+
+		nameLength := int(data[0])
+		if len(data) < xattrEntryOverhead+nameLength {
+			return nil, fmt.Errorf("%w: truncated attribute", ErrInvalidXattrs)
+		}
+		name := string(data[1 : 1+nameLength])
+		valueLength := binary.BigEndian.Uint32(data[1+nameLength:])
+		data = data[xattrEntryOverhead+nameLength:]
+		if uint64(valueLength) > uint64(len(data)) {
+			return nil, fmt.Errorf("%w: truncated value of the attribute %q", ErrInvalidXattrs, name)
+		}
+
+		xattrs = append(xattrs, Xattr{Name: name, Value: data[:valueLength:valueLength]})
+		data = data[valueLength:]
+	}
+	return xattrs, nil
+}
@@ -0,0 +1,72 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// TestDecodeXattrsRoundTrip tests that `decodeXattrs` decodes what `encodeXattrs` encodes,
+// and that the encoding takes exactly `xattrsSize` bytes.
+func TestDecodeXattrsRoundTrip(t *testing.T) {
+	xattrs := []Xattr{
+		{Name: "user.comment", Value: []byte("a comment")},
+		{Name: "user.empty", Value: []byte{}},
+		{Name: "user.binary", Value: []byte{0x00, 0xFF, 0x00}},
+	}
+	data := encodeXattrs(xattrs)
+	if len(data) != xattrsSize(xattrs) {
+		t.Fatalf("expected %d encoded bytes, got %d", xattrsSize(xattrs), len(data))
+	}
+
+	got, err := decodeXattrs(data)
+	if err != nil {
+		t.Fatalf("decodeXattrs returned error: %v", err)
+	}
+	if len(got) != len(xattrs) {
+		t.Fatalf("expected %d attributes, got %d", len(xattrs), len(got))
+	}
+	for i := range xattrs {
+		if got[i].Name != xattrs[i].Name || !bytes.Equal(got[i].Value, xattrs[i].Value) {
+			t.Errorf("attribute %d: got %q=%q, want %q=%q", i, got[i].Name, got[i].Value, xattrs[i].Name, xattrs[i].Value)
+		}
+	}
+}
+
+// TestDecodeXattrsInvalid tests that `decodeXattrs` rejects truncated entries and too many attributes
+// with `ErrInvalidXattrs`.
+func TestDecodeXattrsInvalid(t *testing.T) {
+	valid := encodeXattrs([]Xattr{{Name: "user.a", Value: []byte("value")}})
+	tooMany := bytes.Repeat(encodeXattrs([]Xattr{{Name: "a"}}), MaxXattrs+1)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated name", valid[:3]},
+		{"truncated value length", valid[:1+len("user.a")+2]},
+		{"truncated value", valid[:len(valid)-1]},
+		{"too many attributes", tooMany},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeXattrs(tt.data); !errors.Is(err, ErrInvalidXattrs) {
+				t.Fatalf("expected ErrInvalidXattrs, got %v", err)
+			}
+		})
+	}
+}
+
+// TestReadHeaderXattrsTooLarge tests the `ReadHeader` function to ensure that an extended attributes length
+// beyond what is left of `MaxHeaderSize` fails with `ErrHeaderTooLarge` before its data is read.
+func TestReadHeaderXattrsTooLarge(t *testing.T) {
+	data := hostileHeader(1, 0)
+	data = append(data[:len(data)-4], u32Bytes(MaxHeaderSize)...)
+	reader := bytes.NewReader(data)
+	if _, err := ReadHeader(reader); !errors.Is(err, ErrHeaderTooLarge) {
+		t.Fatalf("expected ErrHeaderTooLarge, got %v", err)
+	}
+	if reader.Len() != 0 {
+		t.Fatalf("expected the header to be read up to the extended attributes length, %d bytes left", reader.Len())
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"filexfer/protocol"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Statuses of a transfer in the access log.
+const (
+	AccessStatusCompleted = "completed" // The file was received, verified, and stored.
+	AccessStatusFailed    = "failed"    // The transfer was rejected or interrupted, and nothing was stored.
+)
+
+// An accessLogEntry is a line of the access log (-access-log), written as a JSON object per finished transfer.
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`                  // Time the transfer finished.
+	TransferID string    `json:"transfer_id"`           // Identifier of the transfer, as in the diagnostic logs.
+	ClientIP   string    `json:"client_ip"`             // IP address of the client.
+	TLSSubject string    `json:"tls_subject,omitempty"` // Subject of the client's TLS certificate, if it presented one.
+	FileName   string    `json:"file_name"`             // Name of the file as sent by the client.
+	Path       string    `json:"path,omitempty"`        // Path of the stored file (the destination root of an archive).
+	Bytes      int64     `json:"bytes"`                 // Number of bytes received.
+	DurationMS int64     `json:"duration_ms"`           // Duration of the transfer in milliseconds.
+	Checksum   string    `json:"checksum,omitempty"`    // Hex-encoded SHA-256 checksum of the verified content.
+	Status     string    `json:"status"`                // One of the `AccessStatus` constants.
+	Error      string    `json:"error,omitempty"`       // Error message sent to the client for a failed transfer.
+}
+
+// An accessLogger appends entries to the access log file, rotating it once it would grow past `maxSize` bytes (unless 0).
+// Every entry is flushed as it is written, so that the file is complete even if the server is killed,
+// and with `fsync`, synced to stable storage as well, so that it survives a crash of the machine.
+type accessLogger struct {
+	mu      sync.Mutex
+	name    string        // Name of the log in error messages (e.g. "access log").
+	path    string        // Path of the current log file.
+	maxSize int64         // Size in bytes at which the file is rotated, or 0 to never rotate it.
+	fsync   bool          // Whether every entry is synced to stable storage.
+	file    *os.File      // Current log file (nil once closed).
+	writer  *bufio.Writer // Buffered writer of the current log file.
+	size    int64         // Size of the current log file in bytes.
+}
+
+// accessLog writes the access log, or is nil if no access log is configured.
+var accessLog *accessLogger
+
+// auditLog writes the audit log (-audit-log), or is nil if no audit log is configured.
+var auditLog *accessLogger
+
+// openAccessLog opens (or creates) the access log file at `path` for appending.
+func openAccessLog(path string, maxSize int64) (*accessLogger, error) {
+	l := &accessLogger{name: "access log", path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// openAuditLog opens (or creates) the audit log file at `path` for appending. The audit log has the entries of the
+// access log, but is never rotated, and every entry is synced to stable storage before the next one is written.
+func openAuditLog(path string) (*accessLogger, error) {
+	l := &accessLogger{name: "audit log", path: path, fsync: true}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// open opens the log file for appending and picks up its current size.
+func (l *accessLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open the %s: %v", l.name, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open the %s: %v", l.name, err)
+	}
+	l.file, l.writer, l.size = file, bufio.NewWriter(file), info.Size()
+	return nil
+}
+
+// record appends the entry to the log and flushes (and with `fsync`, syncs) it,
+// rotating the file first if the entry would grow it past its size limit.
+func (l *accessLogger) record(entry accessLogEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode the %s entry: %v", l.name, err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("the %s is closed", l.name)
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	n, err := l.writer.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write the %s: %v", l.name, err)
+	}
+	if err := l.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write the %s: %v", l.name, err)
+	}
+	if l.fsync {
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync the %s: %v", l.name, err)
+		}
+	}
+	return nil
+}
+
+// rotate moves the current log file aside and starts a new one (on SIGUSR2).
+func (l *accessLogger) rotate() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("the %s is closed", l.name)
+	}
+	return l.rotateLocked()
+}
+
+// rotateLocked renames the current log file with a timestamp suffix (see `rotatedAccessLogPath`) and opens a new one.
+// The caller must hold the mutex.
+func (l *accessLogger) rotateLocked() error {
+	if err := l.closeLocked(); err != nil {
+		slog.Warn("Error closing the access log before rotating it", "path", l.path, "error", err)
+	}
+	rotated := rotatedAccessLogPath(l.path, time.Now())
+	if err := os.Rename(l.path, rotated); err != nil {
+		slog.Error("Failed to rotate the access log, appending to it instead", "path", l.path, "error", err)
+	} else {
+		slog.Info("Rotated the access log", "path", l.path, "rotated_path", rotated)
+	}
+	return l.open()
+}
+
+// close flushes and closes the log file. Entries recorded afterwards are rejected.
+func (l *accessLogger) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	return l.closeLocked()
+}
+
+// closeLocked flushes and closes the current log file. The caller must hold the mutex.
+func (l *accessLogger) closeLocked() error {
+	flushErr := l.writer.Flush()
+	closeErr := l.file.Close()
+	l.file, l.writer = nil, nil
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
+
+// rotatedAccessLogPath returns the path that a rotated log file is moved to: its path with the time of the rotation appended
+// (e.g. "access.log.20240102-150405"), and a counter if a file rotated within the same second already has that name.
+func rotatedAccessLogPath(path string, now time.Time) string {
+	rotated := path + "." + now.Format("20060102-150405")
+	candidate := rotated
+	for counter := 1; ; counter++ {
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = rotated + "." + strconv.Itoa(counter)
+	}
+}
+
+// An accessRecord collects the details of a transfer for its access log entry as the transfer progresses.
+type accessRecord struct {
+	entry     accessLogEntry // Entry written when the transfer finishes.
+	startTime time.Time      // Time the transfer started.
+	journaled bool           // Whether the start of the content was recorded in the journal (see `begin`).
+}
+
+// newAccessRecord starts the record of the transfer (identified by `transferID`) of the header received on the connection.
+func newAccessRecord(conn net.Conn, header *protocol.Header, transferID string) *accessRecord {
+	clientIP := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	return &accessRecord{
+		entry: accessLogEntry{
+			TransferID: transferID,
+			ClientIP:   clientIP,
+			TLSSubject: tlsSubject(conn),
+			FileName:   header.FileName,
+		},
+		startTime: time.Now(),
+	}
+}
+
+// tlsSubject returns the subject of the certificate the client presented over TLS, or an empty string.
+func tlsSubject(conn net.Conn) string {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return ""
+	}
+	return certificates[0].Subject.String()
+}
+
+// begin records in the journal, if one is kept, that the content of the transfer starts to be written to `path`,
+// so that the end of the transfer is recorded there as well.
+func (r *accessRecord) begin(path string, size int64) {
+	r.journaled = true
+	journalStarted(r.entry.TransferID, r.entry.FileName, path, size)
+}
+
+// stored records in the journal, if the transfer was begun there, that its content was stored at `path`.
+// It is called before the client is acknowledged, so that an acknowledged file is never taken for a partial one.
+func (r *accessRecord) stored(path string, checksum []byte) {
+	if r.journaled {
+		r.journaled = false
+		journalEnded(r.entry.TransferID, r.entry.FileName, path, AccessStatusCompleted, hex.EncodeToString(checksum))
+	}
+}
+
+// complete writes the entry of a transfer whose content was stored at `path` and verified against `checksum`.
+func (r *accessRecord) complete(path string, bytes int64, checksum []byte) {
+	r.entry.Path, r.entry.Bytes, r.entry.Checksum = path, bytes, hex.EncodeToString(checksum)
+	r.finish(AccessStatusCompleted, "")
+}
+
+// fail sends the error response to the client and writes the entry of the failed transfer with the same message.
+func (r *accessRecord) fail(conn net.Conn, message string) {
+	r.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeNone, message)
+}
+
+// failWithCode sends the response with the status and the error code to the client,
+// and writes the entry of the failed transfer with the same message.
+func (r *accessRecord) failWithCode(conn net.Conn, status uint8, code uint16, message string) {
+	sendCodedResponse(conn, status, code, message)
+	r.finish(AccessStatusFailed, message)
+}
+
+// abort notifies the client of the shutdown that interrupted the transfer and writes the entry of the failed transfer.
+func (r *accessRecord) abort(conn net.Conn, logger *slog.Logger) {
+	notifyShutdown(conn, logger)
+	r.finish(AccessStatusFailed, protocol.ShutdownMessage)
+}
+
+// finish counts the transfer in the metrics, records its end in the journal if it was begun,
+// and writes the entry with its status to the access log and the audit log, if they are configured.
+// A failure to write it is logged, and does not affect the transfer.
+func (r *accessRecord) finish(status, message string) {
+	recordTransfer(status == AccessStatusFailed)
+	shutdownState.record(status == AccessStatusFailed)
+	if r.journaled {
+		r.journaled = false
+		journalEnded(r.entry.TransferID, r.entry.FileName, r.entry.Path, status, r.entry.Checksum)
+	}
+	if accessLog == nil && auditLog == nil {
+		return
+	}
+	r.entry.Time = time.Now()
+	r.entry.DurationMS = time.Since(r.startTime).Milliseconds()
+	r.entry.Status, r.entry.Error = status, message
+	for _, logger := range []*accessLogger{accessLog, auditLog} {
+		if logger == nil {
+			continue
+		}
+		if err := logger.record(r.entry); err != nil {
+			slog.Error("Failed to write the "+logger.name+" entry", "file_name", r.entry.FileName, "error", err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withAccessLog opens an access log at `path` for the duration of the test.
+func withAccessLog(t *testing.T, path string, maxSize int64) {
+	t.Helper()
+
+	logger, err := openAccessLog(path, maxSize)
+	if err != nil {
+		t.Fatalf("failed to open the access log: %v", err)
+	}
+	accessLog = logger
+	t.Cleanup(func() {
+		_ = logger.close()
+		accessLog = nil
+	})
+}
+
+// readAccessLog reads the entries of the access log file at `path`.
+func readAccessLog(t *testing.T, path string) []accessLogEntry {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open the access log: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var entries []accessLogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry accessLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("expected a JSON line, got %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// TestAccessLogTransfers tests the access log to ensure that
+// a completed and a failed transfer are each written as an entry, while verification requests are not.
+func TestAccessLogTransfers(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "access.log")
+	withAccessLog(t, logPath, AccessLogMaxSize)
+
+	content := []byte("audited")
+	if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if status, _ := sendStream(t, dir, "b.txt", encodeStream(t, content, true)); status != protocol.ResponseStatusError {
+		t.Fatal("expected an error response for a corrupted stream")
+	}
+	if status, message := sendVerifyRequest(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	entries := readAccessLog(t, logPath)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	completed, failed := entries[0], entries[1]
+	if completed.Status != AccessStatusCompleted || completed.FileName != "a.txt" || completed.Path != filepath.Join(dir, "a.txt") ||
+		completed.Bytes != int64(len(content)) || completed.Checksum != hex.EncodeToString(protocol.CalculateDataChecksum(content)) ||
+		completed.ClientIP == "" || completed.Time.IsZero() || completed.Error != "" {
+		t.Fatalf("unexpected entry of the completed transfer: %+v", completed)
+	}
+	if failed.Status != AccessStatusFailed || failed.FileName != "b.txt" || failed.Error != "Data integrity check failed" || failed.Checksum != "" {
+		t.Fatalf("unexpected entry of the failed transfer: %+v", failed)
+	}
+}
+
+// TestAccessLogRotation tests `accessLogger` to ensure that
+// the file is rotated before an entry would grow it past its size limit, and on demand, without losing entries.
+func TestAccessLogRotation(t *testing.T) {
+	logDir := t.TempDir()
+	logPath := filepath.Join(logDir, "access.log")
+	entry := accessLogEntry{Time: time.Now(), ClientIP: "192.0.2.1", FileName: "a.txt", Status: AccessStatusCompleted}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Room for two entries per file.
+	withAccessLog(t, logPath, int64(2*(len(line)+1)))
+
+	for range 3 {
+		if err := accessLog.record(entry); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := accessLog.rotate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	matches, err := filepath.Glob(logPath + ".*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("expected 2 rotated files, got %v", matches)
+	}
+	total := 0
+	for _, match := range matches {
+		total += len(readAccessLog(t, match))
+	}
+	if total != 3 || len(readAccessLog(t, logPath)) != 0 {
+		t.Fatalf("expected the 3 entries in the rotated files and an empty current file, got %d and %d", total, len(readAccessLog(t, logPath)))
+	}
+
+	if err := accessLog.close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := accessLog.record(entry); err == nil || !strings.Contains(err.Error(), "closed") {
+		t.Fatalf("expected an error for a closed access log, got %v", err)
+	}
+}
+
+// TestAuditLogTransfers tests the audit log to ensure that
+// two transfers append two well-formed entries to an existing file, with nothing written to the unconfigured access log.
+func TestAuditLogTransfers(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(logPath, []byte(`{"file_name":"earlier.txt","status":"completed"}`+"\n"), 0644); err != nil {
+		t.Fatalf("failed to write the audit log: %v", err)
+	}
+	logger, err := openAuditLog(logPath)
+	if err != nil {
+		t.Fatalf("failed to open the audit log: %v", err)
+	}
+	auditLog = logger
+	t.Cleanup(func() {
+		_ = logger.close()
+		auditLog = nil
+	})
+
+	contents := map[string][]byte{"a.txt": []byte("first"), "b.txt": []byte("second")}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if status, message := sendFile(t, dir, name, contents[name]); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response, got %d: %s", status, message)
+		}
+	}
+
+	entries := readAccessLog(t, logPath)
+	if len(entries) != 3 || entries[0].FileName != "earlier.txt" {
+		t.Fatalf("expected the earlier entry followed by 2 appended entries, got %d: %v", len(entries), entries)
+	}
+	for _, entry := range entries[1:] {
+		content := contents[entry.FileName]
+		if entry.Status != AccessStatusCompleted || content == nil || entry.Bytes != int64(len(content)) ||
+			entry.Checksum != hex.EncodeToString(protocol.CalculateDataChecksum(content)) || entry.ClientIP == "" || entry.Time.IsZero() {
+			t.Fatalf("unexpected entry of the transfer: %+v", entry)
+		}
+	}
+	if entries[1].FileName != "a.txt" || entries[2].FileName != "b.txt" {
+		t.Fatalf("expected the entries in the order of the transfers, got %v", entries)
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrInvalidArchiveEntry is returned for an entry of a tar archive transfer that cannot be extracted safely.
+var ErrInvalidArchiveEntry = errors.New("invalid archive entry")
+
+// An archiveEntry is an entry of a received tar archive, validated before anything is extracted.
+type archiveEntry struct {
+	header *tar.Header // Header of the entry.
+	path   string      // Sanitized destination path of the entry (empty for a directory left out by "-flatten").
+}
+
+// handleArchiveTransfer receives a directory sent as a tar archive (`protocol.TransferTypeTarArchive`)
+// and extracts it into the destination directory.
+// The archive is spooled to a temporary file while the checksum trailing its stream is verified,
+// and every entry is validated before the first one is extracted, so that nothing is extracted from a corrupted archive
+// or from one with an entry escaping the destination.
+// The outcome is written to the access log through `record`, with the destination root as the path of the archive.
+// The archive is read from the connection through `ctxReader`, which tracks the transfer until its `release`.
+// It returns whether the connection can be used for further requests.
+func handleArchiveTransfer(ctxReader *contextReader, conn net.Conn, header *protocol.Header, logger *slog.Logger, record *accessRecord, buffer []byte) bool {
+	startTime := time.Now()
+	// Read the limit once, since a reload may change it.
+	maxDirSize := maxDirectorySize.Load()
+	logger = logger.With("file_name", header.FileName)
+	logger.Info("Receiving an archive of unknown size", "max_bytes", maxDirSize)
+
+	// The entries of an archive are extracted in place, so they cannot go through quarantine one by one.
+	if *quarantineDir != "" {
+		logger.Warn("Refusing an archive transfer in quarantine mode")
+		record.fail(conn, "Archive transfers are not accepted with quarantine")
+		return false
+	}
+
+	if err := os.MkdirAll(*destDir, 0755); err != nil {
+		logger.Error("Failed to create the output directory", "dir", *destDir, "error", err)
+		record.fail(conn, "Failed to create output directory")
+		return false
+	}
+
+	spool, err := os.CreateTemp(*destDir, ".filexfer-archive-*.tar")
+	if err != nil {
+		logger.Error("Failed to create the archive spool file", "error", err)
+		record.fail(conn, "Failed to create output file")
+		return false
+	}
+	openPartials.Store(spool.Name(), struct{}{})
+	defer func() {
+		openPartials.Delete(spool.Name())
+		if err := spool.Close(); err != nil {
+			logger.Warn("Error closing the archive spool file", "path", spool.Name(), "error", err)
+		}
+		if err := os.Remove(spool.Name()); err != nil {
+			logger.Warn("Failed to remove the archive spool file", "path", spool.Name(), "error", err)
+		}
+	}()
+
+	reader := protocol.NewStreamReader(ctxReader, maxDirSize)
+	archiveSize, err := io.CopyBuffer(spool, reader, buffer)
+	record.entry.Bytes = archiveSize
+	if err != nil {
+		logger.Error("Failed to receive the archive", "error", err)
+		switch {
+		case ctxReader.ctx.Err() != nil:
+			record.abort(conn, logger)
+		case errors.Is(err, protocol.ErrStreamTooLarge):
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeQuotaExceeded,
+				fmt.Sprintf("Archive exceeds the maximum allowed size of %d bytes", maxDirSize))
+		case errors.Is(err, protocol.ErrChecksumMismatch):
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+		default:
+			record.fail(conn, "Failed to receive archive content")
+		}
+		return false
+	}
+	checksum := reader.Checksum()
+	logger.Info("Archive received and verified", "bytes", archiveSize, "checksum", hex.EncodeToString(checksum))
+
+	// The whole stream has been read, so the connection stays usable even if the archive is rejected.
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		logger.Error("Failed to rewind the archive spool file", "path", spool.Name(), "error", err)
+		record.fail(conn, "Failed to extract the archive")
+		return true
+	}
+	root, err := destinationRoot(header)
+	if err != nil {
+		logger.Warn("Rejecting the archive", "error", err)
+		record.fail(conn, fmt.Sprintf("Invalid file path: %v", err))
+		return true
+	}
+	entries, err := readArchiveEntries(spool, root)
+	if err != nil {
+		logger.Warn("Rejecting the archive", "error", err)
+		record.fail(conn, fmt.Sprintf("Invalid archive: %v", err))
+		return true
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		logger.Error("Failed to rewind the archive spool file", "path", spool.Name(), "error", err)
+		record.fail(conn, "Failed to extract the archive")
+		return true
+	}
+	files, err := extractArchive(spool, entries, buffer)
+	// Extracting a large archive can take a while, so the response gets a fresh write deadline.
+	if deadlineErr := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); deadlineErr != nil {
+		logger.Error("Failed to set the write deadline", "error", deadlineErr)
+		return false
+	}
+	if err != nil {
+		logger.Error("Failed to extract the archive", "error", err)
+		record.fail(conn, fmt.Sprintf("Failed to extract the archive: %v", err))
+		return true
+	}
+	logger.Info("Archive extracted", "files", len(files), "duration_ms", time.Since(startTime).Milliseconds())
+
+	// The receipt names the directory the files went to, e.g. one chosen by "-dest-template".
+	sendTransferResponse(conn, protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(checksum),
+		transferReceipt(root, uint64(archiveSize), checksum))
+	record.complete(root, archiveSize, checksum)
+	for _, file := range files {
+		journalEnded(record.entry.TransferID, file.name, file.path, AccessStatusCompleted, hex.EncodeToString(file.checksum))
+	}
+
+	notifyCompleted(record, files...)
+	return true
+}
+
+// readArchiveEntries reads the headers of every entry of the archive, in order, and validates them:
+// only regular files and directories are accepted, each within the `root` directory and no larger than `MaxFileSize`.
+// An entry of the `root` directory itself is skipped.
+func readArchiveEntries(r io.Reader, root string) ([]archiveEntry, error) {
+	var entries []archiveEntry
+	tarReader := tar.NewReader(r)
+	for {
+		entryHeader, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the archive: %v", err)
+		}
+
+		name := strings.TrimSuffix(entryHeader.Name, "/")
+		if *normalizeUnicode {
+			name = normalizeName(name)
+		}
+		switch entryHeader.Typeflag {
+		case tar.TypeReg:
+			if entryHeader.Size > MaxFileSize {
+				return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchiveEntry, name, ErrFileTooLarge)
+			}
+		case tar.TypeDir:
+			// Do nothing.
+		default:
+			return nil, fmt.Errorf("%w: %s: unsupported entry type %q", ErrInvalidArchiveEntry, name, entryHeader.Typeflag)
+		}
+
+		if *flatten {
+			if entryHeader.Typeflag == tar.TypeDir {
+				entries = append(entries, archiveEntry{header: entryHeader})
+				continue
+			}
+			name = filepath.Base(name)
+		}
+		path, err := sanitizePath(root, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidArchiveEntry, err)
+		}
+		// An entry of the root itself (e.g. "./", as written by "tar -C dir .") would have its mode and modification time
+		// restored on the root, so a directory is skipped like the directories of "-flatten", and a file is rejected.
+		if path == filepath.Clean(root) {
+			if entryHeader.Typeflag != tar.TypeDir {
+				return nil, fmt.Errorf("%w: %s: not a file name", ErrInvalidArchiveEntry, entryHeader.Name)
+			}
+			entries = append(entries, archiveEntry{header: entryHeader})
+			continue
+		}
+		// Files are checked against "-reject-pattern" and "-allow-pattern" by their path under the destination directory.
+		if entryHeader.Typeflag == tar.TypeReg {
+			relative, err := filepath.Rel(filepath.Clean(*destDir), path)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidArchiveEntry, err)
+			}
+			if err := checkFileNamePatterns(filepath.ToSlash(relative)); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidArchiveEntry, err)
+			}
+		}
+		entries = append(entries, archiveEntry{header: entryHeader, path: path})
+	}
+}
+
+// extractArchive extracts the entries of the archive validated by `readArchiveEntries`, applying the file
+// conflict-resolution strategy to files and restoring the mode and modification time of every entry.
+// It returns the extracted files.
+func extractArchive(r io.Reader, entries []archiveEntry, buffer []byte) ([]completedFile, error) {
+	var files []completedFile
+	var dirs []archiveEntry
+	tarReader := tar.NewReader(r)
+	for _, entry := range entries {
+		if _, err := tarReader.Next(); err != nil {
+			return files, fmt.Errorf("failed to read the archive: %v", err)
+		}
+		if entry.path == "" {
+			continue
+		}
+
+		if entry.header.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(entry.path, 0755); err != nil {
+				return files, fmt.Errorf("failed to create the directory %s: %v", entry.path, err)
+			}
+			dirs = append(dirs, entry)
+			continue
+		}
+
+		file, err := extractArchiveFile(tarReader, entry, buffer)
+		if err != nil {
+			return files, err
+		}
+		if file != nil {
+			files = append(files, *file)
+		}
+	}
+
+	// Restore the directories last, since extracting their files changes their modification time,
+	// and deepest first, so that a read-only directory does not prevent restoring its subdirectories.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := restoreMetadata(dirs[i].path, dirs[i].header); err != nil {
+			return files, err
+		}
+	}
+
+	// With "-fsync", the entries of the extracted files are durable before the archive is acknowledged.
+	if *fsyncFiles {
+		synced := make(map[string]bool)
+		for _, file := range files {
+			if dir := filepath.Dir(file.path); !synced[dir] {
+				if err := syncDir(dir); err != nil {
+					return files, err
+				}
+				synced[dir] = true
+			}
+		}
+	}
+	return files, nil
+}
+
+// extractArchiveFile extracts a regular file of the archive from the reader positioned at its content.
+// It returns nil if the file already exists and is kept by the "skip" strategy,
+// or by the "newer" strategy because the entry is not newer than the existing file.
+func extractArchiveFile(r io.Reader, entry archiveEntry, buffer []byte) (*completedFile, error) {
+	if err := os.MkdirAll(filepath.Dir(entry.path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the directory structure %s: %v", filepath.Dir(entry.path), err)
+	}
+
+	var outputFile *os.File
+	finalPath := entry.path
+	_, exists := existingPath(entry.path)
+	switch {
+	case *fileStrategy == StrategyRename:
+		file, path, err := createRenamedFile(entry.path)
+		if err != nil {
+			return nil, err
+		}
+		outputFile, finalPath = file, path
+	case !exists:
+		file, err := os.Create(entry.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the file %s: %v", entry.path, err)
+		}
+		outputFile = file
+	case *fileStrategy == StrategySkip:
+		slog.Info("Skipping the archive entry of an existing file", "file_name", entry.header.Name, "strategy", StrategySkip)
+		return nil, nil
+	default:
+		path, err := resolveFilePath(entry.path, *fileStrategy, entry.header.ModTime)
+		if errors.Is(err, errSkipExisting) {
+			slog.Info("Skipping the archive entry of an existing file", "file_name", entry.header.Name, "strategy", *fileStrategy, "error", err)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the file %s: %v", path, err)
+		}
+		outputFile, finalPath = file, path
+	}
+	caseFolding.add(finalPath)
+
+	hasher := sha256.New()
+	written, err := io.CopyBuffer(outputFile, io.TeeReader(r, hasher), buffer)
+	if err == nil && *fsyncFiles {
+		err = outputFile.Sync()
+	}
+	if closeErr := outputFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if err := os.Remove(finalPath); err != nil {
+			slog.Warn("Failed to remove the partial file", "path", finalPath, "error", err)
+		}
+		return nil, fmt.Errorf("failed to extract the file %s: %v", entry.header.Name, err)
+	}
+	if err := restoreMetadata(finalPath, entry.header); err != nil {
+		return nil, err
+	}
+
+	checksum := hasher.Sum(nil)
+	recordStoredChecksum(finalPath, checksum)
+	return &completedFile{path: finalPath, name: entry.header.Name, checksum: checksum, size: uint64(written)}, nil
+}
+
+// restoreMetadata sets the permissions and modification time of an extracted entry to those in its header.
+func restoreMetadata(path string, entryHeader *tar.Header) error {
+	if err := os.Chmod(path, entryHeader.FileInfo().Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set the mode of %s: %v", path, err)
+	}
+	modTime := entryHeader.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		return fmt.Errorf("failed to set the modification time of %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// buildArchive builds a tar archive of the given entries, whose names ending with "/" are directories.
+func buildArchive(t *testing.T, entries []tar.Header, contents map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		entry.Typeflag = tar.TypeReg
+		if strings.HasSuffix(entry.Name, "/") {
+			entry.Typeflag = tar.TypeDir
+		}
+		entry.Size = int64(len(contents[entry.Name]))
+		if err := tarWriter.WriteHeader(&entry); err != nil {
+			t.Fatalf("failed to write the archive entry %s: %v", entry.Name, err)
+		}
+		if _, err := tarWriter.Write([]byte(contents[entry.Name])); err != nil {
+			t.Fatalf("failed to write the archive entry %s: %v", entry.Name, err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close the archive: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// sendArchive sends a tar archive transfer of the archive and returns the server's response.
+func sendArchive(t *testing.T, dir string, archive []byte) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileName:     "tree",
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeTarArchive,
+	}, encodeStream(t, archive, false))
+}
+
+// TestHandleArchiveTransfer tests the handling of a tar archive transfer to ensure that
+// the tree is extracted with its files, empty directories, modes, and modification times.
+func TestHandleArchiveTransfer(t *testing.T) {
+	dir := t.TempDir()
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	contents := map[string]string{"a.txt": "alpha", "sub/b.sh": "#!/bin/sh\n"}
+	archive := buildArchive(t, []tar.Header{
+		{Name: "empty/", Mode: 0700, ModTime: modTime},
+		{Name: "sub/", Mode: 0755, ModTime: modTime},
+		{Name: "a.txt", Mode: 0600, ModTime: modTime},
+		{Name: "sub/b.sh", Mode: 0755, ModTime: modTime},
+	}, contents)
+
+	status, message := sendArchive(t, dir, archive)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected success, got %q", message)
+	}
+	if checksum, ok := protocol.ParseTransferReceivedChecksum(message); !ok || !bytes.Equal(checksum, protocol.CalculateDataChecksum(archive)) {
+		t.Fatalf("expected the checksum of the archive in the response, got %q", message)
+	}
+
+	for name, content := range contents {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("expected %s with %q, got %q and %v", name, content, got, err)
+		}
+	}
+	for name, mode := range map[string]os.FileMode{"empty": 0700, "sub": 0755, "a.txt": 0600, "sub/b.sh": 0755} {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("expected %s to be extracted, got %v", name, err)
+		}
+		if info.Mode().Perm() != mode || !info.ModTime().Equal(modTime) {
+			t.Fatalf("expected %s with mode %v and time %v, got %v and %v", name, mode, modTime, info.Mode().Perm(), info.ModTime())
+		}
+	}
+	if info, err := os.Stat(filepath.Join(dir, "empty")); err != nil || !info.IsDir() {
+		t.Fatalf("expected the empty directory to be extracted, got %v", err)
+	}
+
+	// The spool file of the archive is removed.
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 3 {
+		t.Fatalf("expected only the extracted entries in the destination, got %v and %v", entries, err)
+	}
+}
+
+// TestHandleArchiveTransferNewerStrategy tests the handling of a tar archive transfer to ensure that
+// the "newer" strategy replaces only the existing files older than their entries.
+func TestHandleArchiveTransferNewerStrategy(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"strategy": StrategyNewer})
+	existingTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, name := range []string{"newer.txt", "older.txt", "equal.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+			t.Fatalf("failed to create the existing file: %v", err)
+		}
+		if err := os.Chtimes(path, existingTime, existingTime); err != nil {
+			t.Fatalf("failed to set the modification time: %v", err)
+		}
+	}
+	archive := buildArchive(t, []tar.Header{
+		{Name: "newer.txt", Mode: 0644, ModTime: existingTime.Add(time.Second)},
+		{Name: "older.txt", Mode: 0644, ModTime: existingTime.Add(-time.Second)},
+		{Name: "equal.txt", Mode: 0644, ModTime: existingTime},
+		{Name: "new.txt", Mode: 0644, ModTime: existingTime.Add(-time.Hour)},
+	}, map[string]string{"newer.txt": "received", "older.txt": "received", "equal.txt": "received", "new.txt": "received"})
+
+	status, message := sendArchive(t, dir, archive)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected success, got %q", message)
+	}
+	for name, expected := range map[string]string{"newer.txt": "received", "older.txt": "existing", "equal.txt": "existing", "new.txt": "received"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(content) != expected {
+			t.Fatalf("expected %s with %q, got %q and %v", name, expected, content, err)
+		}
+	}
+}
+
+// TestHandleArchiveTransferRejectsEscapingEntry tests the handling of a tar archive transfer to ensure that
+// an archive with an entry escaping the destination directory is rejected as a whole.
+func TestHandleArchiveTransferRejectsEscapingEntry(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "dest")
+	archive := buildArchive(t, []tar.Header{
+		{Name: "good.txt", Mode: 0644},
+		{Name: "../evil.txt", Mode: 0644},
+	}, map[string]string{"good.txt": "good", "../evil.txt": "evil"})
+
+	status, message := sendArchive(t, dir, archive)
+	if status != protocol.ResponseStatusError || !strings.Contains(message, "Invalid archive") {
+		t.Fatalf("expected the archive to be rejected, got %d: %q", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(root, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file outside the destination, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected nothing to be extracted, got %v and %v", entries, err)
+	}
+}
+
+// TestHandleArchiveTransferSkipsRootEntry tests the handling of a tar archive transfer to ensure that
+// an entry of the root directory itself ("./") leaves the mode and modification time of the root untouched,
+// and that a file entry naming the root is rejected.
+func TestHandleArchiveTransferSkipsRootEntry(t *testing.T) {
+	dir := t.TempDir()
+	before, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("failed to stat the destination: %v", err)
+	}
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	archive := buildArchive(t, []tar.Header{
+		{Name: "./", Mode: 0, ModTime: modTime},
+		{Name: "./a.txt", Mode: 0644, ModTime: modTime},
+	}, map[string]string{"./a.txt": "alpha"})
+	if status, message := sendArchive(t, dir, archive); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected success, got %q", message)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("failed to stat the destination: %v", err)
+	}
+	if info.Mode().Perm() != before.Mode().Perm() || info.ModTime().Equal(modTime) {
+		t.Fatalf("expected the destination to keep mode %v, got %v and time %v", before.Mode().Perm(), info.Mode().Perm(), info.ModTime())
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(content) != "alpha" {
+		t.Fatalf("expected a.txt to be extracted, got %q and %v", content, err)
+	}
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: ".", Typeflag: tar.TypeReg, Mode: 0644}); err != nil {
+		t.Fatalf("failed to write the archive entry: %v", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close the archive: %v", err)
+	}
+	if status, message := sendArchive(t, dir, buf.Bytes()); status != protocol.ResponseStatusError || !strings.Contains(message, "Invalid archive") {
+		t.Fatalf("expected the file entry of the root to be rejected, got %d: %q", status, message)
+	}
+}
+
+// TestHandleArchiveTransferRejectsPatterns tests the handling of a tar archive transfer to ensure that
+// an archive with a file matching a "-reject-pattern" is rejected as a whole.
+func TestHandleArchiveTransferRejectsPatterns(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"reject-pattern": "uploads/**/*.sh"})
+	archive := buildArchive(t, []tar.Header{
+		{Name: "uploads/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "uploads/readme.txt", Mode: 0644},
+		{Name: "uploads/bin/run.sh", Mode: 0755},
+	}, map[string]string{"uploads/readme.txt": "read me", "uploads/bin/run.sh": "#!/bin/sh"})
+
+	status, message := sendArchive(t, dir, archive)
+	if status != protocol.ResponseStatusError || !strings.Contains(message, "-reject-pattern uploads/**/*.sh") {
+		t.Fatalf("expected the archive to be rejected by the pattern, got %d: %q", status, message)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected nothing to be extracted, got %v and %v", entries, err)
+	}
+}
+
+// TestHandleArchiveTransferRejectsCorruptedStream tests the handling of a tar archive transfer to ensure that
+// nothing is extracted from an archive whose stream checksum does not match.
+func TestHandleArchiveTransferRejectsCorruptedStream(t *testing.T) {
+	dir := t.TempDir()
+	archive := buildArchive(t, []tar.Header{{Name: "a.txt", Mode: 0644}}, map[string]string{"a.txt": "alpha"})
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileName:     "tree",
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeTarArchive,
+	}, encodeStream(t, archive, true))
+	if status != protocol.ResponseStatusError || !strings.Contains(message, "integrity") {
+		t.Fatalf("expected a checksum error, got %d: %q", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be extracted, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/cases"
+)
+
+// Values of the "-case-insensitive" flag.
+const (
+	CaseModeAuto  = "auto"  // Probe the destination directory for a case-insensitive file system.
+	CaseModeTrue  = "true"  // Treat names differing only by case as the same file.
+	CaseModeFalse = "false" // Compare names byte for byte.
+)
+
+// A caseIndex remembers the names of the files in the destination directories by their case-folded form,
+// so that a received name can be matched against an existing one differing only by case ("Report.txt" and "report.txt")
+// without scanning its directory for every file. A directory is read once, the first time a name in it is looked up,
+// and the names stored by the server are added as they are created.
+type caseIndex struct {
+	mutex  sync.Mutex
+	folder cases.Caser                  // Case folding, which is stateful and so guarded by the mutex.
+	dirs   map[string]map[string]string // Names of the files by their folded name, by directory.
+}
+
+// caseFolding is the index of the "-case-insensitive" mode, or nil if names are compared byte for byte.
+var caseFolding *caseIndex
+
+// newCaseIndex returns an empty index.
+func newCaseIndex() *caseIndex {
+	return &caseIndex{folder: cases.Fold(), dirs: make(map[string]map[string]string)}
+}
+
+// lookup returns the path of the existing file whose name matches the base name of `path` regardless of case.
+// An indexed file removed since then (e.g. by a deletion request) is forgotten.
+func (ci *caseIndex) lookup(path string) (string, bool) {
+	if _, err := os.Lstat(path); err == nil {
+		return path, true
+	}
+
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	names := ci.load(dir)
+	folded := ci.folder.String(name)
+	actual, ok := names[folded]
+	if !ok {
+		return "", false
+	}
+	existing := filepath.Join(dir, actual)
+	if _, err := os.Lstat(existing); os.IsNotExist(err) {
+		delete(names, folded)
+		return "", false
+	}
+	return existing, true
+}
+
+// add records a file created at `path`. It does nothing on a nil index, so that it can be called in every mode.
+func (ci *caseIndex) add(path string) {
+	if ci == nil {
+		return
+	}
+	dir, name := filepath.Dir(path), filepath.Base(path)
+	ci.mutex.Lock()
+	defer ci.mutex.Unlock()
+	ci.load(dir)[ci.folder.String(name)] = name
+}
+
+// load returns the names of the directory by their folded name, reading the directory the first time.
+// A directory that cannot be read (e.g. one not created yet) starts empty. It must be called with the mutex held.
+func (ci *caseIndex) load(dir string) map[string]string {
+	if names, ok := ci.dirs[dir]; ok {
+		return names
+	}
+	names := make(map[string]string)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names[ci.folder.String(entry.Name())] = entry.Name()
+	}
+	ci.dirs[dir] = names
+	return names
+}
+
+// existingPath returns the path of the existing file that a file stored at `path` would conflict with:
+// `path` itself if it exists, or, in the "-case-insensitive" mode, a file whose name differs only by case.
+func existingPath(path string) (string, bool) {
+	if caseFolding != nil {
+		return caseFolding.lookup(path)
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", false
+	}
+	return path, true
+}
+
+// detectCaseInsensitive reports whether the file system of the directory, which is created if needed,
+// ignores the case of names, by creating a probe file and looking it up with its name in upper case.
+func detectCaseInsensitive(dir string) (bool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, fmt.Errorf("failed to create the destination directory: %v", err)
+	}
+	probe, err := os.CreateTemp(dir, ".filexfer-case-probe-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create the case probe file: %v", err)
+	}
+	defer func() {
+		_ = os.Remove(probe.Name())
+	}()
+	if err := probe.Close(); err != nil {
+		return false, fmt.Errorf("failed to close the case probe file: %v", err)
+	}
+
+	_, err = os.Lstat(filepath.Join(dir, strings.ToUpper(filepath.Base(probe.Name()))))
+	return err == nil, nil
+}
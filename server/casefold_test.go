@@ -0,0 +1,132 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// withCaseFolding enables the "-case-insensitive" mode with a new index for the duration of the test.
+func withCaseFolding(t *testing.T) {
+	t.Helper()
+
+	original := caseFolding
+	caseFolding = newCaseIndex()
+	t.Cleanup(func() { caseFolding = original })
+}
+
+// storedFiles returns the names and contents of the files in the directory.
+func storedFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read the destination directory: %v", err)
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", entry.Name(), err)
+		}
+		files[entry.Name()] = string(content)
+	}
+	return files
+}
+
+// TestCaseInsensitiveRenameStrategy tests the "-case-insensitive" mode to ensure that
+// names differing only by case are renamed with the rename strategy, also when the renamed name itself differs by case.
+func TestCaseInsensitiveRenameStrategy(t *testing.T) {
+	withFlags(t, map[string]string{"strategy": StrategyRename})
+	withCaseFolding(t)
+
+	dir := t.TempDir()
+	for _, name := range []string{"Report.txt", "report.txt", "REPORT.txt"} {
+		if status, message := sendFile(t, dir, name, []byte(name)); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response for %s, got %d: %s", name, status, message)
+		}
+	}
+
+	expected := map[string]string{"Report.txt": "Report.txt", "report_1.txt": "report.txt", "REPORT_2.txt": "REPORT.txt"}
+	if got := storedFiles(t, dir); !maps.Equal(got, expected) {
+		t.Fatalf("expected the stored files %v, got %v", expected, got)
+	}
+}
+
+// TestCaseInsensitiveOverwriteStrategy tests the "-case-insensitive" mode to ensure that
+// the overwrite strategy replaces a file differing only by case, and the skip strategy keeps it.
+func TestCaseInsensitiveOverwriteStrategy(t *testing.T) {
+	withCaseFolding(t)
+
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"strategy": StrategyOverwrite})
+	for _, name := range []string{"Report.txt", "report.txt"} {
+		if status, message := sendFile(t, dir, name, []byte(name)); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response for %s, got %d: %s", name, status, message)
+		}
+	}
+	if got := storedFiles(t, dir); !maps.Equal(got, map[string]string{"report.txt": "report.txt"}) {
+		t.Fatalf("expected report.txt to replace Report.txt, got %v", got)
+	}
+
+	withFlags(t, map[string]string{"strategy": StrategySkip})
+	if status, _ := sendFile(t, dir, "REPORT.TXT", []byte("skipped")); status != protocol.ResponseStatusSkipped {
+		t.Fatalf("expected REPORT.TXT to be skipped, got status %d", status)
+	}
+	if got := storedFiles(t, dir); !maps.Equal(got, map[string]string{"report.txt": "report.txt"}) {
+		t.Fatalf("expected report.txt to be kept, got %v", got)
+	}
+}
+
+// TestCaseIndex tests `caseIndex` to ensure that
+// existing files are found regardless of case, and that removed files are forgotten.
+func TestCaseIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "Existing.txt"), nil, 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	index := newCaseIndex()
+
+	if got, ok := index.lookup(filepath.Join(dir, "EXISTING.TXT")); !ok || got != filepath.Join(dir, "Existing.txt") {
+		t.Fatalf("expected Existing.txt to be found, got %q (%v)", got, ok)
+	}
+	if _, ok := index.lookup(filepath.Join(dir, "missing.txt")); ok {
+		t.Fatal("expected missing.txt not to be found")
+	}
+
+	// A file created after the directory was read is only known once added.
+	created := filepath.Join(dir, "Created.txt")
+	if err := os.WriteFile(created, nil, 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	index.add(created)
+	if got, ok := index.lookup(filepath.Join(dir, "created.txt")); !ok || got != created {
+		t.Fatalf("expected Created.txt to be found, got %q (%v)", got, ok)
+	}
+
+	if err := os.Remove(created); err != nil {
+		t.Fatalf("failed to remove the file: %v", err)
+	}
+	if _, ok := index.lookup(filepath.Join(dir, "created.txt")); ok {
+		t.Fatal("expected the removed Created.txt to be forgotten")
+	}
+}
+
+// TestDetectCaseInsensitive tests `detectCaseInsensitive` to ensure that
+// it probes the directory without leaving files behind, and reports a case-sensitive file system on Linux.
+func TestDetectCaseInsensitive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dest")
+	insensitive, err := detectCaseInsensitive(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if runtime.GOOS == "linux" && insensitive {
+		t.Fatal("expected the temporary directory to be case-sensitive on Linux")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("expected the probe file to be removed, got %v (%v)", entries, err)
+	}
+}
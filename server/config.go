@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configPath is the path of the configuration file, which cannot be set in the file itself.
+var configPath = Flags.String("config", "", "Path of a TOML configuration file setting the server flags by name (flags given on the command line take precedence); re-read on SIGHUP")
+
+// A uint64Setting is a `flag.Value` of a uint64 flag that a reload can change while connections read it.
+type uint64Setting struct {
+	atomic.Uint64
+}
+
+// newUint64Setting defines a reloadable uint64 flag with the given name, default value, and usage.
+func newUint64Setting(name string, value uint64, usage string) *uint64Setting {
+	setting := &uint64Setting{}
+	setting.Store(value)
+	Flags.Var(setting, name, usage)
+	return setting
+}
+
+// String returns the current value of the setting.
+func (s *uint64Setting) String() string {
+	return strconv.FormatUint(s.Load(), 10)
+}
+
+// Set parses and stores a new value of the setting.
+func (s *uint64Setting) Set(value string) error {
+	v, err := strconv.ParseUint(value, 0, 64)
+	if err != nil {
+		return err
+	}
+	s.Store(v)
+	return nil
+}
+
+// Get returns the current value of the setting, for `flag.Getter`.
+func (s *uint64Setting) Get() any {
+	return s.Load()
+}
+
+// A stringListFlag is a `flag.Value` that collects the values of a repeatable flag.
+type stringListFlag []string
+
+// newStringListFlag defines a repeatable string flag with the given name and usage.
+func newStringListFlag(name, usage string) *stringListFlag {
+	list := &stringListFlag{}
+	Flags.Var(list, name, usage)
+	return list
+}
+
+// String returns the collected values as a comma-separated list.
+func (s *stringListFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+// Set appends a value each time the flag is given.
+func (s *stringListFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// A certificateStore holds the TLS certificate presented to clients, which a reload can replace
+// without affecting established connections.
+type certificateStore struct {
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// serverCertificate is the TLS certificate of the server, served to new connections through `GetCertificate`.
+var serverCertificate certificateStore
+
+// load loads the certificate and private key from the files and replaces the stored certificate.
+// The stored certificate is kept if they fail to load.
+func (s *certificateStore) load(certFile, keyFile string) error {
+	certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the TLS certificate: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.certificate = &certificate
+	return nil
+}
+
+// getCertificate returns the stored certificate, for `tls.Config.GetCertificate`.
+func (s *certificateStore) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.certificate == nil {
+		return nil, fmt.Errorf("no TLS certificate loaded")
+	}
+	return s.certificate, nil
+}
+
+// loadConfig reads the configuration file, a TOML table of flag names and values, e.g.
+//
+//	port = "8443"
+//	dir = "/srv/incoming"
+//	max-dir-size = 10737418240
+func loadConfig(path string) (map[string]string, error) {
+	var config map[string]any
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return nil, fmt.Errorf("failed to read the configuration file %s: %v", path, err)
+	}
+
+	values := make(map[string]string, len(config))
+	for key, value := range config {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		case bool:
+			values[key] = strconv.FormatBool(v)
+		case int64:
+			values[key] = strconv.FormatInt(v, 10)
+		case float64:
+			values[key] = strconv.FormatFloat(v, 'g', -1, 64)
+		default:
+			return nil, fmt.Errorf("invalid configuration file %s: %s: unsupported value %v of type %T", path, key, value, value)
+		}
+	}
+	return values, nil
+}
+
+// configFlag returns the flag set by a key of the configuration file,
+// or nil (with a warning) if the key is not a server flag that the file can set.
+func configFlag(key string) *flag.Flag {
+	f := Flags.Lookup(key)
+	if f == nil || key == "config" {
+		slog.Warn("Ignoring an unknown key in the configuration file", "key", key, "path", *configPath)
+		return nil
+	}
+	return f
+}
+
+// applyConfig sets the flags of the configuration file at startup,
+// except those given on the command line (whose names are in `explicit`), which take precedence.
+func applyConfig(config map[string]string, explicit map[string]bool) error {
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		f := configFlag(key)
+		if f == nil || explicit[key] {
+			continue
+		}
+		if err := f.Value.Set(config[key]); err != nil {
+			return fmt.Errorf("invalid configuration file %s: %s: invalid value %q: %v", *configPath, key, config[key], err)
+		}
+	}
+	return nil
+}
+
+// reloadConfig re-reads the configuration file and applies the changes of the reloadable flags
+// (-tls-cert, -tls-key, and -max-dir-size), logging the changes of the other flags as requiring a restart.
+// Flags given on the command line are left as they are, and nothing is changed if the file or any of its reloadable values is invalid.
+func reloadConfig(explicit map[string]bool) error {
+	if *configPath == "" {
+		return fmt.Errorf("no configuration file (-config) to reload")
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	certFile, keyFile := *tlsCertFile, *tlsKeyFile
+	dirSize := maxDirectorySize.Load()
+	for _, key := range keys {
+		f := configFlag(key)
+		if f == nil || explicit[key] {
+			continue
+		}
+		value := config[key]
+		switch key {
+		case "tls-cert":
+			certFile = value
+		case "tls-key":
+			keyFile = value
+		case "max-dir-size":
+			size, err := strconv.ParseUint(value, 0, 64)
+			if err != nil || size == 0 {
+				return fmt.Errorf("invalid configuration file %s: %s: invalid value %q: must be a positive number of bytes", *configPath, key, value)
+			}
+			dirSize = size
+		default:
+			if value != f.Value.String() {
+				slog.Warn("The change in the configuration file requires a restart", "key", key, "path", *configPath, "kept", f.Value.String())
+			}
+		}
+	}
+
+	if certFile != *tlsCertFile || keyFile != *tlsKeyFile {
+		if *tlsCertFile == "" || certFile == "" || keyFile == "" {
+			slog.Warn("Enabling or disabling TLS in the configuration file requires a restart", "path", *configPath)
+			certFile, keyFile = *tlsCertFile, *tlsKeyFile
+		}
+	}
+	// Reload the certificate even if its paths are unchanged, since its files may have been replaced (e.g. renewed).
+	if certFile != "" {
+		if err := serverCertificate.load(certFile, keyFile); err != nil {
+			return err
+		}
+		*tlsCertFile, *tlsKeyFile = certFile, keyFile
+		slog.Info("Reloaded the TLS certificate", "path", certFile)
+	}
+
+	if dirSize != maxDirectorySize.Load() {
+		slog.Info("Directory size limit changed", "bytes", dirSize, "gb", toGB(dirSize))
+		maxDirectorySize.Store(dirSize)
+	}
+	return nil
+}
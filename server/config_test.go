@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withFlags sets the flags for the duration of the test and restores their original values afterwards.
+func withFlags(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	// The values of repeatable flags are restored as they were, since setting them again would append to them.
+	original := map[string]string{}
+	lists := map[*stringListFlag]stringListFlag{}
+	Flags.VisitAll(func(f *flag.Flag) {
+		if list, ok := f.Value.(*stringListFlag); ok {
+			lists[list] = *list
+			return
+		}
+		original[f.Name] = f.Value.String()
+	})
+	t.Cleanup(func() {
+		for name, value := range original {
+			_ = Flags.Set(name, value)
+		}
+		for list, value := range lists {
+			*list = value
+		}
+	})
+
+	for name, value := range values {
+		if err := Flags.Set(name, value); err != nil {
+			t.Fatalf("failed to set the flag -%s: %v", name, err)
+		}
+	}
+}
+
+// writeConfig writes the configuration file at the path given with the `-config` flag.
+func writeConfig(t *testing.T, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(*configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write the configuration file: %v", err)
+	}
+}
+
+// TestApplyConfig tests `applyConfig` to ensure that
+// the configuration file sets the flags not given on the command line.
+func TestApplyConfig(t *testing.T) {
+	withFlags(t, map[string]string{"config": filepath.Join(t.TempDir(), "server.toml"), "strategy": StrategySkip})
+	writeConfig(t, "port = \"9000\"\ndir = \"/srv/incoming\"\nstrategy = \"overwrite\"\nmax-dir-size = 1024\nflatten = true\nunknown = 1\n")
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := applyConfig(config, map[string]bool{"strategy": true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *listenPort != "9000" || *destDir != "/srv/incoming" || maxDirectorySize.Load() != 1024 || !*flatten {
+		t.Fatalf("expected the settings of the file, got port %q, dir %q, max-dir-size %d, flatten %v",
+			*listenPort, *destDir, maxDirectorySize.Load(), *flatten)
+	}
+	if *fileStrategy != StrategySkip {
+		t.Fatalf("expected the command-line strategy to take precedence, got %q", *fileStrategy)
+	}
+}
+
+// TestApplyConfigErrors tests `loadConfig` and `applyConfig` to ensure that
+// invalid files and values are rejected.
+func TestApplyConfigErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{"invalid TOML", "port = ", "failed to read"},
+		{"table value", "[tls]\ncert = \"a\"", "unsupported value"},
+		{"invalid value", "max-dir-size = \"big\"", "max-dir-size: invalid value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, map[string]string{"config": filepath.Join(t.TempDir(), "server.toml")})
+			writeConfig(t, tt.content)
+
+			config, err := loadConfig(*configPath)
+			if err == nil {
+				err = applyConfig(config, map[string]bool{})
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Fatalf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestReloadConfigCertificate tests `reloadConfig` to ensure that
+// new connections get the certificate of the reloaded configuration, and an invalid one keeps the current certificate.
+func TestReloadConfigCertificate(t *testing.T) {
+	firstCert, firstKey := generateTestCert(t)
+	secondCert, secondKey := generateTestCert(t)
+	withFlags(t, map[string]string{"config": filepath.Join(t.TempDir(), "server.toml")})
+	writeConfig(t, "tls-cert = \""+firstCert+"\"\ntls-key = \""+firstKey+"\"\n")
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := applyConfig(config, map[string]bool{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	served := func() []byte {
+		t.Helper()
+		cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cert.Certificate[0]
+	}
+	first := served()
+
+	writeConfig(t, "tls-cert = \""+secondCert+"\"\ntls-key = \""+secondKey+"\"\n")
+	if err := reloadConfig(map[string]bool{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	second := served()
+	if bytes.Equal(first, second) {
+		t.Fatal("expected the reloaded certificate to be served")
+	}
+	if *tlsCertFile != secondCert {
+		t.Fatalf("expected -tls-cert to be %s, got %s", secondCert, *tlsCertFile)
+	}
+
+	writeConfig(t, "tls-cert = \"/nonexistent/cert.crt\"\ntls-key = \"/nonexistent/key.key\"\n")
+	if err := reloadConfig(map[string]bool{}); err == nil {
+		t.Fatal("expected error for an invalid certificate, got nil")
+	}
+	if !bytes.Equal(served(), second) || *tlsCertFile != secondCert {
+		t.Fatal("expected the current certificate to be kept")
+	}
+}
+
+// TestReloadConfigLimits tests `reloadConfig` to ensure that
+// the size limits are reloaded, while the settings that require a restart and the command-line flags are kept.
+func TestReloadConfigLimits(t *testing.T) {
+	withFlags(t, map[string]string{"config": filepath.Join(t.TempDir(), "server.toml"), "port": "9000", "dir": "in"})
+
+	writeConfig(t, "port = \"9001\"\ndir = \"elsewhere\"\nmax-dir-size = 2048\n")
+	if err := reloadConfig(map[string]bool{"dir": true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if maxDirectorySize.Load() != 2048 {
+		t.Fatalf("expected the reloaded size limit, got %d", maxDirectorySize.Load())
+	}
+	if *listenPort != "9000" || *destDir != "in" {
+		t.Fatalf("expected the port and directory to be kept, got %q and %q", *listenPort, *destDir)
+	}
+
+	writeConfig(t, "max-dir-size = 0\n")
+	if err := reloadConfig(map[string]bool{}); err == nil {
+		t.Fatal("expected error for an invalid size limit, got nil")
+	}
+	if maxDirectorySize.Load() != 2048 {
+		t.Fatalf("expected the size limit to be kept, got %d", maxDirectorySize.Load())
+	}
+
+	if err := reloadConfig(map[string]bool{"max-dir-size": true}); err != nil {
+		t.Fatalf("expected a command-line size limit to ignore the file, got %v", err)
+	}
+}
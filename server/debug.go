@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+)
+
+// Counters of the server published at "/debug/vars" of the debug endpoint ("-debug-addr").
+var (
+	activeConnections = expvar.NewInt("active_connections") // Number of client connections being handled.
+	bytesInFlight     = expvar.NewInt("bytes_in_flight")    // Number of bytes read by transfers that are still in progress.
+	memoryInUse       = expvar.NewInt("memory_in_use")      // Number of bytes of buffers acquired by the connections (see "-max-memory").
+)
+
+// init publishes the number of clients with a directory transfer in progress, read from `directorySizes` on demand.
+func init() {
+	expvar.Publish("directory_transfers", expvar.Func(func() any {
+		dirSizeMutex.RLock()
+		defer dirSizeMutex.RUnlock()
+		return len(directorySizes)
+	}))
+}
+
+// isLoopbackHost reports whether the host is "localhost" or a loopback IP address, such as 127.0.0.1 or ::1.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
+
+// newDebugHandler returns the handler of the debug endpoint: the `net/http/pprof` profiles under "/debug/pprof/"
+// and the `expvar` counters (with the memory statistics of the runtime) at "/debug/vars".
+func newDebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// startHTTPServer listens on the address and serves the handler of an endpoint (named in the logs) in the background.
+// The listener is bound before returning, so that an address in use is reported at startup.
+func startHTTPServer(name, addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on the %s address %s: %v", name, addr, err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: ReadTimeout,
+	}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("The HTTP endpoint stopped", "endpoint", name, "error", err)
+		}
+	}()
+	slog.Info("Serving the HTTP endpoint", "endpoint", name, "addr", listener.Addr().String())
+	return server, nil
+}
+
+// shutdownHTTPServer stops an endpoint started by `startHTTPServer`, waiting up to `HTTPDrainTimeout`
+// for its requests in progress (e.g. a CPU profile) before closing their connections.
+func shutdownHTTPServer(name string, server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), HTTPDrainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Error shutting down the HTTP endpoint", "endpoint", name, "error", err)
+		_ = server.Close()
+	}
+	slog.Info("HTTP endpoint closed", "endpoint", name)
+}
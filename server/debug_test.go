@@ -0,0 +1,63 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestIsLoopbackHost tests `isLoopbackHost` with loopback and other hosts.
+func TestIsLoopbackHost(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"127.0.0.1", true},
+		{"127.1.2.3", true},
+		{"::1", true},
+		{"localhost", true},
+		{"", false},
+		{"0.0.0.0", false},
+		{"192.0.2.1", false},
+		{"example.com", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopbackHost(tt.host); got != tt.expected {
+			t.Errorf("isLoopbackHost(%q) = %v; want %v", tt.host, got, tt.expected)
+		}
+	}
+}
+
+// TestDebugHandler tests `newDebugHandler` to ensure that
+// the pprof index and the expvar counters of the server are served.
+func TestDebugHandler(t *testing.T) {
+	server := httptest.NewServer(newDebugHandler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/debug/pprof/")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("expected the pprof index, got status %d", response.StatusCode)
+	}
+
+	activeConnections.Add(1)
+	defer activeConnections.Add(-1)
+	response, err = http.Get(server.URL + "/debug/vars")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	var vars map[string]any
+	if err := json.NewDecoder(response.Body).Decode(&vars); err != nil {
+		t.Fatalf("expected the expvar counters as JSON: %v", err)
+	}
+	if vars["active_connections"] != 1.0 || vars["bytes_in_flight"] == nil || vars["directory_transfers"] == nil || vars["memstats"] == nil {
+		t.Fatalf("expected the counters of the server, got %v", vars)
+	}
+}
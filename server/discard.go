@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// handleDiscardTransfer receives the content of a file, stream, or archive transfer in the "-discard" mode:
+// the content is read, decompressed, and verified against its checksums as it would be to be stored,
+// but nothing is written, so that the throughput of the network and of the protocol is measured without the disk's.
+// Ranges are refused, since their content is only verified once the file is assembled on disk.
+// The outcome is written to the access log through `record`, without a path.
+// The content is read from the connection through `ctxReader`, which tracks the transfer until its `release`.
+// It returns whether the connection can be used for further requests.
+func handleDiscardTransfer(ctxReader *contextReader, conn net.Conn, header *protocol.Header, logger *slog.Logger, record *accessRecord, buffer []byte) bool {
+	startTime := time.Now()
+	logger = logger.With("file_name", header.FileName)
+
+	if header.TransferType == protocol.TransferTypeRange {
+		logger.Warn("Refusing a range transfer in discard mode")
+		record.fail(conn, "Range transfers are not accepted with -discard")
+		return false
+	}
+
+	// The content of a stream (or an archive, which is sent as one) is followed by its checksum, verified by the `StreamReader`.
+	isStream := header.TransferType == protocol.TransferTypeStream || header.TransferType == protocol.TransferTypeTarArchive
+	maxSize := uint64(MaxFileSize)
+	if header.TransferType == protocol.TransferTypeTarArchive {
+		maxSize = maxDirectorySize.Load()
+	}
+	var contentReader io.Reader = protocol.NewContentReader(ctxReader, int64(header.FileSize))
+	if isStream {
+		contentReader = protocol.NewStreamReader(ctxReader, maxSize)
+	}
+	logger.Info("Receiving content to discard", "bytes", header.FileSize, "stream", isStream)
+
+	var compressedReader *protocol.CompressedReader
+	if header.Compression != protocol.CompressionNone {
+		var err error
+		compressedReader, err = protocol.NewCompressedReader(ctxReader, header.Compression, uint64(MaxFileSize))
+		if err != nil {
+			logger.Error("Failed to start decompressing the content", "error", err)
+			record.fail(conn, "Failed to decompress file content")
+			return false
+		}
+		defer func() {
+			if err := compressedReader.Close(); err != nil {
+				logger.Warn("Error closing the decompressor", "error", err)
+			}
+		}()
+		contentReader = io.LimitReader(compressedReader, int64(header.FileSize)+1)
+	}
+
+	hasher := sha256.New()
+	var hashWriter io.Writer = hasher
+	var blockHasher *protocol.BlockHasher
+	if header.BlockSize != 0 {
+		blockHasher = protocol.NewBlockHasher(header.BlockSize, header.BlockChecksums)
+		hashWriter = io.MultiWriter(hasher, blockHasher)
+	}
+
+	bytesRead, err := io.CopyBuffer(io.Discard, io.TeeReader(contentReader, hashWriter), buffer)
+	record.entry.Bytes = bytesRead
+	var blockErr *protocol.BlockMismatchError
+	if err == nil && blockHasher != nil {
+		_, err = blockHasher.Sum()
+	}
+	if err != nil {
+		logger.Error("Failed to receive the content", "bytes", bytesRead, "error", err)
+		switch {
+		case ctxReader.ctx.Err() != nil:
+			record.abort(conn, logger)
+		case errors.As(err, &blockErr):
+			// The rest of the content is read, so that the connection can carry the next file.
+			_, drainErr := io.CopyBuffer(io.Discard, contentReader, buffer)
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, protocol.BlockMismatchMessage(blockErr.Index))
+			return drainErr == nil
+		case errors.Is(err, protocol.ErrStreamTooLarge):
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeFileTooLarge, fmt.Sprintf("Stream exceeds the maximum allowed size of %d bytes", maxSize))
+		case errors.Is(err, protocol.ErrChecksumMismatch):
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+		case errors.Is(err, protocol.ErrIncompleteContent):
+			record.fail(conn, "File size mismatch: "+protocol.ErrIncompleteContent.Error())
+		default:
+			record.fail(conn, "Failed to receive file content")
+		}
+		return false
+	}
+
+	if !isStream && bytesRead != int64(header.FileSize) {
+		logger.Error("File size mismatch", "expected_bytes", header.FileSize, "bytes", bytesRead)
+		record.fail(conn, "File size mismatch")
+		return false
+	}
+	checksum := hasher.Sum(nil)
+	if !isStream && !bytes.Equal(checksum, header.Checksum) {
+		logger.Error("Data checksum verification failed",
+			"expected_checksum", hex.EncodeToString(header.Checksum), "checksum", hex.EncodeToString(checksum))
+		record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+		return false
+	}
+
+	// The response does not confirm that the file is stored, so that the client does not delete or archive its source.
+	sendSuccessResponse(conn, protocol.TransferDiscardedMessage(checksum))
+	record.complete("", bytesRead, checksum)
+	duration := time.Since(startTime)
+	logger.Info("Discarded the verified content", "bytes", bytesRead, "duration_ms", duration.Milliseconds(),
+		"mb_per_sec", fmt.Sprintf("%.2f", float64(bytesRead)/(1024*1024)/max(duration.Seconds(), 1e-9)))
+	return true
+}
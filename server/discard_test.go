@@ -0,0 +1,51 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"os"
+	"testing"
+)
+
+// TestDiscardTransfers tests the "-discard" mode to ensure that
+// files and streams are verified and acknowledged without being stored, that corrupted content is still refused,
+// and that ranges are refused.
+func TestDiscardTransfers(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"discard": "true"})
+	content := []byte("content to discard")
+	expected := protocol.TransferDiscardedMessage(protocol.CalculateDataChecksum(content))
+
+	if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess || message != expected {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if status, message := sendStream(t, dir, "b.txt", encodeStream(t, content, false)); status != protocol.ResponseStatusSuccess || message != expected {
+		t.Fatalf("expected the stream to be acknowledged, got %d: %s", status, message)
+	}
+	if status, message := sendStream(t, dir, "c.txt", encodeStream(t, content, true)); status != protocol.ResponseStatusError {
+		t.Fatalf("expected the corrupted stream to be refused, got %d: %s", status, message)
+	}
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "d.txt",
+		Checksum:     protocol.CalculateDataChecksum([]byte("other content")),
+		TransferType: protocol.TransferTypeFile,
+	}, content)
+	if status != protocol.ResponseStatusError || message != "Data integrity check failed" {
+		t.Fatalf("expected the corrupted file to be refused, got %d: %s", status, message)
+	}
+
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0)
+	if status, message := sendRange(t, dir, "e.txt", content, ranges[0]); status != protocol.ResponseStatusError {
+		t.Fatalf("expected the range to be refused, got %d: %s", status, message)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read the destination directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected nothing stored, got %v", entries)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DiskCheckInterval is the interval at which "-min-free-percent" measures the free space of the destination volume again.
+const DiskCheckInterval = time.Second
+
+// volumeSpace returns the bytes available to the server and the total bytes of the file system of the directory.
+// It is a variable so that tests can simulate a volume filling up.
+var volumeSpace = statVolume
+
+// statVolume returns the bytes available to the server and the total bytes of the file system of the directory.
+// A directory not created yet is measured at its nearest existing parent.
+func statVolume(dir string) (free, total uint64, err error) {
+	var stat syscall.Statfs_t
+	for {
+		err := syscall.Statfs(dir, &stat)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, syscall.ENOENT) || parent == dir {
+			return 0, 0, err
+		}
+		dir = parent
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), uint64(stat.Blocks) * uint64(stat.Bsize), nil
+}
+
+// A diskGuard tracks whether the destination volume has less free space than "-min-free-percent",
+// in which case new transfers are refused until space is freed.
+// The space is measured on demand, at most once per `interval`, so that a burst of transfers does not stat the volume for each.
+type diskGuard struct {
+	mutex    sync.Mutex
+	interval time.Duration // Minimum interval between two measures of the free space.
+	checked  time.Time     // Time of the last measure.
+	full     bool          // Whether the free space was below the threshold at the last measure.
+}
+
+// diskState tracks the free space of the destination volume for "-min-free-percent".
+var diskState = &diskGuard{interval: DiskCheckInterval}
+
+// isFull reports whether the volume of the directory has less than `minPercent` percent of its space free,
+// logging the changes between the two states. It is always false if `minPercent` is 0 (the guard is off).
+// A failed measure keeps the previous state.
+func (dg *diskGuard) isFull(dir string, minPercent float64) bool {
+	if minPercent <= 0 {
+		return false
+	}
+
+	dg.mutex.Lock()
+	defer dg.mutex.Unlock()
+	if !dg.checked.IsZero() && time.Since(dg.checked) < dg.interval {
+		return dg.full
+	}
+	dg.checked = time.Now()
+
+	free, total, err := volumeSpace(dir)
+	if err != nil || total == 0 {
+		slog.Warn("Failed to measure the free space of the destination volume", "dir", dir, "error", err)
+		return dg.full
+	}
+	freePercent := float64(free) / float64(total) * 100
+	full := freePercent < minPercent
+	switch {
+	case full && !dg.full:
+		slog.Warn("The destination volume is nearly full, refusing new transfers", "dir", dir,
+			"free_bytes", free, "free_percent", freePercent, "min_free_percent", minPercent)
+	case !full && dg.full:
+		slog.Info("Space was freed on the destination volume, accepting transfers again", "dir", dir,
+			"free_bytes", free, "free_percent", freePercent, "min_free_percent", minPercent)
+	}
+	dg.full = full
+	return full
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withVolumeSpace replaces the free space measure with `free` percent of a 1000-byte volume for the duration of the test,
+// with a guard that measures it on every transfer. It returns the number of measures made.
+func withVolumeSpace(t *testing.T, free *uint64) *int {
+	t.Helper()
+
+	originalSpace, originalState := volumeSpace, diskState
+	measures := 0
+	volumeSpace = func(dir string) (uint64, uint64, error) {
+		measures++
+		return *free * 10, 1000, nil
+	}
+	diskState = &diskGuard{}
+	t.Cleanup(func() {
+		volumeSpace, diskState = originalSpace, originalState
+	})
+	return &measures
+}
+
+// TestMinFreePercentRefusesTransfers tests `handleConnection` with "-min-free-percent" to ensure that
+// transfers are refused with the server full response while the volume is below the threshold, and allowed again once space is freed.
+func TestMinFreePercentRefusesTransfers(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"min-free-percent": "10"})
+	free := uint64(5)
+	withVolumeSpace(t, &free)
+
+	status, message := sendFile(t, dir, "full.txt", []byte("refused"))
+	if status != protocol.ResponseStatusRetryLater || message != protocol.ServerFullMessage {
+		t.Fatalf("expected the server full response, got %d: %q", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "full.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the refused file not to be stored, got %v", err)
+	}
+
+	free = 10
+	status, message = sendFile(t, dir, "freed.txt", []byte("stored"))
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the transfer to be allowed once space is freed, got %d: %q", status, message)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "freed.txt")); err != nil || string(content) != "stored" {
+		t.Fatalf("expected freed.txt to be stored, got %q and %v", content, err)
+	}
+}
+
+// TestDiskGuardInterval tests `diskGuard` to ensure that
+// the free space is measured at most once per interval, and not at all when the guard is off.
+func TestDiskGuardInterval(t *testing.T) {
+	free := uint64(5)
+	measures := withVolumeSpace(t, &free)
+	guard := &diskGuard{interval: time.Hour}
+
+	if guard.isFull("dir", 0) || *measures != 0 {
+		t.Fatalf("expected the disabled guard not to measure the volume, got %d measures", *measures)
+	}
+	if !guard.isFull("dir", 10) {
+		t.Fatal("expected 5% free to be below 10%")
+	}
+	free = 50
+	if !guard.isFull("dir", 10) || *measures != 1 {
+		t.Fatalf("expected the state of the first measure within the interval, got %d measures", *measures)
+	}
+}
+
+// TestDiskGuardMeasureFailure tests `diskGuard` to ensure that
+// a failed measure keeps the previous state.
+func TestDiskGuardMeasureFailure(t *testing.T) {
+	free := uint64(5)
+	withVolumeSpace(t, &free)
+	guard := &diskGuard{}
+	if !guard.isFull("dir", 10) {
+		t.Fatal("expected 5% free to be below 10%")
+	}
+
+	volumeSpace = func(dir string) (uint64, uint64, error) {
+		return 0, 0, errors.New("statfs failed")
+	}
+	if !guard.isFull("dir", 10) {
+		t.Fatal("expected a failed measure to keep the volume full")
+	}
+}
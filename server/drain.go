@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"filexfer/protocol"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An activeTransfer is a transfer whose content is being received, listed by the drain logging on shutdown.
+type activeTransfer struct {
+	id         string       // Identifier of the transfer, as in the logs.
+	clientAddr string       // Address of the client.
+	fileName   string       // Name of the file (or archive) as sent by the client.
+	size       uint64       // Size announced by the header (0 for a stream or an archive of unknown size).
+	startTime  time.Time    // Time the transfer started.
+	bytes      atomic.Int64 // Number of bytes of content received so far.
+}
+
+// A transferRegistry tracks the transfers in progress across all connections.
+type transferRegistry struct {
+	mutex     sync.Mutex
+	transfers map[*activeTransfer]struct{}
+}
+
+// activeTransfers tracks the transfers in progress, so that a shutdown can report the ones it is waiting for.
+var activeTransfers = &transferRegistry{transfers: make(map[*activeTransfer]struct{})}
+
+// start registers a transfer whose content is about to be received.
+func (tr *transferRegistry) start(id, clientAddr, fileName string, size uint64) *activeTransfer {
+	transfer := &activeTransfer{id: id, clientAddr: clientAddr, fileName: fileName, size: size, startTime: time.Now()}
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	tr.transfers[transfer] = struct{}{}
+	return transfer
+}
+
+// finish removes a finished (or failed) transfer.
+func (tr *transferRegistry) finish(transfer *activeTransfer) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	delete(tr.transfers, transfer)
+}
+
+// list returns the transfers in progress, oldest first.
+func (tr *transferRegistry) list() []*activeTransfer {
+	tr.mutex.Lock()
+	transfers := make([]*activeTransfer, 0, len(tr.transfers))
+	for transfer := range tr.transfers {
+		transfers = append(transfers, transfer)
+	}
+	tr.mutex.Unlock()
+
+	slices.SortFunc(transfers, func(a, b *activeTransfer) int {
+		return a.startTime.Compare(b.startTime)
+	})
+	return transfers
+}
+
+// A shutdownCounter tracks a shutdown in progress and counts the outcomes of the requests it affects, for its final log.
+type shutdownCounter struct {
+	started   atomic.Bool  // Whether the shutdown has begun, after which new requests are refused.
+	completed atomic.Int64 // Number of transfers completed since the shutdown began.
+	aborted   atomic.Int64 // Number of transfers failed (e.g. interrupted at the shutdown timeout) since the shutdown began.
+	notified  atomic.Int64 // Number of clients sent the shutdown response (`protocol.ShutdownMessage`).
+}
+
+// shutdownState tracks the shutdown of the server.
+var shutdownState = &shutdownCounter{}
+
+// begin marks the shutdown as started.
+func (sc *shutdownCounter) begin() {
+	sc.started.Store(true)
+}
+
+// inProgress reports whether the shutdown has begun.
+func (sc *shutdownCounter) inProgress() bool {
+	return sc.started.Load()
+}
+
+// record counts a finished transfer if the shutdown has begun.
+func (sc *shutdownCounter) record(failed bool) {
+	if !sc.inProgress() {
+		return
+	}
+	if failed {
+		sc.aborted.Add(1)
+	} else {
+		sc.completed.Add(1)
+	}
+}
+
+// logSummary logs the counts of the shutdown.
+func (sc *shutdownCounter) logSummary() {
+	slog.Info("Shutdown summary", "completed_transfers", sc.completed.Load(), "aborted_transfers", sc.aborted.Load(),
+		"notified_clients", sc.notified.Load())
+}
+
+// notifyShutdown sends the shutdown response, which tells the client to retry its request later, and counts the notified client.
+func notifyShutdown(conn net.Conn, logger *slog.Logger) {
+	if err := protocol.WriteCodedResponse(conn, protocol.ResponseStatusRetryLater, protocol.ErrorCodeShuttingDown, protocol.ShutdownMessage); err != nil {
+		logger.Warn("Failed to notify the client of the shutdown", "error", err)
+		return
+	}
+	shutdownState.notified.Add(1)
+}
+
+// drainTransfers waits for `done` to be closed, i.e. for the connections to finish, up to `timeout`,
+// logging the transfers still in progress every `interval` so that an operator can decide whether to wait.
+// It returns whether the connections finished before the timeout.
+func drainTransfers(done <-chan struct{}, timeout, interval time.Duration) bool {
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return true
+		case <-deadline.C:
+			logActiveTransfers(slog.LevelWarn, "Transfer still in progress at the shutdown timeout")
+			return false
+		case <-ticker.C:
+			transfers := activeTransfers.list()
+			if len(transfers) == 0 {
+				continue
+			}
+			slog.Info("Draining active transfers", "active_transfers", len(transfers),
+				"remaining_ms", (timeout - time.Since(start)).Milliseconds())
+			logActiveTransfers(slog.LevelInfo, "Transfer still in progress")
+		}
+	}
+}
+
+// logActiveTransfers logs every transfer in progress with the message at the level.
+func logActiveTransfers(level slog.Level, msg string) {
+	for _, transfer := range activeTransfers.list() {
+		slog.Log(context.Background(), level, msg, "transfer_id", transfer.id, "client_addr", transfer.clientAddr,
+			"file_name", transfer.fileName, "bytes", transfer.bytes.Load(), "expected_bytes", transfer.size, "duration_ms", time.Since(transfer.startTime).Milliseconds())
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"filexfer/protocol"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startSlowTransfer starts a transfer of `slow.bin` on a pipe with the context, sends only the first `sent` bytes of its content,
+// and waits until the server has read them. It returns the client end of the pipe, which finishes the transfer when closed,
+// and a channel closed once the connection is handled.
+func startSlowTransfer(t *testing.T, ctx context.Context, sent int) (net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	content := bytes.Repeat([]byte("x"), 1000)
+	var buf bytes.Buffer
+	if err := protocol.WriteHeader(&buf, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "slow.bin",
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+	}); err != nil {
+		t.Fatalf("failed to encode the header: %v", err)
+	}
+	buf.Write(content[:sent])
+
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go handleConnection(ctx, serverConn, &wg)
+	go func() {
+		_, _ = clientConn.Write(buf.Bytes())
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		transfers := activeTransfers.list()
+		if len(transfers) == 1 && transfers[0].bytes.Load() == int64(sent) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected one active transfer with %d bytes received, got %d transfers", sent, len(transfers))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return clientConn, done
+}
+
+// TestDrainTransfersLogsActiveTransfer tests `drainTransfers` to ensure that
+// a transfer still in progress during a shutdown is logged with its progress until the timeout.
+func TestDrainTransfersLogsActiveTransfer(t *testing.T) {
+	withFlags(t, map[string]string{"dir": t.TempDir()})
+	entries := captureLogs(t)
+
+	clientConn, done := startSlowTransfer(t, context.Background(), 100)
+	if drainTransfers(done, 300*time.Millisecond, 50*time.Millisecond) {
+		t.Fatal("expected the drain to time out while the transfer is stalled")
+	}
+
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	<-done
+	if transfers := activeTransfers.list(); len(transfers) != 0 {
+		t.Fatalf("expected no active transfer once the connection is closed, got %d", len(transfers))
+	}
+
+	logs := entries()
+	if entry := findLog(t, logs, "Draining active transfers"); entry["active_transfers"] != float64(1) {
+		t.Fatalf("expected one active transfer to be reported, got %v", entry)
+	}
+	for _, msg := range []string{"Transfer still in progress", "Transfer still in progress at the shutdown timeout"} {
+		entry := findLog(t, logs, msg)
+		if entry["file_name"] != "slow.bin" || entry["bytes"] != float64(100) || entry["expected_bytes"] != float64(1000) {
+			t.Fatalf("expected %q to report slow.bin with 100 of 1000 bytes, got %v", msg, entry)
+		}
+	}
+}
+
+// TestDrainTransfersCompleted tests `drainTransfers` to ensure that
+// it returns as soon as the connections finish, before the timeout.
+func TestDrainTransfersCompleted(t *testing.T) {
+	withFlags(t, map[string]string{"dir": t.TempDir()})
+
+	clientConn, done := startSlowTransfer(t, context.Background(), 10)
+	time.AfterFunc(100*time.Millisecond, func() {
+		_ = clientConn.Close()
+	})
+
+	start := time.Now()
+	if !drainTransfers(done, 10*time.Second, 20*time.Millisecond) {
+		t.Fatal("expected the drain to complete once the connection is closed")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the drain to return when the connection finished, took %v", elapsed)
+	}
+}
+
+// withShutdown starts a shutdown for the duration of the test, with counts starting at zero.
+func withShutdown(t *testing.T) *shutdownCounter {
+	t.Helper()
+
+	original := shutdownState
+	shutdownState = &shutdownCounter{}
+	shutdownState.begin()
+	t.Cleanup(func() {
+		shutdownState = original
+	})
+	return shutdownState
+}
+
+// TestShutdownRefusesNewRequests tests `handleConnection` to ensure that
+// a request received once a shutdown has begun is answered with the shutdown response instead of being handled.
+func TestShutdownRefusesNewRequests(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+	state := withShutdown(t)
+
+	status, message := sendFile(t, dir, "late.txt", []byte("late"))
+	if status != protocol.ResponseStatusRetryLater || message != protocol.ShutdownMessage {
+		t.Fatalf("expected the shutdown response, got status %d and message %q", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "late.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the refused file not to be stored, got %v", err)
+	}
+	if state.notified.Load() != 1 || state.completed.Load() != 0 || state.aborted.Load() != 0 {
+		t.Fatalf("expected 1 notified client and no transfer, got %d notified, %d completed, and %d aborted",
+			state.notified.Load(), state.completed.Load(), state.aborted.Load())
+	}
+}
+
+// TestShutdownTimeoutNotifiesInterruptedTransfer tests `handleConnection` to ensure that
+// a transfer in progress when the shutdown times out is answered with the shutdown response and counted as aborted.
+func TestShutdownTimeoutNotifiesInterruptedTransfer(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clientConn, done := startSlowTransfer(t, ctx, 100)
+	state := withShutdown(t)
+
+	status, _ := sendFile(t, dir, "quick.txt", []byte("quick"))
+	if status != protocol.ResponseStatusRetryLater {
+		t.Fatal("expected a new transfer to be refused during the shutdown")
+	}
+
+	// The server checks the context before its next read, which the byte sent after the cancellation unblocks.
+	cancel()
+	go func() {
+		_, _ = clientConn.Write([]byte("x"))
+	}()
+	status, message, err := protocol.ReadResponse(clientConn)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	if status != protocol.ResponseStatusRetryLater || message != protocol.ShutdownMessage {
+		t.Fatalf("expected the shutdown response, got status %d and message %q", status, message)
+	}
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	<-done
+
+	if _, err := os.Stat(filepath.Join(dir, "slow.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the interrupted file to be removed, got %v", err)
+	}
+	if state.notified.Load() != 2 || state.aborted.Load() != 1 {
+		t.Fatalf("expected 2 notified clients and 1 aborted transfer, got %d and %d", state.notified.Load(), state.aborted.Load())
+	}
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncContent reports whether the content of a received file is synced to stable storage before the file is
+// acknowledged: with "-fsync", or with "-fsync-quarantine" for a file received into quarantine.
+func syncContent(quarantined bool) bool {
+	return *fsyncFiles || (quarantined && *fsyncQuarantine)
+}
+
+// syncPath syncs the content of the file at `path` to stable storage, e.g. a file written through other descriptors.
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = file.Sync()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// syncDir syncs the directory at `path` to stable storage, which makes the entries created, renamed, or removed in it
+// durable: a file whose content is synced can still vanish in a crash if its directory entry is not.
+func syncDir(path string) error {
+	if err := syncPath(path); err != nil {
+		return fmt.Errorf("failed to sync the directory %s: %w", path, err)
+	}
+	return nil
+}
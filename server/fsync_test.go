@@ -0,0 +1,114 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// TestSyncDir tests `syncDir` to ensure that
+// a directory is synced, and that a missing one fails with its path.
+func TestSyncDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := syncDir(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	missing := filepath.Join(dir, "missing")
+	if err := syncDir(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected the missing directory to fail, got %v", err)
+	}
+}
+
+// TestFsyncStoresFiles tests the "-fsync" and "-fsync-quarantine" flags to ensure that
+// files received whole, as ranges, and through quarantine are stored and acknowledged as without them.
+func TestFsyncStoresFiles(t *testing.T) {
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	expected := protocol.TransferReceivedMessage(protocol.CalculateDataChecksum(content))
+
+	t.Run("file", func(t *testing.T) {
+		dir := t.TempDir()
+		withFlags(t, map[string]string{"fsync": "true"})
+		if status, message := sendFile(t, dir, "sub/a.txt", content); status != protocol.ResponseStatusSuccess || message != expected {
+			t.Fatalf("expected a success response, got %d: %s", status, message)
+		}
+		if got, err := os.ReadFile(filepath.Join(dir, "sub", "a.txt")); err != nil || string(got) != string(content) {
+			t.Fatalf("expected the file stored, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("ranges", func(t *testing.T) {
+		dir := t.TempDir()
+		withFlags(t, map[string]string{"fsync": "true"})
+		ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0)
+		sendRange(t, dir, "a.txt", content, ranges[0])
+		if status, message := sendRange(t, dir, "a.txt", content, ranges[1]); status != protocol.ResponseStatusSuccess || message != expected {
+			t.Fatalf("expected the assembled file to be acknowledged, got %d: %s", status, message)
+		}
+		if got, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(got) != string(content) {
+			t.Fatalf("expected the assembled file stored, got %q (%v)", got, err)
+		}
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		dir := t.TempDir()
+		quarantine := withQuarantine(t, map[string]string{"fsync": "true", "fsync-quarantine": "true"})
+		if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess || message != expected {
+			t.Fatalf("expected a success response, got %d: %s", status, message)
+		}
+		if got, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(got) != string(content) {
+			t.Fatalf("expected the file released, got %q (%v)", got, err)
+		}
+
+		status, _ := sendRequest(t, dir, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(content)),
+			FileName:     "bad.txt",
+			Checksum:     protocol.CalculateDataChecksum([]byte("expected")),
+			TransferType: protocol.TransferTypeFile,
+		}, content)
+		if status != protocol.ResponseStatusError {
+			t.Fatal("expected an error response for a checksum mismatch")
+		}
+		readRejection(t, quarantine)
+	})
+}
+
+// BenchmarkReceiveFsync compares the throughput of receiving small and large files with and without "-fsync",
+// whose cost depends on the storage of the temporary directory.
+func BenchmarkReceiveFsync(b *testing.B) {
+	originalLogger, originalProgress := slog.Default(), *progress
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	*progress = protocol.ProgressModeNone
+	b.Cleanup(func() {
+		slog.SetDefault(originalLogger)
+		*progress = originalProgress
+	})
+
+	for _, size := range []int{64 * 1024, 16 * 1024 * 1024} {
+		content := make([]byte, size)
+		for _, fsync := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%dKB/fsync=%t", size/1024, fsync), func(b *testing.B) {
+				original := *fsyncFiles
+				if err := Flags.Set("fsync", strconv.FormatBool(fsync)); err != nil {
+					b.Fatalf("failed to set the flag: %v", err)
+				}
+				b.Cleanup(func() { *fsyncFiles = original })
+
+				dir := b.TempDir()
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					if status, message := sendFile(b, dir, fmt.Sprintf("file%d.bin", i), content); status != protocol.ResponseStatusSuccess {
+						b.Fatalf("expected a success response, got %d: %s", status, message)
+					}
+				}
+			})
+		}
+	}
+}
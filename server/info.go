@@ -0,0 +1,55 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// serverStartTime is when the server started, from which the uptime reported to ping requests is measured.
+var serverStartTime = time.Now()
+
+// handlePingRequest answers a ping request (`protocol.MessageTypePing`) with the version and the uptime of the server,
+// so that a load balancer or a monitoring probe can check that the server is up without transferring a file.
+func handlePingRequest(conn net.Conn, logger *slog.Logger) {
+	logger.Debug("Ping request")
+	sendSuccessResponse(conn, protocol.PingMessage(protocol.Version, time.Since(serverStartTime)))
+}
+
+// handleInfoRequest answers an information request (`protocol.MessageTypeInfo`) with the effective limits of the server.
+func handleInfoRequest(conn net.Conn, logger *slog.Logger) {
+	message, err := protocol.EncodeServerInfo(serverInfo())
+	if err != nil {
+		logger.Error("Failed to encode the server information", "error", err)
+		sendErrorResponse(conn, "Internal server error")
+		return
+	}
+	logger.Info("Server information request")
+	sendSuccessResponse(conn, message)
+}
+
+// serverInfo returns the current limits and capabilities of the server, reflecting any configuration reload.
+func serverInfo() *protocol.ServerInfo {
+	freeBytes, err := freeSpace(*destDir)
+	if err != nil {
+		slog.Debug("Failed to get the free space of the destination directory", "dir", *destDir, "error", err)
+	}
+	return &protocol.ServerInfo{
+		MaxFileSize:      uint64(MaxFileSize),
+		MaxDirectorySize: maxDirectorySize.Load(),
+		Checksums:        []string{protocol.ChecksumAlgorithmSHA256},
+		Compressions:     protocol.CompressionNames(),
+		Resume:           false,
+		Sessions:         true,
+		Manifest:         true,
+		Ranges:           *quarantineDir == "",
+		FreeBytes:        freeBytes,
+	}
+}
+
+// freeSpace returns the number of bytes available to the server on the file system of the directory (see `statVolume`).
+func freeSpace(dir string) (uint64, error) {
+	free, _, err := statVolume(dir)
+	return free, err
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"filexfer/protocol"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestHandleInfoRequest tests `handleConnection` to ensure that
+// an information request is answered with the effective limits of the server.
+func TestHandleInfoRequest(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "max-dir-size": "1024"})
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeInfo,
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeFile,
+	}, nil)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got status %d: %s", status, message)
+	}
+	info, err := protocol.ParseServerInfo(message)
+	if err != nil {
+		t.Fatalf("failed to parse the server information: %v", err)
+	}
+	if info.MaxFileSize != uint64(MaxFileSize) || info.MaxDirectorySize != 1024 {
+		t.Fatalf("expected the limits %d and 1024, got %d and %d", uint64(MaxFileSize), info.MaxFileSize, info.MaxDirectorySize)
+	}
+	if !slices.Equal(info.Checksums, []string{protocol.ChecksumAlgorithmSHA256}) || !slices.Contains(info.Compressions, "zstd") {
+		t.Fatalf("expected sha256 checksums and zstd compression, got %+v", info)
+	}
+	if !info.Sessions || info.Resume || info.FreeBytes == 0 {
+		t.Fatalf("expected sessions without resume and the free space, got %+v", info)
+	}
+}
+
+// TestFreeSpaceMissingDirectory tests `freeSpace` to ensure that
+// a directory not created yet is measured at its nearest existing parent.
+func TestFreeSpaceMissingDirectory(t *testing.T) {
+	freeBytes, err := freeSpace(filepath.Join(t.TempDir(), "not", "created"))
+	if err != nil || freeBytes == 0 {
+		t.Fatalf("expected the free space of the parent, got %d (%v)", freeBytes, err)
+	}
+}
+
+// TestHandlePingRequest tests `handleConnection` over a listener to ensure that
+// a ping is answered with the version and uptime of the server, and that the connection stays open for a next ping.
+func TestHandlePingRequest(t *testing.T) {
+	withFlags(t, map[string]string{"dir": t.TempDir()})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			wg.Done()
+			return
+		}
+		handleConnection(context.Background(), conn, &wg)
+	}()
+	// Wait for the connection to be handled once the client end is closed.
+	defer wg.Wait()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	for range 2 {
+		if err := protocol.WriteHeader(conn, &protocol.Header{
+			MessageType:  protocol.MessageTypePing,
+			Checksum:     make([]byte, protocol.ChecksumSize),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			t.Fatalf("failed to send the ping: %v", err)
+		}
+		status, message, err := protocol.ReadResponse(conn)
+		if err != nil {
+			t.Fatalf("failed to read the response: %v", err)
+		}
+		if status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response, got status %d: %s", status, message)
+		}
+		if !strings.Contains(message, "filexfer "+protocol.Version) || !strings.Contains(message, "uptime") {
+			t.Fatalf("expected the version and uptime, got %q", message)
+		}
+	}
+}
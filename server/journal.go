@@ -0,0 +1,333 @@
+package server
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Location of the received-files journal in the destination directory (see `receiveJournal`).
+const (
+	JournalDirName  = ".filexfer"     // Directory holding the journal, which clients cannot write to or read from.
+	JournalFileName = "journal.jsonl" // Name of the journal file.
+)
+
+// JournalStatusStarted is the status of the entry written when the content of a file starts to be written,
+// which is followed by an entry with the status of the access log (`AccessStatusCompleted` or `AccessStatusFailed`).
+const JournalStatusStarted = "started"
+
+// JournalCompactSize is the smallest size at which the journal is compacted while the server runs.
+// It's defined as a variable to allow modification during testing, although it should remain constant in practice.
+var JournalCompactSize int64 = 64 * 1024 * 1024
+
+// A journalEntry is a line of the journal, written as a JSON object when a transfer starts writing a file and when it ends.
+type journalEntry struct {
+	Time       time.Time `json:"time"`               // Time the entry was written.
+	TransferID string    `json:"transfer_id"`        // Identifier of the transfer, as in the logs.
+	FileName   string    `json:"file_name"`          // Name of the file as sent by the client.
+	Path       string    `json:"path"`               // Path the content is written to (started), or stored at (completed).
+	Size       int64     `json:"size"`               // Declared size of the file (started), or size of the stored file (completed).
+	ModTime    time.Time `json:"mod_time,omitzero"`  // Modification time of the stored file, which detects a file changed since.
+	Checksum   string    `json:"checksum,omitempty"` // Hex-encoded SHA-256 checksum of the stored file.
+	Status     string    `json:"status"`             // `JournalStatusStarted`, `AccessStatusCompleted`, or `AccessStatusFailed`.
+}
+
+// A receiveJournal is the append-only record of the files received in the destination directory ("-no-journal" to opt out).
+// After a crash, it tells the partial files of the interrupted transfers apart, and it remembers the checksums of the
+// stored files across restarts, so that dedup and sync queries are answered without hashing the files again.
+// It is compacted at startup, and whenever it doubles in size past `JournalCompactSize`.
+type receiveJournal struct {
+	mu        sync.Mutex
+	path      string                  // Path of the journal file.
+	file      *os.File                // Journal file opened for appending (nil once closed).
+	size      int64                   // Size of the journal file in bytes.
+	compactAt int64                   // Size at which the journal is compacted next.
+	active    map[string]journalEntry // Started entries of the transfers in progress, by transfer ID.
+}
+
+// journal records the received files, or is nil if the server keeps no journal.
+var journal *receiveJournal
+
+// A journalRecovery summarizes what the startup scan of the journal found.
+type journalRecovery struct {
+	restored    int // Stored files whose checksums were restored.
+	interrupted int // Transfers interrupted by a crash, whose partial files were removed.
+}
+
+// reservedPath reports whether the path is in the directory of the journal of the destination directory.
+func reservedPath(path string) bool {
+	relative, err := filepath.Rel(filepath.Join(filepath.Clean(*destDir), JournalDirName), path)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// openJournal opens (or creates) the journal of the destination directory and recovers from its entries (see `recover`).
+func openJournal(destination string) (*receiveJournal, journalRecovery, error) {
+	dir := filepath.Join(destination, JournalDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, journalRecovery{}, fmt.Errorf("failed to create the journal directory: %v", err)
+	}
+	j := &receiveJournal{path: filepath.Join(dir, JournalFileName), active: make(map[string]journalEntry)}
+	entries, err := readJournal(j.path)
+	if err != nil {
+		return nil, journalRecovery{}, err
+	}
+	recovery := recoverJournal(entries)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.compactLocked(entries); err != nil {
+		return nil, journalRecovery{}, err
+	}
+	return j, recovery, nil
+}
+
+// readJournal reads the entries of the journal file, if it exists. A malformed line, such as the last line of a journal
+// whose write was cut short by a crash, is skipped.
+func readJournal(path string) ([]journalEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the journal: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("Skipping a malformed journal entry", "path", path, "line", line, "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the journal: %v", err)
+	}
+	return entries, nil
+}
+
+// recoverJournal runs at startup, when no transfer is in progress: it removes the partial file of each transfer started
+// and never ended (unless a later transfer stored a file at the same path), and restores the checksums of the stored
+// files that are unchanged since, for the sync queries and, with "-dedup", the deduplication of later transfers.
+func recoverJournal(entries []journalEntry) journalRecovery {
+	var recovery journalRecovery
+	ended := make(map[string]bool)
+	stored := make(map[string]int) // Path -> index of the last entry completing a transfer at the path.
+	for i, entry := range entries {
+		if entry.Status != JournalStatusStarted {
+			ended[entry.TransferID] = true
+		}
+		if entry.Status == AccessStatusCompleted {
+			stored[entry.Path] = i
+		}
+	}
+
+	for i, entry := range entries {
+		if entry.Status != JournalStatusStarted || ended[entry.TransferID] {
+			continue
+		}
+		if last, ok := stored[entry.Path]; ok && last > i {
+			continue
+		}
+		recovery.interrupted++
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to remove the partial file of an interrupted transfer", "path", entry.Path, "error", err)
+			continue
+		}
+		slog.Info("Removed the partial file of an interrupted transfer", "path", entry.Path, "transfer_id", entry.TransferID,
+			"file_name", entry.FileName)
+	}
+
+	for _, i := range stored {
+		entry := entries[i]
+		checksum, err := hex.DecodeString(entry.Checksum)
+		if err != nil || !journaledFileUnchanged(entry) {
+			continue
+		}
+		rememberStoredChecksum(storedChecksum{path: entry.Path, checksum: checksum, size: entry.Size, modTime: entry.ModTime})
+		if *dedup {
+			dedupMutex.Lock()
+			dedupIndex[entry.Checksum] = dedupEntry{path: entry.Path, size: entry.Size, modTime: entry.ModTime}
+			dedupMutex.Unlock()
+		}
+		recovery.restored++
+	}
+	return recovery
+}
+
+// journaledFileUnchanged reports whether the file of a completed entry is still as it was stored.
+func journaledFileUnchanged(entry journalEntry) bool {
+	info, err := os.Stat(entry.Path)
+	return err == nil && info.Mode().IsRegular() && info.Size() == entry.Size && info.ModTime().Equal(entry.ModTime)
+}
+
+// record appends the entry to the journal, and compacts the journal once it has grown past `compactAt`.
+// With "-fsync", the entry of a stored file is synced along with the file.
+func (j *receiveJournal) record(entry journalEntry) error {
+	entry.Time = time.Now()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode the journal entry: %v", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return fmt.Errorf("the journal is closed")
+	}
+	if entry.Status == JournalStatusStarted {
+		j.active[entry.TransferID] = entry
+	} else {
+		delete(j.active, entry.TransferID)
+	}
+	n, err := j.file.Write(line)
+	j.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write the journal: %v", err)
+	}
+	if *fsyncFiles && entry.Status == AccessStatusCompleted {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync the journal: %v", err)
+		}
+	}
+
+	if j.size >= j.compactAt {
+		entries, err := readJournal(j.path)
+		if err != nil {
+			return err
+		}
+		return j.compactLocked(entries)
+	}
+	return nil
+}
+
+// compactLocked rewrites the journal with the entries still useful: the last completed entry of each stored file
+// that is unchanged since, and the started entries of the transfers in progress. The new journal replaces the old one
+// atomically, so that a crash during the compaction leaves either. The caller must hold the mutex.
+func (j *receiveJournal) compactLocked(entries []journalEntry) error {
+	stored := make(map[string]journalEntry)
+	var order []string
+	for _, entry := range entries {
+		if entry.Status != AccessStatusCompleted {
+			continue
+		}
+		if _, ok := stored[entry.Path]; !ok {
+			order = append(order, entry.Path)
+		}
+		stored[entry.Path] = entry
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(j.path), JournalFileName+".*")
+	if err != nil {
+		return fmt.Errorf("failed to compact the journal: %v", err)
+	}
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	for _, path := range order {
+		if entry := stored[path]; journaledFileUnchanged(entry) {
+			err = encoder.Encode(entry)
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, entry := range j.active {
+		if err == nil {
+			err = encoder.Encode(entry)
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), j.path)
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+		return fmt.Errorf("failed to compact the journal: %v", err)
+	}
+
+	if j.file != nil {
+		if err := j.file.Close(); err != nil {
+			slog.Warn("Error closing the journal before compacting it", "path", j.path, "error", err)
+		}
+	}
+	j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the journal: %v", err)
+	}
+	info, err := j.file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to open the journal: %v", err)
+	}
+	j.size = info.Size()
+	j.compactAt = max(JournalCompactSize, 2*j.size)
+	slog.Debug("Compacted the journal", "path", j.path, "entries", len(entries), "bytes", j.size)
+	return nil
+}
+
+// close closes the journal file. Entries recorded afterwards are rejected.
+func (j *receiveJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// journalStarted records in the journal, if one is kept, that the content of a transfer starts to be written to `path`.
+func journalStarted(transferID, fileName, path string, size int64) {
+	if journal == nil {
+		return
+	}
+	entry := journalEntry{TransferID: transferID, FileName: fileName, Path: path, Size: size, Status: JournalStatusStarted}
+	if err := journal.record(entry); err != nil {
+		slog.Error("Failed to write the journal entry", "file_name", fileName, "error", err)
+	}
+}
+
+// journalEnded records in the journal, if one is kept, how a transfer ended: the file stored at `path` with its
+// hex-encoded checksum (`AccessStatusCompleted`), or nothing stored (`AccessStatusFailed`).
+func journalEnded(transferID, fileName, path, status, checksum string) {
+	if journal == nil {
+		return
+	}
+	entry := journalEntry{TransferID: transferID, FileName: fileName, Path: path, Status: status}
+	if status == AccessStatusCompleted {
+		info, err := os.Stat(path)
+		if err != nil {
+			slog.Warn("Failed to journal the stored file", "path", path, "error", err)
+			entry.Status = AccessStatusFailed
+		} else {
+			entry.Size, entry.ModTime, entry.Checksum = info.Size(), info.ModTime(), checksum
+		}
+	}
+	if err := journal.record(entry); err != nil {
+		slog.Error("Failed to write the journal entry", "file_name", fileName, "error", err)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withJournal opens the journal of the directory for the duration of the test.
+func withJournal(t *testing.T, dir string) *receiveJournal {
+	t.Helper()
+
+	opened, _, err := openJournal(dir)
+	if err != nil {
+		t.Fatalf("failed to open the journal: %v", err)
+	}
+	original := journal
+	journal = opened
+	t.Cleanup(func() {
+		journal = original
+		_ = opened.close()
+	})
+	return opened
+}
+
+// readJournalFile reads the entries of the journal of the directory.
+func readJournalFile(t *testing.T, dir string) []journalEntry {
+	t.Helper()
+
+	entries, err := readJournal(filepath.Join(dir, JournalDirName, JournalFileName))
+	if err != nil {
+		t.Fatalf("failed to read the journal: %v", err)
+	}
+	return entries
+}
+
+// TestJournalRecordsTransfers tests the journal to ensure that
+// a received file is recorded as started, then completed with its final path, size, and checksum.
+func TestJournalRecordsTransfers(t *testing.T) {
+	dir := t.TempDir()
+	withJournal(t, dir)
+
+	content := []byte("journaled content")
+	if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	entries := readJournalFile(t, dir)
+	if len(entries) != 2 {
+		t.Fatalf("expected a started and a completed entry, got %+v", entries)
+	}
+	started, completed := entries[0], entries[1]
+	path := filepath.Join(dir, "a.txt")
+	if started.Status != JournalStatusStarted || started.Path != path || started.Size != int64(len(content)) {
+		t.Fatalf("unexpected started entry: %+v", started)
+	}
+	checksum := protocol.CalculateDataChecksum(content)
+	if completed.Status != AccessStatusCompleted || completed.TransferID != started.TransferID || completed.Path != path ||
+		completed.FileName != "a.txt" || completed.Size != int64(len(content)) || !journaledFileUnchanged(completed) {
+		t.Fatalf("unexpected completed entry: %+v", completed)
+	}
+	if completed.Checksum != hex.EncodeToString(checksum) {
+		t.Fatalf("expected the checksum %x, got %s", checksum, completed.Checksum)
+	}
+}
+
+// TestJournalRecovery tests `openJournal` to ensure that
+// the partial file of a transfer interrupted by a crash is removed, and that the checksums of the stored files
+// are restored for the sync queries, unless a file changed since.
+func TestJournalRecovery(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+
+	partial := filepath.Join(dir, "partial.bin")
+	stored := filepath.Join(dir, "stored.txt")
+	changed := filepath.Join(dir, "changed.txt")
+	for _, path := range []string{partial, stored, changed} {
+		if err := os.WriteFile(path, []byte(path), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", path, err)
+		}
+	}
+
+	first := withJournal(t, dir)
+	journalStarted("interrupted", "partial.bin", partial, 100)
+	for _, path := range []string{stored, changed} {
+		journalStarted(path, filepath.Base(path), path, int64(len(path)))
+		journalEnded(path, filepath.Base(path), path, AccessStatusCompleted, "00")
+	}
+	if err := first.close(); err != nil {
+		t.Fatalf("failed to close the journal: %v", err)
+	}
+	if err := os.WriteFile(changed, []byte("changed since"), 0644); err != nil {
+		t.Fatalf("failed to change the file: %v", err)
+	}
+
+	reopened, recovery, err := openJournal(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen the journal: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = reopened.close()
+	})
+	if recovery.interrupted != 1 || recovery.restored != 1 {
+		t.Fatalf("expected 1 interrupted transfer and 1 restored checksum, got %+v", recovery)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be removed, got %v", err)
+	}
+	info, err := os.Stat(stored)
+	if err != nil {
+		t.Fatalf("expected the stored file to be kept: %v", err)
+	}
+	if checksum, ok := lookupStoredChecksum(stored, info); !ok || !bytes.Equal(checksum, []byte{0}) {
+		t.Fatalf("expected the checksum of the stored file to be restored, got %x", checksum)
+	}
+
+	// The compacted journal keeps only the stored file that is unchanged.
+	entries := readJournalFile(t, dir)
+	if len(entries) != 1 || entries[0].Path != stored {
+		t.Fatalf("expected the journal to be compacted to the stored file, got %+v", entries)
+	}
+}
+
+// TestJournalCompaction tests `receiveJournal.record` to ensure that
+// the journal is compacted once it grows past its compaction size, keeping the transfers in progress.
+func TestJournalCompaction(t *testing.T) {
+	dir := t.TempDir()
+	originalSize := JournalCompactSize
+	JournalCompactSize = 1024
+	t.Cleanup(func() {
+		JournalCompactSize = originalSize
+	})
+	opened := withJournal(t, dir)
+
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to write the file: %v", err)
+	}
+	journalStarted("in-progress", "b.txt", filepath.Join(dir, "b.txt"), 1)
+	for range 100 {
+		journalStarted("overwrite", "a.txt", path, 7)
+		journalEnded("overwrite", "a.txt", path, AccessStatusCompleted, "00")
+	}
+
+	if opened.size >= 1024 {
+		t.Fatalf("expected the journal to be compacted below 1024 bytes, got %d", opened.size)
+	}
+	entries := readJournalFile(t, dir)
+	statuses := map[string]string{}
+	for _, entry := range entries {
+		statuses[entry.TransferID] = entry.Status
+	}
+	if statuses["in-progress"] != JournalStatusStarted || len(entries) > 4 {
+		t.Fatalf("expected the journal to keep the transfer in progress and the stored file, got %+v", entries)
+	}
+}
+
+// TestJournalReservedPath tests `sanitizePath` to ensure that
+// clients cannot write to or query the journal directory.
+func TestJournalReservedPath(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+
+	for _, name := range []string{JournalDirName, JournalDirName + "/" + JournalFileName, "./" + JournalDirName + "/x"} {
+		if _, err := sanitizePath(dir, name); !errors.Is(err, ErrReservedPath) {
+			t.Errorf("expected %q to be reserved, got %v", name, err)
+		}
+	}
+	if _, err := sanitizePath(dir, JournalDirName+".other/a.txt"); err != nil {
+		t.Errorf("expected a sibling of the journal directory to be allowed, got %v", err)
+	}
+}
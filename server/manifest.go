@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// handleManifestRequest answers the manifest of a directory sent ahead of a sync (`protocol.MessageTypeManifest`)
+// with the files the server needs, comparing each file of the manifest like a sync query (see `compareStoredFile`),
+// so that a client skips the files the server already has without querying them one by one.
+// A file that cannot be compared (e.g. of an invalid path) is reported as needed, and refused when it is sent.
+// The manifest and its decoded entries are held in the memory budget ("-max-memory"): a manifest that does not fit
+// is discarded and refused as busy, after which the client queries its files one by one.
+// It returns an error if the manifest cannot be read, leaving the connection out of step with the client.
+func handleManifestRequest(ctx context.Context, conn net.Conn, header *protocol.Header, logger *slog.Logger) error {
+	logger = logger.With("request", "Manifest")
+
+	manifestMemory := 2 * int64(header.FileSize)
+	if !memory.acquire(ctx, manifestMemory, *maxMemory, MemoryWait) {
+		logger.Warn("Refusing the manifest while the memory budget is used up", "bytes", header.FileSize, "max_memory", *maxMemory)
+		if _, err := io.CopyN(io.Discard, conn, int64(header.FileSize)); err != nil {
+			return fmt.Errorf("failed to discard the manifest: %w", err)
+		}
+		sendCodedResponse(conn, protocol.ResponseStatusRetryLater, protocol.ErrorCodeServerBusy, protocol.ServerBusyMessage)
+		return nil
+	}
+	defer memory.release(manifestMemory)
+
+	data := make([]byte, header.FileSize)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		logger.Error("Failed to read the manifest", "bytes", header.FileSize, "error", err)
+		return fmt.Errorf("failed to read the manifest: %w", err)
+	}
+	if !bytes.Equal(protocol.CalculateDataChecksum(data), header.Checksum) {
+		logger.Warn("Manifest checksum mismatch")
+		sendErrorResponse(conn, "Data integrity check failed")
+		return nil
+	}
+	entries, err := protocol.DecodeManifest(data)
+	if err != nil {
+		logger.Warn("Failed to decode the manifest", "error", err)
+		sendErrorResponse(conn, err.Error())
+		return nil
+	}
+
+	needed := make([]bool, len(entries))
+	missing := 0
+	for i, entry := range entries {
+		needed[i] = !manifestEntryMatches(ctx, header, entry, logger)
+		if needed[i] {
+			missing++
+		}
+	}
+	logger.Info("Manifest compared", "files", len(entries), "needed", missing)
+
+	// Comparing the files may have taken a while.
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		return fmt.Errorf("failed to set the write deadline: %w", err)
+	}
+	sendSuccessResponse(conn, protocol.EncodeManifestReply(needed))
+	return nil
+}
+
+// manifestEntryMatches reports whether the server has the file of the manifest entry, stored under the directory path of the
+// manifest's header as a file of a directory transfer would be. Once the context is canceled, no file is compared any more.
+func manifestEntryMatches(ctx context.Context, header *protocol.Header, entry protocol.ManifestEntry, logger *slog.Logger) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	entryHeader := *header
+	entryHeader.MessageType = protocol.MessageTypeQuery
+	entryHeader.FileName = entry.Path
+	if *normalizeUnicode {
+		normalizeHeaderNames(logger, &entryHeader)
+	}
+	if *flatten {
+		flattenHeader(&entryHeader)
+	}
+	path, err := destinationPath(&entryHeader)
+	if err != nil {
+		logger.Warn("Path sanitization failed", "file_name", entry.Path, "error", err)
+		return false
+	}
+	return compareStoredFile(ctx, logger.With("file_name", entry.Path), path, entry.Size, entry.Checksum, true) == protocol.VerifyMessageMatch
+}
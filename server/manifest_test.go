@@ -0,0 +1,94 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// sendManifest sends the manifest of the entries for the directory path and returns the server's response.
+func sendManifest(t *testing.T, dir, dirPath string, entries []protocol.ManifestEntry) (uint8, string) {
+	t.Helper()
+
+	data, err := protocol.EncodeManifest(entries)
+	if err != nil {
+		t.Fatalf("failed to encode the manifest: %v", err)
+	}
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:   protocol.MessageTypeManifest,
+		FileSize:      uint64(len(data)),
+		Checksum:      protocol.CalculateDataChecksum(data),
+		TransferType:  protocol.TransferTypeDirectory,
+		DirectoryPath: dirPath,
+	}, data)
+}
+
+// TestHandleManifestRequest tests the manifest request handling to ensure that
+// the files the server already has are reported as not needed, while changed, missing, and invalid files are requested.
+func TestHandleManifestRequest(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"same.txt":     "unchanged content",
+		"sub/same.txt": "unchanged nested content",
+		"changed.txt":  "new content",
+		"new.txt":      "brand new",
+	}
+	stored := map[string]string{
+		"same.txt":     files["same.txt"],
+		"sub/same.txt": files["sub/same.txt"],
+		"changed.txt":  "old content",
+	}
+	for name, content := range stored {
+		path := filepath.Join(dir, "backup", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	var entries []protocol.ManifestEntry
+	for _, name := range []string{"same.txt", "changed.txt", "sub/same.txt", "new.txt"} {
+		content := []byte(files[name])
+		entries = append(entries, protocol.ManifestEntry{Path: name, Size: uint64(len(content)), Checksum: protocol.CalculateDataChecksum(content)})
+	}
+	entries = append(entries, protocol.ManifestEntry{Path: "../escape.txt", Size: 1, Checksum: protocol.CalculateDataChecksum([]byte("x"))})
+
+	status, message := sendManifest(t, dir, "backup", entries)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	needed, err := protocol.ParseManifestReply(message, len(entries))
+	if err != nil {
+		t.Fatalf("failed to parse the reply: %v", err)
+	}
+	if expected := []bool{false, true, false, true, true}; !reflect.DeepEqual(needed, expected) {
+		t.Fatalf("expected the needed files %v, got %v", expected, needed)
+	}
+}
+
+// TestHandleManifestRequestCorrupted tests the manifest request handling to ensure that
+// a manifest that does not match the checksum of its header is refused.
+func TestHandleManifestRequestCorrupted(t *testing.T) {
+	data, err := protocol.EncodeManifest([]protocol.ManifestEntry{
+		{Path: "a.txt", Size: 1, Checksum: protocol.CalculateDataChecksum([]byte("a"))},
+	})
+	if err != nil {
+		t.Fatalf("failed to encode the manifest: %v", err)
+	}
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypeManifest,
+		FileSize:     uint64(len(data)),
+		Checksum:     protocol.CalculateDataChecksum(data),
+		TransferType: protocol.TransferTypeDirectory,
+	}
+	data[len(data)-1] ^= 0xFF
+
+	if status, message := sendRequest(t, t.TempDir(), header, data); status != protocol.ResponseStatusError ||
+		message != "Data integrity check failed" {
+		t.Fatalf("expected an integrity error, got status %d with %q", status, message)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"filexfer/protocol"
+	"sync"
+	"time"
+)
+
+// MemoryWait is the time a connection waits for its share of "-max-memory" before it is refused as busy.
+// It's defined as a variable to allow modification during testing, although it should remain constant in practice.
+var MemoryWait = 10 * time.Second
+
+// A memoryBudget is a weighted semaphore bounding the memory of the buffers allocated by the connections ("-max-memory"):
+// each connection acquires the memory of its buffers before allocating them, and releases it once they are freed.
+type memoryBudget struct {
+	mutex    sync.Mutex
+	used     int64         // Bytes acquired and not yet released.
+	peak     int64         // Highest number of bytes acquired at once.
+	released chan struct{} // Closed (and replaced) when memory is released, to wake the waiting connections.
+}
+
+// memory bounds the memory of the buffers of the connections for "-max-memory".
+var memory = &memoryBudget{released: make(chan struct{})}
+
+// connectionMemory returns the memory a connection acquires for its whole duration:
+// the header it reads, its copy buffer ("-buffer-size"), and the buffer of a checksum it calculates.
+func connectionMemory() int64 {
+	return protocol.MaxHeaderSize + int64(*bufferSize) + protocol.ChecksumChunkSize
+}
+
+// acquire acquires `n` bytes of a budget of `limit` bytes, waiting up to `wait` for other connections to release theirs.
+// It reports whether the bytes were acquired, which they always are if `limit` is 0 (no budget, the bytes are only counted),
+// and never if `n` exceeds `limit`, or if the context is canceled first. Acquired bytes must be given back with `release`.
+func (mb *memoryBudget) acquire(ctx context.Context, n, limit int64, wait time.Duration) bool {
+	if limit > 0 && n > limit {
+		return false
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		mb.mutex.Lock()
+		if limit <= 0 || mb.used+n <= limit {
+			mb.used += n
+			mb.peak = max(mb.peak, mb.used)
+			memoryInUse.Add(n)
+			mb.mutex.Unlock()
+			return true
+		}
+		released := mb.released
+		mb.mutex.Unlock()
+
+		select {
+		case <-released:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// release gives back `n` acquired bytes, and wakes the connections waiting for memory.
+func (mb *memoryBudget) release(n int64) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	mb.used -= n
+	memoryInUse.Add(-n)
+	close(mb.released)
+	mb.released = make(chan struct{})
+}
+
+// usage returns the bytes acquired now, and the most acquired at once since the server started.
+func (mb *memoryBudget) usage() (used, peak int64) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	return mb.used, mb.peak
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"filexfer/protocol"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withMemoryBudget replaces the memory budget with an empty one for the duration of the test.
+func withMemoryBudget(t *testing.T) *memoryBudget {
+	t.Helper()
+
+	original := memory
+	memory = &memoryBudget{released: make(chan struct{})}
+	t.Cleanup(func() {
+		memory = original
+	})
+	return memory
+}
+
+// TestMemoryBudgetAcquire tests `memoryBudget` to ensure that
+// more than the limit is never acquired, that a waiting acquisition gets the memory released meanwhile or times out,
+// and that nothing is refused without a limit.
+func TestMemoryBudgetAcquire(t *testing.T) {
+	budget := &memoryBudget{released: make(chan struct{})}
+	ctx := context.Background()
+
+	if budget.acquire(ctx, 11, 10, time.Second) {
+		t.Fatalf("expected more than the whole budget to be refused")
+	}
+	if !budget.acquire(ctx, 6, 10, time.Second) {
+		t.Fatalf("expected the acquisition to fit in the budget")
+	}
+	if budget.acquire(ctx, 6, 10, 10*time.Millisecond) {
+		t.Fatalf("expected the acquisition beyond the budget to time out")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		budget.release(6)
+	}()
+	if !budget.acquire(ctx, 6, 10, 5*time.Second) {
+		t.Fatalf("expected the waiting acquisition to get the released memory")
+	}
+	if !budget.acquire(ctx, 100, 0, 0) {
+		t.Fatalf("expected the acquisition without a limit to succeed")
+	}
+	budget.release(106)
+
+	if used, peak := budget.usage(); used != 0 || peak != 106 {
+		t.Fatalf("expected 0 bytes used with a peak of 106, got %d and %d", used, peak)
+	}
+}
+
+// TestMaxMemoryConcurrentConnections tests `handleConnection` with "-max-memory" to ensure that
+// 100 concurrent connections with a 10MB budget never hold more than the budget, while all their transfers complete.
+func TestMaxMemoryConcurrentConnections(t *testing.T) {
+	const limit = 10 * 1024 * 1024
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"max-memory": strconv.Itoa(limit), "dir": dir})
+	budget := withMemoryBudget(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := range 100 {
+		content := []byte(fmt.Sprintf("content of file %d", i))
+		var request bytes.Buffer
+		if err := protocol.WriteHeader(&request, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(content)),
+			FileName:     fmt.Sprintf("file%d.txt", i),
+			Checksum:     protocol.CalculateDataChecksum(content),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			t.Fatalf("failed to encode the header: %v", err)
+		}
+		request.Write(content)
+
+		serverConn, clientConn := net.Pipe()
+		wg.Add(2)
+		go handleConnection(context.Background(), serverConn, &wg)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				_ = clientConn.Close()
+			}()
+			go func() {
+				_, _ = clientConn.Write(request.Bytes())
+			}()
+			status, message, err := protocol.ReadResponse(clientConn)
+			if err != nil || status != protocol.ResponseStatusSuccess {
+				errs <- fmt.Errorf("file %d: status %d with %q and %v", i, status, message, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("expected every transfer to succeed: %v", err)
+	}
+	used, peak := budget.usage()
+	if peak > limit {
+		t.Fatalf("expected at most %d bytes held at once, got %d", limit, peak)
+	}
+	if used != 0 {
+		t.Fatalf("expected all memory to be released, got %d bytes", used)
+	}
+}
+
+// TestMaxMemoryRefusesManifest tests `handleManifestRequest` with "-max-memory" to ensure that
+// a manifest larger than the memory left to the connection is refused as busy.
+func TestMaxMemoryRefusesManifest(t *testing.T) {
+	withFlags(t, map[string]string{"max-memory": strconv.FormatInt(connectionMemory()+64, 10)})
+	withMemoryBudget(t)
+	originalWait := MemoryWait
+	MemoryWait = 10 * time.Millisecond
+	t.Cleanup(func() {
+		MemoryWait = originalWait
+	})
+
+	entries := []protocol.ManifestEntry{
+		{Path: "a.txt", Size: 1, Checksum: protocol.CalculateDataChecksum([]byte("a"))},
+		{Path: "b.txt", Size: 1, Checksum: protocol.CalculateDataChecksum([]byte("b"))},
+	}
+	status, message := sendManifest(t, t.TempDir(), "", entries)
+	if status != protocol.ResponseStatusRetryLater || message != protocol.ServerBusyMessage {
+		t.Fatalf("expected the server busy response, got %d: %q", status, message)
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Transfer counters of the server, exposed at "/metrics" of the metrics endpoint ("-metrics-addr") and at "/debug/vars".
+var (
+	transfersTotal   = expvar.NewInt("transfers_total")         // Number of finished file, stream, and archive transfers, successful or not.
+	transferFailures = expvar.NewInt("transfer_failures_total") // Number of transfers that failed.
+	bytesReceived    = expvar.NewInt("bytes_received_total")    // Number of bytes of file content read from clients.
+)
+
+// MetricsContentType is the content type of the Prometheus text exposition format served at "/metrics".
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// recordTransfer counts a finished transfer in the metrics.
+func recordTransfer(failed bool) {
+	transfersTotal.Add(1)
+	if failed {
+		transferFailures.Add(1)
+	}
+}
+
+// writeMetrics renders the current metrics in the Prometheus text exposition format.
+func writeMetrics() string {
+	var sb strings.Builder
+	metric := func(name, kind, help string, value any) {
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+	}
+
+	numClient, totalSize := getDirectoryStats()
+	metric("filexfer_transfers_total", "counter", "Number of finished file, stream, and archive transfers, successful or not.", transfersTotal.Value())
+	metric("filexfer_transfer_failures_total", "counter", "Number of transfers that failed.", transferFailures.Value())
+	metric("filexfer_bytes_received_total", "counter", "Number of bytes of file content read from clients.", bytesReceived.Value())
+	metric("filexfer_active_connections", "gauge", "Number of client connections being handled.", activeConnections.Value())
+	metric("filexfer_memory_in_use_bytes", "gauge", "Number of bytes of buffers acquired by the connections.", memoryInUse.Value())
+	metric("filexfer_directory_transfers", "gauge", "Number of clients with a directory transfer in progress.", numClient)
+	metric("filexfer_directory_bytes", "gauge", "Total size in bytes of the directory transfers in progress.", totalSize)
+	return sb.String()
+}
+
+// newMetricsHandler returns the handler of the metrics endpoint, which serves the metrics at "/metrics".
+func newMetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MetricsContentType)
+		_, _ = w.Write([]byte(writeMetrics()))
+	})
+	return mux
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"filexfer/protocol"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics fetches "/metrics" from the metrics endpoint at `url` and returns the values by metric name.
+func scrapeMetrics(t *testing.T, url string) map[string]int64 {
+	t.Helper()
+
+	response, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape the metrics: %v", err)
+	}
+	defer func() {
+		_ = response.Body.Close()
+	}()
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != MetricsContentType {
+		t.Fatalf("unexpected response: status %d, content type %q", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+
+	values := map[string]int64{}
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if !ok || err != nil {
+			t.Fatalf("unexpected metric line %q", scanner.Text())
+		}
+		values[name] = parsed
+	}
+	return values
+}
+
+// TestMetricsEndpoint tests the metrics endpoint to ensure that
+// the transfer, failure, and byte counters increase with the transfers received.
+func TestMetricsEndpoint(t *testing.T) {
+	dir := t.TempDir()
+	server := httptest.NewServer(newMetricsHandler())
+	defer server.Close()
+
+	before := scrapeMetrics(t, server.URL)
+	for _, name := range []string{"filexfer_transfers_total", "filexfer_transfer_failures_total", "filexfer_bytes_received_total",
+		"filexfer_active_connections", "filexfer_directory_transfers", "filexfer_directory_bytes"} {
+		if _, ok := before[name]; !ok {
+			t.Fatalf("expected the metric %s, got %v", name, before)
+		}
+	}
+
+	content := []byte("measured content")
+	if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if status, _ := sendStream(t, dir, "b.txt", encodeStream(t, content, true)); status != protocol.ResponseStatusError {
+		t.Fatal("expected an error response for a corrupted stream")
+	}
+
+	after := scrapeMetrics(t, server.URL)
+	if got := after["filexfer_transfers_total"] - before["filexfer_transfers_total"]; got != 2 {
+		t.Fatalf("expected 2 more transfers, got %d", got)
+	}
+	if got := after["filexfer_transfer_failures_total"] - before["filexfer_transfer_failures_total"]; got != 1 {
+		t.Fatalf("expected 1 more failure, got %d", got)
+	}
+	if got := after["filexfer_bytes_received_total"] - before["filexfer_bytes_received_total"]; got < int64(len(content)) {
+		t.Fatalf("expected at least %d more bytes received, got %d", len(content), got)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Values of the "-cleanup-mode" flag.
+const (
+	CleanupModeOff    = "off"    // Do not sweep the destination directory.
+	CleanupModeReport = "report" // Log the orphaned partial files, and leave them.
+	CleanupModeDelete = "delete" // Remove the orphaned partial files.
+	CleanupModeTrash  = "trash"  // Move the orphaned partial files to `TrashDirName` in the journal directory.
+)
+
+// Constants for the sweep of orphaned partial files.
+const (
+	PartialFilePrefix = ".filexfer-"   // Prefix of the temporary files the server writes in the destination directory.
+	TrashDirName      = ".trash"       // Directory of `JournalDirName` the partial files are moved to by `CleanupModeTrash`.
+	CleanupMaxAge     = 24 * time.Hour // Default age at which a partial file is orphaned.
+)
+
+// openPartials holds the paths of the partial files being written or read outside of a range transfer (archive spools),
+// which the sweep leaves alone however old they are.
+var openPartials sync.Map
+
+// Totals of the partial files swept since the server started, logged with the other stats.
+var (
+	sweptPartials atomic.Int64 // Number of partial files removed or moved to the trash.
+	sweptBytes    atomic.Int64 // Bytes of the partial files removed or moved to the trash.
+)
+
+// A partialSweep summarizes a sweep of the destination directory.
+type partialSweep struct {
+	files int   // Orphaned partial files found (and, unless reported only, removed or moved).
+	bytes int64 // Total size of those files.
+}
+
+// partialInUse reports whether the partial file at `path` belongs to a transfer in progress:
+// a range transfer not yet removed by "-range-timeout", or an archive being received or extracted.
+func partialInUse(path string) bool {
+	if _, ok := openPartials.Load(path); ok {
+		return true
+	}
+	rangeTransfers.Lock()
+	defer rangeTransfers.Unlock()
+	for _, transfer := range rangeTransfers.byID {
+		if transfer.partPath == path {
+			return true
+		}
+	}
+	return false
+}
+
+// partialPath reports whether a file or directory name of the relative path `relPath` starts with `PartialFilePrefix`.
+// Clients cannot write such paths, so that the sweep only ever finds the partial files of the server.
+func partialPath(relPath string) bool {
+	for _, name := range strings.Split(filepath.ToSlash(relPath), "/") {
+		if strings.HasPrefix(name, PartialFilePrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweepPartials walks the destination directory for the partial files (`PartialFilePrefix`) that crashed servers and
+// killed transfers leave behind, and reports, removes, or moves to the trash (depending on `mode`) those last modified
+// more than `maxAge` ago. It never touches other files, nor the partial files of the transfers in progress, so it is
+// safe to run while the server receives files. The journal directory (with the trash) is not walked.
+func sweepPartials(dir string, maxAge time.Duration, mode string, now time.Time) (partialSweep, error) {
+	var sweep partialSweep
+	if mode == CleanupModeOff {
+		return sweep, nil
+	}
+
+	dir = filepath.Clean(dir)
+	reserved := filepath.Join(dir, JournalDirName)
+	cutoff := now.Add(-maxAge)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir {
+				// A destination directory not created yet has no partial files.
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			slog.Warn("Failed to read a path during the partial file sweep", "path", path, "error", err)
+			return nil
+		}
+		if entry.IsDir() {
+			if path == reserved {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), PartialFilePrefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.ModTime().Before(cutoff) || partialInUse(path) {
+			return nil
+		}
+
+		switch mode {
+		case CleanupModeDelete:
+			if err := os.Remove(path); err != nil {
+				slog.Warn("Failed to remove the orphaned partial file", "path", path, "error", err)
+				return nil
+			}
+			slog.Info("Removed the orphaned partial file", "path", path, "bytes", info.Size(), "mod_time", info.ModTime())
+		case CleanupModeTrash:
+			trashPath, err := moveToTrash(dir, path)
+			if err != nil {
+				slog.Warn("Failed to move the orphaned partial file to the trash", "path", path, "error", err)
+				return nil
+			}
+			slog.Info("Moved the orphaned partial file to the trash", "path", path, "trash_path", trashPath, "bytes", info.Size(),
+				"mod_time", info.ModTime())
+		default:
+			slog.Warn("Orphaned partial file", "path", path, "bytes", info.Size(), "mod_time", info.ModTime())
+		}
+		sweep.files++
+		sweep.bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return sweep, fmt.Errorf("failed to sweep the destination directory: %v", err)
+	}
+	if mode != CleanupModeReport {
+		sweptPartials.Add(int64(sweep.files))
+		sweptBytes.Add(sweep.bytes)
+	}
+	return sweep, nil
+}
+
+// moveToTrash moves the file at `path` of the destination directory `dir` to the same relative path in the trash,
+// and returns its path there. The trash is in the journal directory, out of the reach of clients.
+func moveToTrash(dir, path string) (string, error) {
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil {
+		return "", err
+	}
+	trashPath := filepath.Join(dir, JournalDirName, TrashDirName, relPath)
+	if err := os.MkdirAll(filepath.Dir(trashPath), 0700); err != nil {
+		return "", err
+	}
+	return trashPath, os.Rename(path, trashPath)
+}
+
+// runPartialSweeps sweeps the destination directory at once, then every `interval` (unless 0) until `done` is closed.
+func runPartialSweeps(dir string, maxAge time.Duration, mode string, interval time.Duration, done <-chan struct{}) {
+	sweep := func() {
+		result, err := sweepPartials(dir, maxAge, mode, time.Now())
+		if err != nil {
+			slog.Error("Failed to sweep the orphaned partial files", "dir", dir, "error", err)
+			return
+		}
+		if result.files > 0 {
+			slog.Info("Swept the orphaned partial files", "dir", dir, "mode", mode, "files", result.files, "bytes", result.bytes)
+		}
+	}
+
+	sweep()
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			sweep()
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePartials writes the files of a destination directory for the sweep tests, last modified two days ago
+// except for the young partial file, and registers the partial file of a range transfer in progress.
+func writePartials(t *testing.T, dir string) {
+	t.Helper()
+
+	old := time.Now().Add(-48 * time.Hour)
+	files := map[string]time.Time{
+		".filexfer-range-abandoned.part":      old,
+		"sub/.filexfer-archive-1.tar":         old,
+		".filexfer-range-active.part":         old,
+		".filexfer-move-young":                time.Now(),
+		"a.txt":                               old,
+		"sub/b.txt":                           old,
+		JournalDirName + "/.filexfer-ignored": old,
+	}
+	for name, modTime := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create the directory of %s: %v", name, err)
+		}
+		if err := os.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set the modification time of %s: %v", name, err)
+		}
+	}
+
+	rangeTransfers.Lock()
+	rangeTransfers.byID["active"] = &rangeTransfer{partPath: filepath.Join(dir, ".filexfer-range-active.part")}
+	rangeTransfers.Unlock()
+	t.Cleanup(func() {
+		rangeTransfers.Lock()
+		delete(rangeTransfers.byID, "active")
+		rangeTransfers.Unlock()
+	})
+}
+
+// TestSweepPartials tests `sweepPartials` to ensure that
+// only the old partial files of no transfer in progress are reported, removed, or moved to the trash,
+// outside of the journal directory, and that completed files are never touched.
+func TestSweepPartials(t *testing.T) {
+	kept := []string{".filexfer-range-active.part", ".filexfer-move-young", "a.txt", "sub/b.txt", JournalDirName + "/.filexfer-ignored"}
+	swept := []string{".filexfer-range-abandoned.part", "sub/.filexfer-archive-1.tar"}
+
+	for _, mode := range []string{CleanupModeReport, CleanupModeDelete, CleanupModeTrash} {
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			writePartials(t, dir)
+
+			sweep, err := sweepPartials(dir, 24*time.Hour, mode, time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if sweep.files != 2 || sweep.bytes != 20 {
+				t.Fatalf("expected 2 partial files of 20 bytes, got %+v", sweep)
+			}
+
+			for _, name := range kept {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("expected %s to be kept: %v", name, err)
+				}
+			}
+			for _, name := range swept {
+				_, err := os.Stat(filepath.Join(dir, name))
+				if mode == CleanupModeReport && err != nil {
+					t.Errorf("expected %s to be only reported: %v", name, err)
+				}
+				if mode != CleanupModeReport && !os.IsNotExist(err) {
+					t.Errorf("expected %s to be swept, got %v", name, err)
+				}
+				_, err = os.Stat(filepath.Join(dir, JournalDirName, TrashDirName, name))
+				if (mode == CleanupModeTrash) != (err == nil) {
+					t.Errorf("expected %s in the trash only in the %s mode, got %v", name, CleanupModeTrash, err)
+				}
+			}
+		})
+	}
+
+	t.Run("missing directory", func(t *testing.T) {
+		sweep, err := sweepPartials(filepath.Join(t.TempDir(), "missing"), time.Hour, CleanupModeDelete, time.Now())
+		if err != nil || sweep.files != 0 {
+			t.Fatalf("expected nothing swept without an error, got %+v and %v", sweep, err)
+		}
+	})
+}
+
+// TestPartialPathReserved tests `sanitizePath` to ensure that
+// clients cannot write files or directories named like partial files, which the sweep would remove,
+// and that a file received as such is refused.
+func TestPartialPathReserved(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+
+	for _, name := range []string{".filexfer-range-x.part", "sub/.filexfer-archive-1.tar", ".filexfer-dir/a.txt", "./.filexfer-"} {
+		if _, err := sanitizePath(dir, name); !errors.Is(err, ErrReservedPath) {
+			t.Errorf("expected %q to be reserved, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"a.filexfer-x", "sub/filexfer-x", ".filexfer.txt"} {
+		if _, err := sanitizePath(dir, name); err != nil {
+			t.Errorf("expected %q to be allowed, got %v", name, err)
+		}
+	}
+
+	if status, message := sendFile(t, dir, ".filexfer-move-upload", []byte("content")); status != protocol.ResponseStatusError {
+		t.Fatalf("expected the partial file name to be refused, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".filexfer-move-upload")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be stored, got %v", err)
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errNoSpace is returned by `preallocate` for a file that does not fit in the free space of its volume.
+var errNoSpace = errors.New("not enough space for the file")
+
+// preallocate reserves `size` bytes of disk space for the file ("-preallocate"), without changing its size, so that
+// a large file is laid out contiguously and a file that cannot fit is refused before its content is received.
+// It reports whether the space was reserved. A file system or platform that does not support preallocation
+// is written to without it; only a lack of space fails, with `errNoSpace`.
+func preallocate(file *os.File, size int64) (bool, error) {
+	if err := preallocateFile(file, size); err != nil {
+		if errors.Is(err, syscall.ENOSPC) {
+			return false, errNoSpace
+		}
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocateSupported is the default of "-preallocate", on since the platform can preallocate files.
+const preallocateSupported = true
+
+// preallocateFile reserves `size` bytes for the file with F_PREALLOCATE, contiguously if possible.
+// F_PREALLOCATE does not change the size of the file.
+func preallocateFile(file *os.File, size int64) error {
+	store := &unix.Fstore_t{Flags: unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL, Posmode: unix.F_PEOFPOSMODE, Length: size}
+	if err := unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store); err == nil {
+		return nil
+	}
+	store.Flags = unix.F_ALLOCATEALL
+	return unix.FcntlFstore(file.Fd(), unix.F_PREALLOCATE, store)
+}
@@ -0,0 +1,15 @@
+package server
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// preallocateSupported is the default of "-preallocate", on since the platform can preallocate files.
+const preallocateSupported = true
+
+// preallocateFile reserves `size` bytes for the file with fallocate, keeping its size.
+func preallocateFile(file *os.File, size int64) error {
+	return unix.Fallocate(int(file.Fd()), unix.FALLOC_FL_KEEP_SIZE, 0, size)
+}
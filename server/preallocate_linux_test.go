@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountTinyTmpfs mounts a tmpfs of 1MB for the duration of the test and returns its directory,
+// skipping the test where mounting is not permitted (e.g. without root).
+func mountTinyTmpfs(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := unix.Mount("tmpfs", dir, "tmpfs", 0, "size=1m"); err != nil {
+		t.Skipf("failed to mount a tmpfs: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := unix.Unmount(dir, 0); err != nil {
+			t.Errorf("failed to unmount the tmpfs: %v", err)
+		}
+	})
+	return dir
+}
+
+// TestPreallocateTinyTmpfs tests `preallocate` to ensure that
+// the space of a file that fits is reserved without changing its size, and a file that does not fit fails with `errNoSpace`.
+func TestPreallocateTinyTmpfs(t *testing.T) {
+	dir := mountTinyTmpfs(t)
+	file, err := os.Create(filepath.Join(dir, "file.bin"))
+	if err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if ok, err := preallocate(file, 64*1024); !ok || err != nil {
+		t.Fatalf("expected the space of a small file to be reserved, got %v and %v", ok, err)
+	}
+	if info, err := file.Stat(); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the file to stay empty, got %v and %v", info, err)
+	}
+	if _, err := preallocate(file, 4*1024*1024); !errors.Is(err, errNoSpace) {
+		t.Fatalf("expected errNoSpace for a file larger than the tmpfs, got %v", err)
+	}
+}
+
+// TestHandleConnectionPreallocateNoSpace tests `handleConnection` with "-preallocate" to ensure that
+// a file declared larger than the free space of a tiny tmpfs is refused from its header alone, before any content arrives.
+func TestHandleConnectionPreallocateNoSpace(t *testing.T) {
+	dir := mountTinyTmpfs(t)
+	withFlags(t, map[string]string{"preallocate": "true"})
+
+	// No content is sent, so the response can only come from the header.
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     4 * 1024 * 1024,
+		FileName:     "huge.bin",
+		Checksum:     protocol.CalculateDataChecksum([]byte("huge")),
+		TransferType: protocol.TransferTypeFile,
+	}, nil)
+	if status != protocol.ResponseStatusError || message != "Not enough space on the server for 4194304 bytes" {
+		t.Fatalf("expected the not enough space response, got %d: %q", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "huge.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the refused file not to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package server
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocateSupported is the default of "-preallocate", off since the platform cannot preallocate files.
+const preallocateSupported = false
+
+// preallocateFile fails with `errors.ErrUnsupported`, since files are only preallocated on Linux and macOS.
+func preallocateFile(file *os.File, size int64) error {
+	return errors.ErrUnsupported
+}
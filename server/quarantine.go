@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Suffixes of the files in the quarantine directory ("-quarantine-dir").
+// A file is received as "<transfer ID>-<name>.part", renamed to "<transfer ID>-<name>" once verified,
+// and renamed to "<transfer ID>-<name>.rejected", next to a "<transfer ID>-<name>.rejected.json" sidecar, if it fails.
+const (
+	QuarantinePartSuffix     = ".part"
+	QuarantineRejectedSuffix = ".rejected"
+	QuarantineSidecarSuffix  = ".json"
+)
+
+// errQuarantineRejected marks a verified file rejected by the "-on-complete" command in quarantine mode.
+var errQuarantineRejected = errors.New("rejected by the -on-complete command")
+
+// A quarantineRejection is the sidecar written next to a rejected file, describing why it was rejected.
+type quarantineRejection struct {
+	Time        time.Time `json:"time"`        // Time the file was rejected.
+	TransferID  string    `json:"transfer_id"` // Identifier of the transfer, as in the logs.
+	ClientIP    string    `json:"client_ip"`   // IP address of the client.
+	FileName    string    `json:"file_name"`   // Name of the file as sent by the client.
+	Destination string    `json:"destination"` // Path the file would have been stored at.
+	Bytes       int64     `json:"bytes"`       // Number of bytes received.
+	Reason      string    `json:"reason"`      // Why the file was rejected.
+}
+
+// createQuarantineFile creates the file that the content of a transfer to `outputPath` is received into,
+// in the quarantine directory.
+func createQuarantineFile(record *accessRecord, outputPath string) (*os.File, string, error) {
+	if err := os.MkdirAll(*quarantineDir, 0700); err != nil {
+		return nil, "", fmt.Errorf("failed to create the quarantine directory: %v", err)
+	}
+	path := filepath.Join(*quarantineDir, record.entry.TransferID+"-"+filepath.Base(outputPath)+QuarantinePartSuffix)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create the quarantine file: %v", err)
+	}
+	return file, path, nil
+}
+
+// rejectQuarantined renames a file in quarantine with the ".rejected" suffix and writes the sidecar with the reason.
+// Failures are logged, since the transfer has failed already.
+func rejectQuarantined(logger *slog.Logger, record *accessRecord, path, destination, reason string) {
+	rejectedPath := strings.TrimSuffix(path, QuarantinePartSuffix) + QuarantineRejectedSuffix
+	if err := os.Rename(path, rejectedPath); err != nil {
+		logger.Warn("Failed to mark the quarantined file as rejected", "path", path, "error", err)
+		return
+	}
+
+	sidecar, err := json.MarshalIndent(quarantineRejection{
+		Time:        time.Now(),
+		TransferID:  record.entry.TransferID,
+		ClientIP:    record.entry.ClientIP,
+		FileName:    record.entry.FileName,
+		Destination: destination,
+		Bytes:       record.entry.Bytes,
+		Reason:      reason,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(rejectedPath+QuarantineSidecarSuffix, append(sidecar, '\n'), 0644)
+	}
+	if err != nil {
+		logger.Warn("Failed to write the rejection sidecar", "path", rejectedPath, "error", err)
+	}
+	if *fsyncQuarantine {
+		if err := syncDir(*quarantineDir); err != nil {
+			logger.Warn("Failed to sync the rejection of the quarantined file", "path", rejectedPath, "error", err)
+		}
+	}
+	logger.Warn("Rejected the quarantined file", "path", rejectedPath, "reason", reason)
+}
+
+// releaseQuarantined releases a verified file from quarantine into `outputPath` and returns its final path.
+// The file is first renamed without the ".part" suffix, then, if an "-on-complete" command is configured,
+// the command runs on it and must succeed (or the file is rejected with `errQuarantineRejected`).
+// The conflict-resolution strategy is applied when the file is moved to its destination, with `modTime` the modification time
+// of the received file for the "newer" strategy (the zero time if it is unknown).
+func releaseQuarantined(logger *slog.Logger, record *accessRecord, path, outputPath string, checksum []byte, modTime time.Time, buffer []byte) (string, error) {
+	verifiedPath := strings.TrimSuffix(path, QuarantinePartSuffix)
+	if err := os.Rename(path, verifiedPath); err != nil {
+		return "", fmt.Errorf("failed to rename the verified file: %v", err)
+	}
+	if *fsyncQuarantine {
+		if err := syncDir(*quarantineDir); err != nil {
+			return "", err
+		}
+	}
+
+	if completeHook != nil {
+		file := completedFile{path: verifiedPath, name: record.entry.FileName, checksum: checksum, size: uint64(record.entry.Bytes)}
+		if err := completeHook.execute(file); err != nil {
+			rejectQuarantined(logger, record, verifiedPath, outputPath, fmt.Sprintf("%v: %v", errQuarantineRejected, err))
+			return "", fmt.Errorf("%w: %v", errQuarantineRejected, err)
+		}
+	}
+
+	var finalPath string
+	if *fileStrategy == StrategyRename {
+		// Reserve the name (or a unique one), which the file then replaces.
+		placeholder, uniquePath, err := createRenamedFile(outputPath)
+		if err != nil {
+			return "", err
+		}
+		if err := placeholder.Close(); err != nil {
+			logger.Warn("Error closing the reserved file", "path", uniquePath, "error", err)
+		}
+		finalPath = uniquePath
+	} else {
+		resolvedPath, err := resolveFilePath(outputPath, *fileStrategy, modTime)
+		if err != nil {
+			if removeErr := os.Remove(verifiedPath); removeErr != nil {
+				logger.Warn("Failed to remove the quarantined file", "path", verifiedPath, "error", removeErr)
+			}
+			return "", err
+		}
+		finalPath = resolvedPath
+	}
+
+	if err := moveFile(verifiedPath, finalPath, buffer, *fsyncFiles); err != nil {
+		return "", err
+	}
+	caseFolding.add(finalPath)
+	logger.Info("Released the file from quarantine", "path", finalPath)
+	return finalPath, nil
+}
+
+// moveFile moves the file at `src` to `dst`, replacing it. A move across file systems copies the file
+// to a temporary file next to `dst` and renames it, so that `dst` never holds partial content.
+// With `sync`, the copy is synced to stable storage before it is renamed.
+func moveFile(src, dst string, buffer []byte, sync bool) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+	temp, err := os.CreateTemp(filepath.Dir(dst), ".filexfer-move-*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(temp, source, buffer); err != nil {
+		_ = temp.Close()
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if sync {
+		if err := temp.Sync(); err != nil {
+			_ = temp.Close()
+			_ = os.Remove(temp.Name())
+			return err
+		}
+	}
+	if err := temp.Close(); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	if err := os.Rename(temp.Name(), dst); err != nil {
+		_ = os.Remove(temp.Name())
+		return err
+	}
+	return os.Remove(src)
+}
+
+// sweepQuarantine reports the entries of the quarantine directory last modified more than `maxAge` ago,
+// or removes them if `clean` is set, and returns how many were found.
+// It runs at startup, when no transfer is in progress, so a leftover ".part" file is from an interrupted server.
+func sweepQuarantine(dir string, maxAge time.Duration, clean bool) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the quarantine directory: %v", err)
+	}
+
+	stale := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		stale++
+		path := filepath.Join(dir, entry.Name())
+		if !clean {
+			slog.Warn("Stale quarantine entry", "path", path, "mod_time", info.ModTime())
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Failed to remove the stale quarantine entry", "path", path, "error", err)
+			continue
+		}
+		slog.Info("Removed the stale quarantine entry", "path", path, "mod_time", info.ModTime())
+	}
+	return stale, nil
+}
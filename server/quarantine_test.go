@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/json"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withQuarantine receives the test's transfers into a new quarantine directory, and returns it.
+func withQuarantine(t *testing.T, flags map[string]string) string {
+	t.Helper()
+
+	quarantine := t.TempDir()
+	values := map[string]string{"quarantine-dir": quarantine}
+	for name, value := range flags {
+		values[name] = value
+	}
+	withFlags(t, values)
+	return quarantine
+}
+
+// readRejection reads the sidecar of the only rejected file in the quarantine directory.
+func readRejection(t *testing.T, quarantine string) quarantineRejection {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(quarantine, "*"+QuarantineRejectedSuffix))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("expected 1 rejected file, got %v (%v)", matches, err)
+	}
+	data, err := os.ReadFile(matches[0] + QuarantineSidecarSuffix)
+	if err != nil {
+		t.Fatalf("expected a sidecar next to the rejected file: %v", err)
+	}
+	var rejection quarantineRejection
+	if err := json.Unmarshal(data, &rejection); err != nil {
+		t.Fatalf("expected a JSON sidecar, got %q: %v", data, err)
+	}
+	return rejection
+}
+
+// TestQuarantineReleasesVerifiedFiles tests the "-quarantine-dir" mode to ensure that
+// verified files are moved to the destination directory, with the conflict-resolution strategy applied at the move.
+func TestQuarantineReleasesVerifiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	quarantine := withQuarantine(t, nil)
+
+	for _, content := range []string{"first", "second"} {
+		if status, message := sendFile(t, dir, "a.txt", []byte(content)); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response, got %d: %s", status, message)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "a_1.txt")); err != nil || string(got) != "second" {
+		t.Fatalf("expected the second file renamed, got %q (%v)", got, err)
+	}
+
+	withFlags(t, map[string]string{"strategy": StrategySkip})
+	if status, message := sendFile(t, dir, "a.txt", []byte("third")); status != protocol.ResponseStatusSkipped || !strings.Contains(message, "skip") {
+		t.Fatalf("expected the skip error, got %d: %s", status, message)
+	}
+
+	if entries, err := os.ReadDir(quarantine); err != nil || len(entries) != 0 {
+		t.Fatalf("expected an empty quarantine directory, got %v (%v)", entries, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(got) != "first" {
+		t.Fatalf("expected the first file kept, got %q (%v)", got, err)
+	}
+}
+
+// TestQuarantineRejectsCorruptedFiles tests the "-quarantine-dir" mode to ensure that
+// a file failing verification is left in quarantine as rejected, with a sidecar giving the reason.
+func TestQuarantineRejectsCorruptedFiles(t *testing.T) {
+	dir := t.TempDir()
+	quarantine := withQuarantine(t, nil)
+
+	content := []byte("corrupted")
+	status, _ := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "bad.txt",
+		Checksum:     protocol.CalculateDataChecksum([]byte("expected")),
+		TransferType: protocol.TransferTypeFile,
+	}, content)
+	if status != protocol.ResponseStatusError {
+		t.Fatal("expected an error response for a checksum mismatch")
+	}
+
+	rejection := readRejection(t, quarantine)
+	if rejection.FileName != "bad.txt" || rejection.Destination != filepath.Join(dir, "bad.txt") ||
+		rejection.Bytes != int64(len(content)) || rejection.TransferID == "" || !strings.Contains(rejection.Reason, "checksum mismatch") {
+		t.Fatalf("unexpected rejection: %+v", rejection)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bad.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing stored in the destination directory, got %v", err)
+	}
+}
+
+// TestQuarantineHookGate tests the "-quarantine-dir" mode to ensure that
+// the "-on-complete" command runs on the quarantined file, and that its failure rejects the file.
+func TestQuarantineHookGate(t *testing.T) {
+	dir := t.TempDir()
+	quarantine := withQuarantine(t, nil)
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	// The command only accepts files containing "clean", and records the path it was run on.
+	completeHook = newHookRunner("echo {path} > "+shellQuote(marker)+" && grep -q clean {path}", 1, 1)
+	defer func() {
+		completeHook.close()
+		completeHook = nil
+	}()
+
+	if status, message := sendFile(t, dir, "ok.txt", []byte("clean content")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if got, err := os.ReadFile(marker); err != nil || !strings.HasPrefix(string(got), quarantine) {
+		t.Fatalf("expected the command to run on the quarantined file, got %q (%v)", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ok.txt")); err != nil {
+		t.Fatalf("expected the accepted file to be released: %v", err)
+	}
+
+	if status, _ := sendFile(t, dir, "virus.txt", []byte("infected content")); status != protocol.ResponseStatusError {
+		t.Fatal("expected an error response for a file rejected by the command")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "virus.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the rejected file not to be released, got %v", err)
+	}
+	if rejection := readRejection(t, quarantine); rejection.FileName != "virus.txt" || !strings.Contains(rejection.Reason, "-on-complete") {
+		t.Fatalf("unexpected rejection: %+v", rejection)
+	}
+}
+
+// TestQuarantineRefusesArchives tests the "-quarantine-dir" mode to ensure that archive transfers are refused.
+func TestQuarantineRefusesArchives(t *testing.T) {
+	withQuarantine(t, nil)
+
+	status, message := sendRequest(t, t.TempDir(), &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileName:     "archive",
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeTarArchive,
+	}, nil)
+	if status != protocol.ResponseStatusError || !strings.Contains(message, "quarantine") {
+		t.Fatalf("expected the archive to be refused, got %d: %s", status, message)
+	}
+}
+
+// TestSweepQuarantine tests `sweepQuarantine` to ensure that
+// only entries older than the maximum age are reported, and removed when cleaning.
+func TestSweepQuarantine(t *testing.T) {
+	quarantine := t.TempDir()
+	stale := filepath.Join(quarantine, "0123-a.txt"+QuarantinePartSuffix)
+	fresh := filepath.Join(quarantine, "4567-b.txt"+QuarantineRejectedSuffix)
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if count, err := sweepQuarantine(quarantine, time.Hour, false); err != nil || count != 1 {
+		t.Fatalf("expected 1 stale entry, got %d (%v)", count, err)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("expected the stale entry to be kept without cleaning: %v", err)
+	}
+
+	if count, err := sweepQuarantine(quarantine, time.Hour, true); err != nil || count != 1 {
+		t.Fatalf("expected 1 stale entry, got %d (%v)", count, err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected the stale entry to be removed, got %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("expected the fresh entry to be kept: %v", err)
+	}
+}
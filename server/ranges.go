@@ -0,0 +1,387 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// A rangeTransfer is a file sent as byte ranges over several connections (`protocol.TransferTypeRange`),
+// assembled in a partial file next to its destination until all of its ranges have been received.
+type rangeTransfer struct {
+	mutex        sync.Mutex
+	name         string               // File name of the headers, as received.
+	outputPath   string               // Destination of the file, before the conflict-resolution strategy.
+	partPath     string               // Partial file the ranges are written into.
+	size         uint64               // Size of the whole file.
+	checksum     []byte               // Checksum of the whole file.
+	modTime      time.Time            // Modification time of the file (the zero time if it is not sent).
+	received     []protocol.ByteRange // Ranges received so far, sorted by offset and merged.
+	active       int                  // Number of ranges being written.
+	lastActivity time.Time            // Time a range was last started or finished.
+
+	// Response to the range that completed the file, replayed to the retransmission of a range after that.
+	done     bool
+	status   uint8
+	code     uint16
+	response string
+	receipt  *protocol.Receipt // Receipt of the stored file, if it was stored.
+}
+
+// rangeTransfers holds the incomplete (and recently completed) range transfers by their identifiers.
+// An entry is removed by `sweepRangeTransfers` once it has been idle for "-range-timeout".
+var rangeTransfers = struct {
+	sync.Mutex
+	byID    map[string]*rangeTransfer
+	started map[string]time.Time // Time the first range of each transfer arrived, kept as long as the transfer (see `rangeStartTime`).
+}{byID: make(map[string]*rangeTransfer), started: make(map[string]time.Time)}
+
+// errRangeMismatch is returned for a range whose header describes another file than the other ranges of its transfer.
+var errRangeMismatch = errors.New("range does not match the file of its transfer")
+
+// rangePartPath returns the path of the partial file that the ranges of the transfer `id` are written into.
+func rangePartPath(outputPath, id string) string {
+	return filepath.Join(filepath.Dir(outputPath), ".filexfer-range-"+id+".part")
+}
+
+// rangeStartTime returns the time the first range of the transfer `id` arrived, recording `now` if this is the first,
+// so that "-dest-template" expands the same directory for every range, even for a transfer that crosses midnight.
+func rangeStartTime(id string, now time.Time) time.Time {
+	rangeTransfers.Lock()
+	defer rangeTransfers.Unlock()
+
+	if started, ok := rangeTransfers.started[id]; ok {
+		return started
+	}
+	rangeTransfers.started[id] = now
+	return now
+}
+
+// openRangeTransfer returns the range transfer of the header, starting it with an empty partial file
+// of the size of the whole file (sparse, where the file system supports it) if it is the first range received.
+func openRangeTransfer(header *protocol.Header, outputPath string) (*rangeTransfer, error) {
+	rangeTransfers.Lock()
+	defer rangeTransfers.Unlock()
+
+	if transfer, ok := rangeTransfers.byID[header.Range.ID]; ok {
+		if transfer.outputPath != outputPath || transfer.size != header.FileSize || !bytes.Equal(transfer.checksum, header.Checksum) ||
+			!transfer.modTime.Equal(header.ModTime) {
+			return nil, fmt.Errorf("%w: %s", errRangeMismatch, header.Range.ID)
+		}
+		return transfer, nil
+	}
+
+	partPath := rangePartPath(outputPath, header.Range.ID)
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the partial file: %w", err)
+	}
+	err = file.Truncate(int64(header.FileSize))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+		return nil, fmt.Errorf("failed to allocate the partial file: %w", err)
+	}
+
+	transfer := &rangeTransfer{
+		name:         header.FileName,
+		outputPath:   outputPath,
+		partPath:     partPath,
+		size:         header.FileSize,
+		checksum:     bytes.Clone(header.Checksum),
+		modTime:      header.ModTime,
+		lastActivity: time.Now(),
+	}
+	rangeTransfers.byID[header.Range.ID] = transfer
+	return transfer, nil
+}
+
+// begin registers a range being written, unless the file is already complete, in which case it returns false.
+func (rt *rangeTransfer) begin() bool {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	rt.lastActivity = time.Now()
+	if rt.done {
+		return false
+	}
+	rt.active++
+	return true
+}
+
+// replay returns the response to the range that completed the file, and the receipt of the stored file.
+func (rt *rangeTransfer) replay() (uint8, uint16, string, *protocol.Receipt) {
+	rt.mutex.Lock()
+	defer rt.mutex.Unlock()
+	return rt.status, rt.code, rt.response, rt.receipt
+}
+
+// covered returns the number of bytes of the file covered by the received ranges.
+func (rt *rangeTransfer) covered() uint64 {
+	var total uint64
+	for _, received := range rt.received {
+		total += received.Length
+	}
+	return total
+}
+
+// add records the received range, merging it with the ranges it overlaps or adjoins.
+func (rt *rangeTransfer) add(byteRange protocol.ByteRange) {
+	ranges := append(rt.received, byteRange)
+	slices.SortFunc(ranges, func(a, b protocol.ByteRange) int { return cmp.Compare(a.Offset, b.Offset) })
+
+	merged := ranges[:1]
+	for _, next := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if next.Offset <= last.Offset+last.Length {
+			last.Length = max(last.Offset+last.Length, next.Offset+next.Length) - last.Offset
+			continue
+		}
+		merged = append(merged, next)
+	}
+	rt.received = merged
+}
+
+// handleRangeTransfer receives a byte range of a file sent over several connections (`protocol.TransferTypeRange`)
+// into the partial file of its transfer. Each range is answered once it is written, with `protocol.RangeReceivedMessage`,
+// except for the last range of the file, whose connection waits until the checksum of the whole file is verified and the file
+// is moved to its destination, and is answered like the transfer of a whole file. A range can be sent again after a failure,
+// and one sent again after the file is complete is answered like the range that completed it.
+// It returns false if the connection can no longer be used, e.g. because the range was not read in full.
+func handleRangeTransfer(ctxReader *contextReader, conn net.Conn, header *protocol.Header, logger *slog.Logger, record *accessRecord, buffer []byte) bool {
+	startTime := time.Now()
+	byteRange := *header.Range
+	logger = logger.With("file_name", header.FileName, "range_id", byteRange.ID)
+	logger.Info("Receiving a file range", "offset", byteRange.Offset, "bytes", byteRange.Length, "file_bytes", header.FileSize)
+
+	// The ranges are assembled in place, so they cannot go through quarantine like whole files.
+	if *quarantineDir != "" {
+		logger.Warn("Refusing a range transfer in quarantine mode")
+		record.fail(conn, "Range transfers are not accepted with quarantine")
+		return false
+	}
+
+	outputPath, err := destinationPath(header)
+	if err != nil {
+		logger.Warn("Path sanitization failed", "error", err)
+		record.fail(conn, fmt.Sprintf("Invalid file path: %v", err))
+		return false
+	}
+	outputDir := filepath.Dir(outputPath)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		logger.Error("Failed to create the directory structure", "dir", outputDir, "error", err)
+		record.fail(conn, "Failed to create directory structure")
+		return false
+	}
+
+	transfer, err := openRangeTransfer(header, outputPath)
+	if err != nil {
+		logger.Error("Failed to start the range transfer", "error", err)
+		if errors.Is(err, errRangeMismatch) {
+			record.fail(conn, "Range does not match the other ranges of its file")
+		} else {
+			record.fail(conn, "Failed to create output file")
+		}
+		return false
+	}
+
+	contentReader := protocol.NewContentReader(ctxReader, int64(byteRange.Length))
+	if !transfer.begin() {
+		// The file is complete, so the range is read to keep the connection in step, and answered as the file was.
+		if _, err := io.CopyBuffer(io.Discard, contentReader, buffer); err != nil {
+			logger.Error("Failed to receive the content of the range", "error", err)
+			record.fail(conn, "Failed to receive file content")
+			return false
+		}
+		status, code, message, receipt := transfer.replay()
+		logger.Info("Range received again after its file was completed", "status", status)
+		sendTransferResponse(conn, status, code, message, receipt)
+		if status == protocol.ResponseStatusSuccess {
+			record.complete(transfer.partPath, int64(byteRange.Length), nil)
+		} else {
+			record.finish(AccessStatusFailed, message)
+		}
+		return true
+	}
+
+	bytesWritten, err := writeRange(transfer.partPath, byteRange, contentReader, buffer)
+	record.entry.Bytes = bytesWritten
+
+	transfer.mutex.Lock()
+	defer transfer.mutex.Unlock()
+	transfer.active--
+	transfer.lastActivity = time.Now()
+
+	if err != nil {
+		// The range is not recorded, so that it can be sent again.
+		logger.Error("Failed to receive the range", "bytes", bytesWritten, "error", err)
+		switch {
+		case ctxReader.ctx.Err() != nil:
+			record.abort(conn, logger)
+		case errors.Is(err, protocol.ErrIncompleteContent):
+			record.fail(conn, "File size mismatch: "+protocol.ErrIncompleteContent.Error())
+		default:
+			record.fail(conn, "Failed to receive file content")
+		}
+		return false
+	}
+	transfer.add(byteRange)
+
+	// Another range still being written finishes the file instead, once it has landed.
+	if transfer.done || transfer.covered() < transfer.size || transfer.active > 0 {
+		logger.Info("Range received", "received_bytes", transfer.covered(), "duration_ms", time.Since(startTime).Milliseconds())
+		sendSuccessResponse(conn, protocol.RangeReceivedMessage)
+		record.complete(transfer.partPath, bytesWritten, nil)
+		return true
+	}
+
+	transfer.finish(ctxReader.ctx, header, logger, record)
+	// Verifying the whole file may have taken a while.
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		logger.Error("Failed to set the write deadline", "error", err)
+		return false
+	}
+	sendTransferResponse(conn, transfer.status, transfer.code, transfer.response, transfer.receipt)
+	if transfer.status == protocol.ResponseStatusSuccess {
+		logger.Info("Transfer completed", "bytes", transfer.size, "path", record.entry.Path, "duration_ms", time.Since(startTime).Milliseconds())
+	}
+	return true
+}
+
+// writeRange writes the content of the range at its offset in the partial file, and returns the number of bytes written.
+func writeRange(partPath string, byteRange protocol.ByteRange, content io.Reader, buffer []byte) (int64, error) {
+	file, err := os.OpenFile(partPath, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open the partial file: %w", err)
+	}
+	written, err := io.CopyBuffer(io.NewOffsetWriter(file, int64(byteRange.Offset)), content, buffer)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close the partial file: %w", closeErr)
+	}
+	return written, err
+}
+
+// finish verifies the checksum of the assembled file and moves it to its destination with the conflict-resolution strategy,
+// recording the response for the range that completed it (see `replay`). The caller holds the mutex.
+func (rt *rangeTransfer) finish(ctx context.Context, header *protocol.Header, logger *slog.Logger, record *accessRecord) {
+	rt.done = true
+	record.begin(rt.partPath, int64(rt.size))
+	fail := func(status uint8, code uint16, message string) {
+		rt.status, rt.code, rt.response = status, code, message
+		record.finish(AccessStatusFailed, message)
+		if err := os.Remove(rt.partPath); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove the partial file", "path", rt.partPath, "error", err)
+		}
+	}
+
+	checksum, err := protocol.CalculateFileChecksumFromPath(ctx, rt.partPath)
+	if err != nil {
+		logger.Error("Failed to verify the assembled file", "path", rt.partPath, "error", err)
+		fail(protocol.ResponseStatusError, protocol.ErrorCodeNone, "Failed to verify the assembled file")
+		return
+	}
+	if !bytes.Equal(checksum, rt.checksum) {
+		logger.Error("Data checksum verification failed",
+			"expected_checksum", hex.EncodeToString(rt.checksum), "checksum", hex.EncodeToString(checksum))
+		fail(protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+		return
+	}
+
+	var finalPath string
+	if *fileStrategy == StrategyRename {
+		var file *os.File
+		file, finalPath, err = createRenamedFile(rt.outputPath)
+		if err == nil {
+			err = file.Close()
+		}
+	} else {
+		finalPath, err = resolveFilePath(rt.outputPath, *fileStrategy, rt.modTime)
+	}
+	// The modification time is set before the rename, so that the file appears with it.
+	if err == nil && !rt.modTime.IsZero() {
+		restoreModTime(logger, rt.partPath, rt.modTime)
+	}
+	// With "-fsync", the ranges written through their own descriptors are on stable storage before the rename.
+	if err == nil && *fsyncFiles {
+		err = syncPath(rt.partPath)
+	}
+	if err == nil {
+		err = os.Rename(rt.partPath, finalPath)
+	}
+	if err == nil && *fsyncFiles {
+		err = syncDir(filepath.Dir(finalPath))
+	}
+	switch {
+	case errors.Is(err, errSkipExisting):
+		logger.Info("Skipping the existing file", "strategy", *fileStrategy, "error", err)
+		fail(protocol.ResponseStatusSkipped, protocol.ErrorCodeConflictSkip, skippedMessage())
+		return
+	case err != nil:
+		logger.Error("Failed to store the assembled file", "path", rt.outputPath, "error", err)
+		fail(protocol.ResponseStatusError, protocol.ErrorCodeNone, "Failed to store the file")
+		return
+	}
+
+	caseFolding.add(finalPath)
+	if *dedup {
+		recordDedup(checksum, finalPath)
+	}
+	recordStoredChecksum(finalPath, checksum)
+
+	rt.status, rt.code, rt.response = protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(checksum)
+	rt.receipt = transferReceipt(finalPath, rt.size, checksum)
+	record.complete(finalPath, int64(rt.size), checksum)
+	notifyCompleted(record, completedFile{
+		path:     finalPath,
+		name:     rt.name,
+		checksum: checksum,
+		size:     rt.size,
+	})
+}
+
+// sweepRangeTransfers removes the range transfers idle for longer than `timeout` as of `now`, with the partial files of
+// the incomplete ones, so that a client that gave up on a file does not leave it behind. It returns the number removed.
+// A transfer whose ranges are being written or that is being completed is kept.
+func sweepRangeTransfers(now time.Time, timeout time.Duration) int {
+	rangeTransfers.Lock()
+	defer rangeTransfers.Unlock()
+
+	removed := 0
+	for id, transfer := range rangeTransfers.byID {
+		if !transfer.mutex.TryLock() {
+			continue
+		}
+		if transfer.active == 0 && now.Sub(transfer.lastActivity) > timeout {
+			if !transfer.done {
+				slog.Info("Removing an incomplete range transfer", "range_id", id, "file_name", transfer.name,
+					"received_bytes", transfer.covered(), "bytes", transfer.size)
+				if err := os.Remove(transfer.partPath); err != nil && !os.IsNotExist(err) {
+					slog.Warn("Failed to remove the partial file", "path", transfer.partPath, "error", err)
+				}
+			}
+			delete(rangeTransfers.byID, id)
+			removed++
+		}
+		transfer.mutex.Unlock()
+	}
+	// The start times outlive their transfer only if its first range failed before the transfer was opened.
+	for id, started := range rangeTransfers.started {
+		if _, ok := rangeTransfers.byID[id]; !ok && now.Sub(started) > timeout {
+			delete(rangeTransfers.started, id)
+		}
+	}
+	return removed
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sendRange sends the range of the content as a range transfer of the file and returns the server's response.
+func sendRange(t *testing.T, dir, fileName string, content []byte, byteRange protocol.ByteRange) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, rangeHeader(fileName, content, byteRange), content[byteRange.Offset:byteRange.Offset+byteRange.Length])
+}
+
+// rangeHeader returns the header of a range transfer of the range of the content.
+func rangeHeader(fileName string, content []byte, byteRange protocol.ByteRange) *protocol.Header {
+	return &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     fileName,
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeRange,
+		Range:        &byteRange,
+	}
+}
+
+// TestHandleRangeTransfer tests the range transfer handling to ensure that
+// ranges received in any order are assembled into the file once the last one lands, answered like a whole file,
+// and that a range sent again after that is answered the same way.
+func TestHandleRangeTransfer(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 3, 0)
+
+	for _, i := range []int{2, 0} {
+		if status, message := sendRange(t, dir, "file.txt", content, ranges[i]); status != protocol.ResponseStatusSuccess ||
+			message != protocol.RangeReceivedMessage {
+			t.Fatalf("expected the range %d to be received, got status %d with %q", i, status, message)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "file.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file before all ranges are received, got %v", err)
+	}
+
+	expected := protocol.TransferReceivedMessage(protocol.CalculateDataChecksum(content))
+	for _, i := range []int{1, 0} {
+		if status, message := sendRange(t, dir, "file.txt", content, ranges[i]); status != protocol.ResponseStatusSuccess ||
+			message != expected {
+			t.Fatalf("expected the file to be completed by the range %d, got status %d with %q", i, status, message)
+		}
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+	if err != nil || string(got) != string(content) {
+		t.Fatalf("expected the assembled file %q, got %q and %v", content, got, err)
+	}
+	if _, err := os.Stat(rangePartPath(filepath.Join(dir, "file.txt"), ranges[0].ID)); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be moved, got %v", err)
+	}
+}
+
+// TestHandleRangeTransferChecksumMismatch tests the range transfer handling to ensure that
+// an assembled file that does not match the checksum of the headers is refused and removed.
+func TestHandleRangeTransferChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0)
+
+	if status, _ := sendRange(t, dir, "file.txt", content, ranges[0]); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the first range to be received, got status %d", status)
+	}
+	corrupted := []byte(string(content))
+	corrupted[len(corrupted)-1] ^= 0xFF
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "file.txt",
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeRange,
+		Range:        &ranges[1],
+	}
+	if status, message := sendRequest(t, dir, header, corrupted[ranges[1].Offset:]); status != protocol.ResponseStatusError ||
+		message != "Data integrity check failed" {
+		t.Fatalf("expected an integrity error, got status %d with %q", status, message)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("expected nothing to be stored, got %v and %v", entries, err)
+	}
+}
+
+// TestHandleRangeTransferMismatch tests the range transfer handling to ensure that
+// a range of another file than the other ranges of its transfer is refused.
+func TestHandleRangeTransferMismatch(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0)
+
+	if status, _ := sendRange(t, dir, "file.txt", content, ranges[0]); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the first range to be received, got status %d", status)
+	}
+	other := append([]byte(string(content)), '!')
+	if status, message := sendRange(t, dir, "file.txt", other, ranges[1]); status != protocol.ResponseStatusError ||
+		message != "Range does not match the other ranges of its file" {
+		t.Fatalf("expected a mismatch error, got status %d with %q", status, message)
+	}
+}
+
+// TestHandleRangeTransferNewerStrategy tests the range transfer handling to ensure that the "newer" strategy compares
+// the modification time sent with the ranges, replacing an older existing file with the assembled one, which gets that time.
+func TestHandleRangeTransferNewerStrategy(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"strategy": StrategyNewer})
+	existingTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+		t.Fatalf("failed to create the existing file: %v", err)
+	}
+	if err := os.Chtimes(path, existingTime, existingTime); err != nil {
+		t.Fatalf("failed to set the modification time: %v", err)
+	}
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	for _, modTime := range []time.Time{existingTime, existingTime.Add(time.Minute)} {
+		var status uint8
+		var message string
+		for _, byteRange := range protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0) {
+			status, message = sendRequest(t, dir, &protocol.Header{
+				MessageType:  protocol.MessageTypeTransfer,
+				FileSize:     uint64(len(content)),
+				FileName:     "file.txt",
+				Checksum:     protocol.CalculateDataChecksum(content),
+				TransferType: protocol.TransferTypeRange,
+				Range:        &byteRange,
+				ModTime:      modTime,
+			}, content[byteRange.Offset:byteRange.Offset+byteRange.Length])
+		}
+		expectedStatus, expectedContent, expectedTime := uint8(protocol.ResponseStatusSkipped), "existing", existingTime
+		if modTime.After(existingTime) {
+			expectedStatus, expectedContent, expectedTime = protocol.ResponseStatusSuccess, string(content), modTime
+		}
+		if status != expectedStatus {
+			t.Fatalf("expected status %d for the time %v, got %d: %q", expectedStatus, modTime, status, message)
+		}
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != expectedContent {
+			t.Fatalf("expected %q for the time %v, got %q and %v", expectedContent, modTime, got, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat the file: %v", err)
+		}
+		if !info.ModTime().Equal(expectedTime) {
+			t.Fatalf("expected the modification time %v, got %v", expectedTime, info.ModTime())
+		}
+	}
+}
+
+// TestHandleRangeTransferQuarantine tests the range transfer handling to ensure that ranges are refused in quarantine mode.
+func TestHandleRangeTransferQuarantine(t *testing.T) {
+	withFlags(t, map[string]string{"quarantine-dir": t.TempDir()})
+
+	content := []byte("content")
+	byteRange := protocol.ByteRange{ID: protocol.NewTransferID(), Length: uint64(len(content))}
+	if status, message := sendRange(t, t.TempDir(), "file.txt", content, byteRange); status != protocol.ResponseStatusError ||
+		message != "Range transfers are not accepted with quarantine" {
+		t.Fatalf("expected a refusal, got status %d with %q", status, message)
+	}
+}
+
+// TestSweepRangeTransfers tests `sweepRangeTransfers` to ensure that
+// an idle incomplete transfer is removed with its partial file, while a recent one is kept.
+func TestSweepRangeTransfers(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	ranges := protocol.SplitRanges(protocol.NewTransferID(), uint64(len(content)), 2, 0)
+	if status, _ := sendRange(t, dir, "file.txt", content, ranges[0]); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the first range to be received, got status %d", status)
+	}
+	partPath := rangePartPath(filepath.Join(dir, "file.txt"), ranges[0].ID)
+
+	sweepRangeTransfers(time.Now(), time.Minute)
+	if _, err := os.Stat(partPath); err != nil {
+		t.Fatalf("expected the recent transfer to be kept, got %v", err)
+	}
+
+	if removed := sweepRangeTransfers(time.Now().Add(2*time.Minute), time.Minute); removed < 1 {
+		t.Fatalf("expected the idle transfer to be removed, got %d removed", removed)
+	}
+	if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be removed, got %v", err)
+	}
+	rangeTransfers.Lock()
+	_, ok := rangeTransfers.byID[ranges[0].ID]
+	rangeTransfers.Unlock()
+	if ok {
+		t.Fatal("expected the transfer to be forgotten")
+	}
+}
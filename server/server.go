@@ -0,0 +1,2527 @@
+// Package server implements the filexfer server: it receives files over the protocol of the `protocol` package
+// and stores them under its destination directory. The server command (cmd/server) runs `Main`,
+// and `StartTestServer` runs one in the background, e.g. for integration tests.
+package server
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"filexfer/protocol"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Errors for representing specific validation failures.
+var (
+	ErrInvalidFileSize   = errors.New("invalid file size")
+	ErrEmptyFilename     = errors.New("empty file name")
+	ErrFileTooLarge      = errors.New("file size exceeds the maximum allowed size")
+	ErrDirectoryTooLarge = errors.New("directory transfer size exceeds the maximum allowed size")
+	ErrRejectedFileName  = errors.New("file name rejected by the server's patterns")
+	ErrAbsolutePath      = errors.New("absolute paths are not allowed")
+	ErrPathTraversal     = errors.New("parent directory traversal is not allowed")
+	ErrReservedPath      = errors.New("path is reserved by the server")
+)
+
+// Constants for file conflict-resolution strategies.
+const (
+	StrategyOverwrite = "overwrite" // Overwrite the existing file.
+	StrategyRename    = "rename"    // Rename the file to avoid conflicts.
+	StrategySkip      = "skip"      // Skip the file if it already exists.
+	StrategyNewer     = "newer"     // Overwrite the existing file only if the received one is strictly newer, otherwise skip it.
+)
+
+// Values of the "-normalize-form" flag.
+const (
+	NormalizeFormNFC = "nfc" // Composed form, as sent by Linux and Windows clients.
+	NormalizeFormNFD = "nfd" // Decomposed form, as sent by macOS clients.
+)
+
+// strategies lists the file conflict-resolution strategies with their descriptions, as printed by "-list-strategies".
+// A strategy is valid only if it is listed here (see `validateStrategy`).
+var strategies = []struct {
+	name        string
+	description string
+}{
+	{StrategyOverwrite, "Overwrite the existing file."},
+	{StrategyRename, "Store the received file under a unique name (file_1.txt, file_2.txt, ...) next to the existing one."},
+	{StrategySkip, "Keep the existing file and skip the received one."},
+	{StrategyNewer, "Overwrite the existing file only if the received one is strictly newer (tar archive entries, and files sent with their modification time), otherwise skip it."},
+}
+
+// strategyNames returns the names of the file conflict-resolution strategies, separated by commas.
+func strategyNames() string {
+	names := make([]string, len(strategies))
+	for i, strategy := range strategies {
+		names[i] = strategy.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// validateStrategy returns an error if the strategy is not a known file conflict-resolution strategy.
+func validateStrategy(strategy string) error {
+	for _, known := range strategies {
+		if strategy == known.name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown file conflict-resolution strategy %q, expected one of: %s", strategy, strategyNames())
+}
+
+// printStrategies prints the file conflict-resolution strategies with their descriptions for "-list-strategies".
+func printStrategies(w io.Writer) {
+	for _, strategy := range strategies {
+		fmt.Fprintf(w, "%-10s %s\n", strategy.name, strategy.description)
+	}
+}
+
+// Constants for server configuration.
+const (
+	MaxFileSize        = 5 * 1024 * 1024 * 1024  // 5GB limit.
+	MaxDirectorySize   = 50 * 1024 * 1024 * 1024 // 50GB limit for directory transfers.
+	LogPrefix          = "[SERVER]"              // Log prefix.
+	ReadTimeout        = 30 * time.Second        // Read timeout.
+	WriteTimeout       = 30 * time.Second        // Write timeout.
+	ShutdownTimeout    = 30 * time.Second        // Shutdown timeout.
+	TransferBufferSize = 256 * 1024              // Default 256KB buffer for `io.CopyBuffer` to improve throughput (see `protocol.CopyBuffers`).
+	MaxBufferSize      = 64 * 1024 * 1024        // Maximum allowed copy buffer size (64MB).
+	MaxQueryHashSize   = 64 * 1024 * 1024        // Largest file hashed to answer a sync query without "-sync-deep" (64MB).
+	ChecksumCacheSize  = 100000                  // Default number of checksums of stored files remembered for sync queries.
+	HookWorkers        = 4                       // Number of "-on-complete" commands run concurrently.
+	HookQueueSize      = 256                     // Number of received files queued for "-on-complete" before transfers wait for a worker.
+	HookTimeout        = 10 * time.Minute        // Time limit of a single "-on-complete" command.
+	HookOutputLimit    = 1024                    // Maximum number of bytes of a failed command's output that are logged.
+	AccessLogMaxSize   = 100 * 1024 * 1024       // Default size at which the access log is rotated (100MB).
+	QuarantineMaxAge   = 7 * 24 * time.Hour      // Default age at which quarantine entries are stale.
+	RangeTimeout       = 10 * time.Minute        // Default time after which an idle range transfer is removed.
+	WebhookWorkers     = 4                       // Number of webhook notifications delivered concurrently.
+	WebhookQueueSize   = 256                     // Number of webhook notifications queued before new ones are dropped.
+	WebhookTimeout     = 10 * time.Second        // Default time limit of a single webhook delivery attempt.
+	WebhookRetries     = 3                       // Default number of retries of a failed webhook delivery.
+	WebhookBackoff     = time.Second             // Delay before the first retry of a webhook delivery, doubled for every following one.
+	TrailingDataWait   = time.Millisecond        // Default time waited for data sent after the declared content of a file.
+	HTTPDrainTimeout   = 5 * time.Second         // Time the debug and metrics endpoints wait for their requests in progress on shutdown.
+	DrainLogInterval   = 5 * time.Second         // Interval at which the transfers still in progress are logged during a shutdown.
+)
+
+// Flags holds the command-line flags of the server, parsed by `Main`. It is separate from `flag.CommandLine`,
+// so that a program importing the package (e.g. to run a `TestServer`) keeps its own flags.
+var Flags = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+// Command-line flags for server configuration.
+var (
+	listenPort       = Flags.String("port", "8080", "Listening port")
+	bindAddr         = Flags.String("bind", "", "Interface address to listen on, e.g. 127.0.0.1 or ::1 (all interfaces if empty)")
+	destDir          = Flags.String("dir", "test", "Destination directory for received files")
+	fileStrategy     = Flags.String("strategy", "rename", "File conflict-resolution strategy: "+strategyNames()+" (see -list-strategies)")
+	listStrategies   = Flags.Bool("list-strategies", false, "Print the file conflict-resolution strategies of -strategy with their descriptions and exit")
+	maxDirectorySize = newUint64Setting("max-dir-size", MaxDirectorySize, "Maximum directory transfer size in bytes")
+	tlsCertFile      = Flags.String("tls-cert", "", "Path to TLS certificate file (required for TLS)")
+	tlsKeyFile       = Flags.String("tls-key", "", "Path to TLS private key file (required for TLS)")
+	requireClientTLS = Flags.Bool("require-client-cert", false, "Require clients to present a TLS certificate signed by -client-ca (mutual TLS)")
+	clientCAFile     = Flags.String("client-ca", "", "Path to the CA certificate that client certificates are verified against (with -require-client-cert)")
+	tenantDirs       = Flags.Bool("tenant-dirs", false, "Store the files of each client in a subdirectory named after its TLS certificate's common name (with -require-client-cert) or its IP address")
+	destTemplate     = Flags.String("dest-template", "", "Store each received file under a subdirectory of the destination directory expanded from this template, with the placeholders "+TemplateDate+", "+TemplateYear+", "+TemplateMonth+", "+TemplateDay+", "+TemplateClientIP+", and "+TemplateTransferID+" (e.g. \""+TemplateDate+"/"+TemplateClientIP+"\")")
+	tcpNoDelay       = Flags.Bool("tcp-nodelay", true, "Disable Nagle's algorithm on client connections, so that headers and responses are sent without delay")
+	tcpKeepAlive     = Flags.Duration("tcp-keepalive", protocol.DefaultKeepAlivePeriod, "Interval of TCP keep-alive probes on idle client connections (0 to disable keep-alive)")
+	rejectPatterns   = newStringListFlag("reject-pattern", "Glob pattern of file names refused by the server, e.g. *.exe or uploads/**/*.sh (repeatable)")
+	allowPatterns    = newStringListFlag("allow-pattern", "Glob pattern of file names accepted by the server, refusing all others (repeatable)")
+	patternNocase    = Flags.Bool("reject-pattern-nocase", false, "Match -reject-pattern and -allow-pattern case-insensitively")
+	flatten          = Flags.Bool("flatten", false, "Store the files of directory transfers directly in the destination directory, without their subdirectories")
+	allowDelete      = Flags.Bool("allow-delete", false, "Allow clients to delete files (and, with a recursive request, directories) under the destination directory")
+	dedup            = Flags.Bool("dedup", false, "Hard-link received files whose content (by checksum) is already stored instead of rewriting it")
+	bufferSize       = Flags.Int("buffer-size", TransferBufferSize, "Size of the copy buffer in bytes used for transfers")
+	maxNameLength    = Flags.Int("max-name-length", protocol.MaxPathComponentLength, "Maximum length of each file or directory name in a received path in bytes")
+	trailingWait     = Flags.Duration("trailing-data-wait", TrailingDataWait, "Time waited after the content of a file for data the client sent beyond its declared size, which fails the transfer (0 to disable the check)")
+	sanitizeNames    = Flags.Bool("sanitize-names", false, "Store files with unsafe names (control characters, reserved Windows names, trailing dots or spaces) under percent-encoded names instead of refusing them")
+	normalizeUnicode = Flags.Bool("normalize-unicode", true, "Normalize received file and directory names to the Unicode form of -normalize-form, so that names sent in NFD (e.g. by macOS) match the same names in NFC")
+	normalizeForm    = Flags.String("normalize-form", NormalizeFormNFC, "Unicode normalization form of received names with -normalize-unicode: "+NormalizeFormNFC+" or "+NormalizeFormNFD)
+	caseInsensitive  = Flags.String("case-insensitive", CaseModeAuto, "Treat file names differing only by case as conflicts: auto (probe the destination directory), true, or false")
+	syncDeep         = Flags.Bool("sync-deep", false, "Hash files of any size to answer sync queries (by default, only files up to 64MB or with a known checksum)")
+	checksumCache    = Flags.Int("checksum-cache-size", ChecksumCacheSize, "Maximum number of checksums of stored files remembered to answer sync queries, the least recently used ones being forgotten first (0 to remember none)")
+	progress         = Flags.String("progress", protocol.ProgressModeAuto, "Progress output mode for received files: auto, bar, plain, or none")
+	onComplete       = Flags.String("on-complete", "", "Shell command run after each received file is verified, with {path}, {name}, {checksum}, and {size} replaced")
+	quarantineDir    = Flags.String("quarantine-dir", "", "Directory that files are received into and verified in before they are moved to the destination directory (off if empty)")
+	quarantineMaxAge = Flags.Duration("quarantine-max-age", QuarantineMaxAge, "Age at which the startup sweep reports (or, with -quarantine-clean, removes) quarantine entries")
+	quarantineClean  = Flags.Bool("quarantine-clean", false, "Remove the quarantine entries older than -quarantine-max-age at startup instead of only reporting them")
+	rangeTimeout     = Flags.Duration("range-timeout", RangeTimeout, "Time after which a file sent as ranges over several connections (-parallel-streams) is removed if none of its ranges arrives, with its partial file")
+	webhookURL       = Flags.String("webhook-url", "", "URL posted a JSON notification after each received file is verified (off if empty)")
+	webhookSecret    = Flags.String("webhook-secret", "", "Key of the HMAC-SHA256 signature of webhook notifications, sent in the "+WebhookSignatureHeader+" header")
+	webhookKeyFile   = Flags.String("webhook-secret-file", "", "Path of a file holding the -webhook-secret key, which keeps it out of the process list")
+	webhookTimeout   = Flags.Duration("webhook-timeout", WebhookTimeout, "Time limit of a single webhook delivery attempt")
+	webhookRetries   = Flags.Int("webhook-retries", WebhookRetries, "Number of retries of a failed webhook delivery, with an exponential backoff")
+	minFreePercent   = Flags.Float64("min-free-percent", 0, "Refuse new transfers while the destination volume has less than this percentage of its space free, e.g. 5 (0 to disable)")
+	preallocateFiles = Flags.Bool("preallocate", preallocateSupported, "Reserve the disk space of each received file before its content arrives (Linux and macOS), refusing a file that does not fit at once")
+	fsyncFiles       = Flags.Bool("fsync", false, "Sync each received file and its directory to stable storage before acknowledging it, so that an acknowledged file survives a crash or power loss")
+	fsyncQuarantine  = Flags.Bool("fsync-quarantine", false, "Sync the content of quarantined files and the renames within -quarantine-dir to stable storage, independently of -fsync")
+	discard          = Flags.Bool("discard", false, "Verify the content of each transfer against its checksum, then discard it instead of storing it, to measure the throughput of the link (e.g. with the client's -benchmark)")
+	noJournal        = Flags.Bool("no-journal", false, "Keep no journal of the received files in "+JournalDirName+"/ of the destination directory, which cleans up interrupted transfers and remembers checksums across restarts")
+	cleanupMode      = Flags.String("cleanup-mode", CleanupModeReport, "What the sweep of the destination directory does with the partial files (.filexfer-*) of interrupted transfers older than -cleanup-max-age: off, report, delete, or trash (moved to "+JournalDirName+"/"+TrashDirName+")")
+	cleanupMaxAge    = Flags.Duration("cleanup-max-age", CleanupMaxAge, "Age at which a partial file left in the destination directory is swept by -cleanup-mode (at least -range-timeout)")
+	cleanupInterval  = Flags.Duration("cleanup-interval", 0, "How often the sweep of -cleanup-mode runs after the one at startup (0 for startup only)")
+	maxMemory        = Flags.Int64("max-memory", 0, "Maximum bytes of buffers allocated by all connections together (headers, copy buffers, checksums, manifests), beyond which connections wait and are then refused as busy (0 for unlimited)")
+	serverRateLimit  = Flags.Uint64("server-rate-limit", 0, "Maximum aggregate rate in bytes per second at which file content is received across all connections (0 for unlimited)")
+	accessLogPath    = Flags.String("access-log", "", "Path of a log file appended with a JSON line per finished transfer (rotated at -access-log-max-size or on SIGUSR2)")
+	accessLogMaxSize = Flags.Int64("access-log-max-size", AccessLogMaxSize, "Size in bytes at which the access log is rotated")
+	auditLogPath     = Flags.String("audit-log", "", "Path of an append-only audit log with a JSON line per finished transfer, synced to stable storage as it is written and never rotated")
+	debugAddr        = Flags.String("debug-addr", "", "Address (host:port) of an HTTP endpoint serving pprof profiles and expvar counters, e.g. 127.0.0.1:6060 (off if empty)")
+	debugAllowRemote = Flags.Bool("debug-allow-remote", false, "Allow -debug-addr to listen on a non-loopback address")
+	metricsAddr      = Flags.String("metrics-addr", "", "Address (host:port) of an HTTP endpoint serving transfer metrics in the Prometheus text format at /metrics (off if empty)")
+	logFormat        = Flags.String("log-format", protocol.LogFormatText, "Log output format: text or json")
+	logLevel         = Flags.String("log-level", "info", "Minimum level of logged messages: debug, info, warn, or error")
+)
+
+// A flagRule is an invariant over one or more command-line flags that is checked at startup,
+// before any socket is bound or file is touched.
+type flagRule struct {
+	flags []string     // Flags involved in the rule.
+	check func() error // Returns a description of the violation, or nil if the rule holds.
+	fix   string       // Suggested fix printed along with a violation.
+}
+
+// flagRules holds the invariants over the server's flags.
+// New flags register their constraints here, next to their definitions above.
+var flagRules = []flagRule{
+	{
+		flags: []string{"port"},
+		check: func() error {
+			if port, err := strconv.Atoi(*listenPort); err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("invalid port %q", *listenPort)
+			}
+			return nil
+		},
+		fix: "use a port number between 0 and 65535",
+	},
+	{
+		flags: []string{"bind"},
+		check: func() error {
+			if _, _, err := net.SplitHostPort(*bindAddr); err == nil {
+				return fmt.Errorf("bind address %q includes a port", *bindAddr)
+			}
+			host := unbracket(*bindAddr)
+			if strings.ContainsAny(host, "[]") || (strings.Contains(host, ":") && !isIPAddress(host)) {
+				return fmt.Errorf("invalid bind address %q", *bindAddr)
+			}
+			return nil
+		},
+		fix: "give only the host or IP address (e.g. 0.0.0.0, ::1, or [::1]) and the port with -port",
+	},
+	{
+		flags: []string{"strategy"},
+		check: func() error {
+			return validateStrategy(*fileStrategy)
+		},
+		fix: "use one of: " + strategyNames() + " (described by -list-strategies)",
+	},
+	{
+		flags: []string{"checksum-cache-size"},
+		check: func() error {
+			if *checksumCache < 0 {
+				return fmt.Errorf("negative checksum cache size %d", *checksumCache)
+			}
+			return nil
+		},
+		fix: "use a number of checksums, e.g. 100000, or 0 to remember none",
+	},
+	{
+		flags: []string{"min-free-percent"},
+		check: func() error {
+			if *minFreePercent < 0 || *minFreePercent >= 100 {
+				return fmt.Errorf("invalid minimum free percentage %v: must be between 0 and 100", *minFreePercent)
+			}
+			return nil
+		},
+		fix: "use a percentage such as 5, or 0 to disable the guard",
+	},
+	{
+		flags: []string{"max-memory", "buffer-size"},
+		check: func() error {
+			if *maxMemory < 0 {
+				return fmt.Errorf("negative memory budget %d", *maxMemory)
+			}
+			if *maxMemory > 0 && *maxMemory < connectionMemory() {
+				return fmt.Errorf("memory budget %d is smaller than the %d bytes of a single connection", *maxMemory, connectionMemory())
+			}
+			return nil
+		},
+		fix: "use a budget of at least -buffer-size plus 1.1MB, e.g. 536870912 (512MB), or 0 for unlimited",
+	},
+	{
+		flags: []string{"trailing-data-wait"},
+		check: func() error {
+			if *trailingWait < 0 || *trailingWait >= ReadTimeout {
+				return fmt.Errorf("invalid trailing data wait %v: must be between 0 and %v", *trailingWait, ReadTimeout)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use a short wait such as %v, or 0 to disable the check", TrailingDataWait),
+	},
+	{
+		flags: []string{"case-insensitive"},
+		check: func() error {
+			switch *caseInsensitive {
+			case CaseModeAuto, CaseModeTrue, CaseModeFalse:
+				return nil
+			default:
+				return fmt.Errorf("invalid case mode %q", *caseInsensitive)
+			}
+		},
+		fix: fmt.Sprintf("use one of: %s, %s, %s", CaseModeAuto, CaseModeTrue, CaseModeFalse),
+	},
+	{
+		flags: []string{"normalize-form"},
+		check: func() error {
+			switch *normalizeForm {
+			case NormalizeFormNFC, NormalizeFormNFD:
+				return nil
+			default:
+				return fmt.Errorf("invalid normalization form %q", *normalizeForm)
+			}
+		},
+		fix: fmt.Sprintf("use one of: %s, %s", NormalizeFormNFC, NormalizeFormNFD),
+	},
+	{
+		flags: []string{"max-name-length"},
+		check: func() error {
+			if *maxNameLength <= 0 || *maxNameLength > protocol.MaxFileNameLength {
+				return fmt.Errorf("invalid maximum name length %d: must be between 1 and %d bytes", *maxNameLength, protocol.MaxFileNameLength)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use the name length limit of the destination file system, e.g. %d", protocol.MaxPathComponentLength),
+	},
+	{
+		flags: []string{"progress"},
+		check: func() error {
+			if !protocol.ValidProgressMode(*progress) {
+				return fmt.Errorf("invalid progress mode %q", *progress)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use one of: %s, %s, %s, %s",
+			protocol.ProgressModeAuto, protocol.ProgressModeBar, protocol.ProgressModePlain, protocol.ProgressModeNone),
+	},
+	{
+		flags: []string{"on-complete"},
+		check: func() error {
+			if *onComplete == "" {
+				return nil
+			}
+			if strings.TrimSpace(*onComplete) == "" {
+				return fmt.Errorf("empty command")
+			}
+			if _, err := exec.LookPath("sh"); err != nil {
+				return fmt.Errorf("no shell to run the command: %v", err)
+			}
+			return nil
+		},
+		fix: "give a shell command such as 'gzip -k {path}', or drop -on-complete",
+	},
+	{
+		flags: []string{"quarantine-dir", "dir"},
+		check: func() error {
+			if *quarantineDir == "" {
+				return nil
+			}
+			quarantine, err := filepath.Abs(*quarantineDir)
+			if err != nil {
+				return fmt.Errorf("invalid quarantine directory %q: %v", *quarantineDir, err)
+			}
+			destination, err := filepath.Abs(*destDir)
+			if err != nil {
+				return fmt.Errorf("invalid destination directory %q: %v", *destDir, err)
+			}
+			if relative, err := filepath.Rel(destination, quarantine); err == nil && !strings.HasPrefix(relative, "..") {
+				return fmt.Errorf("quarantine directory %q is within the destination directory %q", *quarantineDir, *destDir)
+			}
+			return nil
+		},
+		fix: "use a quarantine directory outside of the destination directory, so that clients cannot reach unreleased files",
+	},
+	{
+		flags: []string{"quarantine-max-age", "quarantine-clean"},
+		check: func() error {
+			if *quarantineMaxAge <= 0 {
+				return fmt.Errorf("non-positive quarantine age %v", *quarantineMaxAge)
+			}
+			if *quarantineClean && *quarantineDir == "" {
+				return fmt.Errorf("-quarantine-clean without -quarantine-dir")
+			}
+			return nil
+		},
+		fix: "use a positive age such as 168h, and -quarantine-clean only with -quarantine-dir",
+	},
+	{
+		flags: []string{"fsync-quarantine", "quarantine-dir"},
+		check: func() error {
+			if *fsyncQuarantine && *quarantineDir == "" {
+				return fmt.Errorf("-fsync-quarantine without -quarantine-dir")
+			}
+			return nil
+		},
+		fix: "drop -fsync-quarantine, or use it with -quarantine-dir (use -fsync for the files released to the destination directory)",
+	},
+	{
+		flags: []string{"range-timeout"},
+		check: func() error {
+			if *rangeTimeout <= 0 {
+				return fmt.Errorf("non-positive range transfer timeout %v", *rangeTimeout)
+			}
+			return nil
+		},
+		fix: "use a positive timeout such as 10m",
+	},
+	{
+		flags: []string{"cleanup-mode"},
+		check: func() error {
+			switch *cleanupMode {
+			case CleanupModeOff, CleanupModeReport, CleanupModeDelete, CleanupModeTrash:
+				return nil
+			default:
+				return fmt.Errorf("invalid cleanup mode %q", *cleanupMode)
+			}
+		},
+		fix: fmt.Sprintf("use one of: %s, %s, %s, %s", CleanupModeOff, CleanupModeReport, CleanupModeDelete, CleanupModeTrash),
+	},
+	{
+		flags: []string{"cleanup-max-age", "range-timeout", "cleanup-interval"},
+		check: func() error {
+			if *cleanupMaxAge < *rangeTimeout {
+				return fmt.Errorf("partial file age %v shorter than the range transfer timeout %v", *cleanupMaxAge, *rangeTimeout)
+			}
+			if *cleanupInterval < 0 {
+				return fmt.Errorf("negative cleanup interval %v", *cleanupInterval)
+			}
+			return nil
+		},
+		fix: "use an age such as 24h, at least -range-timeout, so that the partial file of a range transfer in progress is never swept, and an interval such as 1h, or 0",
+	},
+	{
+		flags: []string{"dest-template"},
+		check: func() error {
+			return validateDestTemplate(*destTemplate)
+		},
+		fix: fmt.Sprintf("use a relative path without \"..\" made of literal names and the placeholders %s, %s, %s, %s, %s, and %s",
+			TemplateDate, TemplateYear, TemplateMonth, TemplateDay, TemplateClientIP, TemplateTransferID),
+	},
+	{
+		flags: []string{"reject-pattern", "allow-pattern"},
+		check: func() error {
+			for _, pattern := range append(slices.Clone(*rejectPatterns), *allowPatterns...) {
+				if _, err := path.Match(pattern, ""); err != nil || strings.Trim(pattern, "/") == "" {
+					return fmt.Errorf("invalid file name pattern %q", pattern)
+				}
+			}
+			return nil
+		},
+		fix: "use glob patterns such as *.exe, *.tmp, or uploads/**/*.sh",
+	},
+	{
+		flags: []string{"tcp-keepalive"},
+		check: func() error {
+			if *tcpKeepAlive < 0 {
+				return fmt.Errorf("negative keep-alive period %v", *tcpKeepAlive)
+			}
+			return nil
+		},
+		fix: "use a period such as 30s, or 0 to disable keep-alive",
+	},
+	{
+		flags: []string{"webhook-url", "webhook-secret", "webhook-secret-file"},
+		check: func() error {
+			if *webhookSecret != "" && *webhookKeyFile != "" {
+				return fmt.Errorf("both a webhook secret and a webhook secret file")
+			}
+			if *webhookURL == "" {
+				if *webhookSecret != "" || *webhookKeyFile != "" {
+					return fmt.Errorf("a webhook secret without a webhook URL")
+				}
+				return nil
+			}
+			parsed, err := url.Parse(*webhookURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("invalid webhook URL %q", *webhookURL)
+			}
+			return nil
+		},
+		fix: "give an http or https URL such as https://example.com/hooks/filexfer",
+	},
+	{
+		flags: []string{"webhook-timeout", "webhook-retries"},
+		check: func() error {
+			if *webhookTimeout <= 0 {
+				return fmt.Errorf("non-positive webhook timeout %v", *webhookTimeout)
+			}
+			if *webhookRetries < 0 {
+				return fmt.Errorf("negative number of webhook retries %d", *webhookRetries)
+			}
+			return nil
+		},
+		fix: "use a positive timeout such as 10s and zero or more retries",
+	},
+	{
+		flags: []string{"max-dir-size"},
+		check: func() error {
+			if maxDirectorySize.Load() == 0 {
+				return fmt.Errorf("invalid directory size limit: must be greater than 0")
+			}
+			return nil
+		},
+		fix: "use a positive number of bytes, e.g. 53687091200 for 50GB",
+	},
+	{
+		flags: []string{"buffer-size"},
+		check: func() error {
+			if *bufferSize <= 0 || *bufferSize > MaxBufferSize {
+				return fmt.Errorf("invalid buffer size %d: must be between 1 and %d bytes", *bufferSize, MaxBufferSize)
+			}
+			return nil
+		},
+		fix: "use a positive size up to 64MB, e.g. 1048576 for 1MB",
+	},
+	{
+		flags: []string{"tls-cert", "tls-key"},
+		check: func() error {
+			if (*tlsCertFile == "") != (*tlsKeyFile == "") {
+				return fmt.Errorf("-tls-cert and -tls-key must be given together")
+			}
+			return nil
+		},
+		fix: "provide both the certificate and the private key, or neither for plain TCP",
+	},
+	{
+		flags: []string{"require-client-cert", "client-ca", "tls-cert"},
+		check: func() error {
+			switch {
+			case *requireClientTLS && *clientCAFile == "":
+				return fmt.Errorf("-require-client-cert requires -client-ca to verify the client certificates against")
+			case !*requireClientTLS && *clientCAFile != "":
+				return fmt.Errorf("-client-ca is only used with -require-client-cert")
+			case *requireClientTLS && *tlsCertFile == "":
+				return fmt.Errorf("-require-client-cert requires TLS (-tls-cert and -tls-key)")
+			}
+			return nil
+		},
+		fix: "use -require-client-cert -client-ca ca.crt along with -tls-cert and -tls-key",
+	},
+	{
+		flags: []string{"access-log-max-size"},
+		check: func() error {
+			if *accessLogMaxSize <= 0 {
+				return fmt.Errorf("invalid access log size limit %d: must be greater than 0", *accessLogMaxSize)
+			}
+			return nil
+		},
+		fix: "use a positive number of bytes, e.g. 104857600 for 100MB",
+	},
+	{
+		flags: []string{"audit-log", "access-log"},
+		check: func() error {
+			if *auditLogPath != "" && filepath.Clean(*auditLogPath) == filepath.Clean(*accessLogPath) {
+				return fmt.Errorf("the audit log and the access log are the same file %q", *auditLogPath)
+			}
+			return nil
+		},
+		fix: "use different files, since the access log is rotated and the audit log is not",
+	},
+	{
+		flags: []string{"debug-addr", "debug-allow-remote"},
+		check: func() error {
+			if *debugAddr == "" {
+				return nil
+			}
+			host, _, err := net.SplitHostPort(*debugAddr)
+			if err != nil {
+				return fmt.Errorf("invalid debug address %q: %v", *debugAddr, err)
+			}
+			if !isLoopbackHost(host) && !*debugAllowRemote {
+				return fmt.Errorf("debug address %q is not a loopback address, which would expose the profiles to the network", *debugAddr)
+			}
+			return nil
+		},
+		fix: "listen on a loopback address such as 127.0.0.1:6060, or add -debug-allow-remote",
+	},
+	{
+		flags: []string{"metrics-addr"},
+		check: func() error {
+			if *metricsAddr == "" {
+				return nil
+			}
+			if _, _, err := net.SplitHostPort(*metricsAddr); err != nil {
+				return fmt.Errorf("invalid metrics address %q: %v", *metricsAddr, err)
+			}
+			return nil
+		},
+		fix: "give a host and port such as :9090 or 127.0.0.1:9090",
+	},
+	{
+		flags: []string{"log-format"},
+		check: func() error {
+			if !protocol.ValidLogFormat(*logFormat) {
+				return fmt.Errorf("invalid log format %q", *logFormat)
+			}
+			return nil
+		},
+		fix: fmt.Sprintf("use one of: %s, %s", protocol.LogFormatText, protocol.LogFormatJSON),
+	},
+	{
+		flags: []string{"log-level"},
+		check: func() error {
+			_, err := protocol.ParseLogLevel(*logLevel)
+			return err
+		},
+		fix: "use one of: debug, info, warn, error",
+	},
+}
+
+// listenAddress returns the address to listen on, built from the `-bind` and `-port` flags.
+// An empty bind address listens on all interfaces, and an IPv6 address is bracketed (e.g. "[::1]:8080").
+func listenAddress() string {
+	return net.JoinHostPort(unbracket(*bindAddr), *listenPort)
+}
+
+// unbracket removes the square brackets around an IPv6 address (e.g. "[::1]" becomes "::1").
+func unbracket(host string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host[1 : len(host)-1]
+	}
+	return host
+}
+
+// isIPAddress reports whether the host is an IPv4 or IPv6 address, with an optional IPv6 zone (e.g. "fe80::1%eth0").
+func isIPAddress(host string) bool {
+	_, err := netip.ParseAddr(host)
+	return err == nil
+}
+
+// validateFlags validates the command-line flags against every flag rule and reports all violations at once.
+func validateFlags() error {
+	var violations []string
+	for _, rule := range flagRules {
+		if err := rule.check(); err != nil {
+			violations = append(violations, fmt.Sprintf("-%s: %v (fix: %s)", strings.Join(rule.flags, ", -"), err, rule.fix))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("%d invalid flag(s):\n  - %s", len(violations), strings.Join(violations, "\n  - "))
+	}
+
+	return nil
+}
+
+// Global variables for tracking directory sizes per client.
+var (
+	directorySizes = make(map[string]uint64) // `clientAddr` -> total directory size.
+	dirSizeMutex   sync.RWMutex              // Mutex for synchronizing access to `directorySizes` map.
+)
+
+// A dedupEntry is a stored file indexed by its checksum for the "-dedup" mode.
+// The size and modification time detect a file changed since it was indexed, whose content can no longer be trusted.
+type dedupEntry struct {
+	path    string    // Path of the stored file.
+	size    int64     // Size of the file when it was indexed.
+	modTime time.Time // Modification time of the file when it was indexed.
+}
+
+// Global variables for tracking stored content for the "-dedup" mode.
+var (
+	dedupIndex = make(map[string]dedupEntry) // Hex-encoded checksum -> stored file with that content.
+	dedupMutex sync.Mutex                    // Mutex for synchronizing access to `dedupIndex` map.
+)
+
+// lookupDedup returns the path of a stored file with the given checksum, if it still exists unchanged.
+func lookupDedup(checksum []byte) (string, bool) {
+	key := hex.EncodeToString(checksum)
+
+	dedupMutex.Lock()
+	defer dedupMutex.Unlock()
+
+	entry, ok := dedupIndex[key]
+	if !ok {
+		return "", false
+	}
+	info, err := os.Stat(entry.path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != entry.size || !info.ModTime().Equal(entry.modTime) {
+		delete(dedupIndex, key)
+		return "", false
+	}
+	return entry.path, true
+}
+
+// recordDedup indexes a stored file under its checksum, unless an unchanged file with that content is already indexed.
+func recordDedup(checksum []byte, path string) {
+	if _, ok := lookupDedup(checksum); ok {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("Failed to index the file for deduplication", "file_name", path, "error", err)
+		return
+	}
+
+	dedupMutex.Lock()
+	defer dedupMutex.Unlock()
+	dedupIndex[hex.EncodeToString(checksum)] = dedupEntry{
+		path:    path,
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+}
+
+// linkDuplicate replaces the file at `path` with a hard link to `existing`, which has the same content.
+// If hard linking fails (e.g. across file systems), the content of `existing` is copied instead.
+func linkDuplicate(existing, path string, buffer []byte) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s before linking: %v", path, err)
+	}
+
+	linkErr := os.Link(existing, path)
+	if linkErr == nil {
+		return nil
+	}
+	slog.Warn("Failed to hard-link the duplicate, falling back to a copy", "file_name", path, "existing", existing, "error", linkErr)
+
+	source, err := os.Open(existing)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for copying: %v", existing, err)
+	}
+	defer func() {
+		_ = source.Close()
+	}()
+
+	destination, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s for copying: %v", path, err)
+	}
+	if _, err := io.CopyBuffer(destination, source, buffer); err != nil {
+		_ = destination.Close()
+		return fmt.Errorf("failed to copy %s to %s: %v", existing, path, err)
+	}
+	return destination.Close()
+}
+
+// A storedChecksum is the checksum of a stored file, remembered to answer sync queries without hashing the file again.
+// The size and modification time detect a file changed since its checksum was calculated.
+type storedChecksum struct {
+	path     string    // Path of the file.
+	checksum []byte    // SHA-256 checksum of the file.
+	size     int64     // Size of the file when its checksum was calculated.
+	modTime  time.Time // Modification time of the file when its checksum was calculated.
+}
+
+// Global variables for remembering the checksums of stored files for sync queries.
+// At most "-checksum-cache-size" checksums are remembered, so that a long-running server receiving many files
+// (or replaying a large journal) does not grow without bound; the least recently used one is forgotten first.
+var (
+	storedChecksums     = make(map[string]*list.Element) // Path of a stored file -> its element in `storedChecksumOrder`.
+	storedChecksumOrder = list.New()                     // `storedChecksum` values, the most recently used first.
+	storedChecksumMutex sync.Mutex                       // Mutex for synchronizing access to `storedChecksums` and `storedChecksumOrder`.
+)
+
+// lookupStoredChecksum returns the remembered checksum of the file at `path`, if the file is unchanged since.
+func lookupStoredChecksum(path string, info os.FileInfo) ([]byte, bool) {
+	storedChecksumMutex.Lock()
+	defer storedChecksumMutex.Unlock()
+
+	element, ok := storedChecksums[path]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(storedChecksum)
+	if info.Size() != entry.size || !info.ModTime().Equal(entry.modTime) {
+		storedChecksumOrder.Remove(element)
+		delete(storedChecksums, path)
+		return nil, false
+	}
+	storedChecksumOrder.MoveToFront(element)
+	return entry.checksum, true
+}
+
+// recordStoredChecksum remembers the checksum of the file at `path`.
+func recordStoredChecksum(path string, checksum []byte) {
+	info, err := os.Stat(path)
+	if err != nil {
+		slog.Warn("Failed to remember the checksum of the file", "file_name", path, "error", err)
+		return
+	}
+	rememberStoredChecksum(storedChecksum{path: path, checksum: checksum, size: info.Size(), modTime: info.ModTime()})
+}
+
+// rememberStoredChecksum remembers the checksum as the most recently used one,
+// forgetting the least recently used ones beyond "-checksum-cache-size".
+func rememberStoredChecksum(entry storedChecksum) {
+	storedChecksumMutex.Lock()
+	defer storedChecksumMutex.Unlock()
+
+	if element, ok := storedChecksums[entry.path]; ok {
+		element.Value = entry
+		storedChecksumOrder.MoveToFront(element)
+	} else {
+		storedChecksums[entry.path] = storedChecksumOrder.PushFront(entry)
+	}
+	for storedChecksumOrder.Len() > max(*checksumCache, 0) {
+		oldest := storedChecksumOrder.Back()
+		storedChecksumOrder.Remove(oldest)
+		delete(storedChecksums, oldest.Value.(storedChecksum).path)
+	}
+}
+
+// A completedFile is a received and verified file passed to the "-on-complete" command.
+type completedFile struct {
+	path     string // Path of the stored file.
+	name     string // Name of the file as sent by the client.
+	checksum []byte // SHA-256 checksum of the file.
+	size     uint64 // Size of the file in bytes.
+}
+
+// A hookRunner runs the "-on-complete" command for received files on a bounded pool of workers,
+// so that slow commands hold up neither the accept loop nor the transfers.
+type hookRunner struct {
+	command string             // Command template with placeholders.
+	queue   chan completedFile // Files waiting for a worker.
+	wg      sync.WaitGroup     // Wait group of the workers.
+}
+
+// completeHook runs the "-on-complete" command, or is nil if no command is configured.
+var completeHook *hookRunner
+
+// newHookRunner starts `workers` workers running the command template for the files queued on the runner.
+func newHookRunner(command string, workers, queueSize int) *hookRunner {
+	hr := &hookRunner{
+		command: command,
+		queue:   make(chan completedFile, queueSize),
+	}
+	for range workers {
+		hr.wg.Add(1)
+		go func() {
+			defer hr.wg.Done()
+			for file := range hr.queue {
+				hr.run(file)
+			}
+		}()
+	}
+	return hr
+}
+
+// enqueue queues the file for the command. If the queue is full, it waits for a worker to free a slot.
+func (hr *hookRunner) enqueue(file completedFile) {
+	select {
+	case hr.queue <- file:
+	default:
+		slog.Warn("The -on-complete queue is full, waiting for a free worker", "file_name", file.path)
+		hr.queue <- file
+	}
+}
+
+// close stops accepting files and waits for the queued commands to finish.
+func (hr *hookRunner) close() {
+	close(hr.queue)
+	hr.wg.Wait()
+}
+
+// run runs the command for the file. A failure is logged and does not affect the transfer, which already succeeded.
+func (hr *hookRunner) run(file completedFile) {
+	_ = hr.execute(file)
+}
+
+// execute runs the command for the file and logs the outcome. It returns an error if the command fails or times out.
+func (hr *hookRunner) execute(file completedFile) error {
+	ctx, cancel := context.WithTimeout(context.Background(), HookTimeout)
+	defer cancel()
+
+	command := expandHookCommand(hr.command, file)
+	output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+	if err != nil {
+		if len(output) > HookOutputLimit {
+			output = append(output[:HookOutputLimit], "..."...)
+		}
+		slog.Error("The -on-complete command failed", "file_name", file.path, "error", err, "output", string(bytes.TrimSpace(output)))
+		return err
+	}
+	slog.Info("The -on-complete command succeeded", "file_name", file.path)
+	return nil
+}
+
+// expandHookCommand replaces the placeholders of the command template with the file's details.
+// Every value is quoted for the shell, so file names chosen by clients cannot inject commands.
+func expandHookCommand(template string, file completedFile) string {
+	return strings.NewReplacer(
+		"{path}", shellQuote(file.path),
+		"{name}", shellQuote(file.name),
+		"{checksum}", hex.EncodeToString(file.checksum),
+		"{size}", strconv.FormatUint(file.size, 10),
+	).Replace(template)
+}
+
+// notifyCompleted passes the files received and verified by the transfer of the record
+// to the "-on-complete" command and the "-webhook-url" endpoint, if configured.
+// In quarantine mode ("-quarantine-dir"), the command has already run on the files before they were released.
+func notifyCompleted(record *accessRecord, files ...completedFile) {
+	for _, file := range files {
+		if completeHook != nil && *quarantineDir == "" {
+			completeHook.enqueue(file)
+		}
+		if webhook != nil {
+			webhook.enqueue(newWebhookEvent(record, file))
+		}
+	}
+}
+
+// shellQuote quotes the string as a single word for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// readLimiter caps the aggregate rate at which file content is received across all connections ("-server-rate-limit"),
+// or is nil if the rate is unlimited.
+var readLimiter *protocol.RateLimiter
+
+// contextReader supports reading from a connection with context cancellation support.
+type contextReader struct {
+	ctx      context.Context
+	conn     net.Conn
+	inFlight int64           // Number of bytes read since the last `release`, counted in `bytesInFlight`.
+	transfer *activeTransfer // Transfer the bytes are read for, tracked in `activeTransfers` until the `release`, or nil.
+}
+
+// track registers the transfer whose content is read next in `activeTransfers`.
+func (cr *contextReader) track(id, clientAddr, fileName string, size uint64) {
+	cr.transfer = activeTransfers.start(id, clientAddr, fileName, size)
+}
+
+// release removes the bytes read by the finished transfer from `bytesInFlight`, and the transfer from `activeTransfers`.
+func (cr *contextReader) release() {
+	bytesInFlight.Add(-cr.inFlight)
+	cr.inFlight = 0
+	if cr.transfer != nil {
+		activeTransfers.finish(cr.transfer)
+		cr.transfer = nil
+	}
+}
+
+// Read reads data from the connection with context cancellation support.
+// A deadline is set for each read operation to prevent hanging connections.
+// With "-server-rate-limit", the bytes read are drawn from the shared `readLimiter` before they are returned,
+// a chunk at a time, so that concurrent connections share the rate fairly.
+func (cr *contextReader) Read(p []byte) (n int, err error) {
+	select {
+	// Return if the context is done (canceled or timed out).
+	case <-cr.ctx.Done():
+		return 0, cr.ctx.Err()
+	default:
+		// Do nothing.
+	}
+
+	if readLimiter != nil && len(p) > readLimiter.ChunkSize() {
+		p = p[:readLimiter.ChunkSize()]
+	}
+
+	if err := cr.conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+		return 0, err
+	}
+
+	n, err = cr.conn.Read(p)
+	cr.inFlight += int64(n)
+	bytesInFlight.Add(int64(n))
+	bytesReceived.Add(int64(n))
+	if cr.transfer != nil {
+		cr.transfer.bytes.Add(int64(n))
+	}
+	if readLimiter != nil && n > 0 {
+		if waitErr := readLimiter.Wait(cr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// toGB converts bytes to gigabytes.
+func toGB(bytes uint64) float64 {
+	return float64(bytes) / 1024 / 1024 / 1024
+}
+
+// setupLogging configures structured logging in the format and at the level of the "-log-format" and "-log-level" flags.
+func setupLogging() {
+	level, err := protocol.ParseLogLevel(*logLevel)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	protocol.SetupLogging(os.Stderr, *logFormat, level, LogPrefix)
+}
+
+// fatal logs the message and its attributes as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// sanitizePath performs deep sanitization of file paths to prevent path traversal attacks.
+// It normalizes the path using `filepath.Clean` and verifies the result is a sub-path of the base directory.
+// It also rejects file and directory names longer than "-max-name-length" bytes,
+// which the file system would otherwise reject with an opaque error only once the file is created,
+// and names unusable or dangerous on other platforms (see `protocol.ErrUnsafeFileName`),
+// which are instead percent-encoded with "-sanitize-names" (the length limit then applies to the encoded names).
+func sanitizePath(baseDir, userPath string) (string, error) {
+	if userPath == "" {
+		return "", fmt.Errorf("path cannot be empty")
+	}
+	if filepath.IsAbs(userPath) {
+		return "", fmt.Errorf("%w: %s", ErrAbsolutePath, userPath)
+	}
+	if strings.Contains(userPath, "..") {
+		return "", fmt.Errorf("%w: %s", ErrPathTraversal, userPath)
+	}
+	if *sanitizeNames {
+		userPath = protocol.SanitizePathNames(userPath)
+	} else if err := protocol.ValidatePathNames(userPath); err != nil {
+		return "", err
+	}
+	if err := protocol.ValidatePathComponents(userPath, *maxNameLength); err != nil {
+		return "", err
+	}
+
+	baseDir = filepath.Clean(baseDir)
+	fullPath := filepath.Clean(filepath.Join(baseDir, userPath))
+	// The journal directory and the partial files belong to the server (see `receiveJournal` and `sweepPartials`).
+	if reservedPath(fullPath) || partialPath(userPath) {
+		return "", fmt.Errorf("%w: %s", ErrReservedPath, userPath)
+	}
+	return fullPath, nil
+}
+
+// flattenHeader reduces the relative path of a file in a directory transfer (or verification or query) to its base name
+// for the "-flatten" mode, leaving name collisions to the file conflict-resolution strategy.
+// The base name is still sanitized like any other file name before it is used.
+func flattenHeader(header *protocol.Header) {
+	isDirectoryFile := header.MessageType == protocol.MessageTypeTransfer && header.TransferType == protocol.TransferTypeDirectory
+	if isDirectoryFile || header.MessageType == protocol.MessageTypeVerify || header.MessageType == protocol.MessageTypeQuery {
+		header.FileName = filepath.Base(header.FileName)
+	}
+}
+
+// normalizeName normalizes the name to the Unicode form of "-normalize-form".
+func normalizeName(name string) string {
+	if *normalizeForm == NormalizeFormNFD {
+		return norm.NFD.String(name)
+	}
+	return norm.NFC.String(name)
+}
+
+// normalizeHeaderNames normalizes the file name and directory path of the header to the Unicode form of "-normalize-form"
+// for "-normalize-unicode", so that a name sent decomposed (NFD, e.g. by macOS) and the same name sent composed (NFC)
+// are stored as one file and trigger the conflict-resolution strategy. A name changed by the normalization is logged.
+func normalizeHeaderNames(logger *slog.Logger, header *protocol.Header) {
+	if name := normalizeName(header.FileName); name != header.FileName {
+		logger.Info("Normalized the file name", "file_name", header.FileName, "normalized", name, "form", *normalizeForm)
+		header.FileName = name
+	}
+	if dirPath := normalizeName(header.DirectoryPath); dirPath != header.DirectoryPath {
+		logger.Info("Normalized the directory path", "directory_path", header.DirectoryPath, "normalized", dirPath, "form", *normalizeForm)
+		header.DirectoryPath = dirPath
+	}
+}
+
+// destinationRoot returns the directory under which the files of the header are stored:
+// the subtree of the destination directory given by the header's `DirectoryPath`, or the destination directory itself.
+func destinationRoot(header *protocol.Header) (string, error) {
+	if header.DirectoryPath == "" {
+		return filepath.Clean(*destDir), nil
+	}
+	root, err := sanitizePath(*destDir, header.DirectoryPath)
+	if err != nil {
+		return "", fmt.Errorf("invalid directory path: %w", err)
+	}
+	return root, nil
+}
+
+// destinationPath returns the path of the file of the header: its name under the destination root (see `destinationRoot`),
+// with both the directory path and the name sanitized against path traversal.
+func destinationPath(header *protocol.Header) (string, error) {
+	root, err := destinationRoot(header)
+	if err != nil {
+		return "", err
+	}
+	path, err := sanitizePath(root, header.FileName)
+	if err != nil {
+		return "", fmt.Errorf("invalid file name: %w", err)
+	}
+	return path, nil
+}
+
+// validateHeader performs a series of checks on the file transfer header to ensure it meets security and protocol requirements.
+func validateHeader(header *protocol.Header, clientAddr string) error {
+	if header == nil {
+		return fmt.Errorf("header is nil")
+	}
+
+	// Information and ping requests carry nothing to check.
+	if header.MessageType == protocol.MessageTypeInfo || header.MessageType == protocol.MessageTypePing {
+		return nil
+	}
+
+	// Verification, query, and deletion requests carry no content, so only the file path needs to be checked.
+	if header.MessageType == protocol.MessageTypeVerify || header.MessageType == protocol.MessageTypeQuery ||
+		header.MessageType == protocol.MessageTypeDelete {
+		_, err := destinationPath(header)
+		return err
+	}
+
+	// The manifest of a sync only lists files, whose paths are checked as they are compared.
+	if header.MessageType == protocol.MessageTypeManifest {
+		_, err := destinationRoot(header)
+		return err
+	}
+
+	if header.TransferType == protocol.TransferTypeDirectory {
+		// Read the limit once, since a reload may change it.
+		maxDirSize := maxDirectorySize.Load()
+		if header.MessageType == protocol.MessageTypeValidate {
+			if header.FileSize > maxDirSize {
+				return fmt.Errorf("%w: directory size %d bytes exceeds the maximum allowed size %d bytes",
+					ErrDirectoryTooLarge, header.FileSize, maxDirSize)
+			}
+			return nil
+		}
+
+		dirSizeMutex.RLock()
+		currentDirSize := directorySizes[clientAddr]
+		newTotalSize := currentDirSize + header.FileSize
+		dirSizeMutex.RUnlock()
+
+		if newTotalSize > maxDirSize {
+			return fmt.Errorf("%w: directory transfer size %d bytes would exceed the maximum allowed size %d bytes (current: %d bytes, adding: %d bytes, expected total: %d bytes, exceeds by: %d bytes)",
+				ErrDirectoryTooLarge, newTotalSize, maxDirSize, currentDirSize, header.FileSize, newTotalSize, newTotalSize-maxDirSize)
+		}
+	} else {
+		maxSize := uint64(MaxFileSize)
+		if header.FileSize > maxSize {
+			return fmt.Errorf("%w: file size %d bytes exceeds the maximum allowed size %d bytes",
+				ErrFileTooLarge, header.FileSize, maxSize)
+		}
+	}
+
+	if header.MessageType == protocol.MessageTypeTransfer && header.FileName == "" {
+		return fmt.Errorf("%w: file name cannot be empty", ErrEmptyFilename)
+	}
+
+	if header.MessageType == protocol.MessageTypeTransfer {
+		if _, err := destinationPath(header); err != nil {
+			return err
+		}
+		if err := checkFileNamePatterns(path.Join(header.DirectoryPath, header.FileName)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A fileNamePatternError is returned for a file name refused by the "-reject-pattern" and "-allow-pattern" flags,
+// naming the rule that refused it.
+type fileNamePatternError struct {
+	name string // Slash-separated relative file name.
+	rule string // Rule that refused the name, e.g. "-reject-pattern *.exe".
+}
+
+// Error describes the refused name and the rule.
+func (e *fileNamePatternError) Error() string {
+	return fmt.Sprintf("%v: %q is refused by %s", ErrRejectedFileName, e.name, e.rule)
+}
+
+// Unwrap returns `ErrRejectedFileName`.
+func (e *fileNamePatternError) Unwrap() error {
+	return ErrRejectedFileName
+}
+
+// checkFileNamePatterns checks a received file name, relative to the destination directory, against the
+// "-reject-pattern" and "-allow-pattern" flags: a name matching a reject pattern is refused, and, if allow patterns
+// are given, so is a name matching none of them. Patterns are matched as in `protocol.MatchPattern`.
+func checkFileNamePatterns(name string) error {
+	if len(*rejectPatterns) == 0 && len(*allowPatterns) == 0 {
+		return nil
+	}
+
+	name = strings.TrimPrefix(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+	match := func(pattern string) bool {
+		if *patternNocase {
+			return protocol.MatchPattern(strings.ToLower(pattern), strings.ToLower(name))
+		}
+		return protocol.MatchPattern(pattern, name)
+	}
+
+	for _, pattern := range *rejectPatterns {
+		if match(pattern) {
+			return &fileNamePatternError{name: name, rule: "-reject-pattern " + pattern}
+		}
+	}
+	if len(*allowPatterns) == 0 || slices.ContainsFunc(*allowPatterns, match) {
+		return nil
+	}
+	return &fileNamePatternError{name: name, rule: "-allow-pattern (no pattern matches)"}
+}
+
+// transferReceipt returns the receipt of a transfer of `size` bytes with the checksum, stored at `finalPath`,
+// whose path tells the client where the file went (e.g. renamed by "-sanitize-names" or the rename strategy,
+// or placed under a subdirectory chosen by the server with "-dest-template"), or nil if it is not under "-dir".
+func transferReceipt(finalPath string, size uint64, checksum []byte) *protocol.Receipt {
+	relPath, err := filepath.Rel(filepath.Clean(*destDir), finalPath)
+	if err != nil {
+		return nil
+	}
+	return &protocol.Receipt{Path: filepath.ToSlash(relPath), BytesWritten: size, Checksum: checksum}
+}
+
+// sendTransferResponse sends the response to a transfer to the client, followed by the receipt of the stored file if any.
+func sendTransferResponse(conn net.Conn, status uint8, code uint16, message string, receipt *protocol.Receipt) {
+	if receipt == nil {
+		sendCodedResponse(conn, status, code, message)
+		return
+	}
+	if err := protocol.WriteReceiptResponse(conn, message, receipt); err != nil {
+		slog.Warn("Failed to send a success response to the client", "error", err)
+	}
+}
+
+// sendErrorResponse sends a structured error response to the client.
+func sendErrorResponse(conn net.Conn, message string) {
+	sendCodedResponse(conn, protocol.ResponseStatusError, protocol.ErrorCodeNone, message)
+}
+
+// sendCodedResponse sends a structured response with the status and the error code to the client.
+func sendCodedResponse(conn net.Conn, status uint8, code uint16, message string) {
+	if err := protocol.WriteCodedResponse(conn, status, code, message); err != nil {
+		slog.Warn("Failed to send an error response to the client", "error", err)
+	}
+}
+
+// headerErrorCode returns the error code of the response to a header refused by `validateHeader` with the error.
+func headerErrorCode(err error) uint16 {
+	switch {
+	case errors.Is(err, ErrFileTooLarge):
+		return protocol.ErrorCodeFileTooLarge
+	case errors.Is(err, ErrDirectoryTooLarge):
+		return protocol.ErrorCodeQuotaExceeded
+	case errors.Is(err, ErrAbsolutePath), errors.Is(err, ErrPathTraversal):
+		return protocol.ErrorCodeTraversalRejected
+	default:
+		return protocol.ErrorCodeNone
+	}
+}
+
+// sendSuccessResponse sends a structured success response to the client.
+func sendSuccessResponse(conn net.Conn, message string) {
+	if err := protocol.WriteResponse(conn, protocol.ResponseStatusSuccess, message); err != nil {
+		slog.Warn("Failed to send a success response to the client", "error", err)
+	}
+}
+
+// getDirectoryStats gets the stats of active directory transfers.
+func getDirectoryStats() (int, uint64) {
+	dirSizeMutex.RLock()
+	defer dirSizeMutex.RUnlock()
+
+	numClient := len(directorySizes)
+	var totalSize uint64
+	for _, size := range directorySizes {
+		totalSize += size
+	}
+
+	return numClient, totalSize
+}
+
+// errSkipExisting is returned by `resolveFilePath` for an existing file kept by the "skip" or "newer" strategy.
+var errSkipExisting = errors.New("file already exists and skip conflict-resolution strategy is enabled")
+
+// errNotNewer is returned by `resolveFilePath` for an existing file kept by the "newer" strategy.
+// It wraps `errSkipExisting`, since the received file is skipped in the same way.
+var errNotNewer = fmt.Errorf("%w: the received file is not newer", errSkipExisting)
+
+// resolveFilePath resolves the file path for the "overwrite", "skip", and "newer" conflict-resolution strategies.
+// `modTime` is the modification time of the received file for the "newer" strategy, or the zero time if it is unknown,
+// in which case the received file is not considered newer.
+func resolveFilePath(originalPath string, strategy string, modTime time.Time) (string, error) {
+	if err := validateStrategy(strategy); err != nil {
+		return "", err
+	}
+	existing, exists := existingPath(originalPath)
+	if !exists {
+		return originalPath, nil
+	}
+
+	switch strategy {
+	case StrategyOverwrite:
+		// In the "-case-insensitive" mode, the existing file may differ by case, and is replaced by the received name.
+		if err := os.Remove(existing); err != nil {
+			return "", fmt.Errorf("failed to remove existing file: %v", err)
+		}
+		slog.Info("Overwriting the existing file", "file_name", originalPath, "strategy", StrategyOverwrite)
+		return originalPath, nil
+
+	case StrategySkip:
+		return "", fmt.Errorf("%w: %s", errSkipExisting, originalPath)
+
+	case StrategyNewer:
+		info, err := os.Stat(existing)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the information of the existing file: %v", err)
+		}
+		if modTime.IsZero() || !modTime.After(info.ModTime()) {
+			return "", fmt.Errorf("%w: %s", errNotNewer, originalPath)
+		}
+		if err := os.Remove(existing); err != nil {
+			return "", fmt.Errorf("failed to remove existing file: %v", err)
+		}
+		slog.Info("Overwriting the older existing file", "file_name", originalPath, "strategy", StrategyNewer,
+			"existing_mod_time", info.ModTime(), "mod_time", modTime)
+		return originalPath, nil
+
+	default:
+		// The "rename" strategy creates a unique file instead (see `createRenamedFile`).
+		return "", fmt.Errorf("the %s strategy is not resolved by path", strategy)
+	}
+}
+
+// restoreModTime sets the modification time of a received file to the one sent in its header, so that the "newer" strategy
+// compares the next version of the file with this one rather than with the time it was received. A failure is only logged.
+func restoreModTime(logger *slog.Logger, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		logger.Warn("Failed to set the modification time of the received file", "path", path, "mod_time", modTime, "error", err)
+	}
+}
+
+// skippedMessage returns the message of the response to a file skipped by the conflict-resolution strategy.
+func skippedMessage() string {
+	return fmt.Sprintf("File already exists and %s strategy is enabled", *fileStrategy)
+}
+
+// discardContent reads and discards the content of a transfer refused after its header (a file skipped by the "skip" strategy),
+// so that the next request of the session is read from the connection instead of the rest of the content.
+func discardContent(reader io.Reader, header *protocol.Header, buffer []byte) error {
+	var contentReader io.Reader = protocol.NewContentReader(reader, int64(header.FileSize))
+	if header.TransferType == protocol.TransferTypeStream {
+		contentReader = protocol.NewStreamReader(reader, uint64(MaxFileSize))
+	}
+	if header.Compression != protocol.CompressionNone {
+		compressedReader, err := protocol.NewCompressedReader(reader, header.Compression, uint64(MaxFileSize))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = compressedReader.Close()
+		}()
+		contentReader = io.LimitReader(compressedReader, int64(header.FileSize)+1)
+	}
+	_, err := io.CopyBuffer(io.Discard, contentReader, buffer)
+	return err
+}
+
+// createRenamedFile creates the file at `path` for the "rename" strategy, or a unique file next to it
+// (see `generateUniqueFile`) if a file with that name exists. The file is created with `os.O_EXCL`,
+// so that of several transfers of the same name racing for `path`, only one claims it and the others are renamed.
+func createRenamedFile(path string) (*os.File, string, error) {
+	if _, exists := existingPath(path); !exists {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return f, path, nil
+		}
+		if !os.IsExist(err) {
+			return nil, "", fmt.Errorf("failed to create the file: %v", err)
+		}
+	}
+	return generateUniqueFile(path, filepath.Base(path))
+}
+
+// generateUniqueFile atomically creates a unique file by adding a numeric suffix for the "rename" strategy.
+func generateUniqueFile(originalPath, fileName string) (*os.File, string, error) {
+	dir := filepath.Dir(originalPath)
+	ext := filepath.Ext(fileName)
+	baseName := strings.TrimSuffix(fileName, ext)
+
+	counter := 1
+	for {
+		newFileName := fmt.Sprintf("%s_%d%s", baseName, counter, ext)
+		newPath := filepath.Join(dir, newFileName)
+		if caseFolding != nil {
+			if _, exists := caseFolding.lookup(newPath); exists {
+				counter++
+				continue
+			}
+		}
+
+		// Use `os.OpenFile` with `os.O_RDWR|os.O_CREATE|os.O_EXCL` to create the file atomically,
+		// thereby preventing race conditions when multiple clients upload files with the same name concurrently.
+		f, err := os.OpenFile(newPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			slog.Info("Renaming the file to avoid a conflict", "file_name", fileName, "new_file_name", newFileName, "strategy", StrategyRename)
+			return f, newPath, nil
+		}
+
+		// If the error is not "file exists", return the error; otherwise, try the next suffix.
+		if !os.IsExist(err) {
+			return nil, "", fmt.Errorf("failed to create a unique file: %v", err)
+		}
+		counter++
+	}
+}
+
+// handleVerifyRequest compares an already-transferred file against the size and checksum in the header
+// and responds with a match, a mismatch, or not found, without any file content being sent.
+// It also answers sync queries (`protocol.MessageTypeQuery`), which must stay cheap (see `compareStoredFile`).
+func handleVerifyRequest(ctx context.Context, conn net.Conn, header *protocol.Header, logger *slog.Logger) {
+	isQuery := header.MessageType == protocol.MessageTypeQuery
+	requestKind := "Verification"
+	if isQuery {
+		requestKind = "Sync query"
+	}
+
+	path, err := destinationPath(header)
+	if err != nil {
+		logger.Warn("Path sanitization failed", "file_name", header.FileName, "error", err)
+		sendErrorResponse(conn, fmt.Sprintf("Invalid file path: %v", err))
+		return
+	}
+	logger = logger.With("request", requestKind, "file_name", header.FileName)
+
+	message := compareStoredFile(ctx, logger, path, header.FileSize, header.Checksum, isQuery)
+	switch {
+	case message != protocol.VerifyMessageMatch:
+		sendErrorResponse(conn, message)
+	case isQuery:
+		sendCodedResponse(conn, protocol.ResponseStatusExists, protocol.ErrorCodeNone, protocol.VerifyMessageMatch)
+	default:
+		sendSuccessResponse(conn, protocol.VerifyMessageMatch)
+	}
+}
+
+// compareStoredFile compares the file at `path` against the size and checksum, and returns the message of the response:
+// `protocol.VerifyMessageMatch`, `protocol.VerifyMessageMismatch`, `protocol.VerifyMessageNotFound`, or the reason of a failure.
+// A sync comparison (`isQuery`) must stay cheap: it reuses the remembered checksum of an unchanged file,
+// and only hashes a file larger than `MaxQueryHashSize` with "-sync-deep" (`protocol.QueryMessageTooLarge` otherwise).
+// Hashing stops when the context is canceled, so that a shutdown does not wait for a large file to be read.
+func compareStoredFile(ctx context.Context, logger *slog.Logger, path string, size uint64, expected []byte, isQuery bool) string {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Failed to access the file for verification", "error", err)
+			return "Failed to access file"
+		}
+		logger.Info("File not found")
+		return protocol.VerifyMessageNotFound
+	}
+
+	// A size difference already implies a content difference, so the file need not be hashed.
+	if uint64(info.Size()) != size {
+		logger.Info("File size mismatch", "expected_bytes", size, "bytes", info.Size())
+		return protocol.VerifyMessageMismatch
+	}
+
+	checksum, known := []byte(nil), false
+	if isQuery {
+		checksum, known = lookupStoredChecksum(path, info)
+	}
+	if !known {
+		if isQuery && !*syncDeep && info.Size() > MaxQueryHashSize {
+			logger.Info("File too large to hash", "bytes", info.Size())
+			return protocol.QueryMessageTooLarge
+		}
+
+		checksum, err = protocol.CalculateFileChecksumFromPath(ctx, path)
+		if err != nil {
+			logger.Error("Failed to calculate the checksum of the file", "error", err)
+			return "Failed to calculate file checksum"
+		}
+		recordStoredChecksum(path, checksum)
+	}
+
+	if !bytes.Equal(checksum, expected) {
+		logger.Info("File checksum mismatch",
+			"expected_checksum", hex.EncodeToString(expected), "checksum", hex.EncodeToString(checksum))
+		return protocol.VerifyMessageMismatch
+	}
+
+	logger.Info("File checksum verified")
+	return protocol.VerifyMessageMatch
+}
+
+// handleDeleteRequest deletes a file, or with `protocol.TransferTypeDirectory` a directory and its contents,
+// under the destination directory, and responds with deleted or not found.
+// Deletion requests are refused unless the server runs with "-allow-delete", and the destination directory itself is never deleted.
+func handleDeleteRequest(conn net.Conn, header *protocol.Header, logger *slog.Logger) {
+	logger = logger.With("request", "Deletion", "file_name", header.FileName)
+	if !*allowDelete {
+		logger.Warn("Refusing the deletion request, since -allow-delete is not set")
+		sendErrorResponse(conn, "Deletion is disabled on the server")
+		return
+	}
+
+	path, err := destinationPath(header)
+	if err != nil {
+		logger.Warn("Path sanitization failed", "error", err)
+		sendErrorResponse(conn, fmt.Sprintf("Invalid file path: %v", err))
+		return
+	}
+	// With "-tenant-dirs", the tenant directories, directly under the destination directory, are kept as well.
+	if path == filepath.Clean(*destDir) || *tenantDirs && filepath.Dir(path) == filepath.Clean(*destDir) {
+		logger.Warn("Refusing to delete the destination directory")
+		sendErrorResponse(conn, "The destination directory cannot be deleted")
+		return
+	}
+
+	// `os.Lstat` does not follow symbolic links, so a link is deleted rather than its target.
+	info, err := os.Lstat(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Error("Failed to access the file for deletion", "error", err)
+			sendErrorResponse(conn, "Failed to access file")
+			return
+		}
+		logger.Info("File not found")
+		sendErrorResponse(conn, protocol.VerifyMessageNotFound)
+		return
+	}
+
+	if info.IsDir() {
+		if header.TransferType != protocol.TransferTypeDirectory {
+			logger.Warn("Refusing to delete a directory without a recursive request")
+			sendErrorResponse(conn, "Path is a directory, which requires a recursive deletion")
+			return
+		}
+		err = os.RemoveAll(path)
+	} else {
+		err = os.Remove(path)
+	}
+	if err != nil {
+		logger.Error("Failed to delete the file", "path", path, "error", err)
+		sendErrorResponse(conn, "Failed to delete file")
+		return
+	}
+
+	logger.Info("File deleted", "path", path, "directory", info.IsDir())
+	sendSuccessResponse(conn, protocol.DeleteMessageDeleted)
+}
+
+// handleConnection handles a client connection with context support for graceful shutdown.
+func handleConnection(ctx context.Context, conn net.Conn, wg *sync.WaitGroup) {
+	startTime := time.Now()
+	clientAddr := conn.RemoteAddr().String()
+	connLogger := slog.With("client_addr", clientAddr)
+	activeConnections.Add(1)
+
+	// Defer the close of the connection ("Close closes the connection. Any blocked Read or Write operations will be unblocked and return errors.")
+	// and the done ("Done decrements the [WaitGroup] counter by one") of the wait group.
+	defer func() {
+		activeConnections.Add(-1)
+
+		if err := conn.Close(); err != nil {
+			connLogger.Warn("Error closing the connection", "error", err)
+		}
+
+		dirSizeMutex.Lock()
+		// Since the connection is closed, remove the entry from the map (atomically).
+		delete(directorySizes, clientAddr)
+		dirSizeMutex.Unlock()
+
+		connLogger.Info("Connection closed", "duration_ms", time.Since(startTime).Milliseconds())
+
+		// Decrement the `sync.WaitGroup` counter by 1 to indicate that a client connection has finished, last,
+		// so that a drain waiting for the connections (see `drainTransfers`) returns only once they are closed and logged.
+		wg.Done()
+	}()
+
+	connLogger.Info("New connection established")
+
+	if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+		connLogger.Error("Failed to set the read deadline", "error", err)
+		sendErrorResponse(conn, "Internal server error")
+		return
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+		connLogger.Error("Failed to set the write deadline", "error", err)
+		sendErrorResponse(conn, "Internal server error")
+		return
+	}
+
+	if !verifyClientCertificate(conn, connLogger) {
+		return
+	}
+
+	var tenant string
+	if *tenantDirs {
+		var err error
+		if tenant, err = connectionTenant(conn); err != nil {
+			connLogger.Warn("Refusing the client without a valid tenant directory", "error", err)
+			return
+		}
+		connLogger = connLogger.With("tenant", tenant)
+	}
+
+	// Acquire the memory of the buffers of the connection before allocating them, refusing the client as busy
+	// if other connections hold the whole "-max-memory" budget for too long.
+	if !memory.acquire(ctx, connectionMemory(), *maxMemory, MemoryWait) {
+		connLogger.Warn("Refusing the connection while the memory budget is used up", "max_memory", *maxMemory)
+		sendCodedResponse(conn, protocol.ResponseStatusRetryLater, protocol.ErrorCodeServerBusy, protocol.ServerBusyMessage)
+		return
+	}
+	defer memory.release(connectionMemory())
+
+	// Take a copy buffer from the pool once and reuse it for every file transferred on this connection.
+	pooledBuffer := protocol.CopyBuffers.Get(*bufferSize)
+	defer protocol.CopyBuffers.Put(pooledBuffer)
+	transferBuffer := *pooledBuffer
+
+	// Instantiate a `contextReader` to read file content from the connection with context support (for graceful shutdown).
+	ctxReader := &contextReader{
+		ctx:  ctx,
+		conn: conn,
+	}
+	defer ctxReader.release()
+
+	// Handle multiple file transfers on the same connection to persist the connection
+	// until the client closes the connection or an error occurs.
+	for {
+		// The previous transfer is finished, so its bytes are no longer in flight.
+		ctxReader.release()
+
+		// At the beginning of each iteration,
+		// refresh connection timeouts for each file transfer to prevent hanging connections.
+		if err := conn.SetReadDeadline(time.Now().Add(ReadTimeout)); err != nil {
+			connLogger.Error("Failed to set the read deadline", "error", err)
+			return
+		}
+		if err := conn.SetWriteDeadline(time.Now().Add(WriteTimeout)); err != nil {
+			connLogger.Error("Failed to set the write deadline", "error", err)
+			return
+		}
+
+		header, err := protocol.ReadHeader(conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				connLogger.Info("Client closed the connection (end of session)")
+				return
+			}
+
+			connLogger.Error("Failed to read the file transfer header", "error", err)
+			if !errors.Is(err, io.EOF) {
+				sendErrorResponse(conn, "Failed to read file transfer header: "+err.Error())
+			}
+			return
+		}
+
+		// Every request gets its own identifier, so that the messages of the transfers on a connection can be told apart.
+		transferID := protocol.NewTransferID()
+		logger := connLogger.With("transfer_id", transferID)
+
+		// A shutting-down server finishes the requests in progress, but refuses new ones.
+		if shutdownState.inProgress() {
+			logger.Info("Refusing the request during shutdown", "file_name", header.FileName)
+			notifyShutdown(conn, logger)
+			return
+		}
+		if *normalizeUnicode {
+			normalizeHeaderNames(logger, header)
+		}
+		record := newAccessRecord(conn, header, transferID)
+
+		err = applyDestTemplate(header, record.entry.ClientIP, transferID)
+		if err == nil {
+			err = addTenant(header, tenant)
+		}
+		if err == nil {
+			err = validateHeader(header, clientAddr)
+		}
+		if err != nil {
+			logger.Warn("Header validation failed", "file_name", header.FileName, "error", err)
+			if header.MessageType == protocol.MessageTypeTransfer {
+				record.failWithCode(conn, protocol.ResponseStatusError, headerErrorCode(err), err.Error())
+			} else {
+				sendCodedResponse(conn, protocol.ResponseStatusError, headerErrorCode(err), err.Error())
+			}
+			return
+		}
+
+		if *flatten {
+			flattenHeader(header)
+		}
+
+		if header.MessageType == protocol.MessageTypeVerify || header.MessageType == protocol.MessageTypeQuery {
+			handleVerifyRequest(ctx, conn, header, logger)
+			// Continue to the next request, so that a whole directory can be verified (or synced) on the same connection.
+			continue
+		}
+
+		if header.MessageType == protocol.MessageTypeManifest {
+			if err := handleManifestRequest(ctx, conn, header, logger); err != nil {
+				return
+			}
+			// Continue to the next request, so that the files the server needs are sent on the same connection.
+			continue
+		}
+
+		if header.MessageType == protocol.MessageTypeInfo {
+			handleInfoRequest(conn, logger)
+			// Continue to the next request, so that a client can ask before it transfers on the same connection.
+			continue
+		}
+
+		if header.MessageType == protocol.MessageTypePing {
+			handlePingRequest(conn, logger)
+			// Continue to the next request, so that a monitoring probe can keep its connection.
+			continue
+		}
+
+		if header.MessageType == protocol.MessageTypeDelete {
+			handleDeleteRequest(conn, header, logger)
+			// Continue to the next request, so that several paths can be deleted on the same connection.
+			continue
+		}
+
+		if header.MessageType == protocol.MessageTypeValidate {
+			logger.Info("Directory size validation request", "bytes", header.FileSize)
+			sendSuccessResponse(conn, "Directory size validated!")
+			logger.Info("Directory size validation completed", "duration_ms", time.Since(startTime).Milliseconds())
+			return
+		}
+
+		// In the "-discard" mode, nothing is stored, so the destination volume and the storage options play no part.
+		if *discard {
+			ctxReader.track(transferID, clientAddr, header.FileName, header.FileSize)
+			if !handleDiscardTransfer(ctxReader, conn, header, logger, record, transferBuffer) {
+				return
+			}
+			continue
+		}
+
+		// A server whose volume is nearly full refuses new transfers until space is freed, closing the connection
+		// like a shutdown, since the content of the refused transfer follows its header.
+		if diskState.isFull(*destDir, *minFreePercent) {
+			logger.Warn("Refusing the transfer while the destination volume is nearly full", "file_name", header.FileName,
+				"min_free_percent", *minFreePercent)
+			record.failWithCode(conn, protocol.ResponseStatusRetryLater, protocol.ErrorCodeServerFull, protocol.ServerFullMessage)
+			return
+		}
+
+		if header.TransferType == protocol.TransferTypeRange {
+			ctxReader.track(transferID, clientAddr, header.FileName, header.Range.Length)
+			if !handleRangeTransfer(ctxReader, conn, header, logger, record, transferBuffer) {
+				return
+			}
+			continue
+		}
+
+		ctxReader.track(transferID, clientAddr, header.FileName, header.FileSize)
+
+		if header.TransferType == protocol.TransferTypeTarArchive {
+			if !handleArchiveTransfer(ctxReader, conn, header, logger, record, transferBuffer) {
+				return
+			}
+			continue
+		}
+
+		isStream := header.TransferType == protocol.TransferTypeStream
+		logger = logger.With("file_name", header.FileName)
+		switch header.TransferType {
+		case protocol.TransferTypeDirectory:
+			logger.Info("Receiving a directory file", "bytes", header.FileSize)
+		case protocol.TransferTypeStream:
+			logger.Info("Receiving a stream of unknown size", "max_bytes", uint64(MaxFileSize))
+		default:
+			logger.Info("Receiving a file", "bytes", header.FileSize)
+		}
+
+		// Create the directory to save the received file (if it doesn't exist).
+		// `0755`: "OwnerCanDoAllExecuteGroupOtherCanReadExecute" (https://pkg.go.dev/gitlab.com/evatix-go/core/filemode).
+		if err := os.MkdirAll(*destDir, 0755); err != nil {
+			logger.Error("Failed to create the output directory", "dir", *destDir, "error", err)
+			record.fail(conn, "Failed to create output directory")
+			return
+		}
+
+		outputPath, err := destinationPath(header)
+		if err != nil {
+			logger.Warn("Path sanitization failed", "error", err)
+			record.fail(conn, fmt.Sprintf("Invalid file path: %v", err))
+			return
+		}
+
+		outputDir := filepath.Dir(outputPath)
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			logger.Error("Failed to create the directory structure", "dir", outputDir, "error", err)
+			record.fail(conn, "Failed to create directory structure")
+			return
+		}
+
+		var outputFile *os.File
+		var finalPath string
+		// In quarantine mode, the content is received into the quarantine directory and only moved to its destination
+		// once verified, so `finalPath` is resolved (with the conflict-resolution strategy) at the end.
+		var quarantinePath string
+
+		if *quarantineDir != "" {
+			outputFile, quarantinePath, err = createQuarantineFile(record, outputPath)
+			if err != nil {
+				logger.Error("Failed to create the quarantine file", "error", err)
+				record.fail(conn, "Failed to create output file")
+				return
+			}
+			finalPath = quarantinePath
+		} else if *fileStrategy == StrategyRename {
+			outputFile, finalPath, err = createRenamedFile(outputPath)
+			if err != nil {
+				logger.Error("Failed to create a unique file", "strategy", StrategyRename, "error", err)
+				record.fail(conn, fmt.Sprintf("Failed to create unique file: %v", err))
+				return
+			}
+		} else {
+			// For other strategies ("overwrite", "skip", "newer"), resolve the file path.
+			// A header without a modification time (e.g. a stream) does not replace an existing file with the "newer" strategy.
+			finalPath, err = resolveFilePath(outputPath, *fileStrategy, header.ModTime)
+			if err != nil {
+				if errors.Is(err, errSkipExisting) {
+					logger.Info("Skipping the existing file", "strategy", *fileStrategy, "error", err)
+					if err := discardContent(ctxReader, header, transferBuffer); err != nil {
+						logger.Error("Failed to receive the content of the skipped file", "error", err)
+						record.fail(conn, "Failed to receive file content")
+						return
+					}
+					record.failWithCode(conn, protocol.ResponseStatusSkipped, protocol.ErrorCodeConflictSkip, skippedMessage())
+				} else {
+					logger.Error("Failed to handle the file conflict", "strategy", *fileStrategy, "error", err)
+					record.fail(conn, fmt.Sprintf("Failed to handle file conflict: %v", err))
+				}
+				// Continue to next file instead of returning, to allow other files in the session to transfer.
+				continue
+			}
+
+			outputFile, err = os.Create(finalPath)
+			if err != nil {
+				logger.Error("Failed to create the output file", "path", finalPath, "error", err)
+				record.fail(conn, "Failed to create output file")
+				return
+			}
+		}
+
+		if quarantinePath == "" {
+			caseFolding.add(finalPath)
+		}
+		record.begin(finalPath, int64(header.FileSize))
+
+		logger.Debug("Receiving the file content")
+
+		// Instantiate a `ContentReader` to read exactly the declared file size, or, for a stream of unknown size,
+		// a `StreamReader` that enforces `MaxFileSize` against the bytes actually received and verifies the trailing checksum.
+		var contentReader io.Reader = protocol.NewContentReader(ctxReader, int64(header.FileSize))
+		if isStream {
+			contentReader = protocol.NewStreamReader(ctxReader, uint64(MaxFileSize))
+		}
+
+		// The content of a compressed transfer is decompressed as it arrives. Decompressing at most one byte more than
+		// the declared size is enough to detect content larger than the header claims (a decompression bomb).
+		var compressedReader *protocol.CompressedReader
+		if header.Compression != protocol.CompressionNone {
+			compressedReader, err = protocol.NewCompressedReader(ctxReader, header.Compression, uint64(MaxFileSize))
+			if err != nil {
+				logger.Error("Failed to start decompressing the content", "error", err)
+				if err := outputFile.Close(); err != nil {
+					logger.Warn("Error closing the output file", "path", finalPath, "error", err)
+				}
+				if quarantinePath != "" {
+					rejectQuarantined(logger, record, quarantinePath, outputPath, "failed to decompress the content")
+				} else if err := os.Remove(finalPath); err != nil {
+					logger.Warn("Failed to remove the empty file", "path", finalPath, "error", err)
+				}
+				record.fail(conn, "Failed to decompress file content")
+				return
+			}
+			contentReader = io.LimitReader(compressedReader, int64(header.FileSize)+1)
+		}
+
+		// In "-dedup" mode, if the content is already stored, the bytes are still received and verified but then discarded,
+		// and the file is hard-linked to the stored copy afterward. The checksum of a stream is only known at its end.
+		var dedupSource string
+		if *dedup && !isStream && quarantinePath == "" {
+			if existing, ok := lookupDedup(header.Checksum); ok && existing != finalPath {
+				dedupSource = existing
+				logger.Info("Content is already stored, discarding the received bytes", "existing", existing)
+			}
+		}
+
+		// Reserve the space of the file before its content arrives, so that a file that does not fit is refused
+		// before the client sends it. The connection is closed like a full server's, since the content follows the header.
+		preallocated := false
+		if *preallocateFiles && !isStream && dedupSource == "" && header.FileSize > 0 {
+			preallocated, err = preallocate(outputFile, int64(header.FileSize))
+			if err != nil {
+				logger.Error("Not enough space for the file", "bytes", header.FileSize, "error", err)
+				if compressedReader != nil {
+					if err := compressedReader.Close(); err != nil {
+						logger.Warn("Error closing the decompressor", "path", finalPath, "error", err)
+					}
+				}
+				if err := outputFile.Close(); err != nil {
+					logger.Warn("Error closing the output file", "path", finalPath, "error", err)
+				}
+				if quarantinePath != "" {
+					rejectQuarantined(logger, record, quarantinePath, outputPath, "not enough space for the file")
+				} else if err := os.Remove(finalPath); err != nil {
+					logger.Warn("Failed to remove the empty file", "path", finalPath, "error", err)
+				}
+				record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeServerFull,
+					fmt.Sprintf("Not enough space on the server for %d bytes", header.FileSize))
+				return
+			}
+		}
+
+		// Instantiate a `TeeReader` that reads from network and writes to hash while returning data to be copied to file.
+		hasher := sha256.New()
+		teeReader := io.TeeReader(contentReader, hasher)
+		// The blocks of a file sent with block checksums are verified as they arrive, so that a corrupted block is found where it is.
+		var blockHasher *protocol.BlockHasher
+		if header.BlockSize != 0 {
+			blockHasher = protocol.NewBlockHasher(header.BlockSize, header.BlockChecksums)
+			teeReader = io.TeeReader(contentReader, io.MultiWriter(hasher, blockHasher))
+		}
+
+		// Instantiate a `ProgressWriter` to track transfer progress (only possible if the size is known up front).
+		var fileWriter io.Writer = outputFile
+		if dedupSource != "" {
+			fileWriter = io.Discard
+		}
+		// The size of a stream is unknown (0), so its progress is shown with a spinner instead of a bar.
+		progressWriter := protocol.NewProgressWriter(fileWriter, header.FileSize, fmt.Sprintf("Receiving %s", header.FileName), os.Stderr, *progress,
+			protocol.WithCopyBuffer(transferBuffer))
+		fileWriter = progressWriter
+
+		bytesWritten, err := io.CopyBuffer(fileWriter, teeReader, transferBuffer)
+		// A corrupted block stops the file from being written, but the rest of its content is still read (and discarded),
+		// so that the client reads the index of the block and the connection can carry the next file.
+		var blockErr *protocol.BlockMismatchError
+		contentDrained := false
+		if errors.As(err, &blockErr) {
+			_, drainErr := io.CopyBuffer(io.Discard, contentReader, transferBuffer)
+			contentDrained = drainErr == nil
+		}
+		if compressedReader != nil {
+			if err := compressedReader.Close(); err != nil {
+				logger.Warn("Error closing the decompressor", "path", finalPath, "error", err)
+			}
+		}
+		// A file that ends short of its preallocated space is truncated to its content, which frees the space beyond it
+		// (e.g. for the partial file of a rejected transfer kept in quarantine).
+		if preallocated && bytesWritten < int64(header.FileSize) {
+			if err := outputFile.Truncate(bytesWritten); err != nil {
+				logger.Warn("Failed to free the preallocated space", "path", finalPath, "error", err)
+			}
+		}
+		if err != nil {
+			logger.Error("Failed to receive the file content", "bytes", bytesWritten, "error", err)
+			if errors.Is(err, io.EOF) {
+				logger.Warn("Client disconnected during the file transfer")
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				logger.Warn("Client sent incomplete file data")
+			}
+			if ctx.Err() != nil {
+				logger.Warn("Transfer interrupted due to server shutdown", "error", ctx.Err())
+			}
+			if err := outputFile.Close(); err != nil {
+				logger.Warn("Error closing the output file", "path", finalPath, "error", err)
+			}
+			if quarantinePath != "" {
+				record.entry.Bytes = bytesWritten
+				rejectQuarantined(logger, record, quarantinePath, outputPath, fmt.Sprintf("failed to receive the content: %v", err))
+			} else if err := os.Remove(finalPath); err != nil {
+				logger.Warn("Failed to remove the partial file", "path", finalPath, "error", err)
+			}
+			switch {
+			case ctx.Err() != nil:
+				record.abort(conn, logger)
+			case blockErr != nil:
+				logger.Error("Block checksum verification failed", "block", blockErr.Index, "offset", uint64(blockErr.Index)*uint64(header.BlockSize))
+				record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, protocol.BlockMismatchMessage(blockErr.Index))
+				if contentDrained {
+					continue
+				}
+			case errors.Is(err, protocol.ErrStreamTooLarge):
+				record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeFileTooLarge,
+					fmt.Sprintf("Stream exceeds the maximum allowed size of %d bytes", uint64(MaxFileSize)))
+			case errors.Is(err, protocol.ErrChecksumMismatch):
+				record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+			case errors.Is(err, protocol.ErrIncompleteContent):
+				record.fail(conn, "File size mismatch: "+protocol.ErrIncompleteContent.Error())
+			default:
+				record.fail(conn, "Failed to receive file content")
+			}
+			return
+		}
+
+		// With "-fsync", the content is on stable storage before the file is acknowledged.
+		var syncErr error
+		if syncContent(quarantinePath != "") {
+			syncErr = outputFile.Sync()
+		}
+		if err := outputFile.Close(); err != nil {
+			logger.Warn("Error closing the output file", "path", finalPath, "error", err)
+		}
+		record.entry.Bytes = bytesWritten
+		if syncErr != nil {
+			logger.Error("Failed to sync the received file", "path", finalPath, "error", syncErr)
+			if quarantinePath != "" {
+				rejectQuarantined(logger, record, quarantinePath, outputPath, fmt.Sprintf("failed to sync the content: %v", syncErr))
+			} else if err := os.Remove(finalPath); err != nil {
+				logger.Warn("Failed to remove the unsynced file", "path", finalPath, "error", err)
+			}
+			record.fail(conn, "Failed to store the file")
+			return
+		}
+
+		if !isStream && bytesWritten != int64(header.FileSize) {
+			logger.Error("File size mismatch", "expected_bytes", header.FileSize, "bytes", bytesWritten)
+			if quarantinePath != "" {
+				rejectQuarantined(logger, record, quarantinePath, outputPath,
+					fmt.Sprintf("size mismatch: expected %d bytes, received %d", header.FileSize, bytesWritten))
+			} else if err := os.Remove(finalPath); err != nil {
+				logger.Warn("Failed to remove the incomplete (partial) file", "path", finalPath, "error", err)
+			}
+			record.fail(conn, "File size mismatch")
+			return
+		}
+
+		// Bytes following the declared content would be parsed as the next header, so the transfer fails instead.
+		if *trailingWait > 0 {
+			if err := protocol.CheckTrailingData(conn, *trailingWait); err != nil {
+				logger.Error("Unexpected data after the file content", "expected_bytes", header.FileSize, "error", err)
+				if quarantinePath != "" {
+					rejectQuarantined(logger, record, quarantinePath, outputPath, err.Error())
+				} else if err := os.Remove(finalPath); err != nil {
+					logger.Warn("Failed to remove the file", "path", finalPath, "error", err)
+				}
+				if errors.Is(err, protocol.ErrTrailingData) {
+					record.fail(conn, "File size mismatch: "+protocol.ErrTrailingData.Error())
+				} else {
+					record.fail(conn, "Failed to receive file content")
+				}
+				return
+			}
+		}
+
+		if compressedReader != nil {
+			logger.Info("Decompressed the file content", "bytes", bytesWritten, "compressed_bytes", compressedReader.CompressedBytes())
+		}
+
+		progressWriter.Complete()
+		if isStream {
+			logger.Info("Stream completed", "bytes", bytesWritten)
+		}
+
+		logger.Debug("Verifying the received data integrity")
+		if blockHasher != nil {
+			if _, err := blockHasher.Sum(); errors.As(err, &blockErr) {
+				logger.Error("Block checksum verification failed", "block", blockErr.Index, "offset", uint64(blockErr.Index)*uint64(header.BlockSize))
+				if quarantinePath != "" {
+					rejectQuarantined(logger, record, quarantinePath, outputPath, fmt.Sprintf("checksum mismatch of block %d", blockErr.Index))
+				} else if err := os.Remove(finalPath); err != nil {
+					logger.Warn("Failed to remove the corrupted file", "path", finalPath, "error", err)
+				}
+				record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, protocol.BlockMismatchMessage(blockErr.Index))
+				// The content was received in full, so the connection can carry the next file.
+				continue
+			}
+		}
+		calculatedChecksum := hasher.Sum(nil)
+		// The checksum of a stream trails its content and has already been verified by the `StreamReader`.
+		if !isStream && !bytes.Equal(calculatedChecksum, header.Checksum) {
+			logger.Error("Data checksum verification failed",
+				"expected_checksum", hex.EncodeToString(header.Checksum), "checksum", hex.EncodeToString(calculatedChecksum))
+			if quarantinePath != "" {
+				rejectQuarantined(logger, record, quarantinePath, outputPath, fmt.Sprintf("checksum mismatch: expected %s, received %s",
+					hex.EncodeToString(header.Checksum), hex.EncodeToString(calculatedChecksum)))
+			} else if err := os.Remove(finalPath); err != nil {
+				logger.Warn("Failed to remove the corrupted file", "path", finalPath, "error", err)
+			}
+			record.failWithCode(conn, protocol.ResponseStatusError, protocol.ErrorCodeChecksumMismatch, "Data integrity check failed")
+			return
+		}
+		logger.Debug("Data checksum verification passed")
+
+		if quarantinePath != "" {
+			finalPath, err = releaseQuarantined(logger, record, quarantinePath, outputPath, calculatedChecksum, header.ModTime, transferBuffer)
+			switch {
+			case errors.Is(err, errQuarantineRejected):
+				record.fail(conn, "File rejected by the server's validation")
+				// The content was received in full, so the connection can carry the next file.
+				continue
+			case errors.Is(err, errSkipExisting):
+				logger.Info("Skipping the existing file", "strategy", *fileStrategy, "error", err)
+				record.failWithCode(conn, protocol.ResponseStatusSkipped, protocol.ErrorCodeConflictSkip, skippedMessage())
+				continue
+			case err != nil:
+				logger.Error("Failed to release the file from quarantine", "path", quarantinePath, "error", err)
+				record.fail(conn, "Failed to store the file")
+				return
+			}
+		}
+
+		if *dedup {
+			if dedupSource != "" {
+				if err := linkDuplicate(dedupSource, finalPath, transferBuffer); err != nil {
+					logger.Error("Failed to store the duplicate", "path", finalPath, "error", err)
+					if err := os.Remove(finalPath); err != nil && !os.IsNotExist(err) {
+						logger.Warn("Failed to remove the duplicate", "path", finalPath, "error", err)
+					}
+					record.fail(conn, "Failed to store the file")
+					return
+				}
+				logger.Info("Stored the file as a link to its duplicate", "path", finalPath, "existing", dedupSource)
+			}
+			recordDedup(calculatedChecksum, finalPath)
+		}
+		recordStoredChecksum(finalPath, calculatedChecksum)
+
+		// A link to a duplicate shares the attributes of the stored copy, which are left as they are.
+		if len(header.Xattrs) > 0 && dedupSource == "" {
+			restoreXattrs(logger, finalPath, header.Xattrs)
+		}
+		if !header.ModTime.IsZero() && dedupSource == "" {
+			restoreModTime(logger, finalPath, header.ModTime)
+		}
+
+		if header.TransferType == protocol.TransferTypeDirectory {
+			dirSizeMutex.Lock()
+			directorySizes[clientAddr] += header.FileSize
+			currentTotal := directorySizes[clientAddr]
+			dirSizeMutex.Unlock()
+			logger.Info("Directory transfer progress", "directory_bytes", currentTotal)
+		}
+
+		// The entry of the file in its directory, created or renamed into it, must be durable as well.
+		if *fsyncFiles {
+			if err := syncDir(filepath.Dir(finalPath)); err != nil {
+				logger.Error("Failed to sync the destination directory", "path", finalPath, "error", err)
+				record.fail(conn, "Failed to store the file")
+				return
+			}
+		}
+
+		record.stored(finalPath, calculatedChecksum)
+		sendTransferResponse(conn, protocol.ResponseStatusSuccess, protocol.ErrorCodeNone, protocol.TransferReceivedMessage(calculatedChecksum),
+			transferReceipt(finalPath, uint64(bytesWritten), calculatedChecksum))
+		record.complete(finalPath, bytesWritten, calculatedChecksum)
+
+		notifyCompleted(record, completedFile{
+			path:     finalPath,
+			name:     header.FileName,
+			checksum: calculatedChecksum,
+			size:     uint64(bytesWritten),
+		})
+
+		logger.Info("Transfer completed", "bytes", bytesWritten, "path", finalPath, "duration_ms", time.Since(startTime).Milliseconds())
+
+		// Continue to the next file transfer on the same connection.
+		// The loop will break when the client closes the connection or an error occurs.
+	}
+}
+
+// Main runs the server with the command-line arguments (without the program name) until it is shut down by a signal.
+func Main(args []string) {
+	// Errors exit the program, since `Flags` is created with `flag.ExitOnError`.
+	_ = Flags.Parse(args)
+	if *listStrategies {
+		printStrategies(os.Stdout)
+		return
+	}
+
+	// Flags given on the command line take precedence over the configuration file, also when it is reloaded.
+	explicit := map[string]bool{}
+	Flags.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	setupLogging()
+	if *configPath != "" {
+		config, err := loadConfig(*configPath)
+		if err != nil {
+			fatal("Failed to load the configuration", "error", err)
+		}
+		if err := applyConfig(config, explicit); err != nil {
+			fatal("Failed to load the configuration", "error", err)
+		}
+		// The configuration file may set the logging flags as well.
+		setupLogging()
+	}
+
+	if err := validateFlags(); err != nil {
+		fatal("Invalid command-line arguments", "error", err)
+	}
+
+	slog.Info("Starting file transfer server...")
+	slog.Info("Directory size limit", "bytes", maxDirectorySize.Load(), "gb", toGB(maxDirectorySize.Load()))
+
+	// Create a cancellable context for managing graceful shutdown.
+	// `ctx` is the context that can be passed to goroutines to listen for cancellation signals.
+	// `cancel` is the function that can be called to cancel the context.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load the TLS configuration if certificates are provided.
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		fatal("Failed to load the TLS configuration", "error", err)
+	}
+
+	// Establish a listener on the specified port and listen for incoming connections.
+	var listener net.Listener
+	if tlsConfig != nil {
+		slog.Info("Starting server with TLS encryption")
+		listener, err = tls.Listen("tcp", listenAddress(), tlsConfig)
+		if err != nil {
+			fatal("Failed to start listening for incoming TLS connections", "error", err)
+		}
+	} else {
+		slog.Warn("Starting server without TLS encryption (insecure)")
+		listener, err = net.Listen("tcp", listenAddress())
+		if err != nil {
+			fatal("Failed to start listening for incoming connections", "error", err)
+		}
+	}
+
+	defer func() {
+		if err := listener.Close(); err != nil {
+			slog.Warn("Error closing the listener", "error", err)
+		}
+		slog.Info("Server listener closed")
+	}()
+
+	slog.Info("Server is listening", "addr", listener.Addr().String())
+
+	if *onComplete != "" {
+		completeHook = newHookRunner(*onComplete, HookWorkers, HookQueueSize)
+		defer func() {
+			slog.Info("Waiting for the queued -on-complete commands to finish...")
+			completeHook.close()
+		}()
+		slog.Info("Running the -on-complete command after each received file", "command", *onComplete, "workers", HookWorkers)
+	}
+
+	insensitive := *caseInsensitive == CaseModeTrue
+	if *caseInsensitive == CaseModeAuto {
+		insensitive, err = detectCaseInsensitive(*destDir)
+		if err != nil {
+			slog.Warn("Failed to probe the destination directory for case sensitivity, comparing names byte for byte", "dir", *destDir, "error", err)
+		}
+	}
+	if insensitive {
+		caseFolding = newCaseIndex()
+		slog.Info("Treating file names differing only by case as conflicts", "dir", *destDir, "mode", *caseInsensitive)
+	}
+
+	if *quarantineDir != "" {
+		stale, err := sweepQuarantine(*quarantineDir, *quarantineMaxAge, *quarantineClean)
+		if err != nil {
+			fatal("Failed to sweep the quarantine directory", "error", err)
+		}
+		slog.Info("Receiving files into quarantine", "dir", *quarantineDir, "stale_entries", stale, "cleaned", *quarantineClean)
+	}
+
+	if !*noJournal {
+		var recovery journalRecovery
+		journal, recovery, err = openJournal(*destDir)
+		if err != nil {
+			fatal("Failed to open the journal", "error", err)
+		}
+		// Deferred calls run once every connection has finished, so that the ends of the last transfers are recorded.
+		defer func() {
+			if err := journal.close(); err != nil {
+				slog.Warn("Error closing the journal", "path", journal.path, "error", err)
+			}
+		}()
+		slog.Info("Recording the received files in the journal", "path", journal.path,
+			"restored_checksums", recovery.restored, "interrupted_transfers", recovery.interrupted)
+	}
+
+	if *webhookURL != "" {
+		secret, err := loadWebhookSecret()
+		if err != nil {
+			fatal("Failed to load the webhook secret", "error", err)
+		}
+		webhook = newWebhookNotifier(*webhookURL, secret, *webhookTimeout, *webhookRetries, WebhookWorkers, WebhookQueueSize)
+		defer func() {
+			slog.Info("Waiting for the queued webhook notifications to be delivered...")
+			webhook.close()
+		}()
+		slog.Info("Posting a webhook notification after each received file", "url", *webhookURL, "signed", secret != "")
+	}
+
+	if *debugAddr != "" {
+		debugServer, err := startHTTPServer("debug", *debugAddr, newDebugHandler())
+		if err != nil {
+			fatal("Failed to start the debug endpoint", "error", err)
+		}
+		// Deferred calls run once every connection has finished, so that the endpoint stays up while transfers drain.
+		defer shutdownHTTPServer("debug", debugServer)
+	}
+
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		metricsServer, err = startHTTPServer("metrics", *metricsAddr, newMetricsHandler())
+		if err != nil {
+			fatal("Failed to start the metrics endpoint", "error", err)
+		}
+	}
+
+	if *minFreePercent > 0 {
+		slog.Info("Refusing new transfers while the destination volume is nearly full", "dir", *destDir,
+			"min_free_percent", *minFreePercent, "check_interval", DiskCheckInterval.String())
+	}
+
+	if *serverRateLimit > 0 {
+		readLimiter = protocol.NewRateLimiter(*serverRateLimit)
+		slog.Info("Limiting the aggregate receive rate", "bytes_per_sec", *serverRateLimit)
+	}
+
+	if *accessLogPath != "" {
+		accessLog, err = openAccessLog(*accessLogPath, *accessLogMaxSize)
+		if err != nil {
+			fatal("Failed to open the access log", "error", err)
+		}
+		// Deferred calls run once every connection has finished, so that the entries of the last transfers are kept.
+		defer func() {
+			if err := accessLog.close(); err != nil {
+				slog.Warn("Error closing the access log", "path", *accessLogPath, "error", err)
+			}
+		}()
+		slog.Info("Writing the access log", "path", *accessLogPath, "max_bytes", *accessLogMaxSize)
+	}
+	if *auditLogPath != "" {
+		auditLog, err = openAuditLog(*auditLogPath)
+		if err != nil {
+			fatal("Failed to open the audit log", "error", err)
+		}
+		defer func() {
+			if err := auditLog.close(); err != nil {
+				slog.Warn("Error closing the audit log", "path", *auditLogPath, "error", err)
+			}
+		}()
+		slog.Info("Writing the audit log", "path", *auditLogPath)
+	}
+
+	// Create a wait group to wait for all connections ("a collection of goroutines") to finish.
+	var wg sync.WaitGroup
+
+	// Set up signal handling for graceful shutdown.
+	// Create a channel to receive signals.
+	// The channel is buffered to hold one signal without blocking the sender (the OS signal handler).
+	receiveSigChannel := make(chan os.Signal, 1)
+	// Set up an OS signal handler to relay signals to the channel.
+	signal.Notify(receiveSigChannel, syscall.SIGINT, syscall.SIGTERM)
+	// Create a channel that carries an empty struct (since no data is needed to be sent) to signal the main loop to stop accepting new connections.
+	// The channel is unbuffered to ensure that the main loop only stops accepting new connections when all active connections have finished.
+	shutdownChannel := make(chan struct{})
+
+	// Reload the configuration file on SIGHUP, and rotate the access log on SIGUSR2, without affecting the active connections.
+	reloadSigChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadSigChannel, syscall.SIGHUP)
+	rotateSigChannel := make(chan os.Signal, 1)
+	signal.Notify(rotateSigChannel, syscall.SIGUSR2)
+	go func() {
+		for {
+			select {
+			case <-reloadSigChannel:
+				slog.Info("Reload signal received. Reloading the configuration...")
+				if err := reloadConfig(explicit); err != nil {
+					slog.Error("Failed to reload the configuration (keeping the current one)", "error", err)
+				}
+			case <-rotateSigChannel:
+				if accessLog == nil {
+					slog.Warn("Rotation signal received, but no access log (-access-log) is configured")
+					continue
+				}
+				if err := accessLog.rotate(); err != nil {
+					slog.Error("Failed to rotate the access log", "error", err)
+				}
+			case <-shutdownChannel:
+				return
+			}
+		}
+	}()
+
+	// Launch a goroutine to sweep the destination directory for orphaned partial files, at startup and every "-cleanup-interval".
+	// It runs alongside the transfers, whose partial files it leaves alone.
+	if *cleanupMode != CleanupModeOff {
+		slog.Info("Sweeping the destination directory for orphaned partial files", "dir", *destDir, "mode", *cleanupMode,
+			"max_age", cleanupMaxAge.String(), "interval", cleanupInterval.String())
+		go runPartialSweeps(*destDir, *cleanupMaxAge, *cleanupMode, *cleanupInterval, shutdownChannel)
+	}
+
+	// Launch a goroutine to periodically log directory transfer, memory, and partial file sweep statistics,
+	// and remove the abandoned range transfers.
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		var loggedSwept int64
+		for {
+			select {
+			case <-ticker.C:
+				numClient, totalSize := getDirectoryStats()
+				if numClient > 0 {
+					slog.Info("Directory transfer stats", "active_clients", numClient, "bytes", totalSize)
+				}
+				if used, peak := memory.usage(); *maxMemory > 0 && used > 0 {
+					slog.Info("Memory budget stats", "bytes", used, "peak_bytes", peak, "max_memory", *maxMemory)
+				}
+				if swept := sweptPartials.Load(); swept != loggedSwept {
+					loggedSwept = swept
+					slog.Info("Partial file sweep stats", "files", swept, "reclaimed_bytes", sweptBytes.Load(), "mode", *cleanupMode)
+				}
+				if removed := sweepRangeTransfers(time.Now(), *rangeTimeout); removed > 0 {
+					slog.Info("Removed idle range transfers", "count", removed, "timeout", rangeTimeout.String())
+				}
+			case <-shutdownChannel:
+				return
+			}
+		}
+	}()
+
+	// Launch a goroutine to handle shutdown signals.
+	go func() {
+		sig := <-receiveSigChannel
+		slog.Info("Shutdown signal received. Starting graceful shutdown...", "signal", sig.String())
+
+		// Refuse new requests, but let the transfers in progress complete until the shutdown timeout.
+		shutdownState.begin()
+
+		if err := listener.Close(); err != nil {
+			slog.Warn("Error closing the listener during shutdown", "error", err)
+		}
+		// The metrics endpoint stops along with the listener, before the main loop returns.
+		if metricsServer != nil {
+			shutdownHTTPServer("metrics", metricsServer)
+		}
+
+		close(shutdownChannel)
+
+		slog.Info("Waiting for active transfers to complete...", "timeout", ShutdownTimeout.String())
+		logActiveTransfers(slog.LevelInfo, "Transfer still in progress")
+		doneChannel := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(doneChannel)
+		}()
+		if drainTransfers(doneChannel, ShutdownTimeout, DrainLogInterval) {
+			slog.Info("All active transfers completed.")
+		} else {
+			slog.Warn("Shutdown timeout reached. Forcing shutdown...")
+			// Cancel the context to signal all active transfers to stop, which notifies their clients.
+			cancel()
+		}
+
+		numClient, totalSize := getDirectoryStats()
+		if numClient > 0 {
+			slog.Info("Final directory transfer stats", "active_clients", numClient, "bytes", totalSize)
+		}
+	}()
+
+	// Main loop to accept incoming client connections.
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-shutdownChannel:
+				slog.Info("Stopped accepting new connections.")
+				wg.Wait()
+				shutdownState.logSummary()
+				slog.Info("All active connections finished. Server exiting.")
+				return
+			default:
+				slog.Error("Failed to accept a client connection", "error", err)
+				continue
+			}
+		}
+		if _, err := protocol.TuneTCP(conn, *tcpNoDelay, *tcpKeepAlive); err != nil {
+			slog.Warn("Failed to tune the client connection", "client_addr", conn.RemoteAddr().String(), "error", err)
+		}
+
+		// Increment the `sync.WaitGroup` counter by `1` to indicate that a new client connection (handled in a new goroutine) has started
+		// so that the server will wait for this connection to finish before shutting down.
+		wg.Add(1)
+
+		// Launch a new goroutine to handle the client connection so that the server can concurrently handle multiple connections.
+		go handleConnection(ctx, conn, &wg)
+	}
+}
+
+// loadTLSConfig loads the TLS configuration for the server.
+// The certificate is served through `GetCertificate`, so that a reload (SIGHUP) can replace it for new connections.
+func loadTLSConfig() (*tls.Config, error) {
+	if *tlsCertFile == "" || *tlsKeyFile == "" {
+		return nil, nil
+	}
+
+	if err := serverCertificate.load(*tlsCertFile, *tlsKeyFile); err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		GetCertificate: serverCertificate.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+
+	if *requireClientTLS {
+		caCert, err := os.ReadFile(*clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client CA certificate: %v", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse the client CA certificate")
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = caCertPool
+	}
+
+	return config, nil
+}
+
+// verifyClientCertificate completes the TLS handshake of a connection when client certificates are required,
+// so that a client without a valid certificate is rejected before any request is read.
+// It returns whether the connection may proceed, logging the subject of the verified certificate.
+func verifyClientCertificate(conn net.Conn, logger *slog.Logger) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok || !*requireClientTLS {
+		return true
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Warn("Rejected the client at the TLS handshake", "error", err)
+		return false
+	}
+	if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		logger.Info("Verified the client certificate", "subject", certs[0].Subject.String())
+	}
+	return true
+}
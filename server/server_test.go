@@ -0,0 +1,2827 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"log/slog"
+	"maps"
+	"math/big"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestToGBZeroByte tests the `toGB` function with 0 bytes.
+func TestToGBZeroByte(t *testing.T) {
+	bytes := uint64(0)
+	expected := 0.0
+
+	got := toGB(bytes)
+	if got != expected {
+		t.Fatalf("`toGB(%d)` = %f, expected %f", bytes, got, expected)
+	}
+}
+
+// TestToGB5GB tests the `toGB` function with 5 GB.
+func TestToGB5GB(t *testing.T) {
+	bytes := uint64(5 * 1024 * 1024 * 1024)
+	expected := 5.0
+
+	got := toGB(bytes)
+	if got != expected {
+		t.Fatalf("`toGB(%d)` = %f, expected %f", bytes, got, expected)
+	}
+}
+
+// A lockedBuffer is a buffer safe for concurrent use, for the log messages that connections may still write
+// (e.g. once their deferred calls run) while the test reads them.
+type lockedBuffer struct {
+	mu     sync.Mutex
+	buffer bytes.Buffer
+}
+
+// Write appends the message to the buffer.
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.Write(p)
+}
+
+// String returns the messages written so far.
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buffer.String()
+}
+
+// captureLogs sends the log messages of the test to a JSON handler, and returns a function
+// that decodes the messages logged so far. The default loggers are restored at the end of the test.
+func captureLogs(t *testing.T) func() []map[string]any {
+	t.Helper()
+
+	logger, writer, flags, prefix := slog.Default(), log.Writer(), log.Flags(), log.Prefix()
+	t.Cleanup(func() {
+		slog.SetDefault(logger)
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+		log.SetPrefix(prefix)
+	})
+
+	var output lockedBuffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&output, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	return func() []map[string]any {
+		t.Helper()
+
+		var entries []map[string]any
+		for line := range strings.Lines(output.String()) {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("failed to decode the log line %q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+}
+
+// findLog returns the first logged message with the given text, or fails the test.
+func findLog(t *testing.T, entries []map[string]any, msg string) map[string]any {
+	t.Helper()
+
+	for _, entry := range entries {
+		if entry["msg"] == msg {
+			return entry
+		}
+	}
+	t.Fatalf("expected the log to contain %q, got: %v", msg, entries)
+	return nil
+}
+
+// TestSetupLogging tests the `setupLogging` function to ensure that
+// it expectedly configures the text format by default, and the JSON format with "-log-format".
+func TestSetupLogging(t *testing.T) {
+	captureLogs(t)
+	setupLogging()
+
+	expectedFlags := log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile
+	if log.Flags() != expectedFlags {
+		t.Fatalf("expected the log flag(s) `log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile`, got %d", log.Flags())
+	}
+
+	expectedPrefix := LogPrefix + " "
+	if log.Prefix() != expectedPrefix {
+		t.Fatalf("expected the log prefix %q, got %q", expectedPrefix, log.Prefix())
+	}
+
+	withFlags(t, map[string]string{"log-format": protocol.LogFormatJSON, "log-level": "warn"})
+	setupLogging()
+	if _, ok := slog.Default().Handler().(*slog.JSONHandler); !ok {
+		t.Fatalf("expected a JSON handler, got %T", slog.Default().Handler())
+	}
+	if slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("expected info messages to be dropped at the warn level")
+	}
+}
+
+// TestSanitizePathEmptyPath tests the `sanitizePath` function to ensure that
+// it expectedly handles an empty user path.
+func TestSanitizePathEmptyPath(t *testing.T) {
+	base := t.TempDir()
+	userPath := ""
+
+	_, err := sanitizePath(base, userPath)
+	if err == nil {
+		t.Fatalf("expected error for empty user path")
+	}
+}
+
+// TestSanitizePathBasicPath tests the `sanitizePath` function to ensure that
+// it handles basic (non-nested) file and directory paths.
+func TestSanitizePathBasicPath(t *testing.T) {
+	base := t.TempDir()
+	userPath := "file.txt"
+	expectedPath := filepath.Join(base, "file.txt")
+
+	got, err := sanitizePath(base, userPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, got)
+	}
+
+	userPath = "dir"
+	expectedPath = filepath.Join(base, "dir")
+
+	got, err = sanitizePath(base, userPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, got)
+	}
+}
+
+// TestSanitizePathNestedPath tests the `sanitizePath` function to ensure that
+// it expectedly handles nested file and directory paths.
+func TestSanitizePathNestedPath(t *testing.T) {
+	base := t.TempDir()
+	userPath := "dir/subdir/file.txt"
+	expectedPath := filepath.Join(base, "dir", "subdir", "file.txt")
+
+	got, err := sanitizePath(base, userPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, got)
+	}
+}
+
+// TestSanitizePathDotSegments tests the `sanitizePath` function to ensure that
+// it expectedly handles paths with dot segments.
+func TestSanitizePathDotSegments(t *testing.T) {
+	base := t.TempDir()
+	userPath := "dir/./subdir/./file.txt"
+	expectedPath := filepath.Join(base, "dir", "subdir", "file.txt")
+
+	got, err := sanitizePath(base, userPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, got)
+	}
+}
+
+// TestSanitizePathAbsolutePath tests the `sanitizePath` function to ensure that
+// it expectedly rejects absolute paths.
+func TestSanitizePathAbsolutePath(t *testing.T) {
+	base := t.TempDir()
+	userPath := string(filepath.Separator) + "etc" + string(filepath.Separator) + "passwd"
+
+	_, err := sanitizePath(base, userPath)
+	if err == nil {
+		t.Fatal("expected error for absolute path")
+	}
+
+}
+
+// TestSanitizePathUnixStylePathTraversal tests the `sanitizePath` function to ensure that
+// it expectedly rejects unix-style path traversal attempts.
+func TestSanitizePathUnixStylePathTraversal(t *testing.T) {
+	base := t.TempDir()
+	userPath := "dir/../secret.txt"
+
+	_, err := sanitizePath(base, userPath)
+	if err == nil {
+		t.Fatal("expected error for unix style path traversal")
+	}
+}
+
+// TestSanitizePathBackslashPathTraversal tests the `sanitizePath` function to ensure that
+// it expectedly rejects backslash path traversal attempts.
+func TestSanitizePathBackslashPathTraversal(t *testing.T) {
+	base := t.TempDir()
+	userPath := "dir\\..\\secret.txt"
+
+	_, err := sanitizePath(base, userPath)
+	if err == nil {
+		t.Fatal("expected error for backslash path traversal")
+	}
+}
+
+// TestSanitizePathMixedPathTraversal tests the `sanitizePath` function to ensure that
+// it expectedly rejects mixed path traversal attempts.
+func TestSanitizePathMixedPathTraversal(t *testing.T) {
+	base := t.TempDir()
+	userPath := "dir/..\\secret.txt"
+
+	_, err := sanitizePath(base, userPath)
+	if err == nil {
+		t.Fatal("expected error for mixed path traversal")
+	}
+}
+
+// TestSanitizePathComponentLength tests the `sanitizePath` function to ensure that
+// a 255-byte name is accepted and a longer one (in bytes, not runes) is rejected, honoring "-max-name-length".
+func TestSanitizePathComponentLength(t *testing.T) {
+	base := t.TempDir()
+
+	if _, err := sanitizePath(base, "dir/"+strings.Repeat("a", 255)); err != nil {
+		t.Fatalf("unexpected error for a 255-byte name: %v", err)
+	}
+	for _, userPath := range []string{strings.Repeat("a", 256) + "/file.txt", strings.Repeat("€", 86)} {
+		if _, err := sanitizePath(base, userPath); !errors.Is(err, protocol.ErrPathComponentTooLong) {
+			t.Fatalf("expected ErrPathComponentTooLong for a %d-byte name, got: %v", len(userPath), err)
+		}
+	}
+
+	originalMaxNameLength := *maxNameLength
+	*maxNameLength = 8
+	defer func() { *maxNameLength = originalMaxNameLength }()
+	if _, err := sanitizePath(base, "long-name.txt"); !errors.Is(err, protocol.ErrPathComponentTooLong) {
+		t.Fatalf("expected ErrPathComponentTooLong with a lower limit, got: %v", err)
+	}
+}
+
+// TestSanitizeNames tests `handleConnection` to ensure that a file with an unsafe name is refused by default,
+// and stored under its percent-encoded name, reported in the response, with "-sanitize-names".
+func TestSanitizeNames(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("device")
+
+	for _, fileName := range []string{"aux.txt", "foo\nbar", "foo\tbar", "file. "} {
+		status, message := sendFile(t, dir, fileName, content)
+		if status != protocol.ResponseStatusError || !strings.Contains(message, protocol.ErrUnsafeFileName.Error()) {
+			t.Fatalf("expected %q to be refused as unsafe, got status %d: %s", fileName, status, message)
+		}
+	}
+
+	withFlags(t, map[string]string{"sanitize-names": "true"})
+	status, message, receipt := sendRequestReceipt(t, dir, fileHeader("sub/aux.txt", content), content)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected success with -sanitize-names, got status %d: %s", status, message)
+	}
+	if receipt == nil || receipt.Path != "sub/%61ux.txt" || receipt.BytesWritten != uint64(len(content)) ||
+		!bytes.Equal(receipt.Checksum, protocol.CalculateDataChecksum(content)) {
+		t.Fatalf("expected the receipt to report the stored name sub/%%61ux.txt, got %+v", receipt)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "sub", "%61ux.txt")); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected the file to be stored under its sanitized name, got %q (%v)", data, err)
+	}
+
+	status, message, receipt = sendRequestReceipt(t, dir, fileHeader("plain.txt", content), content)
+	if status != protocol.ResponseStatusSuccess || receipt == nil || receipt.Path != "plain.txt" {
+		t.Fatalf("expected a safe name to be stored as requested, got status %d: %s (%+v)", status, message, receipt)
+	}
+}
+
+// TestValidateHeaderNilHeader tests the `validateHeader` function to ensure that
+// it expectedly handles a nil header.
+func TestValidateHeaderNilHeader(t *testing.T) {
+	err := validateHeader(nil, "127.0.0.1:12345")
+	if err == nil {
+		t.Fatal("expected error for the nil header")
+	}
+}
+
+// TestValidateHeaderFileSizeExceeded tests the `validateHeader` function to ensure that
+// it expectedly handles a header with file size exceeding the maximum allowed.
+func TestValidateHeaderFileSizeExceeded(t *testing.T) {
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeFile,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(MaxFileSize) + 1,
+		FileName:     "large.txt",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, "127.0.0.1:12345")
+	if err == nil {
+		t.Fatal("expected error for the exceeded file size")
+	}
+}
+
+// TestValidateHeaderEmptyFileName tests the `validateHeader` function to ensure that
+// it expectedly handles a header with an empty file name.
+func TestValidateHeaderEmptyFileName(t *testing.T) {
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeFile,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     1024,
+		FileName:     "",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, "127.0.0.1:12345")
+	if err == nil {
+		t.Fatal("expected error for the empty file name")
+	}
+}
+
+// TestValidateHeaderSanitizeFailure tests the `validateHeader` function to ensure that
+// it expectedly handles a header with a file name that fails sanitization.
+func TestValidateHeaderSanitizeFailure(t *testing.T) {
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeFile,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     1024,
+		FileName:     "../secret.txt",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, "127.0.0.1:12345")
+	if err == nil {
+		t.Fatal("expected error for failing to sanitize the file name")
+	}
+}
+
+// TestValidateHeaderDirectorySizeValidation tests the `validateHeader` function to ensure that
+// it expectedly handles a directory header with size exceeding the maximum allowed.
+func TestValidateHeaderDirectorySizeValidation(t *testing.T) {
+	oldMaxDirSize := maxDirectorySize.Load()
+	defer func() {
+		maxDirectorySize.Store(oldMaxDirSize)
+	}()
+	maxDirectorySize.Store(100 * 1024 * 1024)
+
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeDirectory,
+		MessageType:  protocol.MessageTypeValidate,
+		FileSize:     200 * 1024 * 1024,
+		FileName:     "",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, "127.0.0.1:12345")
+	if err == nil {
+		t.Fatal("expected error for directory size exceeded")
+	}
+
+	header = &protocol.Header{
+		TransferType: protocol.TransferTypeDirectory,
+		MessageType:  protocol.MessageTypeValidate,
+		FileSize:     50 * 1024 * 1024,
+		FileName:     "",
+		Checksum:     make([]byte, 32),
+	}
+
+	err = validateHeader(header, "127.0.0.1:12345")
+	if err != nil {
+		t.Fatalf("unexpected error for valid directory size: %v", err)
+	}
+}
+
+// TestValidateHeaderDirectorySizeExceededOnTransfer tests the `validateHeader` function to ensure that
+// it expectedly rejects a directory transfer if the cumulative size would exceed the limit.
+func TestValidateHeaderDirectorySizeExceededOnTransfer(t *testing.T) {
+	oldMaxDirSize := maxDirectorySize.Load()
+	defer func() {
+		maxDirectorySize.Store(oldMaxDirSize)
+	}()
+	maxDirectorySize.Store(1000)
+
+	clientAddr := "127.0.0.1:12345"
+	dirSizeMutex.Lock()
+	directorySizes = make(map[string]uint64)
+	directorySizes[clientAddr] = 600
+	dirSizeMutex.Unlock()
+	defer func() {
+		dirSizeMutex.Lock()
+		delete(directorySizes, clientAddr)
+		dirSizeMutex.Unlock()
+	}()
+
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeDirectory,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     500, // 600 + 500 = 1100, which exceeds 1000.
+		FileName:     "file.txt",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, clientAddr)
+	if err == nil {
+		t.Fatal("expected error for the exceeded directory size on transfer")
+	}
+	if !strings.Contains(err.Error(), "would exceed the maximum allowed size") {
+		t.Fatalf("expected 'would exceed' error, got: %v", err)
+	}
+}
+
+// TestValidateHeaderDirectorySizeAcceptedOnTransfer tests the `validateHeader` function to ensure that
+// it expectedly accepts a directory transfer if the cumulative size is within the limit.
+func TestValidateHeaderDirectorySizeAcceptedOnTransfer(t *testing.T) {
+	oldMaxDirSize := maxDirectorySize.Load()
+	defer func() {
+		maxDirectorySize.Store(oldMaxDirSize)
+	}()
+	maxDirectorySize.Store(1000)
+
+	clientAddr := "127.0.0.1:12345"
+	dirSizeMutex.Lock()
+	directorySizes = make(map[string]uint64)
+	directorySizes[clientAddr] = 600
+	dirSizeMutex.Unlock()
+	defer func() {
+		dirSizeMutex.Lock()
+		delete(directorySizes, clientAddr)
+		dirSizeMutex.Unlock()
+	}()
+
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeDirectory,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     300, // 600 + 300 = 900, which is within 1000.
+		FileName:     "file.txt",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, clientAddr)
+	if err != nil {
+		t.Fatalf("unexpected error for a valid directory size on transfer: %v", err)
+	}
+}
+
+// TestValidateHeaderValidFile tests the `validateHeader` function to ensure that
+// it expectedly validates a correct file header.
+func TestValidateHeaderValidFile(t *testing.T) {
+	base := t.TempDir()
+	oldDestDir := *destDir
+	defer func() {
+		*destDir = oldDestDir
+	}()
+	*destDir = base
+
+	header := &protocol.Header{
+		TransferType: protocol.TransferTypeFile,
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     1024,
+		FileName:     "test.txt",
+		Checksum:     make([]byte, 32),
+	}
+
+	err := validateHeader(header, "127.0.0.1:12345")
+	if err != nil {
+		t.Fatalf("unexpected error for valid header: %v", err)
+	}
+}
+
+// TestGetDirectoryStatsNonEmpty tests the `getDirectoryStats` function to ensure that
+// it expectedly calculates the number of clients and total directory size.
+func TestGetDirectoryStatsNonEmpty(t *testing.T) {
+	dirSizeMutex.Lock()
+	directorySizes = make(map[string]uint64)
+	directorySizes["client1"] = 100
+	directorySizes["client2"] = 200
+	directorySizes["client3"] = 300
+	dirSizeMutex.Unlock()
+
+	numClients, totalSize := getDirectoryStats()
+
+	if numClients != 3 {
+		t.Fatalf("expected 3 clients, got %d", numClients)
+	}
+	if totalSize != 600 {
+		t.Fatalf("expected total size 600, got %d", totalSize)
+	}
+}
+
+// TestGetDirectoryStatsEmpty tests the `getDirectoryStats` function to ensure that
+// it expectedly handles an empty `directorySizes` map.
+func TestGetDirectoryStatsEmpty(t *testing.T) {
+	dirSizeMutex.Lock()
+	directorySizes = make(map[string]uint64)
+	dirSizeMutex.Unlock()
+
+	numClients, totalSize := getDirectoryStats()
+
+	if numClients != 0 {
+		t.Fatalf("expected 0 clients in total, got %d", numClients)
+	}
+	if totalSize != 0 {
+		t.Fatalf("expected the total size 0, got %d", totalSize)
+	}
+}
+
+// TestSendErrorResponseWriteFailure tests the `sendErrorResponse` function to ensure that
+// it expectedly logs an error when writing the response fails.
+func TestSendErrorResponseWriteFailure(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+
+	// Intentionally close the connections to cause a write failure.
+	if err := conn1.Close(); err != nil {
+		t.Fatalf("failed to close conn1: %v", err)
+	}
+	if err := conn2.Close(); err != nil {
+		t.Fatalf("failed to close conn2: %v", err)
+	}
+
+	logs := captureLogs(t)
+
+	sendErrorResponse(conn1, "test error")
+
+	entry := findLog(t, logs(), "Failed to send an error response to the client")
+	if entry["level"] != "WARN" || entry["error"] == nil {
+		t.Fatalf("expected a warning with the error, got: %v", entry)
+	}
+}
+
+// TestSendSuccessResponseWriteFailure tests the `sendSuccessResponse` function to ensure that
+// it expectedly logs an error when writing the response fails.
+func TestSendSuccessResponseWriteFailure(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+
+	// Intentionally close the connections to cause a write failure.
+	if err := conn1.Close(); err != nil {
+		t.Fatalf("failed to close `conn1`: %v", err)
+	}
+	if err := conn2.Close(); err != nil {
+		t.Fatalf("failed to close `conn2`: %v", err)
+	}
+
+	logs := captureLogs(t)
+
+	sendSuccessResponse(conn1, "test success")
+
+	entry := findLog(t, logs(), "Failed to send a success response to the client")
+	if entry["level"] != "WARN" || entry["error"] == nil {
+		t.Fatalf("expected a warning with the error, got: %v", entry)
+	}
+}
+
+// TestResolveFilePathNonExistent tests the `resolveFilePath` function to ensure that
+// it expectedly handles a non-existent file path.
+func TestResolveFilePathNonExistent(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "newfile.txt")
+
+	got, err := resolveFilePath(filePath, StrategyOverwrite, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != filePath {
+		t.Fatalf("expected %q, got %q", filePath, got)
+	}
+}
+
+// TestResolveFilePathOverwrite tests the `resolveFilePath` function to ensure that
+// it expectedly handles an existing file path with the overwrite strategy.
+func TestResolveFilePathOverwrite(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "existing.txt")
+
+	if err := os.WriteFile(filePath, []byte("old content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	got, err := resolveFilePath(filePath, StrategyOverwrite, time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != filePath {
+		t.Fatalf("expected %q, got %q", filePath, got)
+	}
+
+	if _, err := os.Stat(filePath); err == nil {
+		t.Fatal("file should have been removed")
+	}
+}
+
+// TestResolveFilePathRename tests the `resolveFilePath` function to ensure that
+// it expectedly handles an existing file path with the rename strategy.
+func TestResolveFilePathSkip(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "existing.txt")
+
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	_, err := resolveFilePath(filePath, StrategySkip, time.Time{})
+	if err == nil {
+		t.Fatal("expected error for the skip strategy on an existing file")
+	}
+}
+
+// TestResolveFilePathNewer tests the `resolveFilePath` function to ensure that
+// the "newer" strategy overwrites an existing file only with a strictly newer one.
+func TestResolveFilePathNewer(t *testing.T) {
+	existingTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		modTime     time.Time
+		overwritten bool
+	}{
+		{"newer wins", existingTime.Add(time.Nanosecond), true},
+		{"older skipped", existingTime.Add(-time.Hour), false},
+		{"equal skipped", existingTime, false},
+		{"unknown skipped", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "existing.txt")
+			if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+				t.Fatalf("failed to create test file: %v", err)
+			}
+			if err := os.Chtimes(filePath, existingTime, existingTime); err != nil {
+				t.Fatalf("failed to set the modification time: %v", err)
+			}
+
+			got, err := resolveFilePath(filePath, StrategyNewer, tt.modTime)
+			if tt.overwritten {
+				if err != nil || got != filePath {
+					t.Fatalf("expected %q, got %q and %v", filePath, got, err)
+				}
+				if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+					t.Fatal("expected the older file to be removed")
+				}
+				return
+			}
+			if !errors.Is(err, errNotNewer) || !errors.Is(err, errSkipExisting) {
+				t.Fatalf("expected the file to be skipped as not newer, got %v", err)
+			}
+			if _, err := os.Stat(filePath); err != nil {
+				t.Fatalf("expected the existing file to be kept, got %v", err)
+			}
+		})
+	}
+}
+
+// TestResolveFilePathUnknownStrategy tests the `resolveFilePath` function to ensure that
+// it expectedly handles an unknown strategy.
+func TestResolveFilePathUnknownStrategy(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "existing.txt")
+
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	_, err := resolveFilePath(filePath, "invalid-strategy", time.Time{})
+	if err == nil {
+		t.Fatal("expected error for an unknown strategy")
+	}
+	if !strings.Contains(err.Error(), "unknown file conflict-resolution strategy") {
+		t.Fatalf("expected 'unknown file conflict-resolution strategy' error, got: %v", err)
+	}
+}
+
+// TestValidateStrategy tests the `validateStrategy` function to ensure that
+// every strategy listed by "-list-strategies" is accepted, and that an unknown one is rejected.
+func TestValidateStrategy(t *testing.T) {
+	var listed strings.Builder
+	printStrategies(&listed)
+	lines := strings.Split(strings.TrimSuffix(listed.String(), "\n"), "\n")
+	if len(lines) != len(strategies) {
+		t.Fatalf("expected %d listed strategies, got %q", len(strategies), listed.String())
+	}
+	for _, line := range lines {
+		name, _, _ := strings.Cut(line, " ")
+		if err := validateStrategy(name); err != nil {
+			t.Errorf("expected the listed strategy %q to be valid, got %v", name, err)
+		}
+	}
+
+	for _, strategy := range []string{"", "invalid-strategy", "Overwrite"} {
+		if err := validateStrategy(strategy); err == nil {
+			t.Errorf("expected the strategy %q to be rejected", strategy)
+		}
+	}
+}
+
+// TestGenerateUniqueFile tests the `generateUniqueFile` function to ensure that
+// it expectedly generates a unique file name when a conflict exists.
+func TestGenerateUniqueFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalPath := filepath.Join(tmpDir, "file.txt")
+
+	f, finalPath, err := generateUniqueFile(originalPath, "file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Fatalf("failed to close file: %v", err)
+		}
+	}()
+
+	expectedPath := filepath.Join(tmpDir, "file_1.txt")
+	if finalPath != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, finalPath)
+	}
+
+	info, err := os.Stat(finalPath)
+	if err != nil {
+		t.Fatalf("file not created: %v", err)
+	}
+	if info.IsDir() {
+		t.Fatal("expected file, got directory")
+	}
+}
+
+// TestGenerateUniqueFileWithExisting tests the `generateUniqueFile` function to ensure that
+// it expectedly generates a unique file name when the original file already exists.
+func TestGenerateUniqueFileWithExisting(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalPath := filepath.Join(tmpDir, "file.txt")
+
+	if err := os.WriteFile(originalPath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	f, finalPath, err := generateUniqueFile(originalPath, "file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Fatalf("failed to close file: %v", err)
+		}
+	}()
+
+	expectedPath := filepath.Join(tmpDir, "file_1.txt")
+	if finalPath != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, finalPath)
+	}
+
+	if _, err := os.Stat(finalPath); err != nil {
+		t.Fatalf("renamed file not created: %v", err)
+	}
+}
+
+// TestGenerateUniqueFileMultipleConflicts tests the `generateUniqueFile` function to ensure that
+// it expectedly generates a unique file name when the original file already exists.
+func TestGenerateUniqueFileMultipleConflicts(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalPath := filepath.Join(tmpDir, "file.txt")
+
+	if err := os.WriteFile(originalPath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "file_1.txt"), []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	f, finalPath, err := generateUniqueFile(originalPath, "file.txt")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			t.Fatalf("failed to close file: %v", err)
+		}
+	}()
+
+	expectedPath := filepath.Join(tmpDir, "file_2.txt")
+	if finalPath != expectedPath {
+		t.Fatalf("expected %q, got %q", expectedPath, finalPath)
+	}
+}
+
+// TestCreateRenamedFileConcurrent tests the `createRenamedFile` function to ensure that
+// concurrent transfers of the same name each get a distinct file, so that none overwrites another.
+func TestCreateRenamedFileConcurrent(t *testing.T) {
+	tmpDir := t.TempDir()
+	originalPath := filepath.Join(tmpDir, "file.txt")
+
+	const workers = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, _, err := createRenamedFile(originalPath)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = fmt.Fprintf(f, "content %d", i)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files := storedFiles(t, tmpDir)
+	if len(files) != workers {
+		t.Fatalf("expected %d distinct files, got %d", workers, len(files))
+	}
+	contents := make(map[string]bool)
+	for _, content := range files {
+		contents[content] = true
+	}
+	for i := range workers {
+		if !contents[fmt.Sprintf("content %d", i)] {
+			t.Fatalf("expected the content of transfer %d to be stored, got %v", i, files)
+		}
+	}
+}
+
+// TestReadContextCanceled tests the `Read` method of the `contextReader` to ensure that
+// it respects context cancellation.
+func TestReadContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	// Intentionally cancel the context immediately.
+	cancel()
+
+	conn1, conn2 := net.Pipe()
+	defer func() {
+		if err := conn1.Close(); err != nil {
+			t.Fatalf("failed to close conn1: %v", err)
+		}
+		if err := conn2.Close(); err != nil {
+			t.Fatalf("failed to close conn2: %v", err)
+		}
+	}()
+
+	cr := &contextReader{
+		ctx:  ctx,
+		conn: conn1,
+	}
+
+	buf := make([]byte, 10)
+	n, err := cr.Read(buf)
+
+	if n != 0 {
+		t.Fatalf("expected 0 bytes read, got %d", n)
+	}
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled error, got %v", err)
+	}
+}
+
+// TestReadSuccess tests the `Read` method of the `contextReader` to ensure that
+// it expectedly reads data from the connection.
+func TestReadSuccess(t *testing.T) {
+	ctx := context.Background()
+
+	conn1, conn2 := net.Pipe()
+	defer func() {
+		if err := conn1.Close(); err != nil {
+			t.Fatalf("failed to close conn1: %v", err)
+		}
+		if err := conn2.Close(); err != nil {
+			t.Fatalf("failed to close conn2: %v", err)
+		}
+	}()
+
+	cr := &contextReader{
+		ctx:  ctx,
+		conn: conn1,
+	}
+
+	testData := []byte("test data")
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := conn2.Write(testData)
+		errChan <- err
+	}()
+
+	buf := make([]byte, 10)
+	n, err := cr.Read(buf)
+
+	if writeErr := <-errChan; writeErr != nil {
+		t.Fatalf("failed to write to conn2: %v", writeErr)
+	}
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != len(testData) {
+		t.Fatalf("expected %d bytes read, got %d", len(testData), n)
+	}
+	if !bytes.Equal(buf[:n], testData) {
+		t.Fatalf("expected %q, got %q", testData, buf[:n])
+	}
+}
+
+// TestReadContextDeadlineExceeded tests the `Read` method of the `contextReader` struct to ensure that
+// it expectedly respects context deadlines.
+func TestReadContextDeadlineExceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+
+	conn1, conn2 := net.Pipe()
+	defer func() {
+		if err := conn1.Close(); err != nil {
+			t.Fatalf("failed to close conn1: %v", err)
+		}
+		if err := conn2.Close(); err != nil {
+			t.Fatalf("failed to close conn2: %v", err)
+		}
+	}()
+
+	cr := &contextReader{
+		ctx:  ctx,
+		conn: conn1,
+	}
+
+	// Intentionally do not write any data to `conn2`.
+	buf := make([]byte, 10)
+	// Wait for the context deadline to exceed.
+	time.Sleep(40 * time.Millisecond)
+
+	n, err := cr.Read(buf)
+
+	if n != 0 {
+		t.Fatalf("expected 0 bytes read, got %d", n)
+	}
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded error, got %v", err)
+	}
+}
+
+// TestReadSetReadDeadlineFailure tests the `Read` method of the `contextReader` struct to ensure that
+// it expectedly handles `SetReadDeadline` failures.
+func TestReadSetReadDeadlineFailure(t *testing.T) {
+	ctx := context.Background()
+
+	conn1, conn2 := net.Pipe()
+	defer func() {
+		if err := conn1.Close(); err != nil {
+			t.Fatalf("failed to close conn1: %v", err)
+		}
+		if err := conn2.Close(); err != nil {
+			t.Fatalf("failed to close conn2: %v", err)
+		}
+	}()
+
+	cr := &contextReader{
+		ctx:  ctx,
+		conn: conn1,
+	}
+
+	// Intentionally close the connection to cause `SetReadDeadline` to fail.
+	if err := conn1.Close(); err != nil {
+		t.Fatalf("failed to close conn1: %v", err)
+	}
+
+	buf := make([]byte, 10)
+	// Since the connection is closed, `SetReadDeadline` should fail.
+	n, err := cr.Read(buf)
+
+	if n != 0 {
+		t.Fatalf("expected 0 bytes read, got %d", n)
+	}
+	if err == nil {
+		t.Fatal("expected error when SetReadDeadline fails on closed connection")
+	}
+}
+
+// generateTestCert generates a self-signed TLS certificate for testing.
+func generateTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	return generateTestCertFor(t, x509.ExtKeyUsageServerAuth, "localhost")
+}
+
+// generateTestCertFor generates a self-signed TLS certificate with the extended key usage and the common name for testing,
+// e.g. `x509.ExtKeyUsageClientAuth` for a client certificate, which can also serve as its own CA.
+func generateTestCertFor(t *testing.T, usage x509.ExtKeyUsage, commonName string) (certFile, keyFile string) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+	certFile = filepath.Join(tmpDir, "test.crt")
+	keyFile = filepath.Join(tmpDir, "test.key")
+
+	// Generates a 2048-bit RSA key.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the private key: %v", err)
+	}
+
+	// Create a certificate template.
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			Organization: []string{"Test"},
+			CommonName:   commonName,
+		},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:    []string{"localhost"},
+	}
+
+	// Create a self-signed certificate valid for 365 days.
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create the certificate: %v", err)
+	}
+
+	certOut, err := os.Create(certFile)
+	if err != nil {
+		t.Fatalf("failed to create the certificate file: %v", err)
+	}
+	defer func() {
+		if err := certOut.Close(); err != nil {
+			t.Fatalf("failed to close the certificate file: %v", err)
+		}
+	}()
+
+	// Encode and write the certificate to a PEM file.
+	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: certDER}); err != nil {
+		t.Fatalf("failed to write the certificate: %v", err)
+	}
+
+	keyOut, err := os.Create(keyFile)
+	if err != nil {
+		t.Fatalf("failed to create the key file: %v", err)
+	}
+	defer func() {
+		if err := keyOut.Close(); err != nil {
+			t.Fatalf("failed to close the key file: %v", err)
+		}
+	}()
+
+	// Encode and write the private key to a PEM file.
+	keyDER := x509.MarshalPKCS1PrivateKey(key)
+	if err := pem.Encode(keyOut, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: keyDER}); err != nil {
+		t.Fatalf("failed to write the key: %v", err)
+	}
+
+	return certFile, keyFile
+}
+
+// TestLoadTLSConfigNoCertificates tests that `loadTLSConfig` returns nil when no certificates are provided.
+func TestLoadTLSConfigNoCertificates(t *testing.T) {
+	oldCertFile := *tlsCertFile
+	oldKeyFile := *tlsKeyFile
+	defer func() {
+		*tlsCertFile = oldCertFile
+		*tlsKeyFile = oldKeyFile
+	}()
+
+	*tlsCertFile = ""
+	*tlsKeyFile = ""
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config != nil {
+		t.Fatal("expected nil config when no certificates are provided")
+	}
+}
+
+// TestLoadTLSConfigWithValidCertificates tests that `loadTLSConfig` expectedly loads valid certificates.
+func TestLoadTLSConfigWithValidCertificates(t *testing.T) {
+	oldCertFile := *tlsCertFile
+	oldKeyFile := *tlsKeyFile
+	defer func() {
+		*tlsCertFile = oldCertFile
+		*tlsKeyFile = oldKeyFile
+	}()
+
+	certFile, keyFile := generateTestCert(t)
+	*tlsCertFile = certFile
+	*tlsKeyFile = keyFile
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config == nil {
+		t.Fatal("expected non-nil config when certificates are provided")
+	}
+	if config.GetCertificate == nil {
+		t.Fatal("expected the certificate to be served through GetCertificate")
+	}
+	if cert, err := config.GetCertificate(&tls.ClientHelloInfo{}); err != nil || cert == nil {
+		t.Fatalf("expected the loaded certificate, got %v", err)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("expected TLS 1.2 minimum version, got %x", config.MinVersion)
+	}
+}
+
+// serveTLS serves `handleConnection` on a TLS listener with the configuration until the end of the test,
+// and returns the address of the listener.
+func serveTLS(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var wg sync.WaitGroup
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go handleConnection(context.Background(), conn, &wg)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	return listener.Addr().String()
+}
+
+// dialTLS connects to the TLS server at the address, presenting the client certificate if `certFile` is set.
+// The server certificate is not verified.
+func dialTLS(t *testing.T, addr, certFile, keyFile string) (*tls.Conn, error) {
+	t.Helper()
+
+	config := &tls.Config{InsecureSkipVerify: true}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatalf("failed to load the client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return tls.Dial("tcp", addr, config)
+}
+
+// TestMutualTLS tests `handleConnection` with "-require-client-cert" over a TLS listener to ensure that
+// a client presenting a certificate signed by "-client-ca" is served, and one with an untrusted certificate or none is rejected at the handshake.
+func TestMutualTLS(t *testing.T) {
+	certFile, keyFile := generateTestCert(t)
+	clientCert, clientKey := generateTestCertFor(t, x509.ExtKeyUsageClientAuth, "client")
+	untrustedCert, untrustedKey := generateTestCertFor(t, x509.ExtKeyUsageClientAuth, "client")
+	withFlags(t, map[string]string{"dir": t.TempDir(), "tls-cert": certFile, "tls-key": keyFile,
+		"require-client-cert": "true", "client-ca": clientCert})
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("failed to load the TLS configuration: %v", err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Fatal("expected client certificates to be required and verified")
+	}
+	addr := serveTLS(t, config)
+
+	// ping dials the server with the client certificate (if any) and returns the error of a ping.
+	ping := func(certFile, keyFile string) error {
+		conn, err := dialTLS(t, addr, certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		if err := protocol.WriteHeader(conn, &protocol.Header{
+			MessageType:  protocol.MessageTypePing,
+			Checksum:     make([]byte, protocol.ChecksumSize),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			return err
+		}
+		_, _, err = protocol.ReadResponse(conn)
+		return err
+	}
+
+	if err := ping(clientCert, clientKey); err != nil {
+		t.Fatalf("expected the trusted client to be served, got %v", err)
+	}
+	if err := ping(untrustedCert, untrustedKey); err == nil {
+		t.Fatal("expected the client with an untrusted certificate to be rejected")
+	}
+	if err := ping("", ""); err == nil {
+		t.Fatal("expected the client without a certificate to be rejected")
+	}
+}
+
+// TestLoadTLSConfigWithInvalidCertFile tests that `loadTLSConfig` returns an error for invalid certificate file paths.
+func TestLoadTLSConfigWithInvalidCertFile(t *testing.T) {
+	oldCertFile := *tlsCertFile
+	oldKeyFile := *tlsKeyFile
+	defer func() {
+		*tlsCertFile = oldCertFile
+		*tlsKeyFile = oldKeyFile
+	}()
+
+	*tlsCertFile = "/nonexistent/cert.crt"
+	*tlsKeyFile = "/nonexistent/key.key"
+
+	config, err := loadTLSConfig()
+	if err == nil {
+		t.Fatal("expected error for the invalid certificate file")
+	}
+	if config != nil {
+		t.Fatal("expected nil config on error")
+	}
+	if !strings.Contains(err.Error(), "failed to load the TLS certificate") {
+		t.Fatalf("expected 'failed to load the TLS certificate' in error, got: %v", err)
+	}
+}
+
+// TestLoadTLSConfigWithOnlyCertFile tests that `loadTLSConfig` returns nil when only a cert file is provided.
+func TestLoadTLSConfigWithOnlyCertFile(t *testing.T) {
+	oldCertFile := *tlsCertFile
+	oldKeyFile := *tlsKeyFile
+	defer func() {
+		*tlsCertFile = oldCertFile
+		*tlsKeyFile = oldKeyFile
+	}()
+
+	certFile, _ := generateTestCert(t)
+	*tlsCertFile = certFile
+	*tlsKeyFile = ""
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config != nil {
+		t.Fatal("expected nil config when only the cert file is provided")
+	}
+}
+
+// TestLoadTLSConfigWithOnlyKeyFile tests that `loadTLSConfig` returns nil when only a key file is provided.
+func TestLoadTLSConfigWithOnlyKeyFile(t *testing.T) {
+	oldCertFile := *tlsCertFile
+	oldKeyFile := *tlsKeyFile
+	defer func() {
+		*tlsCertFile = oldCertFile
+		*tlsKeyFile = oldKeyFile
+	}()
+
+	_, keyFile := generateTestCert(t)
+	*tlsCertFile = ""
+	*tlsKeyFile = keyFile
+
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config != nil {
+		t.Fatal("expected nil config when only the key file is provided")
+	}
+}
+
+// sendVerifyRequest runs `handleConnection` on one end of a pipe, sends a verification request
+// for the given file name and content, and returns the server's response.
+func sendVerifyRequest(t *testing.T, dir, fileName string, content []byte) (uint8, string) {
+	t.Helper()
+
+	originalDestDir := *destDir
+	*destDir = dir
+	defer func() { *destDir = originalDestDir }()
+
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go handleConnection(context.Background(), serverConn, &wg)
+
+	header := &protocol.Header{
+		MessageType:  protocol.MessageTypeVerify,
+		FileSize:     uint64(len(content)),
+		FileName:     fileName,
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+	}
+	// Encode the header up front, since `net.Pipe` blocks on the zero-length write of an empty directory path.
+	var headerBuf bytes.Buffer
+	if err := protocol.WriteHeader(&headerBuf, header); err != nil {
+		t.Fatalf("failed to encode the verification header: %v", err)
+	}
+	if _, err := clientConn.Write(headerBuf.Bytes()); err != nil {
+		t.Fatalf("failed to send the verification header: %v", err)
+	}
+
+	status, message, err := protocol.ReadResponse(clientConn)
+	if err != nil {
+		t.Fatalf("failed to read the verification response: %v", err)
+	}
+
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	wg.Wait()
+
+	return status, message
+}
+
+// TestHandleVerifyRequestMatch tests the verification request handling to ensure that
+// a file whose content matches the expected checksum is reported as verified.
+func TestHandleVerifyRequestMatch(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("transferred content")
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create the directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), content, 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+
+	status, message := sendVerifyRequest(t, dir, filepath.Join("sub", "file.txt"), content)
+	if status != protocol.ResponseStatusSuccess || message != protocol.VerifyMessageMatch {
+		t.Fatalf("expected a successful %q response, got status %d with %q", protocol.VerifyMessageMatch, status, message)
+	}
+}
+
+// TestHandleVerifyRequestMismatch tests the verification request handling to ensure that
+// a corrupted server file (of the same size) is reported as a checksum mismatch.
+func TestHandleVerifyRequestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("transferred content")
+	corrupted := []byte("transferred c0ntent")
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), corrupted, 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+
+	status, message := sendVerifyRequest(t, dir, "file.txt", content)
+	if status != protocol.ResponseStatusError || message != protocol.VerifyMessageMismatch {
+		t.Fatalf("expected an error %q response, got status %d with %q", protocol.VerifyMessageMismatch, status, message)
+	}
+}
+
+// TestHandleVerifyRequestNotFound tests the verification request handling to ensure that
+// a file missing on the server is reported as not found.
+func TestHandleVerifyRequestNotFound(t *testing.T) {
+	status, message := sendVerifyRequest(t, t.TempDir(), "missing.txt", []byte("content"))
+	if status != protocol.ResponseStatusError || message != protocol.VerifyMessageNotFound {
+		t.Fatalf("expected an error %q response, got status %d with %q", protocol.VerifyMessageNotFound, status, message)
+	}
+}
+
+// TestValidateFlags tests `validateFlags` to ensure that
+// every flag rule rejects its violation and accepts a valid combination.
+func TestValidateFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   map[string]string
+		wantErr string
+	}{
+		{"defaults", map[string]string{}, ""},
+		{"non-numeric port", map[string]string{"port": "http"}, "-port"},
+		{"port out of range", map[string]string{"port": "70000"}, "-port"},
+		{"ephemeral port", map[string]string{"port": "0"}, ""},
+		{"invalid strategy", map[string]string{"strategy": "merge"}, "-strategy"},
+		{"skip strategy", map[string]string{"strategy": StrategySkip}, ""},
+		{"newer strategy", map[string]string{"strategy": StrategyNewer}, ""},
+		{"zero directory size", map[string]string{"max-dir-size": "0"}, "-max-dir-size"},
+		{"certificate without key", map[string]string{"tls-cert": "cert.pem"}, "-tls-cert, -tls-key"},
+		{"key without certificate", map[string]string{"tls-key": "key.pem"}, "-tls-cert, -tls-key"},
+		{"certificate and key", map[string]string{"tls-cert": "cert.pem", "tls-key": "key.pem"}, ""},
+		{"zero buffer size", map[string]string{"buffer-size": "0"}, "-buffer-size"},
+		{"buffer size too large", map[string]string{"buffer-size": "67108865"}, "-buffer-size"},
+		{"maximum buffer size", map[string]string{"buffer-size": "67108864"}, ""},
+		{"invalid progress mode", map[string]string{"progress": "fancy"}, "-progress"},
+		{"plain progress mode", map[string]string{"progress": "plain"}, ""},
+		{"zero name length", map[string]string{"max-name-length": "0"}, "-max-name-length"},
+		{"lower name length", map[string]string{"max-name-length": "143"}, ""},
+		{"invalid case mode", map[string]string{"case-insensitive": "maybe"}, "-case-insensitive"},
+		{"forced case mode", map[string]string{"case-insensitive": "true"}, ""},
+		{"negative trailing data wait", map[string]string{"trailing-data-wait": "-1ms"}, "-trailing-data-wait"},
+		{"disabled trailing data check", map[string]string{"trailing-data-wait": "0"}, ""},
+		{"IPv4 bind address", map[string]string{"bind": "127.0.0.1"}, ""},
+		{"IPv6 bind address", map[string]string{"bind": "::1"}, ""},
+		{"bracketed IPv6 bind address", map[string]string{"bind": "[::1]"}, ""},
+		{"bind address with port", map[string]string{"bind": "[::1]:8080"}, "-bind"},
+		{"invalid IPv6 bind address", map[string]string{"bind": "::1::2"}, "-bind"},
+		{"blank hook command", map[string]string{"on-complete": "  "}, "-on-complete"},
+		{"hook command", map[string]string{"on-complete": "gzip -k {path}"}, ""},
+		{"reject pattern", map[string]string{"reject-pattern": "uploads/**/*.sh", "reject-pattern-nocase": "true"}, ""},
+		{"malformed reject pattern", map[string]string{"reject-pattern": "[a-"}, "-reject-pattern"},
+		{"empty allow pattern", map[string]string{"allow-pattern": "/"}, "-allow-pattern"},
+		{"keep-alive disabled", map[string]string{"tcp-keepalive": "0", "tcp-nodelay": "false"}, ""},
+		{"negative keep-alive", map[string]string{"tcp-keepalive": "-1s"}, "-tcp-keepalive"},
+		{"quarantine", map[string]string{"dir": "received", "quarantine-dir": "quarantine", "quarantine-clean": "true"}, ""},
+		{"quarantine within destination", map[string]string{"dir": "received", "quarantine-dir": "received/quarantine"}, "-quarantine-dir"},
+		{"quarantine clean without quarantine", map[string]string{"quarantine-clean": "true"}, "-quarantine-max-age"},
+		{"zero quarantine age", map[string]string{"quarantine-max-age": "0s"}, "-quarantine-max-age"},
+		{"fsync quarantine", map[string]string{"dir": "received", "quarantine-dir": "quarantine", "fsync-quarantine": "true"}, ""},
+		{"fsync quarantine without quarantine", map[string]string{"fsync-quarantine": "true", "fsync": "true"}, "-fsync-quarantine"},
+		{"invalid cleanup mode", map[string]string{"cleanup-mode": "shred"}, "-cleanup-mode"},
+		{"cleanup age below range timeout", map[string]string{"cleanup-max-age": "1m", "range-timeout": "10m"}, "-cleanup-max-age"},
+		{"negative cleanup interval", map[string]string{"cleanup-interval": "-1h"}, "-cleanup-interval"},
+		{"unknown template placeholder", map[string]string{"dest-template": "{date}/{user}"}, "-dest-template"},
+		{"template traversal", map[string]string{"dest-template": "{date}/../.."}, "-dest-template"},
+		{"absolute template", map[string]string{"dest-template": "/srv/{date}"}, "-dest-template"},
+		{"webhook", map[string]string{"webhook-url": "https://example.com/hook", "webhook-secret": "s"}, ""},
+		{"webhook without scheme", map[string]string{"webhook-url": "example.com/hook"}, "-webhook-url"},
+		{"webhook secret without URL", map[string]string{"webhook-secret": "s"}, "-webhook-url"},
+		{"webhook secret and secret file", map[string]string{"webhook-url": "https://example.com/hook", "webhook-secret": "s", "webhook-secret-file": "secret"}, "-webhook-secret-file"},
+		{"webhook secret file without URL", map[string]string{"webhook-secret-file": "secret"}, "-webhook-url"},
+		{"zero webhook timeout", map[string]string{"webhook-timeout": "0s"}, "-webhook-timeout"},
+		{"negative webhook retries", map[string]string{"webhook-retries": "-1"}, "-webhook-timeout"},
+		{"JSON log format", map[string]string{"log-format": "json", "log-level": "warn"}, ""},
+		{"invalid log format", map[string]string{"log-format": "xml"}, "-log-format"},
+		{"invalid log level", map[string]string{"log-level": "verbose"}, "-log-level"},
+		{"metrics address", map[string]string{"metrics-addr": ":9090"}, ""},
+		{"metrics address without port", map[string]string{"metrics-addr": "localhost"}, "-metrics-addr"},
+		{"loopback debug address", map[string]string{"debug-addr": "127.0.0.1:6060"}, ""},
+		{"remote debug address", map[string]string{"debug-addr": ":6060"}, "-debug-addr"},
+		{"allowed remote debug address", map[string]string{"debug-addr": "0.0.0.0:6060", "debug-allow-remote": "true"}, ""},
+		{"debug address without port", map[string]string{"debug-addr": "localhost"}, "-debug-addr"},
+		{"zero access log size", map[string]string{"access-log": "access.log", "access-log-max-size": "0"}, "-access-log-max-size"},
+		{"audit log same as access log", map[string]string{"access-log": "logs/transfers.log", "audit-log": "logs/../logs/transfers.log"}, "-audit-log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, tt.flags)
+
+			err := validateFlags()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error containing %q, got nil", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), "fix:") {
+				t.Fatalf("expected an error containing %q and a fix, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// sendRequest runs `handleConnection` on one end of a pipe, sends the header followed by the body,
+// and returns the server's response.
+func sendRequest(t testing.TB, dir string, header *protocol.Header, body []byte) (uint8, string) {
+	t.Helper()
+
+	status, message, _ := sendRequestReceipt(t, dir, header, body)
+	return status, message
+}
+
+// sendRequestReceipt sends a request like `sendRequest`, and also returns the receipt of the server's response, if any.
+func sendRequestReceipt(t testing.TB, dir string, header *protocol.Header, body []byte) (uint8, string, *protocol.Receipt) {
+	t.Helper()
+
+	// Encode the header up front, since `net.Pipe` blocks on the zero-length write of an empty directory path.
+	var buf bytes.Buffer
+	if err := protocol.WriteHeader(&buf, header); err != nil {
+		t.Fatalf("failed to encode the header: %v", err)
+	}
+	buf.Write(body)
+
+	return sendRawReceipt(t, dir, buf.Bytes())
+}
+
+// sendRaw runs `handleConnection` on one end of a pipe, sends the raw bytes of a request,
+// and returns the server's response.
+func sendRaw(t testing.TB, dir string, data []byte) (uint8, string) {
+	t.Helper()
+
+	status, message, _ := sendRawReceipt(t, dir, data)
+	return status, message
+}
+
+// sendRawReceipt sends the raw bytes of a request like `sendRaw`, and also returns the receipt of the server's response, if any.
+func sendRawReceipt(t testing.TB, dir string, data []byte) (uint8, string, *protocol.Receipt) {
+	t.Helper()
+
+	originalDestDir := *destDir
+	*destDir = dir
+	defer func() { *destDir = originalDestDir }()
+
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go handleConnection(context.Background(), serverConn, &wg)
+
+	// Write in the background, since the server may stop reading and respond before the whole body is consumed.
+	go func() {
+		_, _ = clientConn.Write(data)
+	}()
+
+	status, _, message, receipt, err := protocol.ReadReceiptResponse(clientConn)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	wg.Wait()
+
+	return status, message, receipt
+}
+
+// sendStream sends a streamed transfer of the given encoded stream and returns the server's response.
+func sendStream(t *testing.T, dir, fileName string, stream []byte) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileName:     fileName,
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeStream,
+	}, stream)
+}
+
+// sendFile sends a single file transfer of the given content and returns the server's response.
+func sendFile(t testing.TB, dir, fileName string, content []byte) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, fileHeader(fileName, content), content)
+}
+
+// fileHeader returns the header of a single file transfer of the given content.
+func fileHeader(fileName string, content []byte) *protocol.Header {
+	return &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     fileName,
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+	}
+}
+
+// encodeStream encodes the content as a stream, optionally corrupting its first byte after the checksum is calculated.
+func encodeStream(t *testing.T, content []byte, corrupt bool) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := protocol.NewStreamWriter(&buf)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("failed to write the stream: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the stream: %v", err)
+	}
+
+	stream := buf.Bytes()
+	if corrupt {
+		stream[4] ^= 0xFF // First byte of the chunk data, right after the chunk length.
+	}
+	return stream
+}
+
+// TestHandleConnectionStream tests the handling of a streamed transfer of unknown size to ensure that
+// the content is stored once its trailing checksum is verified.
+func TestHandleConnectionStream(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("pg_dump output\n"), 100000)
+
+	status, message := sendStream(t, dir, "mydb.sql", encodeStream(t, content, false))
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "mydb.sql"))
+	if err != nil {
+		t.Fatalf("failed to read the received file: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("expected %d bytes to be received, got %d", len(content), len(got))
+	}
+}
+
+// TestHandleConnectionStreamCorrupted tests the handling of a streamed transfer to ensure that
+// corrupted content is rejected and not kept on disk.
+func TestHandleConnectionStreamCorrupted(t *testing.T) {
+	dir := t.TempDir()
+
+	status, message := sendStream(t, dir, "mydb.sql", encodeStream(t, []byte("original"), true))
+	if status != protocol.ResponseStatusError || message != "Data integrity check failed" {
+		t.Fatalf("expected a data integrity error, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mydb.sql")); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupted file to be removed, got: %v", err)
+	}
+}
+
+// TestHandleConnectionBlockChecksums tests the handling of a transfer with block checksums to ensure that
+// a corrupted block is reported by its index, the file is not kept on disk, and the connection carries the next file.
+func TestHandleConnectionBlockChecksums(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+
+	content := bytes.Repeat([]byte("block content\n"), protocol.MinBlockSize/2) // 7 blocks.
+	checksums, err := protocol.CalculateBlockChecksums(context.Background(), bytes.NewReader(content), protocol.MinBlockSize)
+	if err != nil {
+		t.Fatalf("failed to calculate the block checksums: %v", err)
+	}
+	header := &protocol.Header{
+		MessageType:    protocol.MessageTypeTransfer,
+		FileSize:       uint64(len(content)),
+		FileName:       "corrupted.bin",
+		Checksum:       protocol.CalculateDataChecksum(content),
+		TransferType:   protocol.TransferTypeFile,
+		BlockSize:      protocol.MinBlockSize,
+		BlockChecksums: checksums,
+	}
+	var request bytes.Buffer
+	if err := protocol.WriteHeader(&request, header); err != nil {
+		t.Fatalf("failed to encode the header: %v", err)
+	}
+	corrupted := bytes.Clone(content)
+	corrupted[5*protocol.MinBlockSize+42] ^= 0xFF
+	request.Write(corrupted)
+	header.FileName = "intact.bin"
+	if err := protocol.WriteHeader(&request, header); err != nil {
+		t.Fatalf("failed to encode the header: %v", err)
+	}
+	request.Write(content)
+
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go handleConnection(context.Background(), serverConn, &wg)
+	go func() {
+		_, _ = clientConn.Write(request.Bytes())
+	}()
+
+	status, message, err := protocol.ReadResponse(clientConn)
+	if err != nil || status != protocol.ResponseStatusError || message != protocol.BlockMismatchMessage(5) {
+		t.Fatalf("expected the corrupted block 5 to be reported, got %d: %q (%v)", status, message, err)
+	}
+	status, message, err = protocol.ReadResponse(clientConn)
+	if err != nil || status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the next file to be received on the same connection, got %d: %q (%v)", status, message, err)
+	}
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	wg.Wait()
+
+	if _, err := os.Stat(filepath.Join(dir, "corrupted.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupted file to be removed, got: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "intact.bin")); err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected intact.bin to be received, got %d bytes and %v", len(got), err)
+	}
+}
+
+// TestHandleConnectionLogAttributes tests the log messages of a transfer to ensure that
+// they carry the client address, the transfer identifier, and the details of the file.
+func TestHandleConnectionLogAttributes(t *testing.T) {
+	logs := captureLogs(t)
+	content := []byte("hello, world")
+
+	status, message := sendFile(t, t.TempDir(), "hello.txt", content)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	entries := logs()
+	received := findLog(t, entries, "Receiving a file")
+	completed := findLog(t, entries, "Transfer completed")
+	if completed["client_addr"] == nil || completed["client_addr"] != received["client_addr"] {
+		t.Fatalf("expected the client address on every message, got %v and %v", received, completed)
+	}
+	if completed["transfer_id"] == nil || completed["transfer_id"] != received["transfer_id"] {
+		t.Fatalf("expected the same transfer identifier on every message of the transfer, got %v and %v", received, completed)
+	}
+	if completed["file_name"] != "hello.txt" || completed["bytes"] != float64(len(content)) {
+		t.Fatalf("expected the file name and size, got %v", completed)
+	}
+	if _, ok := completed["duration_ms"].(float64); !ok {
+		t.Fatalf("expected the duration in milliseconds, got %v", completed)
+	}
+}
+
+// BenchmarkCopyBufferOverPipe compares the throughput of `io.CopyBuffer` over a `net.Pipe`
+// with the default `io.Copy` buffer size (32KB) and the default transfer buffer size (256KB).
+func BenchmarkCopyBufferOverPipe(b *testing.B) {
+	const payloadSize = 16 * 1024 * 1024
+	payload := make([]byte, payloadSize)
+
+	for _, size := range []int{32 * 1024, TransferBufferSize} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			buffer := make([]byte, size)
+			// Hide `io.Discard`'s `ReaderFrom`, which would bypass the buffer under test.
+			sink := struct{ io.Writer }{io.Discard}
+			b.SetBytes(payloadSize)
+			for i := 0; i < b.N; i++ {
+				serverConn, clientConn := net.Pipe()
+				go func() {
+					_, _ = clientConn.Write(payload)
+					_ = clientConn.Close()
+				}()
+				if _, err := io.CopyBuffer(sink, serverConn, buffer); err != nil {
+					b.Fatalf("failed to copy: %v", err)
+				}
+				_ = serverConn.Close()
+			}
+		})
+	}
+}
+
+// enableDedup enables the "-dedup" mode with an empty index for the duration of the test.
+func enableDedup(t *testing.T) {
+	t.Helper()
+
+	originalDedup := *dedup
+	*dedup = true
+	dedupMutex.Lock()
+	originalIndex := dedupIndex
+	dedupIndex = make(map[string]dedupEntry)
+	dedupMutex.Unlock()
+
+	t.Cleanup(func() {
+		*dedup = originalDedup
+		dedupMutex.Lock()
+		dedupIndex = originalIndex
+		dedupMutex.Unlock()
+	})
+}
+
+// TestDedupHardLinksIdenticalContent tests the "-dedup" mode to ensure that
+// identical content uploaded twice under different names is stored once, with both paths sharing an inode.
+func TestDedupHardLinksIdenticalContent(t *testing.T) {
+	enableDedup(t)
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("same content "), 1000)
+
+	for _, name := range []string{"first.bin", "second.bin"} {
+		if status, message := sendFile(t, dir, name, content); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response for %s, got %d: %s", name, status, message)
+		}
+	}
+
+	first, err := os.Stat(filepath.Join(dir, "first.bin"))
+	if err != nil {
+		t.Fatalf("failed to stat the first file: %v", err)
+	}
+	second, err := os.Stat(filepath.Join(dir, "second.bin"))
+	if err != nil {
+		t.Fatalf("failed to stat the second file: %v", err)
+	}
+	if !os.SameFile(first, second) {
+		t.Fatal("expected both paths to share an inode")
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "second.bin"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the linked file to have the uploaded content, got %d bytes and %v", len(got), err)
+	}
+}
+
+// TestDedupSkipsModifiedFile tests the "-dedup" mode to ensure that
+// a stored file modified since it was indexed is not linked to, and the content is written normally.
+func TestDedupSkipsModifiedFile(t *testing.T) {
+	enableDedup(t)
+	dir := t.TempDir()
+	content := []byte("original content")
+
+	if status, message := sendFile(t, dir, "first.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "first.txt"), []byte("modified after the upload"), 0644); err != nil {
+		t.Fatalf("failed to modify the stored file: %v", err)
+	}
+
+	if status, message := sendFile(t, dir, "second.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "second.txt"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the second file to be written normally, got %q and %v", got, err)
+	}
+	first, _ := os.Stat(filepath.Join(dir, "first.txt"))
+	second, _ := os.Stat(filepath.Join(dir, "second.txt"))
+	if os.SameFile(first, second) {
+		t.Fatal("expected the modified file not to be linked to")
+	}
+}
+
+// TestLinkDuplicateFallsBackToCopy tests `linkDuplicate` to ensure that
+// the content is copied when a hard link cannot be created.
+func TestLinkDuplicateFallsBackToCopy(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.txt")
+	if err := os.WriteFile(existing, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+
+	// Hard links to directories are not permitted, so linking the directory fails and copying it fails too.
+	if err := linkDuplicate(dir, filepath.Join(dir, "dir-link"), make([]byte, 1024)); err == nil {
+		t.Fatal("expected an error when neither linking nor copying is possible, got nil")
+	}
+
+	path := filepath.Join(dir, "copy.txt")
+	if err := linkDuplicate(existing, path, make([]byte, 1024)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got, err := os.ReadFile(path); err != nil || string(got) != "content" {
+		t.Fatalf("expected the linked content, got %q and %v", got, err)
+	}
+}
+
+// sendDirectoryFile sends a file of a directory transfer with the given relative path and content,
+// and returns the server's response.
+func sendDirectoryFile(t *testing.T, dir, relPath string, content []byte) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     relPath,
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeDirectory,
+	}, content)
+}
+
+// TestCheckFileNamePatterns tests `checkFileNamePatterns` to ensure that
+// names matching a reject pattern, or no allow pattern, are refused with the rule that refused them.
+func TestCheckFileNamePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		reject   []string
+		allow    []string
+		nocase   bool
+		fileName string
+		wantRule string
+	}{
+		{"no patterns", nil, nil, false, "run.exe", ""},
+		{"rejected extension", []string{"*.php", "*.exe"}, nil, false, "run.exe", "-reject-pattern *.exe"},
+		{"rejected extension in a subdirectory", []string{"*.exe"}, nil, false, "dir/sub/run.exe", "-reject-pattern *.exe"},
+		{"other extension", []string{"*.exe"}, nil, false, "run.txt", ""},
+		{"nested path", []string{"uploads/**/*.sh"}, nil, false, "uploads/a/b/run.sh", "-reject-pattern uploads/**/*.sh"},
+		{"nested path at the top", []string{"uploads/**/*.sh"}, nil, false, "uploads/run.sh", "-reject-pattern uploads/**/*.sh"},
+		{"nested path elsewhere", []string{"uploads/**/*.sh"}, nil, false, "scripts/run.sh", ""},
+		{"backslashes", []string{"uploads/**/*.sh"}, nil, false, `uploads\bin\run.sh`, "-reject-pattern uploads/**/*.sh"},
+		{"case-sensitive", []string{"*.exe"}, nil, false, "RUN.EXE", ""},
+		{"case-insensitive", []string{"*.exe"}, nil, true, "RUN.EXE", "-reject-pattern *.exe"},
+		{"allowed", nil, []string{"*.txt", "*.csv"}, false, "data/a.csv", ""},
+		{"not allowed", nil, []string{"*.txt"}, false, "a.bin", "-allow-pattern"},
+		{"rejected before allowed", []string{"secret*"}, []string{"*.txt"}, false, "secret.txt", "-reject-pattern secret*"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, map[string]string{"reject-pattern-nocase": strconv.FormatBool(tt.nocase)})
+			*rejectPatterns, *allowPatterns = tt.reject, tt.allow
+
+			err := checkFileNamePatterns(tt.fileName)
+			if tt.wantRule == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got: %v", err)
+				}
+				return
+			}
+			var patternErr *fileNamePatternError
+			if !errors.As(err, &patternErr) || !errors.Is(err, ErrRejectedFileName) || !strings.HasPrefix(patternErr.rule, tt.wantRule) {
+				t.Fatalf("expected a refusal by %q, got: %v", tt.wantRule, err)
+			}
+		})
+	}
+}
+
+// TestRejectPatternRefusesDirectoryFile tests the "-reject-pattern" flag to ensure that
+// each file of a directory transfer is checked before its content is read, and nothing is stored.
+func TestRejectPatternRefusesDirectoryFile(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"reject-pattern": "*.tmp"})
+
+	if status, message := sendDirectoryFile(t, dir, "project/keep.txt", []byte("kept")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	status, message := sendDirectoryFile(t, dir, "project/cache/build.tmp", []byte("refused"))
+	if status != protocol.ResponseStatusError || !strings.Contains(message, "-reject-pattern *.tmp") {
+		t.Fatalf("expected the file to be refused by the pattern, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "project", "cache", "build.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("expected the refused file not to be stored, got %v", err)
+	}
+}
+
+// TestNormalizeUnicodeWithRenameStrategy tests the "-normalize-unicode" mode to ensure that
+// a name sent in NFD (as by macOS) collides with the same name in NFC, so that the rename strategy applies,
+// that both are stored in the form of "-normalize-form", and that the names are stored byte for byte as sent without the mode.
+func TestNormalizeUnicodeWithRenameStrategy(t *testing.T) {
+	const nfc, nfd = "caf\u00e9", "cafe\u0301"
+	files := []struct {
+		relPath string
+		content string
+	}{
+		{nfc + "/" + nfc + ".txt", "composed"},
+		{nfd + "/" + nfd + ".txt", "decomposed"},
+	}
+
+	tests := []struct {
+		name      string
+		normalize string
+		form      string
+		expected  map[string]string
+	}{
+		{"normalized to NFC", "true", NormalizeFormNFC, map[string]string{
+			nfc + "/" + nfc + ".txt":   "composed",
+			nfc + "/" + nfc + "_1.txt": "decomposed",
+		}},
+		{"normalized to NFD", "true", NormalizeFormNFD, map[string]string{
+			nfd + "/" + nfd + ".txt":   "composed",
+			nfd + "/" + nfd + "_1.txt": "decomposed",
+		}},
+		{"byte for byte", "false", NormalizeFormNFD, map[string]string{
+			nfc + "/" + nfc + ".txt": "composed",
+			nfd + "/" + nfd + ".txt": "decomposed",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, map[string]string{"normalize-unicode": tt.normalize, "normalize-form": tt.form, "strategy": StrategyRename})
+			dir := t.TempDir()
+			for _, file := range files {
+				if status, message := sendDirectoryFile(t, dir, file.relPath, []byte(file.content)); status != protocol.ResponseStatusSuccess {
+					t.Fatalf("expected a success response for %q, got %d: %s", file.relPath, status, message)
+				}
+			}
+
+			stored := map[string]string{}
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				content, err := os.ReadFile(path)
+				relPath, _ := filepath.Rel(dir, path)
+				stored[filepath.ToSlash(relPath)] = string(content)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("failed to walk the destination directory: %v", err)
+			}
+			if !reflect.DeepEqual(stored, tt.expected) {
+				t.Fatalf("expected the stored files %q, got %q", tt.expected, stored)
+			}
+		})
+	}
+}
+
+// TestFlattenWithRenameStrategy tests the "-flatten" mode to ensure that
+// files from different subdirectories land directly in the destination directory, with collisions renamed.
+func TestFlattenWithRenameStrategy(t *testing.T) {
+	originalFlatten, originalStrategy := *flatten, *fileStrategy
+	*flatten, *fileStrategy = true, StrategyRename
+	defer func() { *flatten, *fileStrategy = originalFlatten, originalStrategy }()
+
+	dir := t.TempDir()
+	files := []struct {
+		relPath  string
+		content  string
+		expected string
+	}{
+		{"a/x.txt", "from a", "x.txt"},
+		{"b/x.txt", "from b", "x_1.txt"},
+	}
+	for _, file := range files {
+		if status, message := sendDirectoryFile(t, dir, file.relPath, []byte(file.content)); status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response for %s, got %d: %s", file.relPath, status, message)
+		}
+	}
+
+	for _, file := range files {
+		got, err := os.ReadFile(filepath.Join(dir, file.expected))
+		if err != nil || string(got) != file.content {
+			t.Fatalf("expected %s to contain %q, got %q and %v", file.expected, file.content, got, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read the destination directory: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			t.Fatalf("expected no subdirectories to be created, found %s", entry.Name())
+		}
+	}
+}
+
+// TestFlattenHeader tests `flattenHeader` to ensure that
+// only the files of directory transfers and verifications are reduced to their base names.
+func TestFlattenHeader(t *testing.T) {
+	tests := []struct {
+		header   protocol.Header
+		expected string
+	}{
+		{protocol.Header{MessageType: protocol.MessageTypeTransfer, TransferType: protocol.TransferTypeDirectory, FileName: "a/b/x.txt"}, "x.txt"},
+		{protocol.Header{MessageType: protocol.MessageTypeVerify, TransferType: protocol.TransferTypeFile, FileName: "a/x.txt"}, "x.txt"},
+		{protocol.Header{MessageType: protocol.MessageTypeValidate, TransferType: protocol.TransferTypeDirectory, FileName: "project"}, "project"},
+		{protocol.Header{MessageType: protocol.MessageTypeTransfer, TransferType: protocol.TransferTypeFile, FileName: "x.txt"}, "x.txt"},
+	}
+	for _, tt := range tests {
+		header := tt.header
+		flattenHeader(&header)
+		if header.FileName != tt.expected {
+			t.Errorf("flattenHeader(%q) = %q; want %q", tt.header.FileName, header.FileName, tt.expected)
+		}
+	}
+}
+
+// TestTransferIntoDirectoryPath tests `handleConnection` to ensure that
+// a file is stored under the directory path of its header, and a path escaping the destination directory is rejected.
+func TestTransferIntoDirectoryPath(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("dump")
+	header := &protocol.Header{
+		MessageType:   protocol.MessageTypeTransfer,
+		FileSize:      uint64(len(content)),
+		FileName:      "db.sql",
+		Checksum:      protocol.CalculateDataChecksum(content),
+		TransferType:  protocol.TransferTypeFile,
+		DirectoryPath: "backups/2024",
+	}
+	if status, message := sendRequest(t, dir, header, content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "backups", "2024", "db.sql")); err != nil || !bytes.Equal(data, content) {
+		t.Fatalf("expected the file under backups/2024, got %q: %v", data, err)
+	}
+
+	header.MessageType = protocol.MessageTypeVerify
+	if status, message := sendRequest(t, dir, header, nil); status != protocol.ResponseStatusSuccess || message != protocol.VerifyMessageMatch {
+		t.Fatalf("expected the file to be verified under backups/2024, got %d: %s", status, message)
+	}
+
+	header.MessageType = protocol.MessageTypeTransfer
+	header.DirectoryPath = "../escape"
+	if status, message := sendRequest(t, dir, header, content); status != protocol.ResponseStatusError || !strings.Contains(message, "invalid directory path") {
+		t.Fatalf("expected an invalid directory path error, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written outside the destination directory, got %v", err)
+	}
+}
+
+// sendDelete sends a deletion request for the given path, recursive for directories if requested, and returns the server's response.
+func sendDelete(t *testing.T, dir, fileName string, recursive bool) (uint8, string) {
+	t.Helper()
+
+	transferType := uint8(protocol.TransferTypeFile)
+	if recursive {
+		transferType = protocol.TransferTypeDirectory
+	}
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeDelete,
+		FileName:     fileName,
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: transferType,
+	}, nil)
+}
+
+// TestHandleDeleteRequest tests the handling of deletion requests to ensure that
+// files and, with a recursive request, directories are deleted, while missing files and escaping paths are reported.
+func TestHandleDeleteRequest(t *testing.T) {
+	withFlags(t, map[string]string{"allow-delete": "true"})
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "dest")
+	if err := os.MkdirAll(filepath.Join(dir, "old", "sub"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	for _, name := range []string{"a.txt", filepath.Join("old", "sub", "b.txt"), filepath.Join("..", "outside.txt")} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+
+	if status, message := sendDelete(t, dir, "a.txt", false); status != protocol.ResponseStatusSuccess || message != protocol.DeleteMessageDeleted {
+		t.Fatalf("expected the file to be deleted, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected a.txt to be deleted, got %v", err)
+	}
+	if status, message := sendDelete(t, dir, "a.txt", false); status != protocol.ResponseStatusError || message != protocol.VerifyMessageNotFound {
+		t.Fatalf("expected a not found response, got %d: %s", status, message)
+	}
+
+	if status, message := sendDelete(t, dir, "old", false); status != protocol.ResponseStatusError || !strings.Contains(message, "recursive") {
+		t.Fatalf("expected a directory to require a recursive deletion, got %d: %s", status, message)
+	}
+	if status, message := sendDelete(t, dir, "old", true); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the directory to be deleted, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "old")); !os.IsNotExist(err) {
+		t.Fatalf("expected the directory to be deleted, got %v", err)
+	}
+
+	for _, name := range []string{"../outside.txt", "."} {
+		if status, message := sendDelete(t, dir, name, true); status != protocol.ResponseStatusError {
+			t.Fatalf("expected the deletion of %s to be refused, got %d: %s", name, status, message)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "outside.txt")); err != nil {
+		t.Fatalf("expected the file outside the destination directory to be kept, got %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("expected the destination directory to be kept, got %v", err)
+	}
+}
+
+// TestHandleDeleteRequestDisabled tests the handling of deletion requests to ensure that
+// nothing is deleted without "-allow-delete".
+func TestHandleDeleteRequestDisabled(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("kept"), 0644); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	if status, message := sendDelete(t, dir, "a.txt", false); status != protocol.ResponseStatusError || !strings.Contains(message, "disabled") {
+		t.Fatalf("expected the deletion to be refused, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.txt")); err != nil {
+		t.Fatalf("expected the file to be kept, got %v", err)
+	}
+}
+
+// sendQuery sends a sync query for the given file name, size, and checksum, and returns the server's response.
+func sendQuery(t *testing.T, dir, fileName string, size uint64, checksum []byte) (uint8, string) {
+	t.Helper()
+
+	return sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeQuery,
+		FileSize:     size,
+		FileName:     fileName,
+		Checksum:     checksum,
+		TransferType: protocol.TransferTypeDirectory,
+	}, nil)
+}
+
+// TestHandleQueryRequest tests the query request handling to ensure that
+// a received file is reported as unchanged until it is modified, and missing files are reported as not found.
+func TestHandleQueryRequest(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("synced content")
+	checksum := protocol.CalculateDataChecksum(content)
+
+	if status, message := sendQuery(t, dir, "file.txt", uint64(len(content)), checksum); message != protocol.VerifyMessageNotFound {
+		t.Fatalf("expected %q before the upload, got status %d with %q", protocol.VerifyMessageNotFound, status, message)
+	}
+	if status, message := sendFile(t, dir, "file.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	if status, message := sendQuery(t, dir, "file.txt", uint64(len(content)), checksum); status != protocol.ResponseStatusExists ||
+		message != protocol.VerifyMessageMatch {
+		t.Fatalf("expected %q after the upload, got status %d with %q", protocol.VerifyMessageMatch, status, message)
+	}
+
+	// A file changed since it was received is hashed again instead of trusting the remembered checksum.
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("synced c0ntent"), 0644); err != nil {
+		t.Fatalf("failed to modify the file: %v", err)
+	}
+	if err := os.Chtimes(path, time.Now().Add(time.Hour), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to update the modification time: %v", err)
+	}
+	if status, message := sendQuery(t, dir, "file.txt", uint64(len(content)), checksum); message != protocol.VerifyMessageMismatch {
+		t.Fatalf("expected %q for the modified file, got status %d with %q", protocol.VerifyMessageMismatch, status, message)
+	}
+}
+
+// TestHandleQueryRequestSizeGuard tests the query request handling to ensure that
+// a file larger than `MaxQueryHashSize` is not hashed unless "-sync-deep" is set.
+func TestHandleQueryRequestSizeGuard(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(MaxQueryHashSize + 1)
+	// A sparse file is created instantly, and is only read if the guard fails.
+	if err := os.WriteFile(filepath.Join(dir, "large.bin"), nil, 0644); err != nil {
+		t.Fatalf("failed to create the file: %v", err)
+	}
+	if err := os.Truncate(filepath.Join(dir, "large.bin"), size); err != nil {
+		t.Fatalf("failed to resize the file: %v", err)
+	}
+
+	checksum := make([]byte, protocol.ChecksumSize)
+	if status, message := sendQuery(t, dir, "large.bin", uint64(size), checksum); status != protocol.ResponseStatusError ||
+		message != protocol.QueryMessageTooLarge {
+		t.Fatalf("expected %q, got status %d with %q", protocol.QueryMessageTooLarge, status, message)
+	}
+
+	originalSyncDeep := *syncDeep
+	*syncDeep = true
+	defer func() { *syncDeep = originalSyncDeep }()
+	if status, message := sendQuery(t, dir, "large.bin", uint64(size), checksum); message != protocol.VerifyMessageMismatch {
+		t.Fatalf("expected the file to be hashed with -sync-deep, got status %d with %q", status, message)
+	}
+}
+
+// TestStoredChecksumCache tests `rememberStoredChecksum` with "-checksum-cache-size" to ensure that
+// the least recently used checksum is forgotten beyond the size, and that a size of 0 remembers none.
+func TestStoredChecksumCache(t *testing.T) {
+	withFlags(t, map[string]string{"checksum-cache-size": "2"})
+	dir := t.TempDir()
+	infos := map[string]os.FileInfo{}
+	for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatalf("failed to create the file: %v", err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat the file: %v", err)
+		}
+		infos[path] = info
+	}
+	remember := func(name string) string {
+		path := filepath.Join(dir, name)
+		rememberStoredChecksum(storedChecksum{path: path, checksum: []byte(name), size: infos[path].Size(), modTime: infos[path].ModTime()})
+		return path
+	}
+	remembered := func(path string) bool {
+		_, ok := lookupStoredChecksum(path, infos[path])
+		return ok
+	}
+
+	a, b := remember("a.txt"), remember("b.txt")
+	if !remembered(a) {
+		t.Fatal("expected the checksum of a.txt to be remembered")
+	}
+	// a.txt was used after b.txt, so b.txt is the one forgotten for c.txt.
+	c := remember("c.txt")
+	if !remembered(a) || remembered(b) || !remembered(c) {
+		t.Fatalf("expected the checksum of b.txt to be forgotten, got a.txt %v, b.txt %v, c.txt %v", remembered(a), remembered(b), remembered(c))
+	}
+
+	withFlags(t, map[string]string{"checksum-cache-size": "0"})
+	if remember("a.txt"); remembered(a) || remembered(c) {
+		t.Fatal("expected no checksum to be remembered with a size of 0")
+	}
+}
+
+// TestListenAddress tests `listenAddress` to ensure that
+// the bind address and the port are joined with IPv6 addresses in brackets.
+func TestListenAddress(t *testing.T) {
+	originalBindAddr, originalListenPort := *bindAddr, *listenPort
+	defer func() { *bindAddr, *listenPort = originalBindAddr, originalListenPort }()
+
+	*listenPort = "8080"
+	for bind, expected := range map[string]string{
+		"":          ":8080",
+		"127.0.0.1": "127.0.0.1:8080",
+		"localhost": "localhost:8080",
+		"::1":       "[::1]:8080",
+		"[::1]":     "[::1]:8080",
+	} {
+		*bindAddr = bind
+		if got := listenAddress(); got != expected {
+			t.Errorf("listenAddress() with -bind %q = %q, expected %q", bind, got, expected)
+		}
+	}
+}
+
+// TestListenAddressIPv6 tests that the server's listen address for an IPv6 bind address
+// can be listened on and dialed.
+func TestListenAddressIPv6(t *testing.T) {
+	originalBindAddr, originalListenPort := *bindAddr, *listenPort
+	defer func() { *bindAddr, *listenPort = originalBindAddr, originalListenPort }()
+
+	*bindAddr, *listenPort = "::1", "0"
+	listener, err := net.Listen("tcp", listenAddress())
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial %s: %v", listener.Addr(), err)
+	}
+	_ = conn.Close()
+}
+
+// TestOnCompleteHookRunsAfterTransfer tests the "-on-complete" hook to ensure that
+// the command runs with its placeholders replaced after a file is received.
+func TestOnCompleteHookRunsAfterTransfer(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "marker")
+
+	completeHook = newHookRunner("printf '%s %s %s' {name} {size} {checksum} > "+shellQuote(marker), 1, 1)
+	defer func() {
+		completeHook.close()
+		completeHook = nil
+	}()
+
+	content := []byte("hooked content")
+	if status, message := sendFile(t, dir, "hooked.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	// The command runs asynchronously, so wait for the marker to appear.
+	expected := fmt.Sprintf("hooked.txt %d %x", len(content), protocol.CalculateDataChecksum(content))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := os.ReadFile(marker)
+		if err == nil && string(got) == expected {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the marker to contain %q, got %q (%v)", expected, got, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestOnCompleteHookFailureDoesNotFailTransfer tests the "-on-complete" hook to ensure that
+// a failing command is only logged and the transfer still succeeds.
+func TestOnCompleteHookFailureDoesNotFailTransfer(t *testing.T) {
+	dir := t.TempDir()
+
+	completeHook = newHookRunner("exit 3", 1, 1)
+	defer func() {
+		completeHook = nil
+	}()
+
+	if status, message := sendFile(t, dir, "kept.txt", []byte("kept")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	completeHook.close()
+
+	if _, err := os.Stat(filepath.Join(dir, "kept.txt")); err != nil {
+		t.Fatalf("expected the file to be kept after the command failed: %v", err)
+	}
+}
+
+// TestExpandHookCommand tests `expandHookCommand` to ensure that
+// the placeholders are replaced and file names are quoted against shell injection.
+func TestExpandHookCommand(t *testing.T) {
+	file := completedFile{
+		path:     "dest/it's; rm -rf ~",
+		name:     "$(whoami).txt",
+		checksum: []byte{0xab, 0xcd},
+		size:     42,
+	}
+	got := expandHookCommand("process {path} {name} {checksum} {size} {unknown}", file)
+	expected := `process 'dest/it'\''s; rm -rf ~' '$(whoami).txt' abcd 42 {unknown}`
+	if got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+
+	output, err := exec.Command("sh", "-c", "printf '%s' "+shellQuote(file.path)).Output()
+	if err != nil {
+		t.Skipf("no shell available: %v", err)
+	}
+	if string(output) != file.path {
+		t.Fatalf("expected the shell to see %q, got %q", file.path, output)
+	}
+}
+
+// compressContent compresses the content as the body of a compressed transfer.
+func compressContent(t *testing.T, content []byte, compression uint8) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer, err := protocol.NewCompressedWriter(&buf, compression)
+	if err != nil {
+		t.Fatalf("failed to create the compressed writer: %v", err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("failed to compress the content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close the compressed writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestHandleConnectionZstd tests `handleConnection` to ensure that
+// a zstd-compressed transfer is stored decompressed, matching the original content.
+func TestHandleConnectionZstd(t *testing.T) {
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("zstd compressed content\n"), 50000)
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "compressed.txt",
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+		Compression:  protocol.CompressionZstd,
+	}, compressContent(t, content, protocol.CompressionZstd))
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "compressed.txt"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected the original %d bytes, got %d bytes and %v", len(content), len(got), err)
+	}
+}
+
+// TestHandleConnectionCompressedLargerThanDeclared tests `handleConnection` to ensure that
+// compressed content that decompresses to more than the declared size is rejected and not stored.
+func TestHandleConnectionCompressedLargerThanDeclared(t *testing.T) {
+	dir := t.TempDir()
+	content := make([]byte, 1024*1024)
+	declared := content[:1024]
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(declared)),
+		FileName:     "bomb.bin",
+		Checksum:     protocol.CalculateDataChecksum(declared),
+		TransferType: protocol.TransferTypeFile,
+		Compression:  protocol.CompressionGzip,
+	}, compressContent(t, content, protocol.CompressionGzip))
+	if status != protocol.ResponseStatusError || message != "File size mismatch" {
+		t.Fatalf("expected a size mismatch error, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bomb.bin")); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+}
+
+// TestHandleConnectionTrailingData tests `handleConnection` to ensure that
+// a client sending more content than its header declares fails the transfer instead of desynchronizing the session.
+func TestHandleConnectionTrailingData(t *testing.T) {
+	dir := t.TempDir()
+	declared := []byte("declared")
+
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(declared)),
+		FileName:     "oversent.txt",
+		Checksum:     protocol.CalculateDataChecksum(declared),
+		TransferType: protocol.TransferTypeFile,
+	}, append(declared, []byte("trailing bytes read as the next header")...))
+	if status != protocol.ResponseStatusError || !strings.Contains(message, protocol.ErrTrailingData.Error()) {
+		t.Fatalf("expected a trailing data error, got %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "oversent.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed, got %v", err)
+	}
+}
+
+// TestHandleConnectionIncompleteContent tests `handleConnection` to ensure that
+// a client closing its side of a TCP connection before sending the declared content gets a clear error.
+func TestHandleConnectionIncompleteContent(t *testing.T) {
+	withFlags(t, map[string]string{"dir": t.TempDir()})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			wg.Done()
+			return
+		}
+		handleConnection(context.Background(), conn, &wg)
+	}()
+	// Wait for the connection to be handled once the client end is closed.
+	defer wg.Wait()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	content := []byte("the declared content")
+	if err := protocol.WriteHeader(conn, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "undersent.txt",
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+	}); err != nil {
+		t.Fatalf("failed to send the header: %v", err)
+	}
+	if _, err := conn.Write(content[:5]); err != nil {
+		t.Fatalf("failed to send the content: %v", err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("failed to close the write side: %v", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set the read deadline: %v", err)
+	}
+	status, message, err := protocol.ReadResponse(conn)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	if status != protocol.ResponseStatusError || !strings.Contains(message, protocol.ErrIncompleteContent.Error()) {
+		t.Fatalf("expected an incomplete content error, got %d: %s", status, message)
+	}
+}
+
+// TestHandleConnectionUnknownCompression tests `handleConnection` to ensure that
+// a header with an unknown compression value is rejected.
+func TestHandleConnectionUnknownCompression(t *testing.T) {
+	var buf bytes.Buffer
+	if err := protocol.WriteHeader(&buf, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     4,
+		FileName:     "unknown.bin",
+		Checksum:     protocol.CalculateDataChecksum([]byte("data")),
+		TransferType: protocol.TransferTypeFile,
+	}); err != nil {
+		t.Fatalf("failed to encode the header: %v", err)
+	}
+	data := buf.Bytes()
+	// The compression precedes the (empty) extended attributes, which end the header. Its two high bits flag trailing fields.
+	data[len(data)-5] = 0x3F
+
+	dir := t.TempDir()
+	status, message := sendRaw(t, dir, append(data, "data"...))
+	if status != protocol.ResponseStatusError || !strings.Contains(message, protocol.ErrInvalidCompression.Error()) {
+		t.Fatalf("expected an invalid compression error, got %d: %s", status, message)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("expected nothing to be stored, got %d entries", len(entries))
+	}
+}
+
+// TestServerRateLimit tests "-server-rate-limit" to ensure that
+// two concurrent transfers together stay under the aggregate rate, and that neither is starved by the other.
+func TestServerRateLimit(t *testing.T) {
+	const rate = 200000
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir})
+	readLimiter = protocol.NewRateLimiter(rate)
+	defer func() { readLimiter = nil }()
+
+	content := bytes.Repeat([]byte("x"), 150000)
+	start := time.Now()
+	finished := make([]time.Duration, 2)
+	var clients sync.WaitGroup
+	for i := range finished {
+		var request bytes.Buffer
+		if err := protocol.WriteHeader(&request, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(content)),
+			FileName:     fmt.Sprintf("file%d.bin", i),
+			Checksum:     protocol.CalculateDataChecksum(content),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			t.Fatalf("failed to encode the header: %v", err)
+		}
+		request.Write(content)
+		data := request.Bytes()
+
+		clients.Add(1)
+		go func() {
+			defer clients.Done()
+
+			serverConn, clientConn := net.Pipe()
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go handleConnection(context.Background(), serverConn, &wg)
+			go func() {
+				_, _ = clientConn.Write(data)
+			}()
+
+			status, message, err := protocol.ReadResponse(clientConn)
+			finished[i] = time.Since(start)
+			if err != nil || status != protocol.ResponseStatusSuccess {
+				t.Errorf("expected a success response for file%d.bin, got %d: %s (%v)", i, status, message, err)
+			}
+			_ = clientConn.Close()
+			wg.Wait()
+		}()
+	}
+	clients.Wait()
+
+	// 300000 bytes at 200000 bytes per second, less the burst of one chunk: at least 1.4 seconds.
+	elapsed := time.Since(start)
+	if elapsed < 1400*time.Millisecond {
+		t.Fatalf("expected the transfers to take at least 1.4s, took %v", elapsed)
+	}
+	// Both transfers share the rate all along, so neither finishes long before the other.
+	for i, duration := range finished {
+		if duration < elapsed*3/4 {
+			t.Fatalf("expected file%d.bin to share the rate with the other transfer, finished after %v of %v", i, duration, elapsed)
+		}
+	}
+}
+
+// TestSkipStrategyKeepsSession tests `handleConnection` to ensure that
+// a file skipped by the "skip" strategy is answered with the skipped status and its content is discarded,
+// so that the next file of the session is received.
+func TestSkipStrategyKeepsSession(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "strategy": StrategySkip})
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("kept"), 0644); err != nil {
+		t.Fatalf("failed to create the existing file: %v", err)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go handleConnection(context.Background(), serverConn, &wg)
+
+	// Like the client, each file is sent once the previous one is answered.
+	statuses := map[uint8]uint16{}
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{{"a.txt", []byte("skipped")}, {"b.txt", []byte("stored")}} {
+		var buf bytes.Buffer
+		if err := protocol.WriteHeader(&buf, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(file.content)),
+			FileName:     file.name,
+			Checksum:     protocol.CalculateDataChecksum(file.content),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			t.Fatalf("failed to encode the header: %v", err)
+		}
+		buf.Write(file.content)
+		go func() {
+			_, _ = clientConn.Write(buf.Bytes())
+		}()
+
+		status, code, message, err := protocol.ReadCodedResponse(clientConn)
+		if err != nil {
+			t.Fatalf("failed to read the response: %v", err)
+		}
+		if status == protocol.ResponseStatusError {
+			t.Fatalf("expected no error response, got %q", message)
+		}
+		statuses[status] = code
+	}
+	if err := clientConn.Close(); err != nil {
+		t.Fatalf("failed to close the client connection: %v", err)
+	}
+	wg.Wait()
+
+	if code, ok := statuses[protocol.ResponseStatusSkipped]; !ok || code != protocol.ErrorCodeConflictSkip {
+		t.Fatalf("expected a skipped response with the conflict-skip code, got %v", statuses)
+	}
+	if _, ok := statuses[protocol.ResponseStatusSuccess]; !ok {
+		t.Fatalf("expected a success response, got %v", statuses)
+	}
+	if got := storedFiles(t, dir); !maps.Equal(got, map[string]string{"a.txt": "kept", "b.txt": "stored"}) {
+		t.Fatalf("expected a.txt to be kept and b.txt to be stored, got %v", got)
+	}
+}
+
+// TestNewerStrategySkipsFileTransfer tests `handleConnection` to ensure that
+// the "newer" strategy keeps an existing file for a plain transfer, whose header carries no modification time.
+func TestNewerStrategySkipsFileTransfer(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"strategy": StrategyNewer})
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("kept"), 0644); err != nil {
+		t.Fatalf("failed to create the existing file: %v", err)
+	}
+
+	status, message := sendFile(t, dir, "a.txt", []byte("received"))
+	if status != protocol.ResponseStatusSkipped || message != "File already exists and newer strategy is enabled" {
+		t.Fatalf("expected the file to be skipped, got %d: %q", status, message)
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "a.txt")); err != nil || string(content) != "kept" {
+		t.Fatalf("expected the existing file to be kept, got %q and %v", content, err)
+	}
+}
+
+// TestNewerStrategyFileTransferModTime tests `handleConnection` to ensure that
+// the "newer" strategy replaces an existing file with a plain transfer whose header carries a strictly newer modification time,
+// and keeps it for an older or equal one, directly and through the quarantine, and that the stored file gets the sent time.
+func TestNewerStrategyFileTransferModTime(t *testing.T) {
+	existingTime := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		modTime  time.Time
+		expected string
+	}{
+		{"newer wins", existingTime.Add(time.Second), "received"},
+		{"older skipped", existingTime.Add(-time.Second), "existing"},
+		{"equal skipped", existingTime, "existing"},
+	}
+
+	for _, quarantine := range []bool{false, true} {
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s (quarantine %v)", tt.name, quarantine), func(t *testing.T) {
+				dir := t.TempDir()
+				flags := map[string]string{"strategy": StrategyNewer}
+				if quarantine {
+					flags["quarantine-dir"] = t.TempDir()
+				}
+				withFlags(t, flags)
+				path := filepath.Join(dir, "a.txt")
+				if err := os.WriteFile(path, []byte("existing"), 0644); err != nil {
+					t.Fatalf("failed to create the existing file: %v", err)
+				}
+				if err := os.Chtimes(path, existingTime, existingTime); err != nil {
+					t.Fatalf("failed to set the modification time: %v", err)
+				}
+
+				content := []byte("received")
+				status, message := sendRequest(t, dir, &protocol.Header{
+					MessageType:  protocol.MessageTypeTransfer,
+					FileSize:     uint64(len(content)),
+					FileName:     "a.txt",
+					Checksum:     protocol.CalculateDataChecksum(content),
+					TransferType: protocol.TransferTypeFile,
+					ModTime:      tt.modTime,
+				}, content)
+				expectedStatus := uint8(protocol.ResponseStatusSuccess)
+				if tt.expected == "existing" {
+					expectedStatus = protocol.ResponseStatusSkipped
+				}
+				if status != expectedStatus {
+					t.Fatalf("expected status %d, got %d: %q", expectedStatus, status, message)
+				}
+
+				got, err := os.ReadFile(path)
+				if err != nil || string(got) != tt.expected {
+					t.Fatalf("expected %q, got %q and %v", tt.expected, got, err)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatalf("failed to stat the file: %v", err)
+				}
+				expectedTime := existingTime
+				if tt.expected == "received" {
+					expectedTime = tt.modTime
+				}
+				if !info.ModTime().Equal(expectedTime) {
+					t.Fatalf("expected the modification time %v, got %v", expectedTime, info.ModTime())
+				}
+			})
+		}
+	}
+}
+
+// TestHeaderErrorCode tests `headerErrorCode` to ensure that
+// the errors of `validateHeader` are answered with the error codes of their reasons.
+func TestHeaderErrorCode(t *testing.T) {
+	withFlags(t, map[string]string{"dir": t.TempDir()})
+
+	tests := []struct {
+		name     string
+		header   *protocol.Header
+		expected uint16
+	}{
+		{"file too large", &protocol.Header{MessageType: protocol.MessageTypeTransfer, FileSize: uint64(MaxFileSize) + 1, FileName: "big.bin"},
+			protocol.ErrorCodeFileTooLarge},
+		{"directory too large", &protocol.Header{MessageType: protocol.MessageTypeValidate, TransferType: protocol.TransferTypeDirectory,
+			FileSize: maxDirectorySize.Load() + 1}, protocol.ErrorCodeQuotaExceeded},
+		{"parent traversal", &protocol.Header{MessageType: protocol.MessageTypeTransfer, FileName: "../escape.txt"},
+			protocol.ErrorCodeTraversalRejected},
+		{"traversal in the directory path", &protocol.Header{MessageType: protocol.MessageTypeVerify, FileName: "a.txt", DirectoryPath: "../up"},
+			protocol.ErrorCodeTraversalRejected},
+		{"absolute path", &protocol.Header{MessageType: protocol.MessageTypeTransfer, FileName: "/etc/passwd"},
+			protocol.ErrorCodeTraversalRejected},
+		{"empty name", &protocol.Header{MessageType: protocol.MessageTypeTransfer}, protocol.ErrorCodeNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateHeader(tt.header, "client")
+			if err == nil {
+				t.Fatal("expected the header to be refused")
+			}
+			if code := headerErrorCode(err); code != tt.expected {
+				t.Fatalf("expected the code %d, got %d for %v", tt.expected, code, err)
+			}
+		})
+	}
+}
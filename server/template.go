@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Placeholders of the "-dest-template" flag, expanded for every transfer.
+const (
+	TemplateDate       = "{date}"        // Date the transfer started, in the server's time zone (2006-01-02).
+	TemplateYear       = "{year}"        // Year the transfer started (2006).
+	TemplateMonth      = "{month}"       // Month the transfer started (01 to 12).
+	TemplateDay        = "{day}"         // Day of the month the transfer started (01 to 31).
+	TemplateClientIP   = "{client_ip}"   // IP address of the client, with the colons of an IPv6 address replaced.
+	TemplateTransferID = "{transfer_id}" // Identifier of the transfer, shared by the ranges of a file sent over several connections.
+)
+
+// ErrInvalidTemplate is returned for a "-dest-template" that cannot name a subdirectory of the destination directory.
+var ErrInvalidTemplate = errors.New("invalid destination template")
+
+// templateClock returns the time that "-dest-template" expands the date placeholders from (replaced in tests).
+var templateClock = time.Now
+
+// templatePlaceholder matches anything in braces, to find the placeholders of a template.
+var templatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// validateDestTemplate checks that the template only uses the known placeholders, and that it names a relative path
+// without ".." components, which the expanded values, reduced to single path components, cannot introduce either.
+func validateDestTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+	if filepath.IsAbs(template) || strings.HasPrefix(template, "/") {
+		return fmt.Errorf("%w: %w: %s", ErrInvalidTemplate, ErrAbsolutePath, template)
+	}
+	known := []string{TemplateDate, TemplateYear, TemplateMonth, TemplateDay, TemplateClientIP, TemplateTransferID}
+	for _, placeholder := range templatePlaceholder.FindAllString(template, -1) {
+		if !slices.Contains(known, placeholder) {
+			return fmt.Errorf("%w: unknown placeholder %s", ErrInvalidTemplate, placeholder)
+		}
+	}
+	literal := templatePlaceholder.ReplaceAllString(template, "x")
+	if strings.ContainsAny(literal, "{}") {
+		return fmt.Errorf("%w: unbalanced braces in %s", ErrInvalidTemplate, template)
+	}
+	for _, component := range strings.Split(filepath.ToSlash(literal), "/") {
+		if component == ".." {
+			return fmt.Errorf("%w: %w: %s", ErrInvalidTemplate, ErrPathTraversal, template)
+		}
+	}
+	return nil
+}
+
+// expandDestTemplate expands the placeholders of the template for a transfer started at `now`.
+// The client IP and the transfer ID are reduced to single path components (see `tenantName`).
+func expandDestTemplate(template, clientIP, transferID string, now time.Time) (string, error) {
+	ip, err := tenantName(clientIP)
+	if err != nil {
+		return "", fmt.Errorf("%w: client IP %q", ErrInvalidTemplate, clientIP)
+	}
+	id, err := tenantName(transferID)
+	if err != nil {
+		return "", fmt.Errorf("%w: transfer ID %q", ErrInvalidTemplate, transferID)
+	}
+	return strings.NewReplacer(
+		TemplateDate, now.Format(time.DateOnly),
+		TemplateYear, now.Format("2006"),
+		TemplateMonth, now.Format("01"),
+		TemplateDay, now.Format("02"),
+		TemplateClientIP, ip,
+		TemplateTransferID, id,
+	).Replace(template), nil
+}
+
+// applyDestTemplate places the path of a transfer header under the subdirectory expanded from "-dest-template",
+// by prefixing it to the directory path like the tenant directory (see `addTenant`), so that the traversal checks of
+// `destinationPath` run on the fully expanded path. Other requests (verifications, sync queries, deletions) address
+// the paths under the destination directory as they are, since the expansion of their own request may differ.
+// The ranges of a file share the identifier of their range transfer as `{transfer_id}`, and the time its first range
+// arrived for the date placeholders (see `rangeStartTime`), so that they land in one file.
+func applyDestTemplate(header *protocol.Header, clientIP, transferID string) error {
+	if *destTemplate == "" || header.MessageType != protocol.MessageTypeTransfer {
+		return nil
+	}
+	now := templateClock()
+	if header.TransferType == protocol.TransferTypeRange && header.Range != nil {
+		transferID = header.Range.ID
+		now = rangeStartTime(header.Range.ID, now)
+	}
+	subdirectory, err := expandDestTemplate(*destTemplate, clientIP, transferID, now)
+	if err != nil {
+		return err
+	}
+	return addTenant(header, subdirectory)
+}
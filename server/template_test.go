@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestExpandDestTemplate tests `expandDestTemplate` to ensure that
+// every placeholder is expanded, and that the client IP and transfer ID are reduced to single path components.
+func TestExpandDestTemplate(t *testing.T) {
+	now := time.Date(2026, time.March, 7, 23, 59, 0, 0, time.Local)
+	tests := []struct {
+		template   string
+		clientIP   string
+		transferID string
+		expected   string
+	}{
+		{"{date}/{client_ip}", "192.0.2.1", "id", "2026-03-07/192.0.2.1"},
+		{"{year}/{month}/{day}", "192.0.2.1", "id", "2026/03/07"},
+		{"uploads/{client_ip}/{transfer_id}", "::1", "a/b", "uploads/__1/a_b"},
+		{"{transfer_id}-{transfer_id}", "192.0.2.1", "id", "id-id"},
+	}
+	for _, tt := range tests {
+		if got, err := expandDestTemplate(tt.template, tt.clientIP, tt.transferID, now); err != nil || got != tt.expected {
+			t.Errorf("expected %q for %q, got %q (%v)", tt.expected, tt.template, got, err)
+		}
+	}
+
+	if _, err := expandDestTemplate("{transfer_id}", "192.0.2.1", "..", now); !errors.Is(err, ErrInvalidTemplate) {
+		t.Errorf("expected a transfer ID of \"..\" to be refused, got %v", err)
+	}
+}
+
+// TestDestTemplateTransfer tests "-dest-template" to ensure that
+// a file is stored under the expanded subdirectory, which the response reports, and that paths cannot leave it.
+func TestDestTemplateTransfer(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "dest-template": "uploads/{client_ip}"})
+
+	// The address of a `net.Pipe` connection is "pipe".
+	content := []byte("content")
+	status, message, receipt := sendRequestReceipt(t, dir, fileHeader("file.txt", content), content)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got status %d: %s", status, message)
+	}
+	if receipt == nil || receipt.Path != "uploads/pipe/file.txt" {
+		t.Fatalf("expected the receipt to report uploads/pipe/file.txt, got %+v", receipt)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "uploads", "pipe", "file.txt")); err != nil || string(got) != string(content) {
+		t.Fatalf("expected the file under the expanded directory, got %q and %v", got, err)
+	}
+
+	header := &protocol.Header{
+		MessageType:   protocol.MessageTypeTransfer,
+		DirectoryPath: "../..",
+		FileName:      "escaped.txt",
+		FileSize:      1,
+		Checksum:      protocol.CalculateDataChecksum([]byte("x")),
+		TransferType:  protocol.TransferTypeFile,
+	}
+	if status, _ := sendRequest(t, dir, header, []byte("x")); status != protocol.ResponseStatusError {
+		t.Fatalf("expected ../../escaped.txt to be refused, got status %d", status)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file outside of the destination directory, got %v", err)
+	}
+}
+
+// TestDestTemplateRanges tests "-dest-template" with `{transfer_id}` to ensure that
+// the ranges of a file, sent over separate connections, are assembled in the directory of their range transfer.
+func TestDestTemplateRanges(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "dest-template": "{transfer_id}"})
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	id := protocol.NewTransferID()
+	ranges := protocol.SplitRanges(id, uint64(len(content)), 2, 0)
+	if status, message := sendRange(t, dir, "file.txt", content, ranges[0]); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the first range to be received, got status %d: %s", status, message)
+	}
+	status, message, receipt := sendRequestReceipt(t, dir, rangeHeader("file.txt", content, ranges[1]), content[ranges[1].Offset:])
+	if status != protocol.ResponseStatusSuccess || receipt == nil || receipt.Path != id+"/file.txt" || receipt.BytesWritten != uint64(len(content)) {
+		t.Fatalf("expected the file to be completed as %s/file.txt, got status %d with %q (%+v)", id, status, message, receipt)
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, id, "file.txt")); err != nil || string(got) != string(content) {
+		t.Fatalf("expected the assembled file %q, got %q and %v", content, got, err)
+	}
+}
+
+// TestDestTemplateRangesAcrossMidnight tests "-dest-template" with `{date}` to ensure that
+// the ranges of a file received on both sides of midnight, and a range retransmitted the next day,
+// all land in the directory of the day the first range arrived.
+func TestDestTemplateRangesAcrossMidnight(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "dest-template": "{date}"})
+	clock := time.Date(2026, time.March, 7, 23, 59, 59, 0, time.Local)
+	t.Cleanup(func() {
+		templateClock = time.Now
+	})
+	templateClock = func() time.Time { return clock }
+
+	content := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	id := protocol.NewTransferID()
+	ranges := protocol.SplitRanges(id, uint64(len(content)), 2, 0)
+	if status, message := sendRange(t, dir, "file.txt", content, ranges[0]); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected the first range to be received, got status %d: %s", status, message)
+	}
+
+	clock = clock.Add(2 * time.Second)
+	for i, byteRange := range []protocol.ByteRange{ranges[1], ranges[0]} {
+		body := content[byteRange.Offset : byteRange.Offset+byteRange.Length]
+		if status, message, receipt := sendRequestReceipt(t, dir, rangeHeader("file.txt", content, byteRange), body); status != protocol.ResponseStatusSuccess ||
+			receipt == nil || receipt.Path != "2026-03-07/file.txt" {
+			t.Fatalf("expected the range %d of the next day to complete 2026-03-07/file.txt, got status %d with %q (%+v)", i, status, message, receipt)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dir, "2026-03-07", "file.txt")); err != nil || string(got) != string(content) {
+		t.Fatalf("expected the assembled file %q, got %q and %v", content, got, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2026-03-08")); !os.IsNotExist(err) {
+		t.Fatalf("expected no directory for the next day, got %v", err)
+	}
+
+	// The start time of the transfer is forgotten with the transfer.
+	sweepRangeTransfers(time.Now().Add(time.Hour), time.Minute)
+	rangeTransfers.Lock()
+	_, ok := rangeTransfers.started[id]
+	rangeTransfers.Unlock()
+	if ok {
+		t.Fatal("expected the start time to be removed with the transfer")
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"filexfer/protocol"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// ErrInvalidTenant is returned for a client whose tenant name (see `connectionTenant`) cannot name a directory.
+var ErrInvalidTenant = errors.New("invalid tenant name")
+
+// connectionTenant returns the name of the subdirectory of the destination directory that the files of the client
+// are stored in for "-tenant-dirs": the common name of its verified TLS client certificate with "-require-client-cert",
+// or its IP address otherwise. The name is reduced to a single safe path component (see `tenantName`).
+func connectionTenant(conn net.Conn) (string, error) {
+	if tlsConn, ok := conn.(*tls.Conn); ok && *requireClientTLS {
+		// The handshake is complete, since `verifyClientCertificate` ran before.
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			return tenantName(certs[0].Subject.CommonName)
+		}
+	}
+
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return tenantName(addr)
+}
+
+// tenantName reduces the identity of a client to a directory name, replacing every character other than letters,
+// digits, dots, hyphens, and underscores (e.g. the slashes of a crafted common name, or the colons of an IPv6 address) with an underscore.
+// A name that is empty or could still reach another directory ("." or anything with "..") is refused with `ErrInvalidTenant`.
+func tenantName(identity string) (string, error) {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, identity)
+	if strings.Trim(name, ".") == "" || strings.Contains(name, "..") {
+		return "", fmt.Errorf("%w: %q", ErrInvalidTenant, identity)
+	}
+	return name, nil
+}
+
+// addTenant places the path of the header under the tenant directory, by prefixing the tenant to its directory path,
+// so that `destinationPath` sanitizes the combined path like any other. The directory path is prefixed as is,
+// without cleaning it, so that its ".." components are still refused rather than resolved within the tenant directory.
+// An absolute directory path, which would otherwise become relative to the tenant directory, is refused.
+func addTenant(header *protocol.Header, tenant string) error {
+	if tenant == "" {
+		return nil
+	}
+	switch header.MessageType {
+	case protocol.MessageTypeInfo, protocol.MessageTypePing, protocol.MessageTypeValidate:
+		return nil
+	}
+	if filepath.IsAbs(header.DirectoryPath) {
+		return fmt.Errorf("invalid directory path: %w: %s", ErrAbsolutePath, header.DirectoryPath)
+	}
+	if header.DirectoryPath == "" {
+		header.DirectoryPath = tenant
+	} else {
+		header.DirectoryPath = tenant + "/" + header.DirectoryPath
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"filexfer/protocol"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestTenantDirsByCertificate tests "-tenant-dirs" with "-require-client-cert" over a TLS listener to ensure that
+// identically named files of two clients are stored in the directories named after their certificates' common names.
+func TestTenantDirsByCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := generateTestCert(t)
+	aliceCert, aliceKey := generateTestCertFor(t, x509.ExtKeyUsageClientAuth, "alice")
+	bobCert, bobKey := generateTestCertFor(t, x509.ExtKeyUsageClientAuth, "bob")
+
+	// Trust both self-signed client certificates.
+	var caCerts []byte
+	for _, path := range []string{aliceCert, bobCert} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read the client certificate: %v", err)
+		}
+		caCerts = append(caCerts, data...)
+	}
+	caFile := filepath.Join(t.TempDir(), "clients.crt")
+	if err := os.WriteFile(caFile, caCerts, 0644); err != nil {
+		t.Fatalf("failed to write the client CA file: %v", err)
+	}
+
+	withFlags(t, map[string]string{"dir": dir, "tls-cert": certFile, "tls-key": keyFile,
+		"require-client-cert": "true", "client-ca": caFile, "tenant-dirs": "true"})
+	config, err := loadTLSConfig()
+	if err != nil {
+		t.Fatalf("failed to load the TLS configuration: %v", err)
+	}
+	addr := serveTLS(t, config)
+
+	for _, client := range []struct{ certFile, keyFile, content string }{
+		{aliceCert, aliceKey, "from alice"},
+		{bobCert, bobKey, "from bob"},
+	} {
+		conn, err := dialTLS(t, addr, client.certFile, client.keyFile)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := protocol.WriteHeader(&buf, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(client.content)),
+			FileName:     "report.txt",
+			Checksum:     protocol.CalculateDataChecksum([]byte(client.content)),
+			TransferType: protocol.TransferTypeFile,
+		}); err != nil {
+			t.Fatalf("failed to encode the header: %v", err)
+		}
+		buf.WriteString(client.content)
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			t.Fatalf("failed to send the file: %v", err)
+		}
+		status, message, err := protocol.ReadResponse(conn)
+		if err != nil || status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected a success response, got status %d: %s (%v)", status, message, err)
+		}
+		_ = conn.Close()
+	}
+
+	for tenant, expected := range map[string]string{"alice": "from alice", "bob": "from bob"} {
+		if got := storedFiles(t, filepath.Join(dir, tenant)); !maps.Equal(got, map[string]string{"report.txt": expected}) {
+			t.Fatalf("expected report.txt with %q under %s, got %v", expected, tenant, got)
+		}
+	}
+}
+
+// TestTenantDirsConfinement tests "-tenant-dirs" to ensure that
+// a file is stored under the directory of the client (named after its address), and that paths cannot leave it.
+func TestTenantDirsConfinement(t *testing.T) {
+	dir := t.TempDir()
+	withFlags(t, map[string]string{"dir": dir, "tenant-dirs": "true", "allow-delete": "true"})
+
+	// The address of a `net.Pipe` connection is "pipe".
+	if status, message := sendFile(t, dir, "file.txt", []byte("content")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got status %d: %s", status, message)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pipe", "file.txt")); err != nil {
+		t.Fatalf("expected the file in the tenant directory: %v", err)
+	}
+
+	for _, header := range []*protocol.Header{
+		{DirectoryPath: "..", FileName: "escaped.txt"},
+		{DirectoryPath: "/tmp", FileName: "escaped.txt"},
+		{FileName: "../escaped.txt"},
+	} {
+		header.MessageType = protocol.MessageTypeTransfer
+		header.FileSize = 1
+		header.Checksum = protocol.CalculateDataChecksum([]byte("x"))
+		header.TransferType = protocol.TransferTypeFile
+		if status, _ := sendRequest(t, dir, header, []byte("x")); status != protocol.ResponseStatusError {
+			t.Fatalf("expected %s/%s to be refused, got status %d", header.DirectoryPath, header.FileName, status)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file outside of the tenant directory, got %v", err)
+	}
+
+	// The tenant directory itself cannot be deleted.
+	status, _ := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeDelete,
+		FileName:     ".",
+		Checksum:     make([]byte, protocol.ChecksumSize),
+		TransferType: protocol.TransferTypeDirectory,
+	}, nil)
+	if status != protocol.ResponseStatusError {
+		t.Fatalf("expected the deletion of the tenant directory to be refused, got status %d", status)
+	}
+}
+
+// TestTenantName tests `tenantName` to ensure that
+// identities are reduced to a single path component, and crafted ones that could leave the destination directory are refused.
+func TestTenantName(t *testing.T) {
+	tests := []struct {
+		identity string
+		expected string
+	}{
+		{"alice", "alice"},
+		{"192.168.1.10", "192.168.1.10"},
+		{"::1", "__1"},
+		{"team/alice", "team_alice"},
+		{`C:\data`, "C__data"},
+	}
+	for _, tt := range tests {
+		if got, err := tenantName(tt.identity); err != nil || got != tt.expected {
+			t.Errorf("expected %q for %q, got %q (%v)", tt.expected, tt.identity, got, err)
+		}
+	}
+
+	for _, identity := range []string{"", ".", "..", "../../etc", "a/../b", "..."} {
+		if _, err := tenantName(identity); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("expected ErrInvalidTenant for %q, got %v", identity, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// A TestServer is a server receiving files on a loopback port in the background, without TLS,
+// for the integration tests of programs speaking the protocol (e.g. the client).
+// It is configured by `Flags` like the server command. The flags are shared by every server of the process,
+// so only one should run at a time.
+type TestServer struct {
+	Addr string // Address the server listens on, e.g. "127.0.0.1:41234".
+	Dir  string // Destination directory of the received files.
+
+	listener net.Listener
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup        // Connections being handled.
+	served   chan struct{}         // Closed once the server stops accepting connections.
+	mutex    sync.Mutex            // Guards `conns`.
+	conns    map[net.Conn]struct{} // Connections accepted and not closed by `Close` yet.
+}
+
+// StartTestServer starts a server storing the received files in `dir` on a port of 127.0.0.1 chosen by the system.
+// The other flags keep their current values, which must be valid (see `validateFlags`).
+func StartTestServer(dir string) (*TestServer, error) {
+	if err := Flags.Set("dir", dir); err != nil {
+		return nil, err
+	}
+	if err := validateFlags(); err != nil {
+		return nil, err
+	}
+
+	ts := &TestServer{
+		Addr:  "127.0.0.1:0",
+		Dir:   dir,
+		conns: make(map[net.Conn]struct{}),
+	}
+	if err := ts.start(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// start listens on the address of the server, and accepts the connections in the background.
+func (ts *TestServer) start() error {
+	listener, err := net.Listen("tcp", ts.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start listening: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ts.Addr = listener.Addr().String()
+	ts.listener = listener
+	ts.cancel = cancel
+	ts.served = make(chan struct{})
+	go ts.serve(ctx)
+	return nil
+}
+
+// serve accepts the connections until the listener is closed, handling each like the server command.
+func (ts *TestServer) serve(ctx context.Context) {
+	defer close(ts.served)
+	for {
+		conn, err := ts.listener.Accept()
+		if err != nil {
+			return
+		}
+		ts.mutex.Lock()
+		ts.conns[conn] = struct{}{}
+		ts.mutex.Unlock()
+
+		ts.wg.Add(1)
+		go handleConnection(ctx, conn, &ts.wg)
+	}
+}
+
+// Close stops the server: it stops accepting connections, closes the ones still open (interrupting their transfers),
+// and waits for them to be handled.
+func (ts *TestServer) Close() error {
+	err := ts.listener.Close()
+	<-ts.served
+	ts.cancel()
+
+	ts.mutex.Lock()
+	for conn := range ts.conns {
+		_ = conn.Close()
+	}
+	clear(ts.conns)
+	ts.mutex.Unlock()
+
+	ts.wg.Wait()
+	return err
+}
+
+// Restart stops the server like `Close`, interrupting the transfers in progress, and starts it again on the same address,
+// like a server restarting under its clients.
+func (ts *TestServer) Restart() error {
+	if err := ts.Close(); err != nil {
+		return err
+	}
+	return ts.start()
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"filexfer/protocol"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTestServer starts a `TestServer` storing the files in a temporary directory and closes it at the end of the test.
+func startTestServer(t *testing.T) *TestServer {
+	t.Helper()
+
+	withFlags(t, map[string]string{"progress": protocol.ProgressModeNone})
+	ts, err := StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the test server: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := ts.Close(); err != nil {
+			t.Errorf("failed to close the test server: %v", err)
+		}
+	})
+	return ts
+}
+
+// TestTestServerUpload tests `TestServer` to ensure that
+// files uploaded on a connection to it are stored in its destination directory.
+func TestTestServerUpload(t *testing.T) {
+	ts := startTestServer(t)
+
+	conn, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect to the test server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	files := map[string]string{"a.txt": "alpha", "sub/b.txt": "bravo"}
+	for name, content := range files {
+		if err := protocol.WriteHeader(conn, &protocol.Header{
+			MessageType:  protocol.MessageTypeTransfer,
+			FileSize:     uint64(len(content)),
+			FileName:     name,
+			Checksum:     protocol.CalculateDataChecksum([]byte(content)),
+			TransferType: protocol.TransferTypeDirectory,
+		}); err != nil {
+			t.Fatalf("failed to send the header: %v", err)
+		}
+		if _, err := conn.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to send the content: %v", err)
+		}
+		status, message, err := protocol.ReadResponse(conn)
+		if err != nil || status != protocol.ResponseStatusSuccess {
+			t.Fatalf("expected %s to be received, got status %d, message %q, and %v", name, status, message, err)
+		}
+	}
+
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(ts.Dir, filepath.FromSlash(name)))
+		if err != nil || string(got) != content {
+			t.Fatalf("expected %s with %q, got %q and %v", name, content, got, err)
+		}
+	}
+}
+
+// TestTestServerCloseIdleConnection tests `Close` to ensure that
+// it closes a connection still open instead of waiting for the client to hang up.
+func TestTestServerCloseIdleConnection(t *testing.T) {
+	withFlags(t, map[string]string{"progress": protocol.ProgressModeNone})
+	ts, err := StartTestServer(t.TempDir())
+	if err != nil {
+		t.Fatalf("failed to start the test server: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect to the test server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- ts.Close()
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return with an idle connection open")
+	}
+	if _, err := net.Dial("tcp", ts.Addr); err == nil {
+		t.Fatal("expected the closed server to refuse connections")
+	}
+}
+
+// TestTestServerRestart tests `Restart` to ensure that
+// it closes the connections open before, and accepts new ones on the same address.
+func TestTestServerRestart(t *testing.T) {
+	ts := startTestServer(t)
+	addr := ts.Addr
+
+	conn, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatalf("failed to connect to the test server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := ts.Restart(); err != nil {
+		t.Fatalf("failed to restart the test server: %v", err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set the read deadline: %v", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection open before the restart to be closed")
+	}
+
+	if ts.Addr != addr {
+		t.Fatalf("expected the restarted server on %s, got %s", addr, ts.Addr)
+	}
+	restarted, err := net.Dial("tcp", ts.Addr)
+	if err != nil {
+		t.Fatalf("expected the restarted server to accept connections: %v", err)
+	}
+	_ = restarted.Close()
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookSignatureHeader is the HTTP header carrying the HMAC-SHA256 signature of a webhook body ("-webhook-secret"),
+// as "sha256=" followed by the hex-encoded signature.
+const WebhookSignatureHeader = "X-Filexfer-Signature"
+
+// WebhookSecretEnv is the environment variable holding the key of the webhook signature
+// when neither "-webhook-secret" nor "-webhook-secret-file" is given.
+const WebhookSecretEnv = "FILEXFER_WEBHOOK_SECRET"
+
+// loadWebhookSecret returns the key of the webhook signature, from the first source set of:
+// the file of "-webhook-secret-file" (without its trailing newlines), the "-webhook-secret" flag
+// (the two cannot be combined), and the `WebhookSecretEnv` environment variable. It returns "" if none is set.
+// The file and the environment variable keep the key out of the process list, unlike the flag.
+func loadWebhookSecret() (string, error) {
+	if *webhookKeyFile != "" {
+		data, err := os.ReadFile(*webhookKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the webhook secret file: %v", err)
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("the webhook secret file %s is empty", *webhookKeyFile)
+		}
+		return secret, nil
+	}
+	if *webhookSecret != "" {
+		return *webhookSecret, nil
+	}
+	return os.Getenv(WebhookSecretEnv), nil
+}
+
+// A webhookEvent is the JSON body posted to the "-webhook-url" endpoint for every received and verified file.
+type webhookEvent struct {
+	TransferID string `json:"transfer_id"` // Identifier of the transfer, as in the logs.
+	FileName   string `json:"file_name"`   // Name of the file as sent by the client.
+	Path       string `json:"path"`        // Path of the stored file.
+	Size       uint64 `json:"size"`        // Size of the file in bytes.
+	Checksum   string `json:"checksum"`    // Hex-encoded SHA-256 checksum of the file.
+	ClientIP   string `json:"client_ip"`   // IP address of the client.
+	DurationMS int64  `json:"duration_ms"` // Duration of the transfer in milliseconds.
+}
+
+// newWebhookEvent returns the event of a file received by the transfer of the record.
+func newWebhookEvent(record *accessRecord, file completedFile) webhookEvent {
+	return webhookEvent{
+		TransferID: record.entry.TransferID,
+		FileName:   file.name,
+		Path:       file.path,
+		Size:       file.size,
+		Checksum:   hex.EncodeToString(file.checksum),
+		ClientIP:   record.entry.ClientIP,
+		DurationMS: time.Since(record.startTime).Milliseconds(),
+	}
+}
+
+// A webhookNotifier posts the events of received files to the "-webhook-url" endpoint on a bounded pool of workers.
+// Events that find the queue full are dropped, so that an unresponsive endpoint holds up neither the transfers nor memory.
+type webhookNotifier struct {
+	url     string            // URL of the endpoint.
+	secret  []byte            // Key of the HMAC signature, or nil to send unsigned requests.
+	retries int               // Number of retries of a failed delivery.
+	backoff time.Duration     // Delay before the first retry, doubled for every following one.
+	client  *http.Client      // Client with the timeout of a single delivery attempt.
+	queue   chan webhookEvent // Events waiting for a worker.
+	wg      sync.WaitGroup    // Wait group of the workers.
+}
+
+// webhook posts the events of received files, or is nil if no webhook is configured.
+var webhook *webhookNotifier
+
+// newWebhookNotifier starts `workers` workers posting the events queued on the notifier to the URL.
+func newWebhookNotifier(url, secret string, timeout time.Duration, retries, workers, queueSize int) *webhookNotifier {
+	wn := &webhookNotifier{
+		url:     url,
+		retries: retries,
+		backoff: WebhookBackoff,
+		client:  &http.Client{Timeout: timeout},
+		queue:   make(chan webhookEvent, queueSize),
+	}
+	if secret != "" {
+		wn.secret = []byte(secret)
+	}
+	for range workers {
+		wn.wg.Add(1)
+		go func() {
+			defer wn.wg.Done()
+			for event := range wn.queue {
+				wn.deliver(event)
+			}
+		}()
+	}
+	return wn
+}
+
+// enqueue queues the event for delivery. If the queue is full, the event is dropped and logged.
+func (wn *webhookNotifier) enqueue(event webhookEvent) {
+	select {
+	case wn.queue <- event:
+	default:
+		slog.Error("The -webhook-url queue is full, dropping the notification", "transfer_id", event.TransferID, "file_name", event.Path)
+	}
+}
+
+// close stops accepting events and waits for the queued deliveries to finish.
+func (wn *webhookNotifier) close() {
+	close(wn.queue)
+	wn.wg.Wait()
+}
+
+// deliver posts the event, retrying with an exponential backoff. A failure is logged and does not affect the transfer,
+// whose response was already sent.
+func (wn *webhookNotifier) deliver(event webhookEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode the webhook notification", "transfer_id", event.TransferID, "error", err)
+		return
+	}
+
+	backoff := wn.backoff
+	for attempt := 0; ; attempt++ {
+		err = wn.post(body)
+		if err == nil {
+			slog.Debug("Webhook notification delivered", "transfer_id", event.TransferID, "file_name", event.Path, "attempts", attempt+1)
+			return
+		}
+		if attempt >= wn.retries {
+			break
+		}
+		slog.Warn("Webhook notification failed, retrying", "transfer_id", event.TransferID, "error", err, "retry_in", backoff.String())
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	slog.Error("Failed to deliver the webhook notification", "transfer_id", event.TransferID, "file_name", event.Path,
+		"attempts", wn.retries+1, "error", err)
+}
+
+// post sends the body to the endpoint once, signed if a secret is configured, and expects a 2xx status.
+func (wn *webhookNotifier) post(body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, wn.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if wn.secret != nil {
+		request.Header.Set(WebhookSignatureHeader, "sha256="+signWebhook(wn.secret, body))
+	}
+
+	response, err := wn.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+	return nil
+}
+
+// signWebhook returns the hex-encoded HMAC-SHA256 signature of the body with the secret.
+func signWebhook(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"filexfer/protocol"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A webhookRecorder is an endpoint that records the notifications posted to it,
+// and fails the first `failures` of them with a server error.
+type webhookRecorder struct {
+	mu         sync.Mutex
+	failures   int
+	bodies     [][]byte
+	signatures []string
+}
+
+// ServeHTTP records the body and signature of a notification.
+func (wr *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.bodies = append(wr.bodies, body)
+	wr.signatures = append(wr.signatures, r.Header.Get(WebhookSignatureHeader))
+	if len(wr.bodies) <= wr.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// withWebhook posts the notifications of the test's transfers to the endpoint, and returns a function that
+// waits for the queued deliveries to finish.
+func withWebhook(t *testing.T, endpoint http.Handler, secret string, retries int) func() {
+	t.Helper()
+
+	server := httptest.NewServer(endpoint)
+	webhook = newWebhookNotifier(server.URL, secret, time.Second, retries, 1, 1)
+	webhook.backoff = time.Millisecond
+	closed := false
+	wait := func() {
+		if !closed {
+			closed = true
+			webhook.close()
+		}
+	}
+	t.Cleanup(func() {
+		wait()
+		webhook = nil
+		server.Close()
+	})
+	return wait
+}
+
+// TestWebhookNotification tests the "-webhook-url" notification to ensure that
+// a signed JSON body with the details of the received file is posted after the transfer.
+func TestWebhookNotification(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &webhookRecorder{}
+	wait := withWebhook(t, recorder, "secret", 0)
+
+	content := []byte("notified content")
+	if status, message := sendFile(t, dir, "a.txt", content); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	wait()
+
+	if len(recorder.bodies) != 1 {
+		t.Fatalf("expected 1 notification, got %d", len(recorder.bodies))
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(recorder.bodies[0], &event); err != nil {
+		t.Fatalf("expected a JSON body, got %q: %v", recorder.bodies[0], err)
+	}
+	if event.FileName != "a.txt" || event.Path != filepath.Join(dir, "a.txt") || event.Size != uint64(len(content)) ||
+		event.Checksum != hex.EncodeToString(protocol.CalculateDataChecksum(content)) || event.ClientIP == "" || event.TransferID == "" {
+		t.Fatalf("unexpected notification: %+v", event)
+	}
+	if expected := "sha256=" + signWebhook([]byte("secret"), recorder.bodies[0]); recorder.signatures[0] != expected {
+		t.Fatalf("expected the signature %q, got %q", expected, recorder.signatures[0])
+	}
+}
+
+// TestWebhookRetries tests the "-webhook-url" notification to ensure that
+// a failed delivery is retried, and that a notification that cannot be delivered does not fail the transfer.
+func TestWebhookRetries(t *testing.T) {
+	dir := t.TempDir()
+	recorder := &webhookRecorder{failures: 2}
+	wait := withWebhook(t, recorder, "", 2)
+
+	if status, message := sendFile(t, dir, "a.txt", []byte("retried")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response, got %d: %s", status, message)
+	}
+	wait()
+	if len(recorder.bodies) != 3 || recorder.signatures[0] != "" {
+		t.Fatalf("expected 3 unsigned attempts, got %d (%q)", len(recorder.bodies), recorder.signatures)
+	}
+
+	recorder = &webhookRecorder{failures: 10}
+	wait = withWebhook(t, recorder, "", 1)
+	if status, message := sendFile(t, dir, "b.txt", []byte("undelivered")); status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected a success response despite the failing webhook, got %d: %s", status, message)
+	}
+	wait()
+	if len(recorder.bodies) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(recorder.bodies))
+	}
+}
+
+// TestWebhookQueueFull tests `webhookNotifier.enqueue` to ensure that
+// notifications are dropped instead of waiting once the queue is full.
+func TestWebhookQueueFull(t *testing.T) {
+	notifier := newWebhookNotifier("http://127.0.0.1:1", "", time.Second, 0, 0, 1)
+	notifier.enqueue(webhookEvent{FileName: "a.txt"})
+	notifier.enqueue(webhookEvent{FileName: "b.txt"})
+	if len(notifier.queue) != 1 {
+		t.Fatalf("expected 1 queued notification, got %d", len(notifier.queue))
+	}
+}
+
+// TestLoadWebhookSecret tests `loadWebhookSecret` to ensure that
+// the secret is loaded from each source, with the file and the flag taking precedence over the environment variable.
+func TestLoadWebhookSecret(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n\r\n"), 0600); err != nil {
+		t.Fatalf("failed to write the secret file: %v", err)
+	}
+	emptyFile := filepath.Join(t.TempDir(), "empty")
+	if err := os.WriteFile(emptyFile, []byte("\n"), 0600); err != nil {
+		t.Fatalf("failed to write the secret file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		flags    map[string]string
+		env      string
+		expected string
+		wantErr  bool
+	}{
+		{"no secret", nil, "", "", false},
+		{"environment variable", nil, "from-env", "from-env", false},
+		{"flag", map[string]string{"webhook-secret": "from-flag"}, "", "from-flag", false},
+		{"flag over environment variable", map[string]string{"webhook-secret": "from-flag"}, "from-env", "from-flag", false},
+		{"file without trailing newlines", map[string]string{"webhook-secret-file": secretFile}, "", "from-file", false},
+		{"file over environment variable", map[string]string{"webhook-secret-file": secretFile}, "from-env", "from-file", false},
+		{"empty file", map[string]string{"webhook-secret-file": emptyFile}, "from-env", "", true},
+		{"missing file", map[string]string{"webhook-secret-file": secretFile + ".missing"}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withFlags(t, tt.flags)
+			t.Setenv(WebhookSecretEnv, tt.env)
+
+			secret, err := loadWebhookSecret()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got the secret %q", secret)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secret != tt.expected {
+				t.Fatalf("expected the secret %q, got %q", tt.expected, secret)
+			}
+		})
+	}
+}
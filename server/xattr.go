@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"filexfer/protocol"
+	"log/slog"
+	"runtime"
+	"strings"
+)
+
+// xattrRestorable reports whether the extended attribute is restored on received files.
+// On Linux, only the "user." namespace is restored: the others are either privileged ("trusted.", "security.")
+// or managed by the system ("system."), and should follow the policies of the server rather than those of the client.
+func xattrRestorable(name string) bool {
+	return runtime.GOOS != "linux" || strings.HasPrefix(name, "user.")
+}
+
+// restoreXattrs sets the extended attributes sent with the file (with the client's "-xattrs") on the received file.
+// Failures are logged and do not fail the transfer, whose content has been verified already, and a filesystem or
+// platform without extended attributes leaves the file without them.
+func restoreXattrs(logger *slog.Logger, path string, xattrs []protocol.Xattr) {
+	restored := 0
+	for _, xattr := range xattrs {
+		if !xattrRestorable(xattr.Name) {
+			logger.Debug("Skipping the extended attribute outside the user namespace", "path", path, "xattr", xattr.Name)
+			continue
+		}
+		if err := setXattr(path, xattr.Name, xattr.Value); err != nil {
+			if errors.Is(err, errors.ErrUnsupported) {
+				logger.Debug("Extended attributes are not supported by the destination, skipping them", "path", path)
+				return
+			}
+			logger.Warn("Failed to restore the extended attribute", "path", path, "xattr", xattr.Name, "error", err)
+			continue
+		}
+		restored++
+	}
+	logger.Debug("Restored the extended attributes", "path", path, "count", restored)
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package server
+
+import "errors"
+
+// setXattr fails with `errors.ErrUnsupported`, since extended attributes are only supported on Linux and macOS.
+func setXattr(path, name string, value []byte) error {
+	return errors.ErrUnsupported
+}
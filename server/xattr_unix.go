@@ -0,0 +1,10 @@
+//go:build linux || darwin
+
+package server
+
+import "golang.org/x/sys/unix"
+
+// setXattr sets the extended attribute of the file at `path`, replacing its existing value.
+func setXattr(path, name string, value []byte) error {
+	return unix.Setxattr(path, name, value, 0)
+}
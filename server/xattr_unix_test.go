@@ -0,0 +1,60 @@
+//go:build linux || darwin
+
+package server
+
+import (
+	"bytes"
+	"errors"
+	"filexfer/protocol"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestTransferRestoresXattrs tests the `handleConnection` function to ensure that the extended attributes
+// sent with a file are restored on the received file (on Linux, only those of the "user." namespace).
+func TestTransferRestoresXattrs(t *testing.T) {
+	dir := t.TempDir()
+	// Check that the filesystem supports extended attributes.
+	probe := filepath.Join(dir, "probe")
+	if err := os.WriteFile(probe, nil, 0644); err != nil {
+		t.Fatalf("failed to create the probe file: %v", err)
+	}
+	if err := unix.Setxattr(probe, "user.filexfer.probe", []byte("1"), 0); errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("extended attributes are not supported by the filesystem of %s", dir)
+	}
+
+	content := []byte("content with attributes")
+	status, message := sendRequest(t, dir, &protocol.Header{
+		MessageType:  protocol.MessageTypeTransfer,
+		FileSize:     uint64(len(content)),
+		FileName:     "file.txt",
+		Checksum:     protocol.CalculateDataChecksum(content),
+		TransferType: protocol.TransferTypeFile,
+		Xattrs: []protocol.Xattr{
+			{Name: "user.filexfer.comment", Value: []byte("a comment")},
+			{Name: "trusted.filexfer", Value: []byte("privileged")},
+		},
+	}, content)
+	if status != protocol.ResponseStatusSuccess {
+		t.Fatalf("expected success, got status %d (%s)", status, message)
+	}
+
+	path := filepath.Join(dir, "file.txt")
+	value := make([]byte, 64)
+	n, err := unix.Getxattr(path, "user.filexfer.comment", value)
+	if err != nil {
+		t.Fatalf("expected the extended attribute to be restored, got %v", err)
+	}
+	if !bytes.Equal(value[:n], []byte("a comment")) {
+		t.Fatalf("expected the value %q, got %q", "a comment", value[:n])
+	}
+	if runtime.GOOS == "linux" {
+		if _, err := unix.Getxattr(path, "trusted.filexfer", value); !errors.Is(err, unix.ENODATA) {
+			t.Fatalf("expected the attribute outside the user namespace not to be restored, got %v", err)
+		}
+	}
+}